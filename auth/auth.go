@@ -0,0 +1,173 @@
+// Package auth identifies the users of API requests by static bearer tokens, HTTP basic
+// auth, or a header set by an authenticating reverse proxy (e.g. oauth2-proxy in front of an
+// OIDC provider), and keeps browsers signed in with session cookies.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	MethodToken   = "token"   // Authorization: Bearer with a static token
+	MethodBasic   = "basic"   // HTTP basic auth with a static user and password
+	MethodProxy   = "proxy"   // User header set by an authenticating reverse proxy
+	MethodSession = "session" // Session cookie issued after one of the others
+
+	defaultTokenName = "token" // User name of tokens configured without one
+)
+
+var (
+	errEmptyCredential = errors.New("empty credential")
+	errDuplicateUser   = errors.New("duplicate user")
+	errBasicEntry      = errors.New("basic auth entries must be user:password")
+)
+
+// User is the authenticated user of a request.
+type User struct {
+	Name   string `json:"name"`
+	Method string `json:"method"` // How the request was authenticated
+}
+
+// Authenticator identifies the user of a request from the credentials it carries. ok is
+// false when the request carries none this authenticator accepts.
+type Authenticator interface {
+	Method() string
+	Authenticate(r *http.Request) (User, bool)
+}
+
+// userKey is the context key of the authenticated user.
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying user.
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the user stored in ctx by WithUser. ok is false for unauthenticated requests.
+func UserFrom(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userKey{}).(User)
+
+	return user, ok
+}
+
+// Tokens accepts static bearer tokens. Only their digests are kept, so looking one up
+// doesn't leak the secret through timing.
+type Tokens struct {
+	names map[[sha256.Size]byte]string
+}
+
+// NewTokens accepts the tokens of entries, each "name:token" or a bare token, whose user is
+// then named "token".
+func NewTokens(entries []string) (*Tokens, error) {
+	tokens := &Tokens{names: make(map[[sha256.Size]byte]string, len(entries))}
+	for _, entry := range entries {
+		name, token, named := strings.Cut(entry, ":")
+		if !named {
+			name, token = defaultTokenName, entry
+		}
+		if token == "" || name == "" {
+			return nil, fmt.Errorf("token: %w", errEmptyCredential)
+		}
+		tokens.names[sha256.Sum256([]byte(token))] = name
+	}
+
+	return tokens, nil
+}
+
+// Method returns MethodToken.
+func (t *Tokens) Method() string {
+	return MethodToken
+}
+
+// Authenticate accepts an Authorization: Bearer header with a known token.
+func (t *Tokens) Authenticate(r *http.Request) (User, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return User{}, false
+	}
+	name, exists := t.names[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !exists {
+		return User{}, false
+	}
+
+	return User{Name: name, Method: MethodToken}, true
+}
+
+// Basic accepts HTTP basic auth with static users and passwords.
+type Basic struct {
+	passwords map[string][sha256.Size]byte // Password digests by user
+}
+
+// NewBasic accepts the users of entries, each "user:password".
+func NewBasic(entries []string) (*Basic, error) {
+	basic := &Basic{passwords: make(map[string][sha256.Size]byte, len(entries))}
+	for _, entry := range entries {
+		user, password, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, errBasicEntry
+		}
+		if user == "" || password == "" {
+			return nil, fmt.Errorf("user %q: %w", user, errEmptyCredential)
+		}
+		if _, exists := basic.passwords[user]; exists {
+			return nil, fmt.Errorf("%w %s", errDuplicateUser, user)
+		}
+		basic.passwords[user] = sha256.Sum256([]byte(password))
+	}
+
+	return basic, nil
+}
+
+// Method returns MethodBasic.
+func (b *Basic) Method() string {
+	return MethodBasic
+}
+
+// Authenticate accepts basic auth credentials of a known user. Passwords are compared in
+// constant time.
+func (b *Basic) Authenticate(r *http.Request) (User, bool) {
+	name, password, found := r.BasicAuth()
+	if !found {
+		return User{}, false
+	}
+	expected, exists := b.passwords[name]
+	given := sha256.Sum256([]byte(password))
+	if !exists || subtle.ConstantTimeCompare(given[:], expected[:]) != 1 {
+		return User{}, false
+	}
+
+	return User{Name: name, Method: MethodBasic}, true
+}
+
+// Proxy trusts a header naming the user, such as X-Forwarded-User from oauth2-proxy or
+// X-Forwarded-Email from an OIDC-aware load balancer. Use it only when every request passes
+// through that proxy, which must strip the header from client requests.
+type Proxy struct {
+	header string
+}
+
+// NewProxy trusts the given header.
+func NewProxy(header string) *Proxy {
+	return &Proxy{header: http.CanonicalHeaderKey(header)}
+}
+
+// Method returns MethodProxy.
+func (p *Proxy) Method() string {
+	return MethodProxy
+}
+
+// Authenticate accepts the user named in the header.
+func (p *Proxy) Authenticate(r *http.Request) (User, bool) {
+	name := strings.TrimSpace(r.Header.Get(p.header))
+	if name == "" {
+		return User{}, false
+	}
+
+	return User{Name: name, Method: MethodProxy}, true
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// SessionCookie is the name of the session cookie.
+	SessionCookie = "zeek_viz_session"
+
+	minSessionKey = 32 // Bytes of a session signing key at least
+)
+
+var errSessionKey = errors.New("session key must be at least 32 bytes")
+
+// sessionClaims is the signed content of a session cookie.
+type sessionClaims struct {
+	Name    string `json:"name"`
+	Method  string `json:"method"` // How the user signed in
+	Expires int64  `json:"exp"`
+}
+
+// Sessions issues and verifies signed session cookies, so browsers sign in once with a token
+// or password and then authenticate every request, including event streams and downloads that
+// can't send an Authorization header. Sessions are stateless: instances sharing a key accept
+// each other's cookies, and they stay valid until they expire.
+type Sessions struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewSessions signs sessions lasting ttl with key. An empty key is replaced by a random one,
+// which signs everyone out on restart.
+func NewSessions(key []byte, ttl time.Duration) (*Sessions, error) {
+	if len(key) == 0 {
+		key = make([]byte, minSessionKey)
+		_, _ = rand.Read(key) // Never fails
+	}
+	if len(key) < minSessionKey {
+		return nil, errSessionKey
+	}
+
+	return &Sessions{key: key, ttl: ttl}, nil
+}
+
+// Method returns MethodSession.
+func (s *Sessions) Method() string {
+	return MethodSession
+}
+
+// Authenticate accepts a valid, unexpired session cookie.
+func (s *Sessions) Authenticate(r *http.Request) (User, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return User{}, false
+	}
+	payload, signature, found := strings.Cut(cookie.Value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return User{}, false
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return User{}, false
+	}
+	var claims sessionClaims
+	err = json.Unmarshal(data, &claims)
+	if err != nil || time.Now().Unix() >= claims.Expires {
+		return User{}, false
+	}
+
+	return User{Name: claims.Name, Method: MethodSession}, true
+}
+
+// Issue returns a session cookie for user, scoped to path. It is only sent over HTTPS when
+// secure is set.
+func (s *Sessions) Issue(user User, path string, secure bool) (*http.Cookie, error) {
+	expires := time.Now().Add(s.ttl)
+	data, err := json.Marshal(sessionClaims{Name: user.Name, Method: user.Method, Expires: expires.Unix()})
+	if err != nil {
+		return nil, fmt.Errorf("encoding session: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    payload + "." + s.sign(payload),
+		Path:     path,
+		Expires:  expires,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode, // Cross-site requests carry no session, which rules out CSRF
+	}, nil
+}
+
+// Clear returns a cookie deleting the session cookie scoped to path.
+func (s *Sessions) Clear(path string, secure bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Path:     path,
+		MaxAge:   -1,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// sign returns the signature of a cookie payload.
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
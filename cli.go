@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+	"zeek-viz/parse"
+)
+
+var (
+	errExportFormat = errors.New("must be csv or ndjson")
+	errGraphFormat  = errors.New("must be graphml, gexf, dot, or json")
+	errEdgeBy       = errors.New("must be protocol, pair, service, or port")
+	errFilterParam  = errors.New("unknown filter parameter")
+	errFilterTwice  = errors.New("q in --params and --filter both given")
+)
+
+// usage prints the commands and the flags of serve, as -h shows them.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprint(out, `Usage:
+  zeek-viz [serve] [flags]                      Serve the web interface and API
+  zeek-viz stats [flags] [conn.log ...]         Print the statistics of logs
+  zeek-viz graph [flags] [conn.log ...]         Write the network graph of logs
+  zeek-viz export [flags] [conn.log ...]        Write the connections of logs as CSV or NDJSON
+  zeek-viz generate [flags]                     Write a synthetic conn.log
+
+stats, graph, and export read standard input without logs or for "-". Run a command with -h
+for its flags. The flags of serve are:
+`)
+	flag.PrintDefaults()
+}
+
+// logFlags are the flags of the commands that read logs.
+type logFlags struct {
+	filter        *string
+	params        *string
+	localNetworks *string
+	servicePorts  *string
+	strict        *bool
+}
+
+// addLogFlags defines the flags of the commands that read logs.
+func addLogFlags(flags *flag.FlagSet) logFlags {
+	return logFlags{
+		filter: flags.String("filter", "", "Keep only the connections matching this query expression, e.g. 'proto==udp and resp_bytes>1000'"),
+		params: flags.String("params", "", "Keep only the connections these API filter parameters select, e.g. 'protocol=udp&resp_port=53&scope=external'"),
+		localNetworks: flags.String("local-networks", "",
+			"Comma-separated CIDR prefixes of the local network, or @file with one per line (default: private ranges)"),
+		servicePorts: flags.String("service-ports", "",
+			"Comma-separated port=service or port/proto=service overrides of the ports services are guessed from, or @file with one per line"),
+		strict: flags.Bool("strict", false, "Fail on the first damaged line instead of repairing or skipping it"),
+	}
+}
+
+// local returns the networks of --local-networks, or the private ranges without it.
+func (f logFlags) local() (models.LocalNetworks, error) {
+	if *f.localNetworks == "" {
+		return models.DefaultLocalNetworks(), nil
+	}
+
+	values, err := listValues(*f.localNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local networks: %w", err)
+	}
+	local, err := models.ParseLocalNetworks(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local networks: %w", err)
+	}
+
+	return local, nil
+}
+
+// ports returns the ports services are guessed from: the well-known ones, with the
+// overrides of --service-ports.
+func (f logFlags) ports() (models.ServicePorts, error) {
+	if *f.servicePorts == "" {
+		return models.DefaultServicePorts(), nil
+	}
+
+	values, err := listValues(*f.servicePorts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service ports: %w", err)
+	}
+	overrides, err := models.ParseServicePorts(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service ports: %w", err)
+	}
+
+	return models.DefaultServicePorts().WithOverrides(overrides), nil
+}
+
+// connectionFilter returns the filter of --params, with --filter as its q expression, as
+// the API parses them. Parameters the API filters by without connfilter, such as country,
+// are rejected.
+func (f logFlags) connectionFilter() (*connfilter.Filter, error) {
+	values, err := url.ParseQuery(*f.params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --params: %w", err)
+	}
+	for name := range values {
+		if !slices.Contains(connfilter.Params(), name) {
+			return nil, fmt.Errorf("invalid --params: %w: %s", errFilterParam, name)
+		}
+	}
+	if *f.filter != "" {
+		if values.Has("q") {
+			return nil, errFilterTwice
+		}
+		values.Set("q", *f.filter)
+	}
+	local, err := f.local()
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := connfilter.Parse(values, local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	return filter, nil
+}
+
+// readLogs parses the logs named by files, or standard input without any or for "-",
+// decompressing .gz files, guesses the services of their connections as uploads do, and keeps
+// the connections --params and --filter select. Skipped lines are logged. Parsing stops on
+// SIGINT or SIGTERM.
+func readLogs(files []string, options logFlags) ([]models.Connection, error) {
+	filter, err := options.connectionFilter()
+	if err != nil {
+		return nil, err
+	}
+	ports, err := options.ports()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var connections []models.Connection
+	for _, name := range files {
+		parsed, err := readLog(ctx, name, *options.strict)
+		if err != nil {
+			return nil, err
+		}
+		connstats.GuessServices(parsed, ports, nil)
+		connections = append(connections, filter.Apply(parsed)...)
+	}
+
+	return connections, nil
+}
+
+// readLog parses one log, or standard input for "-".
+func readLog(ctx context.Context, name string, strict bool) ([]models.Connection, error) {
+	var reader io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name) //nolint:gosec // Reading the logs the user names is the point
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log: %w", err)
+		}
+		defer file.Close()
+		reader = file
+
+		if strings.HasSuffix(name, ".gz") {
+			decompressed, err := gzip.NewReader(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+			}
+			defer decompressed.Close()
+			reader = decompressed
+		}
+	}
+
+	result, err := parse.Connections(ctx, reader, parse.Options{Strict: strict})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if warning := result.Report.Warning(); warning != "" {
+		log.Printf("%s: %s", name, warning)
+	}
+
+	return result.Connections, nil
+}
+
+// writeOutput writes to standard output, or to the file at path if there is one.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	out := os.Stdout
+	if path != "" {
+		var err error
+		out, err = os.Create(path) //nolint:gosec // Writing where the user asks is the point
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+	}
+
+	writer := bufio.NewWriter(out)
+	err := write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if path != "" {
+		err = cmp.Or(err, out.Close())
+	}
+
+	return err
+}
+
+// runStats implements "zeek-viz stats": it prints the statistics /api/stats reports for the
+// connections of the logs, as a table or, with --json, in the JSON of /api/stats.
+func runStats(args []string) {
+	err := printStats(os.Stdout, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printStats writes the statistics of "zeek-viz stats" with the arguments args to w.
+func printStats(w io.Writer, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	options := addLogFlags(flags)
+	asJSON := flags.Bool("json", false, "Print the statistics as JSON, with the fields of /api/stats")
+	humanize := flags.Bool("humanize", false, "Add the formatted fields of /api/stats?humanize=true to --json")
+	_ = flags.Parse(args) // ExitOnError exits on invalid flags
+
+	connections, err := readLogs(flags.Args(), options)
+	if err != nil {
+		return err
+	}
+	stats := connstats.Compute(connections)
+
+	if *asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(connstats.Summary(stats, connstats.Options{Humanize: *humanize}))
+		if err != nil {
+			return fmt.Errorf("failed to encode stats: %w", err)
+		}
+
+		return nil
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd // Column padding
+	fmt.Fprintf(table, "connections\t%d\n", stats.TotalConnections)
+	fmt.Fprintf(table, "bytes\t%d\n", stats.TotalBytes)
+	fmt.Fprintf(table, "hosts\t%d\n", stats.UniqueIPCount())
+	if stats.TotalConnections > 0 {
+		fmt.Fprintf(table, "start\t%s\n", formatTimestamp(stats.StartTime))
+		fmt.Fprintf(table, "end\t%s\n", formatTimestamp(stats.EndTime))
+		fmt.Fprintf(table, "duration\t%s\n", time.Duration(stats.Duration()*float64(time.Second)).Round(time.Millisecond))
+	}
+	fmt.Fprintf(table, "protocols\t%s\n", formatCounts(stats.Protocols))
+	fmt.Fprintf(table, "services\t%s\n", formatCounts(stats.Services))
+	fmt.Fprintf(table, "service guesses\t%s\n", formatCounts(stats.ServiceGuesses))
+	fmt.Fprintf(table, "conn states\t%s\n", formatCounts(stats.ConnStates))
+
+	return table.Flush() //nolint:wrapcheck // Reported as a write failure
+}
+
+// formatTimestamp formats a Zeek timestamp in RFC 3339, in UTC, to the microseconds Zeek
+// logs.
+func formatTimestamp(timestamp float64) string {
+	return time.UnixMicro(int64(math.Round(timestamp * 1e6))).UTC().Format("2006-01-02T15:04:05.000000Z07:00") //nolint:mnd // Microseconds
+}
+
+// formatCounts lists counts by key, most frequent first, like "tcp 300, udp 150".
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(x, y string) int {
+		return cmp.Or(cmp.Compare(counts[y], counts[x]), strings.Compare(x, y))
+	})
+
+	listed := make([]string, len(keys))
+	for i, key := range keys {
+		listed[i] = fmt.Sprintf("%s %d", cmp.Or(key, "-"), counts[key])
+	}
+
+	return cmp.Or(strings.Join(listed, ", "), "-")
+}
+
+// graphWriters returns the writers of the graph formats "zeek-viz graph" writes, by format
+// name: those of /api/export/graph, and json for the graph as /api/nodes returns it.
+func graphWriters() map[string]func(w io.Writer, graph models.NetworkGraph) error {
+	return map[string]func(w io.Writer, graph models.NetworkGraph) error{
+		"graphml": models.WriteGraphML,
+		"gexf":    models.WriteGEXF,
+		"dot":     models.WriteDOT,
+		"json": func(w io.Writer, graph models.NetworkGraph) error {
+			return json.NewEncoder(w).Encode(graph) //nolint:wrapcheck // Reported as a write failure
+		},
+	}
+}
+
+// runGraph implements "zeek-viz graph": it builds the network graph of the connections of the
+// logs, as /api/nodes does without a limit, and writes it to stdout or the --output file.
+func runGraph(args []string) {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	options := addLogFlags(flags)
+	format := flags.String("format", "graphml", "Graph format: graphml, gexf, dot, or json")
+	edgeBy := flags.String("edge-by", netgraph.ByProtocol, "Connections sharing an edge: those of a protocol, pair, service, or port")
+	output := flags.String("output", "", "Output file (default stdout)")
+	_ = flags.Parse(args) // ExitOnError exits on invalid flags
+
+	write, exists := graphWriters()[*format]
+	if !exists {
+		log.Fatalf("Invalid --format: %v", errGraphFormat)
+	}
+	if !slices.Contains([]string{netgraph.ByProtocol, netgraph.ByPair, netgraph.ByService, netgraph.ByPort}, *edgeBy) {
+		log.Fatalf("Invalid --edge-by: %v", errEdgeBy)
+	}
+	local, err := options.local()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	connections, err := readLogs(flags.Args(), options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	graph := netgraph.Build(connections, local, *edgeBy)
+	err = writeOutput(*output, func(w io.Writer) error { return write(w, graph) })
+	if err != nil {
+		log.Fatalf("Failed to write graph: %v", err)
+	}
+	log.Printf("Wrote a graph of %d nodes and %d edges from %d connections", len(graph.Nodes), len(graph.Edges), len(connections))
+}
+
+// runExport implements "zeek-viz export": it writes the connections of the logs as CSV or
+// NDJSON, as /api/export does, to stdout or the --output file.
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	options := addLogFlags(flags)
+	format := flags.String("format", "csv", "Export format: csv, or ndjson for one JSON connection per line")
+	fields := flags.String("fields", "", "Comma-separated fields to export, or @file with one per line (default all conn.log fields)")
+	output := flags.String("output", "", "Output file (default stdout)")
+	_ = flags.Parse(args) // ExitOnError exits on invalid flags
+
+	write := map[string]func(io.Writer, []models.Connection, []string, models.Annotator) error{
+		"csv":    models.WriteCSV,
+		"ndjson": models.WriteNDJSON,
+	}[*format]
+	if write == nil {
+		log.Fatalf("Invalid --format: %v", errExportFormat)
+	}
+	fieldList, err := listValues(*fields)
+	if err != nil {
+		log.Fatalf("Failed to read fields: %v", err)
+	}
+	for _, field := range fieldList {
+		if _, exists := models.StringFieldAccessor(field); !exists {
+			log.Fatalf("Invalid --fields: %v: %s", models.ErrUnknownField, field)
+		}
+	}
+
+	connections, err := readLogs(flags.Args(), options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeOutput(*output, func(w io.Writer) error { return write(w, connections, fieldList, nil) })
+	if err != nil {
+		log.Fatalf("Failed to write connections: %v", err)
+	}
+	log.Printf("Exported %d connections as %s", len(connections), *format)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"zeek-viz/handlers"
+)
+
+// testLog is the conn.log both sides of TestStatsMatchesAPI read.
+const testLog = "test-data/conn.log"
+
+// apiStats uploads the log to a fresh API and returns the decoded /api/stats body of the
+// query, without the fields about the loaded datasets, which the command has no equivalent of.
+func apiStats(t *testing.T, content []byte, query string) map[string]any {
+	t.Helper()
+
+	api := handlers.NewAPI("")
+	mux := http.NewServeMux()
+	api.HandleAPI(mux)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("logfile", "conn.log")
+	if err == nil {
+		_, err = part.Write(content)
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		t.Fatalf("building upload: %v", err)
+	}
+	r := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/api/stats?"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/api/stats?%s: status %d: %s", query, w.Code, w.Body)
+	}
+	var stats map[string]any
+	err = json.Unmarshal(w.Body.Bytes(), &stats)
+	if err != nil {
+		t.Fatalf("decoding /api/stats: %v", err)
+	}
+	delete(stats, "current_file")
+	delete(stats, "total_files")
+
+	return stats
+}
+
+func TestStatsMatchesAPI(t *testing.T) {
+	content, err := os.ReadFile(testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args  []string
+		query string
+	}{
+		{[]string{"--json"}, ""},
+		{[]string{"--json", "--humanize"}, "humanize=true"},
+		{[]string{"--json", "--params", "exclude_noise=true"}, "exclude_noise=true"},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			var out bytes.Buffer
+			err := printStats(&out, append(test.args, testLog))
+			if err != nil {
+				t.Fatalf("zeek-viz stats: %v", err)
+			}
+			var command map[string]any
+			err = json.Unmarshal(out.Bytes(), &command)
+			if err != nil {
+				t.Fatalf("decoding zeek-viz stats --json: %v", err)
+			}
+
+			want := apiStats(t, content, test.query)
+			if guesses, _ := want["service_guesses"].(map[string]any); len(guesses) == 0 {
+				t.Fatal("/api/stats has no service guesses to compare")
+			}
+			if !reflect.DeepEqual(command, want) {
+				got, _ := json.MarshalIndent(command, "", "  ")
+				expected, _ := json.MarshalIndent(want, "", "  ")
+				t.Errorf("zeek-viz stats %v:\n%s\nwant /api/stats?%s:\n%s", test.args, got, test.query, expected)
+			}
+		})
+	}
+}
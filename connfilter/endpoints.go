@@ -0,0 +1,200 @@
+package connfilter
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const maxPort = 65535 // Highest TCP/UDP port number
+
+var (
+	// ErrInvalidPort is returned for a port filter that isn't a list of ports and ranges.
+	ErrInvalidPort = errors.New("ports must be comma-separated ports or ranges such as 80,443,8000-8100")
+	// ErrInvalidHost is returned for a host or subnet filter that isn't a list of addresses
+	// and prefixes.
+	ErrInvalidHost = errors.New("hosts and subnets must be comma-separated IP addresses or CIDR prefixes")
+)
+
+// PortRange is an inclusive range of ports; a single port has equal bounds.
+type PortRange struct {
+	Low, High int
+}
+
+// Endpoints matches connections by their ports, service, and hosts. Empty lists match every
+// connection.
+type Endpoints struct {
+	OrigPorts []PortRange
+	RespPorts []PortRange
+	Services  []string
+	Guesses   bool // Services also match the service_guess of connections Zeek found none on
+	OrigHosts []netip.Prefix
+	RespHosts []netip.Prefix
+	Subnets   []netip.Prefix // Either host
+}
+
+// ParseEndpoints reads the orig_port, resp_port, service, orig_host, resp_host, and subnet
+// parameters. Each takes a comma-separated list and matches connections with any listed
+// value; subnet matches connections with either host in a listed prefix. With
+// infer_services=true, service also matches the services guessed from the port.
+func ParseEndpoints(values url.Values) (*Endpoints, error) {
+	endpoints := &Endpoints{
+		Services: SplitList(strings.ToLower(values.Get("service"))),
+		Guesses:  values.Get("infer_services") == "true",
+	}
+
+	var err error
+	endpoints.OrigPorts, err = parsePortRanges(values.Get("orig_port"))
+	if err != nil {
+		return nil, fmt.Errorf("orig_port: %w", err)
+	}
+	endpoints.RespPorts, err = parsePortRanges(values.Get("resp_port"))
+	if err != nil {
+		return nil, fmt.Errorf("resp_port: %w", err)
+	}
+	endpoints.OrigHosts, err = ParseHostPrefixes(values.Get("orig_host"))
+	if err != nil {
+		return nil, fmt.Errorf("orig_host: %w", err)
+	}
+	endpoints.RespHosts, err = ParseHostPrefixes(values.Get("resp_host"))
+	if err != nil {
+		return nil, fmt.Errorf("resp_host: %w", err)
+	}
+	endpoints.Subnets, err = ParseHostPrefixes(values.Get("subnet"))
+	if err != nil {
+		return nil, fmt.Errorf("subnet: %w", err)
+	}
+
+	return endpoints, nil
+}
+
+// parsePortRanges parses a list such as "80,443,8000-8100".
+func parsePortRanges(value string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, item := range SplitList(value) {
+		lowText, highText, isRange := strings.Cut(item, "-")
+		if !isRange {
+			highText = lowText
+		}
+
+		low, lowErr := strconv.Atoi(strings.TrimSpace(lowText))
+		high, highErr := strconv.Atoi(strings.TrimSpace(highText))
+		if lowErr != nil || highErr != nil || low < 0 || high > maxPort || low > high {
+			return nil, fmt.Errorf("%w, got %q", ErrInvalidPort, item)
+		}
+		ranges = append(ranges, PortRange{Low: low, High: high})
+	}
+
+	return ranges, nil
+}
+
+// ParseHostPrefixes parses a comma-separated list of IP addresses and CIDR prefixes.
+func ParseHostPrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range SplitList(value) {
+		prefix, err := ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%w, got %q", ErrInvalidHost, item)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// ParsePrefix parses an IP address, as the prefix of just that address, or a CIDR prefix,
+// masking its host bits.
+func ParsePrefix(value string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		addr = addr.Unmap()
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, ErrInvalidHost
+	}
+
+	return prefix.Masked(), nil
+}
+
+// Apply keeps the connections the filter matches.
+func (f *Endpoints) Apply(connections []models.Connection) []models.Connection {
+	active := len(f.OrigPorts)+len(f.RespPorts)+len(f.Services)+len(f.OrigHosts)+len(f.RespHosts)+len(f.Subnets) > 0
+
+	return keep(connections, active, f.Matches)
+}
+
+// Matches reports whether a connection passes every set part of the filter.
+func (f *Endpoints) Matches(conn *models.Connection) bool {
+	return inPortRanges(f.OrigPorts, conn.OrigPort) &&
+		inPortRanges(f.RespPorts, conn.RespPort) &&
+		f.matchesService(conn) &&
+		inPrefixes(f.OrigHosts, conn.OrigHost) &&
+		inPrefixes(f.RespHosts, conn.RespHost) &&
+		(len(f.Subnets) == 0 || inPrefixes(f.Subnets, conn.OrigHost) || inPrefixes(f.Subnets, conn.RespHost))
+}
+
+// matchesService reports whether the connection has one of the filter's services, or the
+// filter accepts guesses and Zeek found no service but the port suggests one.
+func (f *Endpoints) matchesService(conn *models.Connection) bool {
+	if hasService(f.Services, conn.Service) {
+		return true
+	}
+
+	return f.Guesses && conn.Service == "" && hasService(f.Services, conn.ServiceGuess)
+}
+
+// inPortRanges reports whether port is in one of the ranges, or ranges is empty.
+func inPortRanges(ranges []PortRange, port int) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if port >= r.Low && port <= r.High {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasService reports whether one of the connection's services (Zeek lists several
+// comma-separated, e.g. "ssl,http") is in services, or services is empty.
+func hasService(services []string, service string) bool {
+	if len(services) == 0 {
+		return true
+	}
+	for _, name := range SplitList(strings.ToLower(service)) {
+		if slices.Contains(services, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inPrefixes reports whether host is in one of the prefixes, or prefixes is empty.
+func inPrefixes(prefixes []netip.Prefix, host string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	addr, err := models.ParseHost(host)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
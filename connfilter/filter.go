@@ -0,0 +1,209 @@
+// Package connfilter filters connections by the query parameters the API accepts, such as
+// protocol=udp&resp_port=53&scope=external, so the server and the command line select the
+// same connections for the same parameters.
+package connfilter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zeek-viz/models"
+	"zeek-viz/query"
+)
+
+const all = "all" // Value of protocol and conn_state that keeps every connection
+
+var (
+	// ErrInvalidIPVersion is returned for an ip_version other than 4 or 6.
+	ErrInvalidIPVersion = errors.New("ip_version must be 4 or 6")
+	// ErrInvalidHistory is returned for a history_flag listing an unknown flag.
+	ErrInvalidHistory = errors.New("history_flag must list half_open, midstream, no_data, reset_midstream, content_gap, retransmitted, zero_window, or flipped")
+)
+
+// Params returns the query parameters Parse reads.
+func Params() []string {
+	return []string{
+		"start", "end", "protocol", "conn_state", "exclude_noise", "scope",
+		"orig_port", "resp_port", "service", "orig_host", "resp_host", "subnet",
+		"infer_services", "q", "ip_version", "history_flag",
+	}
+}
+
+// Filter is a parsed set of the parameters Params lists.
+type Filter struct {
+	start, end string
+	protocol   string
+	connState  string
+	noise      bool // Drop broadcast, multicast, and link-local traffic
+	scope      string
+	local      models.LocalNetworks // Networks scope judges endpoints local by
+	version    int
+	history    []string
+	endpoints  *Endpoints
+	predicate  query.ConnectionPredicate
+}
+
+// Parse reads the filters of a query; scope judges endpoints by whether they are in local.
+// Invalid port, host, scope, ip_version, history_flag, or q values are rejected. Other
+// parameters are ignored.
+func Parse(values url.Values, local models.LocalNetworks) (*Filter, error) {
+	if !ValidScope(values.Get("scope")) {
+		return nil, ErrInvalidScope
+	}
+	version, err := ParseIPVersion(values.Get("ip_version"))
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := ParseEndpoints(values)
+	if err != nil {
+		return nil, err
+	}
+	predicate, err := parseQuery(values.Get("q"))
+	if err != nil {
+		return nil, err
+	}
+	history, err := parseHistoryFlags(values.Get("history_flag"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{
+		start:     values.Get("start"),
+		end:       values.Get("end"),
+		protocol:  values.Get("protocol"),
+		connState: values.Get("conn_state"),
+		noise:     ExcludesNoise(values),
+		scope:     values.Get("scope"),
+		local:     local,
+		version:   version,
+		history:   history,
+		endpoints: endpoints,
+		predicate: predicate,
+	}, nil
+}
+
+// Apply keeps the connections that pass every filter. It may return connections itself.
+func (f *Filter) Apply(connections []models.Connection) []models.Connection {
+	connections = applyTime(connections, f.start, f.end)
+	connections = keep(connections, f.protocol != "" && f.protocol != all, func(conn *models.Connection) bool {
+		return conn.Protocol == f.protocol
+	})
+	connections = keep(connections, f.connState != "" && f.connState != all, func(conn *models.Connection) bool {
+		return conn.ConnState == f.connState
+	})
+	connections = ApplyNoise(connections, f.noise)
+	connections = applyScope(connections, f.scope, f.local)
+	connections = keep(connections, f.version != 0, func(conn *models.Connection) bool {
+		return connectionVersion(conn) == f.version
+	})
+	connections = keep(connections, len(f.history) > 0, func(conn *models.Connection) bool {
+		return !slices.ContainsFunc(f.history, func(flag string) bool { return !conn.HasHistoryFlag(flag) })
+	})
+	connections = f.endpoints.Apply(connections)
+
+	return keep(connections, f.predicate != nil, f.predicate)
+}
+
+// keep returns the connections matching match, or all of them when active is false.
+func keep(connections []models.Connection, active bool, match func(*models.Connection) bool) []models.Connection {
+	if !active {
+		return connections
+	}
+
+	var filtered []models.Connection
+	for i := range connections {
+		if match(&connections[i]) {
+			filtered = append(filtered, connections[i])
+		}
+	}
+
+	return filtered
+}
+
+// ParseIPVersion reads the ip_version parameter, 4 or 6; 0 means either.
+func ParseIPVersion(value string) (int, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "4", "6":
+		return strconv.Atoi(value) //nolint:wrapcheck // Digits checked above
+	default:
+		return 0, ErrInvalidIPVersion
+	}
+}
+
+// connectionVersion returns the IP version of a connection, judged by the originator, or the
+// responder where the originator isn't an address.
+func connectionVersion(conn *models.Connection) int {
+	return cmp.Or(models.IPVersion(conn.OrigHost), models.IPVersion(conn.RespHost))
+}
+
+// parseHistoryFlags reads the history_flag parameter, a comma-separated list of history flags
+// such as half_open,no_data.
+func parseHistoryFlags(value string) ([]string, error) {
+	flags := SplitList(strings.ToLower(value))
+	for _, flag := range flags {
+		if !slices.Contains(models.HistoryFlagNames(), flag) {
+			return nil, ErrInvalidHistory
+		}
+	}
+
+	return flags, nil
+}
+
+// parseQuery compiles the q parameter, an expression such as
+// `resp_port==445 && orig_bytes>1e6 && resp_h in 10.0.0.0/8`. It returns nil without one.
+func parseQuery(value string) (query.ConnectionPredicate, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil //nolint:nilnil // No expression filters nothing
+	}
+
+	expr, err := query.ParseExpr(value)
+	if err != nil {
+		return nil, fmt.Errorf("q: %w", err)
+	}
+	predicate, err := query.CompileConnectionFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("q: %w", err)
+	}
+
+	return predicate, nil
+}
+
+// applyTime keeps the connections from the start to the end second, both Unix timestamps.
+// Without both, or with either invalid, it keeps all connections.
+func applyTime(connections []models.Connection, startTime, endTime string) []models.Connection {
+	if startTime == "" || endTime == "" {
+		return connections
+	}
+
+	start, err1 := strconv.ParseInt(startTime, 10, 64)
+	end, err2 := strconv.ParseInt(endTime, 10, 64)
+	if err1 != nil || err2 != nil {
+		return connections
+	}
+
+	return keep(connections, true, func(conn *models.Connection) bool {
+		ts := int64(conn.Timestamp)
+
+		return ts >= start && ts <= end
+	})
+}
+
+// SplitList splits a comma-separated query value into trimmed, non-empty items.
+func SplitList(value string) []string {
+	items := make([]string, 0)
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
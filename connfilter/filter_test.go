@@ -0,0 +1,87 @@
+package connfilter
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"zeek-viz/models"
+)
+
+// testConnections returns connections covering the filters, named by their UIDs.
+func testConnections() []models.Connection {
+	return []models.Connection{
+		{UID: "dns", Timestamp: 100, OrigHost: "10.0.0.5", OrigPort: 5353, RespHost: "192.0.2.53", RespPort: 53, Protocol: "udp", Service: "dns", ConnState: "SF"},
+		{UID: "web", Timestamp: 200, OrigHost: "10.0.0.5", OrigPort: 50000, RespHost: "198.51.100.7", RespPort: 443, Protocol: "tcp", Service: "ssl,http", ConnState: "SF", History: "ShADadFf"},
+		{UID: "smb", Timestamp: 300, OrigHost: "10.0.0.6", OrigPort: 50001, RespHost: "10.0.0.9", RespPort: 445, Protocol: "tcp", ConnState: "S0", History: "S", ServiceGuess: "smb"},
+		{UID: "mdns", Timestamp: 400, OrigHost: "10.0.0.7", OrigPort: 5353, RespHost: "224.0.0.251", RespPort: 5353, Protocol: "udp", ConnState: "S0"},
+		{UID: "v6", Timestamp: 500, OrigHost: "2001:db8::1", OrigPort: 40000, RespHost: "2001:db8::2", RespPort: 22, Protocol: "tcp", Service: "ssh", ConnState: "SF"},
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	tests := []struct {
+		params string
+		want   []string
+	}{
+		{"", []string{"dns", "web", "smb", "mdns", "v6"}},
+		{"start=150&end=350", []string{"web", "smb"}},
+		{"protocol=udp", []string{"dns", "mdns"}},
+		{"protocol=all&conn_state=S0", []string{"smb", "mdns"}},
+		{"exclude_noise=true", []string{"dns", "web", "smb", "v6"}},
+		{"scope=internal", []string{"smb"}},
+		{"scope=crossing", []string{"dns", "web", "mdns"}},
+		{"ip_version=6", []string{"v6"}},
+		{"history_flag=half_open", []string{"smb"}},
+		{"resp_port=400-500", []string{"web", "smb"}},
+		{"service=http", []string{"web"}},
+		{"service=smb&infer_services=true", []string{"smb"}},
+		{"subnet=198.51.100.0/24,2001:db8::1", []string{"web", "v6"}},
+		{"orig_host=10.0.0.5&resp_port=53", []string{"dns"}},
+		{"q=resp_port==445 or resp_port==22", []string{"smb", "v6"}},
+	}
+	for _, test := range tests {
+		t.Run(test.params, func(t *testing.T) {
+			values, err := url.ParseQuery(test.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := Parse(values, models.DefaultLocalNetworks())
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			var got []string
+			for _, conn := range filter.Apply(testConnections()) {
+				got = append(got, conn.UID)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("kept %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidValues(t *testing.T) {
+	// A nil error accepts any error
+	tests := map[string]error{
+		"scope=everywhere":      ErrInvalidScope,
+		"ip_version=5":          ErrInvalidIPVersion,
+		"history_flag=sideways": ErrInvalidHistory,
+		"resp_port=80-70":       ErrInvalidPort,
+		"orig_port=70000":       ErrInvalidPort,
+		"subnet=10.0.0.0/33":    ErrInvalidHost,
+		"q=resp_port==":         nil,
+	}
+	for params, want := range tests {
+		values, err := url.ParseQuery(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Parse(values, nil)
+		if err == nil || (want != nil && !errors.Is(err, want)) {
+			t.Errorf("%s: error %v, want %v", params, err, want)
+		}
+	}
+}
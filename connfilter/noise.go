@@ -0,0 +1,38 @@
+package connfilter
+
+import (
+	"net/url"
+
+	"zeek-viz/models"
+)
+
+const broadcastOctet = 255 // Last octet of IPv4 directed broadcast addresses
+
+// ExcludesNoise reports whether the query asks to hide broadcast, multicast, and link-local
+// traffic.
+func ExcludesNoise(values url.Values) bool {
+	return values.Get("exclude_noise") == "true"
+}
+
+// ApplyNoise drops connections to or from broadcast, multicast, and link-local addresses,
+// such as mDNS, SSDP, and NetBIOS chatter, when exclude is set.
+func ApplyNoise(connections []models.Connection, exclude bool) []models.Connection {
+	return keep(connections, exclude, func(conn *models.Connection) bool {
+		return !IsNoiseAddress(conn.OrigHost) && !IsNoiseAddress(conn.RespHost)
+	})
+}
+
+// IsNoiseAddress reports whether the address is a broadcast (x.x.x.255, 255.255.255.255),
+// multicast (224.0.0.0/4, ff00::/8), or link-local (169.254.0.0/16, fe80::/10) address.
+func IsNoiseAddress(host string) bool {
+	addr, err := models.ParseHost(host)
+	if err != nil {
+		return false
+	}
+
+	if addr.Is4() && addr.As4()[3] == broadcastOctet {
+		return true
+	}
+
+	return addr.IsMulticast() || addr.IsLinkLocalUnicast()
+}
@@ -0,0 +1,45 @@
+package connfilter
+
+import (
+	"errors"
+	"slices"
+
+	"zeek-viz/models"
+)
+
+// Scopes of the scope parameter.
+const (
+	ScopeInternal = "internal" // Both endpoints on the local network
+	ScopeExternal = "external" // At least one endpoint outside the local network
+	ScopeCrossing = "crossing" // Exactly one endpoint on the local network
+)
+
+// ErrInvalidScope is returned for an unknown scope.
+var ErrInvalidScope = errors.New("scope must be internal, external, or crossing")
+
+// ValidScope reports whether scope is empty or a known traffic scope.
+func ValidScope(scope string) bool {
+	return scope == "" || slices.Contains([]string{ScopeInternal, ScopeExternal, ScopeCrossing}, scope)
+}
+
+// applyScope keeps connections within the given network scope, judged by whether their
+// endpoints are in the local networks. An empty or unknown scope keeps all connections.
+func applyScope(connections []models.Connection, scope string, local models.LocalNetworks) []models.Connection {
+	return keep(connections, scope != "" && ValidScope(scope), func(conn *models.Connection) bool {
+		return inScope(local.Contains(conn.OrigHost), local.Contains(conn.RespHost), scope)
+	})
+}
+
+// inScope reports whether a connection between endpoints of the given locality is in scope.
+func inScope(origLocal, respLocal bool, scope string) bool {
+	switch scope {
+	case ScopeInternal:
+		return origLocal && respLocal
+	case ScopeExternal:
+		return !origLocal || !respLocal
+	case ScopeCrossing:
+		return origLocal != respLocal
+	default:
+		return true
+	}
+}
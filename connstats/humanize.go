@@ -0,0 +1,85 @@
+package connstats
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	bytesUnit     = 1000   // SI multiple between byte units
+	secondsPerMin = 60     // Seconds in a minute
+	secondsPerHr  = 3600   // Seconds in an hour
+	secondsPerDay = 86400  // Seconds in a day
+	msPerSecond   = 1000.0 // Milliseconds in a second
+)
+
+// HumanizeBytes formats a byte count with SI units, e.g. "1.4 GB".
+func HumanizeBytes(bytes float64) string {
+	if math.Abs(bytes) < bytesUnit {
+		return fmt.Sprintf("%.0f B", bytes)
+	}
+
+	units := "kMGTPE"
+	value := bytes
+	unit := -1
+	for math.Abs(value) >= bytesUnit && unit < len(units)-1 {
+		value /= bytesUnit
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %cB", value, units[unit])
+}
+
+// HumanizeDuration formats seconds as the two most significant units, e.g. "2h 13m".
+func HumanizeDuration(seconds float64) string {
+	if seconds < 1 {
+		return fmt.Sprintf("%.0fms", seconds*msPerSecond)
+	}
+
+	total := int64(seconds)
+	parts := []struct {
+		value int64
+		unit  string
+	}{
+		{total / secondsPerDay, "d"},
+		{total % secondsPerDay / secondsPerHr, "h"},
+		{total % secondsPerHr / secondsPerMin, "m"},
+		{total % secondsPerMin, "s"},
+	}
+
+	for i, part := range parts {
+		if part.value == 0 {
+			continue
+		}
+
+		formatted := fmt.Sprintf("%d%s", part.value, part.unit)
+		if i+1 < len(parts) && parts[i+1].value > 0 {
+			formatted += fmt.Sprintf(" %d%s", parts[i+1].value, parts[i+1].unit)
+		}
+
+		return formatted
+	}
+
+	return "0s"
+}
+
+// HumanizeCount formats a count with thousands separators, e.g. "12,345".
+func HumanizeCount(count int) string {
+	digits := strconv.Itoa(count)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	var builder strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteRune(digit)
+	}
+
+	return sign + builder.String()
+}
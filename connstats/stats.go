@@ -0,0 +1,129 @@
+// Package connstats summarizes connections into the statistics /api/stats reports, so the
+// server and "zeek-viz stats" report the same figures for the same connections.
+package connstats
+
+import (
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+)
+
+// Options selects the optional parts of a Summary.
+type Options struct {
+	Location *time.Location // Adds the start and end in this time zone
+	Humanize bool           // Adds formatted companions of the counts, bytes, and duration
+}
+
+// Compute returns the statistics of the connections.
+func Compute(connections []models.Connection) *models.ConnectionStats {
+	stats := models.NewConnectionStats()
+	for i := range connections {
+		stats.Add(&connections[i])
+	}
+
+	return stats
+}
+
+// GuessServices fills in the service_guess of the connections Zeek identified no service on,
+// from their responder port, and recounts the guesses in stats unless it is nil.
+func GuessServices(connections []models.Connection, ports models.ServicePorts, stats *models.ConnectionStats) {
+	if stats != nil {
+		stats.ServiceGuesses = make(map[string]int)
+	}
+	for i := range connections {
+		conn := &connections[i]
+		conn.ServiceGuess = ports.Guess(conn)
+		if stats != nil && conn.ServiceGuess != "" {
+			stats.ServiceGuesses[conn.ServiceGuess]++
+		}
+	}
+}
+
+// Summary returns the statistics as /api/stats reports them: the totals, the counts of each
+// protocol, service, guessed service, and connection state, the time range, and the
+// connection states with their descriptions.
+func Summary(stats *models.ConnectionStats, options Options) map[string]any {
+	timeRange := map[string]any{
+		"start":    stats.StartTime,
+		"end":      stats.EndTime,
+		"duration": stats.Duration(),
+	}
+	if options.Location != nil && stats.TotalConnections > 0 {
+		timeRange["timezone"] = options.Location.String()
+		timeRange["start_local"] = time.Unix(int64(stats.StartTime), 0).In(options.Location).Format(time.RFC3339)
+		timeRange["end_local"] = time.Unix(int64(stats.EndTime), 0).In(options.Location).Format(time.RFC3339)
+	}
+
+	summary := map[string]any{
+		"total_connections":     stats.TotalConnections,
+		"protocols":             stats.Protocols,
+		"services":              stats.Services,
+		"service_guesses":       stats.ServiceGuesses,
+		"conn_states":           stats.ConnStates,
+		"total_bytes":           stats.TotalBytes,
+		"unique_ip_count":       stats.UniqueIPCount(),
+		"time_range":            timeRange,
+		"available_conn_states": ConnStateDescriptions(stats.ConnStates),
+	}
+
+	if options.Humanize {
+		summary["total_connections_human"] = HumanizeCount(stats.TotalConnections)
+		summary["total_bytes_human"] = HumanizeBytes(float64(stats.TotalBytes))
+		summary["unique_ip_count_human"] = HumanizeCount(stats.UniqueIPCount())
+		timeRange["duration_human"] = HumanizeDuration(stats.Duration())
+	}
+
+	return summary
+}
+
+// ConnStateDescription returns a human-readable description of a connection state.
+func ConnStateDescription(state string) string {
+	descriptions := map[string]string{
+		"SF":     "Normal Established - Successful connection that was properly closed",
+		"S0":     "Connection Attempt Rejected - Initial SYN was not acknowledged",
+		"S1":     "Connection Established, Not Terminated - Connection established but not cleanly closed",
+		"S2":     "Connection Established, Originator Aborted - Connection established but originator aborted",
+		"S3":     "Connection Established, Responder Aborted - Connection established but responder aborted",
+		"REJ":    "Connection Rejected - Connection attempt was explicitly rejected",
+		"RSTO":   "Connection Reset by Originator - Originator sent RST",
+		"RSTR":   "Connection Reset by Responder - Responder sent RST",
+		"RSTOS0": "Originator Sent SYN+RST - Connection attempt with immediate reset",
+		"RSTRH":  "Responder Sent RST after Handshake - Reset after successful handshake",
+		"SH":     "Originator Sent SYN+FIN - Unusual SYN+FIN combination",
+		"SHR":    "Responder Sent SYN+FIN after SYN - Response with SYN+FIN",
+		"OTH":    "Other/No Further Info - No additional information available",
+	}
+
+	if desc, exists := descriptions[state]; exists {
+		return desc
+	}
+
+	return state + " - Unknown connection state"
+}
+
+// ConnStateDescriptions lists the connection states with their descriptions and counts, most
+// frequent first.
+func ConnStateDescriptions(connStates map[string]int) []map[string]any {
+	availableStates := make([]map[string]any, 0)
+	for state, count := range connStates {
+		availableStates = append(availableStates, map[string]any{
+			"code":        state,
+			"description": ConnStateDescription(state),
+			"count":       count,
+		})
+	}
+
+	// Sort by count (descending)
+	sort.Slice(availableStates, func(i, j int) bool {
+		countI, okI := availableStates[i]["count"].(int)
+		countJ, okJ := availableStates[j]["count"].(int)
+		if !okI || !okJ {
+			return false
+		}
+
+		return countI > countJ
+	})
+
+	return availableStates
+}
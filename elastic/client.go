@@ -0,0 +1,169 @@
+// Package elastic indexes connections in Elasticsearch or OpenSearch and searches them, so
+// datasets larger than memory can be queried. It talks to the REST API over HTTP and needs no
+// client library; both servers accept the small subset of the API it uses.
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	requestTimeout = 5 * time.Minute // Upper bound for one request, such as a bulk batch
+	maxErrorBody   = 64 << 10        // Bytes of an error response read for its reason
+)
+
+var (
+	// ErrNotFound is returned when the index of a dataset doesn't exist.
+	ErrNotFound = errors.New("index not found")
+
+	errBadURL = errors.New("search backend URL must be http:// or https://")
+)
+
+// responseError is an error answer of the server.
+type responseError struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+// Error describes the failure as the server reported it.
+func (e *responseError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("search backend answered %d", e.Status)
+	}
+
+	return fmt.Sprintf("search backend answered %d: %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// Client indexes and searches the connections of datasets, one index per dataset named
+// after its file ID. It is safe for concurrent use.
+type Client struct {
+	base     *url.URL
+	prefix   string // Prepended to the file ID to name the index of a dataset
+	username string
+	password string
+	http     *http.Client
+	version  string // Distribution and version the server reported
+}
+
+// New connects to the server at rawURL, with credentials in the URL if it needs them, and
+// names indexes prefix followed by the file ID.
+func New(ctx context.Context, rawURL, prefix string) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(rawURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("%w, got %q", errBadURL, rawURL)
+	}
+
+	client := &Client{base: base, prefix: strings.ToLower(prefix), http: &http.Client{Timeout: requestTimeout}}
+	if base.User != nil {
+		client.username = base.User.Username()
+		client.password, _ = base.User.Password()
+		base.User = nil
+	}
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"` // Only set by OpenSearch
+		} `json:"version"`
+	}
+	err = client.do(ctx, http.MethodGet, "/", nil, &info)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to search backend: %w", err)
+	}
+	client.version = "Elasticsearch " + info.Version.Number
+	if info.Version.Distribution == "opensearch" {
+		client.version = "OpenSearch " + info.Version.Number
+	}
+
+	return client, nil
+}
+
+// Version returns the distribution and version of the server, such as "Elasticsearch 8.13.0".
+func (c *Client) Version() string {
+	return c.version
+}
+
+// URL returns the address of the server, without credentials.
+func (c *Client) URL() string {
+	return c.base.String()
+}
+
+// index returns the name of the index of a dataset.
+func (c *Client) index(fileID string) string {
+	return c.prefix + strings.ToLower(fileID)
+}
+
+// do sends a request with a JSON body, or NDJSON for bulk requests, and decodes the JSON
+// answer into out, if given. Answers of 404 return ErrNotFound.
+func (c *Client) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	contentType := "application/json"
+	switch body := body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		reader, contentType = body, "application/x-ndjson"
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.base.JoinPath(path).String(), reader)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if reader != nil {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		return readError(response)
+	}
+	if out == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(response.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("decoding %s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// readError reads the reason of an error answer.
+func readError(response *http.Response) error {
+	var answer struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(response.Body, maxErrorBody)).Decode(&answer)
+
+	return &responseError{Status: response.StatusCode, Type: answer.Error.Type, Reason: answer.Error.Reason}
+}
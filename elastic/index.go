@@ -0,0 +1,204 @@
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"zeek-viz/models"
+)
+
+const bulkBatch = 5000 // Documents sent per bulk request
+
+var errBulkFailed = errors.New("bulk indexing failed")
+
+// Document is a connection as it is indexed, with fields derived from it for the filters that
+// the server can't evaluate on the logged values. The connection's fields are stored as
+// logged; the derived ones start with zv_.
+type Document struct {
+	*models.Connection
+
+	Position  int      `json:"zv_pos"`                  // Place in the dataset, for answers in dataset order
+	OrigIP    string   `json:"zv_orig_ip,omitempty"`    // Originator address, for prefix queries
+	RespIP    string   `json:"zv_resp_ip,omitempty"`    // Responder address, for prefix queries
+	IPVersion int      `json:"zv_ip_version,omitempty"` // 4 or 6, 0 when neither host is an address
+	Services  []string `json:"zv_services,omitempty"`   // Lower-case services Zeek found
+	Guesses   []string `json:"zv_guesses,omitempty"`    // Lower-case services the port suggests
+	Noise     bool     `json:"zv_noise,omitempty"`      // Either host is a broadcast, multicast, or link-local address
+}
+
+// Derived fields of a Document, for queries.
+const (
+	FieldPosition  = "zv_pos"
+	FieldOrigIP    = "zv_orig_ip"
+	FieldRespIP    = "zv_resp_ip"
+	FieldIPVersion = "zv_ip_version"
+	FieldServices  = "zv_services"
+	FieldGuesses   = "zv_guesses"
+	FieldNoise     = "zv_noise"
+)
+
+// IndexMeta describes the dataset an index was built from. It is kept in the index mapping.
+type IndexMeta struct {
+	Connections int   `json:"connections"`
+	StoredAt    int64 `json:"stored_at,omitempty"` //nolint:tagliatelle // Store version the index matches, 0 if unstored
+}
+
+// connectionMapping types the fields filters query. Others, such as the extra fields of a
+// connection, are kept in the document but not indexed.
+func connectionMapping(meta IndexMeta) map[string]any {
+	keyword := map[string]any{"type": "keyword"}
+	integer := map[string]any{"type": "integer"}
+	long := map[string]any{"type": "long"}
+	double := map[string]any{"type": "double"}
+	address := map[string]any{"type": "ip"}
+
+	return map[string]any{
+		"dynamic": false,
+		"_meta":   meta,
+		"properties": map[string]any{
+			"ts":  double,
+			"uid": keyword,
+			"id": map[string]any{"properties": map[string]any{
+				"orig_h": keyword, "orig_p": integer, "resp_h": keyword, "resp_p": integer,
+			}},
+			"proto":        keyword,
+			"service":      keyword,
+			"duration":     double,
+			"orig_bytes":   long,
+			"resp_bytes":   long,
+			"conn_state":   keyword,
+			FieldPosition:  long,
+			FieldOrigIP:    address,
+			FieldRespIP:    address,
+			FieldIPVersion: map[string]any{"type": "byte"},
+			FieldServices:  keyword,
+			FieldGuesses:   keyword,
+			FieldNoise:     map[string]any{"type": "boolean"},
+		},
+	}
+}
+
+// Create makes an empty index for a dataset, replacing any index it had.
+func (c *Client) Create(ctx context.Context, fileID string, meta IndexMeta) error {
+	err := c.Delete(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"settings": map[string]any{"index": map[string]any{"refresh_interval": "-1"}}, // Refreshed once filled
+		"mappings": connectionMapping(meta),
+	}
+	err = c.do(ctx, http.MethodPut, "/"+c.index(fileID), body, nil)
+	if err != nil {
+		return fmt.Errorf("creating index of %s: %w", fileID, err)
+	}
+
+	return nil
+}
+
+// Index adds documents to the index of a dataset in bulk requests.
+func (c *Client) Index(ctx context.Context, fileID string, documents []Document) error {
+	var body bytes.Buffer
+	for start := 0; start < len(documents); start += bulkBatch {
+		body.Reset()
+		encoder := json.NewEncoder(&body)
+		for i := start; i < min(start+bulkBatch, len(documents)); i++ {
+			body.WriteString("{\"index\":{}}\n")
+			err := encoder.Encode(&documents[i])
+			if err != nil {
+				return fmt.Errorf("encoding connection: %w", err)
+			}
+		}
+
+		var answer bulkAnswer
+		err := c.do(ctx, http.MethodPost, "/"+c.index(fileID)+"/_bulk", &body, &answer)
+		if err != nil {
+			return fmt.Errorf("indexing %s: %w", fileID, err)
+		}
+		if err := answer.err(); err != nil {
+			return fmt.Errorf("indexing %s: %w", fileID, err)
+		}
+	}
+
+	return nil
+}
+
+// Refresh makes the documents indexed so far searchable.
+func (c *Client) Refresh(ctx context.Context, fileID string) error {
+	err := c.do(ctx, http.MethodPost, "/"+c.index(fileID)+"/_refresh", nil, nil)
+	if err != nil {
+		return fmt.Errorf("refreshing index of %s: %w", fileID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the index of a dataset. Deleting a missing index is not an error.
+func (c *Client) Delete(ctx context.Context, fileID string) error {
+	err := c.do(ctx, http.MethodDelete, "/"+c.index(fileID), nil, nil)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("deleting index of %s: %w", fileID, err)
+	}
+
+	return nil
+}
+
+// Meta returns the description of the dataset the index was built from, or ErrNotFound.
+func (c *Client) Meta(ctx context.Context, fileID string) (IndexMeta, error) {
+	var answer map[string]struct {
+		Mappings struct {
+			Meta IndexMeta `json:"_meta"`
+		} `json:"mappings"`
+	}
+	err := c.do(ctx, http.MethodGet, "/"+c.index(fileID)+"/_mapping", nil, &answer)
+	if err != nil {
+		return IndexMeta{}, err
+	}
+	for _, index := range answer {
+		return index.Mappings.Meta, nil
+	}
+
+	return IndexMeta{}, ErrNotFound
+}
+
+// bulkAnswer is the answer to a bulk request.
+type bulkAnswer struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// err returns the first failure of a bulk request, if a document was rejected.
+func (b *bulkAnswer) err() error {
+	if !b.Errors {
+		return nil
+	}
+	failed := 0
+	var first error
+	for _, item := range b.Items {
+		for _, result := range item {
+			if result.Status < http.StatusBadRequest {
+				continue
+			}
+			failed++
+			if first == nil {
+				first = &responseError{Status: result.Status, Type: result.Error.Type, Reason: result.Error.Reason}
+			}
+		}
+	}
+	if first == nil {
+		return errBulkFailed
+	}
+
+	return fmt.Errorf("%w: %d documents rejected: %w", errBulkFailed, failed, first)
+}
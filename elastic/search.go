@@ -0,0 +1,216 @@
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"zeek-viz/models"
+)
+
+const maxWindow = 10000 // Hits one search may page through with from and size (index.max_result_window)
+
+// Query is a query of the search DSL. The nil Query matches every document.
+type Query map[string]any
+
+// Term matches documents whose field is value, or for address fields lies in the CIDR prefix
+// value.
+func Term(field string, value any) Query {
+	return Query{"term": map[string]any{field: value}}
+}
+
+// Terms matches documents whose field is one of the values.
+func Terms(field string, values []string) Query {
+	return Query{"terms": map[string]any{field: values}}
+}
+
+// Exists matches documents that have a value for field.
+func Exists(field string) Query {
+	return Query{"exists": map[string]any{"field": field}}
+}
+
+// Range matches documents whose field is at least gte and below lt. A nil bound is open.
+func Range(field string, gte, lt any) Query {
+	bounds := map[string]any{}
+	if gte != nil {
+		bounds["gte"] = gte
+	}
+	if lt != nil {
+		bounds["lt"] = lt
+	}
+
+	return Query{"range": map[string]any{field: bounds}}
+}
+
+// All matches documents every query matches. Nil queries are left out.
+func All(queries ...Query) Query {
+	filters := make([]Query, 0, len(queries))
+	for _, query := range queries {
+		if query != nil {
+			filters = append(filters, query)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+
+	return Query{"bool": map[string]any{"filter": filters}}
+}
+
+// Any matches documents one of the queries matches.
+func Any(queries ...Query) Query {
+	return Query{"bool": map[string]any{"should": queries, "minimum_should_match": 1}}
+}
+
+// Not matches documents the query doesn't match.
+func Not(query Query) Query {
+	return Query{"bool": map[string]any{"must_not": []Query{query}}}
+}
+
+// body returns the query for a request body, matching everything when it is nil.
+func (q Query) body() Query {
+	if q == nil {
+		return Query{"match_all": map[string]any{}}
+	}
+
+	return q
+}
+
+// searchAnswer is the answer to a search request.
+type searchAnswer struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source json.RawMessage `json:"_source"`
+			Sort   []any           `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search returns the number of connections of a dataset that match the query, and limit of
+// them after the first offset, in dataset order. Pages beyond the server's result window are
+// reached by walking the hits before them.
+func (c *Client) Search(ctx context.Context, fileID string, query Query, offset, limit int) (int, []models.Connection, error) {
+	if offset+limit <= maxWindow {
+		answer, err := c.search(ctx, fileID, query, offset, limit, nil, true)
+		if err != nil {
+			return 0, nil, err
+		}
+		connections, err := answer.connections()
+
+		return answer.Hits.Total.Value, connections, err
+	}
+
+	total := -1
+	var after []any
+	for skip := offset; skip > 0; {
+		answer, err := c.search(ctx, fileID, query, 0, min(skip, maxWindow), after, false)
+		if err != nil {
+			return 0, nil, err
+		}
+		total = max(total, answer.Hits.Total.Value)
+		hits := answer.Hits.Hits
+		if len(hits) == 0 {
+			return total, []models.Connection{}, nil
+		}
+		after = hits[len(hits)-1].Sort
+		skip -= len(hits)
+	}
+
+	connections := []models.Connection{}
+	for len(connections) < limit {
+		answer, err := c.search(ctx, fileID, query, 0, min(limit-len(connections), maxWindow), after, true)
+		if err != nil {
+			return 0, nil, err
+		}
+		total = max(total, answer.Hits.Total.Value)
+		page, err := answer.connections()
+		if err != nil {
+			return 0, nil, err
+		}
+		connections = append(connections, page...)
+		if len(page) == 0 {
+			break
+		}
+		after = answer.Hits.Hits[len(answer.Hits.Hits)-1].Sort
+	}
+
+	return total, connections, nil
+}
+
+// Connections returns all connections of a dataset in dataset order, for reading an evicted
+// dataset back.
+func (c *Client) Connections(ctx context.Context, fileID string) ([]models.Connection, error) {
+	var connections []models.Connection
+	var after []any
+	for {
+		answer, err := c.search(ctx, fileID, nil, 0, maxWindow, after, true)
+		if err != nil {
+			return nil, err
+		}
+		page, err := answer.connections()
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, page...)
+		if len(page) < maxWindow {
+			return connections, nil
+		}
+		after = answer.Hits.Hits[len(answer.Hits.Hits)-1].Sort
+	}
+}
+
+// Count returns the number of connections of a dataset that match the query.
+func (c *Client) Count(ctx context.Context, fileID string, query Query) (int, error) {
+	var answer struct {
+		Count int `json:"count"`
+	}
+	err := c.do(ctx, http.MethodPost, "/"+c.index(fileID)+"/_count", map[string]any{"query": query.body()}, &answer)
+	if err != nil {
+		return 0, fmt.Errorf("counting connections of %s: %w", fileID, err)
+	}
+
+	return answer.Count, nil
+}
+
+// search runs one search request sorted by dataset position, from offset or after the sort
+// values of the last hit of the previous page. Documents are only returned with source.
+func (c *Client) search(ctx context.Context, fileID string, query Query, offset, size int, after []any, source bool) (*searchAnswer, error) {
+	body := map[string]any{
+		"query":            query.body(),
+		"sort":             []any{map[string]any{FieldPosition: "asc"}},
+		"size":             size,
+		"track_total_hits": true,
+		"_source":          source,
+	}
+	if offset > 0 {
+		body["from"] = offset
+	}
+	if after != nil {
+		body["search_after"] = after
+	}
+
+	answer := &searchAnswer{}
+	err := c.do(ctx, http.MethodPost, "/"+c.index(fileID)+"/_search", body, answer)
+	if err != nil {
+		return nil, fmt.Errorf("searching connections of %s: %w", fileID, err)
+	}
+
+	return answer, nil
+}
+
+// connections decodes the connections of the hits.
+func (a *searchAnswer) connections() ([]models.Connection, error) {
+	connections := make([]models.Connection, len(a.Hits.Hits))
+	for i, hit := range a.Hits.Hits {
+		err := json.Unmarshal(hit.Source, &connections[i])
+		if err != nil {
+			return nil, fmt.Errorf("decoding connection: %w", err)
+		}
+	}
+
+	return connections, nil
+}
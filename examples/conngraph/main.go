@@ -0,0 +1,73 @@
+// Command conngraph shows zeek-viz used as a library, without its server: it parses a
+// conn.log, keeps the connections matching a query expression, prints their statistics to
+// stderr, and writes their network graph to stdout as GraphML.
+//
+//	go run ./examples/conngraph conn.log 'proto == "tcp" and resp_bytes > 1000' > graph.graphml
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+	"zeek-viz/parse"
+	"zeek-viz/query"
+)
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 { //nolint:mnd // Program name, log, and expression
+		log.Fatal("usage: conngraph <conn.log> [expression]")
+	}
+
+	file, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	result, err := parse.Connections(context.Background(), file, parse.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if warning := result.Report.Warning(); warning != "" {
+		log.Print(warning)
+	}
+
+	connections := result.Connections
+	if len(os.Args) == 3 { //nolint:mnd // With an expression
+		expr, err := query.ParseExpr(os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		matches, err := query.CompileConnectionFilter(expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var kept []models.Connection
+		for i := range connections {
+			if matches(&connections[i]) {
+				kept = append(kept, connections[i])
+			}
+		}
+		connections = kept
+	}
+
+	stats := models.NewConnectionStats()
+	for i := range connections {
+		stats.Add(&connections[i])
+	}
+	fmt.Fprintf(os.Stderr, "%d connections between %d hosts, %d bytes over %.0f seconds\n",
+		stats.TotalConnections, stats.UniqueIPCount(), stats.TotalBytes, stats.Duration())
+	for proto, count := range stats.Protocols {
+		fmt.Fprintf(os.Stderr, "  %s: %d\n", proto, count)
+	}
+
+	graph := netgraph.Build(connections, models.DefaultLocalNetworks(), netgraph.ByProtocol)
+	err = models.WriteGraphML(os.Stdout, graph)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package geoip
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	asnTableType    = "ip2asn" // Database type reported for range tables
+	asnTableColumns = 5        // range_start, range_end, AS_number, country_code, AS_description
+	unknownCountry  = "None"   // Country code of ranges without a registration country
+)
+
+var errBadASNTable = errors.New("invalid ip2asn table")
+
+// asnRange is a range of addresses announced by one autonomous system.
+type asnRange struct {
+	start, end netip.Addr
+	asn        uint64
+	country    string
+	org        string
+}
+
+// asnTable looks up addresses in an offline table of the autonomous systems announcing address
+// ranges, in the tab-separated format of the ip2asn-v4, ip2asn-v6, and ip2asn-combined files
+// of https://iptoasn.com/, optionally gzip-compressed. Unrouted ranges (AS 0) are left out.
+type asnTable struct {
+	ranges []asnRange // Sorted by start, not overlapping
+}
+
+// isASNTable reports whether path names a range table rather than a MaxMind DB.
+func isASNTable(path string) bool {
+	return strings.HasSuffix(path, ".tsv") || strings.HasSuffix(path, ".tsv.gz")
+}
+
+// openASNTable reads the table at path.
+func openASNTable(path string) (*asnTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading ASN table: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		decompressed, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadASNTable, err)
+		}
+		defer decompressed.Close()
+		reader = decompressed
+	}
+
+	table := &asnTable{}
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseASNRange(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", errBadASNTable, lineNumber, err)
+		}
+		if entry.asn != 0 {
+			table.ranges = append(table.ranges, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading ASN table: %w", err)
+	}
+
+	sort.Slice(table.ranges, func(i, j int) bool {
+		return table.ranges[i].start.Less(table.ranges[j].start)
+	})
+
+	return table, nil
+}
+
+// parseASNRange parses one line of the table.
+func parseASNRange(line string) (asnRange, error) {
+	columns := strings.Split(line, "\t")
+	if len(columns) < asnTableColumns {
+		return asnRange{}, fmt.Errorf("%d columns, expected %d", len(columns), asnTableColumns)
+	}
+
+	start, err := netip.ParseAddr(columns[0])
+	if err != nil {
+		return asnRange{}, err //nolint:wrapcheck // Wrapped with the line by openASNTable
+	}
+	end, err := netip.ParseAddr(columns[1])
+	if err != nil {
+		return asnRange{}, err //nolint:wrapcheck // Wrapped with the line by openASNTable
+	}
+	asn, err := strconv.ParseUint(columns[2], 10, 32)
+	if err != nil {
+		return asnRange{}, err //nolint:wrapcheck // Wrapped with the line by openASNTable
+	}
+
+	entry := asnRange{start: start.Unmap(), end: end.Unmap(), asn: asn, org: columns[4]}
+	if columns[3] != unknownCountry {
+		entry.country = columns[3]
+	}
+
+	return entry, nil
+}
+
+// lookup returns a record of the range containing addr in the shape of a GeoLite2-ASN record,
+// or nil when no range does.
+func (t *asnTable) lookup(addr netip.Addr) (map[string]any, error) {
+	addr = addr.Unmap()
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return addr.Less(t.ranges[i].start)
+	}) - 1
+	if i < 0 || t.ranges[i].end.Less(addr) {
+		return nil, nil //nolint:nilnil // No range contains the address
+	}
+
+	entry := &t.ranges[i]
+	record := map[string]any{
+		"autonomous_system_number":       entry.asn,
+		"autonomous_system_organization": entry.org,
+	}
+	if entry.country != "" {
+		record["registered_country"] = map[string]any{"iso_code": entry.country}
+	}
+
+	return record, nil
+}
+
+// databaseType returns the type of the table.
+func (t *asnTable) databaseType() string {
+	return asnTableType
+}
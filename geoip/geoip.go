@@ -0,0 +1,125 @@
+// Package geoip looks up the country, city, and autonomous system of IP addresses in
+// MaxMind DB files such as GeoLite2-City, GeoLite2-Country, and GeoLite2-ASN, and in offline
+// ip2asn range tables.
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Location is what the databases know about an address. Fields a database doesn't carry
+// stay empty.
+type Location struct {
+	Country string // ISO 3166-1 alpha-2 code
+	City    string // English name
+	ASN     uint
+	ASOrg   string // Organization the autonomous system is registered to
+}
+
+// DB answers lookups from one or more databases, so a City and an ASN database can be
+// combined. It is safe for concurrent use.
+type DB struct {
+	readers []source
+	types   []string
+}
+
+// source is a database addresses are looked up in.
+type source interface {
+	lookup(addr netip.Addr) (map[string]any, error)
+	databaseType() string
+}
+
+// Open loads the databases at paths: MaxMind DB files, or ip2asn tables (.tsv or .tsv.gz).
+func Open(paths []string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		var r source
+		var err error
+		if isASNTable(path) {
+			r, err = openASNTable(path)
+		} else {
+			r, err = openReader(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		db.readers = append(db.readers, r)
+		db.types = append(db.types, r.databaseType())
+	}
+
+	return db, nil
+}
+
+// Types returns the database types, such as GeoLite2-City, in the order they were opened.
+func (db *DB) Types() []string {
+	return db.types
+}
+
+// Lookup returns the location of host, an IP address. Later databases only fill in fields
+// the earlier ones left empty. ok is false when no database knows the address.
+func (db *DB) Lookup(host string) (Location, bool) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return Location{}, false
+	}
+
+	var location Location
+	found := false
+	for _, r := range db.readers {
+		record, err := r.lookup(addr.WithZone(""))
+		if err != nil || record == nil {
+			continue
+		}
+		found = true
+		location.merge(record)
+	}
+
+	return location, found
+}
+
+// merge fills the empty fields of the location from a database record.
+func (l *Location) merge(record map[string]any) {
+	country := field(record, "country")
+	if country == nil {
+		country = field(record, "registered_country")
+	}
+	if l.Country == "" {
+		l.Country, _ = country["iso_code"].(string)
+	}
+	if l.City == "" {
+		l.City = englishName(field(record, "city"))
+	}
+	if asn, ok := record["autonomous_system_number"].(uint64); ok && l.ASN == 0 {
+		l.ASN = uint(asn)
+	}
+	if l.ASOrg == "" {
+		l.ASOrg, _ = record["autonomous_system_organization"].(string)
+	}
+}
+
+// Matches reports whether the location is in one of the countries, given as ISO codes.
+func (l Location) Matches(countries []string) bool {
+	for _, country := range countries {
+		if l.Country != "" && strings.EqualFold(l.Country, country) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// field returns a nested map of a record.
+func field(record map[string]any, name string) map[string]any {
+	value, _ := record[name].(map[string]any)
+
+	return value
+}
+
+// englishName returns the English name in the names map of a record.
+func englishName(record map[string]any) string {
+	name, _ := field(record, "names")["en"].(string)
+
+	return name
+}
@@ -0,0 +1,335 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+const (
+	metadataSearchSize = 128 << 10 // Metadata sits within the last 128 KiB of a database
+	dataSeparatorSize  = 16        // Zero bytes between the search tree and the data section
+	maxDecodeDepth     = 32        // Nesting allowed in decoded values, against malformed files
+	ipv4InIPv6Bits     = 96        // Zero bits before an IPv4 address in an IPv6 tree
+
+	metadataMarker = "\xab\xcd\xefMaxMind.com" // Precedes the metadata map
+)
+
+// Types of the MaxMind DB data section.
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBoolean  = 14
+	typeFloat    = 15
+)
+
+var (
+	errNoMetadata  = errors.New("no MaxMind DB metadata found")
+	errBadMetadata = errors.New("invalid MaxMind DB metadata")
+	errCorrupt     = errors.New("corrupt MaxMind DB data")
+)
+
+// metadata describes the layout of a MaxMind DB file.
+type metadata struct {
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+}
+
+// reader looks up addresses in a MaxMind DB (.mmdb) file, as documented at
+// https://maxmind.github.io/MaxMind-DB/. The whole file is kept in memory.
+type reader struct {
+	data      []byte
+	meta      metadata
+	treeSize  uint
+	ipv4Start uint // Node an IPv4 lookup starts at
+}
+
+// openReader reads the database at path.
+func openReader(path string) (*reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading GeoIP database: %w", err)
+	}
+
+	searchFrom := max(0, len(data)-metadataSearchSize)
+	start := bytes.LastIndex(data[searchFrom:], []byte(metadataMarker))
+	if start < 0 {
+		return nil, errNoMetadata
+	}
+	start += searchFrom + len(metadataMarker)
+
+	decoder := &decoder{data: data[start:]}
+	value, _, err := decoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadMetadata, err)
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return nil, errBadMetadata
+	}
+
+	r := &reader{data: data}
+	r.meta.nodeCount, _ = asUint(fields["node_count"])
+	r.meta.recordSize, _ = asUint(fields["record_size"])
+	r.meta.ipVersion, _ = asUint(fields["ip_version"])
+	r.meta.databaseType, _ = fields["database_type"].(string)
+	if r.meta.recordSize != 24 && r.meta.recordSize != 28 && r.meta.recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", errBadMetadata, r.meta.recordSize)
+	}
+
+	r.treeSize = r.meta.recordSize * 2 / 8 * r.meta.nodeCount //nolint:mnd // Two records per node, in bytes
+	if r.treeSize+dataSeparatorSize > uint(start) {
+		return nil, fmt.Errorf("%w: search tree exceeds the file", errBadMetadata)
+	}
+
+	if r.meta.ipVersion == 6 { //nolint:mnd // IPv6 tree
+		for i := 0; i < ipv4InIPv6Bits && r.ipv4Start < r.meta.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// lookup returns the record of the network containing addr, or nil when there is none.
+func (r *reader) lookup(addr netip.Addr) (map[string]any, error) {
+	addr = addr.Unmap()
+	node := uint(0)
+	if addr.Is4() {
+		if r.meta.ipVersion == 6 { //nolint:mnd // IPv6 tree
+			node = r.ipv4Start
+		}
+	} else if r.meta.ipVersion != 6 { //nolint:mnd // IPv6 tree
+		return nil, nil //nolint:nilnil // An IPv4 database knows no IPv6 addresses
+	}
+
+	address := addr.AsSlice()
+	for i := 0; i < len(address)*8 && node < r.meta.nodeCount; i++ {
+		bit := uint(address[i/8]>>(7-i%8)) & 1 //nolint:mnd // Most significant bit first
+		node = r.record(node, bit)
+	}
+	if node <= r.meta.nodeCount {
+		return nil, nil //nolint:nilnil // No data for the address
+	}
+
+	offset := node - r.meta.nodeCount - dataSeparatorSize
+	decoder := &decoder{data: r.data[min(r.treeSize+dataSeparatorSize, uint(len(r.data))):]}
+	value, _, err := decoder.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := value.(map[string]any)
+
+	return fields, nil
+}
+
+// databaseType returns the type of the database, such as GeoLite2-City.
+func (r *reader) databaseType() string {
+	return r.meta.databaseType
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a search tree node.
+func (r *reader) record(node, bit uint) uint {
+	size := r.meta.recordSize * 2 / 8 //nolint:mnd // Bytes per node
+	b := r.data[node*size : (node+1)*size]
+
+	switch r.meta.recordSize {
+	case 24: //nolint:mnd // Record size in bits
+		b = b[bit*3 : bit*3+3]
+
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28: //nolint:mnd // Record size in bits
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4 : bit*4+4]))
+	}
+}
+
+// decoder reads values from a MaxMind DB data section; pointers are offsets into data.
+type decoder struct {
+	data []byte
+}
+
+// decode returns the value at offset and the offset after it.
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("%w: values nested too deeply", errCorrupt)
+	}
+
+	kind, size, offset, err := d.header(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if kind == typePointer {
+		value, _, err := d.decode(size, depth+1)
+
+		return value, offset, err
+	}
+
+	switch kind {
+	case typeBoolean:
+		return size != 0, offset, nil // The size is the value
+	case typeMap:
+		fields := make(map[string]any, min(size, uint(len(d.data))))
+		for range size {
+			var key, value any
+			key, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", errCorrupt)
+			}
+			fields[name] = value
+		}
+
+		return fields, offset, nil
+	case typeArray:
+		values := make([]any, 0, min(size, uint(len(d.data))))
+		for range size {
+			var value any
+			value, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+		}
+
+		return values, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, fmt.Errorf("%w: value exceeds the data section", errCorrupt)
+	}
+	value, err := scalar(kind, d.data[offset:offset+size])
+
+	return value, offset + size, err
+}
+
+// header reads the control byte(s) at offset and returns the type, the size (or pointer
+// target), and the offset of the payload.
+func (d *decoder) header(offset uint) (int, uint, uint, error) {
+	next := func() (uint, error) {
+		if offset >= uint(len(d.data)) {
+			return 0, fmt.Errorf("%w: truncated value", errCorrupt)
+		}
+		offset++
+
+		return uint(d.data[offset-1]), nil
+	}
+
+	control, err := next()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	kind := int(control >> 5) //nolint:mnd // Top three bits
+	if kind == typePointer {
+		length := (control>>3)&0x3 + 1 //nolint:mnd // Pointer size bits
+		target := uint(0)
+		if length < 4 { //nolint:mnd // Four-byte pointers ignore the control byte's value bits
+			target = control & 0x7 //nolint:mnd // Value bits
+		}
+		for range length {
+			b, err := next()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			target = target<<8 | b
+		}
+
+		return typePointer, target + [...]uint{0, 2048, 526336, 0}[length-1], offset, nil
+	}
+	if kind == typeExtended {
+		extended, err := next()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		kind = int(extended) + 7 //nolint:mnd // Extended types start after the seven basic ones
+	}
+
+	size := control & 0x1f //nolint:mnd // Low five bits
+	if size >= 29 {        //nolint:mnd // Sizes of 29 and more continue in the next bytes
+		extra := size - 28 //nolint:mnd // One to three more bytes
+		size = 0
+		for range extra {
+			b, err := next()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			size = size<<8 | b
+		}
+		size += [...]uint{29, 285, 65821}[extra-1]
+	}
+
+	return kind, size, offset, nil
+}
+
+// scalar decodes a value that is not a map, array, or pointer.
+func scalar(kind int, b []byte) (any, error) {
+	switch kind {
+	case typeString:
+		return string(b), nil
+	case typeBytes:
+		return bytes.Clone(b), nil
+	case typeDouble:
+		if len(b) != 8 { //nolint:mnd // 64-bit float
+			return nil, fmt.Errorf("%w: double of %d bytes", errCorrupt, len(b))
+		}
+
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case typeFloat:
+		if len(b) != 4 { //nolint:mnd // 32-bit float
+			return nil, fmt.Errorf("%w: float of %d bytes", errCorrupt, len(b))
+		}
+
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case typeUint16, typeUint32, typeUint64, typeUint128:
+		var value uint64
+		for _, c := range b[max(0, len(b)-8):] { //nolint:mnd // Only the low 64 bits of uint128 are kept
+			value = value<<8 | uint64(c)
+		}
+
+		return value, nil
+	case typeInt32:
+		var value uint32
+		for _, c := range b {
+			value = value<<8 | uint32(c)
+		}
+
+		return int64(int32(value)), nil //nolint:gosec // Two's complement reinterpretation
+	default:
+		return nil, nil //nolint:nilnil // Containers and end markers carry no lookup data
+	}
+}
+
+// asUint returns an unsigned integer value.
+func asUint(value any) (uint, bool) {
+	number, ok := value.(uint64)
+
+	return uint(number), ok
+}
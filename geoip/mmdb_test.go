@@ -0,0 +1,119 @@
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testDatabases are the MaxMind DB files of testdata.
+var testDatabases = []string{filepath.Join("testdata", "test-city.mmdb"), filepath.Join("testdata", "test-asn.mmdb")}
+
+func TestLookup(t *testing.T) {
+	db, err := Open(testDatabases)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if types := db.Types(); !reflect.DeepEqual(types, []string{"Test-City", "Test-ASN"}) {
+		t.Errorf("types %v", types)
+	}
+
+	tests := []struct {
+		host  string
+		want  Location
+		found bool
+	}{
+		{"81.2.69.142", Location{Country: "GB", City: "London", ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}, true},
+		{"81.2.69.192", Location{Country: "GB", City: "Cambridge", ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}, true},
+		{"::ffff:81.2.69.191", Location{Country: "GB", City: "London", ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}, true},
+		{"89.160.20.120", Location{Country: "SE", City: "Linköping"}, true},
+		{"216.160.83.63", Location{Country: "US"}, true}, // Only a registered country
+		{"192.0.2.1", Location{ASN: 4200000001, ASOrg: "Test Autonomous System Organization with a long name"}, true},
+		{"2001:db8:1::1", Location{Country: "FR", City: "Paris"}, true},
+		{"2001:db8:1:ffff:ffff:ffff:ffff:ffff", Location{Country: "FR", City: "Paris"}, true},
+		{"fe80::1%eth0", Location{}, false},
+		{"81.2.69.127", Location{ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}, true}, // Just before London
+		{"81.2.128.1", Location{}, false},
+		{"10.0.0.1", Location{}, false},
+		{"2001:db8:2::1", Location{}, false},
+		{"not an address", Location{}, false},
+	}
+	for _, test := range tests {
+		location, found := db.Lookup(test.host)
+		if location != test.want || found != test.found {
+			t.Errorf("Lookup(%s) = %+v, %t; want %+v, %t", test.host, location, found, test.want, test.found)
+		}
+	}
+}
+
+func TestReaderRecords(t *testing.T) {
+	city, err := openReader(testDatabases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := city.lookup(netip.MustParseAddr("81.2.69.160"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"city": map[string]any{"geoname_id": uint64(2643743), "names": map[string]any{"de": "London", "en": "London"}},
+		"country": map[string]any{ // Behind a pointer, shared with Cambridge
+			"geoname_id": uint64(2635167), "iso_code": "GB",
+			"names": map[string]any{"de": "Vereinigtes Königreich", "en": "United Kingdom"},
+		},
+		"location": map[string]any{"accuracy_radius": uint64(10), "latitude": 51.5142, "longitude": -0.0931},
+		"subdivisions": []any{
+			map[string]any{"iso_code": "ENG", "names": map[string]any{"de": "England", "en": "England"}},
+		},
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("London record:\n got %v\nwant %v", record, want)
+	}
+
+	record, err = city.lookup(netip.MustParseAddr("89.160.20.113"))
+	if err != nil || !reflect.DeepEqual(record["traits"], map[string]any{"is_anycast": false}) {
+		t.Errorf("Linköping traits: %v, %v", record["traits"], err)
+	}
+
+	asn, err := openReader(testDatabases[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = asn.lookup(netip.MustParseAddr("2001:db8:1::1"))
+	if record != nil || err != nil {
+		t.Errorf("IPv6 lookup in an IPv4 database: %v, %v", record, err)
+	}
+}
+
+func TestOpenRejectsDamage(t *testing.T) {
+	content, err := os.ReadFile(testDatabases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, content, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	_, err = openReader(write("cut.mmdb", content[:len(content)/2]))
+	if !errors.Is(err, errNoMetadata) {
+		t.Errorf("database cut in half: %v, want %v", err, errNoMetadata)
+	}
+	_, err = openReader(write("metadata.mmdb", content[:len(content)-8]))
+	if !errors.Is(err, errBadMetadata) {
+		t.Errorf("database with cut metadata: %v, want %v", err, errBadMetadata)
+	}
+	_, err = Open([]string{write("missing.mmdb", nil), testDatabases[1]})
+	if err == nil {
+		t.Error("Open accepted an empty database")
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+)
+
+const (
+	defaultAggregateLimit = 50 // Default number of groups returned
+	maxGroupByFields      = 2  // Maximum number of group-by fields
+	countMetric           = "count"
+	groupKeySeparator     = "\x00"
+)
+
+var (
+	errGroupByRequired = errors.New("group_by parameter is required")
+	errTooManyGroupBy  = errors.New("too many group_by fields")
+	errUnknownField    = errors.New("unknown field")
+)
+
+// aggregateGroup holds the key and accumulated metrics of one group.
+type aggregateGroup struct {
+	Key     map[string]string  `json:"key"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// GetAggregate performs a one- or two-field group-by over the filtered connections.
+func (a *API) GetAggregate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	groupBy := connfilter.SplitList(query.Get("group_by"))
+	metrics := connfilter.SplitList(query.Get("metrics"))
+	if len(metrics) == 0 {
+		metrics = []string{countMetric}
+	}
+
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultAggregateLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	groups, err := aggregateConnections(connections, groupBy, metrics)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	response := map[string]any{
+		"group_by":     groupBy,
+		"metrics":      metrics,
+		"total_groups": len(groups),
+		"truncated":    len(groups) > limit,
+		"limits":       map[string]int{"limit": limit},
+	}
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+	response["groups"] = groups
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode aggregation: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// aggregateConnections groups connections by the given fields and accumulates the metrics.
+// Groups are sorted by the first metric in descending order.
+func aggregateConnections(connections []models.Connection, groupBy, metrics []string) ([]aggregateGroup, error) {
+	if len(groupBy) == 0 {
+		return nil, errGroupByRequired
+	}
+	if len(groupBy) > maxGroupByFields {
+		return nil, fmt.Errorf("%w: at most %d allowed", errTooManyGroupBy, maxGroupByFields)
+	}
+
+	keyAccessors := make([]models.StringAccessor, len(groupBy))
+	for i, field := range groupBy {
+		accessor, ok := models.StringFieldAccessor(field)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknownField, field)
+		}
+		keyAccessors[i] = accessor
+	}
+
+	metricAccessors, err := resolveMetrics(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	groupMap := make(map[string]*aggregateGroup)
+	order := make([]string, 0)
+	keyParts := make([]string, len(groupBy))
+
+	for i := range connections {
+		conn := &connections[i]
+		for j, accessor := range keyAccessors {
+			keyParts[j] = accessor(conn)
+		}
+		key := strings.Join(keyParts, groupKeySeparator)
+
+		group, exists := groupMap[key]
+		if !exists {
+			group = &aggregateGroup{
+				Key:     make(map[string]string, len(groupBy)),
+				Metrics: make(map[string]float64, len(metrics)),
+			}
+			for j, field := range groupBy {
+				group.Key[field] = keyParts[j]
+			}
+			groupMap[key] = group
+			order = append(order, key)
+		}
+
+		for j, metric := range metrics {
+			group.Metrics[metric] += metricAccessors[j](conn)
+		}
+	}
+
+	groups := make([]aggregateGroup, 0, len(order))
+	for _, key := range order {
+		groups = append(groups, *groupMap[key])
+	}
+
+	primary := metrics[0]
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Metrics[primary] > groups[j].Metrics[primary]
+	})
+
+	return groups, nil
+}
+
+// resolveMetrics maps metric names to accessors; "count" counts connections, any other
+// metric sums the numeric field of the same name.
+func resolveMetrics(metrics []string) ([]models.NumericAccessor, error) {
+	accessors := make([]models.NumericAccessor, len(metrics))
+	for i, metric := range metrics {
+		if metric == countMetric {
+			accessors[i] = func(*models.Connection) float64 { return 1 }
+
+			continue
+		}
+
+		accessor, ok := models.NumericFieldAccessor(metric)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknownField, metric)
+		}
+		accessors[i] = accessor
+	}
+
+	return accessors, nil
+}
@@ -0,0 +1,627 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const (
+	alertInterval      = time.Minute      // How often the alerter looks for new findings
+	alertTimeout       = 10 * time.Second // Time a webhook may take to answer one attempt
+	alertAttempts      = 3                // Attempts at delivering a dispatch
+	alertRetryDelay    = 2 * time.Second  // Delay before the second attempt, doubled for each further one
+	maxAlertFindings   = 50               // Findings one dispatch carries, most severe first
+	maxAlertHistory    = 200              // Dispatches /api/alerts keeps
+	defaultAlertsLimit = 50               // Dispatches /api/alerts returns by default
+	alertResponseTail  = 256              // Bytes of a failed webhook's response a dispatch records
+
+	alertFormatGeneric = "generic" // JSON body listing the findings
+	alertFormatSlack   = "slack"   // Slack incoming webhook message
+	alertFormatTeams   = "teams"   // Microsoft Teams connector card
+
+	alertSent   = "sent"   // Dispatch the webhook accepted
+	alertFailed = "failed" // Dispatch every attempt at failed
+
+	severityLow      = "low"
+	severityMedium   = "medium"
+	severityHigh     = "high"
+	severityCritical = "critical"
+
+	scanHighTargets   = 100       // Targets in one window from which a scan is high severity
+	beaconHighScore   = 0.9       // Beacon score from which a beacon is high severity
+	beaconMediumScore = 0.75      // Beacon score from which a beacon is medium severity
+	exfilHighBytes    = 100 << 20 // Outbound bytes from which an upload is high severity
+	exfilMediumBytes  = 10 << 20  // Outbound bytes from which an upload is medium severity
+)
+
+var (
+	errAlertWebhook    = errors.New("alert webhook must be an http or https URL")
+	errAlertFormat     = errors.New("alert format must be generic, slack, or teams")
+	errAlertSeverity   = errors.New("alert severity must be low, medium, high, or critical")
+	errAlertsDisabled  = errors.New("alerting is not configured; start the server with --alert-webhook")
+	errAlertStatusCode = errors.New("webhook answered")
+)
+
+// AlertFinding is a finding of the analyses as alerts report it.
+type AlertFinding struct {
+	Rule     string `json:"rule"` // scan, beacon, or exfil
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Src      string `json:"src,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Proto    string `json:"proto,omitempty"`
+	Detail   any    `json:"detail,omitempty"` // The finding as its analysis endpoint returns it
+}
+
+// AlertDispatch is one delivery of findings to the webhook, as /api/alerts lists it.
+type AlertDispatch struct {
+	ID         int            `json:"id"`
+	Time       int64          `json:"time"`              // Unix seconds of the last attempt
+	FileID     string         `json:"file_id,omitempty"` //nolint:tagliatelle // API consistency
+	Dataset    string         `json:"dataset,omitempty"`
+	Findings   []AlertFinding `json:"findings"`
+	Omitted    int            `json:"omitted,omitempty"`     // New findings beyond maxAlertFindings, left out of the dispatch
+	Status     string         `json:"status"`                // sent or failed
+	StatusCode int            `json:"status_code,omitempty"` //nolint:tagliatelle // API consistency
+	Attempts   int            `json:"attempts"`
+	Error      string         `json:"error,omitempty"`
+	Test       bool           `json:"test,omitempty"` // Sent by POST /api/alerts/test
+}
+
+// alerter posts new findings to a webhook and keeps the history of its dispatches. Its
+// mutex is independent of the API's, so webhooks are called without holding a.mu.
+type alerter struct {
+	webhook     *url.URL
+	format      string
+	minSeverity string
+	client      *http.Client
+
+	mu      sync.Mutex
+	checked map[string]alertCheck // Dataset state last evaluated, by file ID
+	known   map[string]bool       // Findings already seen, by file ID, rule, and identity
+	history []AlertDispatch       // Newest last, up to maxAlertHistory
+	nextID  int
+	sent    int
+	failed  int
+}
+
+// alertCheck is the state of a dataset when the alerter last evaluated it.
+type alertCheck struct {
+	version     int64
+	connections int
+}
+
+// alertBatch is the findings of one dataset evaluation.
+type alertBatch struct {
+	fileID   string
+	dataset  string
+	findings []AlertFinding
+}
+
+// SetAlerts posts findings of the scan, beacon, and exfil analyses of at least minSeverity
+// (default high) to webhook. format is generic, slack, or teams; empty guesses it from the
+// webhook's host. An empty webhook disables alerting.
+func (a *API) SetAlerts(webhook, format, minSeverity string) error {
+	if webhook == "" {
+		a.alerts = nil
+
+		return nil
+	}
+
+	target, err := url.Parse(webhook)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errAlertWebhook
+	}
+	if format == "" {
+		format = guessAlertFormat(target.Hostname())
+	}
+	if !slices.Contains([]string{alertFormatGeneric, alertFormatSlack, alertFormatTeams}, format) {
+		return errAlertFormat
+	}
+	minSeverity = cmp.Or(minSeverity, severityHigh)
+	if severityRank(minSeverity) == 0 {
+		return errAlertSeverity
+	}
+
+	a.alerts = &alerter{
+		webhook:     target,
+		format:      format,
+		minSeverity: minSeverity,
+		client:      &http.Client{Timeout: alertTimeout},
+		checked:     make(map[string]alertCheck),
+		known:       make(map[string]bool),
+	}
+
+	return nil
+}
+
+// StartAlerts runs the alerter when a webhook is set: every alertInterval until ctx is done,
+// it evaluates the datasets that were added or grew since it last did and posts their new
+// findings. Findings of the datasets loaded when it starts are known already and not posted.
+func (a *API) StartAlerts(ctx context.Context) {
+	if a.alerts == nil {
+		return
+	}
+	log.Printf("Posting %s and more severe findings to %s webhook at %s",
+		a.alerts.minSeverity, a.alerts.format, a.alerts.webhook.Host)
+
+	go func() {
+		a.checkAlerts(ctx, true)
+
+		ticker := time.NewTicker(alertInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			a.checkAlerts(ctx, false)
+		}
+	}()
+}
+
+// checkAlerts evaluates the datasets that changed since they were last evaluated and posts
+// their new findings, or, for a baseline, only records them as known.
+func (a *API) checkAlerts(ctx context.Context, baseline bool) {
+	batches := a.newFindings()
+	if baseline {
+		known := 0
+		for _, batch := range batches {
+			known += len(batch.findings)
+		}
+		log.Printf("Alerting baseline: %d findings of %d datasets known already", known, len(batches))
+
+		return
+	}
+
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			return
+		}
+		dispatch := AlertDispatch{FileID: batch.fileID, Dataset: batch.dataset, Findings: batch.findings}
+		if len(dispatch.Findings) > maxAlertFindings {
+			dispatch.Omitted = len(dispatch.Findings) - maxAlertFindings
+			dispatch.Findings = dispatch.Findings[:maxAlertFindings]
+		}
+		a.dispatchAlert(ctx, dispatch)
+	}
+}
+
+// newFindings evaluates the loaded datasets whose connections changed since the last
+// evaluation and returns their findings of at least the minimum severity that weren't seen
+// before, most severe first, marking them as seen.
+func (a *API) newFindings() []alertBatch {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	alerts := a.alerts
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+
+	for fileID := range alerts.checked {
+		if a.files[fileID] == nil {
+			delete(alerts.checked, fileID)
+		}
+	}
+	for key := range alerts.known {
+		if fileID, _, _ := strings.Cut(key, "|"); a.files[fileID] == nil {
+			delete(alerts.known, key)
+		}
+	}
+
+	fileIDs := make([]string, 0, len(a.files))
+	for fileID := range a.files {
+		fileIDs = append(fileIDs, fileID)
+	}
+	slices.Sort(fileIDs)
+
+	var batches []alertBatch
+	for _, fileID := range fileIDs {
+		fileData := a.files[fileID]
+		if fileData.unloaded != nil || fileData.Stats == nil {
+			continue // Evaluated once reloaded; evicting and reloading keeps the version
+		}
+		check := alertCheck{version: fileData.version, connections: fileData.Stats.TotalConnections}
+		if alerts.checked[fileID] == check {
+			continue
+		}
+		alerts.checked[fileID] = check
+
+		batch := alertBatch{fileID: fileID, dataset: fileData.Filename}
+		findings, keys := a.datasetFindings(fileData)
+		for i, finding := range findings {
+			key := fileID + "|" + keys[i]
+			if alerts.known[key] || severityRank(finding.Severity) < severityRank(alerts.minSeverity) {
+				continue
+			}
+			alerts.known[key] = true
+			batch.findings = append(batch.findings, finding)
+		}
+		if len(batch.findings) == 0 {
+			continue
+		}
+		slices.SortStableFunc(batch.findings, func(x, y AlertFinding) int {
+			return cmp.Compare(severityRank(y.Severity), severityRank(x.Severity))
+		})
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// datasetFindings returns the unsuppressed scans, beacons, and uploads of a dataset at the
+// default thresholds of their endpoints, with the identity of each. Callers must hold a.mu,
+// for reading.
+func (a *API) datasetFindings(fileData *FileData) ([]AlertFinding, []string) {
+	var findings []AlertFinding
+	var keys []string
+
+	for _, scan := range fileData.scans() {
+		if connectionSuppressed(a.suppressions, scanRule, scan.first) {
+			continue
+		}
+		scan.Threat = a.intel.matchConnection(scan.first)
+		finding := AlertFinding{Rule: scanRule, Src: scan.Src, Dst: scan.Dst, Port: scan.Port, Proto: scan.Proto, Detail: scan}
+		finding.Severity = scanSeverity(scan)
+		if scan.Type == "vertical" {
+			finding.Summary = fmt.Sprintf("%s scanned %d ports of %s", scan.Src, scan.Targets, scan.Dst)
+		} else {
+			finding.Summary = fmt.Sprintf("%s swept %d hosts on %s/%d", scan.Src, scan.Targets, scan.Proto, scan.Port)
+		}
+		findings = append(findings, finding)
+		keys = append(keys, strings.Join([]string{scanRule, scan.Type, scan.Src, scan.Dst, strconv.Itoa(scan.Port), scan.Proto}, "|"))
+	}
+
+	for _, tuple := range beaconTuples(fileData.Connections, defaultBeaconMinConns) {
+		beacon := tuple.score()
+		if beacon.Interval < defaultBeaconInterval || connectionSuppressed(a.suppressions, beaconRule, tuple.first) {
+			continue
+		}
+		beacon.Threat = a.intel.matchConnection(tuple.first)
+		findings = append(findings, AlertFinding{
+			Rule:     beaconRule,
+			Severity: beaconSeverity(beacon),
+			Summary: fmt.Sprintf("%s connects to %s:%d/%s every %s (%d connections, score %.2f)",
+				beacon.Src, beacon.Dst, beacon.Port, beacon.Proto, connstats.HumanizeDuration(beacon.Interval), beacon.Connections, beacon.Score),
+			Src:    beacon.Src,
+			Dst:    beacon.Dst,
+			Port:   beacon.Port,
+			Proto:  beacon.Proto,
+			Detail: beacon,
+		})
+		keys = append(keys, strings.Join([]string{beaconRule, beacon.Src, beacon.Dst, strconv.Itoa(beacon.Port), beacon.Proto}, "|"))
+	}
+
+	for _, host := range exfilHosts(fileData.Connections, a.localNetworks, defaultExfilWindow) {
+		if host.OutboundBytes < defaultExfilMinBytes || host.Ratio < defaultExfilMinRatio {
+			continue
+		}
+		probe := models.Connection{OrigHost: host.Host, RespHost: host.largestDest}
+		if connectionSuppressed(a.suppressions, exfilRule, &probe) {
+			continue
+		}
+		for _, destination := range host.TopDestinations {
+			if host.Threat = a.intel.match(destination.Host); host.Threat != nil {
+				break
+			}
+		}
+		findings = append(findings, AlertFinding{
+			Rule:     exfilRule,
+			Severity: exfilSeverity(host),
+			Summary: fmt.Sprintf("%s sent %s to %d external hosts (%.1f times what it received)",
+				host.Host, connstats.HumanizeBytes(float64(host.OutboundBytes)), host.Destinations, host.Ratio),
+			Src:    host.Host,
+			Dst:    host.largestDest,
+			Detail: host,
+		})
+		keys = append(keys, exfilRule+"|"+host.Host)
+	}
+
+	return findings, keys
+}
+
+// scanSeverity rates a scan: critical when a party is a known indicator, high from
+// scanHighTargets targets in one window, and medium otherwise.
+func scanSeverity(scan Scan) string {
+	switch {
+	case scan.Threat != nil:
+		return severityCritical
+	case scan.Targets >= scanHighTargets:
+		return severityHigh
+	default:
+		return severityMedium
+	}
+}
+
+// beaconSeverity rates a beacon by its regularity, or critical when a party is a known
+// indicator.
+func beaconSeverity(beacon Beacon) string {
+	switch {
+	case beacon.Threat != nil:
+		return severityCritical
+	case beacon.Score >= beaconHighScore:
+		return severityHigh
+	case beacon.Score >= beaconMediumScore:
+		return severityMedium
+	default:
+		return severityLow
+	}
+}
+
+// exfilSeverity rates an upload by the bytes sent, or critical when a destination is a
+// known indicator.
+func exfilSeverity(host *ExfilHost) string {
+	switch {
+	case host.Threat != nil:
+		return severityCritical
+	case host.OutboundBytes >= exfilHighBytes:
+		return severityHigh
+	case host.OutboundBytes >= exfilMediumBytes:
+		return severityMedium
+	default:
+		return severityLow
+	}
+}
+
+// severityRank orders severities from 1 for low to 4 for critical, and 0 for unknown ones.
+func severityRank(severity string) int {
+	return slices.Index([]string{severityLow, severityMedium, severityHigh, severityCritical}, severity) + 1
+}
+
+// guessAlertFormat returns the webhook format of Slack's and Teams' webhook hosts, and
+// generic JSON for others.
+func guessAlertFormat(host string) string {
+	switch {
+	case host == "hooks.slack.com":
+		return alertFormatSlack
+	case strings.HasSuffix(host, ".webhook.office.com"), strings.HasSuffix(host, ".logic.azure.com"):
+		return alertFormatTeams
+	default:
+		return alertFormatGeneric
+	}
+}
+
+// dispatchAlert posts a dispatch to the webhook, retrying network errors, 5xx, and 429
+// answers, and records it in the history.
+func (a *API) dispatchAlert(ctx context.Context, dispatch AlertDispatch) AlertDispatch {
+	alerts := a.alerts
+	body, err := a.alertPayload(dispatch)
+	if err != nil {
+		dispatch.Error = err.Error()
+	}
+	for attempt := 1; body != nil && attempt <= alertAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(alertRetryDelay << (attempt - 2)): //nolint:gosec // attempt is at least 2
+			}
+		}
+		var retry bool
+		dispatch.Attempts = attempt
+		dispatch.StatusCode, retry, err = alerts.post(ctx, body)
+		if err == nil {
+			dispatch.Error = ""
+
+			break
+		}
+		dispatch.Error = err.Error()
+		if !retry || ctx.Err() != nil {
+			break
+		}
+	}
+
+	dispatch.Time = time.Now().Unix()
+	dispatch.Status = alertSent
+	if dispatch.Error != "" {
+		dispatch.Status = alertFailed
+		log.Printf("Failed to post %d findings of %s to the alert webhook: %s", len(dispatch.Findings), cmp.Or(dispatch.FileID, "test"), dispatch.Error)
+	}
+	a.metrics.alerts.Inc(dispatch.Status)
+
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+
+	alerts.nextID++
+	dispatch.ID = alerts.nextID
+	if dispatch.Status == alertSent {
+		alerts.sent++
+	} else {
+		alerts.failed++
+	}
+	alerts.history = append(alerts.history, dispatch)
+	if len(alerts.history) > maxAlertHistory {
+		alerts.history = slices.Delete(alerts.history, 0, len(alerts.history)-maxAlertHistory)
+	}
+
+	return dispatch
+}
+
+// post makes one attempt at posting body to the webhook, returning the status code and
+// whether a failure is worth retrying.
+func (al *alerter) post(ctx context.Context, body []byte) (int, bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, al.webhook.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "zeek-viz")
+
+	response, err := al.client.Do(request) //nolint:gosec // The webhook is the operator's --alert-webhook
+	if err != nil {
+		return 0, true, fmt.Errorf("failed to reach webhook: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		tail, _ := io.ReadAll(io.LimitReader(response.Body, alertResponseTail))
+		retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+
+		return response.StatusCode, retry, fmt.Errorf("%w %s: %s", errAlertStatusCode, response.Status, bytes.TrimSpace(tail))
+	}
+
+	return response.StatusCode, false, nil
+}
+
+// alertPayload encodes a dispatch in the webhook's format.
+func (a *API) alertPayload(dispatch AlertDispatch) ([]byte, error) {
+	instance := cmp.Or(a.instanceName, defaultInstanceName)
+	var payload any
+	switch a.alerts.format {
+	case alertFormatSlack:
+		payload = map[string]any{"text": alertText(instance, dispatch, "*", "• ")}
+	case alertFormatTeams:
+		payload = map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    alertTitle(instance, dispatch),
+			"title":      alertTitle(instance, dispatch),
+			"text":       alertText("", dispatch, "**", "- "),
+			"themeColor": alertColor(dispatch),
+		}
+	default:
+		payload = map[string]any{
+			"source":   "zeek-viz",
+			"instance": instance,
+			"dataset":  map[string]any{"id": dispatch.FileID, "filename": dispatch.Dataset},
+			"findings": dispatch.Findings,
+			"omitted":  dispatch.Omitted,
+			"test":     dispatch.Test,
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	return body, nil
+}
+
+// alertTitle is the headline of a dispatch.
+func alertTitle(instance string, dispatch AlertDispatch) string {
+	if dispatch.Test {
+		return instance + ": test alert"
+	}
+	count := len(dispatch.Findings) + dispatch.Omitted
+	noun := "findings"
+	if count == 1 {
+		noun = "finding"
+	}
+
+	return fmt.Sprintf("%s: %d new %s in %s", instance, count, noun, dispatch.Dataset)
+}
+
+// alertText renders a dispatch as a chat message: the title, unless instance is empty, and a
+// line per finding, with bold the chat's bold marker.
+func alertText(instance string, dispatch AlertDispatch, bold, bullet string) string {
+	var text strings.Builder
+	if instance != "" {
+		text.WriteString(bold + alertTitle(instance, dispatch) + bold + "\n")
+	}
+	for _, finding := range dispatch.Findings {
+		fmt.Fprintf(&text, "%s%s%s%s %s: %s\n", bullet, bold, strings.ToUpper(finding.Severity), bold, finding.Rule, finding.Summary)
+	}
+	if dispatch.Omitted > 0 {
+		fmt.Fprintf(&text, "…and %d more\n", dispatch.Omitted)
+	}
+
+	return strings.TrimSuffix(text.String(), "\n")
+}
+
+// alertColor is the Teams card color of the most severe finding of a dispatch.
+func alertColor(dispatch AlertDispatch) string {
+	severity := severityLow
+	for _, finding := range dispatch.Findings {
+		if severityRank(finding.Severity) > severityRank(severity) {
+			severity = finding.Severity
+		}
+	}
+
+	return map[string]string{
+		severityLow:      "2E86C1",
+		severityMedium:   "F1C40F",
+		severityHigh:     "E67E22",
+		severityCritical: "C0392B",
+	}[severity]
+}
+
+// GetAlerts returns the alerting configuration and the dispatches to the webhook, newest
+// first. The webhook URL is a secret, so only its host is shown. Accepts limit.
+func (a *API) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := map[string]any{"enabled": a.alerts != nil, "dispatches": []AlertDispatch{}}
+	if alerts := a.alerts; alerts != nil {
+		limit := cmp.Or(parseLimit(r.URL.Query(), "limit"), defaultAlertsLimit)
+
+		alerts.mu.Lock()
+		dispatches := append([]AlertDispatch{}, alerts.history...)
+		sent, failed := alerts.sent, alerts.failed
+		alerts.mu.Unlock()
+
+		slices.Reverse(dispatches)
+		response["format"] = alerts.format
+		response["min_severity"] = alerts.minSeverity
+		response["webhook_host"] = alerts.webhook.Host
+		response["sent"] = sent
+		response["failed"] = failed
+		response["total"] = len(dispatches)
+		response["dispatches"] = dispatches[:min(limit, len(dispatches))]
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode alerts: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// TestAlert posts a test alert to the webhook and returns the dispatch, with 502 when the
+// webhook didn't accept it.
+func (a *API) TestAlert(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.alerts == nil {
+		http.Error(w, errAlertsDisabled.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	dispatch := a.dispatchAlert(r.Context(), AlertDispatch{
+		Test: true,
+		Findings: []AlertFinding{{
+			Rule:     "test",
+			Severity: a.alerts.minSeverity,
+			Summary:  "Test alert sent from /api/alerts/test; new findings will be posted like this",
+		}},
+	})
+	if dispatch.Status != alertSent {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	err := json.NewEncoder(w).Encode(dispatch)
+	if err != nil {
+		log.Printf("Failed to encode alert dispatch: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
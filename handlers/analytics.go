@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"cmp"
+	"slices"
+
+	"zeek-viz/models"
+)
+
+const (
+	betweennessWork   = 20_000_000 // Node and link visits betweenness may take before sampling sources
+	maxLouvainPasses  = 20         // Passes over the nodes per Louvain level
+	maxLouvainLevels  = 10         // Times communities are merged into nodes and moved again
+	modularityEpsilon = 1e-12      // Smallest modularity gain worth moving a node for
+)
+
+// analyticsGraph is the graph analytics run on: an undirected graph over node indices, with
+// the edges of a host pair merged and weighted by their connections.
+type analyticsGraph struct {
+	neighbors [][]int
+	weights   []map[int]float64 // Link weights by neighbor, the self-loop counted twice
+}
+
+// newAnalyticsGraph indexes the graph's nodes in order and merges their edges.
+func newAnalyticsGraph(graph *models.NetworkGraph) *analyticsGraph {
+	index := make(map[string]int, len(graph.Nodes))
+	for i := range graph.Nodes {
+		index[graph.Nodes[i].ID] = i
+	}
+
+	g := &analyticsGraph{
+		neighbors: make([][]int, len(graph.Nodes)),
+		weights:   make([]map[int]float64, len(graph.Nodes)),
+	}
+	for i := range g.weights {
+		g.weights[i] = map[int]float64{}
+	}
+	for _, edge := range graph.Edges {
+		source, ok1 := index[edge.Source]
+		target, ok2 := index[edge.Target]
+		if !ok1 || !ok2 {
+			continue
+		}
+		weight := float64(edge.Count)
+		if source == target {
+			g.weights[source][source] += 2 * weight //nolint:mnd // Both ends of the loop
+
+			continue
+		}
+		if _, linked := g.weights[source][target]; !linked {
+			g.neighbors[source] = append(g.neighbors[source], target)
+			g.neighbors[target] = append(g.neighbors[target], source)
+		}
+		g.weights[source][target] += weight
+		g.weights[target][source] += weight
+	}
+	for i := range g.neighbors {
+		slices.Sort(g.neighbors[i])
+	}
+
+	return g
+}
+
+// analyzeGraph adds centrality and community metrics to the graph's nodes: the number of
+// distinct peers, the connections on their edges, betweenness centrality, and the Louvain
+// community. The nodes are copied first, since they may be shared with the file's graph
+// cache.
+func analyzeGraph(graph *models.NetworkGraph) {
+	g := newAnalyticsGraph(graph)
+	betweenness, sources := g.betweenness()
+	communities, modularity := g.louvain()
+
+	graph.Nodes = slices.Clone(graph.Nodes)
+	count := 0
+	for i := range graph.Nodes {
+		weighted := 0.0
+		for _, weight := range g.weights[i] {
+			weighted += weight
+		}
+		graph.Nodes[i].Metrics = &models.NodeMetrics{
+			Degree:         len(g.neighbors[i]),
+			WeightedDegree: weighted,
+			Betweenness:    betweenness[i],
+			Community:      communities[i],
+		}
+		count = max(count, communities[i]+1)
+	}
+	graph.Analytics = &models.GraphAnalytics{
+		Communities:        count,
+		Modularity:         modularity,
+		BetweennessSources: sources,
+		BetweennessSampled: sources < len(graph.Nodes),
+	}
+}
+
+// betweenness computes the betweenness centrality of every node with Brandes' algorithm,
+// normalized to the share of shortest paths between other nodes that pass through it. Large
+// graphs are sampled: shortest paths are taken from evenly spaced sources and scaled up.
+// It returns the centralities and the number of sources.
+func (g *analyticsGraph) betweenness() ([]float64, int) {
+	n := len(g.neighbors)
+	centrality := make([]float64, n)
+	if n < 3 { //nolint:mnd // No node lies between two others
+		return centrality, n
+	}
+
+	links := 0
+	for i := range g.neighbors {
+		links += len(g.neighbors[i])
+	}
+	sources := n
+	if n*(n+links) > betweennessWork {
+		sources = max(1, betweennessWork/(n+links))
+	}
+
+	sigma := make([]float64, n)
+	distance := make([]int, n)
+	delta := make([]float64, n)
+	predecessors := make([][]int, n)
+	order := make([]int, 0, n)
+	queue := make([]int, 0, n)
+	for s := range sources {
+		source := s * n / sources
+		for i := range n {
+			sigma[i], distance[i], delta[i] = 0, -1, 0
+			predecessors[i] = predecessors[i][:0]
+		}
+		sigma[source], distance[source] = 1, 0
+		order, queue = order[:0], append(queue[:0], source)
+		for head := 0; head < len(queue); head++ {
+			v := queue[head]
+			order = append(order, v)
+			for _, w := range g.neighbors[v] {
+				if distance[w] < 0 {
+					distance[w] = distance[v] + 1
+					queue = append(queue, w)
+				}
+				if distance[w] == distance[v]+1 {
+					sigma[w] += sigma[v]
+					predecessors[w] = append(predecessors[w], v)
+				}
+			}
+		}
+		for i := len(order) - 1; i > 0; i-- {
+			w := order[i]
+			for _, v := range predecessors[w] {
+				delta[v] += sigma[v] / sigma[w] * (1 + delta[w])
+			}
+			centrality[w] += delta[w]
+		}
+	}
+
+	// Each pair is counted from both ends; sampled sources stand for n/sources each
+	scale := float64(n) / float64(sources) / float64((n-1)*(n-2)) //nolint:mnd // Pairs of other nodes, both directions
+	for i := range centrality {
+		centrality[i] *= scale
+	}
+
+	return centrality, sources
+}
+
+// louvain detects communities with the Louvain method: nodes move to the neighboring
+// community that raises modularity most, then communities are merged into single nodes and
+// moved again, until nothing improves. Nodes are visited in order, so the result is
+// deterministic. Communities are numbered by size, largest first; it returns them with the
+// modularity of the partition.
+func (g *analyticsGraph) louvain() ([]int, float64) {
+	n := len(g.neighbors)
+	membership := make([]int, n)
+	for i := range membership {
+		membership[i] = i
+	}
+
+	weights := g.weights
+	for range maxLouvainLevels {
+		communities, moved := louvainLevel(weights)
+		if !moved {
+			break
+		}
+		for i := range membership {
+			membership[i] = communities[membership[i]]
+		}
+		weights = aggregateCommunities(weights, communities)
+	}
+
+	return numberCommunities(membership), modularity(g.weights, membership)
+}
+
+// louvainLevel moves the nodes of a weighted graph between communities until no move raises
+// modularity. It returns each node's community, numbered from 0, and whether any node moved.
+func louvainLevel(weights []map[int]float64) ([]int, bool) {
+	n := len(weights)
+	community := make([]int, n)
+	degree := make([]float64, n)
+	total := make([]float64, n)
+	twiceWeight := 0.0
+	for i := range weights {
+		community[i] = i
+		for _, weight := range weights[i] {
+			degree[i] += weight
+		}
+		total[i] = degree[i]
+		twiceWeight += degree[i]
+	}
+	if twiceWeight == 0 {
+		return community, false
+	}
+
+	links := make(map[int]float64)
+	neighbors := make([]int, 0)
+	moved := false
+	for range maxLouvainPasses {
+		improved := false
+		for i := range n {
+			clear(links)
+			neighbors = neighbors[:0]
+			for j, weight := range weights[i] {
+				if j == i {
+					continue
+				}
+				if _, seen := links[community[j]]; !seen {
+					neighbors = append(neighbors, community[j])
+				}
+				links[community[j]] += weight
+			}
+			slices.Sort(neighbors)
+
+			current := community[i]
+			total[current] -= degree[i]
+			best, bestGain := current, links[current]-total[current]*degree[i]/twiceWeight
+			for _, c := range neighbors {
+				gain := links[c] - total[c]*degree[i]/twiceWeight
+				if gain > bestGain+modularityEpsilon {
+					best, bestGain = c, gain
+				}
+			}
+			total[best] += degree[i]
+			community[i] = best
+			if best != current {
+				improved, moved = true, true
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+
+	return renumber(community), moved
+}
+
+// aggregateCommunities merges each community into one node, summing the weights of the links
+// between communities; links within one become its self-loop.
+func aggregateCommunities(weights []map[int]float64, community []int) []map[int]float64 {
+	count := 0
+	for _, c := range community {
+		count = max(count, c+1)
+	}
+	merged := make([]map[int]float64, count)
+	for i := range merged {
+		merged[i] = map[int]float64{}
+	}
+	for i := range weights {
+		for j, weight := range weights[i] {
+			merged[community[i]][community[j]] += weight
+		}
+	}
+
+	return merged
+}
+
+// modularity returns the Newman modularity of the partition of the weighted graph.
+func modularity(weights []map[int]float64, community []int) float64 {
+	inside := map[int]float64{}
+	total := map[int]float64{}
+	twiceWeight := 0.0
+	for i := range weights {
+		for j, weight := range weights[i] {
+			total[community[i]] += weight
+			twiceWeight += weight
+			if community[i] == community[j] {
+				inside[community[i]] += weight
+			}
+		}
+	}
+	if twiceWeight == 0 {
+		return 0
+	}
+
+	q := 0.0
+	for c, degree := range total {
+		q += inside[c]/twiceWeight - (degree/twiceWeight)*(degree/twiceWeight)
+	}
+
+	return q
+}
+
+// renumber numbers communities from 0 in the order of their first member.
+func renumber(community []int) []int {
+	numbers := map[int]int{}
+	result := make([]int, len(community))
+	for i, c := range community {
+		number, ok := numbers[c]
+		if !ok {
+			number = len(numbers)
+			numbers[c] = number
+		}
+		result[i] = number
+	}
+
+	return result
+}
+
+// numberCommunities numbers communities by size, largest first, ties in the order of their
+// first member.
+func numberCommunities(membership []int) []int {
+	membership = renumber(membership)
+	sizes := map[int]int{}
+	for _, c := range membership {
+		sizes[c]++
+	}
+	order := make([]int, 0, len(sizes))
+	for c := range sizes {
+		order = append(order, c)
+	}
+	slices.SortFunc(order, func(x, y int) int {
+		return cmp.Or(cmp.Compare(sizes[y], sizes[x]), cmp.Compare(x, y))
+	})
+	rank := make(map[int]int, len(order))
+	for i, c := range order {
+		rank[c] = i
+	}
+	for i, c := range membership {
+		membership[i] = rank[c]
+	}
+
+	return membership
+}
@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	annotationHost       = "host"       // Kind of annotations of IP addresses
+	annotationConnection = "connection" // Kind of annotations of connection UIDs
+
+	maxAnnotationTags = 32   // Tags per annotation
+	maxTagLength      = 64   // Characters of a tag
+	maxNoteLength     = 4096 // Characters of a note
+	maxUIDLength      = 64   // Characters of a connection UID
+)
+
+var (
+	errAnnotationTarget   = errors.New("target must be an IP address or a connection UID")
+	errAnnotationEmpty    = errors.New("an annotation needs tags or a note")
+	errAnnotationTags     = errors.New("annotations take at most 32 tags of at most 64 characters")
+	errAnnotationNote     = errors.New("notes are at most 4096 characters")
+	errAnnotationKind     = errors.New("kind must be host or connection")
+	errAnnotationNotFound = errors.New("annotation not found")
+)
+
+// Annotation is what an analyst noted about an IP address or a connection UID, such as "DC01",
+// "known scanner", or "ticket #1234", so investigations can mark what was already looked at.
+type Annotation struct {
+	models.Annotation
+
+	Target    string `json:"target"`     // IP address or connection UID
+	Kind      string `json:"kind"`       // host or connection
+	UpdatedAt int64  `json:"updated_at"` //nolint:tagliatelle // API consistency
+}
+
+// annotatedConnection is a connection with the anomalies of its history, the indicator one of
+// its hosts matches, and the annotations of it and its hosts.
+type annotatedConnection struct {
+	models.Connection
+
+	HistoryFlags []string                      `json:"history_flags,omitempty"` //nolint:tagliatelle // API consistency
+	Threat       *models.Threat                `json:"threat,omitempty"`
+	Annotations  *models.ConnectionAnnotations `json:"annotations,omitempty"`
+}
+
+// parseAnnotationTarget returns the canonical form of an IP address or connection UID and
+// the kind of annotations it takes.
+func parseAnnotationTarget(value string) (string, string, error) {
+	value = strings.TrimSpace(value)
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap().String(), annotationHost, nil
+	}
+
+	isUID := value != "" && len(value) <= maxUIDLength && strings.IndexFunc(value, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+	}) < 0
+	if !isUID {
+		return "", "", errAnnotationTarget
+	}
+
+	return value, annotationConnection, nil
+}
+
+// normalize trims the tags and note, dropping empty and repeated tags, and checks their limits.
+func (n *Annotation) normalize() error {
+	tags := make([]string, 0, len(n.Tags))
+	for _, tag := range n.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if len([]rune(tag)) > maxTagLength {
+			return errAnnotationTags
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxAnnotationTags {
+		return errAnnotationTags
+	}
+	n.Tags = tags
+	n.Note = strings.TrimSpace(n.Note)
+	if len([]rune(n.Note)) > maxNoteLength {
+		return errAnnotationNote
+	}
+	if len(n.Tags) == 0 && n.Note == "" {
+		return errAnnotationEmpty
+	}
+
+	return nil
+}
+
+// SetAnnotationsFile persists annotations in path, loading the ones saved there.
+func (a *API) SetAnnotationsFile(path string) error {
+	var annotations []Annotation
+	err := readJSONFile(path, &annotations)
+	if err != nil {
+		return err
+	}
+
+	for i := range annotations {
+		annotations[i].Target, annotations[i].Kind, err = parseAnnotationTarget(annotations[i].Target)
+		if err != nil {
+			return fmt.Errorf("annotation %q: %w", annotations[i].Target, err)
+		}
+	}
+
+	a.annotationsPath = path
+	a.setAnnotations(annotations)
+
+	return nil
+}
+
+// GetAnnotations returns the annotations, sorted by kind and target. The kind and tag
+// parameters narrow them down to hosts or connections, and to those carrying a tag.
+func (a *API) GetAnnotations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	kind, tag := r.URL.Query().Get("kind"), r.URL.Query().Get("tag")
+	if kind != "" && kind != annotationHost && kind != annotationConnection {
+		http.Error(w, errAnnotationKind.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	annotations := make([]Annotation, 0, len(a.annotations))
+	for _, annotation := range a.annotations {
+		if (kind == "" || annotation.Kind == kind) && (tag == "" || slices.Contains(annotation.Tags, tag)) {
+			annotations = append(annotations, annotation)
+		}
+	}
+	slices.SortFunc(annotations, func(x, y Annotation) int {
+		return cmp.Or(cmp.Compare(x.Kind, y.Kind), cmp.Compare(x.Target, y.Target))
+	})
+
+	err := json.NewEncoder(w).Encode(map[string]any{"annotations": annotations})
+	if err != nil {
+		log.Printf("Failed to encode annotations: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetAnnotation returns the annotation of an IP address or connection UID.
+func (a *API) GetAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	target, _, err := parseAnnotationTarget(r.PathValue("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	annotation, exists := a.annotations[target]
+	if !exists {
+		http.Error(w, errAnnotationNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(annotation)
+	if err != nil {
+		log.Printf("Failed to encode annotation: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// PutAnnotation sets the tags and note of an IP address or connection UID, replacing what
+// was noted about it before.
+func (a *API) PutAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	target, kind, err := parseAnnotationTarget(r.PathValue("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var annotation Annotation
+	err = json.NewDecoder(r.Body).Decode(&annotation)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	err = annotation.normalize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	annotation.Target, annotation.Kind, annotation.UpdatedAt = target, kind, time.Now().Unix()
+
+	annotations := a.annotationList()
+	annotations = slices.DeleteFunc(annotations, func(existing Annotation) bool {
+		return existing.Target == target
+	})
+	a.setAnnotations(append(annotations, annotation))
+
+	err = a.saveAnnotations()
+	if err != nil {
+		log.Printf("Failed to save annotations: %v", err)
+		http.Error(w, "Failed to save annotations", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Annotated %s %s with %d tags", kind, target, len(annotation.Tags))
+
+	err = json.NewEncoder(w).Encode(annotation)
+	if err != nil {
+		log.Printf("Failed to encode annotation: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// DeleteAnnotation removes the annotation of an IP address or connection UID.
+func (a *API) DeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	target, _, err := parseAnnotationTarget(r.PathValue("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if _, exists := a.annotations[target]; !exists {
+		http.Error(w, errAnnotationNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	a.setAnnotations(slices.DeleteFunc(a.annotationList(), func(annotation Annotation) bool {
+		return annotation.Target == target
+	}))
+
+	err = a.saveAnnotations()
+	if err != nil {
+		log.Printf("Failed to save annotations: %v", err)
+		http.Error(w, "Failed to save annotations", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Removed annotation of %s", target)
+
+	err = json.NewEncoder(w).Encode(map[string]any{"success": true, "target": target})
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// restoreAnnotations replaces the annotations with those of a snapshot, skipping invalid ones.
+func (a *API) restoreAnnotations(annotations []Annotation) {
+	valid := make([]Annotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		var err error
+		annotation.Target, annotation.Kind, err = parseAnnotationTarget(annotation.Target)
+		if err != nil {
+			log.Printf("Skipping invalid annotation %q: %v", annotation.Target, err)
+
+			continue
+		}
+		valid = append(valid, annotation)
+	}
+	a.setAnnotations(valid)
+
+	err := a.saveAnnotations()
+	if err != nil {
+		log.Printf("Failed to save annotations: %v", err)
+	}
+}
+
+// setAnnotations replaces the annotations and invalidates cached responses carrying the
+// previous ones. Later annotations of a target replace earlier ones.
+func (a *API) setAnnotations(annotations []Annotation) {
+	a.annotations = make(map[string]Annotation, len(annotations))
+	for _, annotation := range annotations {
+		a.annotations[annotation.Target] = annotation
+	}
+	a.settingsVersion = time.Now().UnixNano()
+}
+
+// annotationList returns the annotations sorted by target, as they are persisted.
+func (a *API) annotationList() []Annotation {
+	annotations := make([]Annotation, 0, len(a.annotations))
+	for _, annotation := range a.annotations {
+		annotations = append(annotations, annotation)
+	}
+	slices.SortFunc(annotations, func(x, y Annotation) int {
+		return cmp.Compare(x.Target, y.Target)
+	})
+
+	return annotations
+}
+
+// saveAnnotations writes the annotations to their file, if one is configured.
+func (a *API) saveAnnotations() error {
+	if a.annotationsPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.annotationList(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding annotations: %w", err)
+	}
+
+	return writeFileAtomic(a.annotationsPath, data)
+}
+
+// hostAnnotation returns the annotation of a host address, or nil.
+func (a *API) hostAnnotation(host string) *models.Annotation {
+	if len(a.annotations) == 0 {
+		return nil
+	}
+	annotation, exists := a.annotations[host]
+	if !exists {
+		addr, err := netip.ParseAddr(host)
+		if err != nil || addr.Unmap().String() == host {
+			return nil
+		}
+		annotation, exists = a.annotations[addr.Unmap().String()]
+		if !exists {
+			return nil
+		}
+	}
+
+	return &annotation.Annotation
+}
+
+// connectionAnnotations returns the annotations of a connection and its hosts, or nil when
+// none of them are annotated.
+func (a *API) connectionAnnotations(conn *models.Connection) *models.ConnectionAnnotations {
+	if len(a.annotations) == 0 {
+		return nil
+	}
+
+	annotations := &models.ConnectionAnnotations{
+		Orig: a.hostAnnotation(conn.OrigHost),
+		Resp: a.hostAnnotation(conn.RespHost),
+	}
+	if annotation, exists := a.annotations[conn.UID]; exists && annotation.Kind == annotationConnection {
+		annotations.Connection = &annotation.Annotation
+	}
+	if annotations.Connection == nil && annotations.Orig == nil && annotations.Resp == nil {
+		return nil
+	}
+
+	return annotations
+}
+
+// annotator returns the annotations exports add to connections, or nil when nothing is
+// annotated, which leaves exports in Zeek's layout.
+func (a *API) annotator() models.Annotator {
+	if len(a.annotations) == 0 {
+		return nil
+	}
+
+	return a.connectionAnnotations
+}
+
+// annotateConnections pairs the connections with their history flags, the indicators they
+// match, and their annotations.
+func (a *API) annotateConnections(connections []models.Connection) any {
+	annotated := make([]annotatedConnection, len(connections))
+	for i := range connections {
+		annotated[i] = annotatedConnection{
+			Connection:   connections[i],
+			HistoryFlags: connections[i].HistoryFlags(),
+			Threat:       a.intel.matchConnection(&connections[i]),
+			Annotations:  a.connectionAnnotations(&connections[i]),
+		}
+	}
+
+	return annotated
+}
+
+// annotateNodes adds the annotations of the nodes' addresses. The nodes are copied first,
+// since the unfiltered graph is shared by concurrent requests.
+func (a *API) annotateNodes(nodes []models.Node) []models.Node {
+	if len(a.annotations) == 0 {
+		return nodes
+	}
+
+	nodes = slices.Clone(nodes)
+	for i := range nodes {
+		nodes[i].Annotation = a.hostAnnotation(nodes[i].ID)
+	}
+
+	return nodes
+}
+
+// connectionsAnnotations returns the annotations of the connections and their hosts, sorted
+// by kind and target, for evidence packages.
+func (a *API) connectionsAnnotations(connections []models.Connection) []Annotation {
+	if len(a.annotations) == 0 {
+		return nil
+	}
+
+	found := make(map[string]Annotation)
+	for i := range connections {
+		conn := &connections[i]
+		for _, target := range []string{conn.UID, conn.OrigHost, conn.RespHost} {
+			key := target
+			if addr, err := netip.ParseAddr(target); err == nil {
+				key = addr.Unmap().String()
+			}
+			if annotation, exists := a.annotations[key]; exists {
+				found[key] = annotation
+			}
+		}
+	}
+
+	annotations := make([]Annotation, 0, len(found))
+	for _, annotation := range found {
+		annotations = append(annotations, annotation)
+	}
+	slices.SortFunc(annotations, func(x, y Annotation) int {
+		return cmp.Or(cmp.Compare(x.Kind, y.Kind), cmp.Compare(x.Target, y.Target))
+	})
+
+	return annotations
+}
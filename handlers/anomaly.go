@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	anomalyWindow          = 24     // Preceding buckets the baseline of a bucket is taken from
+	anomalyMinHistory      = 6      // Preceding buckets needed before a bucket is scored
+	anomalyMADScale        = 1.4826 // Scales the median absolute deviation to a standard deviation
+	anomalyMinSpread       = 0.1    // Fraction of the baseline a deviation counts at least, for steady traffic
+	anomalyScorePrecision  = 100    // Scores are rounded to two decimals
+	defaultAnomalyMinScore = 3.5    // Absolute score from which a bucket is listed
+	defaultAnomalyLimit    = 20     // Windows returned by default
+	anomalyMetricCount     = "connections"
+	anomalyMetricBytes     = "bytes"
+)
+
+var (
+	errInvalidAnomalyMetric   = errors.New("metric must be connections or bytes")
+	errInvalidAnomalyMinScore = errors.New("min_score must be a non-negative number")
+)
+
+// Anomaly is a timeline bucket whose traffic deviates from the buckets before it.
+type Anomaly struct {
+	Start     int64   `json:"start"`
+	End       int64   `json:"end"`
+	Local     string  `json:"local,omitempty"` // Bucket start in the requested time zone
+	Count     int     `json:"count"`
+	Bytes     int     `json:"bytes"`
+	Baseline  float64 `json:"baseline"` // Median of the metric over the preceding buckets
+	Score     float64 `json:"score"`    // Robust z-score: positive for spikes, negative for drops
+	Direction string  `json:"direction"`
+}
+
+// bucketScore is the baseline and score of a timeline bucket.
+type bucketScore struct {
+	timestamp int64
+	value     float64
+	baseline  float64
+	score     float64
+}
+
+// GetAnomalies lists the timeline buckets whose connection count or bytes deviate most from
+// the buckets before them, both bursts and drops, including buckets where traffic stopped.
+// Accepts the standard filters, bucket, tz, metric, min_score, and limit.
+func (a *API) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = anomalyMetricCount
+	}
+	value := anomalyMetric(metric)
+	if value == nil {
+		http.Error(w, errInvalidAnomalyMetric.Error(), http.StatusBadRequest)
+
+		return
+	}
+	minScore, err := parseFloatParam(query.Get("min_score"), defaultAnomalyMinScore)
+	if err != nil || minScore < 0 {
+		http.Error(w, errInvalidAnomalyMinScore.Error(), http.StatusBadRequest)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultAnomalyLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	bucketSize, err := parseTimelineBucket(query, connections)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var timeline *models.TimelineData
+	currentFile := a.files[a.currentDataset(r)]
+	if currentFile != nil && isUnfiltered(query) {
+		timeline = currentFile.timeline(bucketSize, loc) // Includes rolled-up live history
+	} else {
+		timeline = buildTimeline(connections, bucketSize, loc)
+	}
+
+	points := make(map[int64]*models.TimelinePoint, len(timeline.Points))
+	for i := range timeline.Points {
+		points[timeline.Points[i].Timestamp] = &timeline.Points[i]
+	}
+	anomalies := make([]Anomaly, 0)
+	for _, bucket := range scoreBuckets(timeline.Points, bucketSize, loc, value) {
+		if bucket.score == 0 || math.Abs(bucket.score) < minScore {
+			continue
+		}
+		anomaly := Anomaly{
+			Start:     bucket.timestamp,
+			End:       nextBucket(bucket.timestamp, bucketSize, loc),
+			Baseline:  bucket.baseline,
+			Score:     bucket.score,
+			Direction: "spike",
+		}
+		if bucket.score < 0 {
+			anomaly.Direction = "drop"
+		}
+		if point := points[bucket.timestamp]; point != nil {
+			anomaly.Count, anomaly.Bytes = point.Count, point.Bytes
+		}
+		if loc != nil {
+			anomaly.Local = formatLocal(anomaly.Start, loc)
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if math.Abs(anomalies[i].Score) != math.Abs(anomalies[j].Score) {
+			return math.Abs(anomalies[i].Score) > math.Abs(anomalies[j].Score)
+		}
+
+		return anomalies[i].Start < anomalies[j].Start
+	})
+
+	response := map[string]any{
+		"anomalies":   anomalies[:min(limit, len(anomalies))],
+		"total":       len(anomalies),
+		"truncated":   len(anomalies) > limit,
+		"bucket_size": bucketSize,
+		"metric":      metric,
+		"timezone":    timezoneName(loc),
+		"limits": map[string]any{
+			"limit":     limit,
+			"min_score": minScore,
+			"window":    anomalyWindow,
+		},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode anomalies: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// anomalyMetric returns the accessor of the metric buckets are scored by, or nil for an
+// unknown one.
+func anomalyMetric(metric string) func(point *models.TimelinePoint) float64 {
+	switch metric {
+	case anomalyMetricCount:
+		return func(point *models.TimelinePoint) float64 { return float64(point.Count) }
+	case anomalyMetricBytes:
+		return func(point *models.TimelinePoint) float64 { return float64(point.Bytes) }
+	default:
+		return nil
+	}
+}
+
+// scoreTimeline sets the anomaly score of each timeline point from its connection count.
+func scoreTimeline(timeline *models.TimelineData, loc *time.Location) {
+	scores := scoreBuckets(timeline.Points, timeline.BucketSize, loc, anomalyMetric(anomalyMetricCount))
+	byTimestamp := make(map[int64]float64, len(scores))
+	for _, bucket := range scores {
+		byTimestamp[bucket.timestamp] = bucket.score
+	}
+	for i := range timeline.Points {
+		timeline.Points[i].AnomalyScore = byTimestamp[timeline.Points[i].Timestamp]
+	}
+}
+
+// scoreBuckets compares each bucket of a sorted timeline with the median and median absolute
+// deviation of the anomalyWindow buckets before it, empty ones counting as zero. Besides the
+// points, the first empty bucket after each one is scored, so traffic stopping shows up as a
+// drop. Buckets with less than anomalyMinHistory buckets of history score zero.
+func scoreBuckets(points []models.TimelinePoint, bucketSize int64, loc *time.Location, value func(point *models.TimelinePoint) float64) []bucketScore {
+	if len(points) == 0 {
+		return nil
+	}
+
+	values := make(map[int64]float64, len(points))
+	for i := range points {
+		values[points[i].Timestamp] = value(&points[i])
+	}
+	first, last := points[0].Timestamp, points[len(points)-1].Timestamp
+
+	candidates := make([]int64, 0, len(points))
+	for i := range points {
+		candidates = append(candidates, points[i].Timestamp)
+		next := nextBucket(points[i].Timestamp, bucketSize, loc)
+		if _, exists := values[next]; !exists && next <= last {
+			candidates = append(candidates, next)
+		}
+	}
+
+	scores := make([]bucketScore, 0, len(candidates))
+	history := make([]float64, 0, anomalyWindow)
+	for _, timestamp := range candidates {
+		history = history[:0]
+		bucket := timestamp
+		for len(history) < anomalyWindow {
+			bucket = previousBucket(bucket, bucketSize, loc)
+			if bucket < first {
+				break
+			}
+			history = append(history, values[bucket])
+		}
+
+		current := bucketScore{timestamp: timestamp, value: values[timestamp]}
+		if len(history) >= anomalyMinHistory {
+			_, current.baseline, _ = quartiles(history)
+			deviation := medianDeviation(history, current.baseline)
+			spread := max(anomalyMADScale*deviation, anomalyMinSpread*current.baseline, 1)
+			current.score = roundTo((current.value-current.baseline)/spread, anomalyScorePrecision)
+		}
+		scores = append(scores, current)
+	}
+
+	return scores
+}
@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"bufio"
+	"context"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -12,47 +13,159 @@ import (
 	"net/http"
 	"os"
 	"sort"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"zeek-viz/auth"
+	"zeek-viz/connfilter"
+	"zeek-viz/connstats"
+	"zeek-viz/elastic"
+	"zeek-viz/geoip"
 	"zeek-viz/models"
+	"zeek-viz/netgraph"
+	"zeek-viz/parse"
+	"zeek-viz/store"
 )
 
 const (
-	maxUploadSize     = 50 << 20 // 50MB
+	maxUploadSize     = 50 << 20 // 50MB, the default upload limit and the form data kept in memory
 	timelineBucketSec = 10       // 10 seconds
-	bytesScaleFactor  = 1000.0   // Scale factor for visualization
 	fileIDLength      = 16       // File ID hash length
 	allProtocol       = "all"    // String constant for "all" protocol filter
+	zjsonFormat       = "zjson"  // Format value selecting Zed ZJSON output
+	zngFormat         = "zng"    // Format value selecting Zed's binary ZNG output
 )
 
 var (
 	errFailedToOpenLogFile = errors.New("failed to open log file")
 	errErrorReadingData    = errors.New("error reading data")
+	errIdempotencyConflict = errors.New("idempotency key was already used for different content")
 )
 
 // FileData represents an uploaded file with its connections.
 type FileData struct {
-	Filename    string              `json:"filename"`
-	UploadTime  int64               `json:"upload_time"` //nolint:tagliatelle // API compatibility
-	Size        int64               `json:"size"`
-	Connections []models.Connection `json:"-"` // Don't include in JSON responses
+	Filename    string                  `json:"filename"`
+	UploadTime  int64                   `json:"upload_time"` //nolint:tagliatelle // API compatibility
+	Size        int64                   `json:"size"`
+	Connections []models.Connection     `json:"-"` // Don't include in JSON responses
+	Stats       *models.ConnectionStats `json:"-"` // Computed once at ingest time
+	Tags        []string                `json:"tags,omitempty"`
+	SHA256      string                  `json:"sha256,omitempty"`      // Digest of the raw uploaded bytes
+	Dataset     string                  `json:"dataset,omitempty"`     // Client-supplied stable dataset name
+	Name        string                  `json:"name,omitempty"`        // Display name set after upload
+	Description string                  `json:"description,omitempty"` // Notes on the dataset
+	CaseNumber  string                  `json:"case_number,omitempty"` //nolint:tagliatelle // API consistency
+	ParseReport *parse.Report           `json:"-"`                     // Lines skipped while parsing
+	ParseMode   string                  `json:"parse_mode"`            //nolint:tagliatelle // API consistency
+	StitchGap   float64                 `json:"stitch_gap,omitempty"`  //nolint:tagliatelle // Flow stitching gap in seconds, 0 when off
+
+	raw        []byte              // Original uploaded bytes, kept unless raw storage is disabled
+	unstitched []models.Connection // Records as logged, while flow stitching merged Connections
+
+	cacheMu        sync.Mutex                           // Guards the lazily computed caches below
+	timelineCache  map[timelineKey]*models.TimelineData // Timeline per bucket size and time zone
+	sqlDB          *sql.DB                              // In-memory SQL view for /api/query
+	rollups        map[int64]*models.TimelinePoint      // Aggregated history of rolled-up live data
+	rawSpan        [2]float64                           // Earliest and latest ts of the raw connections, once spanKnown
+	spanKnown      bool                                 // rawSpan covers the connections
+	graphCache     *graphCache                          // Unfiltered nodes and edges
+	scanCache      []Scan                               // Unfiltered scans at the default thresholds
+	index          *connectionIndex                     // Positions by time, protocol, state, and host
+	warming        bool                                 // Caches are being precomputed in the background
+	storedAt       int64                                // Store version this copy matches, 0 if never stored
+	watchlistHits  []WatchlistHit                       // Watchlist entries the connections touch
+	suppressedHits int                                  // Watchlist hits silenced by suppressions
+	httpRequests   map[string][]models.HTTPRequest      // Attached http.log records by connection UID
+	tlsSessions    map[string][]models.TLSSession       // Attached ssl.log records by connection UID
+	notices        map[string][]models.Notice           // Attached notice.log records by connection UID, "" without one
+	weirds         map[string][]models.Weird            // Attached weird.log records by connection UID, "" without one
+	memory         int64                                // Estimated bytes of the connections, guarded by cacheMu
+	unloaded       *unloadedInfo                        // Set while the dataset is evicted to the store
+	version        int64                                // Incremented whenever the connections are replaced
+	attachVersion  int64                                // Incremented whenever a protocol log is attached
+	searchState    string                               // Progress of indexing in the search backend, empty without one
+	searchVersion  int64                                // Version the search index holds
+	lastAccess     atomic.Int64                         // When a request last used the dataset (Unix nanoseconds)
 }
 
-// API handles all API endpoints.
+// API handles all API endpoints. mu guards the datasets and their records, the current
+// selection, the watchlist, suppressions, saved views, annotations, and settings; handlers
+// hold it through ReadLocked or Locked.
 type API struct {
-	files         map[string]*FileData // Map of file ID to file data
-	currentFileID string               // Currently selected file ID
-	logPath       string               // For backward compatibility
+	mu               sync.RWMutex
+	files            map[string]*FileData  // Map of file ID to file data
+	currentFileID    string                // Server-wide selection, for clients without a workspace (deprecated)
+	workspaces       map[string]*workspace // Selections of browsers by workspace ID
+	logPath          string                // For backward compatibility
+	live             *models.LiveStats     // Rolling aggregates fed by streaming ingestion
+	liveRetention    time.Duration         // Raw data retention for live datasets
+	discardRaw       bool                  // Don't keep original upload bytes in memory
+	zeekPath         string                // Zeek binary reading uploaded packet captures, empty for the built-in extraction
+	backupDir        string                // Directory of backup archives, empty when disabled
+	backupKeep       int                   // Number of backups kept in backupDir
+	store            store.Store           // Shared dataset store, nil when datasets are memory-only
+	search           *elastic.Client       // Search backend datasets are indexed in, nil with the memory backend
+	searchJobs       *searchQueue          // Indexing and deletions waiting for the search backend
+	storePending     atomic.Int64          // Stored datasets listed at startup that are still being loaded
+	cache            store.Cache           // Shared result cache and selection, nil without Redis
+	instanceName     string                // Name shown in the UI
+	basePath         string                // Path prefix the application is served under
+	watchlist        []WatchlistEntry      // Addresses of interest, sorted by value
+	watchlistPath    string                // File the watchlist is persisted in, empty when memory-only
+	suppressions     []Suppression         // Findings silenced as known-benign, sorted by ID
+	suppressionsPath string                // File suppressions are persisted in, empty when memory-only
+	views            []View                // Saved filter combinations, sorted by name
+	viewsPath        string                // File views are persisted in, empty when memory-only
+	annotations      map[string]Annotation // Analyst tags and notes by IP address or connection UID
+	annotationsPath  string                // File annotations are persisted in, empty when memory-only
+	settingsVersion  int64                 // Changes whenever suppressions, annotations, local networks, IOC lists, or the watchlist change, for cache keys
+	localNetworks    models.LocalNetworks  // Prefixes whose hosts count as local
+	servicePorts     models.ServicePorts   // Services guessed from the responder port when Zeek found none
+	geoip            *geoip.DB             // Locations of external hosts, nil without a GeoIP database
+	rdns             *resolver             // Hostnames of node addresses, nil without reverse DNS
+	intel            *threatIntel          // Uploaded IOC lists, nil when none are loaded
+	ingestBudget     int64                 // Heap growth allowed per streamed upload, 0 for the default
+	uploadLimit      int64                 // Bytes a multipart upload may hold, 0 for the default
+	batchLimit       int64                 // Decompressed bytes a batch upload may hold, 0 for the default
+	maxResults       int                   // Connections /api/connections returns at once, 0 for no limit
+	authenticators   []auth.Authenticator  // Accepted credentials, none when authentication is off
+	sessions         *auth.Sessions        // Signs session cookies issued by /api/login
+	metrics          *apiMetrics           // Served at /metrics
+	accessLog        bool                  // Log every API request
+	maxLoaded        int                   // Datasets kept loaded at most, 0 for no limit
+	memoryLimit      int64                 // Estimated bytes the loaded datasets may take, 0 for no limit
+	evictions        chan struct{}         // Wakes the evictor when datasets were added or grew, nil without limits
+	maxStored        int                   // Datasets the server stores at most, 0 for no limit
+	storageQuota     int64                 // Bytes of uploaded logs the stored datasets may total, 0 for no limit
+	retention        RetentionPolicy       // Datasets the janitor removes beyond, zero for no limits
+	retentionSweep   int64                 // When the janitor next runs (Unix seconds), 0 while it isn't running
+	limiter          *rateLimiter          // Requests allowed per client address, nil without a rate limit
+	alerts           *alerter              // Posts new findings to a webhook, nil when alerting is off
+
+	ingestMu sync.Mutex                 // Guards ingests, independently of mu so progress can be polled during a store
+	ingests  map[string]*ingestProgress // Progress of streamed uploads by upload ID
+
+	liveEvents     liveHub          // Subscribers of /api/live/events
+	tailMu         sync.Mutex       // Guards tails, watchers, and kafkaConsumers
+	tails          []*tailer        // Log files followed in live tail mode
+	watchers       []*dirWatcher    // Directories watched for rotated logs
+	kafkaConsumers []*kafkaConsumer // Kafka topics consumed in live mode
+	kafkaRunning   sync.WaitGroup   // Kafka consumers that haven't committed their last offsets yet
 }
 
 // NewAPI creates a new API handler.
 func NewAPI(logPath string) *API {
-	return &API{
-		files:   make(map[string]*FileData),
-		logPath: logPath,
+	api := &API{
+		files:         make(map[string]*FileData),
+		logPath:       logPath,
+		live:          models.NewLiveStats(),
+		localNetworks: models.DefaultLocalNetworks(),
+		servicePorts:  models.DefaultServicePorts(),
 	}
+	api.metrics = newAPIMetrics(api)
+
+	return api
 }
 
 // LoadConnections reads and parses the connection log file.
@@ -63,7 +176,7 @@ func (a *API) LoadConnections() error {
 	}
 	defer file.Close()
 
-	connections, err := a.LoadConnectionsFromReader(file)
+	connections, stats, report, err := parseConnections(context.Background(), file, false)
 	if err != nil {
 		return err
 	}
@@ -76,10 +189,15 @@ func (a *API) LoadConnections() error {
 		Filename:    a.logPath,
 		UploadTime:  uploadTime,
 		Size:        0, // File size not available in this case
-		Connections: connections,
+		ParseReport: report,
+		ParseMode:   lenientMode,
 	}
+	fileData.setConnections(connections, stats)
+	a.guessFileServices(fileData)
 
 	a.files[fileID] = fileData
+	a.checkWatchlist(fileID, fileData)
+	a.indexFile(fileID, fileData)
 	a.currentFileID = fileID
 
 	return nil
@@ -87,280 +205,540 @@ func (a *API) LoadConnections() error {
 
 // LoadConnectionsFromReader reads and parses connections from an io.Reader.
 func (a *API) LoadConnectionsFromReader(reader io.Reader) ([]models.Connection, error) {
-	var connections []models.Connection
-	var err error
-	var conn *models.Connection
-	scanner := bufio.NewScanner(reader)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+	connections, _, _, err := parseConnections(context.Background(), reader, false)
 
-		conn, err = models.UnmarshalConnection([]byte(line))
-		if err != nil {
-			log.Printf("Failed to parse connection: %v", err)
-
-			continue
-		}
+	return connections, err
+}
 
-		connections = append(connections, *conn)
+// parseConnections parses connections from an io.Reader and accumulates their statistics,
+// decoding records on one worker per CPU, and logs the outcome. See parse.Connections for
+// how damaged lines are recovered, skipped, or in strict mode abort parsing.
+func parseConnections(ctx context.Context, reader io.Reader, strict bool) ([]models.Connection, *models.ConnectionStats, *parse.Report, error) {
+	result, err := parse.Connections(ctx, reader, parse.Options{Strict: strict})
+	if err == nil {
+		logParseReport(result.Report)
 	}
 
-	err = scanner.Err()
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", errErrorReadingData, err)
-	}
-
-	log.Printf("Parsed %d connections", len(connections))
+	return result.Connections, result.Stats, result.Report, err
+}
 
-	return connections, nil
+// logParseReport logs the outcome of parsing a log.
+func logParseReport(report *parse.Report) {
+	switch {
+	case report.SkippedLines > 0 || report.RecoveredLines > 0:
+		log.Printf("Parsed %d connections, recovered %d and skipped %d malformed lines",
+			report.ParsedLines, report.RecoveredLines, report.SkippedLines)
+	default:
+		log.Printf("Parsed %d connections", report.ParsedLines)
+	}
 }
 
 // UploadFile handles file upload and parses the connection log.
 func (a *API) UploadFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-
+	options, ok := a.readUploadForm(w, r)
+	if !ok {
 		return
 	}
-
-	// Parse multipart form data
-	err := r.ParseMultipartForm(maxUploadSize)
-	if err != nil {
-		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+	if headers := r.MultipartForm.File["logfile"]; isBatchUpload(headers) {
+		a.uploadBatch(w, r, headers, options)
 
 		return
 	}
 
-	// Get the file from form data
-	file, header, err := r.FormFile("logfile")
-	if err != nil {
-		http.Error(w, "Failed to get file from request", http.StatusBadRequest)
-
+	upload, ok := a.readUploadFile(w, r, options)
+	if !ok {
 		return
 	}
-	defer file.Close()
 
-	log.Printf("Received file upload: %s (size: %d bytes)", header.Filename, header.Size)
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	// Parse connections from uploaded file
-	connections, err := a.LoadConnectionsFromReader(file)
-	if err != nil {
-		log.Printf("Failed to load connections from uploaded file: %v", err)
-		http.Error(w, "Failed to parse connection log file", http.StatusBadRequest)
-
-		return
-	}
+	a.storeUpload(w, r, upload)
+}
 
-	// Create file data record
+// storeUpload stores a parsed upload under its file ID, makes it the current file, and writes
+// the upload response. It returns the file ID, or "" when the upload was rejected. Callers
+// must hold a.mu.
+func (a *API) storeUpload(w http.ResponseWriter, r *http.Request, upload *parsedUpload) string {
 	uploadTime := time.Now().Unix()
-	fileID := a.generateFileID(header.Filename, uploadTime)
-
-	fileData := &FileData{
-		Filename:    header.Filename,
-		UploadTime:  uploadTime,
-		Size:        header.Size,
-		Connections: connections,
+	fileID := a.uploadFileID(r, upload, uploadTime)
+	fileData, status, err := a.addUpload(fileID, r.FormValue("dataset"), connfilter.SplitList(r.FormValue("tags")), upload, uploadTime)
+	var quotaErr *quotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		writeQuotaError(w, quotaErr)
+
+		return ""
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return ""
 	}
+	noteDataset(r.Context(), fileID)
 
-	// Store the file data
-	a.files[fileID] = fileData
-	a.currentFileID = fileID // Make this the current file
+	a.setCurrentDataset(r, fileID) // Make this the current file
+	fileData.warmCaches(a.localNetworks)
 
-	log.Printf("Stored file %s as ID %s with %d connections", header.Filename, fileID, len(connections))
+	log.Printf("Stored file %s as ID %s with %d connections (%s)", upload.filename, fileID, len(fileData.Connections), status)
 
 	// Return success response with stats
 	w.Header().Set("Content-Type", "application/json")
+	message := fmt.Sprintf("Successfully loaded %d connections from %s", len(fileData.Connections), fileData.Filename)
+	if status == uploadDuplicate {
+		message = fmt.Sprintf("%s was already uploaded as file %s", upload.filename, fileID)
+	}
 	response := map[string]any{
 		"success":           true,
-		"message":           fmt.Sprintf("Successfully loaded %d connections from %s", len(connections), header.Filename),
-		"connections_count": len(connections),
-		"filename":          header.Filename,
+		"message":           message,
+		"connections_count": len(fileData.Connections),
+		"filename":          fileData.Filename,
 		"file_id":           fileID,
 		"total_files":       len(a.files),
+		"status":            status,
+		"parse_errors":      fileData.ParseReport.Summary(),
+		"ingest":            upload.metrics,
+	}
+	if warning := fileData.ParseReport.Warning(); warning != "" {
+		response["message"] = message + "; " + warning
+		response["warning"] = warning
+	}
+	if len(fileData.watchlistHits) > 0 {
+		response["watchlist_hits"] = fileData.watchlistHits
+	}
+	if fileData.suppressedHits > 0 {
+		response["suppressed_findings"] = fileData.suppressedHits
 	}
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		log.Printf("Failed to encode response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
+
+	return fileID
 }
 
-// GetConnections returns all connections with optional filtering.
-func (a *API) GetConnections(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// addUpload stores a parsed upload under fileID as a new file, or as new content of the named
+// dataset. Content identical to what the ID holds is a duplicate and left alone; other content
+// under an idempotency key's ID is rejected with errIdempotencyConflict, and content beyond the
+// quota with a *quotaError. Callers must hold a.mu.
+func (a *API) addUpload(fileID, dataset string, tags []string, upload *parsedUpload, uploadTime int64) (*FileData, string, error) {
+	status := uploadCreated
+	fileData, exists := a.files[fileID]
+	if !exists || (fileData.SHA256 != upload.sha256 && dataset != "") {
+		err := a.checkQuota(map[string]int64{fileID: upload.size}, true)
+		if err != nil {
+			a.metrics.uploads.Inc(uploadOverQuota)
+
+			return nil, "", err
+		}
+	}
+	switch {
+	case !exists:
+		// Create file data record
+		fileData = &FileData{
+			UploadTime: uploadTime,
+			Tags:       tags,
+			Dataset:    dataset,
+		}
+		upload.applyTo(fileData)
+		a.files[fileID] = fileData
+	case fileData.SHA256 == upload.sha256:
+		status = uploadDuplicate
+		if fileData.unloaded != nil {
+			upload.applyTo(fileData) // Evicted: the upload has the content that would be read back
+		}
+	case dataset != "":
+		upload.applyTo(fileData)
+		status = uploadReplaced
+	default:
+		a.metrics.uploads.Inc(uploadConflict)
 
+		return nil, "", errIdempotencyConflict
+	}
+	if status != uploadDuplicate || fileData.unloaded == nil {
+		a.guessFileServices(fileData)
+	}
+	a.metrics.recordUpload(status, upload)
+	fileData.touch()
+	a.requestEviction()
+
+	if status != uploadDuplicate {
+		a.checkWatchlist(fileID, fileData)
+		a.persistFile(fileID, fileData)
+		a.indexFile(fileID, fileData)
+	}
+
+	return fileData, status, nil
+}
+
+// GetConnections returns all connections with optional filtering. The search backend answers
+// for an evicted current dataset it indexed. With file_id=all or files, the connections of
+// those datasets are combined, each with the file ID it came from in dataset. With sample,
+// a representative subset is returned along with the number of matches.
+func (a *API) GetConnections(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters for filtering
 	query := r.URL.Query()
-	startTime := query.Get("start")
-	endTime := query.Get("end")
-	protocol := query.Get("protocol")
-	connState := query.Get("conn_state")
+	fileIDs, err := a.queryDatasets(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if fileID := a.currentDataset(r); a.searchServes(query, a.files[fileID]) {
+		a.searchConnections(w, r, fileID)
+
+		return
+	}
+
+	filteredConnections, err := a.filterQuery(r, fileIDs, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	matching := len(filteredConnections)
+	filteredConnections, sample, err := a.sampleConnections(filteredConnections, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	offset, limit := parseLimit(query, "offset"), parseLimit(query, "limit")
+	if format := query.Get("format"); format == zjsonFormat || format == zngFormat {
+		filteredConnections = filteredConnections[min(offset, len(filteredConnections)):]
+		writeZed(w, format, filteredConnections, limit, query.Get("download") == "true")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Wrap the result in a paging envelope only when paging, fields, or a sample were
+	// requested, or the result is too large to return whole
+	var payload any
+	fields := connfilter.SplitList(query.Get("fields"))
+	page, bounded := a.boundPage(filteredConnections, offset, limit)
+	switch {
+	case bounded || limit > 0 || offset > 0 || len(fields) > 0 || sample != nil:
+		if !bounded {
+			page = pageConnections(filteredConnections, offset, limit)
+		}
+		if sample != nil {
+			page.Total, page.Sample = matching, sample // Sampled from all matches, paged within the sample
+		}
+		if len(fields) > 0 {
+			err := projectConnections(&page, fields, a.annotator())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 
-	filteredConnections := a.getCurrentConnections()
-	filteredConnections = applyTimeFilter(filteredConnections, startTime, endTime)
-	filteredConnections = applyProtocolFilter(filteredConnections, protocol)
-	filteredConnections = applyConnStateFilter(filteredConnections, connState)
+				return
+			}
+		} else if connections, ok := page.Connections.([]models.Connection); ok {
+			page.Connections = a.annotateConnections(connections)
+		}
+		payload = page
+	default:
+		payload = a.annotateConnections(filteredConnections)
+	}
 
-	err := json.NewEncoder(w).Encode(filteredConnections)
+	err = json.NewEncoder(w).Encode(payload)
 	if err != nil {
 		log.Printf("Failed to encode connections: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// GetNodes returns network nodes for graph visualization.
+// writeZed streams connections as ZJSON or ZNG, by format, optionally capped and served as a
+// download.
+func writeZed(w http.ResponseWriter, format string, connections []models.Connection, limit int, download bool) {
+	if limit > 0 && len(connections) > limit {
+		connections = connections[:limit]
+	}
+
+	write, contentType := models.WriteZJSON, "application/x-ndjson"
+	if format == zngFormat {
+		write, contentType = models.WriteZNG, "application/x-zng"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if download {
+		w.Header().Set("Content-Disposition", `attachment; filename="connections.`+format+`"`)
+	}
+
+	err := write(w, connections)
+	if err != nil {
+		log.Printf("Failed to encode %s: %v", format, err)
+	}
+}
+
+// CountConnections returns the number of connections matching the filters without the records themselves.
+func (a *API) CountConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fileID := a.currentDataset(r)
+	if fileData := a.files[fileID]; a.searchServes(r.URL.Query(), fileData) {
+		a.searchCount(w, r, fileID, fileData.connectionCount())
+
+		return
+	}
+
+	connections := a.getCurrentConnections(r)
+	matching, err := a.filterCurrentConnections(r, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	response := map[string]any{
+		"count": len(matching),
+		"total": len(connections),
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode count: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetNodes returns network nodes for graph visualization. With file_id=all or files, the
+// graph combines those datasets and lists the datasets of each node and edge.
 func (a *API) GetNodes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	graph, err := a.networkGraph(r)
+	if errors.Is(err, errDatasetNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(graph)
+	if err != nil {
+		log.Printf("Failed to encode graph: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// networkGraph builds the graph /api/nodes returns for the request: the filtered nodes and
+// edges, pruned, limited, annotated, analyzed, and laid out. Errors are invalid query
+// parameters, and errDatasetNotFound for unknown datasets in files.
+func (a *API) networkGraph(r *http.Request) (models.NetworkGraph, error) {
 	// Parse query parameters for filtering (same as GetConnections)
 	query := r.URL.Query()
-	startTime := query.Get("start")
-	endTime := query.Get("end")
-	protocol := query.Get("protocol")
-	connState := query.Get("conn_state")
 
-	connections := a.getCurrentConnections()
-	connections = applyTimeFilter(connections, startTime, endTime)
-	connections = applyProtocolFilter(connections, protocol)
-	connections = applyConnStateFilter(connections, connState)
+	grouping, err := parseSubnetGrouping(query)
+	if err != nil {
+		return models.NetworkGraph{}, err
+	}
+	grouping.systems, err = a.asnGrouping(query.Get("group_by"))
+	if err != nil {
+		return models.NetworkGraph{}, err
+	}
+	layout, err := parseGraphLayout(query.Get("layout"))
+	if err != nil {
+		return models.NetworkGraph{}, err
+	}
+	aggregation, err := parseEdgeAggregation(query.Get("edge_by"))
+	if err != nil {
+		return models.NetworkGraph{}, err
+	}
+
+	fileIDs, err := a.queryDatasets(query)
+	if err != nil {
+		return models.NetworkGraph{}, err
+	}
 
-	nodes, edges := buildNodesAndEdges(connections)
+	var nodes []models.Node
+	var edges []models.Edge
+	var scans []Scan
+	currentFile := a.files[a.currentDataset(r)]
+	if fileIDs == nil && currentFile != nil && isUnfiltered(query) && !grouping.enabled() && aggregation == netgraph.ByProtocol {
+		nodes, edges = currentFile.graph(a.localNetworks)
+		scans = currentFile.scans()
+	} else {
+		connections, err := a.filterQuery(r, fileIDs, query)
+		if err != nil {
+			return models.NetworkGraph{}, err
+		}
+		if grouping.enabled() {
+			nodes, edges = buildSubnetGraph(connections, grouping, a.localNetworks, aggregation)
+		} else {
+			nodes, edges = netgraph.NodesAndEdges(connections, a.localNetworks, aggregation)
+			scans = detectScans(connections, defaultScanThresholds())
+		}
+	}
 
+	nodes, suppressed := suppressNodeFindings(nodes, a.suppressions)
 	graph := models.NetworkGraph{
-		Nodes: nodes,
-		Edges: edges,
+		Nodes:              nodes,
+		Edges:              edges,
+		TotalNodes:         len(nodes),
+		TotalEdges:         len(edges),
+		SuppressedFindings: suppressed,
 	}
 
-	err := json.NewEncoder(w).Encode(graph)
+	pruneEdges(&graph, parseLimit(query, "min_edge_count"), parseLimit(query, "min_edge_bytes"))
+	pruneNodes(&graph, parseLimit(query, "min_connections"))
+
+	err = limitNodes(&graph, query.Get("sort"), parseLimit(query, "limit"))
 	if err != nil {
-		log.Printf("Failed to encode graph: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return models.NetworkGraph{}, err
 	}
+	limitEdges(&graph, parseLimit(query, "edge_limit"))
+	graph.Nodes = a.annotateLocations(graph.Nodes)
+	a.annotateGraphThreats(&graph)
+	a.annotateGraphScans(&graph, scans)
+	graph.Nodes = a.annotateNodes(graph.Nodes)
+	if query.Get("hostnames") != "false" {
+		graph.Nodes = a.annotateHostnames(r.Context(), graph.Nodes)
+	}
+	if query.Get("analytics") == "true" {
+		analyzeGraph(&graph)
+	}
+	layoutGraph(&graph, layout)
+
+	return graph, nil
 }
 
-// GetTimeline returns timeline data for temporal visualization.
+// GetTimeline returns timeline data for temporal visualization, narrowed by the standard filters.
+// The bucket size is configurable, and group_by adds one series per protocol, service, or
+// connection state. With file_id=all or files, the timeline combines those datasets, with one
+// series per dataset unless group_by is given.
 func (a *API) GetTimeline(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	connections := a.getCurrentConnections()
-	if len(connections) == 0 {
-		err := json.NewEncoder(w).Encode(models.TimelineData{Points: []models.TimelinePoint{}})
-		if err != nil {
-			log.Printf("Failed to encode timeline data: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+	query := r.URL.Query()
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		return
 	}
+	groupBy, key, err := parseTimelineGroupBy(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
-	// Sort connections by timestamp
-	sortedConns := make([]models.Connection, len(connections))
-	copy(sortedConns, connections)
-	sort.Slice(sortedConns, func(i, j int) bool {
-		return sortedConns[i].Timestamp < sortedConns[j].Timestamp
-	})
+		return
+	}
+	fileIDs, err := a.queryDatasets(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 
-	startTime := int64(sortedConns[0].Timestamp)
-	endTime := int64(sortedConns[len(sortedConns)-1].Timestamp)
+		return
+	}
 
-	// Create time buckets (better granularity)
-	bucketSize := int64(timelineBucketSec) // Time bucket size in seconds
-	timelineMap := make(map[int64]*models.TimelinePoint)
+	var connections []models.Connection
+	currentFile := a.files[a.currentDataset(r)]
+	switch {
+	case fileIDs != nil:
+		connections, err = a.filterDatasets(fileIDs, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 
-	// Populate buckets with connection data directly
-	for _, conn := range sortedConns {
-		bucket := (int64(conn.Timestamp) / bucketSize) * bucketSize
-		if point, exists := timelineMap[bucket]; exists {
-			point.Count++
-			point.Bytes += conn.TotalBytes()
-		} else {
-			timelineMap[bucket] = &models.TimelinePoint{
-				Timestamp: bucket,
-				Count:     1,
-				Bytes:     conn.TotalBytes(),
+			return
+		}
+		if groupBy == "" {
+			groupBy = "dataset"
+			key, _ = models.StringFieldAccessor(groupBy)
+		}
+	case currentFile != nil:
+		connections = currentFile.Connections
+		if !isUnfiltered(query) {
+			connections, err = a.filterFile(currentFile, query)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+
+				return
 			}
 		}
 	}
+	bucketSize, err := parseTimelineBucket(query, connections)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
-	// Convert map to sorted slice
-	points := make([]models.TimelinePoint, 0, len(timelineMap))
-	for _, point := range timelineMap {
-		points = append(points, *point)
+		return
 	}
 
-	sort.Slice(points, func(i, j int) bool {
-		return points[i].Timestamp < points[j].Timestamp
-	})
-
-	timeline := models.TimelineData{
-		Points: points,
-		Start:  startTime,
-		End:    endTime,
+	timeline := &models.TimelineData{Points: []models.TimelinePoint{}, BucketSize: bucketSize}
+	switch {
+	case fileIDs == nil && currentFile == nil:
+	case fileIDs == nil && isUnfiltered(query):
+		cached := *currentFile.timeline(bucketSize, loc) // Shared; only the copy gets series
+		timeline = &cached
+	default:
+		timeline = buildTimeline(connections, bucketSize, loc)
+		scoreTimeline(timeline, loc)
+		localizeTimeline(timeline, loc)
+	}
+	if groupBy != "" {
+		timeline.GroupBy = groupBy
+		timeline.Series = buildTimelineSeries(connections, bucketSize, loc, key)
+		for i := range timeline.Series {
+			localizePoints(timeline.Series[i].Points, loc)
+		}
 	}
 
-	err := json.NewEncoder(w).Encode(timeline)
+	err = json.NewEncoder(w).Encode(timeline)
 	if err != nil {
 		log.Printf("Failed to encode timeline: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// getConnStateDescription returns a human-readable description for connection states.
-func getConnStateDescription(state string) string {
-	descriptions := map[string]string{
-		"SF":     "Normal Established - Successful connection that was properly closed",
-		"S0":     "Connection Attempt Rejected - Initial SYN was not acknowledged",
-		"S1":     "Connection Established, Not Terminated - Connection established but not cleanly closed",
-		"S2":     "Connection Established, Originator Aborted - Connection established but originator aborted",
-		"S3":     "Connection Established, Responder Aborted - Connection established but responder aborted",
-		"REJ":    "Connection Rejected - Connection attempt was explicitly rejected",
-		"RSTO":   "Connection Reset by Originator - Originator sent RST",
-		"RSTR":   "Connection Reset by Responder - Responder sent RST",
-		"RSTOS0": "Originator Sent SYN+RST - Connection attempt with immediate reset",
-		"RSTRH":  "Responder Sent RST after Handshake - Reset after successful handshake",
-		"SH":     "Originator Sent SYN+FIN - Unusual SYN+FIN combination",
-		"SHR":    "Responder Sent SYN+FIN after SYN - Response with SYN+FIN",
-		"OTH":    "Other/No Further Info - No additional information available",
+// GetStats returns summary statistics. With file_id=all or files, they combine those datasets
+// and list the connections and time span of each. With bucket or group_by, a breakdown of the
+// traffic of each protocol or service over time is added (see buildStatsBreakdown).
+func (a *API) GetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	loc, err := parseTimezone(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
 	}
+	fileIDs, err := a.queryDatasets(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 
-	if desc, exists := descriptions[state]; exists {
-		return desc
+		return
 	}
 
-	return state + " - Unknown connection state"
-}
+	var datasets []fileCoverage
+	fileStats := a.getCurrentStats(r)
+	switch {
+	case fileIDs != nil:
+		fileStats, datasets = a.datasetStats(fileIDs, connfilter.ExcludesNoise(r.URL.Query()))
+	case connfilter.ExcludesNoise(r.URL.Query()):
+		fileStats = connstats.Compute(connfilter.ApplyNoise(a.getCurrentConnections(r), true))
+	}
 
-// GetStats returns summary statistics.
-func (a *API) GetStats(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	stats := connstats.Summary(fileStats, connstats.Options{Location: loc, Humanize: wantsHumanize(r.URL.Query())})
 
-	connections := a.getCurrentConnections()
-	protocols, services, connStates, uniqueIPs, totalBytes, startTime, endTime := processConnectionStats(connections)
+	if wantsBreakdown(r.URL.Query()) {
+		breakdown, err := buildStatsBreakdown(a.statsConnections(r, fileIDs), r.URL.Query(), loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 
-	stats := map[string]any{
-		"total_connections": len(connections),
-		"protocols":         protocols,
-		"services":          services,
-		"conn_states":       connStates,
-		"total_bytes":       totalBytes,
-		"unique_ip_count":   len(uniqueIPs),
-		"time_range": map[string]any{
-			"start":    startTime,
-			"end":      endTime,
-			"duration": endTime - startTime,
-		},
+			return
+		}
+		stats["breakdown"] = breakdown
 	}
 
-	stats["available_conn_states"] = buildConnStateDescriptions(connStates)
-
 	// Add file information to stats
-	if a.currentFileID != "" && a.files[a.currentFileID] != nil {
-		currentFile := a.files[a.currentFileID]
+	if fileIDs != nil {
+		stats["datasets"] = datasets
+	} else if current := a.currentDataset(r); a.files[current] != nil {
+		currentFile := a.files[current]
 		stats["current_file"] = map[string]any{
-			"id":          a.currentFileID,
+			"id":          current,
 			"filename":    currentFile.Filename,
 			"upload_time": currentFile.UploadTime,
 			"size":        currentFile.Size,
@@ -368,66 +746,97 @@ func (a *API) GetStats(w http.ResponseWriter, r *http.Request) {
 	}
 	stats["total_files"] = len(a.files)
 
-	err := json.NewEncoder(w).Encode(stats)
+	err = json.NewEncoder(w).Encode(stats)
 	if err != nil {
 		log.Printf("Failed to encode stats: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// GetFiles returns list of all uploaded files.
+// statsConnections returns the connections /api/stats summarizes: those of the datasets
+// fileIDs lists, or of the current dataset without them, without noise with exclude_noise.
+func (a *API) statsConnections(r *http.Request, fileIDs []string) []models.Connection {
+	connections := a.getCurrentConnections(r)
+	if fileIDs != nil {
+		connections = nil
+		for _, fileID := range fileIDs {
+			connections = append(connections, a.files[fileID].Connections...)
+		}
+	}
+
+	return connfilter.ApplyNoise(connections, connfilter.ExcludesNoise(r.URL.Query()))
+}
+
+// GetFiles returns the list of uploaded files, optionally filtered, sorted, and paginated.
 func (a *API) GetFiles(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	type FileInfo struct {
-		ID              string `json:"id"`
-		Filename        string `json:"filename"`
-		UploadTime      int64  `json:"upload_time"` //nolint:tagliatelle // API compatibility
-		Size            int64  `json:"size"`
-		ConnectionCount int    `json:"connection_count"` //nolint:tagliatelle // API compatibility
-		IsCurrent       bool   `json:"is_current"`       //nolint:tagliatelle // API compatibility
-	}
+	a.syncStore(r.Context())
 
+	current := a.currentDataset(r)
 	files := make([]FileInfo, 0, len(a.files))
 	for fileID, fileData := range a.files {
-		files = append(files, FileInfo{
-			ID:              fileID,
-			Filename:        fileData.Filename,
-			UploadTime:      fileData.UploadTime,
-			Size:            fileData.Size,
-			ConnectionCount: len(fileData.Connections),
-			IsCurrent:       fileID == a.currentFileID,
-		})
-	}
-
-	// Sort by upload time (most recent first)
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].UploadTime > files[j].UploadTime
-	})
+		files = append(files, a.fileInfo(fileID, fileData, current))
+	}
+	a.markExpiry(files)
+
+	query := r.URL.Query()
+	files = filterFileInfos(files, query.Get("name"), query.Get("tag"))
+
+	err := sortFileInfos(files, query.Get("sort"), query.Get("order"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
+		return
+	}
+
+	matching := len(files)
+	offset, limit := parseLimit(query, "offset"), parseLimit(query, "limit")
+	files = paginateFileInfos(files, offset, limit)
+
+	memory, loaded := a.memoryUsage()
 	response := map[string]any{
-		"files":        files,
-		"current_file": a.currentFileID,
-		"total_files":  len(files),
+		"files":          files,
+		"current_file":   current,
+		"total_files":    len(a.files),
+		"matching_files": matching,
+		"offset":         offset,
+		"loaded_files":   loaded,
+		"memory_bytes":   memory,
+	}
+	if a.memoryLimit > 0 {
+		response["memory_limit"] = a.memoryLimit
+	}
+	if a.maxLoaded > 0 {
+		response["max_loaded_files"] = a.maxLoaded
+	}
+	if quota := a.quotaStatus(); quota != nil {
+		response["quota"] = quota
+	}
+	if a.retention.enabled() {
+		response["retention"] = a.retention.settings()
+	}
+	if pending := a.storePending.Load(); pending > 0 {
+		response["loading_files"] = pending
+	}
+	if limit > 0 {
+		response["limit"] = limit
 	}
 
-	err := json.NewEncoder(w).Encode(response)
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		log.Printf("Failed to encode response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// SwitchFile changes the currently active file.
+// SwitchFile changes the currently active file to the one named by file_id in the JSON body,
+// as POST /api/files/{id}/select does.
 func (a *API) SwitchFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 
+	a.syncStore(r.Context())
+
 	// Parse JSON body
 	var request struct {
 		FileID string `json:"file_id"` //nolint:tagliatelle // API compatibility
@@ -447,42 +856,52 @@ func (a *API) SwitchFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if a.files[request.FileID] == nil {
+	a.switchFile(w, r, request.FileID)
+}
+
+// switchFile makes a file the active one of the request's workspace and answers with it.
+func (a *API) switchFile(w http.ResponseWriter, r *http.Request, fileID string) {
+	switch fileData := a.reloadFile(r.Context(), fileID); {
+	case fileData == nil:
 		http.Error(w, "File not found", http.StatusNotFound)
 
+		return
+	case fileData.unloaded != nil:
+		http.Error(w, errUnloadedDataset.Error(), http.StatusServiceUnavailable)
+
 		return
 	}
 
-	// Switch to the requested file
-	a.currentFileID = request.FileID
-	currentFile := a.files[request.FileID]
+	// Switch to the requested file and precompute its derived data
+	if workspaceID(r) == "" {
+		w.Header().Set("Deprecation", "true") // Switching the server-wide selection affects every client without a workspace
+	}
+	a.setCurrentDataset(r, fileID)
+	currentFile := a.files[fileID]
+	currentFile.warmCaches(a.localNetworks)
 
 	log.Printf("Switched to file: %s (ID: %s, %d connections)",
-		currentFile.Filename, request.FileID, len(currentFile.Connections))
+		currentFile.Filename, fileID, len(currentFile.Connections))
 
 	response := map[string]any{
 		"success":           true,
 		"message":           "Switched to " + currentFile.Filename,
-		"current_file":      request.FileID,
+		"current_file":      fileID,
 		"filename":          currentFile.Filename,
 		"connections_count": len(currentFile.Connections),
+		"cache_status":      currentFile.cacheStatus(),
 	}
 
-	err = json.NewEncoder(w).Encode(response)
+	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
 		log.Printf("Failed to encode response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// DeleteFile removes a file from memory.
+// DeleteFile removes the file named by file_id in the JSON body, as DELETE /api/files/{id}
+// does.
 func (a *API) DeleteFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 
 	// Parse JSON body
@@ -504,7 +923,14 @@ func (a *API) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if a.files[request.FileID] == nil {
+	a.deleteFile(w, r, request.FileID)
+}
+
+// deleteFile removes a file, switching to another one if it was the active file, and
+// answers with the remaining files. Workspaces that selected it read the server-wide
+// selection again.
+func (a *API) deleteFile(w http.ResponseWriter, r *http.Request, fileID string) {
+	if a.files[fileID] == nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 
 		return
@@ -518,159 +944,157 @@ func (a *API) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get filename before deletion
-	filename := a.files[request.FileID].Filename
+	filename := a.files[fileID].Filename
+	a.removeFile(fileID)
 
-	// Delete the file
-	delete(a.files, request.FileID)
-
-	// If this was the current file, switch to another one
-	if a.currentFileID == request.FileID {
-		// Find another file to switch to
-		for fileID := range a.files {
-			a.currentFileID = fileID
-
-			break
-		}
-	}
-
-	log.Printf("Deleted file: %s (ID: %s)", filename, request.FileID)
+	log.Printf("Deleted file: %s (ID: %s)", filename, fileID)
 
 	response := map[string]any{
 		"success":      true,
 		"message":      "Deleted " + filename,
-		"current_file": a.currentFileID,
+		"current_file": a.currentDataset(r),
 		"total_files":  len(a.files),
 	}
 
-	err = json.NewEncoder(w).Encode(response)
+	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
 		log.Printf("Failed to encode response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// processNode updates or creates a node in the nodeMap.
-func processNode(nodeMap map[string]*models.Node, host string, totalBytes int) {
-	if _, exists := nodeMap[host]; !exists {
-		nodeMap[host] = &models.Node{
-			ID:      host,
-			Label:   host,
-			IsLocal: models.IsLocalIP(host),
+// removeFile deletes a dataset from memory, the store, and the search backend, switching the
+// server to another dataset when it was the current one. Callers must hold a.mu.
+func (a *API) removeFile(fileID string) {
+	a.files[fileID].release()
+	delete(a.files, fileID)
+	a.deleteStoredFile(fileID)
+	a.deleteSearchIndex(fileID)
+	a.forgetWorkspaceSelections(fileID)
+
+	// If this was the current file, switch to another one
+	if a.currentFileID == fileID {
+		a.currentFileID = ""
+		// Find another file to switch to
+		for fileID := range a.files {
+			a.currentFileID = fileID
+
+			break
 		}
+		a.publishCurrentFile()
 	}
-	nodeMap[host].Connections++
-	nodeMap[host].TotalBytes += totalBytes
 }
 
-// processEdge updates or creates an edge in the edgeMap.
-func processEdge(edgeMap map[string]*models.Edge, conn models.Connection) {
-	edgeKey := fmt.Sprintf("%s-%s-%s", conn.OrigHost, conn.RespHost, conn.Protocol)
-
-	if _, exists := edgeMap[edgeKey]; !exists {
-		edgeMap[edgeKey] = &models.Edge{
-			Source:   conn.OrigHost,
-			Target:   conn.RespHost,
-			Protocol: conn.Protocol,
-			Service:  conn.Service,
+// setConnections replaces the file's connections and statistics and drops any cached derived data.
+// With flow stitching on, the connections are stitched, and kept as logged alongside.
+func (f *FileData) setConnections(connections []models.Connection, stats *models.ConnectionStats) {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	f.unstitched = nil
+	if f.StitchGap > 0 {
+		stitched, merged := stitchConnections(connections, f.StitchGap)
+		if merged > 0 {
+			f.unstitched = connections
+			connections, stats = stitched, connstats.Compute(stitched)
 		}
 	}
-	edgeMap[edgeKey].Count++
-	edgeMap[edgeKey].TotalBytes += conn.TotalBytes()
-	edgeMap[edgeKey].Weight = float64(edgeMap[edgeKey].TotalBytes) / bytesScaleFactor
+
+	f.Connections = connections
+	f.Stats = stats
+	f.version++
+	f.rollups = nil
+	f.spanKnown = false
+	f.memory = connectionsMemory(connections) + connectionsMemory(f.unstitched)
+	f.unloaded = nil
+	f.invalidateCaches()
 }
 
-// buildNodesAndEdges processes connections to build the network graph data.
-func buildNodesAndEdges(connections []models.Connection) ([]models.Node, []models.Edge) {
-	nodeMap := make(map[string]*models.Node)
-	edgeMap := make(map[string]*models.Edge)
+// release frees resources held by the file's caches.
+func (f *FileData) release() {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
 
-	for _, conn := range connections {
-		totalBytes := conn.TotalBytes()
-		processNode(nodeMap, conn.OrigHost, totalBytes)
-		processNode(nodeMap, conn.RespHost, totalBytes)
-		processEdge(edgeMap, conn)
-	}
+	f.timelineCache = nil
+	f.graphCache = nil
+	f.scanCache = nil
+	f.index = nil
+	f.closeSQLDatabase()
+}
+
+// timeline returns the bucketed timeline for the given bucket size, computing it on first use.
+func (f *FileData) timeline(bucketSize int64, loc *time.Location) *models.TimelineData {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
 
-	// Convert maps to slices
-	nodes := make([]models.Node, 0, len(nodeMap))
-	for _, node := range nodeMap {
-		nodes = append(nodes, *node)
+	key := timelineKey{bucketSize: bucketSize, timezone: timezoneName(loc)}
+	if cached, exists := f.timelineCache[key]; exists {
+		return cached
 	}
 
-	edges := make([]models.Edge, 0, len(edgeMap))
-	for _, edge := range edgeMap {
-		edges = append(edges, *edge)
+	timeline := buildTimeline(f.Connections, bucketSize, loc)
+	mergeRollups(timeline, f.rollups, bucketSize, loc)
+	scoreTimeline(timeline, loc)
+	localizeTimeline(timeline, loc)
+	if f.timelineCache == nil {
+		f.timelineCache = make(map[timelineKey]*models.TimelineData)
+	}
+	if len(f.timelineCache) < maxCachedTimelines {
+		f.timelineCache[key] = timeline
 	}
 
-	return nodes, edges
+	return timeline
 }
 
-// processConnectionStats processes connections and calculates statistics.
-func processConnectionStats(connections []models.Connection) (
-	map[string]int, map[string]int, map[string]int, map[string]bool, int, float64, float64,
-) {
-	protocols := make(map[string]int)
-	services := make(map[string]int)
-	connStates := make(map[string]int)
-	uniqueIPs := make(map[string]bool)
-
-	var totalBytes int
-	var startTime, endTime float64 = -1, -1
-
-	for _, conn := range connections {
-		// Protocol distribution
-		protocols[conn.Protocol]++
-
-		// Service distribution
-		if conn.Service != "" {
-			services[conn.Service]++
-		}
+// buildTimeline groups connections into fixed-size time buckets, aligned to loc when given.
+func buildTimeline(connections []models.Connection, bucketSize int64, loc *time.Location) *models.TimelineData {
+	if len(connections) == 0 {
+		return &models.TimelineData{Points: []models.TimelinePoint{}, BucketSize: bucketSize}
+	}
 
-		// Connection state distribution
-		connStates[conn.ConnState]++
+	// Sort connections by timestamp
+	sortedConns := make([]models.Connection, len(connections))
+	copy(sortedConns, connections)
+	sort.Slice(sortedConns, func(i, j int) bool {
+		return sortedConns[i].Timestamp < sortedConns[j].Timestamp
+	})
 
-		// Unique IPs
-		uniqueIPs[conn.OrigHost] = true
-		uniqueIPs[conn.RespHost] = true
+	startTime := int64(sortedConns[0].Timestamp)
+	endTime := int64(sortedConns[len(sortedConns)-1].Timestamp)
 
-		// Total bytes
-		totalBytes += conn.TotalBytes()
+	timelineMap := make(map[int64]*models.TimelinePoint)
 
-		// Time range
-		if startTime == -1 || conn.Timestamp < startTime {
-			startTime = conn.Timestamp
-		}
-		if endTime == -1 || conn.Timestamp > endTime {
-			endTime = conn.Timestamp
+	// Populate buckets with connection data directly
+	for _, conn := range sortedConns {
+		bucket := bucketStart(int64(conn.Timestamp), bucketSize, loc)
+		if point, exists := timelineMap[bucket]; exists {
+			point.Count++
+			point.Bytes += conn.TotalBytes()
+		} else {
+			timelineMap[bucket] = &models.TimelinePoint{
+				Timestamp: bucket,
+				Count:     1,
+				Bytes:     conn.TotalBytes(),
+			}
 		}
 	}
 
-	return protocols, services, connStates, uniqueIPs, totalBytes, startTime, endTime
-}
-
-// buildConnStateDescriptions builds the available connection states with descriptions.
-func buildConnStateDescriptions(connStates map[string]int) []map[string]any {
-	availableStates := make([]map[string]any, 0)
-	for state, count := range connStates {
-		availableStates = append(availableStates, map[string]any{
-			"code":        state,
-			"description": getConnStateDescription(state),
-			"count":       count,
-		})
+	// Convert map to sorted slice
+	points := make([]models.TimelinePoint, 0, len(timelineMap))
+	for _, point := range timelineMap {
+		points = append(points, *point)
 	}
 
-	// Sort by count (descending)
-	sort.Slice(availableStates, func(i, j int) bool {
-		countI, okI := availableStates[i]["count"].(int)
-		countJ, okJ := availableStates[j]["count"].(int)
-		if !okI || !okJ {
-			return false
-		}
-
-		return countI > countJ
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
 	})
 
-	return availableStates
+	return &models.TimelineData{
+		Points:     points,
+		Start:      startTime,
+		End:        endTime,
+		BucketSize: bucketSize,
+	}
 }
 
 // generateFileID creates a unique ID for a file based on name and upload time.
@@ -681,67 +1105,33 @@ func (a *API) generateFileID(filename string, uploadTime int64) string {
 	return hex.EncodeToString(hash[:])[:fileIDLength] // Use first 16 characters
 }
 
-// getCurrentConnections returns connections from the currently selected file.
-func (a *API) getCurrentConnections() []models.Connection {
-	if a.currentFileID == "" || a.files[a.currentFileID] == nil {
-		return []models.Connection{}
-	}
-
-	return a.files[a.currentFileID].Connections
-}
-
-// applyTimeFilter applies time-based filtering to connections.
-func applyTimeFilter(connections []models.Connection, startTime, endTime string) []models.Connection {
-	if startTime == "" || endTime == "" {
-		return connections
-	}
-
-	start, err1 := strconv.ParseInt(startTime, 10, 64)
-	end, err2 := strconv.ParseInt(endTime, 10, 64)
-
-	if err1 != nil || err2 != nil {
-		return connections
+// newFileID returns an ID based on name and upload time that no file has yet, so uploads of
+// a name within the same second get IDs of their own. Callers must hold a.mu.
+func (a *API) newFileID(filename string, uploadTime int64) string {
+	fileID := a.generateFileID(filename, uploadTime)
+	for n := 1; a.files[fileID] != nil; n++ {
+		fileID = a.generateFileID(fmt.Sprintf("%s#%d", filename, n), uploadTime)
 	}
 
-	var filtered []models.Connection
-	for _, conn := range connections {
-		ts := int64(conn.Timestamp)
-		if ts >= start && ts <= end {
-			filtered = append(filtered, conn)
-		}
-	}
-
-	return filtered
+	return fileID
 }
 
-// applyProtocolFilter applies protocol-based filtering to connections.
-func applyProtocolFilter(connections []models.Connection, protocol string) []models.Connection {
-	if protocol == "" || protocol == allProtocol {
-		return connections
-	}
-
-	var filtered []models.Connection
-	for _, conn := range connections {
-		if conn.Protocol == protocol {
-			filtered = append(filtered, conn)
-		}
+// getCurrentConnections returns connections from the request's current file.
+func (a *API) getCurrentConnections(r *http.Request) []models.Connection {
+	fileData := a.files[a.currentDataset(r)]
+	if fileData == nil {
+		return []models.Connection{}
 	}
 
-	return filtered
+	return fileData.Connections
 }
 
-// applyConnStateFilter applies connection state filtering to connections.
-func applyConnStateFilter(connections []models.Connection, connState string) []models.Connection {
-	if connState == "" || connState == allProtocol {
-		return connections
-	}
-
-	var filtered []models.Connection
-	for _, conn := range connections {
-		if conn.ConnState == connState {
-			filtered = append(filtered, conn)
-		}
+// getCurrentStats returns the ingest-time statistics of the request's current file.
+func (a *API) getCurrentStats(r *http.Request) *models.ConnectionStats {
+	fileData := a.files[a.currentDataset(r)]
+	if fileData == nil || fileData.Stats == nil {
+		return models.NewConnectionStats()
 	}
 
-	return filtered
+	return fileData.Stats
 }
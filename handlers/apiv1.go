@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	v1Prefix        = "/api/v1"
+	maxErrorBody    = 1 << 20 // 1MB of an error response kept for its envelope
+	jsonContentType = "application/json"
+)
+
+// v1Route is an endpoint of the versioned API. The same table registers the handlers and
+// generates the OpenAPI document, so the two can't drift apart.
+type v1Route struct {
+	pattern     string           // Method and path, as registered with http.ServeMux
+	operationID string           // Stable name for generated clients
+	summary     string           // One-line description
+	tag         string           // Group in the OpenAPI document
+	handler     http.HandlerFunc // Handler, wrapped like the unversioned route
+	params      []string         // Query parameters; "filters" stands for the connection filters
+	body        string           // Media type of the request body, if it takes one
+	upload      string           // Multipart field carrying the file of multipart bodies
+	response    string           // Media type of successful responses, JSON when empty
+	created     bool             // Success creates a resource and answers 201
+}
+
+// v1Error is the body of every failed /api/v1 response.
+type v1Error struct {
+	Status  int             `json:"status"`            // HTTP status code
+	Code    string          `json:"code"`              // Machine-readable reason
+	Message string          `json:"message"`           // Human-readable description
+	Details json.RawMessage `json:"details,omitempty"` // Structured error of the endpoint, if it has one
+}
+
+// v1Writer turns the responses of the unversioned handlers into /api/v1 ones: errors are
+// held back and rewritten as an error envelope, and successes of routes that create a
+// resource answer 201.
+type v1Writer struct {
+	http.ResponseWriter
+
+	created     bool
+	wroteHeader bool
+	status      int
+	body        bytes.Buffer
+}
+
+// WriteHeader forwards success statuses and holds back errors for their envelope.
+func (v *v1Writer) WriteHeader(status int) {
+	if v.wroteHeader {
+		return
+	}
+	v.wroteHeader = true
+	v.status = status
+	if status >= http.StatusBadRequest {
+		return
+	}
+	if status == http.StatusOK && v.created {
+		status = http.StatusCreated
+	}
+	v.ResponseWriter.WriteHeader(status)
+}
+
+// Write forwards the body of successes and keeps the body of errors.
+func (v *v1Writer) Write(data []byte) (int, error) {
+	if !v.wroteHeader {
+		v.WriteHeader(http.StatusOK)
+	}
+	if v.status < http.StatusBadRequest {
+		return v.ResponseWriter.Write(data) //nolint:wrapcheck // Transparent wrapper
+	}
+	if v.body.Len() < maxErrorBody {
+		v.body.Write(data)
+	}
+
+	return len(data), nil
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can flush event streams
+// and extend deadlines through the writer.
+func (v *v1Writer) Unwrap() http.ResponseWriter {
+	return v.ResponseWriter
+}
+
+// finish sends the envelope of an error response.
+func (v *v1Writer) finish() {
+	if v.status < http.StatusBadRequest {
+		return
+	}
+
+	envelope := v1Error{Status: v.status, Code: statusCode(v.status), Message: strings.TrimSpace(v.body.String())}
+	if strings.HasPrefix(v.Header().Get("Content-Type"), jsonContentType) && json.Valid(v.body.Bytes()) {
+		envelope.Details = bytes.Clone(v.body.Bytes())
+		envelope.Code, envelope.Message = detailedError(envelope.Details, envelope.Code)
+	}
+	if envelope.Message == "" {
+		envelope.Message = http.StatusText(v.status)
+	}
+	writeV1Error(v.ResponseWriter, envelope)
+}
+
+// HandleV1 registers the versioned API and its OpenAPI document under /api/v1 on mux. The
+// endpoints are those of /api, named after resources, with JSON error envelopes, 201 for
+// created resources, and JSON 404 and 405 responses for unknown endpoints and methods.
+func (a *API) HandleV1(mux *http.ServeMux) {
+	routes := a.v1Routes()
+
+	v1 := http.NewServeMux()
+	for _, route := range routes {
+		v1.HandleFunc(route.pattern, route.handler)
+	}
+	v1.HandleFunc("GET "+v1Prefix+"/openapi.json", a.getOpenAPI(routes))
+	v1.HandleFunc(v1Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if allowed := allowedMethods(v1, r); len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+		http.Error(w, "Unknown endpoint "+r.URL.Path, http.StatusNotFound)
+	})
+
+	created := map[string]bool{}
+	for _, route := range routes {
+		created[route.pattern] = route.created
+	}
+	mux.HandleFunc(v1Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := v1.Handler(r)
+		writer := &v1Writer{ResponseWriter: w, created: created[pattern]}
+		v1.ServeHTTP(writer, r)
+		writer.finish()
+	})
+}
+
+// v1Routes returns the endpoints of the versioned API, wrapped like their /api routes.
+func (a *API) v1Routes() []v1Route {
+	return []v1Route{
+		{pattern: "GET /api/v1/config", operationID: "getConfig", summary: "Instance name and UI settings", tag: "server", handler: a.GetConfig},
+		{pattern: "GET /api/v1/me", operationID: "getMe", summary: "The authenticated user", tag: "server", handler: a.GetMe},
+
+		{pattern: "GET /api/v1/datasets", operationID: "listDatasets", summary: "Uploaded datasets", tag: "datasets", handler: a.Locked(a.GetFiles),
+			params: []string{"name", "tag", "sort", "order", "offset", "limit"}},
+		{pattern: "POST /api/v1/datasets", operationID: "uploadDataset", summary: "Upload a conn.log", tag: "datasets", handler: a.UploadFile,
+			body: "multipart/form-data", upload: "logfile", created: true},
+		{pattern: "POST /api/v1/datasets/stream", operationID: "streamDataset", summary: "Upload a conn.log as the raw request body", tag: "datasets", handler: a.StreamUpload,
+			params: []string{"filename", "mode", "dedup", "upload_id"}, body: "application/octet-stream", created: true},
+		{pattern: "GET /api/v1/uploads/{id}", operationID: "getUploadStatus", summary: "Progress of a streamed upload", tag: "datasets", handler: a.GetIngestStatus},
+		{pattern: "POST /api/v1/datasets/merge", operationID: "mergeDatasets", summary: "Merge datasets into a new one", tag: "datasets", handler: a.Locked(a.MergeFiles),
+			body: jsonContentType, created: true},
+		{pattern: "POST /api/v1/datasets/demo", operationID: "loadDemoDataset", summary: "Load the demo dataset", tag: "datasets", handler: a.Locked(a.LoadDemoData), created: true},
+		{pattern: "GET /api/v1/datasets/{id}", operationID: "getDataset", summary: "A dataset as the list describes it", tag: "datasets", handler: a.ReadLocked(a.GetFile)},
+		{pattern: "PUT /api/v1/datasets/{id}", operationID: "replaceDataset", summary: "Replace a dataset with a corrected log", tag: "datasets", handler: a.ReplaceFile,
+			body: "multipart/form-data", upload: "logfile"},
+		{pattern: "PATCH /api/v1/datasets/{id}", operationID: "updateDataset", summary: "Rename a dataset and set its description and case number", tag: "datasets", handler: a.Locked(a.UpdateFile),
+			body: jsonContentType},
+		{pattern: "DELETE /api/v1/datasets/{id}", operationID: "deleteDataset", summary: "Remove a dataset", tag: "datasets", handler: a.Locked(a.deleteDataset)},
+		{pattern: "POST /api/v1/datasets/{id}/select", operationID: "selectDataset", summary: "Make a dataset the one queries read", tag: "datasets", handler: a.Locked(a.selectDataset)},
+		{pattern: "GET /api/v1/datasets/{id}/raw", operationID: "getRawDataset", summary: "The log as uploaded", tag: "datasets", handler: a.ReadLocked(a.GetRawFile),
+			response: "application/octet-stream"},
+		{pattern: "GET /api/v1/datasets/{id}/parse-report", operationID: "getParseReport", summary: "Lines skipped while parsing a dataset", tag: "datasets", handler: a.ReadLocked(a.GetParseErrors)},
+		{pattern: "GET /api/v1/compare", operationID: "compareDatasets", summary: "Hosts and edges that differ between two datasets", tag: "datasets", handler: a.ReadLocked(a.CompareDatasets),
+			params: []string{"base", "other", "limit", "filters"}},
+
+		{pattern: "GET /api/v1/connections", operationID: "listConnections", summary: "Connections of the current dataset", tag: "connections", handler: a.SearchLocked(a.Conditional(a.GetConnections)),
+			params: []string{"filters", "file_id", "files", "limit", "offset", "fields", "sample", "sample_size", "seed", "format", "download"}},
+		{pattern: "GET /api/v1/connections/count", operationID: "countConnections", summary: "Number of matching connections", tag: "connections", handler: a.SearchLocked(a.Conditional(a.CountConnections)),
+			params: []string{"filters"}},
+		{pattern: "GET /api/v1/connections/{uid}", operationID: "getConnection", summary: "A connection with its decoded history", tag: "connections", handler: a.ReadLocked(a.GetConnection)},
+		{pattern: "GET /api/v1/connections/{uid}/details", operationID: "getConnectionDetails", summary: "HTTP requests, TLS sessions, notices, and weirds of a connection", tag: "connections", handler: a.ReadLocked(a.GetConnectionDetails)},
+		{pattern: "GET /api/v1/notices", operationID: "listNotices", summary: "Notices and weirds with their connections, hosts, and edges", tag: "connections", handler: a.ReadLocked(a.GetNotices),
+			params: []string{"kind", "start", "end", "host", "limit"}},
+		{pattern: "GET /api/v1/nodes", operationID: "getGraph", summary: "Hosts and edges of the network graph", tag: "connections", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetNodes))),
+			params: []string{"filters", "file_id", "files", "subnet_group", "subnet_group_v6", "group_by", "min_edge_count", "min_edge_bytes", "min_connections", "sort", "limit", "edge_limit", "edge_by", "hostnames", "analytics", "layout"}},
+		{pattern: "GET /api/v1/nodes/frames", operationID: "getGraphFrames", summary: "Graph of each time bucket, as deltas for animation", tag: "connections", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetGraphFrames))),
+			params: []string{"filters", "bucket", "deltas", "subnet_group", "subnet_group_v6", "edge_by"}},
+		{pattern: "GET /api/v1/nodes/{ip}/timeline", operationID: "getHostTimeline", summary: "Traffic of a host over time", tag: "connections", handler: a.ReadLocked(a.GetHostTimeline),
+			params: []string{"filters", "bucket", "tz"}},
+		{pattern: "GET /api/v1/hosts/{ip}", operationID: "getHostProfile", summary: "Profile of a host: traffic, peers, services, and timeline", tag: "connections", handler: a.ReadLocked(a.GetHostProfile),
+			params: []string{"filters", "limit", "bucket", "tz", "hostnames"}},
+		{pattern: "GET /api/v1/edges/timeline", operationID: "getEdgeTimeline", summary: "Traffic between two hosts over time", tag: "connections", handler: a.ReadLocked(a.GetEdgeTimeline),
+			params: []string{"source", "target", "bidirectional", "filters", "bucket", "tz"}},
+		{pattern: "GET /api/v1/timeline", operationID: "getTimeline", summary: "Connections per time bucket", tag: "connections", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTimeline))),
+			params: []string{"filters", "file_id", "files", "bucket", "group_by", "tz"}},
+		{pattern: "GET /api/v1/timeline/{start}", operationID: "getTimelineBucket", summary: "Connections of one timeline bucket", tag: "connections", handler: a.ReadLocked(a.GetTimelineBucket),
+			params: []string{"filters", "bucket", "tz", "limit", "offset", "fields", "include"}},
+		{pattern: "GET /api/v1/stats", operationID: "getStats", summary: "Statistics of the current dataset", tag: "connections", handler: a.ReadLocked(a.GetStats),
+			params: []string{"exclude_noise", "file_id", "files", "bucket", "group_by", "tz", "humanize"}},
+		{pattern: "GET /api/v1/stats/global", operationID: "getGlobalStats", summary: "Statistics across all datasets", tag: "connections", handler: a.ReadLocked(a.GetGlobalStats),
+			params: []string{"humanize"}},
+
+		{pattern: "GET /api/v1/aggregate", operationID: "aggregateConnections", summary: "Metrics of connections grouped by fields", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetAggregate))),
+			params: []string{"filters", "group_by", "metrics", "limit"}},
+		{pattern: "GET /api/v1/query", operationID: "queryConnections", summary: "Run a read-only SQL query", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.QueryConnections))),
+			params: []string{"sql", "limit"}},
+		{pattern: "POST /api/v1/query", operationID: "postQuery", summary: "Run a read-only SQL query sent as JSON", tag: "queries", handler: a.ReadLocked(a.QueryConnections),
+			body: jsonContentType},
+		{pattern: "GET /api/v1/pipeline", operationID: "runPipeline", summary: "Evaluate a pipeline expression", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.RunPipeline))),
+			params: []string{"q", "filters", "limit"}},
+		{pattern: "GET /api/v1/histograms", operationID: "getHistogram", summary: "Distribution of a numeric field", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetHistogram))),
+			params: []string{"field", "bins", "scale", "filters"}},
+		{pattern: "GET /api/v1/topn", operationID: "getTopN", summary: "Most frequent values of a field", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTopN))),
+			params: []string{"field", "by", "n", "filters", "humanize"}},
+		{pattern: "GET /api/v1/top", operationID: "getTop", summary: "Top talkers, services, or ports", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTop))),
+			params: []string{"by", "metric", "n", "filters", "humanize"}},
+		{pattern: "GET /api/v1/values", operationID: "getValues", summary: "Distinct values of a field with their counts", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetValues))),
+			params: []string{"field", "limit", "filters"}},
+		{pattern: "GET /api/v1/hierarchy", operationID: "getHierarchy", summary: "Traffic by network, subnet, and host", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetHierarchy))),
+			params: []string{"filters"}},
+		{pattern: "GET /api/v1/clusters", operationID: "getClusters", summary: "Hosts grouped by similar behavior", tag: "queries", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetClusters))),
+			params: []string{"k", "filters"}},
+
+		{pattern: "GET /api/v1/analysis/beacons", operationID: "findBeacons", summary: "Periodic connections typical of command and control", tag: "analysis", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetBeacons))),
+			params: []string{"min_connections", "min_interval", "min_score", "limit", "filters"}},
+		{pattern: "GET /api/v1/analysis/scans", operationID: "findScans", summary: "Port and host scans", tag: "analysis", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetScans))),
+			params: []string{"type", "window", "min_hosts", "min_ports", "limit", "filters"}},
+		{pattern: "GET /api/v1/analysis/exfil", operationID: "findExfil", summary: "Hosts sending unusually much data out", tag: "analysis", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetExfil))),
+			params: []string{"min_bytes", "min_ratio", "window", "limit", "filters", "humanize"}},
+		{pattern: "GET /api/v1/analysis/long-connections", operationID: "findLongConnections", summary: "Connections open for unusually long", tag: "analysis", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetLongConnections))),
+			params: []string{"min_duration", "include_open", "limit", "filters", "humanize"}},
+		{pattern: "GET /api/v1/analysis/anomalies", operationID: "findAnomalies", summary: "Timeline buckets with traffic spikes or drops", tag: "analysis", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetAnomalies))),
+			params: []string{"bucket", "tz", "metric", "min_score", "limit", "filters"}},
+		{pattern: "GET /api/v1/analysis/tls", operationID: "findTLSFingerprints", summary: "TLS clients clustered by JA3 or JA4 fingerprint", tag: "analysis", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTLSFingerprints))),
+			params: []string{"fingerprint", "max_clients", "limit", "filters"}},
+		{pattern: "GET /api/v1/analysis/new-hosts", operationID: "findNewHosts", summary: "Hosts and pairs not seen in earlier datasets", tag: "analysis", handler: a.ReadLocked(a.GetNewHosts),
+			params: []string{"other", "base", "limit", "filters"}},
+		{pattern: "GET /api/v1/alerts", operationID: "listAlerts", summary: "Findings posted to the alert webhook", tag: "analysis", handler: a.GetAlerts,
+			params: []string{"limit"}},
+		{pattern: "POST /api/v1/alerts/test", operationID: "testAlert", summary: "Post a test alert to the webhook", tag: "analysis", handler: a.TestAlert},
+
+		{pattern: "GET /api/v1/export", operationID: "exportConnections", summary: "Download connections as CSV or NDJSON", tag: "exports", handler: a.ReadLocked(a.ExportConnections),
+			params: []string{"format", "fields", "limit", "filters"}, response: "text/csv"},
+		{pattern: "GET /api/v1/export/graph", operationID: "exportGraph", summary: "Download the graph as GraphML, GEXF, or DOT", tag: "exports", handler: a.ReadLocked(a.ExportGraph),
+			params: []string{"format", "filters", "subnet_group", "subnet_group_v6", "group_by", "min_edge_count", "min_edge_bytes", "min_connections", "sort", "limit", "edge_limit", "edge_by", "analytics", "layout"}, response: "application/xml"},
+		{pattern: "GET /api/v1/evidence", operationID: "exportEvidence", summary: "Zip archive of selected connections for handoff", tag: "exports", handler: a.ReadLocked(a.ExportEvidence),
+			params: []string{"tag", "uid", "note", "filters"}, response: snapshotMIMEType},
+		{pattern: "GET /api/v1/report", operationID: "getReport", summary: "Printable HTML summary of the current dataset", tag: "exports", handler: a.ReadLocked(a.GetReport),
+			params: []string{"tz", "bucket", "n", "filters"}, response: "text/html"},
+		{pattern: "GET /api/v1/snapshot", operationID: "exportSnapshot", summary: "Archive of the datasets and settings", tag: "exports", handler: a.ReadLocked(a.ExportSnapshot),
+			response: snapshotMIMEType},
+		{pattern: "POST /api/v1/snapshot", operationID: "importSnapshot", summary: "Restore an archive of datasets and settings", tag: "exports", handler: a.ImportSnapshot,
+			params: []string{"replace", "restore_settings"}, body: "multipart/form-data", upload: "snapshot"},
+		{pattern: "GET /api/v1/backups", operationID: "listBackups", summary: "Backups of the server state", tag: "exports", handler: a.ListBackups},
+		{pattern: "POST /api/v1/backups", operationID: "createBackup", summary: "Back up the server state", tag: "exports", handler: a.ReadLocked(a.CreateBackup), created: true},
+		{pattern: "POST /api/v1/backups/{name}/restore", operationID: "restoreBackup", summary: "Restore a backup", tag: "exports", handler: a.RestoreBackup,
+			params: []string{"restore_settings"}},
+
+		{pattern: "GET /api/v1/live/stats", operationID: "getLiveStats", summary: "Statistics of followed logs", tag: "live", handler: a.GetLiveStats},
+		{pattern: "GET /api/v1/live/events", operationID: "getLiveEvents", summary: "Server-sent events of followed logs", tag: "live", handler: a.GetLiveEvents,
+			response: "text/event-stream"},
+		{pattern: "GET /api/v1/watch", operationID: "listTails", summary: "Logs the server follows", tag: "live", handler: a.GetTails},
+
+		{pattern: "GET /api/v1/settings", operationID: "getSettings", summary: "Server settings", tag: "settings", handler: a.ReadLocked(a.GetSettings)},
+		{pattern: "PUT /api/v1/settings", operationID: "updateSettings", summary: "Change server settings", tag: "settings", handler: a.Locked(a.UpdateSettings),
+			body: jsonContentType},
+		{pattern: "GET /api/v1/watchlist", operationID: "getWatchlist", summary: "Watchlisted addresses and their hits", tag: "settings", handler: a.ReadLocked(a.GetWatchlist)},
+		{pattern: "POST /api/v1/watchlist", operationID: "addWatchlistEntry", summary: "Watch an address or prefix", tag: "settings", handler: a.Locked(a.AddWatchlistEntry),
+			body: jsonContentType, created: true},
+		{pattern: "DELETE /api/v1/watchlist", operationID: "deleteWatchlistEntry", summary: "Stop watching an address or prefix", tag: "settings", handler: a.Locked(a.DeleteWatchlistEntry),
+			params: []string{"value"}},
+		{pattern: "GET /api/v1/intel", operationID: "getIntel", summary: "Loaded IOC lists", tag: "settings", handler: a.ReadLocked(a.GetIntel)},
+		{pattern: "POST /api/v1/intel", operationID: "uploadIntel", summary: "Load an IOC list", tag: "settings", handler: a.Locked(a.UploadIntel),
+			params: []string{"source", "format"}, body: "text/plain"},
+		{pattern: "DELETE /api/v1/intel", operationID: "deleteIntel", summary: "Remove an IOC list", tag: "settings", handler: a.Locked(a.DeleteIntel),
+			params: []string{"source"}},
+		{pattern: "GET /api/v1/suppressions", operationID: "listSuppressions", summary: "Suppressed findings", tag: "settings", handler: a.ReadLocked(a.GetSuppressions)},
+		{pattern: "POST /api/v1/suppressions", operationID: "addSuppression", summary: "Suppress findings for a rule or host", tag: "settings", handler: a.Locked(a.AddSuppression),
+			body: jsonContentType, created: true},
+		{pattern: "DELETE /api/v1/suppressions/{id}", operationID: "deleteSuppression", summary: "Remove a suppression", tag: "settings", handler: a.Locked(a.DeleteSuppression)},
+		{pattern: "GET /api/v1/views", operationID: "listViews", summary: "Saved views", tag: "settings", handler: a.ReadLocked(a.GetViews)},
+		{pattern: "POST /api/v1/views", operationID: "createView", summary: "Save a view", tag: "settings", handler: a.Locked(a.CreateView),
+			body: jsonContentType, created: true},
+		{pattern: "GET /api/v1/views/{id}", operationID: "getView", summary: "A saved view", tag: "settings", handler: a.ReadLocked(a.GetView)},
+		{pattern: "PUT /api/v1/views/{id}", operationID: "updateView", summary: "Replace a saved view", tag: "settings", handler: a.Locked(a.UpdateView),
+			body: jsonContentType},
+		{pattern: "DELETE /api/v1/views/{id}", operationID: "deleteView", summary: "Remove a saved view", tag: "settings", handler: a.Locked(a.DeleteView)},
+		{pattern: "GET /api/v1/annotations", operationID: "listAnnotations", summary: "Tags and notes on hosts and connections", tag: "settings", handler: a.ReadLocked(a.GetAnnotations),
+			params: []string{"kind", "tag"}},
+		{pattern: "GET /api/v1/annotations/{target}", operationID: "getAnnotation", summary: "The annotation of an address or connection UID", tag: "settings", handler: a.ReadLocked(a.GetAnnotation)},
+		{pattern: "PUT /api/v1/annotations/{target}", operationID: "putAnnotation", summary: "Tag and note an address or connection UID", tag: "settings", handler: a.Locked(a.PutAnnotation),
+			body: jsonContentType},
+		{pattern: "DELETE /api/v1/annotations/{target}", operationID: "deleteAnnotation", summary: "Remove an annotation", tag: "settings", handler: a.Locked(a.DeleteAnnotation)},
+	}
+}
+
+// selectDataset makes the dataset of the path the current one.
+func (a *API) selectDataset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+
+	a.syncStore(r.Context())
+	a.switchFile(w, r, r.PathValue("id"))
+}
+
+// deleteDataset removes the dataset of the path.
+func (a *API) deleteDataset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+
+	a.deleteFile(w, r, r.PathValue("id"))
+}
+
+// allowedMethods returns the methods mux has routes for at the path of a request its
+// catch-all pattern handles.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	_, fallback := mux.Handler(r)
+
+	var allowed []string
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := mux.Handler(probe); pattern != fallback {
+			allowed = append(allowed, method)
+		}
+	}
+
+	return allowed
+}
+
+// statusCode returns the machine-readable code of an HTTP status, such as "not_found".
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// detailedError reads the code and message of a structured error body, which some endpoints
+// send instead of plain text, falling back to code. Next to a message, the error field is
+// a code, as with upload rejections; alone, it is the message.
+func detailedError(details json.RawMessage, code string) (string, string) {
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Error   any    `json:"error"`
+	}
+	_ = json.Unmarshal(details, &body) // Arrays and other shapes carry no code or message
+	errorText, _ := body.Error.(string)
+	switch {
+	case body.Message == "":
+		body.Message = errorText
+	case body.Code == "" && errorText != "":
+		body.Code = errorText
+	}
+
+	return cmp.Or(body.Code, code), body.Message
+}
+
+// writeV1Error sends an error envelope.
+func writeV1Error(w http.ResponseWriter, envelope v1Error) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(envelope.Status)
+
+	err := json.NewEncoder(w).Encode(map[string]any{"error": envelope})
+	if err != nil {
+		log.Printf("Failed to encode error: %v", err)
+	}
+}
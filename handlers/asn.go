@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"zeek-viz/geoip"
+	"zeek-viz/models"
+)
+
+const (
+	graphGroupByASN   = "asn"    // group_by of /api/nodes rolling external hosts up by autonomous system
+	graphGroupByASOrg = "as_org" // group_by of /api/nodes rolling external hosts up by organization
+	unknownASNode     = "AS0"    // Node of the external hosts no database knows the autonomous system of
+)
+
+var (
+	errInvalidGraphGroupBy = errors.New("group_by must be asn or as_org")
+	errASNDisabled         = errors.New("group_by needs a GeoLite2-ASN database or ip2asn table (--geoip-db)")
+)
+
+// asnGrouping rolls external hosts up into one node per autonomous system, or per
+// organization, which may register several of them.
+type asnGrouping struct {
+	byOrg   bool
+	db      *geoip.DB
+	local   models.LocalNetworks
+	hosts   map[string]string          // Node of each host seen
+	systems map[string]geoip.Location  // Autonomous system of each node
+	numbers map[string]map[uint]string // Autonomous systems an organization node stands for
+}
+
+// parseGraphGroupBy checks the group_by parameter of /api/nodes. It returns whether external
+// hosts are grouped by organization rather than autonomous system, and ok when they are
+// grouped at all.
+func parseGraphGroupBy(value string) (bool, bool, error) {
+	switch value {
+	case "":
+		return false, false, nil
+	case graphGroupByASN:
+		return false, true, nil
+	case graphGroupByASOrg:
+		return true, true, nil
+	default:
+		return false, false, errInvalidGraphGroupBy
+	}
+}
+
+// asnGrouping returns the grouping the group_by parameter asks for, or nil without one.
+func (a *API) asnGrouping(value string) (*asnGrouping, error) {
+	byOrg, grouped, err := parseGraphGroupBy(value)
+	if err != nil || !grouped {
+		return nil, err
+	}
+	if a.geoip == nil {
+		return nil, errASNDisabled
+	}
+
+	return &asnGrouping{
+		byOrg:   byOrg,
+		db:      a.geoip,
+		local:   a.localNetworks,
+		hosts:   make(map[string]string),
+		systems: make(map[string]geoip.Location),
+		numbers: make(map[string]map[uint]string),
+	}, nil
+}
+
+// node returns the node of an external host, such as AS15169, or of its organization. ok is
+// false for local hosts, which are left to the subnet grouping.
+func (g *asnGrouping) node(host string) (string, bool) {
+	if id, seen := g.hosts[host]; seen {
+		return id, id != ""
+	}
+	if g.local.Contains(host) {
+		g.hosts[host] = ""
+
+		return "", false
+	}
+
+	location, _ := g.db.Lookup(host)
+	id := unknownASNode
+	switch {
+	case location.ASN == 0:
+		location = geoip.Location{}
+	case g.byOrg && location.ASOrg != "":
+		id = location.ASOrg
+		if g.numbers[id] == nil {
+			g.numbers[id] = make(map[uint]string)
+		}
+		g.numbers[id][location.ASN] = location.Country
+	default:
+		id = "AS" + strconv.FormatUint(uint64(location.ASN), 10)
+	}
+	if _, exists := g.systems[id]; !exists {
+		g.systems[id] = location
+	}
+	g.hosts[host] = id
+
+	return id, true
+}
+
+// annotate labels the nodes of autonomous systems and organizations with their number and
+// organization name. Organizations registering several autonomous systems keep the number of
+// the first one seen and report how many there are in their label; their country is only set
+// when all of them share it.
+func (g *asnGrouping) annotate(nodes []models.Node) {
+	for i := range nodes {
+		node := &nodes[i]
+		location, exists := g.systems[node.ID]
+		if !exists {
+			continue
+		}
+		node.ASN, node.ASOrg, node.Country, node.City = location.ASN, location.ASOrg, location.Country, ""
+
+		switch {
+		case node.ID == unknownASNode:
+			node.Label = "Unknown AS"
+		case g.byOrg:
+			node.Label = location.ASOrg
+			if systems := g.numbers[node.ID]; len(systems) > 1 {
+				node.Label += " (" + strconv.Itoa(len(systems)) + " ASes)"
+				for _, country := range systems {
+					if country != location.Country {
+						node.Country = ""
+					}
+				}
+			}
+		default:
+			node.Label = node.ID
+			if location.ASOrg != "" {
+				node.Label += " " + location.ASOrg
+			}
+		}
+	}
+}
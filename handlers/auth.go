@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"zeek-viz/auth"
+)
+
+const logoutPath = "/api/logout" // Served without credentials, so expired sessions can be cleared
+
+// SetAuth requires every /api/ request to authenticate with one of authenticators. Browsers
+// that sign in at /api/login are given a session cookie signed by sessions.
+func (a *API) SetAuth(sessions *auth.Sessions, authenticators ...auth.Authenticator) {
+	a.sessions = sessions
+	a.authenticators = authenticators
+}
+
+// Authenticated rejects /api/ requests without valid credentials with 401 once SetAuth enabled
+// authentication, and stores the user of the others in the request context. /metrics is
+// protected as well; the UI page, static assets, and /health stay public.
+func (a *API) Authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protected := (strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != logoutPath) || r.URL.Path == metricsPath
+		if len(a.authenticators) == 0 || !protected {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		user, ok := a.authenticate(r)
+		if !ok {
+			if r.Header.Get("Authorization") != "" {
+				log.Printf("Rejected invalid credentials for %s from %s", r.URL.Path, r.RemoteAddr)
+			}
+			if strings.HasPrefix(r.URL.Path, v1Prefix+"/") {
+				writer := &v1Writer{ResponseWriter: w}
+				a.writeUnauthorized(writer)
+				writer.finish()
+
+				return
+			}
+			a.writeUnauthorized(w)
+
+			return
+		}
+		noteUser(r.Context(), user.Name)
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// GetMe reports whether authentication is enabled and who the request is authenticated as.
+func (a *API) GetMe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := map[string]any{
+		"auth_enabled":  len(a.authenticators) > 0,
+		"authenticated": false,
+		"methods":       a.authMethods(),
+	}
+	if user, ok := auth.UserFrom(r.Context()); ok {
+		response["authenticated"] = true
+		response["user"] = user.Name
+		response["method"] = user.Method
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// Login turns the credentials of the request, a bearer token or basic auth, into a session
+// cookie, so the browser stays signed in without sending them again.
+func (a *API) Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := auth.UserFrom(r.Context())
+	if !ok || a.sessions == nil {
+		http.Error(w, "Authentication is not enabled", http.StatusNotFound)
+
+		return
+	}
+
+	cookie, err := a.sessions.Issue(user, a.cookiePath(), secureRequest(r))
+	if err != nil {
+		log.Printf("Failed to issue session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	http.SetCookie(w, cookie)
+	log.Printf("Signed in %s with %s auth from %s", user.Name, user.Method, r.RemoteAddr)
+
+	err = json.NewEncoder(w).Encode(map[string]any{"success": true, "user": user.Name, "expires": cookie.Expires.Unix()})
+	if err != nil {
+		log.Printf("Failed to encode login response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// Logout clears the session cookie. Browsers keep basic auth credentials until they are closed.
+func (a *API) Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.sessions != nil {
+		http.SetCookie(w, a.sessions.Clear(a.cookiePath(), secureRequest(r)))
+	}
+
+	err := json.NewEncoder(w).Encode(map[string]any{"success": true})
+	if err != nil {
+		log.Printf("Failed to encode logout response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// authenticate returns the user of the first authenticator accepting the request, trying the
+// session cookie first.
+func (a *API) authenticate(r *http.Request) (auth.User, bool) {
+	if a.sessions != nil {
+		if user, ok := a.sessions.Authenticate(r); ok {
+			return user, true
+		}
+	}
+	for _, authenticator := range a.authenticators {
+		if user, ok := authenticator.Authenticate(r); ok {
+			return user, true
+		}
+	}
+
+	return auth.User{}, false
+}
+
+// writeUnauthorized answers a request without valid credentials. With basic auth enabled,
+// the challenge makes browsers ask for a user and password.
+func (a *API) writeUnauthorized(w http.ResponseWriter) {
+	methods := a.authMethods()
+	for _, method := range methods {
+		if method == auth.MethodBasic {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.Config().InstanceName))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	err := json.NewEncoder(w).Encode(map[string]any{"error": "authentication required", "methods": methods})
+	if err != nil {
+		log.Printf("Failed to encode unauthorized response: %v", err)
+	}
+}
+
+// authMethods returns the methods requests can authenticate with.
+func (a *API) authMethods() []string {
+	methods := make([]string, 0, len(a.authenticators))
+	for _, authenticator := range a.authenticators {
+		methods = append(methods, authenticator.Method())
+	}
+
+	return methods
+}
+
+// cookiePath returns the path session cookies are scoped to.
+func (a *API) cookiePath() string {
+	return a.basePath + "/"
+}
+
+// secureRequest reports whether the request arrived over HTTPS, directly or through a proxy.
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
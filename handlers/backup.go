@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackupKeep = 7         // Backups kept when no retention is configured
+	backupExt         = ".zip"    // Extension of backup archives
+	checksumExt       = ".sha256" // Extension of backup checksum sidecar files
+	backupFileMode    = 0o600     // Permissions of backup files
+)
+
+var (
+	errBackupsDisabled = errors.New("backups are not configured")
+	errInvalidBackup   = errors.New("invalid backup name")
+	errBackupChecksum  = errors.New("backup checksum mismatch")
+	errBackupNoSum     = errors.New("backup checksum file missing")
+)
+
+// BackupInfo describes a backup archive in the backup directory.
+type BackupInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"` //nolint:tagliatelle // API consistency
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+// SetBackupDir enables backups into dir, keeping the newest keep archives (0 for the default).
+func (a *API) SetBackupDir(dir string, keep int) error {
+	err := os.MkdirAll(dir, 0o750) //nolint:mnd // Owner and group access to the backup directory
+	if err != nil {
+		return fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	if keep <= 0 {
+		keep = defaultBackupKeep
+	}
+	a.backupDir = dir
+	a.backupKeep = keep
+
+	return nil
+}
+
+// StartBackupSchedule creates a backup every interval until ctx is done.
+func (a *API) StartBackupSchedule(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			a.mu.RLock()
+			info, err := a.createBackup()
+			a.mu.RUnlock()
+			if err != nil {
+				log.Printf("Scheduled backup failed: %v", err)
+
+				continue
+			}
+			log.Printf("Created scheduled backup %s (%d bytes)", info.Name, info.Size)
+		}
+	}()
+}
+
+// CreateBackup writes a snapshot of all datasets into the backup directory.
+func (a *API) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	info, err := a.createBackup()
+	if errors.Is(err, errBackupsDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create backup: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Created backup %s (%d bytes)", info.Name, info.Size)
+
+	err = json.NewEncoder(w).Encode(info)
+	if err != nil {
+		log.Printf("Failed to encode backup: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ListBackups returns the backups in the backup directory, newest first.
+func (a *API) ListBackups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.backupDir == "" {
+		http.Error(w, errBackupsDisabled.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	backups, err := a.listBackups()
+	if err != nil {
+		log.Printf("Failed to list backups: %v", err)
+		http.Error(w, "Failed to list backups", http.StatusInternalServerError)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(map[string]any{"backups": backups})
+	if err != nil {
+		log.Printf("Failed to encode backups: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// RestoreBackup replaces all datasets with the content of a backup after verifying the
+// archive checksum and the checksums of the datasets inside it. As with ImportSnapshot, the
+// backup is parsed before the lock is taken, and restore_settings=true also restores the
+// live retention and raw upload storage.
+func (a *API) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.backupDir == "" {
+		http.Error(w, errBackupsDisabled.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	name := r.PathValue("name")
+	data, err := a.readBackup(name)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	manifest, files, err := readSnapshot(r.Context(), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.restoreSnapshot(manifest, files, true, r.URL.Query().Get("restore_settings") == "true")
+
+	log.Printf("Restored backup %s with %d datasets", name, len(files))
+
+	response := map[string]any{
+		"success":      true,
+		"message":      fmt.Sprintf("Restored %d datasets from %s", len(files), name),
+		"restored":     len(files),
+		"current_file": a.currentFileID,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// createBackup writes a snapshot archive and its checksum file, then prunes old backups.
+// The archive is written to a temporary file and renamed, so readers never see partial backups.
+func (a *API) createBackup() (*BackupInfo, error) {
+	if a.backupDir == "" {
+		return nil, errBackupsDisabled
+	}
+
+	var archive bytes.Buffer
+	err := a.writeSnapshot(&archive)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s%s", snapshotPrefix, now.UTC().Format("20060102-150405.000"), backupExt)
+	digest := sha256.Sum256(archive.Bytes())
+	checksum := hex.EncodeToString(digest[:])
+
+	err = writeFileAtomic(filepath.Join(a.backupDir, name), archive.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	err = writeFileAtomic(filepath.Join(a.backupDir, name+checksumExt), []byte(checksum+"  "+name+"\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	a.pruneBackups()
+
+	return &BackupInfo{Name: name, Size: int64(archive.Len()), CreatedAt: now.Unix(), SHA256: checksum}, nil
+}
+
+// readBackup reads a backup archive and verifies it against its checksum file.
+func (a *API) readBackup(name string) ([]byte, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, backupExt) {
+		return nil, errInvalidBackup
+	}
+
+	data, err := os.ReadFile(filepath.Join(a.backupDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("reading backup: %w", err)
+	}
+
+	sum, err := os.ReadFile(filepath.Join(a.backupDir, name+checksumExt))
+	if err != nil {
+		return nil, errBackupNoSum
+	}
+
+	digest := sha256.Sum256(data)
+	fields := strings.Fields(string(sum))
+	if len(fields) == 0 || fields[0] != hex.EncodeToString(digest[:]) {
+		return nil, errBackupChecksum
+	}
+
+	return data, nil
+}
+
+// listBackups returns the backups in the backup directory, newest first.
+func (a *API) listBackups() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(a.backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+
+	backups := make([]BackupInfo, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		backup := BackupInfo{Name: name, Size: info.Size(), CreatedAt: info.ModTime().Unix()}
+		if sum, err := os.ReadFile(filepath.Join(a.backupDir, name+checksumExt)); err == nil {
+			if fields := strings.Fields(string(sum)); len(fields) > 0 {
+				backup.SHA256 = fields[0]
+			}
+		}
+		backups = append(backups, backup)
+	}
+
+	// Names embed the creation time, so they sort chronologically
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+
+	return backups, nil
+}
+
+// pruneBackups deletes all but the newest configured number of backups.
+func (a *API) pruneBackups() {
+	backups, err := a.listBackups()
+	if err != nil {
+		log.Printf("Failed to list backups for pruning: %v", err)
+
+		return
+	}
+
+	for _, backup := range backups[min(a.backupKeep, len(backups)):] {
+		for _, path := range []string{backup.Name, backup.Name + checksumExt} {
+			err = os.Remove(filepath.Join(a.backupDir, path))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to remove old backup %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// readJSONFile decodes the JSON file at path into target, leaving target untouched when the
+// file doesn't exist yet.
+func readJSONFile(path string, target any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	err = json.Unmarshal(data, target)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	_, err = io.Copy(tmp, bytes.NewReader(data))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing %s: %w", path, closeErr)
+	}
+
+	err = os.Chmod(tmp.Name(), backupFileMode)
+	if err != nil {
+		return fmt.Errorf("setting permissions of %s: %w", path, err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("renaming %s: %w", path, err)
+	}
+
+	return nil
+}
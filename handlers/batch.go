@@ -0,0 +1,764 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/parse"
+)
+
+const (
+	connLogType = "conn" // conn.log, which becomes a dataset
+
+	zipArchive     = "zip"        // Zip archive
+	tarArchive     = "tar"        // Uncompressed tar archive
+	tarGzArchive   = "tar.gz"     // Gzip-compressed tar archive
+	zipMagic       = "PK\x03\x04" // Leading bytes of a zip archive
+	gzipMagic      = "\x1f\x8b"   // Leading bytes of gzip-compressed content
+	tarMagic       = "ustar"      // Magic of a POSIX tar header
+	tarMagicOffset = 257          // Offset of the magic in a tar header
+	tarBlockSize   = 512          // Bytes of a tar header
+
+	maxBatchSize = 512 << 20 // Decompressed bytes a batch upload may hold by default, guarding against archive bombs
+
+	batchMerged   = "merged"   // conn.log merged into the dataset of the batch
+	batchAttached = "attached" // Protocol log attached to a conn.log dataset
+	batchSkipped  = "skipped"  // File that isn't an ingestible log
+)
+
+var (
+	errBatchTooLarge     = errors.New("batch upload exceeds the limit of decompressed logs")
+	errNoBatchConnLog    = errors.New("none of the uploaded files is a parsable conn.log")
+	errNoRotationConnLog = errors.New("no conn.log of the same directory and rotation was uploaded")
+)
+
+// batchEntry is the outcome of one file of a batch upload.
+type batchEntry struct {
+	Filename    string        `json:"filename"`
+	Status      string        `json:"status"`                 // created, duplicate, replaced, merged, attached, or skipped
+	FileID      string        `json:"file_id,omitempty"`      //nolint:tagliatelle // API consistency
+	LogType     string        `json:"log_type,omitempty"`     //nolint:tagliatelle // API consistency
+	Connections int           `json:"connections,omitempty"`  // Records of a conn.log
+	Records     int           `json:"records,omitempty"`      // Records of a protocol log
+	Correlated  int           `json:"correlated,omitempty"`   // Records of a protocol log with a connection
+	ParseErrors *parse.Report `json:"parse_errors,omitempty"` //nolint:tagliatelle // API consistency
+	Error       string        `json:"error,omitempty"`        // Upload error code of a skipped file
+	Message     string        `json:"message,omitempty"`
+	Detected    string        `json:"detected,omitempty"`
+}
+
+// batchFile is a parsed file of a batch upload: a conn.log, a protocol log, or a skipped
+// file.
+type batchFile struct {
+	entry    *batchEntry
+	upload   *parsedUpload
+	protocol *protocolLog
+}
+
+// batchVisitor is called with each file of a batch upload.
+type batchVisitor func(name string, reader io.Reader) error
+
+// archiveFile is an uploaded or local file that may be an archive.
+type archiveFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// batchBudget is the decompressed bytes a batch upload may still read.
+type batchBudget struct {
+	remaining int64
+}
+
+// budgetReader reads a file of a batch upload, charging the bytes to the batch's budget.
+type budgetReader struct {
+	reader io.Reader
+	budget *batchBudget
+	read   int64
+}
+
+// Read reads from the file and fails once the batch exceeds its budget.
+func (b *budgetReader) Read(buf []byte) (int, error) {
+	n, err := b.reader.Read(buf)
+	b.read += int64(n)
+	b.budget.remaining -= int64(n)
+	if b.budget.remaining < 0 {
+		return n, errBatchTooLarge
+	}
+
+	return n, err //nolint:wrapcheck // io.EOF must stay comparable
+}
+
+// skip marks the file as not ingested, for the reason uploadErr gives.
+func (e *batchEntry) skip(uploadErr *uploadError) {
+	e.Status = batchSkipped
+	e.Error = uploadErr.Code
+	e.Message = uploadErr.Message
+	e.Detected = uploadErr.Detected
+}
+
+// isBatchUpload reports whether the files of a multipart upload are several logs, an archive,
+// or a packet capture, which uploadBatch ingests.
+func isBatchUpload(headers []*multipart.FileHeader) bool {
+	if len(headers) != 1 {
+		return len(headers) > 1
+	}
+
+	file, err := headers[0].Open()
+	if err != nil {
+		return false // Reported by the single-file upload
+	}
+	defer file.Close()
+
+	return archiveFormat(file) != ""
+}
+
+// uploadBatch ingests several logs, or archives of a Zeek log directory, sent in one multipart
+// request. Every conn.log becomes a dataset, or with merge=true all of them are merged into
+// one; protocol logs are attached to the conn.log they were rotated with. Other files are
+// skipped and reported. The dataset of the last conn.log by name becomes the current one.
+func (a *API) uploadBatch(w http.ResponseWriter, r *http.Request, headers []*multipart.FileHeader, options uploadOptions) {
+	merge := r.FormValue("merge") == "true"
+	log.Printf("Received batch upload of %d files (%s mode, merge: %t)", len(headers), options.mode, merge)
+
+	walk := func(visit batchVisitor) error {
+		for _, header := range headers {
+			err := a.walkUploadedFile(r.Context(), header, visit)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+	files, err := readBatch(r.Context(), walk, options, !a.discardRaw && !merge, a.maxBatchBytes())
+	if err != nil {
+		a.metrics.rejectUpload(err)
+	}
+	var uploadErr *uploadError
+	switch {
+	case errors.As(err, &uploadErr):
+		writeUploadError(w, uploadErr)
+
+		return
+	case errors.Is(err, errBatchTooLarge):
+		http.Error(w, fmt.Sprintf("batch upload exceeds the %d MiB limit of decompressed logs", a.maxBatchBytes()>>20), http.StatusRequestEntityTooLarge)
+
+		return
+	case errors.Is(err, parse.ErrCanceled):
+		writeCanceled(w, "Batch upload", err)
+
+		return
+	case errors.Is(err, errBadCapture):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	case errors.Is(err, errZeekFailed):
+		log.Printf("Failed to read batch upload: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	case err != nil:
+		log.Printf("Failed to read batch upload: %v", err)
+		http.Error(w, "Failed to read uploaded files", http.StatusBadRequest)
+
+		return
+	}
+
+	entries := make([]*batchEntry, len(files))
+	connLogs := 0
+	for i, file := range files {
+		entries[i] = file.entry
+		if file.upload != nil {
+			connLogs++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if connLogs == 0 {
+		for _, file := range files {
+			if file.protocol != nil {
+				file.entry.skip(&uploadError{Code: "no_conn_log", Message: errNoRotationConnLog.Error()})
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		response := map[string]any{
+			"success": false,
+			"error":   "no_conn_logs",
+			"message": errNoBatchConnLog.Error(),
+			"files":   entries,
+		}
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			log.Printf("Failed to encode response: %v", err)
+		}
+
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var fileID string
+	if merge {
+		dedup := options.dedup
+		if r.FormValue("dedup") == "" {
+			dedup = dedupFirst // Rotated logs overlap at their boundaries
+		}
+		fileID, err = a.storeMergedBatch(r, headers, files, dedup)
+	} else {
+		uploadTime := time.Now().Unix()
+		fileID, err = a.storeBatch(files, uploadTime, connfilter.SplitList(r.FormValue("tags")), a.batchPlacement(r, uploadTime))
+	}
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		writeQuotaError(w, quotaErr)
+
+		return
+	}
+	if errors.Is(err, errIdempotencyConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to merge batch upload: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	if fileID == "" { // Every conn.log conflicted with an idempotency key
+		http.Error(w, errIdempotencyConflict.Error(), http.StatusConflict)
+
+		return
+	}
+
+	noteDataset(r.Context(), fileID)
+	fileData := a.files[fileID]
+	a.setCurrentDataset(r, fileID)
+	fileData.warmCaches(a.localNetworks)
+
+	connections := len(fileData.Connections)
+	if !merge {
+		connections = 0
+		for _, file := range files {
+			if file.upload != nil && file.entry.Status != batchSkipped {
+				connections += len(file.upload.connections)
+			}
+		}
+	}
+	log.Printf("Stored batch of %d files with %d connections, current file %s", len(files), connections, fileID)
+
+	response := map[string]any{
+		"success":           true,
+		"message":           fmt.Sprintf("Successfully loaded %d connections from %d conn.logs", connections, connLogs),
+		"connections_count": connections,
+		"file_id":           fileID,
+		"filename":          fileData.Filename,
+		"merged":            merge,
+		"files":             entries,
+		"total_files":       len(a.files),
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// LoadPath loads a log file, an archive, or every file of a directory tree at startup, as a
+// batch upload would, and selects the dataset of the last conn.log by name. Datasets are named
+// after the path of their file, so loading the same path after a restart updates them rather
+// than adding new ones. Loading stops when ctx is done. It returns the number of datasets loaded.
+func (a *API) LoadPath(ctx context.Context, root string) (int, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+
+	parent := filepath.Dir(root) // Names start with the loaded file or directory
+	walk := func(visit batchVisitor) error {
+		if !info.IsDir() {
+			return a.walkLocalFile(ctx, root, filepath.Base(root), visit)
+		}
+
+		return filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+			switch {
+			case err != nil:
+				return err
+			case file != root && strings.HasPrefix(entry.Name(), "."):
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			case !entry.Type().IsRegular():
+				return nil
+			}
+			name, err := filepath.Rel(parent, file)
+			if err != nil {
+				return fmt.Errorf("failed to name %s: %w", file, err)
+			}
+
+			return a.walkLocalFile(ctx, file, filepath.ToSlash(name), visit)
+		})
+	}
+	files, err := readBatch(ctx, walk, uploadOptions{mode: lenientMode, dedup: dedupNone}, !a.discardRaw, a.maxBatchBytes())
+	if err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	place := func(upload *parsedUpload) (string, string) {
+		dataset := filepath.Join(parent, filepath.FromSlash(upload.filename))
+
+		return a.generateFileID("dataset:"+dataset, 0), dataset
+	}
+	fileID, err := a.storeBatch(files, time.Now().Unix(), nil, place)
+	loaded := 0
+	for _, file := range files {
+		switch file.entry.Status {
+		case uploadCreated, uploadDuplicate, uploadReplaced:
+			loaded++
+		case batchSkipped:
+			log.Printf("Skipped %s: %s", file.entry.Filename, file.entry.Message)
+		}
+	}
+	if fileID == "" {
+		return 0, cmp.Or(err, errNoBatchConnLog)
+	}
+
+	a.currentFileID = fileID
+	a.publishCurrentFile()
+	a.files[fileID].warmCaches(a.localNetworks)
+
+	return loaded, nil
+}
+
+// walkLocalFile calls visit with the file at location, named name, with every regular file of
+// the archive it is, or with the logs of the packet capture it is.
+func (a *API) walkLocalFile(ctx context.Context, location, name string, visit batchVisitor) error {
+	file, err := os.Open(location)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+
+	return a.walkFile(ctx, name, file, info.Size(), visit)
+}
+
+// storeBatch stores every conn.log of a batch as a dataset, under the file ID and dataset name
+// place returns for it, and attaches each protocol log to the conn.log of the
+// same directory and rotation, or to the only one. It returns the ID of the last dataset
+// stored, or when none was, the error the last conn.log was skipped for. Callers must hold a.mu.
+func (a *API) storeBatch(files []*batchFile, uploadTime int64, tags []string, place func(upload *parsedUpload) (string, string)) (string, error) {
+	fileID := ""
+	var skipped error
+	rotations := make(map[string]string) // File IDs by rotation group
+	for _, file := range files {
+		if file.upload == nil {
+			continue
+		}
+		name := file.upload.filename
+		memberID, memberDataset := place(file.upload)
+		_, status, err := a.addUpload(memberID, memberDataset, slices.Clone(tags), file.upload, uploadTime)
+		var quotaErr *quotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			file.entry.skip(&uploadError{Code: quotaErr.Code, Message: quotaErr.Message})
+			skipped = err
+
+			continue
+		case err != nil:
+			file.entry.skip(&uploadError{Code: "idempotency_conflict", Message: err.Error()})
+			skipped = err
+
+			continue
+		}
+		file.entry.Status, file.entry.FileID = status, memberID
+		rotations[rotationGroup(name)] = memberID
+		fileID = memberID
+	}
+
+	for _, file := range files {
+		if file.protocol == nil {
+			continue
+		}
+		targetID, exists := rotations[rotationGroup(file.entry.Filename)]
+		if !exists && len(rotations) == 1 {
+			targetID, exists = fileID, true
+		}
+		if !exists {
+			file.entry.skip(&uploadError{Code: "no_conn_log", Message: errNoRotationConnLog.Error()})
+
+			continue
+		}
+		file.entry.Status, file.entry.FileID = batchAttached, targetID
+		file.entry.Correlated = a.files[targetID].attachProtocolLog(file.entry.LogType, file.protocol)
+	}
+
+	if fileID == "" {
+		return "", skipped
+	}
+
+	return fileID, nil
+}
+
+// storeMergedBatch merges the conn.logs of a batch into one dataset, named after the uploaded
+// archive, and attaches all its protocol logs to it. It returns the dataset's file ID.
+// Callers must hold a.mu.
+func (a *API) storeMergedBatch(r *http.Request, headers []*multipart.FileHeader, files []*batchFile, dedup string) (string, error) {
+	uploads := make([]*parsedUpload, 0, len(files))
+	for _, file := range files {
+		if file.upload != nil {
+			uploads = append(uploads, file.upload)
+			a.metrics.observeParse(file.upload)
+		}
+	}
+	name := mergedFilename(len(uploads))
+	if len(headers) == 1 {
+		name = headers[0].Filename
+	}
+	upload, err := mergeUploads(name, uploads, dedup, !a.discardRaw)
+	if err != nil {
+		return "", err
+	}
+
+	uploadTime := time.Now().Unix()
+	fileID := a.uploadFileID(r, upload, uploadTime)
+	tags := append([]string{mergedTag}, connfilter.SplitList(r.FormValue("tags"))...)
+	fileData, status, err := a.addUpload(fileID, r.FormValue("dataset"), tags, upload, uploadTime)
+	if err != nil {
+		return "", err
+	}
+
+	records := make(map[string]*protocolLog)
+	for _, logType := range protocolLogTypes() {
+		records[logType] = newProtocolLog()
+	}
+	for _, file := range files {
+		switch {
+		case file.upload != nil:
+			file.entry.Status, file.entry.FileID = batchMerged, fileID
+		case file.protocol != nil:
+			records[file.entry.LogType].merge(file.protocol)
+			file.entry.Status, file.entry.FileID = batchAttached, fileID
+			file.entry.Correlated = fileData.correlatedRecords(file.protocol)
+		}
+	}
+	for logType, merged := range records {
+		if merged.count() > 0 {
+			fileData.attachProtocolLog(logType, merged)
+		}
+	}
+	log.Printf("Merged %d conn.logs into %s as ID %s (%s)", len(uploads), name, fileID, status)
+
+	return fileID, nil
+}
+
+// batchPlacement returns the file IDs and dataset names of the conn.logs of a batch upload. A
+// dataset name or idempotency key pins the IDs of all files of the batch, qualified by their
+// names, so that repeated uploads of the same archive don't create duplicates. Without either,
+// a conn.log already stored is placed at the file holding it, unless force=true.
+func (a *API) batchPlacement(r *http.Request, uploadTime int64) func(upload *parsedUpload) (string, string) {
+	dataset := r.FormValue("dataset")
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = r.FormValue("idempotency_key")
+	}
+
+	force := r.FormValue("force") == "true"
+
+	return func(upload *parsedUpload) (string, string) {
+		filename, existingID := upload.filename, ""
+		if !force {
+			existingID = a.fileWithContent(upload.sha256)
+		}
+		switch {
+		case dataset != "":
+			return a.generateFileID("dataset:"+dataset+"/"+filename, 0), dataset + "/" + filename
+		case idempotencyKey != "":
+			return a.generateFileID("key:"+idempotencyKey+"/"+filename, 0), ""
+		case existingID != "":
+			return existingID, ""
+		default:
+			return a.newFileID(filename, uploadTime), ""
+		}
+	}
+}
+
+// SetMaxBatchSize limits the decompressed bytes of the logs of a batch upload, of a path
+// LoadPath loads, and of a log a watched directory rotates in (0 for the default of 512 MiB).
+func (a *API) SetMaxBatchSize(limit int64) {
+	a.batchLimit = limit
+}
+
+// maxBatchBytes returns the decompressed bytes a batch may hold.
+func (a *API) maxBatchBytes() int64 {
+	if a.batchLimit > 0 {
+		return a.batchLimit
+	}
+
+	return maxBatchSize
+}
+
+// rotationGroup returns the directory and rotation of a Zeek log, which the logs written
+// together share: conn.10:00:00-11:00:00.log.gz and http.10:00:00-11:00:00.log.gz both yield
+// 10:00:00-11:00:00.log.gz.
+func rotationGroup(name string) string {
+	dir, base := path.Split(name)
+	_, rotation, _ := strings.Cut(base, ".")
+
+	return dir + rotation
+}
+
+// readBatch parses the files walk visits as it visits them, and returns them ordered by name.
+// Reading more than limit decompressed bytes fails with errBatchTooLarge.
+func readBatch(ctx context.Context, walk func(visit batchVisitor) error, options uploadOptions, keepRaw bool, limit int64) ([]*batchFile, error) {
+	budget := &batchBudget{remaining: limit}
+	files := make([]*batchFile, 0)
+	visit := func(name string, reader io.Reader) error {
+		file, err := parseBatchFile(ctx, name, reader, options, keepRaw, budget)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+
+		return nil
+	}
+
+	err := walk(visit)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(files, func(a, b *batchFile) int { // Rotations in time order
+		return strings.Compare(a.entry.Filename, b.entry.Filename)
+	})
+
+	return files, nil
+}
+
+// walkUploadedFile calls visit with an uploaded file, with every regular file of an uploaded
+// archive, or with the logs of an uploaded packet capture.
+func (a *API) walkUploadedFile(ctx context.Context, header *multipart.FileHeader, visit batchVisitor) error {
+	file, err := header.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", header.Filename, err)
+	}
+	defer file.Close()
+
+	return a.walkFile(ctx, header.Filename, file, header.Size, visit)
+}
+
+// walkFile calls visit with a file, with every regular file of an archive, or with the logs of
+// a packet capture (see walkCapture). Archive members are named after the archive and their
+// path in it, and the logs of a capture after the capture.
+func (a *API) walkFile(ctx context.Context, name string, file archiveFile, size int64, visit batchVisitor) error {
+	format := archiveFormat(file)
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to rewind %s: %w", name, err)
+	}
+
+	prefix := name + "/"
+	switch format {
+	case zipArchive:
+		archive, err := zip.NewReader(file, size)
+		if err != nil {
+			return fmt.Errorf("failed to read zip archive %s: %w", name, err)
+		}
+
+		return walkZip(archive, prefix, visit)
+	case tarGzArchive:
+		decompressed, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", name, err)
+		}
+		defer decompressed.Close()
+
+		return walkTar(tar.NewReader(decompressed), prefix, visit)
+	case tarArchive:
+		return walkTar(tar.NewReader(file), prefix, visit)
+	case captureFormat:
+		return a.walkCapture(ctx, name, file, visit)
+	case gzCaptureFormat:
+		return a.walkGzCapture(ctx, name, file, visit)
+	default:
+		return visit(name, file)
+	}
+}
+
+// walkZip calls visit with every regular file of a zip archive.
+func walkZip(archive *zip.Reader, prefix string, visit batchVisitor) error {
+	for _, member := range archive.File {
+		if !member.Mode().IsRegular() || ignoredMember(member.Name) {
+			continue
+		}
+
+		reader, err := member.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s%s: %w", prefix, member.Name, err)
+		}
+		err = visit(prefix+path.Clean(member.Name), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkTar calls visit with every regular file of a tar archive.
+func walkTar(archive *tar.Reader, prefix string, visit batchVisitor) error {
+	for {
+		member, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if member.Typeflag != tar.TypeReg || ignoredMember(member.Name) {
+			continue
+		}
+
+		err = visit(prefix+path.Clean(member.Name), archive)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ignoredMember reports whether an archive member is metadata of the archiving tool, such as
+// macOS resource forks, rather than a file the user archived.
+func ignoredMember(name string) bool {
+	return strings.HasPrefix(path.Base(name), ".") || strings.HasPrefix(name, "__MACOSX/")
+}
+
+// archiveFormat returns the archive format of content, recognized by its leading bytes, or ""
+// when it isn't an archive. Packet captures count as archives of the logs they yield.
+func archiveFormat(reader io.Reader) string {
+	buffered := bufio.NewReaderSize(reader, tarBlockSize)
+	head, _ := buffered.Peek(tarBlockSize) // Shorter content returns what's there
+	switch {
+	case bytes.HasPrefix(head, []byte(zipMagic)):
+		return zipArchive
+	case isTarHeader(head):
+		return tarArchive
+	case captureFormatOf(head, false) != "":
+		return captureFormat
+	case bytes.HasPrefix(head, []byte(gzipMagic)):
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return ""
+		}
+		defer decompressed.Close()
+
+		block := make([]byte, tarBlockSize)
+		n, _ := io.ReadFull(decompressed, block) // Shorter content isn't a tar archive
+		if isTarHeader(block[:n]) {
+			return tarGzArchive
+		}
+
+		return captureFormatOf(block[:n], true)
+	}
+
+	return ""
+}
+
+// isTarHeader reports whether block starts with a POSIX tar header.
+func isTarHeader(block []byte) bool {
+	end := tarMagicOffset + len(tarMagic)
+
+	return len(block) >= end && string(block[tarMagicOffset:end]) == tarMagic
+}
+
+// parseBatchFile parses one file of a batch upload, decompressing it when gzip-compressed, as
+// rotated Zeek logs are. Files that aren't parsable conn or protocol logs are marked skipped;
+// read errors, and in strict mode malformed lines, abort the batch.
+func parseBatchFile(ctx context.Context, name string, reader io.Reader, options uploadOptions, keepRaw bool, budget *batchBudget) (*batchFile, error) {
+	file := &batchFile{entry: &batchEntry{Filename: name}}
+
+	buffered := bufio.NewReader(reader)
+	reader = buffered
+	if magic, _ := buffered.Peek(len(gzipMagic)); string(magic) == gzipMagic {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.entry.skip(&uploadError{Code: "compressed_file", Message: fmt.Sprintf("unreadable gzip content: %v", err), Detected: "gzip"})
+
+			return file, nil
+		}
+		defer decompressed.Close()
+		reader = decompressed
+	}
+
+	counter := &budgetReader{reader: reader, budget: budget}
+	content := bufio.NewReaderSize(counter, sniffSize)
+	head, _ := content.Peek(sniffSize) // Read errors surface while parsing
+	if logType := protocolLogType(head); logType != "" {
+		records, report, err := parseProtocolLog(ctx, content, logType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		file.protocol = records
+		file.entry.LogType = logType
+		file.entry.Records = report.ParsedLines
+		file.entry.ParseErrors = report.Summary()
+
+		return file, nil
+	}
+
+	upload, err := parseLog(ctx, content, options.mode, options.dedup, keepRaw)
+	var uploadErr *uploadError
+	switch {
+	case errors.As(err, &uploadErr) && uploadErr.Code == "malformed_line":
+		return nil, &uploadError{Code: uploadErr.Code, Message: name + ": " + uploadErr.Message, Line: uploadErr.Line}
+	case errors.As(err, &uploadErr):
+		file.entry.skip(uploadErr)
+
+		return file, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	upload.filename = name
+	upload.size = counter.read
+	upload.metrics.log(name)
+
+	file.upload = upload
+	file.entry.LogType = connLogType
+	file.entry.Connections = len(upload.connections)
+	file.entry.ParseErrors = upload.report.Summary()
+
+	return file, nil
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarGz returns a gzip-compressed tar archive of the files, by name.
+func tarGz(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var archive bytes.Buffer
+	compressed := gzip.NewWriter(&archive)
+	writer := tar.NewWriter(compressed)
+	for name, content := range files {
+		err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err == nil {
+			_, err = writer.Write(content)
+		}
+		if err != nil {
+			t.Fatalf("archiving %s: %v", name, err)
+		}
+	}
+	err := writer.Close()
+	if err == nil {
+		err = compressed.Close()
+	}
+	if err != nil {
+		t.Fatalf("archiving: %v", err)
+	}
+
+	return archive.Bytes()
+}
+
+// batchResponse is the part of a batch upload response the tests check.
+type batchResponse struct {
+	Connections int           `json:"connections_count"` //nolint:tagliatelle // API consistency
+	Files       []*batchEntry `json:"files"`
+}
+
+func TestBatchUploadLimit(t *testing.T) {
+	first, second := syntheticLog(t, 1), syntheticLog(t, 2)
+	archive := tarGz(t, map[string][]byte{"logs/conn.00:00:00-01:00:00.log": first, "logs/conn.01:00:00-02:00:00.log": second})
+	total := int64(len(first) + len(second))
+
+	tests := []struct {
+		limit int64
+		code  int
+	}{
+		{total - 1, http.StatusRequestEntityTooLarge},
+		{total, http.StatusOK},
+	}
+	for _, test := range tests {
+		api := NewAPI("")
+		api.SetMaxBatchSize(test.limit)
+		mux := http.NewServeMux()
+		api.HandleAPI(mux)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, uploadRequest(t, "logs.tar.gz", archive))
+		if w.Code != test.code {
+			t.Fatalf("limit of %d bytes for %d: status %d, want %d: %s", test.limit, total, w.Code, test.code, w.Body)
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var response batchResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		if err != nil || len(response.Files) != 2 || response.Files[0].Status != uploadCreated || response.Files[1].Status != uploadCreated {
+			t.Errorf("batch within the limit: %s", w.Body)
+		}
+	}
+}
+
+func TestBatchUploadStreamsCaptureFlows(t *testing.T) {
+	capture, err := os.ReadFile(filepath.Join("..", "pcap", "testdata", "flows.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPI("")
+	mux := http.NewServeMux()
+	api.HandleAPI(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, uploadRequest(t, "flows.pcapng", capture))
+	var response batchResponse
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || err != nil || response.Connections != 3 || len(response.Files) != 1 ||
+		response.Files[0].Filename != "flows.pcapng/conn.log" {
+		t.Fatalf("capture upload: status %d: %s", w.Code, w.Body)
+	}
+
+	// The extracted conn.log is counted against the limit while the parser reads it
+	api.SetMaxBatchSize(100)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, uploadRequest(t, "flows.pcapng", capture))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("capture beyond the limit: status %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"zeek-viz/models"
+)
+
+const (
+	beaconRule              = "beacon" // Finding rule of beacon candidates
+	defaultBeaconMinConns   = 10       // Connections a tuple needs before its timing is scored
+	minBeaconConns          = 4        // Lowest accepted min_connections; fewer intervals say nothing
+	defaultBeaconLimit      = 50       // Beacons returned by default
+	defaultBeaconInterval   = 1.0      // Median interval in seconds below which repeats count as bursts
+	beaconSkewWeight        = 0.25     // Weight of the interval symmetry score
+	beaconDispersionWeight  = 0.35     // Weight of the interval regularity score
+	beaconCoverageWeight    = 0.2      // Weight of the share of the time span covered at the interval
+	beaconSizeWeight        = 0.2      // Weight of the payload size regularity score
+	beaconScorePrecision    = 1000     // Scores and ratios are rounded to three decimals
+	beaconIntervalPrecision = 1000     // Intervals are rounded to milliseconds
+)
+
+var (
+	errInvalidMinScore    = errors.New("min_score must be a number between 0 and 1")
+	errInvalidMinInterval = errors.New("min_interval must be a non-negative number of seconds")
+	errInvalidNumber      = errors.New("invalid number")
+)
+
+// Beacon is a source, destination, and port whose connections recur at regular intervals, as
+// command-and-control implants calling home do.
+type Beacon struct {
+	Src          string             `json:"src"`
+	Dst          string             `json:"dst"`
+	Port         int                `json:"port"`
+	Proto        string             `json:"proto"`
+	Connections  int                `json:"connections"`
+	Score        float64            `json:"score"`         // 0 to 1, higher is more regular
+	Scores       map[string]float64 `json:"scores"`        // Components of the score
+	Interval     float64            `json:"interval"`      // Median seconds between connections
+	MeanInterval float64            `json:"mean_interval"` //nolint:tagliatelle // API consistency
+	Jitter       float64            `json:"jitter"`        // Median absolute deviation of the intervals in seconds
+	JitterRatio  float64            `json:"jitter_ratio"`  //nolint:tagliatelle // Jitter relative to the interval
+	Bytes        int                `json:"bytes"`
+	MedianBytes  float64            `json:"median_bytes"` //nolint:tagliatelle // API consistency
+	FirstSeen    float64            `json:"first_seen"`   //nolint:tagliatelle // API consistency
+	LastSeen     float64            `json:"last_seen"`    //nolint:tagliatelle // API consistency
+	Threat       *models.Threat     `json:"threat,omitempty"`
+}
+
+// beaconTuple collects the connections of one source, destination, port, and protocol.
+type beaconTuple struct {
+	first *models.Connection
+	times []float64
+	sizes []float64
+	bytes int
+}
+
+// GetBeacons scores every source, destination, port, and protocol with enough connections by
+// how regularly they recur and returns the most regular ones. Accepts the standard filters,
+// min_connections, min_score, min_interval, and limit.
+func (a *API) GetBeacons(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	minConnections := parseLimit(query, "min_connections")
+	if minConnections == 0 {
+		minConnections = defaultBeaconMinConns
+	}
+	minConnections = max(minConnections, minBeaconConns)
+	minScore, err := parseFloatParam(query.Get("min_score"), 0)
+	if err != nil || minScore < 0 || minScore > 1 {
+		http.Error(w, errInvalidMinScore.Error(), http.StatusBadRequest)
+
+		return
+	}
+	minInterval, err := parseFloatParam(query.Get("min_interval"), defaultBeaconInterval)
+	if err != nil || minInterval < 0 {
+		http.Error(w, errInvalidMinInterval.Error(), http.StatusBadRequest)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultBeaconLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	tuples := beaconTuples(connections, minConnections)
+	beacons := make([]Beacon, 0)
+	suppressed := 0
+	for _, tuple := range tuples {
+		err = r.Context().Err()
+		if err != nil {
+			writeCanceled(w, "Beacon analysis", err)
+
+			return
+		}
+
+		beacon := tuple.score()
+		if beacon.Score < minScore || beacon.Interval < minInterval {
+			continue
+		}
+		if connectionSuppressed(a.suppressions, beaconRule, tuple.first) {
+			suppressed++
+
+			continue
+		}
+		beacon.Threat = a.intel.matchConnection(tuple.first)
+		beacons = append(beacons, beacon)
+	}
+	sort.Slice(beacons, func(i, j int) bool {
+		if beacons[i].Score != beacons[j].Score {
+			return beacons[i].Score > beacons[j].Score
+		}
+
+		return beacons[i].Connections > beacons[j].Connections
+	})
+
+	response := map[string]any{
+		"beacons":             beacons[:min(limit, len(beacons))],
+		"candidates":          len(tuples),
+		"total":               len(beacons),
+		"truncated":           len(beacons) > limit,
+		"suppressed_findings": suppressed,
+		"limits":              map[string]int{"limit": limit, "min_connections": minConnections},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode beacons: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// beaconTuples groups the connections by source, destination, responder port, and protocol,
+// keeping the groups with at least minConnections connections, sorted by time.
+func beaconTuples(connections []models.Connection, minConnections int) []*beaconTuple {
+	groups := make(map[string]*beaconTuple)
+	order := make([]string, 0)
+	for i := range connections {
+		conn := &connections[i]
+		key := conn.OrigHost + groupKeySeparator + conn.RespHost + groupKeySeparator +
+			strconv.Itoa(conn.RespPort) + groupKeySeparator + conn.Protocol
+		tuple, exists := groups[key]
+		if !exists {
+			tuple = &beaconTuple{first: conn}
+			groups[key] = tuple
+			order = append(order, key)
+		}
+		tuple.times = append(tuple.times, conn.Timestamp)
+		tuple.sizes = append(tuple.sizes, float64(conn.TotalBytes()))
+		tuple.bytes += conn.TotalBytes()
+	}
+
+	tuples := make([]*beaconTuple, 0)
+	for _, key := range order {
+		if tuple := groups[key]; len(tuple.times) >= minConnections {
+			sort.Float64s(tuple.times)
+			tuples = append(tuples, tuple)
+		}
+	}
+
+	return tuples
+}
+
+// score rates how regularly the tuple's connections recur. The score combines how symmetric
+// the intervals are around their median (Bowley skewness), how little they deviate from it
+// (median absolute deviation relative to the median), how much of the observed time span the
+// median interval accounts for (so bursts followed by silence rate low), and how constant the
+// payload sizes are.
+func (t *beaconTuple) score() Beacon {
+	intervals := make([]float64, len(t.times)-1)
+	for i := range intervals {
+		intervals[i] = t.times[i+1] - t.times[i]
+	}
+	span := t.times[len(t.times)-1] - t.times[0]
+
+	q1, interval, q3 := quartiles(intervals)
+	jitter := medianDeviation(intervals, interval)
+	skew := 0.0
+	if q3 > q1 {
+		skew = (q1 + q3 - 2*interval) / (q3 - q1) //nolint:mnd // Bowley skewness
+	}
+
+	scores := map[string]float64{"skew": 1 - math.Abs(skew), "dispersion": 0, "coverage": 0}
+	jitterRatio := 0.0
+	if interval > 0 {
+		jitterRatio = jitter / interval
+		scores["dispersion"] = 1 - min(jitterRatio, 1)
+		scores["coverage"] = min(1, interval*float64(len(intervals))/span)
+	}
+
+	_, medianBytes, _ := quartiles(t.sizes)
+	sizeJitter := medianDeviation(t.sizes, medianBytes)
+	switch {
+	case medianBytes > 0:
+		scores["size"] = 1 - min(sizeJitter/medianBytes, 1)
+	case sizeJitter == 0:
+		scores["size"] = 1 // Constantly empty connections, such as repeated probes
+	default:
+		scores["size"] = 0
+	}
+
+	score := beaconSkewWeight*scores["skew"] + beaconDispersionWeight*scores["dispersion"] +
+		beaconCoverageWeight*scores["coverage"] + beaconSizeWeight*scores["size"]
+	for name, value := range scores {
+		scores[name] = roundTo(value, beaconScorePrecision)
+	}
+
+	return Beacon{
+		Src:          t.first.OrigHost,
+		Dst:          t.first.RespHost,
+		Port:         t.first.RespPort,
+		Proto:        t.first.Protocol,
+		Connections:  len(t.times),
+		Score:        roundTo(score, beaconScorePrecision),
+		Scores:       scores,
+		Interval:     roundTo(interval, beaconIntervalPrecision),
+		MeanInterval: roundTo(span/float64(len(intervals)), beaconIntervalPrecision),
+		Jitter:       roundTo(jitter, beaconIntervalPrecision),
+		JitterRatio:  roundTo(jitterRatio, beaconScorePrecision),
+		Bytes:        t.bytes,
+		MedianBytes:  medianBytes,
+		FirstSeen:    t.times[0],
+		LastSeen:     t.times[len(t.times)-1],
+	}
+}
+
+// quartiles returns the first quartile, median, and third quartile of the values.
+func quartiles(values []float64) (float64, float64, float64) {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	return quantile(sorted, 0.25), quantile(sorted, 0.5), quantile(sorted, 0.75) //nolint:mnd // Quartiles
+}
+
+// quantile returns the q-quantile of sorted values, interpolating between neighbors.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	position := q * float64(len(sorted)-1)
+	lower := int(position)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+
+	return sorted[lower] + (position-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// medianDeviation returns the median absolute deviation of the values from median.
+func medianDeviation(values []float64, median float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - median)
+	}
+	_, deviation, _ := quartiles(deviations)
+
+	return deviation
+}
+
+// parseFloatParam parses a numeric query value, returning fallback when it is empty.
+func parseFloatParam(value string, fallback float64) (float64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, errInvalidNumber
+	}
+
+	return number, nil
+}
+
+// roundTo rounds value to 1/precision.
+func roundTo(value float64, precision float64) float64 {
+	return math.Round(value*precision) / precision
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const maxBreakdownBuckets = 5000 // Buckets a stats breakdown may span; smaller bucket sizes are rejected
+
+var (
+	errBreakdownGroupBy = errors.New("group_by must be protocol or service")
+	errBreakdownBuckets = errors.New("bucket is too small: the breakdown would span more than 5000 buckets; use a larger bucket or auto")
+)
+
+// statsBreakdown is the traffic of each protocol or service over time, as /api/stats adds it
+// with bucket or group_by. Every series has one value per bucket, aligned with Buckets, so
+// the series stack. Buckets without connections are left out.
+type statsBreakdown struct {
+	BucketSize   int64             `json:"bucket_size"` //nolint:tagliatelle // API consistency
+	GroupBy      string            `json:"group_by"`    //nolint:tagliatelle // API consistency
+	Buckets      []int64           `json:"buckets"`
+	BucketsLocal []string          `json:"buckets_local,omitempty"` //nolint:tagliatelle // API consistency
+	Series       []breakdownSeries `json:"series"`
+}
+
+// breakdownSeries is the traffic of one protocol or service: its totals, and connections,
+// bytes (orig_bytes plus resp_bytes), and packets per bucket.
+type breakdownSeries struct {
+	Key              string `json:"key"`
+	TotalConnections int    `json:"total_connections"` //nolint:tagliatelle // API consistency
+	TotalBytes       int    `json:"total_bytes"`       //nolint:tagliatelle // API consistency
+	TotalPackets     int    `json:"total_packets"`     //nolint:tagliatelle // API consistency
+	Connections      []int  `json:"connections"`
+	Bytes            []int  `json:"bytes"`
+	Packets          []int  `json:"packets"`
+}
+
+// breakdownTraffic is the traffic of a series, overall or within a bucket.
+type breakdownTraffic struct {
+	connections, bytes, packets int
+}
+
+// add adds other's traffic.
+func (t *breakdownTraffic) add(other breakdownTraffic) {
+	t.connections += other.connections
+	t.bytes += other.bytes
+	t.packets += other.packets
+}
+
+// wantsBreakdown reports whether a stats request asks for a breakdown.
+func wantsBreakdown(query url.Values) bool {
+	return query.Get("bucket") != "" || query.Get("group_by") != ""
+}
+
+// buildStatsBreakdown buckets the connections by time, with the bucket size of the "bucket"
+// parameter (see parseTimelineBucket), and splits them by the protocol or, with
+// group_by=service, the service Zeek logged. Like timeline series, the busiest
+// maxTimelineSeries-1 keys get a series of their own and the rest are merged into "other".
+func buildStatsBreakdown(connections []models.Connection, query url.Values, loc *time.Location) (*statsBreakdown, error) {
+	field := models.CanonicalFieldName(cmp.Or(query.Get("group_by"), "proto"))
+	if field != "proto" && field != "service" {
+		return nil, errBreakdownGroupBy
+	}
+	key, _ := models.StringFieldAccessor(field)
+	bucketSize, err := parseTimelineBucket(query, connections)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]*breakdownTraffic)
+	cells := make(map[string]map[int64]*breakdownTraffic)
+	bucketSet := make(map[int64]bool)
+	for i := range connections {
+		conn := &connections[i]
+		value := cmp.Or(key(conn), timelineNoValue)
+		bucket := bucketStart(int64(conn.Timestamp), bucketSize, loc)
+		if !bucketSet[bucket] {
+			if len(bucketSet) == maxBreakdownBuckets {
+				return nil, errBreakdownBuckets
+			}
+			bucketSet[bucket] = true
+		}
+
+		total := totals[value]
+		if total == nil {
+			total = &breakdownTraffic{}
+			totals[value] = total
+			cells[value] = make(map[int64]*breakdownTraffic)
+		}
+		cell := cells[value][bucket]
+		if cell == nil {
+			cell = &breakdownTraffic{}
+			cells[value][bucket] = cell
+		}
+		traffic := breakdownTraffic{connections: 1, bytes: conn.TotalBytes(), packets: conn.OrigPackets + conn.RespPackets}
+		total.add(traffic)
+		cell.add(traffic)
+	}
+
+	keys := make([]string, 0, len(totals))
+	for value := range totals {
+		keys = append(keys, value)
+	}
+	slices.SortFunc(keys, func(x, y string) int {
+		return cmp.Or(cmp.Compare(totals[y].connections, totals[x].connections), strings.Compare(x, y))
+	})
+	if len(keys) > maxTimelineSeries {
+		other, otherCells := &breakdownTraffic{}, make(map[int64]*breakdownTraffic)
+		for _, value := range keys[maxTimelineSeries-1:] {
+			other.add(*totals[value])
+			for bucket, cell := range cells[value] {
+				merged := otherCells[bucket]
+				if merged == nil {
+					merged = &breakdownTraffic{}
+					otherCells[bucket] = merged
+				}
+				merged.add(*cell)
+			}
+		}
+		keys = append(keys[:maxTimelineSeries-1], timelineOtherSeries)
+		totals[timelineOtherSeries], cells[timelineOtherSeries] = other, otherCells
+	}
+
+	breakdown := &statsBreakdown{
+		BucketSize: bucketSize,
+		GroupBy:    field,
+		Buckets:    slices.Sorted(maps.Keys(bucketSet)),
+		Series:     make([]breakdownSeries, 0, len(keys)),
+	}
+	if breakdown.Buckets == nil {
+		breakdown.Buckets = []int64{}
+	}
+	if loc != nil {
+		breakdown.BucketsLocal = make([]string, len(breakdown.Buckets))
+		for i, bucket := range breakdown.Buckets {
+			breakdown.BucketsLocal[i] = formatLocal(bucket, loc)
+		}
+	}
+	for _, value := range keys {
+		series := breakdownSeries{
+			Key:              value,
+			TotalConnections: totals[value].connections,
+			TotalBytes:       totals[value].bytes,
+			TotalPackets:     totals[value].packets,
+			Connections:      make([]int, len(breakdown.Buckets)),
+			Bytes:            make([]int, len(breakdown.Buckets)),
+			Packets:          make([]int, len(breakdown.Buckets)),
+		}
+		for i, bucket := range breakdown.Buckets {
+			if cell := cells[value][bucket]; cell != nil {
+				series.Connections[i], series.Bytes[i], series.Packets[i] = cell.connections, cell.bytes, cell.packets
+			}
+		}
+		breakdown.Series = append(breakdown.Series, series)
+	}
+
+	return breakdown, nil
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"log"
+	"net/url"
+	"slices"
+	"time"
+
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+)
+
+const (
+	cacheCold    = "cold"    // Derived data will be computed on first request
+	cacheWarming = "warming" // Derived data is being precomputed in the background
+	cacheWarm    = "warm"    // Stats, graph, and default timeline are ready
+)
+
+// graphCache holds the unfiltered network graph of a file.
+type graphCache struct {
+	nodes []models.Node
+	edges []models.Edge
+	local models.LocalNetworks // Networks the nodes' locality was judged by
+}
+
+// warmCaches precomputes the connection index, unfiltered graph, and default timeline in the
+// background so the first requests after switching to a file don't pay for them. Stats are
+// computed at ingest.
+func (f *FileData) warmCaches(local models.LocalNetworks) {
+	f.cacheMu.Lock()
+	if f.warming || f.cachesReady() {
+		f.cacheMu.Unlock()
+
+		return
+	}
+	f.warming = true
+	f.cacheMu.Unlock()
+
+	filename := f.Filename // Read under the API lock held by the caller
+	go func() {
+		started := time.Now()
+		f.buildIndex()
+		f.timeline(timelineBucketSec, nil)
+		f.graph(local)
+
+		f.cacheMu.Lock()
+		f.warming = false
+		f.cacheMu.Unlock()
+
+		log.Printf("Warmed caches of %s in %s", filename, time.Since(started).Round(time.Millisecond))
+	}()
+}
+
+// cacheStatus reports whether the file's derived data is ready.
+func (f *FileData) cacheStatus() string {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	switch {
+	case f.cachesReady():
+		return cacheWarm
+	case f.warming:
+		return cacheWarming
+	default:
+		return cacheCold
+	}
+}
+
+// cachesReady reports whether the index, graph, and default timeline are cached. Callers must
+// hold cacheMu.
+func (f *FileData) cachesReady() bool {
+	_, timelineReady := f.timelineCache[timelineKey{bucketSize: timelineBucketSec}]
+
+	return timelineReady && f.graphCache != nil && f.index != nil
+}
+
+// graph returns the unfiltered nodes and edges of the file, computing them on first use and
+// again when the local networks change. The edges are a copy, so callers may reorder or
+// truncate them.
+func (f *FileData) graph(local models.LocalNetworks) ([]models.Node, []models.Edge) {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	if f.graphCache == nil || !slices.Equal(f.graphCache.local, local) {
+		nodes, edges := netgraph.NodesAndEdges(f.Connections, local, netgraph.ByProtocol)
+		f.graphCache = &graphCache{nodes: nodes, edges: edges, local: local}
+	}
+
+	return f.graphCache.nodes, slices.Clone(f.graphCache.edges)
+}
+
+// scans returns the scans of the file at the default thresholds, computing them on first use.
+func (f *FileData) scans() []Scan {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	if f.scanCache == nil {
+		f.scanCache = detectScans(f.Connections, defaultScanThresholds())
+	}
+
+	return f.scanCache
+}
+
+// isUnfiltered reports whether a query leaves the connections unfiltered, so cached
+// whole-file results can be used.
+func isUnfiltered(query url.Values) bool {
+	for _, param := range filterParams() {
+		if query.Get(param) != "" {
+			return false
+		}
+	}
+
+	return true
+}
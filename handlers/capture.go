@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"zeek-viz/models"
+	"zeek-viz/parse"
+	"zeek-viz/pcap"
+)
+
+const (
+	captureFormat   = "pcap"    // Packet capture, in pcap or pcapng format
+	gzCaptureFormat = "pcap.gz" // Gzip-compressed packet capture
+	zeekCapture     = "capture" // Name of the capture in Zeek's working directory
+	zeekOutputTail  = 512       // Bytes of Zeek's output an error reports
+)
+
+var (
+	errBadCapture = errors.New("unreadable packet capture")
+	errZeekFailed = errors.New("zeek failed to read the packet capture")
+)
+
+// SetZeek makes uploaded packet captures be read by the Zeek binary at path, which may be a
+// name looked up in PATH, rather than by the built-in flow extraction. Zeek then also writes
+// the protocol logs of the capture, and identifies services. An empty path uses the built-in
+// extraction.
+func (a *API) SetZeek(path string) error {
+	if path == "" {
+		a.zeekPath = ""
+
+		return nil
+	}
+
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return fmt.Errorf("zeek binary: %w", err)
+	}
+	a.zeekPath = resolved
+
+	return nil
+}
+
+// walkCapture calls visit with the logs of a packet capture, named after it: conn.log from
+// the built-in flow extraction, or every log Zeek writes with --zeek.
+func (a *API) walkCapture(ctx context.Context, name string, capture io.Reader, visit batchVisitor) error {
+	if a.zeekPath != "" {
+		return a.runZeek(ctx, name, capture, visit)
+	}
+
+	connections, summary, err := pcap.Extract(ctx, capture)
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %w", parse.ErrCanceled, ctx.Err())
+	case err != nil:
+		return fmt.Errorf("%w %s: %w", errBadCapture, name, err)
+	}
+	log.Printf("Extracted %d connections from %d packets of %s (%d packets undecoded)",
+		summary.Flows, summary.Packets, name, summary.Skipped)
+	if summary.Truncated {
+		log.Printf("Packet capture %s is truncated; its last packet was dropped", name)
+	}
+
+	// The parser reads the conn.log as it is written, rather than from a copy of all of it
+	connLog, writer := io.Pipe()
+	go func() {
+		err := models.WriteNDJSON(writer, connections, nil, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to write connections of %s: %w", name, err)
+		}
+		writer.CloseWithError(err)
+	}()
+	err = visit(name+"/conn.log", connLog)
+	connLog.Close() // Stops the writer when visit didn't read to the end
+
+	return err
+}
+
+// runZeek runs Zeek on a packet capture in a temporary directory and calls visit with every
+// log it writes. Checksums aren't verified, as captures taken on the sending host carry
+// those its network card would have filled in.
+func (a *API) runZeek(ctx context.Context, name string, capture io.Reader, visit batchVisitor) error {
+	dir, err := os.MkdirTemp("", "zeek-viz-capture-")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory for Zeek: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := os.Create(filepath.Join(dir, zeekCapture))
+	if err != nil {
+		return fmt.Errorf("failed to write %s for Zeek: %w", name, err)
+	}
+	_, err = io.Copy(file, capture)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write %s for Zeek: %w", name, err)
+	}
+
+	cmd := exec.CommandContext(ctx, a.zeekPath, "-C", "-r", zeekCapture) //nolint:gosec // The binary is the operator's --zeek
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %w", parse.ErrCanceled, ctx.Err())
+	case err != nil:
+		output = bytes.TrimSpace(output)
+		if len(output) > zeekOutputTail {
+			output = output[len(output)-zeekOutputTail:]
+		}
+
+		return fmt.Errorf("%w %s: %w: %s", errZeekFailed, name, err, output)
+	}
+
+	logs, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return fmt.Errorf("failed to list Zeek's logs: %w", err)
+	}
+	slices.Sort(logs)
+	log.Printf("Zeek wrote %d logs for %s", len(logs), name)
+	for _, path := range logs {
+		err = visitZeekLog(path, name+"/"+filepath.Base(path), visit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visitZeekLog calls visit with a log Zeek wrote.
+func visitZeekLog(path, name string, visit batchVisitor) error {
+	file, err := os.Open(path) //nolint:gosec // Zeek's working directory
+	if err != nil {
+		return fmt.Errorf("failed to open Zeek's %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	return visit(name, file)
+}
+
+// captureFormatOf returns captureFormat, or gzCaptureFormat when gzipped, when content starts
+// like a packet capture, and "" otherwise.
+func captureFormatOf(head []byte, gzipped bool) string {
+	switch {
+	case !pcap.IsCapture(head):
+		return ""
+	case gzipped:
+		return gzCaptureFormat
+	default:
+		return captureFormat
+	}
+}
+
+// walkGzCapture decompresses a gzip-compressed packet capture for walkCapture.
+func (a *API) walkGzCapture(ctx context.Context, name string, file io.Reader, visit batchVisitor) error {
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	defer decompressed.Close()
+
+	return a.walkCapture(ctx, strings.TrimSuffix(name, ".gz"), decompressed, visit)
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	maxClusters          = 20  // Upper bound of the k parameter
+	maxAutoClusters      = 8   // Upper bound of the automatically chosen k
+	minClusterHosts      = 3   // Hosts needed before clustering is meaningful
+	maxClusterIterations = 100 // k-means iterations before giving up on convergence
+	outlierThreshold     = 2.0 // Standard deviations above the mean distance that make an outlier
+	minSingletonHosts    = 10  // Hosts needed before a single-host cluster counts as an outlier
+)
+
+// clusterFeatures returns the names of the per-host features, in vector order.
+func clusterFeatures() []string {
+	return []string{"connections", "bytes", "peers", "ports", "sent_ratio", "failed_ratio", "active_hours"}
+}
+
+// hostProfile accumulates the behavior of one host.
+type hostProfile struct {
+	connections int
+	bytes       int
+	sent        int
+	failed      int
+	peers       map[string]bool
+	ports       map[int]bool
+	hours       map[int]bool
+}
+
+// HostCluster is a group of hosts with similar behavior.
+type HostCluster struct {
+	ID       int                `json:"id"`
+	Size     int                `json:"size"`
+	Centroid map[string]float64 `json:"centroid"` // Mean raw feature values of the members
+	Hosts    []string           `json:"hosts"`
+}
+
+// HostOutlier is a host that behaves unlike the rest of its cluster.
+type HostOutlier struct {
+	Host     string             `json:"host"`
+	Cluster  int                `json:"cluster"`
+	Distance float64            `json:"distance"` // Distance to the cluster centroid in standard deviations of the features
+	Score    float64            `json:"score"`    // Standard deviations above the mean distance of all hosts
+	Reason   string             `json:"reason"`
+	Features map[string]float64 `json:"features"`
+}
+
+// GetClusters groups the hosts of the current dataset by behavior with k-means over per-host
+// features (connections, bytes, peers, ports, sent ratio, failed ratio, active hours) and
+// reports the hosts that stand out from their group.
+func (a *API) GetClusters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	hosts, vectors := hostFeatureVectors(connections)
+
+	k := parseLimit(query, "k")
+	if k == 0 {
+		k = int(math.Round(math.Sqrt(float64(len(hosts)) / 2))) //nolint:mnd // Rule-of-thumb k = sqrt(n/2)
+		k = max(2, min(k, maxAutoClusters))                     //nolint:mnd // At least two groups
+	}
+	k = min(k, maxClusters, len(hosts))
+
+	clusters, outliers, err := clusterHosts(r.Context(), hosts, vectors, k)
+	if err != nil {
+		writeCanceled(w, "Host clustering", err)
+
+		return
+	}
+
+	response := map[string]any{
+		"k":        len(clusters),
+		"features": clusterFeatures(),
+		"hosts":    len(hosts),
+		"clusters": clusters,
+		"outliers": outliers,
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode clusters: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// hostFeatureVectors returns the hosts of the connections, sorted, and their raw feature vectors.
+func hostFeatureVectors(connections []models.Connection) ([]string, [][]float64) {
+	profiles := make(map[string]*hostProfile)
+	profile := func(host string) *hostProfile {
+		if profiles[host] == nil {
+			profiles[host] = &hostProfile{peers: map[string]bool{}, ports: map[int]bool{}, hours: map[int]bool{}}
+		}
+
+		return profiles[host]
+	}
+
+	for i := range connections {
+		conn := &connections[i]
+		hour := time.Unix(int64(conn.Timestamp), 0).UTC().Hour()
+		failed := slices.Contains(models.FailedStates(), conn.ConnState)
+
+		origin := profile(conn.OrigHost)
+		origin.connections++
+		origin.bytes += conn.TotalBytes()
+		origin.sent += conn.OrigBytes
+		origin.peers[conn.RespHost] = true
+		origin.ports[conn.RespPort] = true
+		origin.hours[hour] = true
+		if failed {
+			origin.failed++
+		}
+
+		responder := profile(conn.RespHost)
+		responder.connections++
+		responder.bytes += conn.TotalBytes()
+		responder.sent += conn.RespBytes
+		responder.peers[conn.OrigHost] = true
+		responder.hours[hour] = true
+	}
+
+	hosts := make([]string, 0, len(profiles))
+	for host := range profiles {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	vectors := make([][]float64, len(hosts))
+	for i, host := range hosts {
+		p := profiles[host]
+		sentRatio := 0.0
+		if p.bytes > 0 {
+			sentRatio = float64(p.sent) / float64(p.bytes)
+		}
+		vectors[i] = []float64{
+			float64(p.connections),
+			float64(p.bytes),
+			float64(len(p.peers)),
+			float64(len(p.ports)),
+			sentRatio,
+			float64(p.failed) / float64(p.connections),
+			float64(len(p.hours)),
+		}
+	}
+
+	return hosts, vectors
+}
+
+// clusterHosts groups the hosts with k-means over their standardized feature vectors and
+// returns the clusters, largest first, and the outliers, most unusual first. It stops with
+// the context's error once ctx is done.
+func clusterHosts(ctx context.Context, hosts []string, vectors [][]float64, k int) ([]HostCluster, []HostOutlier, error) {
+	clusters := make([]HostCluster, 0, k)
+	outliers := make([]HostOutlier, 0)
+	if len(hosts) == 0 {
+		return clusters, outliers, nil
+	}
+	if len(hosts) < minClusterHosts {
+		k = 1
+	}
+
+	scaled := standardizeFeatures(vectors)
+	assignments, centroids, err := kMeans(ctx, scaled, k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := make([][]int, len(centroids))
+	distances := make([]float64, len(hosts))
+	for i, cluster := range assignments {
+		members[cluster] = append(members[cluster], i)
+		distances[i] = euclidean(scaled[i], centroids[cluster])
+	}
+
+	// Number clusters by size, so IDs are stable for the same data
+	order := make([]int, 0, len(members))
+	for cluster := range members {
+		if len(members[cluster]) > 0 {
+			order = append(order, cluster)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(members[order[i]]) > len(members[order[j]])
+	})
+	ids := make(map[int]int, len(order))
+	for id, cluster := range order {
+		ids[cluster] = id
+		clusters = append(clusters, newHostCluster(id, members[cluster], hosts, vectors))
+	}
+
+	mean, stddev := meanStddev(distances)
+	for i, host := range hosts {
+		cluster := assignments[i]
+		outlier := HostOutlier{
+			Host:     host,
+			Cluster:  ids[cluster],
+			Distance: roundTenth(distances[i]),
+			Features: featureMap(vectors[i]),
+		}
+		switch {
+		case stddev > 0 && (distances[i]-mean)/stddev > outlierThreshold:
+			outlier.Score = roundTenth((distances[i] - mean) / stddev)
+			outlier.Reason = "far from cluster centroid"
+		case len(members[cluster]) == 1 && len(hosts) >= minSingletonHosts:
+			outlier.Reason = "only host in its cluster"
+		default:
+			continue
+		}
+		outliers = append(outliers, outlier)
+	}
+	sort.SliceStable(outliers, func(i, j int) bool {
+		return outliers[i].Score > outliers[j].Score
+	})
+
+	return clusters, outliers, nil
+}
+
+// newHostCluster describes the cluster of the given member indexes.
+func newHostCluster(id int, members []int, hosts []string, vectors [][]float64) HostCluster {
+	centroid := make([]float64, len(clusterFeatures()))
+	cluster := HostCluster{ID: id, Size: len(members), Hosts: make([]string, 0, len(members))}
+	for _, member := range members {
+		cluster.Hosts = append(cluster.Hosts, hosts[member])
+		for feature, value := range vectors[member] {
+			centroid[feature] += value / float64(len(members))
+		}
+	}
+	cluster.Centroid = featureMap(centroid)
+
+	return cluster
+}
+
+// featureMap names the values of a feature vector, rounded to one decimal.
+func featureMap(vector []float64) map[string]float64 {
+	features := make(map[string]float64, len(vector))
+	for i, name := range clusterFeatures() {
+		features[name] = roundTenth(vector[i])
+	}
+
+	return features
+}
+
+// standardizeFeatures log-scales the count features and converts every feature to z-scores,
+// so features of different magnitude weigh the same.
+func standardizeFeatures(vectors [][]float64) [][]float64 {
+	scaled := make([][]float64, len(vectors))
+	for i, vector := range vectors {
+		scaled[i] = slices.Clone(vector)
+		for feature, name := range clusterFeatures() {
+			if name != "sent_ratio" && name != "failed_ratio" {
+				scaled[i][feature] = math.Log1p(vector[feature])
+			}
+		}
+	}
+
+	column := make([]float64, len(scaled))
+	for feature := range clusterFeatures() {
+		for i := range scaled {
+			column[i] = scaled[i][feature]
+		}
+		mean, stddev := meanStddev(column)
+		for i := range scaled {
+			scaled[i][feature] = 0
+			if stddev > 0 {
+				scaled[i][feature] = (column[i] - mean) / stddev
+			}
+		}
+	}
+
+	return scaled
+}
+
+// kMeans partitions the points into k clusters and returns each point's cluster and the
+// centroids. Centroids start at evenly spaced points ordered by distance from the origin,
+// which keeps the result deterministic. It stops with the context's error once ctx is done.
+func kMeans(ctx context.Context, points [][]float64, k int) ([]int, [][]float64, error) {
+	byNorm := make([]int, len(points))
+	for i := range byNorm {
+		byNorm[i] = i
+	}
+	origin := make([]float64, len(points[0]))
+	sort.SliceStable(byNorm, func(i, j int) bool {
+		return euclidean(points[byNorm[i]], origin) < euclidean(points[byNorm[j]], origin)
+	})
+
+	centroids := make([][]float64, k)
+	for cluster := range centroids {
+		centroids[cluster] = slices.Clone(points[byNorm[(2*cluster+1)*len(points)/(2*k)]])
+	}
+
+	assignments := make([]int, len(points))
+	for iteration := 0; iteration < maxClusterIterations; iteration++ {
+		err := ctx.Err()
+		if err != nil {
+			return nil, nil, fmt.Errorf("clustering canceled: %w", err)
+		}
+
+		changed := iteration == 0
+		for i, point := range points {
+			nearest := nearestCentroid(point, centroids)
+			if nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for i, cluster := range assignments {
+			if sums[cluster] == nil {
+				sums[cluster] = make([]float64, len(points[i]))
+			}
+			for feature, value := range points[i] {
+				sums[cluster][feature] += value
+			}
+			counts[cluster]++
+		}
+		for cluster := range centroids {
+			if counts[cluster] == 0 {
+				continue // An empty cluster keeps its centroid
+			}
+			for feature := range centroids[cluster] {
+				centroids[cluster][feature] = sums[cluster][feature] / float64(counts[cluster])
+			}
+		}
+	}
+
+	return assignments, centroids, nil
+}
+
+// nearestCentroid returns the index of the centroid closest to the point.
+func nearestCentroid(point []float64, centroids [][]float64) int {
+	nearest, best := 0, math.Inf(1)
+	for cluster, centroid := range centroids {
+		if distance := euclidean(point, centroid); distance < best {
+			nearest, best = cluster, distance
+		}
+	}
+
+	return nearest
+}
+
+// euclidean returns the Euclidean distance between two vectors of equal length.
+func euclidean(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += (a[i] - b[i]) * (a[i] - b[i])
+	}
+
+	return math.Sqrt(sum)
+}
+
+// meanStddev returns the mean and population standard deviation of the values.
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum, squares float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
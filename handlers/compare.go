@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const defaultCompareLimit = 100 // Entries returned per list by default
+
+var errCompareFiles = errors.New("base and other file IDs are required")
+
+// CompareDataset identifies one side of a comparison.
+type CompareDataset struct {
+	FileID      string `json:"file_id"` //nolint:tagliatelle // API consistency
+	Filename    string `json:"filename"`
+	Connections int    `json:"connections"` // Connections matching the filters
+}
+
+// ComparePair is a source and destination with its totals in one or both datasets.
+type ComparePair struct {
+	Src        string `json:"src"`
+	Dst        string `json:"dst"`
+	BaseCount  int    `json:"base_count"`         //nolint:tagliatelle // API consistency
+	OtherCount int    `json:"other_count"`        //nolint:tagliatelle // API consistency
+	CountDelta int    `json:"count_delta"`        //nolint:tagliatelle // API consistency
+	BaseBytes  int    `json:"base_bytes"`         //nolint:tagliatelle // API consistency
+	OtherBytes int    `json:"other_bytes"`        //nolint:tagliatelle // API consistency
+	BytesDelta int    `json:"bytes_delta"`        //nolint:tagliatelle // API consistency
+	Services   string `json:"services,omitempty"` // Services seen on the pair, comma-separated
+
+	services map[string]struct{} // Services seen on the pair, until joined into Services
+	inBase   bool
+	inOther  bool
+}
+
+// CompareService is a Zeek service with its connection count in the dataset it appears in.
+type CompareService struct {
+	Service     string `json:"service"`
+	Connections int    `json:"connections"`
+}
+
+// CompareList is the entries of a comparison found in only one dataset, or in both.
+type CompareList[T any] struct {
+	Entries   []T  `json:"entries"`
+	Total     int  `json:"total"`
+	Truncated bool `json:"truncated"`
+}
+
+// datasetProfile holds the hosts and services of one side of a comparison.
+type datasetProfile struct {
+	hosts    map[string]struct{}
+	services map[string]int
+}
+
+// CompareDatasets reports what changed between two datasets: the hosts, source and
+// destination pairs, and services present in only one of them, and the count and byte
+// deltas of the pairs present in both. Accepts base and other file IDs, the standard
+// filters, which apply to both, and limit.
+func (a *API) CompareDatasets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	baseID, otherID := query.Get("base"), query.Get("other")
+	if baseID == "" || otherID == "" {
+		http.Error(w, errCompareFiles.Error(), http.StatusBadRequest)
+
+		return
+	}
+	baseFile, otherFile := a.files[baseID], a.files[otherID]
+	if baseFile == nil || otherFile == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultCompareLimit
+	}
+
+	baseConns, err := a.filterFile(baseFile, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	otherConns, err := a.filterFile(otherFile, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	pairs := make(map[string]*ComparePair)
+	base := profileDataset(baseConns, pairs, true)
+	other := profileDataset(otherConns, pairs, false)
+
+	added, removed, changed := make([]ComparePair, 0), make([]ComparePair, 0), make([]ComparePair, 0)
+	for _, pair := range pairs {
+		pair.CountDelta = pair.OtherCount - pair.BaseCount
+		pair.BytesDelta = pair.OtherBytes - pair.BaseBytes
+		pair.Services = joinServices(pair.services)
+		switch {
+		case !pair.inBase:
+			added = append(added, *pair)
+		case !pair.inOther:
+			removed = append(removed, *pair)
+		case pair.CountDelta != 0 || pair.BytesDelta != 0:
+			changed = append(changed, *pair)
+		}
+	}
+	sortComparePairs(added, func(p ComparePair) int { return p.OtherBytes })
+	sortComparePairs(removed, func(p ComparePair) int { return p.BaseBytes })
+	sortComparePairs(changed, func(p ComparePair) int { return max(p.BytesDelta, -p.BytesDelta) })
+
+	response := map[string]any{
+		"base":  CompareDataset{FileID: baseID, Filename: baseFile.Filename, Connections: len(baseConns)},
+		"other": CompareDataset{FileID: otherID, Filename: otherFile.Filename, Connections: len(otherConns)},
+		"nodes": map[string]any{
+			"added":   compareList(onlyIn(other.hosts, base.hosts), limit),
+			"removed": compareList(onlyIn(base.hosts, other.hosts), limit),
+		},
+		"edges": map[string]any{
+			"added":   compareList(added, limit),
+			"removed": compareList(removed, limit),
+		},
+		"services": map[string]any{
+			"added":   compareList(servicesOnlyIn(other.services, base.services), limit),
+			"removed": compareList(servicesOnlyIn(base.services, other.services), limit),
+		},
+		"deltas": compareList(changed, limit),
+		"limits": map[string]int{"limit": limit},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode comparison: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// profileDataset collects the hosts and services of one side of a comparison and adds its
+// pair totals to pairs.
+func profileDataset(connections []models.Connection, pairs map[string]*ComparePair, isBase bool) datasetProfile {
+	profile := datasetProfile{hosts: make(map[string]struct{}), services: make(map[string]int)}
+	for i := range connections {
+		conn := &connections[i]
+		profile.hosts[conn.OrigHost] = struct{}{}
+		profile.hosts[conn.RespHost] = struct{}{}
+		if conn.Service != "" {
+			profile.services[conn.Service]++
+		}
+
+		key := conn.OrigHost + groupKeySeparator + conn.RespHost
+		pair, exists := pairs[key]
+		if !exists {
+			pair = &ComparePair{Src: conn.OrigHost, Dst: conn.RespHost, services: make(map[string]struct{})}
+			pairs[key] = pair
+		}
+		if isBase {
+			pair.inBase = true
+			pair.BaseCount++
+			pair.BaseBytes += conn.TotalBytes()
+		} else {
+			pair.inOther = true
+			pair.OtherCount++
+			pair.OtherBytes += conn.TotalBytes()
+		}
+		if conn.Service != "" {
+			pair.services[conn.Service] = struct{}{}
+		}
+	}
+
+	return profile
+}
+
+// onlyIn returns the sorted keys of set that aren't in exclude.
+func onlyIn(set, exclude map[string]struct{}) []string {
+	keys := make([]string, 0)
+	for key := range set {
+		if _, exists := exclude[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// servicesOnlyIn returns the services of counts that aren't in exclude, most used first.
+func servicesOnlyIn(counts, exclude map[string]int) []CompareService {
+	services := make([]CompareService, 0)
+	for service, connections := range counts {
+		if _, exists := exclude[service]; !exists {
+			services = append(services, CompareService{Service: service, Connections: connections})
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Connections != services[j].Connections {
+			return services[i].Connections > services[j].Connections
+		}
+
+		return services[i].Service < services[j].Service
+	})
+
+	return services
+}
+
+// sortComparePairs orders pairs by weight, largest first, then by source and destination.
+func sortComparePairs(pairs []ComparePair, weight func(ComparePair) int) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if weight(pairs[i]) != weight(pairs[j]) {
+			return weight(pairs[i]) > weight(pairs[j])
+		}
+		if pairs[i].Src != pairs[j].Src {
+			return pairs[i].Src < pairs[j].Src
+		}
+
+		return pairs[i].Dst < pairs[j].Dst
+	})
+}
+
+// joinServices returns the services sorted and comma-separated.
+func joinServices(services map[string]struct{}) string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ",")
+}
+
+// compareList caps entries at limit.
+func compareList[T any](entries []T, limit int) CompareList[T] {
+	return CompareList[T]{Entries: entries[:min(limit, len(entries))], Total: len(entries), Truncated: len(entries) > limit}
+}
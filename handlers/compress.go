@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	minCompressSize  = 1024           // Smaller responses are sent as they are; compressing them saves little
+	compressionLevel = gzip.BestSpeed // Responses are compressed as they are written, so speed beats ratio
+)
+
+// compressibleTypes returns the media types worth compressing; other types, such as pcaps
+// and archives, are compressed already or binary.
+func compressibleTypes() []string {
+	return []string{"application/json", "application/x-ndjson", "application/xml", "application/javascript", "image/svg+xml"}
+}
+
+// Compressed gzip-compresses responses of at least minCompressSize bytes for clients that
+// accept it. Responses with a Content-Encoding of their own, such as precompressed static
+// assets, partial content, and types other than text and JSON are passed through.
+func Compressed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || !acceptsEncoding(r, "gzip") {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		writer := &gzipWriter{ResponseWriter: w, status: http.StatusOK}
+		defer writer.close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// gzipWriter holds back the start of a response until it knows whether to compress it: once
+// minCompressSize bytes are written, the handler flushes, or the handler returns.
+type gzipWriter struct {
+	http.ResponseWriter
+
+	status  int
+	buffer  []byte // Body written before the decision
+	decided bool
+	gzip    *gzip.Writer // Set when the response is compressed
+}
+
+// WriteHeader records the status code until the response is started.
+func (g *gzipWriter) WriteHeader(status int) {
+	if g.decided {
+		return
+	}
+	g.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified || status < http.StatusOK {
+		_ = g.start(false) // Nothing is buffered to fail on
+	}
+}
+
+// Write buffers the start of the body and compresses or forwards the rest.
+func (g *gzipWriter) Write(data []byte) (int, error) {
+	if !g.decided {
+		g.buffer = append(g.buffer, data...)
+		if len(g.buffer) < minCompressSize {
+			return len(data), nil
+		}
+
+		return len(data), g.start(true)
+	}
+	if g.gzip != nil {
+		return g.gzip.Write(data) //nolint:wrapcheck // Transparent wrapper
+	}
+
+	return g.ResponseWriter.Write(data) //nolint:wrapcheck // Transparent wrapper
+}
+
+// Flush sends what was written so far, compressing event streams and other flushed
+// responses regardless of their size.
+func (g *gzipWriter) Flush() {
+	if !g.decided {
+		_ = g.start(true) // Write errors surface to the handler's next write
+	}
+	if g.gzip != nil {
+		_ = g.gzip.Flush() // Write errors surface to the handler's next write
+	}
+	_ = http.NewResponseController(g.ResponseWriter).Flush() // Not every ResponseWriter flushes
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can extend deadlines
+// through the compressor.
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// start sends the header, compressing the body from here on when large is set and the
+// response is worth compressing, and then the buffered body.
+func (g *gzipWriter) start(large bool) error {
+	g.decided = true
+
+	header := g.Header()
+	if header.Get("Content-Type") == "" && len(g.buffer) > 0 {
+		header.Set("Content-Type", http.DetectContentType(g.buffer)) // Sniffed here, as compressed bytes would read as gzip
+	}
+	compressible := g.compressible()
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if compressible && large {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		g.gzip, _ = gzip.NewWriterLevel(g.ResponseWriter, compressionLevel) // The level is valid
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buffered := g.buffer
+	g.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	if g.gzip != nil {
+		_, err := g.gzip.Write(buffered)
+
+		return err //nolint:wrapcheck // Transparent wrapper
+	}
+	_, err := g.ResponseWriter.Write(buffered)
+
+	return err //nolint:wrapcheck // Transparent wrapper
+}
+
+// compressible reports whether the response may be compressed.
+func (g *gzipWriter) compressible() bool {
+	header := g.Header()
+	if g.status == http.StatusPartialContent || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || slices.Contains(compressibleTypes(), mediaType) ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// close sends a response the handler left short of minCompressSize and completes the
+// compressed stream.
+func (g *gzipWriter) close() {
+	if !g.decided {
+		_ = g.start(false) // The client is gone when this fails
+	}
+	if g.gzip != nil {
+		_ = g.gzip.Close() // The client is gone when this fails
+	}
+}
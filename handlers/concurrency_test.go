@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"zeek-viz/synth"
+)
+
+// syntheticLog returns a small synthetic conn.log; different seeds give different logs.
+func syntheticLog(t *testing.T, seed uint64) []byte {
+	t.Helper()
+
+	var log bytes.Buffer
+	_, err := synth.Generate(&log, synth.Config{
+		Hosts:    3,
+		Duration: 10 * time.Minute,
+		Start:    time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Rate:     2,
+		Seed:     seed,
+	})
+	if err != nil {
+		t.Fatalf("generating log: %v", err)
+	}
+
+	return log.Bytes()
+}
+
+// uploadRequest returns a multipart upload of content as the logfile field.
+func uploadRequest(t *testing.T, name string, content []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("logfile", name)
+	if err == nil {
+		_, err = part.Write(content)
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		t.Fatalf("building upload: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	return r
+}
+
+// TestConcurrentUploadsDeletesAndQueries runs uploads, deletes, selections, and queries
+// against one API at once. Run it with -race: it checks that the handlers take the locks
+// they need, and that no request fails because of another.
+func TestConcurrentUploadsDeletesAndQueries(t *testing.T) {
+	const uploaders, queriers, rounds = 4, 4, 5
+
+	api, mux := newDemoServer(t)
+	logs := make([][]byte, uploaders*rounds)
+	for i := range logs {
+		logs[i] = syntheticLog(t, uint64(i+1))
+	}
+	queries := []string{
+		"/api/stats", "/api/nodes", "/api/connections?limit=20", "/api/timeline", "/api/files",
+		"/api/connections/count?proto=udp", "/api/nodes?edge_by=pair&limit=10",
+	}
+
+	var wg sync.WaitGroup
+	failures := make(chan string, uploaders*rounds*3+queriers*rounds*len(queries))
+	for uploader := range uploaders {
+		wg.Go(func() {
+			for round := range rounds {
+				index := uploader*rounds + round
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, uploadRequest(t, fmt.Sprintf("conn-%d.log", index), logs[index]))
+				if w.Code != http.StatusOK {
+					failures <- fmt.Sprintf("upload %d: status %d: %s", index, w.Code, w.Body)
+
+					continue
+				}
+				var uploaded struct {
+					FileID string `json:"file_id"` //nolint:tagliatelle // API consistency
+				}
+				err := json.Unmarshal(w.Body.Bytes(), &uploaded)
+				if err != nil || uploaded.FileID == "" {
+					failures <- fmt.Sprintf("upload %d: no file ID in %s", index, w.Body)
+
+					continue
+				}
+
+				if selected := serve(mux, http.MethodPost, "/api/files/"+uploaded.FileID+"/select", "", nil); selected.Code != http.StatusOK {
+					failures <- fmt.Sprintf("select %d: status %d: %s", index, selected.Code, selected.Body)
+				}
+				if round%2 == 1 {
+					if deleted := serve(mux, http.MethodDelete, "/api/files/"+uploaded.FileID, "", nil); deleted.Code != http.StatusOK {
+						failures <- fmt.Sprintf("delete %d: status %d: %s", index, deleted.Code, deleted.Body)
+					}
+				}
+			}
+		})
+	}
+	for range queriers {
+		wg.Go(func() {
+			for range rounds {
+				for _, target := range queries {
+					// The current dataset may be deleted between selection and query
+					if w := serve(mux, http.MethodGet, target, "", nil); w.Code >= http.StatusInternalServerError {
+						failures <- fmt.Sprintf("GET %s: status %d: %s", target, w.Code, w.Body)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(failures)
+	for failure := range failures {
+		t.Error(failure)
+	}
+
+	// The demo dataset and the uploads of even rounds remain
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	if want := 1 + uploaders*((rounds+1)/2); len(api.files) != want {
+		t.Errorf("%d datasets loaded, want %d", len(api.files), want)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	etagBytes         = 16                  // Bytes of the response version digest in ETags
+	revalidatePrivate = "private, no-cache" // Query results depend on the workspace and are revalidated every time
+)
+
+// Conditional tags GET responses with an ETag and Last-Modified derived from the version of
+// the data they read, and answers requests whose If-None-Match still matches with 304 Not
+// Modified without running handler. The ETag changes when the dataset is replaced or
+// restitched or when suppressions, annotations, local networks, IOC lists, or the watchlist
+// change. Live datasets and queries across several datasets are always answered in full.
+// Callers must hold a.mu, for reading.
+func (a *API) Conditional(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := a.responseVersion(r)
+		if version == "" {
+			handler(w, r)
+
+			return
+		}
+
+		digest := sha256.Sum256([]byte(version))
+		etag := `W/"` + hex.EncodeToString(digest[:etagBytes]) + `"` // Weak, as compression changes the bytes
+		modified := a.lastModified(a.files[a.currentDataset(r)])
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", revalidatePrivate)
+
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// lastModified returns when a dataset or the settings its results depend on last changed.
+// Callers must hold a.mu, for reading.
+func (a *API) lastModified(fileData *FileData) time.Time {
+	return time.Unix(max(fileData.UploadTime, a.settingsVersion/int64(time.Second)), 0)
+}
+
+// notModified reports whether the client's copy of a response is current. If-None-Match is
+// compared when present; If-Modified-Since only for requests naming their dataset with
+// file_id, as the current dataset of other URLs changes when a workspace switches.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return etagMatches(match, etag)
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+
+	return err == nil && r.URL.Query().Get("file_id") != "" && !modified.After(since)
+}
+
+// etagMatches reports whether an If-None-Match list holds etag, comparing weakly.
+func etagMatches(match, etag string) bool {
+	for candidate := range strings.SplitSeq(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const defaultInstanceName = "Zeek Connection Log Visualizer" // Title shown when no instance name is configured
+
+// UIConfig is the server configuration the frontend adapts to. It is inlined into
+// index.html and served by /api/config.
+type UIConfig struct {
+	InstanceName  string     `json:"instance_name"`   //nolint:tagliatelle // API consistency
+	BasePath      string     `json:"base_path"`       //nolint:tagliatelle // API consistency
+	MaxUploadSize int64      `json:"max_upload_size"` //nolint:tagliatelle // API consistency
+	Features      UIFeatures `json:"features"`
+}
+
+// UIFeatures reports which optional backend capabilities are enabled.
+type UIFeatures struct {
+	GeoIP        bool `json:"geoip"`
+	SharedStore  bool `json:"shared_store"` //nolint:tagliatelle // API consistency
+	Search       bool `json:"search"`       // Datasets are indexed in Elasticsearch or OpenSearch
+	SharedCache  bool `json:"shared_cache"` //nolint:tagliatelle // API consistency
+	Backups      bool `json:"backups"`
+	RawDownloads bool `json:"raw_downloads"` //nolint:tagliatelle // API consistency
+	LiveTail     bool `json:"live_tail"`     //nolint:tagliatelle // API consistency
+	ReverseDNS   bool `json:"reverse_dns"`   //nolint:tagliatelle // API consistency
+	Auth         bool `json:"auth"`          // /api requests require credentials; see /api/me
+}
+
+// SetBranding sets the instance name shown in the UI and the path prefix under which a
+// reverse proxy serves the application (e.g. "/zeek").
+func (a *API) SetBranding(instanceName, basePath string) {
+	a.instanceName = instanceName
+	a.basePath = strings.TrimRight(basePath, "/")
+}
+
+// Config returns the configuration exposed to the frontend.
+func (a *API) Config() UIConfig {
+	name := a.instanceName
+	if name == "" {
+		name = defaultInstanceName
+	}
+
+	return UIConfig{
+		InstanceName:  name,
+		BasePath:      a.basePath,
+		MaxUploadSize: a.maxUploadBytes(),
+		Features: UIFeatures{
+			GeoIP:        a.geoip != nil,
+			SharedStore:  a.store != nil,
+			Search:       a.search != nil,
+			SharedCache:  a.cache != nil,
+			Backups:      a.backupDir != "",
+			RawDownloads: !a.discardRaw,
+			LiveTail:     a.following(),
+			ReverseDNS:   a.rdns != nil,
+			Auth:         len(a.authenticators) > 0,
+		},
+	}
+}
+
+// following reports whether a followed log file, watched directory, or Kafka topic feeds a
+// live dataset.
+func (a *API) following() bool {
+	a.tailMu.Lock()
+	defer a.tailMu.Unlock()
+
+	return len(a.tails) > 0 || len(a.watchers) > 0 || len(a.kafkaConsumers) > 0
+}
+
+// GetConfig returns the frontend configuration.
+func (a *API) GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(a.Config())
+	if err != nil {
+		log.Printf("Failed to encode config: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+// GetConnection returns the conn.log entry of a UID in the current dataset, with its
+// connection state and history flags spelled out for drill-down views.
+func (a *API) GetConnection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fileData := a.files[a.currentDataset(r)]
+	if fileData == nil {
+		http.Error(w, "No file selected", http.StatusNotFound)
+
+		return
+	}
+	connection := fileData.connection(r.PathValue("uid"))
+	if connection == nil {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+
+		return
+	}
+
+	response := map[string]any{
+		"connection":             connection,
+		"conn_state_description": connstats.ConnStateDescription(connection.ConnState),
+		"history":                models.DecodeHistory(connection.History),
+		"history_flags":          connection.HistoryFlags(),
+		"http_requests":          len(fileData.httpRequests[connection.UID]),
+		"ssl_sessions":           len(fileData.tlsSessions[connection.UID]),
+		"notices":                len(fileData.notices[connection.UID]),
+		"weirds":                 len(fileData.weirds[connection.UID]),
+	}
+	if threat := a.intel.matchConnection(connection); threat != nil {
+		response["threat"] = threat
+	}
+	if annotations := a.connectionAnnotations(connection); annotations != nil {
+		response["annotations"] = annotations
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode connection: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// connection returns the first connection with the UID, or nil.
+func (f *FileData) connection(uid string) *models.Connection {
+	for i := range f.Connections {
+		if f.Connections[i].UID == uid {
+			return &f.Connections[i]
+		}
+	}
+
+	return nil
+}
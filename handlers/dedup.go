@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+
+	"zeek-viz/models"
+)
+
+const (
+	dedupNone   = "none"   // Keep every record, even when UIDs repeat
+	dedupFirst  = "first"  // Keep the first record of each UID
+	dedupLatest = "latest" // Keep the record of each UID that ends last
+)
+
+var errInvalidDedup = errors.New("dedup must be none, first, or latest")
+
+// validDedup reports whether mode is a supported duplicate handling mode.
+func validDedup(mode string) bool {
+	return mode == dedupNone || mode == dedupFirst || mode == dedupLatest
+}
+
+// dedupConnections collapses records sharing a UID, as produced by merging overlapping
+// rotated logs. It returns the kept connections in their original order and the number of
+// duplicate records; with dedupNone nothing is removed but duplicates are still counted.
+// Records without a UID are never collapsed.
+func dedupConnections(connections []models.Connection, mode string) ([]models.Connection, int) {
+	kept := make(map[string]int, len(connections)) // UID to index of the kept record
+	drop := make([]bool, len(connections))
+	duplicates := 0
+
+	for i := range connections {
+		uid := connections[i].UID
+		if uid == "" {
+			continue
+		}
+
+		previous, seen := kept[uid]
+		if !seen {
+			kept[uid] = i
+
+			continue
+		}
+
+		duplicates++
+		switch {
+		case mode == dedupFirst:
+			drop[i] = true
+		case mode == dedupLatest && connectionEnd(&connections[i]) >= connectionEnd(&connections[previous]):
+			drop[previous] = true
+			kept[uid] = i
+		case mode == dedupLatest:
+			drop[i] = true
+		}
+	}
+
+	if duplicates == 0 || mode == dedupNone {
+		return connections, duplicates
+	}
+
+	deduped := make([]models.Connection, 0, len(connections)-duplicates)
+	for i := range connections {
+		if !drop[i] {
+			deduped = append(deduped, connections[i])
+		}
+	}
+
+	return deduped, duplicates
+}
+
+// connectionEnd returns the time a connection's record ends.
+func connectionEnd(conn *models.Connection) float64 {
+	return conn.Timestamp + conn.Duration
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	demoLogPath  = "demo/conn.log" // Path of the sample log in demoFS
+	demoFilename = "demo-conn.log" // Filename shown for the demo dataset
+	demoTag      = "demo"          // Tag marking the demo dataset
+)
+
+// demoFS holds an anonymized sample conn.log: internal hosts are renumbered into 10.0.0.0/8,
+// external hosts into documentation ranges, and timestamps shifted to 2024-01-01.
+//
+//go:embed demo/conn.log
+var demoFS embed.FS
+
+// LoadDemo loads the embedded sample dataset and makes it the current file. Loading it
+// again only switches to it.
+func (a *API) LoadDemo(ctx context.Context) (string, error) {
+	fileID, err := a.loadDemo(ctx)
+	if err != nil {
+		return "", err
+	}
+	a.currentFileID = fileID
+	a.publishCurrentFile()
+
+	return fileID, nil
+}
+
+// loadDemo loads the embedded sample dataset unless it is loaded already.
+func (a *API) loadDemo(ctx context.Context) (string, error) {
+	fileID := a.generateFileID("demo:"+demoFilename, 0)
+	if fileData := a.files[fileID]; fileData != nil {
+		fileData.warmCaches(a.localNetworks)
+
+		return fileID, nil
+	}
+
+	raw, err := demoFS.ReadFile(demoLogPath)
+	if err != nil {
+		return "", fmt.Errorf("reading demo dataset: %w", err)
+	}
+
+	connections, stats, report, err := parseConnections(ctx, bytes.NewReader(raw), false)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(raw)
+	fileData := &FileData{
+		Filename:    demoFilename,
+		UploadTime:  time.Now().Unix(),
+		Size:        int64(len(raw)),
+		Tags:        []string{demoTag},
+		SHA256:      hex.EncodeToString(digest[:]),
+		ParseReport: report,
+		ParseMode:   lenientMode,
+	}
+	if !a.discardRaw {
+		fileData.raw = raw
+	}
+	fileData.setConnections(connections, stats)
+	a.guessFileServices(fileData)
+
+	a.files[fileID] = fileData
+	a.checkWatchlist(fileID, fileData)
+	a.requestEviction()
+	a.persistFile(fileID, fileData)
+	a.indexFile(fileID, fileData)
+	fileData.warmCaches(a.localNetworks)
+
+	log.Printf("Loaded demo dataset as ID %s with %d connections", fileID, len(connections))
+
+	return fileID, nil
+}
+
+// LoadDemoData loads the embedded sample dataset, so the UI can be explored without own data,
+// and makes it the current dataset of the request's workspace.
+func (a *API) LoadDemoData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fileID, err := a.loadDemo(r.Context())
+	if err != nil {
+		log.Printf("Failed to load demo dataset: %v", err)
+		http.Error(w, "Failed to load demo dataset", http.StatusInternalServerError)
+
+		return
+	}
+	a.setCurrentDataset(r, fileID)
+
+	response := map[string]any{
+		"success":           true,
+		"message":           fmt.Sprintf("Loaded %d demo connections", len(a.files[fileID].Connections)),
+		"file_id":           fileID,
+		"filename":          demoFilename,
+		"connections_count": len(a.files[fileID].Connections),
+		"total_files":       len(a.files),
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"errors"
+
+	"zeek-viz/netgraph"
+)
+
+var errInvalidEdgeAggregation = errors.New("edge_by must be protocol, pair, service, or port")
+
+// parseEdgeAggregation reads the edge_by parameter, which selects the connections that share
+// an edge (see netgraph.NodesAndEdges). It defaults to protocol.
+func parseEdgeAggregation(value string) (string, error) {
+	switch value {
+	case "":
+		return netgraph.ByProtocol, nil
+	case netgraph.ByProtocol, netgraph.ByPair, netgraph.ByService, netgraph.ByPort:
+		return value, nil
+	default:
+		return "", errInvalidEdgeAggregation
+	}
+}
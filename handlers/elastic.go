@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/connstats"
+	"zeek-viz/elastic"
+	"zeek-viz/models"
+	"zeek-viz/store"
+)
+
+const (
+	searchIndexing = "indexing" // search_index of a dataset being written to the search backend
+	searchIndexed  = "indexed"  // search_index of a dataset the search backend answers queries on
+	searchFailed   = "failed"   // search_index of a dataset that couldn't be indexed; see the log
+
+	searchTimeout      = time.Hour // Upper bound for indexing or reading back one dataset
+	defaultSearchLimit = 10000     // Connections a search returns without a limit or max_results
+)
+
+// searchRequestKey marks the context of requests SearchLocked serves.
+type searchRequestKey struct{}
+
+// searchJob is a dataset to index, or with a nil fileData one whose index is to be deleted.
+type searchJob struct {
+	fileID   string
+	fileData *FileData
+	version  int64 // Content version of fileData to index
+}
+
+// searchQueue runs indexing and deletions one at a time in the order they were asked for, so
+// an index is never written by two jobs at once.
+type searchQueue struct {
+	mu      sync.Mutex
+	pending []searchJob
+	wake    chan struct{}
+}
+
+// SetSearchBackend indexes the connections of datasets in Elasticsearch or OpenSearch. Each
+// dataset is indexed in the background after it is uploaded, replaced, or loaded from the
+// store; live datasets, which keep growing, are not. Once indexed, a dataset beyond the
+// memory limits is unloaded rather than dropped even when it isn't stored, and that includes
+// the current dataset: /api/connections and /api/connections/count are then answered by the
+// search backend, and other endpoints read the dataset back from the store or the index.
+func (a *API) SetSearchBackend(client *elastic.Client) {
+	a.search = client
+	a.searchJobs = &searchQueue{wake: make(chan struct{}, 1)}
+	go a.runSearchJobs()
+}
+
+// SearchLocked runs handler like ReadLocked, except that an evicted current dataset the
+// search backend can answer the request's filters on isn't read back first.
+func (a *API) SearchLocked(handler http.HandlerFunc) http.HandlerFunc {
+	locked := a.ReadLocked(handler)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		locked(w, r.WithContext(context.WithValue(r.Context(), searchRequestKey{}, true)))
+	}
+}
+
+// searchAnswers reports whether the search backend answers a request SearchLocked serves on
+// the evicted dataset. Callers must hold a.mu, for reading.
+func (a *API) searchAnswers(r *http.Request, fileData *FileData) bool {
+	marked, _ := r.Context().Value(searchRequestKey{}).(bool)
+
+	return marked && a.searchServes(r.URL.Query(), fileData)
+}
+
+// searchServes reports whether the search backend answers the query on a dataset: it is
+// evicted, its index is up to date, and the query uses only filters the backend evaluates
+// and no other datasets. Callers must hold a.mu, for reading.
+func (a *API) searchServes(query url.Values, fileData *FileData) bool {
+	return a.search != nil && fileData != nil && fileData.unloaded != nil && fileData.searchIndexed() &&
+		query.Get("q") == "" && query.Get("country") == "" && query.Get("threat") == "" && query.Get("history_flag") == "" &&
+		query.Get("sample") == "" && !isMultiDataset(query)
+}
+
+// searchIndexed reports whether the search index holds the dataset's current connections.
+func (f *FileData) searchIndexed() bool {
+	return f.searchState == searchIndexed && f.searchVersion == f.version
+}
+
+// indexFile queues a dataset for indexing unless its index is up to date. Callers must hold
+// a.mu.
+func (a *API) indexFile(fileID string, fileData *FileData) {
+	if a.search == nil || fileData.unloaded != nil || a.isLiveDataset(fileID) || fileData.searchIndexed() {
+		return
+	}
+
+	fileData.searchState = searchIndexing
+	a.searchJobs.push(searchJob{fileID: fileID, fileData: fileData, version: fileData.version})
+}
+
+// deleteSearchIndex queues the deletion of a dataset's index. Callers must hold a.mu.
+func (a *API) deleteSearchIndex(fileID string) {
+	if a.search == nil {
+		return
+	}
+
+	a.searchJobs.push(searchJob{fileID: fileID})
+}
+
+// push adds a job without blocking, so callers may hold a.mu.
+func (q *searchQueue) push(job searchJob) {
+	q.mu.Lock()
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default: // The worker is already due to look at the queue
+	}
+}
+
+// pop takes the next job, or returns false when the queue is empty.
+func (q *searchQueue) pop() (searchJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return searchJob{}, false
+	}
+	job := q.pending[0]
+	q.pending = slices.Delete(q.pending, 0, 1)
+
+	return job, true
+}
+
+// runSearchJobs works through the queue.
+func (a *API) runSearchJobs() {
+	for range a.searchJobs.wake {
+		for job, ok := a.searchJobs.pop(); ok; job, ok = a.searchJobs.pop() {
+			ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+			if job.fileData == nil {
+				err := a.search.Delete(ctx, job.fileID)
+				if err != nil {
+					log.Printf("Failed to delete the search index of dataset %s: %v", job.fileID, err)
+				}
+			} else {
+				a.runIndexJob(ctx, job)
+			}
+			cancel()
+		}
+	}
+}
+
+// runIndexJob indexes the connections of a dataset, unless they were replaced since the job
+// was queued, and reuses the index of a stored dataset when it was built from the same store
+// version.
+func (a *API) runIndexJob(ctx context.Context, job searchJob) {
+	a.mu.RLock()
+	fileData := job.fileData
+	if a.files[job.fileID] != fileData || fileData.version != job.version || fileData.unloaded != nil {
+		a.mu.RUnlock()
+
+		return // Replaced, deleted, or evicted since; a newer job covers the replacement
+	}
+	connections := slices.Clone(fileData.Connections)
+	meta := elastic.IndexMeta{Connections: len(connections), StoredAt: fileData.storedAt}
+	a.mu.RUnlock()
+
+	start := time.Now()
+	existing, err := a.search.Meta(ctx, job.fileID)
+	reused := err == nil && meta.StoredAt != 0 && existing == meta
+	if !reused {
+		err = a.writeSearchIndex(ctx, job.fileID, connections, meta)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.files[job.fileID] != fileData || fileData.version != job.version {
+		return
+	}
+	if err != nil {
+		fileData.searchState = searchFailed
+		log.Printf("Failed to index dataset %s: %v", job.fileID, err)
+
+		return
+	}
+	fileData.searchState, fileData.searchVersion = searchIndexed, job.version
+	if !reused {
+		log.Printf("Indexed dataset %s (%d connections) in %s", job.fileID, len(connections), time.Since(start).Round(time.Millisecond))
+	}
+	a.requestEviction()
+}
+
+// writeSearchIndex replaces the index of a dataset with its connections.
+func (a *API) writeSearchIndex(ctx context.Context, fileID string, connections []models.Connection, meta elastic.IndexMeta) error {
+	err := a.search.Create(ctx, fileID, meta)
+	if err != nil {
+		return err //nolint:wrapcheck // Names the dataset
+	}
+
+	documents := make([]elastic.Document, len(connections))
+	for i := range connections {
+		documents[i] = searchDocument(&connections[i], i)
+	}
+	err = a.search.Index(ctx, fileID, documents)
+	if err != nil {
+		return err //nolint:wrapcheck // Names the dataset
+	}
+
+	return a.search.Refresh(ctx, fileID) //nolint:wrapcheck // Names the dataset
+}
+
+// searchDocument derives the indexed fields of a connection at position i of its dataset.
+func searchDocument(conn *models.Connection, i int) elastic.Document {
+	document := elastic.Document{
+		Connection: conn,
+		Position:   i,
+		IPVersion:  cmp.Or(models.IPVersion(conn.OrigHost), models.IPVersion(conn.RespHost)),
+		Services:   connfilter.SplitList(strings.ToLower(conn.Service)),
+		Guesses:    connfilter.SplitList(strings.ToLower(conn.ServiceGuess)),
+		Noise:      connfilter.IsNoiseAddress(conn.OrigHost) || connfilter.IsNoiseAddress(conn.RespHost),
+	}
+	if addr, err := models.ParseHost(conn.OrigHost); err == nil {
+		document.OrigIP = addr.String()
+	}
+	if addr, err := models.ParseHost(conn.RespHost); err == nil {
+		document.RespIP = addr.String()
+	}
+
+	return document
+}
+
+// readIndexedFile reads an evicted dataset that isn't stored back from its index. Failures
+// are logged and return nil.
+func (a *API) readIndexedFile(ctx context.Context, meta store.Metadata) (store.Metadata, *FileData) {
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+
+	connections, err := a.search.Connections(ctx, meta.ID)
+	if err != nil {
+		log.Printf("Failed to read dataset %s back from the search backend: %v", meta.ID, err)
+
+		return meta, nil
+	}
+
+	fileData := storedFileData(meta)
+	fileData.setConnections(connections, connstats.Compute(connections))
+
+	return meta, fileData
+}
+
+// searchQuery translates the connection filters of a query into a query of the search
+// backend, rejecting invalid values as filterConnections does. The q, country, and threat
+// filters are not translated; searchServes leaves queries using them to memory.
+func (a *API) searchQuery(query url.Values) (elastic.Query, error) {
+	if !connfilter.ValidScope(query.Get("scope")) {
+		return nil, connfilter.ErrInvalidScope
+	}
+	version, err := connfilter.ParseIPVersion(query.Get("ip_version"))
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Reported as the 400 response
+	}
+	endpoints, err := connfilter.ParseEndpoints(query)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Reported as the 400 response
+	}
+
+	var filters []elastic.Query
+	start, errStart := strconv.ParseInt(query.Get("start"), 10, 64)
+	end, errEnd := strconv.ParseInt(query.Get("end"), 10, 64)
+	if errStart == nil && errEnd == nil {
+		filters = append(filters, elastic.Range("ts", start, end+1)) // Whole seconds up to end, as in memory
+	}
+	if protocol := query.Get("protocol"); protocol != "" && protocol != allProtocol {
+		filters = append(filters, elastic.Term("proto", protocol))
+	}
+	if connState := query.Get("conn_state"); connState != "" && connState != allProtocol {
+		filters = append(filters, elastic.Term("conn_state", connState))
+	}
+	if connfilter.ExcludesNoise(query) {
+		filters = append(filters, elastic.Not(elastic.Term(elastic.FieldNoise, true)))
+	}
+	filters = append(filters, a.scopeQuery(query.Get("scope")))
+	if version != 0 {
+		filters = append(filters, elastic.Term(elastic.FieldIPVersion, version))
+	}
+	filters = append(filters, endpointQueries(endpoints)...)
+
+	return elastic.All(filters...), nil
+}
+
+// scopeQuery matches the connections within a network scope, or returns nil without one.
+func (a *API) scopeQuery(scope string) elastic.Query {
+	local := func(field string) elastic.Query {
+		return prefixQuery(field, a.localNetworks)
+	}
+
+	switch scope {
+	case connfilter.ScopeInternal:
+		return elastic.All(local(elastic.FieldOrigIP), local(elastic.FieldRespIP))
+	case connfilter.ScopeExternal:
+		return elastic.Any(elastic.Not(local(elastic.FieldOrigIP)), elastic.Not(local(elastic.FieldRespIP)))
+	case connfilter.ScopeCrossing:
+		return elastic.Any(
+			elastic.All(local(elastic.FieldOrigIP), elastic.Not(local(elastic.FieldRespIP))),
+			elastic.All(elastic.Not(local(elastic.FieldOrigIP)), local(elastic.FieldRespIP)),
+		)
+	default:
+		return nil
+	}
+}
+
+// endpointQueries translates the set parts of an endpoint filter.
+func endpointQueries(f *connfilter.Endpoints) []elastic.Query {
+	var filters []elastic.Query
+	if len(f.OrigPorts) > 0 {
+		filters = append(filters, portQuery("id.orig_p", f.OrigPorts))
+	}
+	if len(f.RespPorts) > 0 {
+		filters = append(filters, portQuery("id.resp_p", f.RespPorts))
+	}
+	if len(f.Services) > 0 {
+		services := elastic.Terms(elastic.FieldServices, f.Services)
+		if f.Guesses {
+			services = elastic.Any(services, elastic.All(
+				elastic.Not(elastic.Exists(elastic.FieldServices)),
+				elastic.Terms(elastic.FieldGuesses, f.Services),
+			))
+		}
+		filters = append(filters, services)
+	}
+	if len(f.OrigHosts) > 0 {
+		filters = append(filters, prefixQuery(elastic.FieldOrigIP, f.OrigHosts))
+	}
+	if len(f.RespHosts) > 0 {
+		filters = append(filters, prefixQuery(elastic.FieldRespIP, f.RespHosts))
+	}
+	if len(f.Subnets) > 0 {
+		filters = append(filters, elastic.Any(prefixQuery(elastic.FieldOrigIP, f.Subnets), prefixQuery(elastic.FieldRespIP, f.Subnets)))
+	}
+
+	return filters
+}
+
+// portQuery matches ports in one of the ranges.
+func portQuery(field string, ranges []connfilter.PortRange) elastic.Query {
+	queries := make([]elastic.Query, len(ranges))
+	for i, r := range ranges {
+		queries[i] = elastic.Range(field, r.Low, r.High+1)
+	}
+
+	return elastic.Any(queries...)
+}
+
+// prefixQuery matches addresses in one of the prefixes, and nothing without prefixes.
+func prefixQuery(field string, prefixes []netip.Prefix) elastic.Query {
+	queries := make([]elastic.Query, len(prefixes))
+	for i, prefix := range prefixes {
+		queries[i] = elastic.Term(field, prefix.String())
+	}
+
+	return elastic.Any(queries...)
+}
+
+// searchConnections answers /api/connections from the search backend with a page of the
+// matching connections, bounded by limit, max_results, or defaultSearchLimit. Callers must
+// hold a.mu, for reading.
+func (a *API) searchConnections(w http.ResponseWriter, r *http.Request, fileID string) {
+	query := r.URL.Query()
+	filter, err := a.searchQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	offset, limit := parseLimit(query, "offset"), parseLimit(query, "limit")
+	page := models.ConnectionsResponse{Offset: offset, Limits: map[string]int{}}
+	switch {
+	case limit > 0 && (a.maxResults <= 0 || limit <= a.maxResults):
+		page.Limits["limit"] = limit
+	case a.maxResults > 0:
+		page.Limits["max_results"] = a.maxResults
+		limit = a.maxResults
+	default:
+		page.Limits["max_results"] = defaultSearchLimit
+		limit = defaultSearchLimit
+	}
+
+	total, connections, err := a.search.Search(r.Context(), fileID, filter, offset, limit)
+	if err != nil {
+		log.Printf("Failed to search dataset %s: %v", fileID, err)
+		http.Error(w, "Search backend unavailable", http.StatusBadGateway)
+
+		return
+	}
+	page.Total = total
+	if offset+len(connections) < total {
+		page.Truncated, page.NextOffset = true, offset+len(connections)
+	}
+
+	if format := query.Get("format"); format == zjsonFormat || format == zngFormat {
+		writeZed(w, format, connections, 0, query.Get("download") == "true")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	page.Connections = connections
+	if fields := connfilter.SplitList(query.Get("fields")); len(fields) > 0 {
+		err := projectConnections(&page, fields, a.annotator())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	} else {
+		page.Connections = a.annotateConnections(connections)
+	}
+
+	err = json.NewEncoder(w).Encode(page)
+	if err != nil {
+		log.Printf("Failed to encode connections: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// searchCount answers /api/connections/count from the search backend. Callers must hold
+// a.mu, for reading.
+func (a *API) searchCount(w http.ResponseWriter, r *http.Request, fileID string, total int) {
+	filter, err := a.searchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	count, err := a.search.Count(r.Context(), fileID, filter)
+	if err != nil {
+		log.Printf("Failed to count connections of dataset %s: %v", fileID, err)
+		http.Error(w, "Search backend unavailable", http.StatusBadGateway)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(map[string]any{"count": count, "total": total})
+	if err != nil {
+		log.Printf("Failed to encode count: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
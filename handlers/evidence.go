@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+)
+
+const (
+	evidencePrefix       = "zeek-viz-evidence" // Filename prefix of evidence packages
+	evidenceManifest     = "manifest.json"     // Manifest describing the package contents
+	evidenceChecksums    = "SHA256SUMS"        // Checksums in sha256sum format
+	evidenceImageNodes   = 200                 // Nodes drawn in the subgraph image
+	evidenceImageSize    = 800.0               // Width and height of the subgraph image
+	evidenceImageMargin  = 80.0                // Space around the node circle for labels
+	evidenceNodeMinSize  = 4.0                 // Radius of nodes without traffic
+	evidenceLabelOffset  = 12.0                // Distance of labels above their node
+	evidenceLocalColor   = "#2e7d32"           // Fill of local nodes
+	evidenceRemoteColor  = "#c62828"           // Fill of external nodes
+	evidenceEdgeColor    = "#9e9e9e"           // Stroke of edges
+	evidenceManifestType = "zeek-viz-evidence" // Manifest type marker
+)
+
+var errEvidenceSelection = errors.New("select connections with uid, tag, or connection filters")
+
+// evidenceFile is a file in an evidence package with its digest.
+type evidenceFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// evidenceContent is a file to be written into an evidence package.
+type evidenceContent struct {
+	name string
+	data []byte
+}
+
+// evidenceSource is a dataset connections of an evidence package were taken from.
+type evidenceSource struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+// evidenceManifestData describes an evidence package.
+type evidenceManifestData struct {
+	Type        string           `json:"type"`
+	CreatedAt   int64            `json:"created_at"` //nolint:tagliatelle // API consistency
+	Selection   map[string]any   `json:"selection"`
+	Sources     []evidenceSource `json:"sources"`
+	Connections int              `json:"connections"`
+	Hosts       int              `json:"hosts"`
+	Files       []evidenceFile   `json:"files"`
+}
+
+// ExportEvidence bundles the selected connections, their subgraph as JSON and SVG, analyst
+// notes, and a manifest with SHA-256 digests into a zip archive for incident handoff.
+// Connections are selected from the datasets tagged tag (or the current one), narrowed by
+// the standard filters and a uid list.
+func (a *API) ExportEvidence(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	uids := connfilter.SplitList(query.Get("uid"))
+	tag := query.Get("tag")
+	if len(uids) == 0 && tag == "" && isUnfiltered(query) {
+		http.Error(w, errEvidenceSelection.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	sources, connections := a.evidenceConnections(tag, a.currentDataset(r))
+	connections, err := a.filterConnections(connections, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if len(uids) > 0 {
+		connections = slices.DeleteFunc(slices.Clone(connections), func(conn models.Connection) bool {
+			return !slices.Contains(uids, conn.UID)
+		})
+	}
+	if len(connections) == 0 {
+		http.Error(w, "No connections match the selection", http.StatusNotFound)
+
+		return
+	}
+
+	var archive bytes.Buffer
+	err = writeEvidence(&archive, evidenceManifestData{
+		Type:      evidenceManifestType,
+		CreatedAt: time.Now().Unix(),
+		Selection: evidenceSelection(query, uids, tag),
+		Sources:   sources,
+	}, connections, query.Get("note"), a.connectionsAnnotations(connections), a.localNetworks)
+	if err != nil {
+		log.Printf("Failed to write evidence package: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.zip", evidencePrefix, time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", snapshotMIMEType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	_, err = w.Write(archive.Bytes())
+	if err != nil {
+		log.Printf("Error writing evidence package: %v", err)
+
+		return
+	}
+
+	log.Printf("Exported evidence package with %d connections from %d datasets", len(connections), len(sources))
+}
+
+// evidenceConnections returns the datasets tagged tag, or current when tag is empty, and
+// their connections.
+func (a *API) evidenceConnections(tag, current string) ([]evidenceSource, []models.Connection) {
+	fileIDs := make([]string, 0, len(a.files))
+	for fileID, fileData := range a.files {
+		if (tag == "" && fileID == current) || (tag != "" && slices.Contains(fileData.Tags, tag)) {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	sort.Strings(fileIDs)
+
+	sources := make([]evidenceSource, 0, len(fileIDs))
+	var connections []models.Connection
+	for _, fileID := range fileIDs {
+		fileData := a.files[fileID]
+		sources = append(sources, evidenceSource{ID: fileID, Filename: fileData.Filename, SHA256: fileData.SHA256})
+		connections = append(connections, fileData.Connections...)
+	}
+
+	return sources, connections
+}
+
+// evidenceSelection records the parameters that selected the connections.
+func evidenceSelection(query url.Values, uids []string, tag string) map[string]any {
+	selection := make(map[string]any)
+	if len(uids) > 0 {
+		selection["uid"] = uids
+	}
+	if tag != "" {
+		selection["tag"] = tag
+	}
+	for _, param := range filterParams() {
+		if value := query.Get(param); value != "" {
+			selection[param] = value
+		}
+	}
+
+	return selection
+}
+
+// writeEvidence writes the evidence archive, with the annotations of the connections and their
+// hosts when there are any. Every file is listed in the manifest and in a SHA256SUMS file, so
+// recipients can verify the package with sha256sum -c.
+func writeEvidence(w io.Writer, manifest evidenceManifestData, connections []models.Connection, note string, annotations []Annotation, local models.LocalNetworks) error {
+	graph := evidenceGraph(connections, local)
+	manifest.Connections = len(connections)
+	manifest.Hosts = graph.TotalNodes
+
+	var contents []evidenceContent
+	add := func(name string, data []byte) {
+		contents = append(contents, evidenceContent{name, data})
+	}
+
+	var encoded bytes.Buffer
+	err := encodeConnections(&encoded, connections)
+	if err != nil {
+		return err
+	}
+	add("conn.log", encoded.Bytes())
+
+	graphJSON, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding subgraph: %w", err)
+	}
+	add("graph.json", graphJSON)
+
+	var image bytes.Buffer
+	renderGraphSVG(&image, graph)
+	add("graph.svg", image.Bytes())
+
+	if note != "" {
+		add("notes.txt", []byte(note+"\n"))
+	}
+	if len(annotations) > 0 {
+		annotationsJSON, err := json.MarshalIndent(annotations, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding annotations: %w", err)
+		}
+		add("annotations.json", annotationsJSON)
+	}
+
+	var checksums bytes.Buffer
+	for _, content := range contents {
+		digest := sha256.Sum256(content.data)
+		sum := hex.EncodeToString(digest[:])
+		manifest.Files = append(manifest.Files, evidenceFile{Name: content.name, Size: len(content.data), SHA256: sum})
+		fmt.Fprintf(&checksums, "%s  %s\n", sum, content.name)
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	add(evidenceManifest, manifestJSON)
+	digest := sha256.Sum256(manifestJSON)
+	fmt.Fprintf(&checksums, "%s  %s\n", hex.EncodeToString(digest[:]), evidenceManifest)
+	add(evidenceChecksums, checksums.Bytes())
+
+	archive := zip.NewWriter(w)
+	for _, content := range contents {
+		entry, err := createZipEntry(archive, content.name)
+		if err != nil {
+			return err
+		}
+		_, err = entry.Write(content.data)
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", content.name, err)
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return fmt.Errorf("closing evidence package: %w", err)
+	}
+
+	return nil
+}
+
+// evidenceGraph builds the subgraph of the connections, with nodes ordered by bytes.
+func evidenceGraph(connections []models.Connection, local models.LocalNetworks) models.NetworkGraph {
+	nodes, edges := netgraph.NodesAndEdges(connections, local, netgraph.ByProtocol)
+	graph := models.NetworkGraph{Nodes: nodes, Edges: edges, TotalNodes: len(nodes), TotalEdges: len(edges)}
+	_ = limitNodes(&graph, nodeSortBytes, 0) // A valid sort never fails
+
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Source != graph.Edges[j].Source {
+			return graph.Edges[i].Source < graph.Edges[j].Source
+		}
+		if graph.Edges[i].Target != graph.Edges[j].Target {
+			return graph.Edges[i].Target < graph.Edges[j].Target
+		}
+
+		return graph.Edges[i].Protocol < graph.Edges[j].Protocol
+	})
+
+	return graph
+}
+
+// renderGraphSVG draws the busiest nodes of the graph on a circle, sized by bytes and colored
+// by locality, with the edges between them.
+func renderGraphSVG(w io.Writer, graph models.NetworkGraph) {
+	drawn := graph
+	_ = limitNodes(&drawn, nodeSortBytes, evidenceImageNodes) // A valid sort never fails
+
+	center := evidenceImageSize / 2
+	radius := center - evidenceImageMargin
+	positions := make(map[string][2]float64, len(drawn.Nodes))
+	for i, node := range drawn.Nodes {
+		angle := 2 * math.Pi * float64(i) / float64(max(1, len(drawn.Nodes)))
+		positions[node.ID] = [2]float64{center + radius*math.Cos(angle), center + radius*math.Sin(angle)}
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]g" height="%[1]g" viewBox="0 0 %[1]g %[1]g">`+"\n", evidenceImageSize)
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+
+	for _, edge := range drawn.Edges {
+		from, to := positions[edge.Source], positions[edge.Target]
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%.1f"/>`+"\n",
+			from[0], from[1], to[0], to[1], evidenceEdgeColor, 1+math.Log10(float64(edge.Count)))
+	}
+
+	for _, node := range drawn.Nodes {
+		position := positions[node.ID]
+		color := evidenceRemoteColor
+		if node.IsLocal {
+			color = evidenceLocalColor
+		}
+		label := html.EscapeString(node.Label)
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"><title>%s</title></circle>`+"\n",
+			position[0], position[1], evidenceNodeMinSize+math.Log10(float64(node.TotalBytes)+1), color, label)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-family="sans-serif" font-size="10" text-anchor="middle">%s</text>`+"\n",
+			position[0], position[1]-evidenceLabelOffset, label)
+	}
+
+	fmt.Fprintln(w, "</svg>")
+}
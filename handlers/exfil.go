@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const (
+	exfilRule            = "exfil"   // Finding rule of asymmetric uploads
+	defaultExfilMinBytes = 1_000_000 // Bytes a host must send to external hosts to be ranked
+	defaultExfilMinRatio = 1.0       // Outbound to inbound ratio a host must reach by default
+	defaultExfilWindow   = 3600      // Seconds of the window the peak upload is measured over
+	defaultExfilLimit    = 50        // Hosts returned by default
+	maxExfilDestinations = 5         // Destinations listed per host
+	exfilRatioPrecision  = 100       // Ratios are rounded to two decimals
+)
+
+var errInvalidMinRatio = errors.New("min_ratio must be a non-negative number")
+
+// ExfilHost is an internal host ranked by how much more it sent to external hosts than it
+// received from them, as uploads leaving the network do.
+type ExfilHost struct {
+	Host            string             `json:"host"`
+	OutboundBytes   int                `json:"outbound_bytes"` //nolint:tagliatelle // API consistency
+	InboundBytes    int                `json:"inbound_bytes"`  //nolint:tagliatelle // API consistency
+	Ratio           float64            `json:"ratio"`          // Outbound bytes per inbound byte, counting at least one
+	Connections     int                `json:"connections"`
+	Destinations    int                `json:"destinations"`                // Distinct external hosts
+	TopDestinations []ExfilDestination `json:"top_destinations"`            //nolint:tagliatelle // API consistency
+	PeakWindowBytes int                `json:"peak_window_bytes"`           //nolint:tagliatelle // Most bytes sent within one window
+	PeakWindowStart float64            `json:"peak_window_start"`           //nolint:tagliatelle // API consistency
+	FirstSeen       float64            `json:"first_seen"`                  //nolint:tagliatelle // API consistency
+	LastSeen        float64            `json:"last_seen"`                   //nolint:tagliatelle // API consistency
+	OutboundHuman   string             `json:"outbound_human,omitempty"`    //nolint:tagliatelle // API consistency
+	InboundHuman    string             `json:"inbound_human,omitempty"`     //nolint:tagliatelle // API consistency
+	PeakWindowHuman string             `json:"peak_window_human,omitempty"` //nolint:tagliatelle // API consistency
+	Threat          *models.Threat     `json:"threat,omitempty"`            // Indicator matched by a destination
+
+	largestDest       string        // Destination that received the most bytes, for suppressions
+	outboundPerWindow map[int64]int // Bytes sent per window start, until finish
+}
+
+// ExfilDestination is an external host an internal host sent bytes to.
+type ExfilDestination struct {
+	Host          string `json:"host"`
+	OutboundBytes int    `json:"outbound_bytes"` //nolint:tagliatelle // API consistency
+	InboundBytes  int    `json:"inbound_bytes"`  //nolint:tagliatelle // API consistency
+	Connections   int    `json:"connections"`
+}
+
+// GetExfil ranks internal hosts by the ratio of bytes they sent to external hosts to bytes
+// they received from them. Accepts the standard filters, start and end narrowing the time
+// span considered, min_bytes, min_ratio, window, limit, and humanize.
+func (a *API) GetExfil(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	minBytes := parseLimit(query, "min_bytes")
+	if query.Get("min_bytes") == "" {
+		minBytes = defaultExfilMinBytes
+	}
+	minRatio, err := parseFloatParam(query.Get("min_ratio"), defaultExfilMinRatio)
+	if err != nil || minRatio < 0 {
+		http.Error(w, errInvalidMinRatio.Error(), http.StatusBadRequest)
+
+		return
+	}
+	window := int64(parseLimit(query, "window"))
+	if window == 0 {
+		window = defaultExfilWindow
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultExfilLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	humanize := wantsHumanize(query)
+	candidates := exfilHosts(connections, a.localNetworks, window)
+	hosts := make([]ExfilHost, 0)
+	suppressed := 0
+	for _, host := range candidates {
+		if host.OutboundBytes < minBytes || host.Ratio < minRatio {
+			continue
+		}
+		probe := models.Connection{OrigHost: host.Host, RespHost: host.largestDest}
+		if connectionSuppressed(a.suppressions, exfilRule, &probe) {
+			suppressed++
+
+			continue
+		}
+		for _, destination := range host.TopDestinations {
+			if host.Threat = a.intel.match(destination.Host); host.Threat != nil {
+				break
+			}
+		}
+		if humanize {
+			host.OutboundHuman = connstats.HumanizeBytes(float64(host.OutboundBytes))
+			host.InboundHuman = connstats.HumanizeBytes(float64(host.InboundBytes))
+			host.PeakWindowHuman = connstats.HumanizeBytes(float64(host.PeakWindowBytes))
+		}
+		hosts = append(hosts, *host)
+	}
+
+	response := map[string]any{
+		"hosts":               hosts[:min(limit, len(hosts))],
+		"candidates":          len(candidates),
+		"total":               len(hosts),
+		"truncated":           len(hosts) > limit,
+		"suppressed_findings": suppressed,
+		"limits": map[string]any{
+			"limit":     limit,
+			"min_bytes": minBytes,
+			"min_ratio": minRatio,
+			"window":    window,
+		},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode exfil hosts: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// exfilHosts totals the bytes each internal host exchanged with external hosts, whichever
+// side opened the connection, sorted by ratio and then outbound bytes.
+func exfilHosts(connections []models.Connection, local models.LocalNetworks, window int64) []*ExfilHost {
+	hosts := make(map[string]*ExfilHost)
+	destinations := make(map[string]map[string]*ExfilDestination)
+	locality := make(map[string]bool)
+	isLocal := func(host string) bool {
+		value, known := locality[host]
+		if !known {
+			value = local.Contains(host)
+			locality[host] = value
+		}
+
+		return value
+	}
+
+	for i := range connections {
+		conn := &connections[i]
+		internal, external := conn.OrigHost, conn.RespHost
+		sent, received := conn.OrigBytes, conn.RespBytes
+		switch origLocal, respLocal := isLocal(conn.OrigHost), isLocal(conn.RespHost); {
+		case origLocal && !respLocal:
+		case respLocal && !origLocal:
+			internal, external = conn.RespHost, conn.OrigHost
+			sent, received = conn.RespBytes, conn.OrigBytes
+		default:
+			continue
+		}
+
+		host, exists := hosts[internal]
+		if !exists {
+			host = &ExfilHost{
+				Host:              internal,
+				FirstSeen:         conn.Timestamp,
+				LastSeen:          conn.Timestamp,
+				outboundPerWindow: make(map[int64]int),
+			}
+			hosts[internal] = host
+			destinations[internal] = make(map[string]*ExfilDestination)
+		}
+		host.OutboundBytes += sent
+		host.InboundBytes += received
+		host.Connections++
+		host.FirstSeen = min(host.FirstSeen, conn.Timestamp)
+		host.LastSeen = max(host.LastSeen, conn.Timestamp)
+		host.outboundPerWindow[int64(math.Floor(conn.Timestamp))/window*window] += sent
+
+		destination, exists := destinations[internal][external]
+		if !exists {
+			destination = &ExfilDestination{Host: external}
+			destinations[internal][external] = destination
+		}
+		destination.OutboundBytes += sent
+		destination.InboundBytes += received
+		destination.Connections++
+	}
+
+	ranked := make([]*ExfilHost, 0, len(hosts))
+	for _, host := range hosts {
+		host.finish(destinations[host.Host])
+		ranked = append(ranked, host)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Ratio != ranked[j].Ratio {
+			return ranked[i].Ratio > ranked[j].Ratio
+		}
+		if ranked[i].OutboundBytes != ranked[j].OutboundBytes {
+			return ranked[i].OutboundBytes > ranked[j].OutboundBytes
+		}
+
+		return ranked[i].Host < ranked[j].Host
+	})
+
+	return ranked
+}
+
+// finish computes the host's ratio, peak window, and top destinations from its totals.
+func (h *ExfilHost) finish(destinations map[string]*ExfilDestination) {
+	h.Ratio = roundTo(float64(h.OutboundBytes)/float64(max(h.InboundBytes, 1)), exfilRatioPrecision)
+
+	for start, bytes := range h.outboundPerWindow {
+		if bytes > h.PeakWindowBytes || (bytes == h.PeakWindowBytes && float64(start) < h.PeakWindowStart) {
+			h.PeakWindowBytes, h.PeakWindowStart = bytes, float64(start)
+		}
+	}
+	h.outboundPerWindow = nil
+
+	ranked := make([]ExfilDestination, 0, len(destinations))
+	for _, destination := range destinations {
+		ranked = append(ranked, *destination)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].OutboundBytes != ranked[j].OutboundBytes {
+			return ranked[i].OutboundBytes > ranked[j].OutboundBytes
+		}
+
+		return ranked[i].Host < ranked[j].Host
+	})
+	h.Destinations = len(ranked)
+	h.TopDestinations = ranked[:min(maxExfilDestinations, len(ranked))]
+	if len(ranked) > 0 {
+		h.largestDest = ranked[0].Host
+	}
+}
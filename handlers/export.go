@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+)
+
+const (
+	csvExportFormat    = "csv"    // Comma-separated values with a header row
+	ndjsonExportFormat = "ndjson" // One JSON connection per line
+	exportBaseName     = "connections"
+	graphExportName    = "graph"
+)
+
+var (
+	errExportFormat      = errors.New("format must be csv or ndjson")
+	errGraphExportFormat = errors.New("format must be graphml, gexf, or dot")
+)
+
+// graphExportFormat is a file format the network graph can be exported in.
+type graphExportFormat struct {
+	contentType string
+	write       func(w io.Writer, graph models.NetworkGraph) error
+}
+
+// graphExportFormats returns the graph formats /api/export/graph writes, by format name,
+// which is also the file extension.
+func graphExportFormats() map[string]graphExportFormat {
+	return map[string]graphExportFormat{
+		"graphml": {"application/graphml+xml", models.WriteGraphML},
+		"gexf":    {"application/gexf+xml", models.WriteGEXF},
+		"dot":     {"text/vnd.graphviz", models.WriteDOT},
+	}
+}
+
+// ExportConnections streams the filtered connections of the current dataset as a CSV or
+// NDJSON download. Accepts the standard filters, format, fields, and limit.
+func (a *API) ExportConnections(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = csvExportFormat
+	}
+	if format != csvExportFormat && format != ndjsonExportFormat {
+		http.Error(w, errExportFormat.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	fields := connfilter.SplitList(query.Get("fields"))
+	for _, field := range fields {
+		if _, exists := models.ValueFieldAccessor(field); !exists {
+			http.Error(w, fmt.Errorf("%w: %s", errUnknownField, field).Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if limit := parseLimit(query, "limit"); limit > 0 && len(connections) > limit {
+		connections = connections[:limit]
+	}
+
+	filename := a.exportFilename(a.currentDataset(r), isUnfiltered(query)) + "." + format
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == csvExportFormat {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = models.WriteCSV(w, connections, fields, a.annotator())
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = models.WriteNDJSON(w, connections, fields, a.annotator())
+	}
+	if err != nil {
+		log.Printf("Failed to export connections: %v", err) // Headers are sent; the download is cut short
+
+		return
+	}
+
+	log.Printf("Exported %d connections as %s", len(connections), format)
+}
+
+// ExportGraph serializes the network graph of /api/nodes as GraphML, GEXF, or Graphviz DOT
+// for layout and reporting in Gephi, yEd, or Graphviz. Accepts format and every /api/nodes
+// parameter.
+func (a *API) ExportGraph(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("format")
+	if name == "" {
+		name = "graphml"
+	}
+	format, exists := graphExportFormats()[name]
+	if !exists {
+		http.Error(w, errGraphExportFormat.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	graph, err := a.networkGraph(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	filename := a.exportFilename(a.currentDataset(r), isUnfiltered(query)) + "-" + graphExportName + "." + name
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err = format.write(w, graph)
+	if err != nil {
+		log.Printf("Failed to export graph: %v", err)
+
+		return
+	}
+
+	log.Printf("Exported graph with %d nodes and %d edges as %s", len(graph.Nodes), len(graph.Edges), name)
+}
+
+// exportFilename returns the download name of an export of a dataset, without an extension,
+// marking filtered exports so they aren't mistaken for the whole log.
+func (a *API) exportFilename(fileID string, unfiltered bool) string {
+	name := exportBaseName
+	if currentFile := a.files[fileID]; currentFile != nil && currentFile.Filename != "" {
+		base := path.Base(currentFile.Filename)
+		name = strings.TrimSuffix(base, path.Ext(base))
+	}
+	if !unfiltered {
+		name += "-filtered"
+	}
+
+	return name
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxFileNameLength  = 200  // Characters of a dataset's name or case number
+	maxFileDescription = 4000 // Characters of a dataset's description
+)
+
+var (
+	errInvalidFileSort  = errors.New("sort must be one of upload_time, size, connections, name")
+	errFileLabelTooLong = errors.New("name and case_number are limited to 200 characters")
+	errFileDescTooLong  = errors.New("description is limited to 4000 characters")
+)
+
+// FileInfo describes an uploaded file in file listings.
+type FileInfo struct {
+	ID              string         `json:"id"`
+	Filename        string         `json:"filename"`
+	UploadTime      int64          `json:"upload_time"` //nolint:tagliatelle // API compatibility
+	Size            int64          `json:"size"`
+	ConnectionCount int            `json:"connection_count"` //nolint:tagliatelle // API compatibility
+	IsCurrent       bool           `json:"is_current"`       //nolint:tagliatelle // API compatibility
+	Tags            []string       `json:"tags,omitempty"`
+	SHA256          string         `json:"sha256,omitempty"`
+	Dataset         string         `json:"dataset,omitempty"`
+	Name            string         `json:"name,omitempty"`
+	Description     string         `json:"description,omitempty"`
+	CaseNumber      string         `json:"case_number,omitempty"`         //nolint:tagliatelle // API consistency
+	ParseMode       string         `json:"parse_mode,omitempty"`          //nolint:tagliatelle // API compatibility
+	StitchGap       float64        `json:"stitch_gap,omitempty"`          //nolint:tagliatelle // API consistency
+	StitchedRecords int            `json:"stitched_records,omitempty"`    //nolint:tagliatelle // Records flow stitching merged away
+	HasRaw          bool           `json:"has_raw"`                       //nolint:tagliatelle // API compatibility
+	CacheStatus     string         `json:"cache_status"`                  //nolint:tagliatelle // API compatibility
+	WatchlistHits   []WatchlistHit `json:"watchlist_hits,omitempty"`      //nolint:tagliatelle // API consistency
+	Suppressed      int            `json:"suppressed_findings,omitempty"` //nolint:tagliatelle // API consistency
+	HTTPRequests    int            `json:"http_requests,omitempty"`       //nolint:tagliatelle // API consistency
+	TLSSessions     int            `json:"tls_sessions,omitempty"`        //nolint:tagliatelle // API consistency
+	Notices         int            `json:"notices,omitempty"`             // Attached notice.log records
+	Weirds          int            `json:"weirds,omitempty"`              // Attached weird.log records
+	MemoryBytes     int64          `json:"memory_bytes"`                  //nolint:tagliatelle // API consistency
+	Loaded          bool           `json:"loaded"`                        // False while evicted to the store or search backend
+	SearchIndex     string         `json:"search_index,omitempty"`        //nolint:tagliatelle // indexing, indexed, or failed with a search backend
+	UnloadedAt      int64          `json:"unloaded_at,omitempty"`         //nolint:tagliatelle // API consistency
+	LastAccess      int64          `json:"last_access"`                   //nolint:tagliatelle // API consistency
+	SkippedLines    int            `json:"skipped_lines,omitempty"`       //nolint:tagliatelle // API consistency
+	ExpiresAt       int64          `json:"expires_at,omitempty"`          //nolint:tagliatelle // When the retention policy removes it (Unix seconds)
+}
+
+// fileInfo describes a file for file listings, marking it current when it is the dataset
+// current is. Callers must hold a.mu.
+func (a *API) fileInfo(fileID string, fileData *FileData, current string) FileInfo {
+	info := FileInfo{
+		ID:              fileID,
+		Filename:        fileData.Filename,
+		UploadTime:      fileData.UploadTime,
+		Size:            fileData.Size,
+		ConnectionCount: fileData.connectionCount(),
+		IsCurrent:       fileID == current,
+		Tags:            fileData.Tags,
+		SHA256:          fileData.SHA256,
+		Dataset:         fileData.Dataset,
+		Name:            fileData.Name,
+		Description:     fileData.Description,
+		CaseNumber:      fileData.CaseNumber,
+		ParseMode:       fileData.ParseMode,
+		StitchGap:       fileData.StitchGap,
+		StitchedRecords: fileData.stitchedRecords(),
+		CacheStatus:     fileData.cacheStatus(),
+		HasRaw:          fileData.hasRaw(),
+		WatchlistHits:   fileData.watchlistHits,
+		Suppressed:      fileData.suppressedHits,
+		HTTPRequests:    countRecords(fileData.httpRequests),
+		TLSSessions:     countRecords(fileData.tlsSessions),
+		Notices:         countRecords(fileData.notices),
+		Weirds:          countRecords(fileData.weirds),
+		MemoryBytes:     fileData.memoryBytes(),
+		Loaded:          fileData.unloaded == nil,
+		SearchIndex:     fileData.searchState,
+		LastAccess:      fileData.accessedAt() / int64(time.Second),
+		SkippedLines:    fileData.ParseReport.Skipped(),
+	}
+	if fileData.unloaded != nil {
+		info.UnloadedAt = fileData.unloaded.at
+	}
+
+	return info
+}
+
+// GetFile describes the dataset of the path as /api/files lists it.
+func (a *API) GetFile(w http.ResponseWriter, r *http.Request) {
+	fileID := r.PathValue("id")
+	fileData := a.files[fileID]
+	if fileData == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+
+	files := []FileInfo{a.fileInfo(fileID, fileData, a.currentDataset(r))}
+	a.markExpiry(files)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(files[0])
+	if err != nil {
+		log.Printf("Failed to encode file: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// displayName returns the name a file is listed by: the name it was given, or its filename.
+func (f *FileInfo) displayName() string {
+	return cmp.Or(f.Name, f.Filename)
+}
+
+// SetStoreRawUploads controls whether the original bytes of uploads are kept in memory
+// for download via /api/files/{id}/raw.
+func (a *API) SetStoreRawUploads(store bool) {
+	a.discardRaw = !store
+}
+
+// GetRawFile serves the original uploaded bytes of a file, with its SHA-256 digest in
+// the Content-Digest header so recipients can verify they received exactly the same log.
+func (a *API) GetRawFile(w http.ResponseWriter, r *http.Request) {
+	fileID := r.PathValue("id")
+
+	fileData := a.files[fileID]
+	if fileData == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+
+	if fileData.raw == nil {
+		http.Error(w, "Original file content is not stored", http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(fileData.Filename)))
+	if digest, err := hex.DecodeString(fileData.SHA256); err == nil {
+		w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+	}
+
+	_, err := w.Write(fileData.raw)
+	if err != nil {
+		log.Printf("Error writing raw file: %v", err)
+	}
+}
+
+// ReplaceFile re-parses a corrected log under an existing file ID. The ID, upload time, and
+// tags are kept, so anything bound to the dataset stays attached; derived caches are rebuilt.
+func (a *API) ReplaceFile(w http.ResponseWriter, r *http.Request) {
+	fileID := r.PathValue("id")
+
+	a.mu.RLock()
+	exists := a.files[fileID] != nil
+	a.mu.RUnlock()
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+
+	upload, ok := a.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fileData := a.files[fileID]
+	if fileData == nil { // Deleted while the upload was parsed
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+	var quotaErr *quotaError
+	if errors.As(a.checkQuota(map[string]int64{fileID: upload.size}, true), &quotaErr) {
+		a.metrics.uploads.Inc(uploadOverQuota)
+		writeQuotaError(w, quotaErr)
+
+		return
+	}
+
+	previous := len(fileData.Connections)
+	upload.applyTo(fileData)
+	a.guessFileServices(fileData)
+	a.checkWatchlist(fileID, fileData)
+	a.persistFile(fileID, fileData)
+	a.indexFile(fileID, fileData)
+
+	log.Printf("Replaced file %s with %s (%d -> %d connections)", fileID, upload.filename, previous, len(upload.connections))
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]any{
+		"success":              true,
+		"message":              fmt.Sprintf("Replaced %s with %d connections from %s", fileID, len(upload.connections), upload.filename),
+		"connections_count":    len(upload.connections),
+		"previous_connections": previous,
+		"filename":             upload.filename,
+		"file_id":              fileID,
+		"sha256":               upload.sha256,
+		"parse_errors":         upload.report.Summary(),
+		"ingest":               upload.metrics,
+	}
+	if warning := upload.report.Warning(); warning != "" {
+		response["warning"] = warning
+	}
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// UpdateFile renames a dataset and sets its description and case number, so that files
+// uploaded under the same name can be told apart, and turns flow stitching on or off. Fields
+// left out of the JSON body are kept; empty ones are cleared. It answers with the file's entry as /api/files lists it.
+func (a *API) UpdateFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		Name        *string  `json:"name"`
+		Description *string  `json:"description"`
+		CaseNumber  *string  `json:"case_number"` //nolint:tagliatelle // API consistency
+		StitchGap   *float64 `json:"stitch_gap"`  //nolint:tagliatelle // API consistency
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	for _, label := range []*string{request.Name, request.CaseNumber} {
+		if label != nil && utf8.RuneCountInString(strings.TrimSpace(*label)) > maxFileNameLength {
+			http.Error(w, errFileLabelTooLong.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+	if request.Description != nil && utf8.RuneCountInString(strings.TrimSpace(*request.Description)) > maxFileDescription {
+		http.Error(w, errFileDescTooLong.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if request.StitchGap != nil && (*request.StitchGap < 0 || *request.StitchGap > maxStitchGap) {
+		http.Error(w, errInvalidStitchGap.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	fileID := r.PathValue("id")
+	switch fileData := a.reloadFile(r.Context(), fileID); {
+	case fileData == nil:
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	case fileData.unloaded != nil: // Storing it again would need its content
+		http.Error(w, errUnloadedDataset.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+
+	fileData := a.files[fileID]
+	if request.StitchGap != nil && *request.StitchGap != fileData.StitchGap {
+		if a.isLiveDataset(fileID) {
+			http.Error(w, errStitchLive.Error(), http.StatusConflict)
+
+			return
+		}
+		fileData.setStitchGap(*request.StitchGap)
+		a.guessFileServices(fileData)
+		a.checkWatchlist(fileID, fileData)
+		log.Printf("Set flow stitching of file %s to %gs: %d records merged", fileID, fileData.StitchGap, fileData.stitchedRecords())
+	}
+	if request.Name != nil {
+		fileData.Name = strings.TrimSpace(*request.Name)
+	}
+	if request.Description != nil {
+		fileData.Description = strings.TrimSpace(*request.Description)
+	}
+	if request.CaseNumber != nil {
+		fileData.CaseNumber = strings.TrimSpace(*request.CaseNumber)
+	}
+	a.persistFile(fileID, fileData)
+	a.indexFile(fileID, fileData)
+
+	log.Printf("Updated file %s: name %q, case number %q", fileID, fileData.Name, fileData.CaseNumber)
+
+	err = json.NewEncoder(w).Encode(a.fileInfo(fileID, fileData, a.currentDataset(r)))
+	if err != nil {
+		log.Printf("Failed to encode file: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// filterFileInfos keeps files whose name, filename, or case number contains name
+// (case-insensitive) and that carry tag.
+func filterFileInfos(files []FileInfo, name, tag string) []FileInfo {
+	if name == "" && tag == "" {
+		return files
+	}
+
+	name = strings.ToLower(name)
+	filtered := make([]FileInfo, 0, len(files))
+	for _, file := range files {
+		if name != "" && !strings.Contains(strings.ToLower(file.Name+"\n"+file.Filename+"\n"+file.CaseNumber), name) {
+			continue
+		}
+		if tag != "" && !slices.Contains(file.Tags, tag) {
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+
+	return filtered
+}
+
+// sortFileInfos orders files by the given key. The default is upload time, most recent first;
+// other keys default to descending order except name, which defaults to ascending.
+func sortFileInfos(files []FileInfo, key, order string) error {
+	var less func(i, j int) bool
+
+	switch key {
+	case "", "upload_time":
+		less = func(i, j int) bool { return files[i].UploadTime < files[j].UploadTime }
+	case "size":
+		less = func(i, j int) bool { return files[i].Size < files[j].Size }
+	case "connections":
+		less = func(i, j int) bool { return files[i].ConnectionCount < files[j].ConnectionCount }
+	case "name":
+		less = func(i, j int) bool { return files[i].displayName() < files[j].displayName() }
+	default:
+		return errInvalidFileSort
+	}
+
+	descending := order == "desc" || (order == "" && key != "name")
+	sort.SliceStable(files, func(i, j int) bool {
+		if descending {
+			return less(j, i)
+		}
+
+		return less(i, j)
+	})
+
+	return nil
+}
+
+// paginateFileInfos returns the page of files starting at offset; a zero limit returns all remaining files.
+func paginateFileInfos(files []FileInfo, offset, limit int) []FileInfo {
+	if offset >= len(files) {
+		return []FileInfo{}
+	}
+
+	files = files[offset:]
+	if limit > 0 && len(files) > limit {
+		files = files[:limit]
+	}
+
+	return files
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/url"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+)
+
+// filterParams returns the query parameters filterConnections reads: those of connfilter,
+// and the country and threat filters, which need the API's GeoIP and threat databases.
+func filterParams() []string {
+	return append(connfilter.Params(), "country", "threat")
+}
+
+// filterConnections applies all supported query filters to the connections. Every endpoint
+// that reads connections filters them here, so they all accept the same parameters. Invalid
+// port, host, scope, or q filters, and country filters without a GeoIP database, are rejected
+// with an error for a 400 response.
+func (a *API) filterConnections(connections []models.Connection, query url.Values) ([]models.Connection, error) {
+	filter, err := connfilter.Parse(query, a.localNetworks)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Reported as the 400 response
+	}
+
+	connections = filter.Apply(connections)
+	connections = a.applyThreatFilter(connections, query.Get("threat"))
+
+	return a.applyCountryFilter(connections, query.Get("country"))
+}
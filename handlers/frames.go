@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+)
+
+const (
+	defaultFrameBucket = 60   // Seconds of traffic per frame by default
+	maxGraphFrames     = 1000 // Frames one response may hold
+)
+
+var errTooManyFrames = errors.New("bucket is too small: the time range would need more than 1000 frames")
+
+// graphFrames is the graph of each time bucket, as deltas from the previous frame unless
+// deltas=false.
+type graphFrames struct {
+	BucketSize int64        `json:"bucket_size"` //nolint:tagliatelle // API consistency
+	Start      int64        `json:"start"`
+	End        int64        `json:"end"`
+	Deltas     bool         `json:"deltas"`
+	Frames     []graphFrame `json:"frames"`
+}
+
+// graphFrame is the graph of the connections that started within one bucket. As a delta,
+// Nodes and Edges hold what appeared since the previous frame, the updated lists the new
+// counts of what stayed, and the removed lists what disappeared; otherwise Nodes and Edges
+// hold the whole graph.
+type graphFrame struct {
+	Start        int64         `json:"start"`
+	End          int64         `json:"end"`
+	Connections  int           `json:"connections"`
+	Nodes        []models.Node `json:"nodes"`
+	Edges        []frameEdge   `json:"edges"`
+	UpdatedNodes []frameUpdate `json:"updated_nodes,omitempty"` //nolint:tagliatelle // API consistency
+	UpdatedEdges []frameUpdate `json:"updated_edges,omitempty"` //nolint:tagliatelle // API consistency
+	RemovedNodes []string      `json:"removed_nodes,omitempty"` //nolint:tagliatelle // API consistency
+	RemovedEdges []string      `json:"removed_edges,omitempty"` //nolint:tagliatelle // API consistency
+	TotalNodes   int           `json:"total_nodes"`             //nolint:tagliatelle // API consistency
+	TotalEdges   int           `json:"total_edges"`             //nolint:tagliatelle // API consistency
+}
+
+// frameEdge is an edge of a frame with the ID later frames update or remove it by.
+type frameEdge struct {
+	ID string `json:"id"`
+
+	models.Edge
+}
+
+// frameUpdate holds the new counts of a node or edge that was already in the previous frame.
+type frameUpdate struct {
+	ID          string `json:"id"`
+	Connections int    `json:"connections"` // The node's connections, or the edge's count
+	TotalBytes  int    `json:"total_bytes"` //nolint:tagliatelle // API consistency
+}
+
+// GetGraphFrames returns the network graph for every bucket of the capture, so clients can
+// animate how its topology evolves. Frames are deltas from the previous one to keep the
+// payload small, unless deltas=false. Accepts the standard filters, bucket (seconds or auto,
+// 60 by default), subnet_group, subnet_group_v6, and edge_by.
+func (a *API) GetGraphFrames(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	grouping, err := parseSubnetGrouping(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	aggregation, err := parseEdgeAggregation(query.Get("edge_by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	bucketSize := int64(defaultFrameBucket)
+	if query.Get("bucket") != "" {
+		bucketSize, err = parseTimelineBucket(query, connections)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	frames, err := a.buildGraphFrames(connections, bucketSize, grouping, aggregation, query.Get("deltas") != "false")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(frames)
+	if err != nil {
+		log.Printf("Failed to encode graph frames: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// buildGraphFrames buckets the connections by start time and builds the graph of each bucket,
+// empty ones included, so frames are evenly spaced.
+func (a *API) buildGraphFrames(
+	connections []models.Connection, bucketSize int64, grouping *subnetGrouping, aggregation string, deltas bool,
+) (*graphFrames, error) {
+	frames := &graphFrames{BucketSize: bucketSize, Deltas: deltas, Frames: []graphFrame{}}
+	if len(connections) == 0 {
+		return frames, nil
+	}
+
+	sorted := slices.Clone(connections)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+	frames.Start = bucketStart(int64(sorted[0].Timestamp), bucketSize, nil)
+	frames.End = bucketStart(int64(sorted[len(sorted)-1].Timestamp), bucketSize, nil) + bucketSize
+	if (frames.End-frames.Start)/bucketSize > maxGraphFrames {
+		return nil, errTooManyFrames
+	}
+
+	var previousNodes map[string]models.Node
+	var previousEdges map[string]frameEdge
+	next := 0
+	for start := frames.Start; start < frames.End; start += bucketSize {
+		first := next
+		for next < len(sorted) && int64(sorted[next].Timestamp) < start+bucketSize {
+			next++
+		}
+		bucket := sorted[first:next]
+
+		var nodes []models.Node
+		var edges []models.Edge
+		if grouping.enabled() {
+			nodes, edges = buildSubnetGraph(bucket, grouping, a.localNetworks, aggregation)
+		} else {
+			nodes, edges = netgraph.NodesAndEdges(bucket, a.localNetworks, aggregation)
+		}
+
+		frame := graphFrame{
+			Start:       start,
+			End:         start + bucketSize,
+			Connections: len(bucket),
+			Nodes:       []models.Node{},
+			Edges:       []frameEdge{},
+			TotalNodes:  len(nodes),
+			TotalEdges:  len(edges),
+		}
+		currentNodes := make(map[string]models.Node, len(nodes))
+		for _, node := range nodes {
+			currentNodes[node.ID] = node
+			old, existed := previousNodes[node.ID]
+			switch {
+			case !deltas || !existed:
+				frame.Nodes = append(frame.Nodes, node)
+			case old.Connections != node.Connections || old.TotalBytes != node.TotalBytes:
+				frame.UpdatedNodes = append(frame.UpdatedNodes, frameUpdate{node.ID, node.Connections, node.TotalBytes})
+			}
+		}
+		currentEdges := make(map[string]frameEdge, len(edges))
+		for _, edge := range edges {
+			current := frameEdge{ID: netgraph.EdgeID(&edge, aggregation), Edge: edge}
+			currentEdges[current.ID] = current
+			old, existed := previousEdges[current.ID]
+			switch {
+			case !deltas || !existed:
+				frame.Edges = append(frame.Edges, current)
+			case old.Count != edge.Count || old.TotalBytes != edge.TotalBytes:
+				frame.UpdatedEdges = append(frame.UpdatedEdges, frameUpdate{current.ID, edge.Count, edge.TotalBytes})
+			}
+		}
+		if deltas {
+			for id := range previousNodes {
+				if _, kept := currentNodes[id]; !kept {
+					frame.RemovedNodes = append(frame.RemovedNodes, id)
+				}
+			}
+			for id := range previousEdges {
+				if _, kept := currentEdges[id]; !kept {
+					frame.RemovedEdges = append(frame.RemovedEdges, id)
+				}
+			}
+		}
+
+		slices.SortFunc(frame.Nodes, func(x, y models.Node) int { return cmp.Compare(x.ID, y.ID) })
+		slices.SortFunc(frame.Edges, func(x, y frameEdge) int { return cmp.Compare(x.ID, y.ID) })
+		slices.SortFunc(frame.UpdatedNodes, func(x, y frameUpdate) int { return cmp.Compare(x.ID, y.ID) })
+		slices.SortFunc(frame.UpdatedEdges, func(x, y frameUpdate) int { return cmp.Compare(x.ID, y.ID) })
+		slices.Sort(frame.RemovedNodes)
+		slices.Sort(frame.RemovedEdges)
+		frames.Frames = append(frames.Frames, frame)
+		previousNodes, previousEdges = currentNodes, currentEdges
+	}
+
+	return frames, nil
+}
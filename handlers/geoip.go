@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/geoip"
+	"zeek-viz/models"
+)
+
+var errGeoIPDisabled = errors.New("country filter needs a GeoIP database (--geoip-db)")
+
+// SetGeoIP enables location lookups for external hosts.
+func (a *API) SetGeoIP(db *geoip.DB) {
+	a.geoip = db
+}
+
+// annotateLocations adds the country, city, and autonomous system of the external hosts. Without
+// a GeoIP database the nodes are returned unchanged; otherwise they are a copy, since the
+// unfiltered graph is shared by concurrent requests.
+func (a *API) annotateLocations(nodes []models.Node) []models.Node {
+	if a.geoip == nil {
+		return nodes
+	}
+
+	nodes = slices.Clone(nodes)
+	for i := range nodes {
+		node := &nodes[i]
+		if node.IsLocal {
+			continue
+		}
+		if location, found := a.geoip.Lookup(node.ID); found {
+			node.Country, node.City, node.ASN, node.ASOrg = location.Country, location.City, location.ASN, location.ASOrg
+		}
+	}
+
+	return nodes
+}
+
+// applyCountryFilter keeps connections with an external host in one of the comma-separated
+// countries (ISO codes such as DE or US).
+func (a *API) applyCountryFilter(connections []models.Connection, value string) ([]models.Connection, error) {
+	countries := connfilter.SplitList(value)
+	if len(countries) == 0 {
+		return connections, nil
+	}
+	if a.geoip == nil {
+		return nil, errGeoIPDisabled
+	}
+
+	// Hosts repeat across connections, so each is looked up once
+	matches := make(map[string]bool)
+	inCountry := func(host string) bool {
+		matched, seen := matches[host]
+		if !seen {
+			location, _ := a.geoip.Lookup(host)
+			matched = !a.localNetworks.Contains(host) && location.Matches(countries)
+			matches[host] = matched
+		}
+
+		return matched
+	}
+
+	var filtered []models.Connection
+	for _, conn := range connections {
+		if inCountry(conn.OrigHost) || inCountry(conn.RespHost) {
+			filtered = append(filtered, conn)
+		}
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+// fileCoverage is the time span covered by one file.
+type fileCoverage struct {
+	ID          string  `json:"id"`
+	Filename    string  `json:"filename"`
+	Connections int     `json:"connections"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+}
+
+// fileOverlap is the time span shared by two files.
+type fileOverlap struct {
+	FileA    string  `json:"file_a"` //nolint:tagliatelle // API consistency
+	FileB    string  `json:"file_b"` //nolint:tagliatelle // API consistency
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+}
+
+// GetGlobalStats aggregates connection counts, unique hosts, and time coverage across all loaded files.
+func (a *API) GetGlobalStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uniqueIPs := models.NewHyperLogLog()
+	coverage := make([]fileCoverage, 0, len(a.files))
+	var totalConnections, totalBytes int
+
+	for fileID, fileData := range a.files {
+		if fileData.Stats == nil {
+			continue
+		}
+
+		totalConnections += fileData.Stats.TotalConnections
+		totalBytes += fileData.Stats.TotalBytes
+		uniqueIPs.Merge(fileData.Stats.UniqueIPs)
+
+		if fileData.Stats.TotalConnections > 0 {
+			coverage = append(coverage, fileCoverage{
+				ID:          fileID,
+				Filename:    fileData.Filename,
+				Connections: fileData.Stats.TotalConnections,
+				Start:       fileData.Stats.StartTime,
+				End:         fileData.Stats.EndTime,
+			})
+		}
+	}
+
+	sort.Slice(coverage, func(i, j int) bool {
+		return coverage[i].Start < coverage[j].Start
+	})
+
+	start, end, covered := coverageUnion(coverage)
+
+	timeRange := map[string]any{
+		"start":    start,
+		"end":      end,
+		"duration": end - start,
+		"covered":  covered,
+	}
+
+	stats := map[string]any{
+		"total_files":       len(a.files),
+		"total_connections": totalConnections,
+		"total_bytes":       totalBytes,
+		"unique_ip_count":   uniqueIPs.Count(),
+		"time_range":        timeRange,
+		"files":             coverage,
+		"overlaps":          findOverlaps(coverage),
+	}
+
+	if wantsHumanize(r.URL.Query()) {
+		stats["total_connections_human"] = connstats.HumanizeCount(totalConnections)
+		stats["total_bytes_human"] = connstats.HumanizeBytes(float64(totalBytes))
+		stats["unique_ip_count_human"] = connstats.HumanizeCount(uniqueIPs.Count())
+		timeRange["duration_human"] = connstats.HumanizeDuration(end - start)
+		timeRange["covered_human"] = connstats.HumanizeDuration(covered)
+	}
+
+	err := json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.Printf("Failed to encode global stats: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// coverageUnion returns the overall time range and the total duration covered by at least
+// one file. The coverage must be sorted by start time.
+func coverageUnion(coverage []fileCoverage) (float64, float64, float64) {
+	if len(coverage) == 0 {
+		return -1, -1, 0
+	}
+
+	start, end := coverage[0].Start, coverage[0].End
+	var covered float64
+	spanStart, spanEnd := coverage[0].Start, coverage[0].End
+
+	for _, file := range coverage[1:] {
+		end = max(end, file.End)
+		if file.Start > spanEnd {
+			covered += spanEnd - spanStart
+			spanStart, spanEnd = file.Start, file.End
+
+			continue
+		}
+		spanEnd = max(spanEnd, file.End)
+	}
+	covered += spanEnd - spanStart
+
+	return start, end, covered
+}
+
+// findOverlaps returns every pair of files whose time ranges intersect.
+// The coverage must be sorted by start time.
+func findOverlaps(coverage []fileCoverage) []fileOverlap {
+	overlaps := make([]fileOverlap, 0)
+
+	for i := range coverage {
+		for j := i + 1; j < len(coverage) && coverage[j].Start <= coverage[i].End; j++ {
+			overlapStart := coverage[j].Start
+			overlapEnd := min(coverage[i].End, coverage[j].End)
+			overlaps = append(overlaps, fileOverlap{
+				FileA:    coverage[i].ID,
+				FileB:    coverage[j].ID,
+				Start:    overlapStart,
+				End:      overlapEnd,
+				Duration: overlapEnd - overlapStart,
+			})
+		}
+	}
+
+	return overlaps
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+	"sort"
+
+	"zeek-viz/models"
+)
+
+const (
+	nodeSortBytes       = "bytes"       // Order nodes by total bytes
+	nodeSortConnections = "connections" // Order nodes by connection count
+	nodeSortDegree      = "degree"      // Order nodes by number of distinct peers
+	nodeSortRisk        = "risk"        // Order nodes by risk score
+)
+
+var errInvalidNodeSort = errors.New("sort must be bytes, connections, degree, or risk")
+
+// limitNodes orders the graph's nodes by the given metric, most active first, and keeps
+// the first limit of them together with the edges between kept nodes. A limit without a
+// sort keeps the nodes with the most connections.
+func limitNodes(graph *models.NetworkGraph, sortBy string, limit int) error {
+	if sortBy == "" && limit <= 0 {
+		return nil
+	}
+	if sortBy == "" {
+		sortBy = nodeSortConnections
+	}
+
+	var metric func(node *models.Node) float64
+	switch sortBy {
+	case nodeSortBytes:
+		metric = func(node *models.Node) float64 { return float64(node.TotalBytes) }
+	case nodeSortConnections:
+		metric = func(node *models.Node) float64 { return float64(node.Connections) }
+	case nodeSortDegree:
+		degrees := nodeDegrees(graph.Edges)
+		metric = func(node *models.Node) float64 { return float64(degrees[node.ID]) }
+	case nodeSortRisk:
+		metric = func(node *models.Node) float64 { return node.RiskScore }
+	default:
+		return errInvalidNodeSort
+	}
+
+	// The node slice may be shared with the file's graph cache
+	graph.Nodes = slices.Clone(graph.Nodes)
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		a, b := metric(&graph.Nodes[i]), metric(&graph.Nodes[j])
+		if a != b {
+			return a > b
+		}
+
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+
+	if limit <= 0 {
+		return nil
+	}
+
+	if graph.Limits == nil {
+		graph.Limits = make(map[string]int)
+	}
+	graph.Limits["limit"] = limit
+
+	if len(graph.Nodes) <= limit {
+		return nil
+	}
+
+	graph.Nodes = graph.Nodes[:limit]
+	graph.Edges = edgesBetween(graph.Nodes, graph.Edges)
+	graph.Truncated = true
+
+	return nil
+}
+
+// pruneEdges drops edges with fewer connections or bytes than the given minimums, together
+// with the nodes left without any edge, and records the thresholds in the graph limits.
+func pruneEdges(graph *models.NetworkGraph, minCount, minBytes int) {
+	if minCount <= 0 && minBytes <= 0 {
+		return
+	}
+
+	if graph.Limits == nil {
+		graph.Limits = make(map[string]int)
+	}
+	if minCount > 0 {
+		graph.Limits["min_edge_count"] = minCount
+	}
+	if minBytes > 0 {
+		graph.Limits["min_edge_bytes"] = minBytes
+	}
+
+	edges := make([]models.Edge, 0, len(graph.Edges))
+	connected := make(map[string]bool, len(graph.Nodes))
+	for _, edge := range graph.Edges {
+		if edge.Count < minCount || edge.TotalBytes < minBytes {
+			continue
+		}
+		edges = append(edges, edge)
+		connected[edge.Source] = true
+		connected[edge.Target] = true
+	}
+
+	nodes := make([]models.Node, 0, len(connected))
+	for _, node := range graph.Nodes {
+		if connected[node.ID] {
+			nodes = append(nodes, node)
+		}
+	}
+
+	graph.Nodes = nodes
+	graph.Edges = edges
+}
+
+// pruneNodes drops nodes with fewer than minConnections connections, together with their
+// edges, and records the threshold in the graph limits.
+func pruneNodes(graph *models.NetworkGraph, minConnections int) {
+	if minConnections <= 0 {
+		return
+	}
+
+	if graph.Limits == nil {
+		graph.Limits = make(map[string]int)
+	}
+	graph.Limits["min_connections"] = minConnections
+
+	nodes := make([]models.Node, 0, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		if node.Connections >= minConnections {
+			nodes = append(nodes, node)
+		}
+	}
+
+	graph.Nodes = nodes
+	graph.Edges = edgesBetween(nodes, graph.Edges)
+}
+
+// nodeDegrees returns the number of distinct peers of each node.
+func nodeDegrees(edges []models.Edge) map[string]int {
+	peers := make(map[[2]string]bool, len(edges))
+	degrees := make(map[string]int)
+
+	for _, edge := range edges {
+		pair := [2]string{min(edge.Source, edge.Target), max(edge.Source, edge.Target)}
+		if peers[pair] {
+			continue
+		}
+		peers[pair] = true
+		degrees[edge.Source]++
+		if edge.Target != edge.Source {
+			degrees[edge.Target]++
+		}
+	}
+
+	return degrees
+}
+
+// edgesBetween returns the edges whose endpoints are both among the nodes.
+func edgesBetween(nodes []models.Node, edges []models.Edge) []models.Edge {
+	kept := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		kept[node.ID] = true
+	}
+
+	filtered := make([]models.Edge, 0, len(edges))
+	for _, edge := range edges {
+		if kept[edge.Source] && kept[edge.Target] {
+			filtered = append(filtered, edge)
+		}
+	}
+
+	return filtered
+}
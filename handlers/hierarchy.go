@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"zeek-viz/models"
+)
+
+const unknownService = "unknown" // Label for connections without a Zeek service
+
+// HierarchyNode is one level of the proto → service → port breakdown.
+type HierarchyNode struct {
+	Name     string           `json:"name"`
+	Count    int              `json:"count"`
+	Bytes    int              `json:"bytes"`
+	Children []*HierarchyNode `json:"children,omitempty"`
+
+	childIndex map[string]*HierarchyNode
+}
+
+// GetHierarchy returns a nested protocol → service → responder port breakdown with
+// counts and bytes, shaped for sunburst/treemap visualizations.
+func (a *API) GetHierarchy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	connections, err := a.filterCurrentConnections(r, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	root := buildHierarchy(connections)
+
+	err = json.NewEncoder(w).Encode(root)
+	if err != nil {
+		log.Printf("Failed to encode hierarchy: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// buildHierarchy groups connections into a proto → service → port tree.
+func buildHierarchy(connections []models.Connection) *HierarchyNode {
+	root := &HierarchyNode{Name: "all"}
+
+	for i := range connections {
+		conn := &connections[i]
+		service := conn.Service
+		if service == "" {
+			service = unknownService
+		}
+
+		bytes := conn.TotalBytes()
+		root.add(bytes)
+		protoNode := root.child(conn.Protocol)
+		protoNode.add(bytes)
+		serviceNode := protoNode.child(service)
+		serviceNode.add(bytes)
+		serviceNode.child(strconv.Itoa(conn.RespPort)).add(bytes)
+	}
+
+	root.sortChildren()
+
+	return root
+}
+
+// add accounts one connection to the node.
+func (n *HierarchyNode) add(bytes int) {
+	n.Count++
+	n.Bytes += bytes
+}
+
+// child returns the named child node, creating it if needed.
+func (n *HierarchyNode) child(name string) *HierarchyNode {
+	if n.childIndex == nil {
+		n.childIndex = make(map[string]*HierarchyNode)
+	}
+
+	if existing, exists := n.childIndex[name]; exists {
+		return existing
+	}
+
+	node := &HierarchyNode{Name: name}
+	n.childIndex[name] = node
+	n.Children = append(n.Children, node)
+
+	return node
+}
+
+// sortChildren orders children by bytes (descending) at every level.
+func (n *HierarchyNode) sortChildren() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Bytes > n.Children[j].Bytes
+	})
+
+	for _, child := range n.Children {
+		child.sortChildren()
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"zeek-viz/models"
+)
+
+const (
+	defaultHistogramBins = 20   // Default number of histogram bins
+	maxHistogramBins     = 1000 // Upper bound on the number of bins
+	linearScale          = "linear"
+	logScale             = "log"
+)
+
+var (
+	errFieldRequired   = errors.New("field parameter is required")
+	errNotNumericField = errors.New("field is not numeric")
+	errInvalidScale    = errors.New("scale must be linear or log")
+)
+
+// HistogramBin is one bin of a histogram covering [Lower, Upper).
+type HistogramBin struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int     `json:"count"`
+}
+
+// Histogram is the binned distribution of a numeric field.
+type Histogram struct {
+	Field string         `json:"field"`
+	Scale string         `json:"scale"`
+	Bins  []HistogramBin `json:"bins"`
+	Min   float64        `json:"min"`
+	Max   float64        `json:"max"`
+	Total int            `json:"total"`
+	// NonPositive counts values <= 0, which cannot be placed on a log scale.
+	NonPositive int `json:"non_positive,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// GetHistogram bins any numeric connection field of the filtered connections.
+func (a *API) GetHistogram(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+
+	bins := parseLimit(query, "bins")
+	if bins == 0 {
+		bins = defaultHistogramBins
+	}
+	bins = min(bins, maxHistogramBins)
+
+	scale := query.Get("scale")
+	if scale == "" {
+		scale = linearScale
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	histogram, err := buildHistogram(connections, query.Get("field"), bins, scale)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(histogram)
+	if err != nil {
+		log.Printf("Failed to encode histogram: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// buildHistogram bins the values of a numeric field on a linear or logarithmic scale.
+func buildHistogram(connections []models.Connection, field string, bins int, scale string) (*Histogram, error) {
+	if field == "" {
+		return nil, errFieldRequired
+	}
+	if scale != linearScale && scale != logScale {
+		return nil, errInvalidScale
+	}
+
+	accessor, ok := models.NumericFieldAccessor(field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errNotNumericField, field)
+	}
+
+	histogram := &Histogram{Field: field, Scale: scale, Bins: []HistogramBin{}, Total: len(connections)}
+
+	values := make([]float64, 0, len(connections))
+	for i := range connections {
+		value := accessor(&connections[i])
+		if scale == logScale && value <= 0 {
+			histogram.NonPositive++
+
+			continue
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 0 {
+		return histogram, nil
+	}
+
+	histogram.Min, histogram.Max = values[0], values[0]
+	for _, value := range values {
+		histogram.Min = math.Min(histogram.Min, value)
+		histogram.Max = math.Max(histogram.Max, value)
+	}
+
+	// Transform to log space so equal-width bins become geometric
+	transform := func(v float64) float64 { return v }
+	inverse := func(v float64) float64 { return v }
+	if scale == logScale {
+		transform = math.Log10
+		inverse = func(v float64) float64 { return math.Pow(10, v) }
+	}
+
+	low, high := transform(histogram.Min), transform(histogram.Max)
+	width := (high - low) / float64(bins)
+	if width == 0 {
+		bins = 1
+		width = 1
+	}
+
+	histogram.Bins = make([]HistogramBin, bins)
+	for i := range histogram.Bins {
+		histogram.Bins[i].Lower = inverse(low + float64(i)*width)
+		histogram.Bins[i].Upper = inverse(low + float64(i+1)*width)
+	}
+
+	for _, value := range values {
+		index := min(int((transform(value)-low)/width), bins-1)
+		histogram.Bins[index].Count++
+	}
+
+	return histogram, nil
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+)
+
+const defaultHostProfileLimit = 100 // Peers and services a host profile lists by default
+
+var (
+	errInvalidHost  = errors.New("host must be an IP address or a CIDR prefix")
+	errHostNotFound = errors.New("no connections of the host match the filters")
+)
+
+// hostProfileResponse is everything known about a host under the filters: its graph node with
+// location, threat, scan, and annotation, its traffic in each direction, peers, services,
+// connection states, and timeline.
+type hostProfileResponse struct {
+	Host          string                      `json:"host"`
+	Node          models.Node                 `json:"node"`
+	Originated    int                         `json:"originated"` // Connections the host originated
+	Received      int                         `json:"received"`   // Connections the host responded to
+	BytesOut      int                         `json:"bytes_out"`  //nolint:tagliatelle // API consistency
+	BytesIn       int                         `json:"bytes_in"`   //nolint:tagliatelle // API consistency
+	Peers         []hostPeer                  `json:"peers"`
+	TotalPeers    int                         `json:"total_peers"` //nolint:tagliatelle // API consistency
+	Services      []hostService               `json:"services"`
+	TotalServices int                         `json:"total_services"` //nolint:tagliatelle // API consistency
+	ConnStates    map[string]int              `json:"conn_states"`    //nolint:tagliatelle // API consistency
+	Timeline      *models.DirectionalTimeline `json:"timeline"`
+}
+
+// hostPeer is a host the profiled one exchanged traffic with.
+type hostPeer struct {
+	Host        string   `json:"host"`
+	Connections int      `json:"connections"`
+	Originated  int      `json:"originated"` // Connections the profiled host originated to the peer
+	BytesOut    int      `json:"bytes_out"`  //nolint:tagliatelle // Sent to the peer
+	BytesIn     int      `json:"bytes_in"`   //nolint:tagliatelle // Received from the peer
+	Services    []string `json:"services,omitempty"`
+	FirstSeen   float64  `json:"first_seen"` //nolint:tagliatelle // API consistency
+	LastSeen    float64  `json:"last_seen"`  //nolint:tagliatelle // API consistency
+}
+
+// hostService is a protocol, responder port, and Zeek service of the host's connections, as a
+// client (originated) or a server.
+type hostService struct {
+	Protocol    string `json:"protocol"`
+	Port        int    `json:"port"`              // Responder port
+	Service     string `json:"service,omitempty"` // Zeek's detected service
+	Connections int    `json:"connections"`
+	Originated  int    `json:"originated"` // Connections the host made as the client
+	BytesOut    int    `json:"bytes_out"`  //nolint:tagliatelle // API consistency
+	BytesIn     int    `json:"bytes_in"`   //nolint:tagliatelle // API consistency
+}
+
+// hostMatcher selects the connections of a host: an address, or the addresses in a subnet
+// node's prefix.
+type hostMatcher struct {
+	host   string
+	prefix netip.Prefix
+}
+
+// newHostMatcher reads the host of a profile request, an IP address or a CIDR prefix.
+func newHostMatcher(host string) (*hostMatcher, error) {
+	if strings.Contains(host, "/") {
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return nil, errInvalidHost
+		}
+
+		return &hostMatcher{host: prefix.Masked().String(), prefix: prefix.Masked()}, nil
+	}
+	_, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, errInvalidHost
+	}
+
+	return &hostMatcher{host: models.CanonicalHost(host)}, nil
+}
+
+// contains reports whether the address is the host or lies in its prefix.
+func (m *hostMatcher) contains(host string) bool {
+	if !m.prefix.IsValid() {
+		return host == m.host
+	}
+	addr, err := models.ParseHost(host)
+
+	return err == nil && m.prefix.Contains(addr)
+}
+
+// direction classifies connections relative to the host: bytes it sent are outgoing.
+// Connections within a subnet count as originated.
+func (m *hostMatcher) direction() directionFunc {
+	return func(conn *models.Connection) (bool, int, int) {
+		switch {
+		case m.contains(conn.OrigHost):
+			return true, conn.OrigBytes, conn.RespBytes
+		case m.contains(conn.RespHost):
+			return true, conn.RespBytes, conn.OrigBytes
+		default:
+			return false, 0, 0
+		}
+	}
+}
+
+// GetHostProfile returns the profile of the host in the path, an IP address or the CIDR prefix
+// of a subnet node, from the connections matching the standard filters. The busiest limit
+// peers and services are listed (100 by default); bucket and tz shape the timeline as for
+// /api/nodes/{ip}/timeline, and hostnames=false skips reverse DNS.
+func (a *API) GetHostProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	matcher, err := newHostMatcher(r.PathValue("ip"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultHostProfileLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	classify := matcher.direction()
+	var matched []models.Connection
+	for i := range connections {
+		if ok, _, _ := classify(&connections[i]); ok {
+			matched = append(matched, connections[i])
+		}
+	}
+	if len(matched) == 0 {
+		http.Error(w, errHostNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	profile := buildHostProfile(matched, matcher, limit)
+	profile.Node = a.hostNode(r, matched, matcher)
+	profile.Timeline = buildDirectionalTimeline(matched, query, loc, classify)
+
+	err = json.NewEncoder(w).Encode(profile)
+	if err != nil {
+		log.Printf("Failed to encode host profile: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// buildHostProfile sums up the host's connections by direction, peer, service, and state,
+// keeping the limit busiest peers and services.
+func buildHostProfile(connections []models.Connection, matcher *hostMatcher, limit int) hostProfileResponse {
+	profile := hostProfileResponse{Host: matcher.host, ConnStates: map[string]int{}}
+	type serviceKey struct {
+		protocol string
+		port     int
+		service  string
+	}
+	peers := map[string]*hostPeer{}
+	services := map[serviceKey]*hostService{}
+	for i := range connections {
+		conn := &connections[i]
+		originated := matcher.contains(conn.OrigHost)
+		peer, bytesOut, bytesIn := conn.RespHost, conn.OrigBytes, conn.RespBytes
+		if !originated {
+			peer, bytesOut, bytesIn = conn.OrigHost, conn.RespBytes, conn.OrigBytes
+		}
+
+		if originated {
+			profile.Originated++
+		} else {
+			profile.Received++
+		}
+		profile.BytesOut += bytesOut
+		profile.BytesIn += bytesIn
+		profile.ConnStates[conn.ConnState]++
+
+		p, exists := peers[peer]
+		if !exists {
+			p = &hostPeer{Host: peer, FirstSeen: conn.Timestamp, LastSeen: conn.Timestamp}
+			peers[peer] = p
+		}
+		p.Connections++
+		if originated {
+			p.Originated++
+		}
+		p.BytesOut += bytesOut
+		p.BytesIn += bytesIn
+		p.FirstSeen = min(p.FirstSeen, conn.Timestamp)
+		p.LastSeen = max(p.LastSeen, conn.Timestamp)
+		if conn.Service != "" && !slices.Contains(p.Services, conn.Service) {
+			p.Services = append(p.Services, conn.Service)
+		}
+
+		key := serviceKey{conn.Protocol, conn.RespPort, conn.Service}
+		s, exists := services[key]
+		if !exists {
+			s = &hostService{Protocol: conn.Protocol, Port: conn.RespPort, Service: conn.Service}
+			services[key] = s
+		}
+		s.Connections++
+		if originated {
+			s.Originated++
+		}
+		s.BytesOut += bytesOut
+		s.BytesIn += bytesIn
+	}
+
+	profile.TotalPeers = len(peers)
+	for _, p := range peers {
+		slices.Sort(p.Services)
+		profile.Peers = append(profile.Peers, *p)
+	}
+	slices.SortFunc(profile.Peers, func(x, y hostPeer) int {
+		return cmp.Or(cmp.Compare(y.Connections, x.Connections), cmp.Compare(y.BytesOut+y.BytesIn, x.BytesOut+x.BytesIn), cmp.Compare(x.Host, y.Host))
+	})
+	profile.Peers = profile.Peers[:min(len(profile.Peers), limit)]
+
+	profile.TotalServices = len(services)
+	for _, s := range services {
+		profile.Services = append(profile.Services, *s)
+	}
+	slices.SortFunc(profile.Services, func(x, y hostService) int {
+		return cmp.Or(cmp.Compare(y.Connections, x.Connections), cmp.Compare(x.Protocol, y.Protocol), cmp.Compare(x.Port, y.Port), cmp.Compare(x.Service, y.Service))
+	})
+	profile.Services = profile.Services[:min(len(profile.Services), limit)]
+
+	return profile
+}
+
+// hostNode builds the host's graph node from its connections and annotates it the way
+// /api/nodes does. Subnet nodes are built with the host's prefix length as grouping.
+func (a *API) hostNode(r *http.Request, connections []models.Connection, matcher *hostMatcher) models.Node {
+	var nodes []models.Node
+	var edges []models.Edge
+	if matcher.prefix.IsValid() {
+		grouping := &subnetGrouping{ipv4: matcher.prefix.Bits(), ipv6: matcher.prefix.Bits()}
+		nodes, edges = buildSubnetGraph(connections, grouping, a.localNetworks, netgraph.ByProtocol)
+	} else {
+		nodes, edges = netgraph.NodesAndEdges(connections, a.localNetworks, netgraph.ByProtocol)
+	}
+
+	graph := models.NetworkGraph{Nodes: nodes, Edges: edges}
+	graph.Nodes, _ = suppressNodeFindings(graph.Nodes, a.suppressions)
+	graph.Nodes = a.annotateLocations(graph.Nodes)
+	a.annotateGraphThreats(&graph)
+	if !matcher.prefix.IsValid() {
+		a.annotateGraphScans(&graph, detectScans(connections, defaultScanThresholds()))
+	}
+	graph.Nodes = a.annotateNodes(graph.Nodes)
+
+	index := slices.IndexFunc(graph.Nodes, func(node models.Node) bool {
+		return node.ID == matcher.host
+	})
+	if index < 0 {
+		return models.Node{ID: matcher.host, Label: matcher.host}
+	}
+	node := graph.Nodes[index]
+	if r.URL.Query().Get("hostnames") != "false" {
+		node = a.annotateHostnames(r.Context(), []models.Node{node})[0]
+	}
+
+	return node
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"math"
+	"net/url"
+	"strings"
+
+	"zeek-viz/connstats"
+)
+
+// wantsHumanize reports whether formatted companion fields were requested with humanize=true.
+func wantsHumanize(query url.Values) bool {
+	return query.Get("humanize") == "true"
+}
+
+// humanizeMetric formats a metric value according to the field it was computed from:
+// byte fields as sizes, duration as time, everything else as a count.
+func humanizeMetric(field string, value float64) string {
+	switch {
+	case strings.HasSuffix(field, "bytes"):
+		return connstats.HumanizeBytes(value)
+	case field == "duration":
+		return connstats.HumanizeDuration(value)
+	default:
+		return connstats.HumanizeCount(int(math.Round(value)))
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+)
+
+// connectionIndex locates the connections of a dataset by time, protocol, connection state,
+// and host, so filtered queries only run the filters over the connections that can match
+// instead of scanning the whole dataset. Positions refer to the dataset's Connections and are
+// ascending in every list except byTime.
+type connectionIndex struct {
+	byTime     []int32                // Positions ordered by timestamp
+	timestamps []float64              // Timestamps in byTime order, for binary search
+	protocols  map[string][]int32     // Positions by protocol
+	states     map[string][]int32     // Positions by connection state
+	hosts      map[netip.Addr][]int32 // Positions by originator or responder address
+}
+
+// buildConnectionIndex indexes connections.
+func buildConnectionIndex(connections []models.Connection) *connectionIndex {
+	index := &connectionIndex{
+		byTime:     make([]int32, len(connections)),
+		timestamps: make([]float64, len(connections)),
+		protocols:  make(map[string][]int32),
+		states:     make(map[string][]int32),
+		hosts:      make(map[netip.Addr][]int32),
+	}
+
+	for i := range connections {
+		conn := &connections[i]
+		position := int32(i) //nolint:gosec // Datasets hold far fewer than 2^31 connections
+		index.byTime[i] = position
+		index.protocols[conn.Protocol] = append(index.protocols[conn.Protocol], position)
+		index.states[conn.ConnState] = append(index.states[conn.ConnState], position)
+
+		orig, origErr := models.ParseHost(conn.OrigHost)
+		if origErr == nil {
+			index.hosts[orig] = append(index.hosts[orig], position)
+		}
+		if resp, err := models.ParseHost(conn.RespHost); err == nil && (origErr != nil || resp != orig) {
+			index.hosts[resp] = append(index.hosts[resp], position)
+		}
+	}
+
+	sort.SliceStable(index.byTime, func(i, j int) bool {
+		return connections[index.byTime[i]].Timestamp < connections[index.byTime[j]].Timestamp
+	})
+	for i, position := range index.byTime {
+		index.timestamps[i] = connections[position].Timestamp
+	}
+
+	return index
+}
+
+// candidates returns the ascending positions of the connections that can match the query's
+// time, protocol, conn_state, and single-address host filters, using the most selective
+// of them. ok is false when none of the filters are indexed, so every connection is a
+// candidate. The candidates are a superset of the matches: filterConnections still applies
+// every filter to them.
+func (ix *connectionIndex) candidates(query url.Values) ([]int32, bool) {
+	var best []int32
+	found := false
+	consider := func(positions []int32) {
+		if !found || len(positions) < len(best) {
+			best, found = positions, true
+		}
+	}
+
+	if positions, ok := ix.timeRange(query.Get("start"), query.Get("end")); ok {
+		consider(positions)
+	}
+	if protocol := query.Get("protocol"); protocol != "" && protocol != allProtocol {
+		consider(ix.protocols[protocol])
+	}
+	if state := query.Get("conn_state"); state != "" && state != allProtocol {
+		consider(ix.states[state])
+	}
+	for _, param := range []string{"orig_host", "resp_host", "subnet"} {
+		if positions, ok := ix.hostPositions(query.Get(param)); ok {
+			consider(positions)
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	if !slices.IsSorted(best) {
+		best = slices.Sorted(slices.Values(best)) // Time ranges are in time order
+	}
+
+	return best, true
+}
+
+// timeRange returns the positions of the connections between the start and end parameters,
+// as connfilter reads them, in time order. It errs on the side of including a second
+// more on both ends; the time filter drops those.
+func (ix *connectionIndex) timeRange(startTime, endTime string) ([]int32, bool) {
+	if startTime == "" || endTime == "" {
+		return nil, false
+	}
+	start, err1 := strconv.ParseInt(startTime, 10, 64)
+	end, err2 := strconv.ParseInt(endTime, 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, false
+	}
+
+	low := sort.SearchFloat64s(ix.timestamps, float64(start-1))
+	high := sort.SearchFloat64s(ix.timestamps, float64(end+2))
+	if low >= high {
+		return []int32{}, true
+	}
+
+	return ix.byTime[low:high], true
+}
+
+// hostPositions returns the positions of the connections with either host at one of the
+// addresses listed in value. ok is false when value is empty, invalid, or lists a prefix
+// wider than one address, which the index can't look up.
+func (ix *connectionIndex) hostPositions(value string) ([]int32, bool) {
+	prefixes, err := connfilter.ParseHostPrefixes(value)
+	if err != nil || len(prefixes) == 0 {
+		return nil, false
+	}
+
+	var positions []int32
+	for _, prefix := range prefixes {
+		if !prefix.IsSingleIP() {
+			return nil, false
+		}
+		positions = append(positions, ix.hosts[prefix.Addr()]...)
+	}
+	if len(prefixes) > 1 {
+		slices.Sort(positions)
+		positions = slices.Compact(positions)
+	}
+
+	return positions, true
+}
+
+// connectionIndex returns the dataset's index, or nil until warmCaches built it.
+func (f *FileData) connectionIndex() *connectionIndex {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	return f.index
+}
+
+// buildIndex indexes the dataset's connections, unless that was already done.
+func (f *FileData) buildIndex() {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	if f.index == nil {
+		f.index = buildConnectionIndex(f.Connections)
+	}
+}
+
+// filterFile applies the query filters to a dataset's connections, narrowed down with its
+// index first when it has one. A nil dataset has no connections.
+func (a *API) filterFile(fileData *FileData, query url.Values) ([]models.Connection, error) {
+	if fileData == nil {
+		return a.filterConnections([]models.Connection{}, query)
+	}
+
+	connections := fileData.Connections
+	if index := fileData.connectionIndex(); index != nil {
+		if positions, ok := index.candidates(query); ok {
+			connections = make([]models.Connection, len(positions))
+			for i, position := range positions {
+				connections[i] = fileData.Connections[position]
+			}
+		}
+	}
+
+	return a.filterConnections(connections, query)
+}
+
+// filterCurrentConnections applies the query filters to the connections of the request's
+// current file.
+func (a *API) filterCurrentConnections(r *http.Request, query url.Values) ([]models.Connection, error) {
+	return a.filterFile(a.files[a.currentDataset(r)], query)
+}
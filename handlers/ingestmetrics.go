@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"log"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"zeek-viz/connstats"
+)
+
+const (
+	heapMetric     = "/memory/classes/heap/objects:bytes" // Live and not yet swept heap objects
+	memorySampling = 10 * time.Millisecond                // Heap sampling interval while parsing
+)
+
+// IngestMetrics reports where the time of an upload went: receiving the body (client and
+// network) versus parsing it (server), plus the parser's memory footprint.
+type IngestMetrics struct {
+	ReceiveMs      float64 `json:"receive_ms"`       //nolint:tagliatelle // API consistency
+	ParseMs        float64 `json:"parse_ms"`         //nolint:tagliatelle // API consistency
+	Lines          int     `json:"lines"`            // Non-blank lines read
+	LinesPerSec    float64 `json:"lines_per_sec"`    //nolint:tagliatelle // API consistency
+	BytesPerSec    float64 `json:"bytes_per_sec"`    //nolint:tagliatelle // API consistency
+	PeakHeapDelta  int64   `json:"peak_heap_delta"`  //nolint:tagliatelle // API consistency
+	HeapDeltaAfter int64   `json:"heap_delta_after"` //nolint:tagliatelle // API consistency
+}
+
+// ingestMeasurement samples heap usage in the background while an upload is parsed.
+type ingestMeasurement struct {
+	start    time.Time
+	baseHeap int64
+	peakHeap int64
+	mu       sync.Mutex
+	done     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// startIngestMeasurement begins timing a parse and sampling the heap.
+func startIngestMeasurement() *ingestMeasurement {
+	measurement := &ingestMeasurement{start: time.Now(), done: make(chan struct{})}
+	measurement.baseHeap = heapBytes()
+	measurement.peakHeap = measurement.baseHeap
+
+	measurement.stopped.Add(1)
+	go func() {
+		defer measurement.stopped.Done()
+
+		ticker := time.NewTicker(memorySampling)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-measurement.done:
+				return
+			case <-ticker.C:
+				measurement.sample()
+			}
+		}
+	}()
+
+	return measurement
+}
+
+// sample records the current heap size if it is a new peak.
+func (m *ingestMeasurement) sample() {
+	heap := heapBytes()
+
+	m.mu.Lock()
+	m.peakHeap = max(m.peakHeap, heap)
+	m.mu.Unlock()
+}
+
+// stop ends the measurement of parsing lines and size bytes. ReceiveMs is left for the
+// caller, which knows how long receiving the upload took.
+func (m *ingestMeasurement) stop(lines int, size int64) *IngestMetrics {
+	elapsed := time.Since(m.start)
+	m.sample()
+	close(m.done)
+	m.stopped.Wait()
+
+	seconds := max(elapsed.Seconds(), time.Microsecond.Seconds()) // Avoid dividing by zero for tiny files
+	after := heapBytes()
+
+	return &IngestMetrics{
+		ParseMs:        durationMs(elapsed),
+		Lines:          lines,
+		LinesPerSec:    float64(lines) / seconds,
+		BytesPerSec:    float64(size) / seconds,
+		PeakHeapDelta:  m.peakHeap - m.baseHeap,
+		HeapDeltaAfter: after - m.baseHeap,
+	}
+}
+
+// log writes the metrics of an ingested file to the server log.
+func (m *IngestMetrics) log(filename string) {
+	log.Printf("Ingested %s: received in %.1fms, parsed %d lines in %.1fms (%.0f lines/s, %s/s), peak heap +%s",
+		filename, m.ReceiveMs, m.Lines, m.ParseMs, m.LinesPerSec,
+		connstats.HumanizeBytes(m.BytesPerSec), connstats.HumanizeBytes(float64(max(m.PeakHeapDelta, 0))))
+}
+
+// heapBytes returns the current size of heap objects.
+func heapBytes() int64 {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return int64(sample[0].Value.Uint64()) //nolint:gosec // Heap sizes fit in int64
+}
+
+// durationMs converts a duration to fractional milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
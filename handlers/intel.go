@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	maxIntelSize       = 20 << 20 // 20MB, larger IOC lists are rejected
+	maxIntelIndicators = 1000000  // Indicators kept across all sources
+	maxIntelHosts      = 100      // Matching hosts listed in intel responses
+	defaultIntelSource = "upload" // Source name of lists uploaded without one
+	intelFormatText    = "text"   // One indicator per line, optionally with other columns
+	intelFormatCSV     = "csv"    // Same as text; a header row names a description column
+	intelFormatSTIX    = "stix"   // STIX 2.x JSON bundle
+)
+
+var (
+	errIntelFormat   = errors.New("format must be auto, text, csv, or stix")
+	errIntelEmpty    = errors.New("no IP or CIDR indicators found")
+	errIntelTooMany  = fmt.Errorf("more than %d indicators", maxIntelIndicators)
+	errIntelSTIX     = errors.New("invalid STIX bundle")
+	errIntelNotFound = errors.New("intel source not found")
+)
+
+// IntelSource is an uploaded IOC list.
+type IntelSource struct {
+	Name       string `json:"name"`
+	Format     string `json:"format"`
+	Indicators int    `json:"indicators"`
+	Skipped    int    `json:"skipped"`  // Lines or objects without an IP or CIDR indicator
+	AddedAt    int64  `json:"added_at"` //nolint:tagliatelle // API consistency
+}
+
+// IntelHostMatch is a host of the current dataset matching an indicator.
+type IntelHostMatch struct {
+	models.Threat
+
+	Connections int `json:"connections"`
+}
+
+// intelIndicator is an IP address or CIDR prefix from an IOC list.
+type intelIndicator struct {
+	prefix      netip.Prefix
+	source      string
+	description string
+}
+
+// threatIntel holds the uploaded indicators, indexed by prefix length so a host is matched
+// with one map lookup per distinct length, most specific first.
+type threatIntel struct {
+	sources    []IntelSource
+	indicators []intelIndicator
+	byLength   map[int]map[netip.Prefix]intelIndicator
+	lengths    []int
+}
+
+// UploadIntel adds the IOC list in the request body as the source named by the source
+// parameter, replacing an earlier list of that name, and reports its matches in the current
+// dataset. Lists are plain text or CSV with one IP address or CIDR prefix per line (extra
+// columns, # comments, and defanged addresses such as 192.0.2[.]1 are accepted) or STIX 2.x
+// bundles of ipv4-addr/ipv6-addr indicators; format picks one instead of detecting it.
+func (a *API) UploadIntel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIntelSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read IOC list: %v", err), http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	source := IntelSource{Name: strings.TrimSpace(query.Get("source")), Format: query.Get("format"), AddedAt: time.Now().Unix()}
+	if source.Name == "" {
+		source.Name = defaultIntelSource
+	}
+	indicators, err := parseIntel(data, &source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	intel := a.intel.without(source.Name)
+	if len(intel.indicators)+len(indicators) > maxIntelIndicators {
+		http.Error(w, errIntelTooMany.Error(), http.StatusRequestEntityTooLarge)
+
+		return
+	}
+	a.setIntel(intel.with(source, indicators))
+	log.Printf("Loaded %d indicators from %s (%d skipped)", source.Indicators, source.Name, source.Skipped)
+
+	a.writeIntel(w, r, map[string]any{"source": source})
+}
+
+// GetIntel lists the IOC sources and the hosts of the current dataset matching them.
+func (a *API) GetIntel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	a.writeIntel(w, r, map[string]any{})
+}
+
+// DeleteIntel removes the source named by the source parameter, or all sources without one.
+func (a *API) DeleteIntel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.URL.Query().Get("source")
+	switch {
+	case name == "":
+		a.setIntel(nil)
+	case a.intel == nil || !slices.ContainsFunc(a.intel.sources, func(source IntelSource) bool { return source.Name == name }):
+		http.Error(w, errIntelNotFound.Error(), http.StatusNotFound)
+
+		return
+	default:
+		a.setIntel(a.intel.without(name))
+	}
+
+	a.writeIntel(w, r, map[string]any{"success": true})
+}
+
+// writeIntel adds the sources and current matches to response and encodes it.
+func (a *API) writeIntel(w http.ResponseWriter, r *http.Request, response map[string]any) {
+	sources, total := []IntelSource{}, 0
+	if a.intel != nil {
+		sources, total = a.intel.sources, len(a.intel.indicators)
+	}
+	hosts, connections := a.intel.matchConnections(a.getCurrentConnections(r))
+
+	response["sources"] = sources
+	response["indicators"] = total
+	response["matches"] = map[string]any{
+		"connections": connections,
+		"hosts":       hosts[:min(len(hosts), maxIntelHosts)],
+		"total_hosts": len(hosts),
+		"truncated":   len(hosts) > maxIntelHosts,
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode intel: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// setIntel replaces the indicators and invalidates cached responses annotated with the
+// previous ones.
+func (a *API) setIntel(intel *threatIntel) {
+	if intel != nil && len(intel.sources) == 0 {
+		intel = nil
+	}
+	a.intel = intel
+	a.settingsVersion = time.Now().UnixNano()
+}
+
+// parseIntel extracts the indicators of an IOC list and records the format and counts in source.
+func parseIntel(data []byte, source *IntelSource) ([]intelIndicator, error) {
+	if source.Format == "" || source.Format == "auto" {
+		source.Format = intelFormatText
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			source.Format = intelFormatSTIX
+		}
+	}
+
+	var indicators []intelIndicator
+	var err error
+	switch source.Format {
+	case intelFormatText, intelFormatCSV:
+		indicators, source.Skipped = parseIntelLines(string(data), source.Name)
+	case intelFormatSTIX:
+		indicators, source.Skipped, err = parseIntelSTIX(data, source.Name)
+	default:
+		return nil, errIntelFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(indicators) == 0 {
+		return nil, errIntelEmpty
+	}
+	source.Indicators = len(indicators)
+
+	return indicators, nil
+}
+
+// parseIntelLines reads one indicator per line: the first column holding an IP address or
+// CIDR prefix. Columns are comma-, semicolon-, or tab-separated, or split at spaces in lines
+// without those. A first line without one is a
+// header, whose description, comment, or threat column describes the indicators.
+func parseIntelLines(text, source string) ([]intelIndicator, int) {
+	var indicators []intelIndicator
+	skipped, descriptionColumn := 0, -1
+	for number, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		columns := strings.Fields(line)
+		if strings.ContainsAny(line, ",;\t") {
+			columns = strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ';' || r == '\t' })
+		}
+		for i := range columns {
+			columns[i] = strings.Trim(strings.TrimSpace(columns[i]), `"'`)
+		}
+
+		indicator, found := intelColumn(columns)
+		switch {
+		case found:
+			indicator.source = source
+			if descriptionColumn >= 0 && descriptionColumn < len(columns) {
+				indicator.description = columns[descriptionColumn]
+			}
+			indicators = append(indicators, indicator)
+		case number == 0:
+			descriptionColumn = slices.IndexFunc(columns, func(column string) bool {
+				return slices.Contains([]string{"description", "comment", "note", "threat", "threat_type"}, strings.ToLower(column))
+			})
+		default:
+			skipped++
+		}
+	}
+
+	return indicators, skipped
+}
+
+// intelColumn returns the first column holding an IP address or CIDR prefix.
+func intelColumn(columns []string) (intelIndicator, bool) {
+	for _, column := range columns {
+		prefix, err := parseWatchlistValue(strings.ReplaceAll(column, "[.]", "."))
+		if err == nil {
+			return intelIndicator{prefix: prefix.Masked()}, true
+		}
+	}
+
+	return intelIndicator{}, false
+}
+
+// parseIntelSTIX reads the IP indicators of a STIX 2.x bundle: indicator objects with
+// ipv4-addr or ipv6-addr value patterns, and ipv4-addr/ipv6-addr observables.
+func parseIntelSTIX(data []byte, source string) ([]intelIndicator, int, error) {
+	var bundle struct {
+		Objects []struct {
+			Type        string `json:"type"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Pattern     string `json:"pattern"`
+			Value       string `json:"value"`
+		} `json:"objects"`
+	}
+	err := json.Unmarshal(data, &bundle)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errIntelSTIX, err)
+	}
+
+	addressPattern := regexp.MustCompile(`ipv[46]-addr:value\s*=\s*'([^']+)'`)
+	var indicators []intelIndicator
+	skipped := 0
+	for _, object := range bundle.Objects {
+		var values []string
+		switch object.Type {
+		case "indicator":
+			for _, match := range addressPattern.FindAllStringSubmatch(object.Pattern, -1) {
+				values = append(values, match[1])
+			}
+		case "ipv4-addr", "ipv6-addr":
+			values = append(values, object.Value)
+		default:
+			continue // Identities, relationships, and other objects aren't indicators
+		}
+
+		description := object.Name
+		if description == "" {
+			description = object.Description
+		}
+		before := len(indicators)
+		for _, value := range values {
+			if indicator, found := intelColumn([]string{value}); found {
+				indicator.source, indicator.description = source, description
+				indicators = append(indicators, indicator)
+			}
+		}
+		if len(indicators) == before {
+			skipped++
+		}
+	}
+
+	return indicators, skipped, nil
+}
+
+// with returns the intel extended by a source and its indicators.
+func (t *threatIntel) with(source IntelSource, indicators []intelIndicator) *threatIntel {
+	intel := &threatIntel{sources: append(slices.Clone(t.sources), source)}
+	sort.Slice(intel.sources, func(i, j int) bool {
+		return intel.sources[i].Name < intel.sources[j].Name
+	})
+	intel.index(append(slices.Clone(t.indicators), indicators...))
+
+	return intel
+}
+
+// without returns the intel without the named source. A nil intel has no sources.
+func (t *threatIntel) without(name string) *threatIntel {
+	intel := &threatIntel{}
+	if t == nil {
+		intel.index(nil)
+
+		return intel
+	}
+
+	intel.sources = slices.DeleteFunc(slices.Clone(t.sources), func(source IntelSource) bool {
+		return source.Name == name
+	})
+	intel.index(slices.DeleteFunc(slices.Clone(t.indicators), func(indicator intelIndicator) bool {
+		return indicator.source == name
+	}))
+
+	return intel
+}
+
+// index builds the lookup maps of the indicators. The first indicator of a prefix wins.
+func (t *threatIntel) index(indicators []intelIndicator) {
+	t.indicators = indicators
+	t.byLength = make(map[int]map[netip.Prefix]intelIndicator)
+	for _, indicator := range indicators {
+		bits := indicator.prefix.Bits()
+		if t.byLength[bits] == nil {
+			t.byLength[bits] = make(map[netip.Prefix]intelIndicator)
+			t.lengths = append(t.lengths, bits)
+		}
+		if _, exists := t.byLength[bits][indicator.prefix]; !exists {
+			t.byLength[bits][indicator.prefix] = indicator
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+}
+
+// match returns the most specific indicator containing host, or nil.
+func (t *threatIntel) match(host string) *models.Threat {
+	if t == nil {
+		return nil
+	}
+	addr, err := models.ParseHost(host)
+	if err != nil {
+		return nil
+	}
+
+	for _, bits := range t.lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue // Length of the other address family
+		}
+		if indicator, found := t.byLength[bits][prefix]; found {
+			return &models.Threat{
+				Indicator:   watchlistValue(indicator.prefix),
+				Source:      indicator.source,
+				Host:        host,
+				Description: indicator.description,
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchConnection returns the indicator matched by the connection's originator or, failing
+// that, its responder.
+func (t *threatIntel) matchConnection(conn *models.Connection) *models.Threat {
+	if threat := t.match(conn.OrigHost); threat != nil {
+		return threat
+	}
+
+	return t.match(conn.RespHost)
+}
+
+// matchConnections returns the matching hosts of the connections, busiest first, and the
+// number of matching connections.
+func (t *threatIntel) matchConnections(connections []models.Connection) ([]IntelHostMatch, int) {
+	hosts := []IntelHostMatch{}
+	if t == nil {
+		return hosts, 0
+	}
+
+	threats := make(map[string]*models.Threat)
+	counts := make(map[string]int)
+	matching := 0
+	for i := range connections {
+		matched := false
+		for _, host := range []string{connections[i].OrigHost, connections[i].RespHost} {
+			threat, seen := threats[host]
+			if !seen {
+				threat = t.match(host)
+				threats[host] = threat
+			}
+			if threat != nil {
+				counts[host]++
+				matched = true
+			}
+		}
+		if matched {
+			matching++
+		}
+	}
+
+	for host, count := range counts {
+		hosts = append(hosts, IntelHostMatch{Threat: *threats[host], Connections: count})
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Connections != hosts[j].Connections {
+			return hosts[i].Connections > hosts[j].Connections
+		}
+
+		return hosts[i].Host < hosts[j].Host
+	})
+
+	return hosts, matching
+}
+
+// applyThreatFilter keeps the connections with a host matching an indicator when threat is true.
+func (a *API) applyThreatFilter(connections []models.Connection, threat string) []models.Connection {
+	if threat != "true" {
+		return connections
+	}
+
+	var filtered []models.Connection
+	for i := range connections {
+		if a.intel.matchConnection(&connections[i]) != nil {
+			filtered = append(filtered, connections[i])
+		}
+	}
+
+	return filtered
+}
+
+// annotateGraphThreats flags the nodes and edges of the graph whose hosts match an indicator.
+// The nodes are copied first, since the unfiltered graph is shared by concurrent requests.
+func (a *API) annotateGraphThreats(graph *models.NetworkGraph) {
+	if a.intel == nil {
+		return
+	}
+
+	graph.Nodes = slices.Clone(graph.Nodes)
+	threats := make(map[string]*models.Threat, len(graph.Nodes))
+	for i := range graph.Nodes {
+		threats[graph.Nodes[i].ID] = a.intel.match(graph.Nodes[i].ID)
+		graph.Nodes[i].Threat = threats[graph.Nodes[i].ID]
+	}
+
+	graph.Edges = slices.Clone(graph.Edges)
+	for i := range graph.Edges {
+		edge := &graph.Edges[i]
+		edge.Threat = threats[edge.Source]
+		if edge.Threat == nil {
+			edge.Threat = threats[edge.Target]
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"zeek-viz/kafka"
+	"zeek-viz/models"
+	"zeek-viz/parse"
+)
+
+const (
+	kafkaRetryInterval  = 5 * time.Second  // Wait after a failed poll before polling again
+	kafkaCommitInterval = 5 * time.Second  // How often consumed offsets are committed to the group
+	kafkaCommitTimeout  = 10 * time.Second // Upper bound for the last commit at shutdown
+	kafkaConnLog        = "conn"           // Tag of conn.log records published with tag_json
+)
+
+// KafkaStatus reports a Kafka topic consumed in live mode.
+type KafkaStatus struct {
+	Topic        string `json:"topic"`
+	Group        string `json:"group"`
+	FileID       string `json:"file_id,omitempty"` //nolint:tagliatelle // API consistency
+	Partitions   int    `json:"partitions"`
+	Messages     int64  `json:"messages"`            // Messages consumed since consuming started
+	Connections  int64  `json:"connections"`         // Connections appended since consuming started
+	OtherLogs    int64  `json:"other_logs"`          //nolint:tagliatelle // Records of other Zeek logs published to the topic, ignored
+	SkippedLines int    `json:"skipped_lines"`       //nolint:tagliatelle // API consistency
+	Lag          int64  `json:"lag"`                 // Messages in the topic not consumed yet
+	LastRead     int64  `json:"last_read,omitempty"` //nolint:tagliatelle // API consistency
+	Committed    int64  `json:"committed,omitempty"` // When offsets were last committed to the group
+	Error        string `json:"error,omitempty"`     // Why the topic can't currently be consumed
+}
+
+// kafkaConsumer appends the conn.log records published to a topic to a live dataset. Its
+// status is read by GetTails while run updates it.
+type kafkaConsumer struct {
+	source string
+
+	// Only used by run
+	consumer   *kafka.Consumer
+	parser     *parse.Parser
+	lineNumber int
+
+	mu     sync.Mutex
+	status KafkaStatus
+}
+
+// ConsumeKafka reads the conn.log records Zeek publishes to a Kafka topic until ctx is done,
+// appending them to a live dataset named after the topic. Offsets are committed to group, so
+// a restarted server continues where it stopped; on first use it starts with the messages
+// published from now on, or with the oldest one kept when fromStart is set. A message is
+// only fetched once the ones before it are ingested, so a server that can't keep up falls
+// behind the topic, reported as lag, rather than buffering it.
+func (a *API) ConsumeKafka(ctx context.Context, brokers []string, topic, group string, fromStart bool) error {
+	consumer, err := kafka.NewConsumer(ctx, brokers, topic, group, fromStart)
+	if err != nil {
+		return err //nolint:wrapcheck // Errors of the kafka package name the topic
+	}
+
+	k := &kafkaConsumer{
+		source:   "kafka:" + topic,
+		consumer: consumer,
+		parser:   parse.NewParser(false),
+		status:   KafkaStatus{Topic: topic, Group: group, Partitions: consumer.Partitions()},
+	}
+
+	a.tailMu.Lock()
+	a.kafkaConsumers = append(a.kafkaConsumers, k)
+	a.tailMu.Unlock()
+
+	log.Printf("Consuming Kafka topic %s (%d partitions) as group %s", topic, consumer.Partitions(), group)
+	a.kafkaRunning.Go(func() { k.run(ctx, a) })
+
+	return nil
+}
+
+// WaitKafka waits until the Kafka consumers stopped by the end of their context committed
+// the offsets they ingested, so a restarted server doesn't read those messages again.
+func (a *API) WaitKafka() {
+	a.kafkaRunning.Wait()
+}
+
+// run polls the topic until ctx is done, committing the offsets ingested every
+// kafkaCommitInterval and when it stops.
+func (k *kafkaConsumer) run(ctx context.Context, a *API) {
+	defer k.consumer.Close()
+
+	lastCommit := time.Now()
+	var commitErr error
+	for {
+		messages, err := k.consumer.Poll(ctx)
+		if ctx.Err() != nil {
+			commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), kafkaCommitTimeout)
+			_ = k.commit(commitCtx) // Logged by commit
+			cancel()
+			log.Printf("Stopped consuming %s", k.source)
+
+			return
+		}
+
+		connections, others := k.parse(messages)
+		if len(connections) > 0 {
+			fileID := a.IngestLive(k.source, connections)
+
+			k.mu.Lock()
+			k.status.FileID = fileID
+			k.status.Connections += int64(len(connections))
+			k.mu.Unlock()
+		}
+		k.mu.Lock()
+		k.status.Messages += int64(len(messages))
+		k.status.OtherLogs += others
+		k.status.Partitions = k.consumer.Partitions()
+		k.status.Lag = k.consumer.Lag()
+		if len(messages) > 0 {
+			k.status.LastRead = time.Now().Unix()
+		}
+		k.mu.Unlock()
+
+		if time.Since(lastCommit) >= kafkaCommitInterval {
+			commitErr = k.commit(ctx)
+			lastCommit = time.Now()
+		}
+		k.setError(errors.Join(err, commitErr))
+		if err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(kafkaRetryInterval):
+		}
+	}
+}
+
+// commit records the offsets ingested so far for the group.
+func (k *kafkaConsumer) commit(ctx context.Context) error {
+	err := k.consumer.Commit(ctx)
+	if err != nil {
+		log.Printf("Failed to commit offsets of %s: %v", k.source, err)
+
+		return err //nolint:wrapcheck // Errors of the kafka package name the group
+	}
+
+	k.mu.Lock()
+	k.status.Committed = time.Now().Unix()
+	k.mu.Unlock()
+
+	return nil
+}
+
+// parse returns the connections of the messages, and the number of records of other logs.
+// A message holds one or more JSON records; those published with the zeek-kafka plugin's
+// tag_json are unwrapped: {"conn": {...}} is read as conn.log and other tags such as
+// {"dns": {...}} are counted and dropped.
+func (k *kafkaConsumer) parse(messages []kafka.Message) ([]models.Connection, int64) {
+	var others int64
+	for _, message := range messages {
+		for line := range strings.Lines(string(message.Value)) {
+			line = strings.TrimRight(line, "\r\n")
+			k.lineNumber++
+			if len(line) > parse.MaxLineLength {
+				_ = k.parser.LongLine(k.lineNumber) // Only fails in strict mode
+
+				continue
+			}
+			tag, record, tagged := untagRecord(line)
+			switch {
+			case !tagged:
+				_ = k.parser.Line(k.lineNumber, line) // Only fails in strict mode
+			case tag == kafkaConnLog:
+				_ = k.parser.Line(k.lineNumber, record) // Only fails in strict mode
+			default:
+				others++
+			}
+		}
+	}
+
+	connections := k.parser.Take() // The live dataset keeps its own statistics
+
+	k.mu.Lock()
+	k.status.SkippedLines = k.parser.Report().SkippedLines
+	k.mu.Unlock()
+
+	return connections, others
+}
+
+// untagRecord splits a record of the form {"tag": {...}}, as zeek-kafka publishes with
+// tag_json, into its tag and the record it wraps. ok is false for other lines, such as
+// untagged records, which have more than one field.
+func untagRecord(line string) (string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, `{"`) || !strings.Contains(trimmed, `":{`) {
+		return "", "", false
+	}
+
+	var wrapper map[string]json.RawMessage
+	err := json.Unmarshal([]byte(trimmed), &wrapper)
+	if err != nil || len(wrapper) != 1 {
+		return "", "", false
+	}
+	for tag, record := range wrapper {
+		if !bytes.HasPrefix(record, []byte("{")) {
+			return "", "", false
+		}
+
+		return tag, string(record), true
+	}
+
+	return "", "", false
+}
+
+// setError records why the topic can't be consumed, or clears it.
+func (k *kafkaConsumer) setError(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.status.Error = ""
+	if err != nil {
+		k.status.Error = err.Error()
+	}
+}
+
+// snapshot returns the current status.
+func (k *kafkaConsumer) snapshot() KafkaStatus {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.status
+}
@@ -0,0 +1,513 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"net/netip"
+	"slices"
+
+	"zeek-viz/models"
+)
+
+const (
+	layoutForce        = "force"        // Force-directed layout
+	layoutCircular     = "circular"     // Nodes on a circle
+	layoutHierarchical = "hierarchical" // Nodes boxed by subnet, local networks first
+
+	layoutEdgeLength     = 100.0   // Room of a node, as the link distance of the UI's simulation
+	layoutNodeSpacing    = 40.0    // Distance of neighboring nodes on a circle or in a subnet box
+	layoutMargin         = 50.0    // Space between the nodes and the edge of the layout box
+	layoutGroupPadding   = 30.0    // Space around the nodes of a subnet box, room for its label
+	layoutMinRowWidth    = 1200.0  // Width rows of subnet boxes may always reach before wrapping
+	layoutWork           = 200_000 // Node updates a force-directed layout may take
+	minLayoutIterations  = 50      // Steps of the force-directed layout of the largest graphs
+	maxLayoutIterations  = 300     // Steps of the force-directed layout of small graphs
+	layoutRepulsion      = 2.0     // ForceAtlas2 scaling of the repulsion
+	layoutSmallRepulsion = 10.0    // Scaling for graphs below layoutSmallGraph nodes, as in Gephi
+	layoutSmallGraph     = 100     // Nodes of a graph below which the small-graph repulsion applies
+	layoutGravity        = 1.0     // ForceAtlas2 gravity
+	layoutMaxSpeedup     = 1.5     // Growth of the global speed per step
+	layoutMaxStep        = 10.0    // Longest distance a node moves in a step, before scaling
+	layoutTheta          = 1.2     // Barnes-Hut accuracy: cells this much smaller than their distance act as one node
+	layoutTreeDepth      = 32      // Depth of the quadtree at which nodes on the same spot share a leaf
+	otherLayoutGroup     = "other" // Subnet box of nodes that aren't addresses
+
+	subnetLayoutIPv4 = 24 // Prefix length hosts are boxed by
+	subnetLayoutIPv6 = 64
+	groupLayoutIPv4  = 16 // Prefix length subnet nodes are boxed by
+	groupLayoutIPv6  = 48
+)
+
+var errInvalidLayout = errors.New("layout must be force, circular, or hierarchical")
+
+// layoutVector is a position or displacement in the layout plane.
+type layoutVector struct {
+	x, y float64
+}
+
+// parseGraphLayout reads the layout parameter, "" when none is asked for.
+func parseGraphLayout(value string) (string, error) {
+	switch value {
+	case "", layoutForce, layoutCircular, layoutHierarchical:
+		return value, nil
+	default:
+		return "", errInvalidLayout
+	}
+}
+
+// layoutGraph positions the graph's nodes with the algorithm, so clients can draw large graphs
+// without simulating forces themselves. The nodes are copied first, since the unfiltered
+// graph is shared by concurrent requests. Positions are deterministic, so cached and repeated
+// responses place every node in the same spot.
+func layoutGraph(graph *models.NetworkGraph, algorithm string) {
+	if algorithm == "" {
+		return
+	}
+	graph.Nodes = slices.Clone(graph.Nodes)
+
+	var positions []layoutVector
+	layout := &models.GraphLayout{Algorithm: algorithm}
+	switch algorithm {
+	case layoutCircular:
+		positions = circularLayout(len(graph.Nodes))
+	case layoutHierarchical:
+		positions, layout.Groups = hierarchicalLayout(graph.Nodes)
+	default:
+		positions, layout.Iterations = forceLayout(graph.Nodes, graph.Edges)
+	}
+
+	layout.Width, layout.Height = fitLayout(positions, layout.Groups)
+	for i := range graph.Nodes {
+		graph.Nodes[i].X, graph.Nodes[i].Y = positions[i].x, positions[i].y
+	}
+	graph.Layout = layout
+}
+
+// forceLayout places nodes with ForceAtlas2: edges pull their nodes together linearly, nodes
+// push each other apart in proportion to their degrees, which spreads hubs and the hosts only
+// they talk to, and gravity keeps disconnected parts close. Repulsion is approximated with a
+// Barnes-Hut quadtree, so a step takes O(n log n) time; large graphs take fewer steps. Nodes
+// start on a spiral with the most connected ones in the center, and the result is scaled so
+// every node gets about layoutEdgeLength squared of room.
+func forceLayout(nodes []models.Node, edges []models.Edge) ([]layoutVector, int) {
+	positions := make([]layoutVector, len(nodes))
+	if len(nodes) == 0 {
+		return positions, 0
+	}
+
+	index := make(map[string]int, len(nodes))
+	for i := range nodes {
+		index[nodes[i].ID] = i
+	}
+	type pair struct{ source, target int }
+	var links []pair
+	seen := make(map[pair]bool, len(edges))
+	masses := make([]float64, len(nodes))
+	for i := range masses {
+		masses[i] = 1 // Degree plus one
+	}
+	for _, edge := range edges {
+		link := pair{index[edge.Source], index[edge.Target]}
+		if link.source > link.target {
+			link.source, link.target = link.target, link.source
+		}
+		if link.source == link.target || seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		masses[link.source]++
+		masses[link.target]++
+	}
+
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(x, y int) int {
+		return cmp.Or(cmp.Compare(masses[y], masses[x]), cmp.Compare(nodes[x].ID, nodes[y].ID))
+	})
+	goldenAngle := math.Pi * (3 - math.Sqrt(5)) //nolint:mnd // Phyllotaxis spiral
+	for rank, i := range order {
+		radius := math.Sqrt(float64(rank) + 0.5) //nolint:mnd // Even spacing
+		angle := float64(rank) * goldenAngle
+		positions[i] = layoutVector{radius * math.Cos(angle), radius * math.Sin(angle)}
+	}
+
+	repulsion := layoutRepulsion
+	if len(nodes) < layoutSmallGraph {
+		repulsion = layoutSmallRepulsion
+	}
+	iterations := min(max(layoutWork/len(nodes), minLayoutIterations), maxLayoutIterations)
+	forces := make([]layoutVector, len(nodes))
+	previous := make([]layoutVector, len(nodes))
+	speed := 1.0
+	for range iterations {
+		forces, previous = previous, forces
+		clear(forces)
+
+		tree := newLayoutTree(positions, masses)
+		for i := range positions {
+			tree.repel(i, positions, masses, repulsion, &forces[i])
+			distance := math.Hypot(positions[i].x, positions[i].y)
+			if distance > 0 {
+				forces[i].x -= layoutGravity * masses[i] * positions[i].x / distance
+				forces[i].y -= layoutGravity * masses[i] * positions[i].y / distance
+			}
+		}
+		for _, link := range links {
+			dx := positions[link.source].x - positions[link.target].x
+			dy := positions[link.source].y - positions[link.target].y
+			forces[link.source].x -= dx
+			forces[link.source].y -= dy
+			forces[link.target].x += dx
+			forces[link.target].y += dy
+		}
+
+		speed = moveNodes(positions, forces, previous, masses, speed)
+	}
+
+	scaleLayout(positions)
+
+	return positions, iterations
+}
+
+// moveNodes applies a step of forces with ForceAtlas2's adaptive speed: nodes whose force
+// keeps changing direction (swinging) slow down, and the global speed rises while the graph
+// moves coherently. It returns the speed of the next step.
+func moveNodes(positions, forces, previous []layoutVector, masses []float64, speed float64) float64 {
+	swinging, traction := 0.0, 0.0
+	for i := range forces {
+		swinging += masses[i] * math.Hypot(forces[i].x-previous[i].x, forces[i].y-previous[i].y)
+		traction += masses[i] * math.Hypot(forces[i].x+previous[i].x, forces[i].y+previous[i].y) / 2 //nolint:mnd // Mean
+	}
+	if swinging > 0 {
+		speed = math.Min(traction/swinging, layoutMaxSpeedup*speed)
+	}
+
+	for i := range positions {
+		force := math.Hypot(forces[i].x, forces[i].y)
+		swing := masses[i] * math.Hypot(forces[i].x-previous[i].x, forces[i].y-previous[i].y)
+		factor := speed / (1 + math.Sqrt(speed*swing))
+		if force > 0 {
+			factor = math.Min(factor, layoutMaxStep/force)
+		}
+		positions[i].x += forces[i].x * factor
+		positions[i].y += forces[i].y * factor
+	}
+
+	return speed
+}
+
+// scaleLayout scales positions around the origin so the nodes cover about layoutEdgeLength
+// squared each, the room the UI's simulation gives them.
+func scaleLayout(positions []layoutVector) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, position := range positions {
+		minX, maxX = math.Min(minX, position.x), math.Max(maxX, position.x)
+		minY, maxY = math.Min(minY, position.y), math.Max(maxY, position.y)
+	}
+	area := (maxX - minX) * (maxY - minY)
+	if area <= 0 {
+		return
+	}
+
+	scale := layoutEdgeLength * math.Sqrt(float64(len(positions))/area)
+	for i := range positions {
+		positions[i].x *= scale
+		positions[i].y *= scale
+	}
+}
+
+// layoutTree is a Barnes-Hut quadtree over node positions. Each cell holds the total mass and
+// center of mass of the nodes in it, so distant cells repel like a single node.
+type layoutTree struct {
+	cells []layoutCell
+}
+
+// layoutCell is a square of the quadtree: a leaf holding one node (or several on the same
+// spot, at the depth limit), or four quadrants.
+type layoutCell struct {
+	centerX, centerY float64 // Center of the square
+	size             float64 // Side length
+	mass             float64
+	massX, massY     float64 // Center of mass
+	children         [4]int  // Indices of the quadrants, 0 when the cell is a leaf
+	node             int     // Node of a leaf, -1 when empty
+}
+
+// newLayoutTree builds the quadtree of the positions.
+func newLayoutTree(positions []layoutVector, masses []float64) *layoutTree {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, position := range positions {
+		minX, maxX = math.Min(minX, position.x), math.Max(maxX, position.x)
+		minY, maxY = math.Min(minY, position.y), math.Max(maxY, position.y)
+	}
+	size := math.Max(math.Max(maxX-minX, maxY-minY), 1)
+
+	tree := &layoutTree{cells: make([]layoutCell, 1, 2*len(positions))}                                      //nolint:mnd // Typical cell count
+	tree.cells[0] = layoutCell{centerX: (minX + maxX) / 2, centerY: (minY + maxY) / 2, size: size, node: -1} //nolint:mnd // Center
+	for i := range positions {
+		tree.insert(0, i, positions, masses, 0)
+	}
+
+	return tree
+}
+
+// insert adds node i to the cell at index, at the given depth.
+func (t *layoutTree) insert(index, i int, positions []layoutVector, masses []float64, depth int) {
+	for {
+		cell := &t.cells[index]
+		total := cell.mass + masses[i]
+		cell.massX = (cell.massX*cell.mass + positions[i].x*masses[i]) / total
+		cell.massY = (cell.massY*cell.mass + positions[i].y*masses[i]) / total
+		cell.mass = total
+
+		switch {
+		case cell.children[0] != 0:
+			index = t.quadrant(index, positions[i])
+			depth++
+		case cell.node < 0 && cell.mass == masses[i]:
+			cell.node = i
+
+			return
+		case depth >= layoutTreeDepth:
+			return // Nodes on the same spot share the leaf's mass
+		default:
+			resident := cell.node
+			t.split(index)
+			cell = &t.cells[index]
+			cell.node = -1
+			child := t.quadrant(index, positions[resident])
+			t.cells[child].mass = masses[resident]
+			t.cells[child].massX, t.cells[child].massY = positions[resident].x, positions[resident].y
+			t.cells[child].node = resident
+			index = t.quadrant(index, positions[i])
+			depth++
+		}
+	}
+}
+
+// split divides a leaf into four empty quadrants.
+func (t *layoutTree) split(index int) {
+	parent := t.cells[index]
+	quarter := parent.size / 4 //nolint:mnd // Half of a half
+	for q := range 4 {
+		offsetX, offsetY := -quarter, -quarter
+		if q&1 != 0 {
+			offsetX = quarter
+		}
+		if q&2 != 0 {
+			offsetY = quarter
+		}
+		t.cells[index].children[q] = len(t.cells)
+		t.cells = append(t.cells, layoutCell{
+			centerX: parent.centerX + offsetX, centerY: parent.centerY + offsetY, size: parent.size / 2, node: -1, //nolint:mnd // Half
+		})
+	}
+}
+
+// quadrant returns the child of a split cell that contains position.
+func (t *layoutTree) quadrant(index int, position layoutVector) int {
+	cell := &t.cells[index]
+	q := 0
+	if position.x >= cell.centerX {
+		q |= 1
+	}
+	if position.y >= cell.centerY {
+		q |= 2
+	}
+
+	return cell.children[q]
+}
+
+// repel adds the repulsion of all other nodes on node i to force: coefficient times the
+// product of the masses over the distance. Cells small for their distance act as one node.
+func (t *layoutTree) repel(i int, positions []layoutVector, masses []float64, coefficient float64, force *layoutVector) {
+	stack := []int{0}
+	for len(stack) > 0 {
+		cell := &t.cells[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if cell.mass == 0 || cell.node == i {
+			continue
+		}
+
+		dx, dy := positions[i].x-cell.massX, positions[i].y-cell.massY
+		distanceSquared := dx*dx + dy*dy
+		leaf := cell.children[0] == 0
+		if !leaf && cell.size*cell.size >= layoutTheta*layoutTheta*distanceSquared {
+			stack = append(stack, cell.children[:]...)
+
+			continue
+		}
+		if distanceSquared == 0 {
+			continue // Coincident nodes at the depth limit
+		}
+		factor := coefficient * masses[i] * cell.mass / distanceSquared // Along (dx, dy), of length d
+		force.x += dx * factor
+		force.y += dy * factor
+	}
+}
+
+// circularLayout places nodes on a circle in their order, spaced so they don't overlap.
+func circularLayout(count int) []layoutVector {
+	positions := make([]layoutVector, count)
+	radius := math.Max(2*layoutEdgeLength, float64(count)*layoutNodeSpacing/(2*math.Pi)) //nolint:mnd // Full circle
+	for i := range positions {
+		angle := 2 * math.Pi * float64(i) / float64(count) //nolint:mnd // Full circle
+		positions[i] = layoutVector{radius * math.Cos(angle), radius * math.Sin(angle)}
+	}
+
+	return positions
+}
+
+// hierarchicalLayout boxes hosts by subnet, /24 for IPv4 and /64 for IPv6 (subnet nodes by
+// /16 and /48), and arranges the boxes in rows in address order: local networks first, then
+// external ones. Hosts fill their box in address order.
+func hierarchicalLayout(nodes []models.Node) ([]layoutVector, []models.LayoutGroup) {
+	type group struct {
+		models.LayoutGroup
+
+		prefix  netip.Prefix
+		members []int
+	}
+	groups := map[string]*group{}
+	addresses := make([]netip.Addr, len(nodes))
+	for i := range nodes {
+		prefix, addr := layoutSubnet(&nodes[i])
+		addresses[i] = addr
+		id := otherLayoutGroup
+		if prefix.IsValid() {
+			id = prefix.String()
+		}
+		key := id
+		if nodes[i].IsLocal {
+			key = "local " + id
+		}
+		if groups[key] == nil {
+			groups[key] = &group{LayoutGroup: models.LayoutGroup{ID: id, IsLocal: nodes[i].IsLocal}, prefix: prefix}
+		}
+		groups[key].members = append(groups[key].members, i)
+	}
+
+	ordered := make([]*group, 0, len(groups))
+	area := 0.0
+	for _, g := range groups {
+		slices.SortFunc(g.members, func(x, y int) int {
+			return cmp.Or(addresses[x].Compare(addresses[y]), cmp.Compare(nodes[x].ID, nodes[y].ID))
+		})
+		columns := int(math.Ceil(math.Sqrt(float64(len(g.members)))))
+		rows := (len(g.members) + columns - 1) / columns
+		g.Nodes = len(g.members)
+		g.Width = float64(columns)*layoutNodeSpacing + 2*layoutGroupPadding //nolint:mnd // Both sides
+		g.Height = float64(rows)*layoutNodeSpacing + 2*layoutGroupPadding   //nolint:mnd // Both sides
+		area += g.Width * g.Height
+		ordered = append(ordered, g)
+	}
+	slices.SortFunc(ordered, func(x, y *group) int {
+		if x.IsLocal != y.IsLocal {
+			if x.IsLocal {
+				return -1
+			}
+
+			return 1
+		}
+		if x.prefix.IsValid() != y.prefix.IsValid() {
+			if x.prefix.IsValid() {
+				return -1
+			}
+
+			return 1
+		}
+
+		return cmp.Or(x.prefix.Addr().Compare(y.prefix.Addr()), cmp.Compare(x.prefix.Bits(), y.prefix.Bits()))
+	})
+
+	positions := make([]layoutVector, len(nodes))
+	result := make([]models.LayoutGroup, 0, len(ordered))
+	maxWidth := math.Max(layoutMinRowWidth, 2*math.Sqrt(area)) //nolint:mnd // About twice as wide as high
+	x, y, rowHeight := 0.0, 0.0, 0.0
+	for i, g := range ordered {
+		newTier := i > 0 && g.IsLocal != ordered[i-1].IsLocal
+		if newTier || (x > 0 && x+g.Width > maxWidth) {
+			x, y = 0, y+rowHeight+layoutNodeSpacing
+			if newTier {
+				y += layoutEdgeLength // Separate the external networks from the local ones
+			}
+			rowHeight = 0
+		}
+		g.X, g.Y = x, y
+		columns := int(math.Round((g.Width - 2*layoutGroupPadding) / layoutNodeSpacing)) //nolint:mnd // Both sides
+		for position, member := range g.members {
+			positions[member] = layoutVector{
+				x: g.X + layoutGroupPadding + (float64(position%columns)+0.5)*layoutNodeSpacing, //nolint:mnd // Cell center
+				y: g.Y + layoutGroupPadding + (float64(position/columns)+0.5)*layoutNodeSpacing, //nolint:mnd // Cell center
+			}
+		}
+		x += g.Width + layoutNodeSpacing
+		rowHeight = math.Max(rowHeight, g.Height)
+		result = append(result, g.LayoutGroup)
+	}
+
+	return positions, result
+}
+
+// layoutSubnet returns the subnet a node is boxed in by the hierarchical layout, and its
+// address; both are invalid for nodes that aren't addresses or subnets.
+func layoutSubnet(node *models.Node) (netip.Prefix, netip.Addr) {
+	if subnet, err := netip.ParsePrefix(node.ID); err == nil {
+		bits := groupLayoutIPv6
+		if subnet.Addr().Is4() {
+			bits = groupLayoutIPv4
+		}
+		parent, _ := subnet.Addr().Prefix(min(bits, subnet.Bits())) // Valid for any length up to the subnet's
+
+		return parent, subnet.Addr()
+	}
+
+	addr, err := models.ParseHost(node.ID)
+	if err != nil {
+		return netip.Prefix{}, netip.Addr{}
+	}
+	bits := subnetLayoutIPv6
+	if addr.Is4() {
+		bits = subnetLayoutIPv4
+	}
+	prefix, _ := addr.Prefix(bits) // Valid for addresses of the family
+
+	return prefix, addr
+}
+
+// fitLayout moves the positions and groups into a box starting at (0, 0) with a margin
+// around them, and returns the box's size.
+func fitLayout(positions []layoutVector, groups []models.LayoutGroup) (float64, float64) {
+	if len(positions) == 0 {
+		return 0, 0
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, position := range positions {
+		minX, maxX = math.Min(minX, position.x), math.Max(maxX, position.x)
+		minY, maxY = math.Min(minY, position.y), math.Max(maxY, position.y)
+	}
+	for _, group := range groups {
+		minX, maxX = math.Min(minX, group.X), math.Max(maxX, group.X+group.Width)
+		minY, maxY = math.Min(minY, group.Y), math.Max(maxY, group.Y+group.Height)
+	}
+
+	offsetX, offsetY := layoutMargin-minX, layoutMargin-minY
+	for i := range positions {
+		positions[i].x = math.Round(positions[i].x + offsetX)
+		positions[i].y = math.Round(positions[i].y + offsetY)
+	}
+	for i := range groups {
+		groups[i].X += offsetX
+		groups[i].Y += offsetY
+	}
+
+	return math.Round(maxX - minX + 2*layoutMargin), math.Round(maxY - minY + 2*layoutMargin) //nolint:mnd // Both sides
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	liveEventBuffer   = 16               // Deltas queued per event subscriber before it is disconnected
+	liveEventMaxConns = 500              // Connections sent with a delta; larger batches only report their count
+	liveKeepAlive     = 15 * time.Second // Interval of comments that keep proxies from closing idle event streams
+)
+
+// LiveDelta is pushed to /api/live/events subscribers when connections are appended to a
+// live dataset.
+type LiveDelta struct {
+	FileID      string              `json:"file_id"` //nolint:tagliatelle // API consistency
+	Source      string              `json:"source"`
+	Count       int                 `json:"count"`                 // Connections appended
+	Total       int                 `json:"total"`                 // Raw connections in the dataset afterwards
+	Connections []models.Connection `json:"connections,omitempty"` // Omitted for batches over liveEventMaxConns
+}
+
+// liveHub fans live deltas out to event stream subscribers.
+type liveHub struct {
+	mu          sync.Mutex
+	subscribers map[chan LiveDelta]bool
+	closed      bool // Shutting down; new subscribers are disconnected at once
+}
+
+// subscribe returns a channel receiving the deltas published from now on. It is closed when
+// the subscriber falls behind.
+func (h *liveHub) subscribe() chan LiveDelta {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan LiveDelta]bool)
+	}
+	events := make(chan LiveDelta, liveEventBuffer)
+	if h.closed {
+		close(events)
+
+		return events
+	}
+	h.subscribers[events] = true
+
+	return events
+}
+
+// unsubscribe stops delivering deltas to events.
+func (h *liveHub) unsubscribe(events chan LiveDelta) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[events] {
+		delete(h.subscribers, events)
+		close(events)
+	}
+}
+
+// publish delivers delta without blocking. Subscribers whose queue is full are disconnected;
+// browsers reconnect and reload the dataset.
+func (h *liveHub) publish(delta LiveDelta) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for events := range h.subscribers {
+		select {
+		case events <- delta:
+		default:
+			delete(h.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// close disconnects every subscriber and those subscribing later.
+func (h *liveHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for events := range h.subscribers {
+		delete(h.subscribers, events)
+		close(events)
+	}
+}
+
+// CloseLiveEvents ends the /api/live/events streams, which would otherwise keep a graceful
+// shutdown waiting until its timeout. Browsers reconnect once the server is back.
+func (a *API) CloseLiveEvents() {
+	a.liveEvents.close()
+}
+
+// GetLiveStats returns rolling 1m/5m/1h aggregates of live-ingested connections.
+// When no streaming source is running the response reports active=false.
+func (a *API) GetLiveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(a.live.Snapshot())
+	if err != nil {
+		log.Printf("Failed to encode live stats: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetLiveEvents streams a "connections" server-sent event for every batch of connections
+// appended to a live dataset, such as a followed log file, until the client disconnects.
+func (a *API) GetLiveEvents(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Time{}) // Not every ResponseWriter supports deadlines
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+
+	events := a.liveEvents.subscribe()
+	defer a.liveEvents.unsubscribe(events)
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	_, err := fmt.Fprint(w, ": connected\n\n")
+	for err == nil {
+		err = controller.Flush()
+		if err != nil {
+			break
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case delta, ok := <-events:
+			if !ok {
+				return // Fell behind or shutting down; the client reconnects
+			}
+			err = writeLiveEvent(w, delta)
+		}
+	}
+	log.Printf("Live event stream closed: %v", err)
+}
+
+// writeLiveEvent writes delta as a "connections" event.
+func writeLiveEvent(w http.ResponseWriter, delta LiveDelta) error {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("encoding live delta: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: connections\ndata: %s\n\n", data)
+	if err != nil {
+		return fmt.Errorf("writing live event: %w", err)
+	}
+
+	return nil
+}
+
+// GetTails lists the log files followed in live tail mode, the directories watched for
+// rotated logs, and the Kafka topics consumed.
+func (a *API) GetTails(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	a.tailMu.Lock()
+	tails := make([]TailStatus, 0, len(a.tails))
+	for _, t := range a.tails {
+		tails = append(tails, t.snapshot())
+	}
+	directories := make([]WatchStatus, 0, len(a.watchers))
+	for _, watcher := range a.watchers {
+		directories = append(directories, watcher.snapshot())
+	}
+	topics := make([]KafkaStatus, 0, len(a.kafkaConsumers))
+	for _, consumer := range a.kafkaConsumers {
+		topics = append(topics, consumer.snapshot())
+	}
+	a.tailMu.Unlock()
+
+	err := json.NewEncoder(w).Encode(map[string]any{"tails": tails, "directories": directories, "kafka": topics})
+	if err != nil {
+		log.Printf("Failed to encode tails: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
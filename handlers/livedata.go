@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const (
+	defaultLiveRetention = time.Hour         // Raw connections kept for live datasets
+	rollupBucketSec      = timelineBucketSec // Granularity of rolled-up history in seconds
+)
+
+// SetLiveRetention configures how long raw connections are kept in live datasets before
+// being rolled up into timeline buckets.
+func (a *API) SetLiveRetention(retention time.Duration) {
+	a.liveRetention = retention
+}
+
+// IngestLive appends streamed connections to the live dataset of the given source, creating
+// it (and making it current when nothing else is selected) on first use. The connections are
+// recorded in the rolling live statistics, raw data older than the retention window is rolled up,
+// and the batch is pushed to live event subscribers.
+func (a *API) IngestLive(source string, connections []models.Connection) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fileID := a.generateFileID("live:"+source, 0)
+
+	fileData, exists := a.files[fileID]
+	if !exists {
+		fileData = &FileData{
+			Filename:   source,
+			UploadTime: time.Now().Unix(),
+		}
+		fileData.setConnections(nil, models.NewConnectionStats())
+		a.files[fileID] = fileData
+
+		if a.currentFileID == "" {
+			a.currentFileID = fileID
+		}
+		log.Printf("Created live dataset %s for %s", fileID, source)
+	}
+
+	connstats.GuessServices(connections, a.servicePorts, nil)
+	fileData.AppendConnections(connections)
+	a.live.Record(connections)
+	a.metrics.connections.Add(float64(len(connections)), sourceLive)
+	a.requestEviction()
+
+	retention := a.liveRetention
+	if retention <= 0 {
+		retention = defaultLiveRetention
+	}
+	fileData.RollUp(retention)
+
+	delta := LiveDelta{FileID: fileID, Source: source, Count: len(connections), Total: len(fileData.Connections)}
+	if len(connections) <= liveEventMaxConns {
+		delta.Connections = connections
+	}
+	a.liveEvents.publish(delta)
+
+	return fileID
+}
+
+// isLiveDataset reports whether fileID is the live dataset of a stream, tailed log, or
+// watched directory. Callers must hold a.mu.
+func (a *API) isLiveDataset(fileID string) bool {
+	fileData := a.files[fileID]
+
+	return fileData != nil && fileID == a.generateFileID("live:"+fileData.Filename, 0)
+}
+
+// AppendConnections adds connections to the file, updating its statistics and dropping cached data.
+func (f *FileData) AppendConnections(connections []models.Connection) {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	if f.Stats == nil {
+		f.Stats = models.NewConnectionStats()
+	}
+	for i := range connections {
+		f.Stats.Add(&connections[i])
+	}
+
+	if f.spanKnown {
+		f.rawSpan = extendSpan(f.rawSpan, connections)
+	}
+	f.Connections = append(f.Connections, connections...)
+	f.memory += connectionsMemory(connections)
+	f.invalidateCaches()
+}
+
+// extendSpan returns the earliest and latest ts of span and the connections.
+func extendSpan(span [2]float64, connections []models.Connection) [2]float64 {
+	for i := range connections {
+		span[0] = min(span[0], connections[i].Timestamp)
+		span[1] = max(span[1], connections[i].Timestamp)
+	}
+
+	return span
+}
+
+// RollUp moves raw connections that started more than retention before the latest one into
+// pre-aggregated timeline buckets, bounding memory while keeping historical timeline queries
+// answerable. The cutoff follows the logs' own timestamps, so replayed or rotated logs keep
+// their last window raw like a live stream does. Ingest-time statistics are unaffected. It
+// returns the number of connections rolled up.
+func (f *FileData) RollUp(retention time.Duration) int {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	if len(f.Connections) == 0 {
+		return 0
+	}
+	if !f.spanKnown {
+		f.rawSpan = extendSpan([2]float64{math.Inf(1), math.Inf(-1)}, f.Connections)
+		f.spanKnown = true
+	}
+	cutoffTS := f.rawSpan[1] - retention.Seconds()
+	if f.rawSpan[0] >= cutoffTS {
+		return 0 // Nothing is old enough
+	}
+
+	kept := make([]models.Connection, 0, len(f.Connections))
+	rolled := 0
+	oldest := math.Inf(1)
+	for i := range f.Connections {
+		conn := &f.Connections[i]
+		if conn.Timestamp >= cutoffTS {
+			kept = append(kept, *conn)
+			oldest = min(oldest, conn.Timestamp)
+
+			continue
+		}
+
+		if f.rollups == nil {
+			f.rollups = make(map[int64]*models.TimelinePoint)
+		}
+		bucket := (int64(conn.Timestamp) / rollupBucketSec) * rollupBucketSec
+		point, exists := f.rollups[bucket]
+		if !exists {
+			point = &models.TimelinePoint{Timestamp: bucket}
+			f.rollups[bucket] = point
+		}
+		point.Count++
+		point.Bytes += conn.TotalBytes()
+		rolled++
+	}
+
+	f.rawSpan[0] = oldest
+	if rolled > 0 {
+		f.Connections = kept
+		f.memory = connectionsMemory(kept)
+		f.invalidateCaches()
+	}
+
+	return rolled
+}
+
+// invalidateCaches drops all data derived from the connections. Callers must hold cacheMu.
+func (f *FileData) invalidateCaches() {
+	f.timelineCache = make(map[timelineKey]*models.TimelineData)
+	f.graphCache = nil
+	f.scanCache = nil
+	f.index = nil
+	f.closeSQLDatabase()
+}
+
+// mergeRollups adds rolled-up history to a timeline, re-bucketing it to the requested size.
+func mergeRollups(timeline *models.TimelineData, rollups map[int64]*models.TimelinePoint, bucketSize int64, loc *time.Location) {
+	if len(rollups) == 0 {
+		return
+	}
+
+	points := make(map[int64]*models.TimelinePoint, len(timeline.Points))
+	for i := range timeline.Points {
+		points[timeline.Points[i].Timestamp] = &timeline.Points[i]
+	}
+
+	merged := make(map[int64]*models.TimelinePoint)
+	for _, rollup := range rollups {
+		bucket := bucketStart(rollup.Timestamp, bucketSize, loc)
+		point, exists := points[bucket]
+		if !exists {
+			point, exists = merged[bucket]
+		}
+		if !exists {
+			point = &models.TimelinePoint{Timestamp: bucket}
+			merged[bucket] = point
+		}
+		point.Count += rollup.Count
+		point.Bytes += rollup.Bytes
+
+		if timeline.Start == 0 || rollup.Timestamp < timeline.Start {
+			timeline.Start = rollup.Timestamp
+		}
+		timeline.End = max(timeline.End, rollup.Timestamp)
+	}
+
+	for _, point := range merged {
+		timeline.Points = append(timeline.Points, *point)
+	}
+
+	sort.Slice(timeline.Points, func(i, j int) bool {
+		return timeline.Points[i].Timestamp < timeline.Points[j].Timestamp
+	})
+}
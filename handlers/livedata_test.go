@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"zeek-viz/models"
+)
+
+// liveConnections returns count connections starting at start, step apart.
+func liveConnections(start time.Time, step time.Duration, count int) []models.Connection {
+	connections := make([]models.Connection, count)
+	for i := range connections {
+		connections[i] = models.Connection{
+			Timestamp: float64(start.Add(time.Duration(i) * step).Unix()),
+			UID:       fmt.Sprintf("C%d-%d", start.Unix(), i),
+			OrigHost:  "10.0.0.1",
+			RespHost:  "192.0.2.1",
+			Protocol:  "tcp",
+		}
+	}
+
+	return connections
+}
+
+func TestLiveRollUpFollowsLogTime(t *testing.T) {
+	api := NewAPI("")
+	api.SetLiveRetention(time.Hour)
+
+	// A replayed log from years ago keeps its last hour raw
+	start := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	fileID := api.IngestLive("replay", liveConnections(start, time.Minute, 50))
+	fileData := api.files[fileID]
+	if len(fileData.Connections) != 50 || len(fileData.rollups) != 0 {
+		t.Fatalf("%d raw connections and %d rolled-up buckets of a 50-minute log, want all raw",
+			len(fileData.Connections), len(fileData.rollups))
+	}
+
+	// Connections two hours later push the first log out of the window
+	api.IngestLive("replay", liveConnections(start.Add(2*time.Hour), time.Minute, 10))
+	if len(fileData.Connections) != 10 {
+		t.Errorf("%d raw connections after two hours, want the 10 of the last hour", len(fileData.Connections))
+	}
+	rolled := 0
+	for _, point := range fileData.rollups {
+		rolled += point.Count
+	}
+	if rolled != 50 {
+		t.Errorf("%d connections rolled up, want 50", rolled)
+	}
+}
+
+func TestLiveRollUpSkipsRecentData(t *testing.T) {
+	fileData := &FileData{}
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	fileData.AppendConnections(liveConnections(start, time.Second, 100))
+	if rolled := fileData.RollUp(time.Hour); rolled != 0 {
+		t.Fatalf("%d connections rolled up within the window", rolled)
+	}
+	if !fileData.spanKnown || fileData.rawSpan != [2]float64{float64(start.Unix()), float64(start.Unix() + 99)} {
+		t.Fatalf("raw span %v (known %t)", fileData.rawSpan, fileData.spanKnown)
+	}
+
+	// Appends keep the span, so later roll-ups return without scanning the connections
+	fileData.AppendConnections(liveConnections(start.Add(2*time.Minute), time.Second, 10))
+	fileData.Connections[0].Timestamp = 0 // Not seen: the span says nothing is old
+	if rolled := fileData.RollUp(time.Hour); rolled != 0 {
+		t.Errorf("%d connections rolled up; the span should have ended the roll-up early", rolled)
+	}
+	if fileData.rawSpan[1] != float64(start.Unix()+129) {
+		t.Errorf("latest ts %v, want %d", fileData.rawSpan[1], start.Unix()+129)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+)
+
+// ReadLocked runs handler while holding the API state's read lock, so queries run
+// concurrently with each other but never while datasets, the current selection, the
+// watchlist, or suppressions are being changed. Requests whose client disconnected while
+// waiting for the lock are dropped. Evicted datasets the request addresses are read back
+// first; the dataset is noted for its access log line.
+func (a *API) ReadLocked(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.loadEvicted(r)
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+
+		err := r.Context().Err()
+		if err != nil {
+			writeCanceled(w, r.URL.Path, err)
+
+			return
+		}
+		a.touchRequestDatasets(r)
+		noteDataset(r.Context(), a.requestDataset(r))
+		handler(w, r)
+	}
+}
+
+// Locked runs handler with exclusive access to the API state, for handlers that change it.
+// Uploads are not wrapped: they parse without the lock and only hold it to store the result.
+// As with ReadLocked, requests whose client disconnected while waiting are dropped.
+func (a *API) Locked(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.loadEvicted(r)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		err := r.Context().Err()
+		if err != nil {
+			writeCanceled(w, r.URL.Path, err)
+
+			return
+		}
+		a.touchRequestDatasets(r)
+		noteDataset(r.Context(), a.requestDataset(r))
+		handler(w, r)
+	}
+}
+
+// writeCanceled answers a request whose work stopped because its context is done. The client
+// is usually gone; the error status keeps the response out of the shared cache.
+func writeCanceled(w http.ResponseWriter, what string, err error) {
+	log.Printf("%s canceled: %v", what, err)
+	http.Error(w, "request canceled", http.StatusServiceUnavailable)
+}
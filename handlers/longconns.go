@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const (
+	longConnectionRule    = "long_connection" // Finding rule of long-lived connections
+	defaultLongDuration   = 3600.0            // Seconds a connection must last to be listed
+	defaultLongConnsLimit = 100               // Connections returned by default
+	openConnectionState   = "S1"              // Established and never closed while Zeek watched it
+	longReasonDuration    = "duration"        // Listed for lasting at least min_duration
+	longReasonOpen        = "open"            // Listed for remaining in S1
+	longDurationPrecision = 1000              // Durations are summed to milliseconds
+)
+
+var errInvalidMinDuration = errors.New("min_duration must be a non-negative number of seconds")
+
+// longConnection is a long-lived connection with why it was listed.
+type longConnection struct {
+	models.Connection
+
+	Reasons       []string       `json:"reasons"`                  // duration, open, or both
+	DurationHuman string         `json:"duration_human,omitempty"` //nolint:tagliatelle // API consistency
+	Threat        *models.Threat `json:"threat,omitempty"`
+}
+
+// GetLongConnections lists the connections lasting at least min_duration seconds or remaining
+// in S1 state, longest first, as persistent tunnels and reverse shells do. Accepts the standard
+// filters, min_duration, include_open, limit, and humanize.
+func (a *API) GetLongConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	minDuration, err := parseFloatParam(query.Get("min_duration"), defaultLongDuration)
+	if err != nil || minDuration < 0 {
+		http.Error(w, errInvalidMinDuration.Error(), http.StatusBadRequest)
+
+		return
+	}
+	includeOpen := query.Get("include_open") != "false"
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultLongConnsLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	long := make([]*models.Connection, 0)
+	suppressed := 0
+	totalDuration := 0.0
+	for i := range connections {
+		conn := &connections[i]
+		open := includeOpen && conn.ConnState == openConnectionState
+		if conn.Duration < minDuration && !open {
+			continue
+		}
+		if connectionSuppressed(a.suppressions, longConnectionRule, conn) {
+			suppressed++
+
+			continue
+		}
+		long = append(long, conn)
+		totalDuration += conn.Duration
+	}
+	sort.SliceStable(long, func(i, j int) bool {
+		return long[i].Duration > long[j].Duration
+	})
+
+	humanize := wantsHumanize(query)
+	entries := make([]longConnection, 0, min(limit, len(long)))
+	for _, conn := range long[:min(limit, len(long))] {
+		entry := longConnection{Connection: *conn, Threat: a.intel.matchConnection(conn)}
+		if conn.Duration >= minDuration {
+			entry.Reasons = append(entry.Reasons, longReasonDuration)
+		}
+		if conn.ConnState == openConnectionState {
+			entry.Reasons = append(entry.Reasons, longReasonOpen)
+		}
+		if humanize {
+			entry.DurationHuman = connstats.HumanizeDuration(conn.Duration)
+		}
+		entries = append(entries, entry)
+	}
+
+	response := map[string]any{
+		"connections":         entries,
+		"total":               len(long),
+		"total_duration":      roundTo(totalDuration, longDurationPrecision),
+		"truncated":           len(long) > limit,
+		"suppressed_findings": suppressed,
+		"limits":              map[string]any{"limit": limit, "min_duration": minDuration, "include_open": includeOpen},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode long connections: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+	"zeek-viz/store"
+)
+
+const (
+	evictUnloaded = "unloaded" // Eviction of a stored dataset, read back when it is next used
+	evictDropped  = "dropped"  // Eviction of a dataset that isn't stored and is gone for good
+
+	extraFieldOverhead = 64 // Approximate bytes of map bucket space and headers per extra field
+)
+
+var errUnloadedDataset = errors.New("evicted dataset could not be read from the store")
+
+// unloadedInfo describes an evicted dataset whose content was dropped from memory and stays
+// in the store or the search backend.
+type unloadedInfo struct {
+	connections int   // Number of connections it had
+	hasRaw      bool  // Whether its raw upload was kept
+	at          int64 // When it was unloaded (Unix seconds)
+}
+
+// SetMemoryLimits keeps at most maxDatasets datasets loaded and their estimated memory within
+// maxBytes, evicting the least recently used ones after datasets are added or grow; zero
+// disables a limit. The current dataset is only evicted once the search backend indexed it.
+// Evicted datasets that are in the store or the search backend stay listed and are read back
+// when a request uses them; the others are dropped.
+func (a *API) SetMemoryLimits(maxDatasets int, maxBytes int64) {
+	a.maxLoaded, a.memoryLimit = maxDatasets, maxBytes
+	if a.evictions != nil || (maxDatasets <= 0 && maxBytes <= 0) {
+		return
+	}
+
+	a.evictions = make(chan struct{}, 1)
+	go func() {
+		for range a.evictions {
+			a.mu.Lock()
+			a.evict()
+			a.mu.Unlock()
+		}
+	}()
+}
+
+// requestEviction asks for datasets to be evicted if the limits are exceeded. It doesn't
+// block, so callers may hold a.mu; eviction runs once they release it.
+func (a *API) requestEviction() {
+	if a.evictions == nil {
+		return
+	}
+	select {
+	case a.evictions <- struct{}{}:
+	default: // An eviction is already due
+	}
+}
+
+// evict unloads the least recently used datasets other than the current one, unless it is
+// indexed, until the limits are met. Callers must hold a.mu.
+func (a *API) evict() {
+	type candidate struct {
+		fileID   string
+		fileData *FileData
+		memory   int64
+		accessed int64
+	}
+
+	loaded, total := 0, int64(0)
+	candidates := make([]candidate, 0, len(a.files))
+	for fileID, fileData := range a.files {
+		if fileData.unloaded != nil {
+			continue
+		}
+		memory := fileData.memoryBytes()
+		loaded++
+		total += memory
+		if fileID != a.currentFileID || fileData.searchIndexed() {
+			candidates = append(candidates, candidate{fileID, fileData, memory, fileData.accessedAt()})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].accessed < candidates[j].accessed
+	})
+
+	for _, c := range candidates {
+		if !a.overMemoryLimits(loaded, total) {
+			break
+		}
+		loaded--
+		total -= c.memory
+		a.evictFile(c.fileID, c.fileData)
+	}
+}
+
+// overMemoryLimits reports whether loaded datasets using total bytes exceed the limits.
+func (a *API) overMemoryLimits(loaded int, total int64) bool {
+	return (a.maxLoaded > 0 && loaded > a.maxLoaded) || (a.memoryLimit > 0 && total > a.memoryLimit)
+}
+
+// evictFile frees the content of a dataset. Stored and indexed datasets keep a stub with their
+// metadata, statistics, and attached protocol records; datasets that can't be read back are
+// removed. Callers must hold a.mu.
+func (a *API) evictFile(fileID string, fileData *FileData) {
+	memory := fileData.memoryBytes()
+	if (a.store == nil || fileData.storedAt == 0) && !fileData.searchIndexed() {
+		fileData.release()
+		delete(a.files, fileID)
+		a.deleteSearchIndex(fileID) // Outdated, if it has one
+		a.metrics.evictions.Inc(evictDropped)
+		log.Printf("Dropped dataset %s (%s, %s) to stay within the memory limits; it isn't stored and can't be reloaded",
+			fileID, fileData.Filename, connstats.HumanizeBytes(float64(memory)))
+
+		return
+	}
+
+	fileData.unload()
+	a.metrics.evictions.Inc(evictUnloaded)
+	log.Printf("Unloaded dataset %s (%s, %s) to stay within the memory limits", fileID, fileData.Filename, connstats.HumanizeBytes(float64(memory)))
+}
+
+// loadEvicted reads the evicted datasets a request uses back from the store or the search
+// backend before the request takes the lock, holding the lock only to swap each one in. The
+// current dataset is left evicted for requests the search backend answers.
+func (a *API) loadEvicted(r *http.Request) {
+	a.mu.RLock()
+	var evicted []store.Metadata
+	for _, fileID := range a.requestDatasets(r) {
+		fileData := a.files[fileID]
+		if fileData == nil || fileData.unloaded == nil || (fileID == a.currentDataset(r) && a.searchAnswers(r, fileData)) {
+			continue
+		}
+		evicted = append(evicted, evictedMetadata(fileID, fileData))
+	}
+	a.mu.RUnlock()
+
+	for _, listed := range evicted {
+		meta, fileData := a.readEvictedFile(r.Context(), listed)
+		if fileData == nil {
+			continue // Logged; the request sees the stub
+		}
+
+		a.mu.Lock()
+		if stub := a.files[meta.ID]; stub != nil && stub.unloaded != nil {
+			a.restoreFile(meta, fileData)
+		}
+		a.mu.Unlock()
+	}
+}
+
+// reloadFile reads an evicted dataset back, for handlers that learn which datasets they need
+// only from the request body. It returns the dataset, still unloaded if reading it failed.
+// Callers must hold a.mu.
+func (a *API) reloadFile(ctx context.Context, fileID string) *FileData {
+	fileData := a.files[fileID]
+	if fileData == nil || fileData.unloaded == nil {
+		return fileData
+	}
+
+	meta, loaded := a.readEvictedFile(ctx, evictedMetadata(fileID, fileData))
+	if loaded == nil {
+		return fileData
+	}
+	a.restoreFile(meta, loaded)
+
+	return loaded
+}
+
+// evictedMetadata describes an evicted dataset for reading it back. UpdatedAt is the store
+// version of stored datasets and zero for the others, which are read from the search backend.
+func evictedMetadata(fileID string, fileData *FileData) store.Metadata {
+	meta := storedMetadata(fileID, fileData)
+	meta.UpdatedAt = fileData.storedAt
+
+	return meta
+}
+
+// readEvictedFile reads an evicted dataset back from the store, or from the search backend
+// when it isn't stored. Failures are logged and return nil.
+func (a *API) readEvictedFile(ctx context.Context, meta store.Metadata) (store.Metadata, *FileData) {
+	if a.store == nil || meta.UpdatedAt == 0 {
+		if a.search == nil {
+			return meta, nil
+		}
+
+		return a.readIndexedFile(ctx, meta)
+	}
+
+	return a.readStoredFile(ctx, meta.ID)
+}
+
+// restoreFile replaces the stub of an evicted dataset with its content read back from the
+// store or the search backend. Callers must hold a.mu.
+func (a *API) restoreFile(meta store.Metadata, fileData *FileData) {
+	stub := a.files[meta.ID]
+	fileData.httpRequests, fileData.tlsSessions = stub.httpRequests, stub.tlsSessions // Not stored
+	fileData.notices, fileData.weirds = stub.notices, stub.weirds
+	fileData.ParseReport = cmp.Or(fileData.ParseReport, stub.ParseReport) // Not indexed
+	if stub.searchIndexed() {
+		fileData.searchState, fileData.searchVersion = searchIndexed, fileData.version
+	}
+	fileData.touch()
+	a.addStoredFile(meta, fileData)
+}
+
+// touchRequestDatasets marks the datasets a request uses as recently used. Callers must hold
+// a.mu, for reading.
+func (a *API) touchRequestDatasets(r *http.Request) {
+	for _, fileID := range a.requestDatasets(r) {
+		if fileData := a.files[fileID]; fileData != nil {
+			fileData.touch()
+		}
+	}
+}
+
+// requestDatasets returns the file IDs a request may read: the {id} of its path, its file_id,
+// base, other, and files parameters, every dataset with file_id=all, and the current dataset
+// of the request.
+// Callers must hold a.mu.
+func (a *API) requestDatasets(r *http.Request) []string {
+	query := r.URL.Query()
+	current := a.currentDataset(r)
+	fileIDs := []string{current}
+	named := []string{r.PathValue("id"), query.Get("file_id"), query.Get("other")}
+	named = append(named, strings.Split(query.Get("base"), ",")...) // Several for /api/analysis/new-hosts
+	named = append(named, strings.Split(query.Get("files"), ",")...)
+	if query.Get("file_id") == allDatasets {
+		named = slices.AppendSeq(named, maps.Keys(a.files))
+	}
+	for _, fileID := range named {
+		if fileID != "" && fileID != current {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	return fileIDs
+}
+
+// memoryUsage returns the estimated memory of the loaded datasets and how many are loaded.
+// Callers must hold a.mu.
+func (a *API) memoryUsage() (int64, int) {
+	total, loaded := int64(0), 0
+	for _, fileData := range a.files {
+		if fileData.unloaded == nil {
+			total += fileData.memoryBytes()
+			loaded++
+		}
+	}
+
+	return total, loaded
+}
+
+// memoryBytes estimates the memory the dataset's connections and raw upload take. Derived
+// caches, which are rebuilt on demand, are not counted.
+func (f *FileData) memoryBytes() int64 {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	pointSize := int64(reflect.TypeFor[models.TimelinePoint]().Size())
+
+	return f.memory + int64(len(f.raw)) + int64(len(f.rollups))*pointSize
+}
+
+// unload drops the content and caches of an evicted dataset, keeping what file listings and
+// global statistics need. Callers must hold a.mu.
+func (f *FileData) unload() {
+	f.release()
+
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	f.unloaded = &unloadedInfo{connections: len(f.Connections), hasRaw: f.raw != nil && f.storedAt != 0, at: time.Now().Unix()}
+	f.Connections, f.unstitched, f.raw, f.memory = nil, nil, nil, 0
+}
+
+// connectionCount returns the number of connections of the dataset, loaded or not.
+func (f *FileData) connectionCount() int {
+	if f.unloaded != nil {
+		return f.unloaded.connections
+	}
+
+	return len(f.Connections)
+}
+
+// hasRaw reports whether the original upload is kept, in memory or for an evicted dataset in
+// the store.
+func (f *FileData) hasRaw() bool {
+	if f.unloaded != nil {
+		return f.unloaded.hasRaw
+	}
+
+	return f.raw != nil
+}
+
+// touch marks the dataset as used now.
+func (f *FileData) touch() {
+	f.lastAccess.Store(time.Now().UnixNano())
+}
+
+// accessedAt returns when the dataset was last used (Unix nanoseconds), its upload time if
+// no request has used it yet.
+func (f *FileData) accessedAt() int64 {
+	if accessed := f.lastAccess.Load(); accessed != 0 {
+		return accessed
+	}
+
+	return time.Unix(f.UploadTime, 0).UnixNano()
+}
+
+// connectionsMemory estimates the memory connections take: their structs, the bytes of their
+// strings, and their extra fields.
+func connectionsMemory(connections []models.Connection) int64 {
+	size := int64(cap(connections)) * int64(reflect.TypeFor[models.Connection]().Size())
+	for i := range connections {
+		conn := &connections[i]
+		size += int64(len(conn.UID) + len(conn.OrigHost) + len(conn.RespHost) + len(conn.Protocol) +
+			len(conn.Service) + len(conn.ConnState) + len(conn.History) + len(conn.SourceFile))
+		for name, value := range conn.Extras {
+			size += int64(len(name) + len(value) + extraFieldOverhead)
+		}
+	}
+
+	return size
+}
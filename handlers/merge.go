@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+	"zeek-viz/parse"
+)
+
+const (
+	minMergeFiles    = 2         // Files a merge combines at least
+	maxMergeBodySize = 64 * 1024 // Bytes of a merge request body
+	mergedTag        = "merged"  // Tag of datasets created by /api/merge
+	mergePrefix      = "merge:"  // Prefix of the name merged file IDs are derived from
+)
+
+var (
+	errMergeFiles      = errors.New("file_ids must list at least two datasets")
+	errMergeDuplicates = errors.New("file_ids must not repeat a dataset")
+)
+
+// mergeRequest is the body of a POST /api/merge request.
+type mergeRequest struct {
+	FileIDs []string `json:"file_ids"` //nolint:tagliatelle // API consistency
+	Name    string   `json:"name"`     // Filename of the merged dataset
+	Dedup   string   `json:"dedup"`    // first (default), latest, or none
+	Tags    []string `json:"tags"`
+}
+
+// mergeSource is a dataset that went into a merge.
+type mergeSource struct {
+	FileID      string `json:"file_id"` //nolint:tagliatelle // API consistency
+	Filename    string `json:"filename"`
+	Connections int    `json:"connections"`
+}
+
+// MergeFiles combines datasets, such as the hourly rotations of a day, into a new dataset and
+// selects it. Records are ordered by time and collapsed by UID as the dedup mode says; each
+// keeps the filename it came from in source_file. The merged dataset is stored as Zeek JSON
+// lines, which are also its raw download.
+func (a *API) MergeFiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request mergeRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMergeBodySize)).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	if request.Dedup == "" {
+		request.Dedup = dedupFirst
+	}
+	if !validDedup(request.Dedup) {
+		http.Error(w, errInvalidDedup.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if len(request.FileIDs) < minMergeFiles {
+		http.Error(w, errMergeFiles.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	sources := make([]mergeSource, 0, len(request.FileIDs))
+	uploads := make([]*parsedUpload, 0, len(request.FileIDs))
+	seen := make(map[string]bool, len(request.FileIDs))
+	for _, fileID := range request.FileIDs {
+		fileData := a.reloadFile(r.Context(), fileID)
+		if fileData == nil {
+			http.Error(w, fmt.Sprintf("File not found: %s", fileID), http.StatusNotFound)
+
+			return
+		}
+		if fileData.unloaded != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", errUnloadedDataset, fileID), http.StatusServiceUnavailable)
+
+			return
+		}
+		if seen[fileID] {
+			http.Error(w, errMergeDuplicates.Error(), http.StatusBadRequest)
+
+			return
+		}
+		seen[fileID] = true
+		sources = append(sources, mergeSource{FileID: fileID, Filename: fileData.Filename, Connections: len(fileData.Connections)})
+		uploads = append(uploads, &parsedUpload{filename: fileData.Filename, size: fileData.Size, connections: fileData.Connections})
+	}
+
+	name := request.Name
+	if name == "" {
+		name = mergedFilename(len(sources))
+	}
+	upload, err := mergeUploads(name, uploads, request.Dedup, !a.discardRaw)
+	if err != nil {
+		log.Printf("Failed to encode merged dataset: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	uploadTime := time.Now().Unix()
+	fileID := a.generateFileID(mergePrefix+name, uploadTime)
+	var quotaErr *quotaError
+	if errors.As(a.checkQuota(map[string]int64{fileID: upload.size}, true), &quotaErr) {
+		writeQuotaError(w, quotaErr)
+
+		return
+	}
+	fileData := &FileData{
+		UploadTime: uploadTime,
+		Tags:       append([]string{mergedTag}, request.Tags...),
+	}
+	upload.applyTo(fileData)
+	a.guessFileServices(fileData)
+
+	if existing := a.files[fileID]; existing != nil {
+		existing.release()
+	}
+	a.files[fileID] = fileData
+	a.checkWatchlist(fileID, fileData)
+	a.requestEviction()
+	a.persistFile(fileID, fileData)
+	a.indexFile(fileID, fileData)
+	a.setCurrentDataset(r, fileID)
+	fileData.warmCaches(a.localNetworks)
+
+	log.Printf("Merged %d datasets into %s as ID %s with %d connections (%d duplicates)", len(sources), name, fileID, len(upload.connections), upload.report.Duplicates)
+
+	response := map[string]any{
+		"success":           true,
+		"file_id":           fileID,
+		"filename":          name,
+		"connections_count": len(upload.connections),
+		"duplicates":        upload.report.Duplicates,
+		"dedup":             request.Dedup,
+		"sources":           sources,
+		"total_files":       len(a.files),
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode merge response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// mergeUploads combines parsed logs into one upload named filename. Records are ordered by
+// time and collapsed by UID as dedup says; each keeps the filename it came from in
+// source_file. The content of the upload, which its digest covers, is the records as Zeek
+// JSON lines; it is kept when keepRaw is set.
+func mergeUploads(filename string, uploads []*parsedUpload, dedup string, keepRaw bool) (*parsedUpload, error) {
+	total := 0
+	size := int64(0)
+	for _, upload := range uploads {
+		total += len(upload.connections)
+		size += upload.size
+	}
+
+	connections := make([]models.Connection, 0, total)
+	for _, upload := range uploads {
+		start := len(connections)
+		connections = append(connections, upload.connections...)
+		for i := start; i < len(connections); i++ {
+			if connections[i].SourceFile == "" { // Merges of merges keep the original file
+				connections[i].SourceFile = upload.filename
+			}
+		}
+	}
+	sort.SliceStable(connections, func(i, j int) bool {
+		return connections[i].Timestamp < connections[j].Timestamp
+	})
+	connections, duplicates := dedupConnections(connections, dedup)
+
+	var encoded bytes.Buffer
+	err := encodeConnections(&encoded, connections)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(encoded.Bytes())
+
+	report := parse.NewReport()
+	report.ParsedLines = len(connections)
+	report.Duplicates = duplicates
+	report.Dedup = dedup
+	merged := &parsedUpload{
+		filename:    filename,
+		size:        size,
+		mode:        lenientMode,
+		connections: connections,
+		stats:       connstats.Compute(connections),
+		report:      report,
+		sha256:      hex.EncodeToString(digest[:]),
+	}
+	if keepRaw {
+		merged.raw = encoded.Bytes()
+	}
+
+	return merged, nil
+}
+
+// mergedFilename returns the default filename of a dataset merged from count files.
+func mergedFilename(count int) string {
+	return fmt.Sprintf("merged-%d-files.log", count)
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"zeek-viz/metrics"
+	"zeek-viz/parse"
+)
+
+const (
+	metricsPath  = "/metrics" // Prometheus scrape endpoint
+	otherRoute   = "other"    // Route label of requests no pattern matched
+	sourceUpload = "upload"   // Connections ingested from uploads, archives, and --load
+	sourceLive   = "live"     // Connections ingested by live tailing and streaming
+
+	uploadConflict  = "conflict" // Upload result of content rejected by its idempotency key
+	uploadRejected  = "rejected" // Upload result of content that failed to parse
+	uploadCanceled  = "canceled" // Upload result of parses stopped by a disconnect or shutdown
+	uploadOverQuota = "quota"    // Upload result of content beyond the dataset quota
+)
+
+// apiMetrics are the Prometheus metrics of the API, served at /metrics.
+type apiMetrics struct {
+	registry    *metrics.Registry
+	requests    *metrics.Counter   // Requests by method, route, and status
+	latency     *metrics.Histogram // Request durations by route
+	uploads     *metrics.Counter   // Uploads by result
+	parse       *metrics.Histogram // Parse durations of uploaded conn.logs
+	connections *metrics.Counter   // Connections ingested by source
+	evictions   *metrics.Counter   // Datasets evicted by action
+	expirations *metrics.Counter   // Datasets removed by the retention policy, by limit
+	alerts      *metrics.Counter   // Dispatches to the alert webhook, by result
+}
+
+// newAPIMetrics registers the metrics of the API, including gauges reading its state.
+func newAPIMetrics(a *API) *apiMetrics {
+	registry := metrics.NewRegistry()
+	m := &apiMetrics{
+		registry: registry,
+		requests: registry.Counter("zeek_viz_http_requests_total",
+			"HTTP requests handled, by method, route pattern, and status code.", "method", "route", "status"),
+		latency: registry.Histogram("zeek_viz_http_request_duration_seconds",
+			"Time taken to answer HTTP requests, by route pattern.", metrics.DurationBuckets(), "route"),
+		uploads: registry.Counter("zeek_viz_uploads_total",
+			"Uploaded conn.logs, by result: created, replaced, duplicate, conflict, quota, rejected, or canceled.", "result"),
+		parse: registry.Histogram("zeek_viz_upload_parse_duration_seconds",
+			"Time taken to parse uploaded conn.logs.", metrics.DurationBuckets()),
+		connections: registry.Counter("zeek_viz_connections_ingested_total",
+			"Connections loaded into datasets, by source: upload or live.", "source"),
+		evictions: registry.Counter("zeek_viz_dataset_evictions_total",
+			"Datasets evicted to stay within the memory limits, by action: unloaded or dropped.", "action"),
+		expirations: registry.Counter("zeek_viz_dataset_expirations_total",
+			"Datasets removed by the retention policy, by the limit they exceeded: age, count, or size.", "reason"),
+		alerts: registry.Counter("zeek_viz_alert_dispatches_total",
+			"Dispatches of findings to the alert webhook, by result: sent or failed.", "result"),
+	}
+	registry.Gauge("zeek_viz_datasets", "Datasets held in memory.", func() float64 {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+
+		return float64(len(a.files))
+	})
+	registry.Gauge("zeek_viz_connections", "Connections held in memory across all datasets.", func() float64 {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+
+		total := 0
+		for _, fileData := range a.files {
+			total += len(fileData.Connections)
+		}
+
+		return float64(total)
+	})
+	registry.Gauge("zeek_viz_dataset_memory_bytes", "Estimated memory of the loaded datasets.", func() float64 {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+
+		total, _ := a.memoryUsage()
+
+		return float64(total)
+	})
+	registry.Gauge("zeek_viz_heap_bytes", "Bytes of heap objects.", func() float64 {
+		return float64(heapBytes())
+	})
+	registry.Gauge("zeek_viz_goroutines", "Goroutines currently running.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	return m
+}
+
+// recordUpload counts a stored upload by its status, and its parse duration.
+func (m *apiMetrics) recordUpload(status string, upload *parsedUpload) {
+	m.uploads.Inc(status)
+	m.observeParse(upload)
+	if status != uploadDuplicate {
+		m.connections.Add(float64(len(upload.connections)), sourceUpload)
+	}
+}
+
+// observeParse records how long parsing upload took. Merged uploads weren't parsed themselves
+// and have no metrics.
+func (m *apiMetrics) observeParse(upload *parsedUpload) {
+	if upload.metrics != nil {
+		m.parse.Observe(upload.metrics.ParseMs / float64(time.Second/time.Millisecond))
+	}
+}
+
+// rejectUpload counts an upload that failed to parse.
+func (m *apiMetrics) rejectUpload(err error) {
+	if errors.Is(err, parse.ErrCanceled) {
+		m.uploads.Inc(uploadCanceled)
+
+		return
+	}
+	m.uploads.Inc(uploadRejected)
+}
+
+// SetAccessLog enables or disables the structured log line written for every API request.
+func (a *API) SetAccessLog(enabled bool) {
+	a.accessLog = enabled
+}
+
+// GetMetrics serves the metrics in the Prometheus text format.
+func (a *API) GetMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+
+	err := a.metrics.registry.Write(w)
+	if err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
+}
+
+// requestRecord collects what handlers learn about a request for its access log line.
+type requestRecord struct {
+	dataset string // File ID of the dataset the request read or changed
+	user    string // Authenticated user
+}
+
+// requestRecordKey is the context key of the request's record.
+type requestRecordKey struct{}
+
+// noteDataset records the dataset a request works on, if the request is instrumented.
+func noteDataset(ctx context.Context, fileID string) {
+	if record, ok := ctx.Value(requestRecordKey{}).(*requestRecord); ok {
+		record.dataset = fileID
+	}
+}
+
+// noteUser records the authenticated user of a request, if the request is instrumented.
+func noteUser(ctx context.Context, name string) {
+	if record, ok := ctx.Value(requestRecordKey{}).(*requestRecord); ok {
+		record.user = name
+	}
+}
+
+// requestDataset returns the file ID a request addresses: the {id} of its path, its file_id
+// parameter, or the current dataset of the request. Callers must hold a.mu.
+func (a *API) requestDataset(r *http.Request) string {
+	if fileID := r.PathValue("id"); a.files[fileID] != nil {
+		return fileID
+	}
+	if fileID := r.URL.Query().Get("file_id"); fileID != "" {
+		return fileID
+	}
+
+	return a.currentDataset(r)
+}
+
+// Instrumented counts and times every request by the route pattern of mux it matches, and
+// writes a structured log line for API requests with their method, path, status, duration,
+// and dataset.
+func (a *API) Instrumented(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := requestRoute(mux, r)
+		record := &requestRecord{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestRecordKey{}, record)))
+
+		elapsed := time.Since(start)
+		a.metrics.requests.Inc(r.Method, route, strconv.Itoa(recorder.status))
+		a.metrics.latency.Observe(elapsed.Seconds(), route)
+		if !a.accessLog || !strings.HasPrefix(r.URL.Path, "/api/") {
+			return
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", recorder.status),
+			slog.Float64("duration_ms", durationMs(elapsed)),
+			slog.Int64("bytes", recorder.written),
+			slog.String("dataset", record.dataset),
+			slog.String("user", record.user),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// requestRoute returns the path of the mux pattern a request matches, which unlike the path
+// itself has a bounded number of values.
+func requestRoute(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return otherRoute
+	}
+	if _, path, found := strings.Cut(pattern, " "); found {
+		return path // Drop the method
+	}
+
+	return pattern
+}
+
+// statusRecorder records the status code and size of a response it forwards.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status  int
+	written int64
+}
+
+// WriteHeader records the status code.
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts and forwards the body.
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(data)
+	s.written += int64(n)
+
+	return n, err //nolint:wrapcheck // Transparent wrapper
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can flush event streams
+// and extend deadlines through the recorder.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const allDatasets = "all" // file_id selecting every loaded dataset
+
+var errDatasetNotFound = errors.New("dataset not found")
+
+// isMultiDataset reports whether a query spans several datasets rather than the current one.
+func isMultiDataset(query url.Values) bool {
+	return query.Get("file_id") == allDatasets || query.Get("files") != ""
+}
+
+// queryDatasets returns the file IDs a query spans: every dataset with file_id=all, ordered
+// by upload time, or those of the comma-separated files parameter in the order given. It
+// returns nil for queries of the current dataset. Callers must hold a.mu, for reading.
+func (a *API) queryDatasets(query url.Values) ([]string, error) {
+	if !isMultiDataset(query) {
+		return nil, nil
+	}
+	if query.Get("file_id") == allDatasets {
+		fileIDs := make([]string, 0, len(a.files))
+		for fileID := range a.files {
+			fileIDs = append(fileIDs, fileID)
+		}
+		slices.SortFunc(fileIDs, func(x, y string) int {
+			return cmp.Or(cmp.Compare(a.files[x].UploadTime, a.files[y].UploadTime), strings.Compare(x, y))
+		})
+
+		return fileIDs, nil
+	}
+
+	fileIDs := []string{}
+	for _, fileID := range connfilter.SplitList(query.Get("files")) {
+		if a.files[fileID] == nil {
+			return nil, fmt.Errorf("%w: %s", errDatasetNotFound, fileID)
+		}
+		if !slices.Contains(fileIDs, fileID) {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	return fileIDs, nil
+}
+
+// filterDatasets applies the query filters to each dataset and combines the matching
+// connections in timestamp order. The connections are copies carrying the file ID they came
+// from in Dataset.
+func (a *API) filterDatasets(fileIDs []string, query url.Values) ([]models.Connection, error) {
+	var combined []models.Connection
+	for _, fileID := range fileIDs {
+		connections, err := a.filterFile(a.files[fileID], query)
+		if err != nil {
+			return nil, err
+		}
+		first := len(combined)
+		combined = append(combined, connections...)
+		for i := first; i < len(combined); i++ {
+			combined[i].Dataset = fileID
+		}
+	}
+	slices.SortStableFunc(combined, func(x, y models.Connection) int {
+		return cmp.Compare(x.Timestamp, y.Timestamp)
+	})
+
+	if combined == nil {
+		return []models.Connection{}, nil
+	}
+
+	return combined, nil
+}
+
+// filterQuery applies the query filters to the datasets it spans, or to the current dataset
+// when fileIDs is nil.
+func (a *API) filterQuery(r *http.Request, fileIDs []string, query url.Values) ([]models.Connection, error) {
+	if fileIDs == nil {
+		return a.filterCurrentConnections(r, query)
+	}
+
+	return a.filterDatasets(fileIDs, query)
+}
+
+// datasetStats combines the statistics of datasets, leaving out broadcast, multicast, and
+// link-local traffic with excludeNoise, and lists the connections and time span of each.
+func (a *API) datasetStats(fileIDs []string, excludeNoise bool) (*models.ConnectionStats, []fileCoverage) {
+	combined := models.NewConnectionStats()
+	coverage := make([]fileCoverage, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		fileData := a.files[fileID]
+		stats := cmp.Or(fileData.Stats, models.NewConnectionStats())
+		if excludeNoise {
+			stats = connstats.Compute(connfilter.ApplyNoise(fileData.Connections, true))
+		}
+		combined.Merge(stats)
+		coverage = append(coverage, fileCoverage{
+			ID:          fileID,
+			Filename:    fileData.Filename,
+			Connections: stats.TotalConnections,
+			Start:       stats.StartTime,
+			End:         stats.EndTime,
+		})
+	}
+
+	return combined, coverage
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const defaultNewHostsLimit = 100 // Hosts and pairs returned by default
+
+var (
+	errNoBaseline       = errors.New("no earlier dataset to compare with; name baseline datasets with base")
+	errBaselineNotFound = errors.New("baseline dataset not found")
+)
+
+// NewHost is a host of a dataset that none of its baseline datasets contain.
+type NewHost struct {
+	Host        string         `json:"host"`
+	Local       bool           `json:"local"`
+	FirstSeen   float64        `json:"first_seen"` //nolint:tagliatelle // API consistency
+	LastSeen    float64        `json:"last_seen"`  //nolint:tagliatelle // API consistency
+	Connections int            `json:"connections"`
+	Bytes       int            `json:"bytes"`
+	Peers       int            `json:"peers"`
+	Threat      *models.Threat `json:"threat,omitempty"`
+
+	peers map[string]struct{}
+}
+
+// NewPair is a source and destination of a dataset that none of its baseline datasets contain.
+type NewPair struct {
+	Src         string  `json:"src"`
+	Dst         string  `json:"dst"`
+	FirstSeen   float64 `json:"first_seen"` //nolint:tagliatelle // API consistency
+	LastSeen    float64 `json:"last_seen"`  //nolint:tagliatelle // API consistency
+	Connections int     `json:"connections"`
+	Bytes       int     `json:"bytes"`
+	Services    string  `json:"services,omitempty"` // Services seen on the pair, comma-separated
+	NewHosts    int     `json:"new_hosts"`          //nolint:tagliatelle // Hosts of the pair that are new themselves
+
+	services map[string]struct{}
+}
+
+// SkippedDataset is a baseline dataset that couldn't be read.
+type SkippedDataset struct {
+	FileID   string `json:"file_id"` //nolint:tagliatelle // API consistency
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// GetNewHosts reports the hosts and source and destination pairs of a dataset that never
+// appear in its baseline: the datasets uploaded before it, or those named by base. Accepts
+// other, the dataset to examine (default the current one), base, a comma-separated list of
+// baseline file IDs, the standard filters, which apply to the examined dataset only, and
+// limit. Entries are listed in the order they were first seen.
+func (a *API) GetNewHosts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	fileID := query.Get("other")
+	if fileID == "" {
+		fileID = a.currentDataset(r)
+	}
+	fileData := a.files[fileID]
+	if fileData == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultNewHostsLimit
+	}
+
+	baseline, err := a.baselineDatasets(fileID, query.Get("base"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if len(baseline) == 0 {
+		http.Error(w, errNoBaseline.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	connections, err := a.filterFile(fileData, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	knownHosts := make(map[string]struct{})
+	knownPairs := make(map[string]struct{})
+	datasets := make([]CompareDataset, 0, len(baseline))
+	skipped := make([]SkippedDataset, 0)
+	for _, id := range baseline {
+		baseFile := a.files[id]
+		if baseFile.unloaded != nil {
+			skipped = append(skipped, SkippedDataset{FileID: id, Filename: baseFile.Filename, Reason: "evicted; name it in base to read it back"})
+
+			continue
+		}
+		for i := range baseFile.Connections {
+			conn := &baseFile.Connections[i]
+			knownHosts[conn.OrigHost] = struct{}{}
+			knownHosts[conn.RespHost] = struct{}{}
+			knownPairs[conn.OrigHost+groupKeySeparator+conn.RespHost] = struct{}{}
+		}
+		datasets = append(datasets, CompareDataset{FileID: id, Filename: baseFile.Filename, Connections: len(baseFile.Connections)})
+	}
+
+	hosts, pairs := a.newHostsAndPairs(connections, knownHosts, knownPairs)
+
+	response := map[string]any{
+		"dataset":  CompareDataset{FileID: fileID, Filename: fileData.Filename, Connections: len(connections)},
+		"baseline": datasets,
+		"skipped":  skipped,
+		"hosts":    compareList(hosts, limit),
+		"pairs":    compareList(pairs, limit),
+		"limits":   map[string]int{"limit": limit},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode new hosts: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// baselineDatasets returns the IDs of the datasets named by the comma-separated base, or
+// those uploaded before the dataset when base is empty, oldest first.
+func (a *API) baselineDatasets(fileID, base string) ([]string, error) {
+	var baseline []string
+	if base != "" {
+		for id := range strings.SplitSeq(base, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" || id == fileID {
+				continue
+			}
+			if a.files[id] == nil {
+				return nil, fmt.Errorf("%w: %s", errBaselineNotFound, id)
+			}
+			baseline = append(baseline, id)
+		}
+
+		return baseline, nil
+	}
+
+	uploadTime := a.files[fileID].UploadTime
+	for id, fileData := range a.files {
+		if fileData.UploadTime < uploadTime {
+			baseline = append(baseline, id)
+		}
+	}
+	sort.Slice(baseline, func(i, j int) bool {
+		if a.files[baseline[i]].UploadTime != a.files[baseline[j]].UploadTime {
+			return a.files[baseline[i]].UploadTime < a.files[baseline[j]].UploadTime
+		}
+
+		return baseline[i] < baseline[j]
+	})
+
+	return baseline, nil
+}
+
+// newHostsAndPairs returns the hosts and pairs of the connections that aren't known, in the
+// order they were first seen.
+func (a *API) newHostsAndPairs(connections []models.Connection, knownHosts, knownPairs map[string]struct{}) ([]NewHost, []NewPair) {
+	hosts := make(map[string]*NewHost)
+	pairs := make(map[string]*NewPair)
+	addHost := func(host, peer string, conn *models.Connection) {
+		if _, known := knownHosts[host]; known {
+			return
+		}
+		entry, exists := hosts[host]
+		if !exists {
+			entry = &NewHost{Host: host, FirstSeen: conn.Timestamp, LastSeen: conn.Timestamp, peers: make(map[string]struct{})}
+			hosts[host] = entry
+		}
+		entry.FirstSeen = min(entry.FirstSeen, conn.Timestamp)
+		entry.LastSeen = max(entry.LastSeen, conn.Timestamp)
+		entry.Connections++
+		entry.Bytes += conn.TotalBytes()
+		entry.peers[peer] = struct{}{}
+	}
+
+	for i := range connections {
+		conn := &connections[i]
+		addHost(conn.OrigHost, conn.RespHost, conn)
+		if conn.RespHost != conn.OrigHost {
+			addHost(conn.RespHost, conn.OrigHost, conn)
+		}
+
+		key := conn.OrigHost + groupKeySeparator + conn.RespHost
+		if _, known := knownPairs[key]; known {
+			continue
+		}
+		pair, exists := pairs[key]
+		if !exists {
+			pair = &NewPair{Src: conn.OrigHost, Dst: conn.RespHost, FirstSeen: conn.Timestamp, LastSeen: conn.Timestamp, services: make(map[string]struct{})}
+			pairs[key] = pair
+		}
+		pair.FirstSeen = min(pair.FirstSeen, conn.Timestamp)
+		pair.LastSeen = max(pair.LastSeen, conn.Timestamp)
+		pair.Connections++
+		pair.Bytes += conn.TotalBytes()
+		if conn.Service != "" {
+			pair.services[conn.Service] = struct{}{}
+		}
+	}
+
+	newHosts := make([]NewHost, 0, len(hosts))
+	for _, host := range hosts {
+		host.Local = a.localNetworks.Contains(host.Host)
+		host.Peers = len(host.peers)
+		host.Threat = a.intel.match(host.Host)
+		newHosts = append(newHosts, *host)
+	}
+	sort.Slice(newHosts, func(i, j int) bool {
+		if newHosts[i].FirstSeen != newHosts[j].FirstSeen {
+			return newHosts[i].FirstSeen < newHosts[j].FirstSeen
+		}
+
+		return newHosts[i].Host < newHosts[j].Host
+	})
+
+	newPairs := make([]NewPair, 0, len(pairs))
+	for _, pair := range pairs {
+		pair.Services = joinServices(pair.services)
+		if hosts[pair.Src] != nil {
+			pair.NewHosts++
+		}
+		if hosts[pair.Dst] != nil && pair.Dst != pair.Src {
+			pair.NewHosts++
+		}
+		newPairs = append(newPairs, *pair)
+	}
+	sort.Slice(newPairs, func(i, j int) bool {
+		if newPairs[i].FirstSeen != newPairs[j].FirstSeen {
+			return newPairs[i].FirstSeen < newPairs[j].FirstSeen
+		}
+		if newPairs[i].Src != newPairs[j].Src {
+			return newPairs[i].Src < newPairs[j].Src
+		}
+
+		return newPairs[i].Dst < newPairs[j].Dst
+	})
+
+	return newHosts, newPairs
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"zeek-viz/models"
+)
+
+const (
+	noticeLogType = "notice" // notice.log, attached to the conn.log of the same traffic
+	weirdLogType  = "weird"  // weird.log, attached to the conn.log of the same traffic
+
+	alertWindow       = 60   // Seconds before or after a connection an alert without its UID still matches it
+	defaultAlertLimit = 1000 // Alerts returned by default
+
+	correlatedByUID  = "uid"       // The alert's UID is that of a connection
+	correlatedByHost = "host_time" // The alert's hosts had a connection around its time
+)
+
+var errInvalidAlertKind = errors.New("kind must be notice or weird")
+
+// Alert is an attached notice.log or weird.log record, with the connection of the dataset it
+// belongs to.
+type Alert struct {
+	Kind        string  `json:"kind"` // notice or weird
+	Timestamp   float64 `json:"ts"`
+	Name        string  `json:"name"`              // Notice type, such as Scan::Port_Scan, or weird name
+	Message     string  `json:"message,omitempty"` // msg of a notice, addl of a weird
+	Sub         string  `json:"sub,omitempty"`
+	Src         string  `json:"src,omitempty"`
+	Dst         string  `json:"dst,omitempty"`
+	Port        int     `json:"port,omitempty"`
+	Protocol    string  `json:"proto,omitempty"`
+	UID         string  `json:"uid,omitempty"`         // Connection the alert belongs to
+	Correlation string  `json:"correlation,omitempty"` // How the connection was found, empty without one
+}
+
+// AlertCount is the number of alerts of a host or an edge of the graph.
+type AlertCount struct {
+	Host    string `json:"host,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Alerts  int    `json:"alerts"`
+	Notices int    `json:"notices"`
+	Weirds  int    `json:"weirds"`
+}
+
+// alertsOf turns notices and weirds, keyed by UID, into alerts in time order.
+func alertsOf(notices map[string][]models.Notice, weirds map[string][]models.Weird) []Alert {
+	alerts := make([]Alert, 0, countRecords(notices)+countRecords(weirds))
+	for _, list := range notices {
+		for i := range list {
+			notice := &list[i]
+			alerts = append(alerts, Alert{
+				Kind:      noticeLogType,
+				Timestamp: notice.Timestamp,
+				Name:      notice.Note,
+				Message:   notice.Msg,
+				Sub:       notice.Sub,
+				Src:       cmp.Or(notice.OrigHost, notice.Src),
+				Dst:       cmp.Or(notice.RespHost, notice.Dst),
+				Port:      cmp.Or(notice.RespPort, notice.Port),
+				Protocol:  notice.Protocol,
+				UID:       notice.UID,
+			})
+		}
+	}
+	for _, list := range weirds {
+		for i := range list {
+			weird := &list[i]
+			alerts = append(alerts, Alert{
+				Kind:      weirdLogType,
+				Timestamp: weird.Timestamp,
+				Name:      weird.Name,
+				Message:   weird.Addl,
+				Src:       weird.OrigHost,
+				Dst:       weird.RespHost,
+				Port:      weird.RespPort,
+				UID:       weird.UID,
+			})
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].Timestamp != alerts[j].Timestamp {
+			return alerts[i].Timestamp < alerts[j].Timestamp
+		}
+
+		return alerts[i].Name < alerts[j].Name
+	})
+
+	return alerts
+}
+
+// correlateAlerts finds the connection of the dataset each alert belongs to: the one of its
+// UID, or else the connection of its hosts, or of its only host, closest to its time within
+// alertWindow seconds. Hosts the alert doesn't name are taken from the connection.
+func (f *FileData) correlateAlerts(alerts []Alert) []Alert {
+	if len(alerts) == 0 {
+		return alerts
+	}
+
+	wanted := make(map[string]int)
+	for i := range alerts {
+		if alerts[i].UID != "" {
+			wanted[alerts[i].UID] = -1
+		}
+	}
+	for i := range f.Connections {
+		if position, exists := wanted[f.Connections[i].UID]; exists && position < 0 {
+			wanted[f.Connections[i].UID] = i
+		}
+	}
+
+	var index *connectionIndex
+	for i := range alerts {
+		alert := &alerts[i]
+		position, exists := wanted[alert.UID]
+		switch {
+		case exists && position >= 0:
+			alert.Correlation = correlatedByUID
+		case alert.Src == "" && alert.Dst == "":
+			continue
+		default:
+			if index == nil {
+				f.buildIndex()
+				index = f.connectionIndex()
+			}
+			position = f.alertConnection(index, alert)
+			if position < 0 {
+				continue
+			}
+			alert.Correlation = correlatedByHost
+		}
+
+		conn := &f.Connections[position]
+		alert.UID = conn.UID
+		if alert.Src == "" && alert.Dst == "" {
+			alert.Src, alert.Dst = conn.OrigHost, conn.RespHost
+		}
+	}
+
+	return alerts
+}
+
+// alertConnection returns the position of the connection between the alert's hosts, or of its
+// only host, whose time is closest to the alert's, or -1 when none is within alertWindow.
+func (f *FileData) alertConnection(index *connectionIndex, alert *Alert) int {
+	host, peer := alert.Src, alert.Dst
+	if host == "" {
+		host, peer = peer, ""
+	}
+	positions, ok := index.hostPositions(host)
+	if !ok {
+		return -1
+	}
+
+	best, bestDistance := -1, math.Inf(1)
+	for _, position := range positions {
+		conn := &f.Connections[position]
+		if peer != "" && conn.OrigHost != peer && conn.RespHost != peer {
+			continue
+		}
+		distance := max(conn.Timestamp-alert.Timestamp, alert.Timestamp-connectionEnd(conn), 0)
+		if distance <= alertWindow && distance < bestDistance {
+			best, bestDistance = int(position), distance
+		}
+	}
+
+	return best
+}
+
+// GetNotices lists the notice.log and weird.log records attached to the current dataset with
+// the connections they belong to, for alert markers on the timeline, and counts them per host
+// and edge, for badges in the graph. Accepts kind (notice or weird), start and end in Unix
+// seconds, host, which either host of an alert matches, and limit, which caps the alerts
+// listed but not the counts.
+func (a *API) GetNotices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fileData := a.files[a.currentDataset(r)]
+	if fileData == nil {
+		http.Error(w, "No file selected", http.StatusNotFound)
+
+		return
+	}
+
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	if kind != "" && kind != noticeLogType && kind != weirdLogType {
+		http.Error(w, errInvalidAlertKind.Error(), http.StatusBadRequest)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultAlertLimit
+	}
+
+	alerts := filterAlerts(alertsOf(fileData.notices, fileData.weirds), kind, query.Get("start"), query.Get("end"), query.Get("host"))
+	alerts = fileData.correlateAlerts(alerts)
+
+	correlated := 0
+	hosts := make(map[string]*AlertCount)
+	edges := make(map[string]*AlertCount)
+	count := func(entry *AlertCount, alert *Alert) {
+		entry.Alerts++
+		if alert.Kind == noticeLogType {
+			entry.Notices++
+		} else {
+			entry.Weirds++
+		}
+	}
+	for i := range alerts {
+		alert := &alerts[i]
+		if alert.Correlation != "" {
+			correlated++
+		}
+		for j, host := range []string{alert.Src, alert.Dst} {
+			if host == "" || (j > 0 && host == alert.Src) {
+				continue
+			}
+			if hosts[host] == nil {
+				hosts[host] = &AlertCount{Host: host}
+			}
+			count(hosts[host], alert)
+		}
+		if alert.Src != "" && alert.Dst != "" {
+			key := alert.Src + groupKeySeparator + alert.Dst
+			if edges[key] == nil {
+				edges[key] = &AlertCount{Source: alert.Src, Target: alert.Dst}
+			}
+			count(edges[key], alert)
+		}
+	}
+
+	response := map[string]any{
+		"total":      len(alerts),
+		"correlated": correlated,
+		"alerts":     alerts[:min(limit, len(alerts))],
+		"hosts":      sortedAlertCounts(hosts),
+		"edges":      sortedAlertCounts(edges),
+		"limits":     map[string]int{"limit": limit},
+		"truncated":  len(alerts) > limit,
+	}
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode notices: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// filterAlerts keeps the alerts of the kind, between start and end, and naming host, where set.
+func filterAlerts(alerts []Alert, kind, startTime, endTime, host string) []Alert {
+	start, errStart := strconv.ParseInt(startTime, 10, 64)
+	end, errEnd := strconv.ParseInt(endTime, 10, 64)
+	timed := errStart == nil && errEnd == nil
+
+	filtered := alerts[:0]
+	for _, alert := range alerts {
+		switch {
+		case kind != "" && alert.Kind != kind:
+		case timed && (int64(alert.Timestamp) < start || int64(alert.Timestamp) > end):
+		case host != "" && alert.Src != host && alert.Dst != host:
+		default:
+			filtered = append(filtered, alert)
+		}
+	}
+
+	return filtered
+}
+
+// sortedAlertCounts returns the counts with the most alerts first.
+func sortedAlertCounts(counts map[string]*AlertCount) []AlertCount {
+	sorted := make([]AlertCount, 0, len(counts))
+	for _, entry := range counts {
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Alerts != sorted[j].Alerts {
+			return sorted[i].Alerts > sorted[j].Alerts
+		}
+		if sorted[i].Host != sorted[j].Host {
+			return sorted[i].Host < sorted[j].Host
+		}
+		if sorted[i].Source != sorted[j].Source {
+			return sorted[i].Source < sorted[j].Source
+		}
+
+		return sorted[i].Target < sorted[j].Target
+	})
+
+	return sorted
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const openAPIVersion = "3.0.3" // OpenAPI version of the generated document
+
+// openAPIParameter describes a query parameter of the versioned API.
+type openAPIParameter struct {
+	kind        string // JSON schema type: string, integer, number, or boolean
+	description string
+}
+
+// v1Parameters documents the query parameters the v1 routes list. Parameters that mean
+// different things on different endpoints, such as format or limit, are described for all.
+func v1Parameters() map[string]openAPIParameter {
+	return map[string]openAPIParameter{
+		"start":            {"integer", "Start timestamp (Unix epoch)"},
+		"end":              {"integer", "End timestamp (Unix epoch)"},
+		"protocol":         {"string", "Protocol (tcp, udp, icmp)"},
+		"conn_state":       {"string", "Zeek connection state (SF, S0, REJ, ...)"},
+		"exclude_noise":    {"boolean", "Drop broadcast, multicast, and link-local traffic"},
+		"scope":            {"string", "internal, external, or crossing traffic"},
+		"orig_port":        {"string", "Comma-separated originator ports and ranges"},
+		"resp_port":        {"string", "Comma-separated responder ports and ranges"},
+		"service":          {"string", "Comma-separated Zeek services"},
+		"infer_services":   {"boolean", "Let service also match the services guessed from the responder port"},
+		"orig_host":        {"string", "Comma-separated originator addresses and CIDR prefixes"},
+		"resp_host":        {"string", "Comma-separated responder addresses and CIDR prefixes"},
+		"subnet":           {"string", "Comma-separated CIDR prefixes either host is in"},
+		"country":          {"string", "Comma-separated ISO codes of external hosts' countries"},
+		"threat":           {"boolean", "Keep connections with a host matching a threat indicator"},
+		"ip_version":       {"integer", "Keep IPv4 (4) or IPv6 (6) connections"},
+		"history_flag":     {"string", "Comma-separated history flags connections must all have, such as half_open,no_data"},
+		"limit":            {"integer", "Maximum number of results"},
+		"offset":           {"integer", "Results to skip"},
+		"fields":           {"string", "Comma-separated fields, in order"},
+		"sample":           {"string", "Return a sample of the matches: random, stratified (per protocol), or top_bytes"},
+		"sample_size":      {"integer", "Connections in the sample (default 5000)"},
+		"seed":             {"integer", "Seed of random and stratified samples (default 1)"},
+		"format":           {"string", "Output format"},
+		"download":         {"boolean", "Send the response as an attachment"},
+		"name":             {"string", "Substring of the file name"},
+		"tag":              {"string", "Tag to select by"},
+		"sort":             {"string", "Sort key"},
+		"order":            {"string", "asc or desc"},
+		"subnet_group":     {"integer", "Collapse IPv4 hosts into subnets of this prefix length"},
+		"subnet_group_v6":  {"integer", "Collapse IPv6 hosts into subnets of this prefix length"},
+		"min_edge_count":   {"integer", "Drop edges with fewer connections"},
+		"min_edge_bytes":   {"integer", "Drop edges with fewer bytes"},
+		"min_connections":  {"integer", "Minimum number of connections"},
+		"edge_limit":       {"integer", "Keep only the N heaviest edges"},
+		"edge_by":          {"string", "Connections sharing an edge: protocol, pair, service, or port"},
+		"hostnames":        {"boolean", "Resolve hostnames (default true)"},
+		"layout":           {"string", "Position nodes: force, circular, or hierarchical"},
+		"analytics":        {"boolean", "Add degree, betweenness, and community metrics to the nodes"},
+		"bucket":           {"string", "Bucket size in seconds, or auto"},
+		"deltas":           {"boolean", "Send frames as changes from the previous one (default true)"},
+		"group_by":         {"string", "Field to group by; asn or as_org to roll external hosts of the graph up"},
+		"include":          {"string", "uids to list the UIDs of all connections"},
+		"tz":               {"string", "IANA time zone of calendar buckets"},
+		"humanize":         {"boolean", "Add human-readable values"},
+		"source":           {"string", "Source host, or name of an IOC list"},
+		"target":           {"string", "Target host"},
+		"bidirectional":    {"boolean", "Count both directions of the edge"},
+		"metrics":          {"string", "Comma-separated metrics, such as count or sum(orig_bytes)"},
+		"sql":              {"string", "SQL query against the connections table"},
+		"q":                {"string", "Filter expression, or the pipeline of /api/pipeline"},
+		"field":            {"string", "Connection field"},
+		"bins":             {"integer", "Number of histogram bins"},
+		"scale":            {"string", "linear or log"},
+		"by":               {"string", "What to rank by"},
+		"metric":           {"string", "bytes, connections, or packets"},
+		"n":                {"integer", "Number of results"},
+		"k":                {"integer", "Number of clusters"},
+		"min_interval":     {"number", "Minimum seconds between connections"},
+		"min_score":        {"number", "Minimum beacon score from 0 to 1, or absolute anomaly score"},
+		"type":             {"string", "Kind of finding"},
+		"window":           {"integer", "Window in seconds"},
+		"min_hosts":        {"integer", "Minimum number of scanned hosts"},
+		"min_ports":        {"integer", "Minimum number of scanned ports"},
+		"min_bytes":        {"integer", "Minimum number of bytes sent"},
+		"min_ratio":        {"number", "Minimum ratio of bytes sent to bytes received"},
+		"min_duration":     {"number", "Minimum duration in seconds"},
+		"include_open":     {"boolean", "Include connections still open at the end of the log"},
+		"fingerprint":      {"string", "TLS client fingerprint: ja3 or ja4"},
+		"max_clients":      {"integer", "Clients at most that make a fingerprint rare"},
+		"base":             {"string", "ID of the dataset to compare against; comma-separated baseline IDs for new-hosts"},
+		"other":            {"string", "ID of the dataset to compare"},
+		"uid":              {"string", "Comma-separated connection UIDs"},
+		"note":             {"string", "Analyst notes"},
+		"replace":          {"boolean", "Replace the datasets and settings instead of adding to them"},
+		"restore_settings": {"boolean", "Also restore the live retention and raw upload storage of the archive's server"},
+		"value":            {"string", "Address or CIDR prefix"},
+		"kind":             {"string", "host or connection; notice or weird for notices"},
+		"host":             {"string", "Address either host of an alert matches"},
+		"filename":         {"string", "File name of the dataset"},
+		"mode":             {"string", "Parse mode: lenient or strict"},
+		"dedup":            {"string", "Records of repeated UIDs to keep: none, first, or latest"},
+		"upload_id":        {"string", "ID to follow the upload's progress by"},
+		"file_id":          {"string", "ID of the dataset to read instead of the current one, or all for every dataset"},
+		"files":            {"string", "Comma-separated IDs of the datasets to query instead of the current one"},
+	}
+}
+
+// getOpenAPI serves the OpenAPI document of the routes.
+func (a *API) getOpenAPI(routes []v1Route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonContentType)
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(a.openAPIDocument(routes))
+		if err != nil {
+			log.Printf("Failed to encode OpenAPI document: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// openAPIDocument generates the OpenAPI description of the routes, served under the base
+// path.
+func (a *API) openAPIDocument(routes []v1Route) map[string]any {
+	parameters := v1Parameters()
+	paths := map[string]map[string]any{}
+	var tags []string
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route.pattern, " ")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = openAPIOperation(route, parameters)
+		if !slices.Contains(tags, route.tag) {
+			tags = append(tags, route.tag)
+		}
+	}
+
+	tagObjects := make([]map[string]any, 0, len(tags))
+	for _, tag := range tags {
+		tagObjects = append(tagObjects, map[string]any{"name": tag})
+	}
+
+	server := a.basePath
+	if server == "" {
+		server = "/"
+	}
+
+	document := map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":       a.Config().InstanceName + " API",
+			"version":     "1",
+			"description": "Versioned API for uploading Zeek conn.logs and querying their connections. Failed requests answer with an error envelope.",
+		},
+		"servers": []map[string]any{{"url": server}},
+		"tags":    tagObjects,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type":     "object",
+					"required": []string{"error"},
+					"properties": map[string]any{
+						"error": map[string]any{
+							"type":     "object",
+							"required": []string{"status", "code", "message"},
+							"properties": map[string]any{
+								"status":  map[string]any{"type": "integer", "description": "HTTP status code"},
+								"code":    map[string]any{"type": "string", "description": "Machine-readable reason, such as not_found"},
+								"message": map[string]any{"type": "string", "description": "Human-readable description"},
+								"details": map[string]any{"type": "object", "description": "Structured error of the endpoint, if it has one"},
+							},
+						},
+					},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"basic":  map[string]any{"type": "http", "scheme": "basic"},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	if len(a.authenticators) > 0 {
+		document["security"] = []map[string][]string{{"basic": {}}, {"bearer": {}}}
+	}
+
+	return document
+}
+
+// openAPIOperation describes the operation of a route.
+func openAPIOperation(route v1Route, parameters map[string]openAPIParameter) map[string]any {
+	var params []map[string]any
+	for _, match := range regexp.MustCompile(`\{(\w+)\}`).FindAllStringSubmatch(route.pattern, -1) {
+		params = append(params, map[string]any{
+			"name": match[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	seen := map[string]bool{}
+	for _, name := range route.params {
+		names := []string{name}
+		if name == "filters" {
+			names = filterParams()
+		}
+		for _, name := range names {
+			if seen[name] {
+				continue // Listed by the route and among the filters, such as q
+			}
+			seen[name] = true
+			parameter := parameters[name]
+			params = append(params, map[string]any{
+				"name": name, "in": "query", "description": parameter.description, "schema": map[string]any{"type": parameter.kind},
+			})
+		}
+	}
+
+	status, description := http.StatusOK, "Success"
+	if route.created {
+		status, description = http.StatusCreated, "Created"
+	}
+	response := cmp.Or(route.response, jsonContentType)
+	operation := map[string]any{
+		"operationId": route.operationID,
+		"summary":     route.summary,
+		"tags":        []string{route.tag},
+		"responses": map[string]any{
+			strconv.Itoa(status): map[string]any{
+				"description": description,
+				"content":     map[string]any{response: map[string]any{"schema": openAPISchema(response)}},
+			},
+			"default": map[string]any{
+				"description": "Error",
+				"content":     map[string]any{jsonContentType: map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}}},
+			},
+		},
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+	if route.body != "" {
+		schema := openAPISchema(route.body)
+		if route.upload != "" {
+			schema = map[string]any{
+				"type":       "object",
+				"required":   []string{route.upload},
+				"properties": map[string]any{route.upload: map[string]any{"type": "string", "format": "binary"}},
+			}
+		}
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{route.body: map[string]any{"schema": schema}},
+		}
+	}
+
+	return operation
+}
+
+// openAPISchema returns the schema of a body of the media type.
+func openAPISchema(mediaType string) map[string]any {
+	switch {
+	case mediaType == jsonContentType:
+		return map[string]any{"type": "object"}
+	case strings.HasPrefix(mediaType, "text/"):
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+}
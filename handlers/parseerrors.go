@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"zeek-viz/parse"
+)
+
+// GetParseErrors returns the parse report of a file: its line counts and the first offending
+// lines with their line numbers.
+func (a *API) GetParseErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fileData := a.files[r.PathValue("id")]
+	if fileData == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+
+		return
+	}
+
+	report := fileData.ParseReport
+	if report == nil {
+		report = parse.NewReport()
+	}
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Printf("Failed to encode parse errors: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+
+	"zeek-viz/query"
+)
+
+const defaultPipelineLimit = 1000 // Default number of rows returned by /api/pipeline
+
+// RunPipeline evaluates a pipeline expression such as
+// `filter proto=="tcp" | summarize sum(orig_bytes) by resp_h | head 20` over the filtered connections.
+func (a *API) RunPipeline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := r.URL.Query()
+	pipelineText := params.Get("q")
+	if pipelineText == "" {
+		http.Error(w, "q parameter is required", http.StatusBadRequest)
+
+		return
+	}
+
+	pipeline, err := query.ParsePipeline(pipelineText)
+	if err != nil {
+		http.Error(w, "Invalid pipeline: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	filters := maps.Clone(params)
+	delete(filters, "q") // The pipeline, not a filter expression
+	connections, err := a.filterCurrentConnections(r, filters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	result, err := pipeline.Execute(connections)
+	if err != nil {
+		http.Error(w, "Pipeline failed: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	limit := parseLimit(params, "limit")
+	if limit == 0 {
+		limit = defaultPipelineLimit
+	}
+
+	total := result.Len()
+	result.Truncate(limit)
+
+	response := map[string]any{
+		"query":     pipelineText,
+		"total":     total,
+		"count":     result.Len(),
+		"truncated": total > limit,
+		"limits":    map[string]int{"limit": limit},
+	}
+	if result.IsRecords {
+		response["kind"] = "records"
+		response["records"] = result.Records
+	} else {
+		response["kind"] = "connections"
+		response["records"] = result.Connections
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode pipeline result: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
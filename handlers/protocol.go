@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"zeek-viz/models"
+	"zeek-viz/parse"
+)
+
+const (
+	httpLogType = "http" // http.log, attached to the conn.log of the same traffic
+	sslLogType  = "ssl"  // ssl.log, attached to the conn.log of the same traffic
+)
+
+var (
+	errNoConnDataset = errors.New("upload the conn.log first, or name its dataset with file_id")
+	errMissingUID    = errors.New("record has no uid")
+)
+
+// protocolLog holds the records of an http.log, ssl.log, notice.log, or weird.log, keyed by
+// connection UID. Notices and weirds without one are filed under "".
+type protocolLog struct {
+	http   map[string][]models.HTTPRequest
+	ssl    map[string][]models.TLSSession
+	notice map[string][]models.Notice
+	weird  map[string][]models.Weird
+}
+
+// newProtocolLog creates a protocol log without records.
+func newProtocolLog() *protocolLog {
+	return &protocolLog{
+		http:   map[string][]models.HTTPRequest{},
+		ssl:    map[string][]models.TLSSession{},
+		notice: map[string][]models.Notice{},
+		weird:  map[string][]models.Weird{},
+	}
+}
+
+// protocolLogTypes returns the log types attached to a conn.log dataset.
+func protocolLogTypes() []string {
+	return []string{httpLogType, sslLogType, noticeLogType, weirdLogType}
+}
+
+// protocolLogType returns the protocol log type of uploaded content that isn't a conn.log,
+// or "" when it is not one of protocolLogTypes.
+func protocolLogType(head []byte) string {
+	uploadErr := sniffUpload(head)
+	if uploadErr == nil || uploadErr.Code != "wrong_log_type" {
+		return ""
+	}
+	if slices.Contains(protocolLogTypes(), uploadErr.Detected) {
+		return uploadErr.Detected
+	}
+
+	return ""
+}
+
+// count returns the number of records of all log types.
+func (p *protocolLog) count() int {
+	return countRecords(p.http) + countRecords(p.ssl) + countRecords(p.notice) + countRecords(p.weird)
+}
+
+// attachProtocolLog parses an uploaded protocol log and attaches its records to the
+// dataset named by the id path value or file_id form field, or to the current dataset. The
+// records replace those of an earlier upload of the same log type.
+func (a *API) attachProtocolLog(w http.ResponseWriter, r *http.Request, logType, filename string, reader io.Reader) {
+	records, report, err := parseProtocolLog(r.Context(), reader, logType)
+	if err != nil {
+		log.Printf("Failed to load %s.log from uploaded file: %v", logType, err)
+		http.Error(w, "Failed to parse protocol log file", http.StatusBadRequest)
+
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fileID := r.PathValue("id")
+	if fileID == "" {
+		fileID = r.FormValue("file_id")
+	}
+	if fileID == "" {
+		fileID = a.currentDataset(r)
+	}
+	fileData := a.files[fileID]
+	if fileData == nil {
+		http.Error(w, errNoConnDataset.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	correlated := fileData.attachProtocolLog(logType, records)
+
+	log.Printf("Attached %d %s.log records from %s to file %s (%d correlated)", report.ParsedLines, logType, filename, fileID, correlated)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]any{
+		"success":      true,
+		"message":      fmt.Sprintf("Attached %d %s.log records from %s to %s", report.ParsedLines, logType, filename, fileData.Filename),
+		"file_id":      fileID,
+		"filename":     filename,
+		"log_type":     logType,
+		"records":      report.ParsedLines,
+		"correlated":   correlated,
+		"parse_errors": report.Summary(),
+	}
+	if warning := report.Warning(); warning != "" {
+		response["warning"] = warning
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// attachProtocolLog replaces the records of the log type attached to the dataset, so that
+// responses derived from them get a new version, and returns how many of them belong to one
+// of its connections.
+func (f *FileData) attachProtocolLog(logType string, records *protocolLog) int {
+	switch logType {
+	case httpLogType:
+		f.httpRequests = records.http
+	case sslLogType:
+		f.tlsSessions = records.ssl
+	case noticeLogType:
+		f.notices = records.notice
+	case weirdLogType:
+		f.weirds = records.weird
+	}
+	f.attachVersion++
+
+	return f.correlatedRecords(records)
+}
+
+// correlatedRecords returns how many records of a protocol log belong to one of the dataset's
+// connections. Notices and weirds also count when they match one by host and time.
+func (f *FileData) correlatedRecords(records *protocolLog) int {
+	uids := make(map[string]bool, len(f.Connections))
+	for i := range f.Connections {
+		uids[f.Connections[i].UID] = true
+	}
+	correlated := 0
+	for uid, requests := range records.http {
+		if uids[uid] {
+			correlated += len(requests)
+		}
+	}
+	for uid, sessions := range records.ssl {
+		if uids[uid] {
+			correlated += len(sessions)
+		}
+	}
+	for _, alert := range f.correlateAlerts(alertsOf(records.notice, records.weird)) {
+		if alert.Correlation != "" {
+			correlated++
+		}
+	}
+
+	return correlated
+}
+
+// merge adds the records of other, such as the next rotation of the same log.
+func (p *protocolLog) merge(other *protocolLog) {
+	for uid, requests := range other.http {
+		p.http[uid] = append(p.http[uid], requests...)
+	}
+	for uid, sessions := range other.ssl {
+		p.ssl[uid] = append(p.ssl[uid], sessions...)
+	}
+	for uid, notices := range other.notice {
+		p.notice[uid] = append(p.notice[uid], notices...)
+	}
+	for uid, weirds := range other.weird {
+		p.weird[uid] = append(p.weird[uid], weirds...)
+	}
+}
+
+// parseProtocolLog parses a protocol log in JSON or TSV format. Malformed lines are
+// skipped and recorded in the returned report.
+func parseProtocolLog(ctx context.Context, reader io.Reader, logType string) (*protocolLog, *parse.Report, error) {
+	records := newProtocolLog()
+	report := parse.NewReport()
+	header := models.NewTSVHeader()
+	lines := parse.NewLineReader(reader)
+
+	for lineNumber := 1; ; lineNumber++ {
+		err := parse.CheckCanceled(ctx, lineNumber)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, err := lines.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return records, report, nil
+		case errors.Is(err, parse.ErrLineTooLong):
+			report.TotalLines++
+			report.Skip(lineNumber, "", err)
+
+			continue
+		case err != nil:
+			return nil, nil, fmt.Errorf("%w: %w", errErrorReadingData, err)
+		}
+
+		line = strings.TrimPrefix(line, parse.ByteOrderMark)
+		if strings.TrimSpace(line) == "" || (strings.HasPrefix(line, "#") && header.ParseDirective(line) == nil) {
+			continue
+		}
+		report.TotalLines++
+
+		unmarshal := func(target any) error {
+			if header.HasFields() && !strings.HasPrefix(strings.TrimSpace(line), "{") {
+				return header.UnmarshalRecord(line, target) //nolint:wrapcheck // Classified by the parse report
+			}
+
+			return json.Unmarshal([]byte(line), target) //nolint:wrapcheck // Classified by the parse report
+		}
+		err = records.add(logType, unmarshal)
+		if err != nil {
+			report.Skip(lineNumber, line, err)
+
+			continue
+		}
+		report.ParsedLines++
+	}
+}
+
+// add decodes one record of the log type and files it under its UID. HTTP requests and TLS
+// sessions without one are rejected.
+func (p *protocolLog) add(logType string, unmarshal func(target any) error) error {
+	switch logType {
+	case httpLogType:
+		var request models.HTTPRequest
+		err := unmarshal(&request)
+		if err == nil && request.UID == "" {
+			err = errMissingUID
+		}
+		if err == nil {
+			p.http[request.UID] = append(p.http[request.UID], request)
+		}
+
+		return err
+	case noticeLogType:
+		var notice models.Notice
+		err := unmarshal(&notice)
+		if err == nil {
+			p.notice[notice.UID] = append(p.notice[notice.UID], notice)
+		}
+
+		return err
+	case weirdLogType:
+		var weird models.Weird
+		err := unmarshal(&weird)
+		if err == nil {
+			p.weird[weird.UID] = append(p.weird[weird.UID], weird)
+		}
+
+		return err
+	}
+
+	var session models.TLSSession
+	err := unmarshal(&session)
+	if err == nil && session.UID == "" {
+		err = errMissingUID
+	}
+	if err == nil {
+		p.ssl[session.UID] = append(p.ssl[session.UID], session)
+	}
+
+	return err
+}
+
+// countRecords returns the number of records attached under all UIDs.
+func countRecords[T any](records map[string][]T) int {
+	count := 0
+	for _, list := range records {
+		count += len(list)
+	}
+
+	return count
+}
+
+// GetConnectionDetails returns the conn.log entry of a UID in the current dataset together
+// with the correlated HTTP requests, TLS sessions, notices, and weirds from attached http.log,
+// ssl.log, notice.log, and weird.log files.
+func (a *API) GetConnectionDetails(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uid := r.PathValue("uid")
+	fileData := a.files[a.currentDataset(r)]
+	if fileData == nil {
+		http.Error(w, "No file selected", http.StatusNotFound)
+
+		return
+	}
+
+	connection := fileData.connection(uid)
+	requests := fileData.httpRequests[uid]
+	sessions := fileData.tlsSessions[uid]
+	notices := fileData.notices[uid]
+	weirds := fileData.weirds[uid]
+	if connection == nil && len(requests)+len(sessions)+len(notices)+len(weirds) == 0 {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+
+		return
+	}
+	if requests == nil {
+		requests = []models.HTTPRequest{}
+	}
+	if sessions == nil {
+		sessions = []models.TLSSession{}
+	}
+	if notices == nil {
+		notices = []models.Notice{}
+	}
+	if weirds == nil {
+		weirds = []models.Weird{}
+	}
+
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"uid":        uid,
+		"connection": connection,
+		"http":       requests,
+		"ssl":        sessions,
+		"notice":     notices,
+		"weird":      weirds,
+	})
+	if err != nil {
+		log.Printf("Failed to encode connection details: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAttachingProtocolLogChangesETag(t *testing.T) {
+	api, mux := newDemoServer(t)
+	cache := &memoryCache{}
+	api.SetCache(cache)
+
+	first := serve(mux, http.MethodGet, "/api/analysis/tls", "", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /api/analysis/tls: status %d, ETag %q", first.Code, etag)
+	}
+	if cached := serve(mux, http.MethodGet, "/api/analysis/tls", "", nil); cached.Header().Get(cacheHeader) != "HIT" {
+		t.Fatalf("repeated request: %s %s, want HIT", cacheHeader, cached.Header().Get(cacheHeader))
+	}
+
+	ssl := `{"ts":1704103236.4,"uid":"CjeyxG4jDPMRCxGgcj","id.orig_h":"10.0.0.235","id.orig_p":61384,"id.resp_h":"198.51.100.1","id.resp_p":443,` +
+		`"version":"TLSv13","cipher":"TLS_AES_128_GCM_SHA256","server_name":"example.com","established":true,"ja3":"773906b0efdefa24a7f2b8eb6985bf37"}` + "\n"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, uploadRequest(t, "ssl.log", []byte(ssl)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("uploading ssl.log: status %d: %s", w.Code, w.Body)
+	}
+
+	after := serve(mux, http.MethodGet, "/api/analysis/tls", "", http.Header{"If-None-Match": {etag}})
+	if after.Code != http.StatusOK || after.Header().Get("ETag") == etag {
+		t.Fatalf("revalidation after attaching ssl.log: status %d, ETag %q", after.Code, after.Header().Get("ETag"))
+	}
+	if after.Header().Get(cacheHeader) != "MISS" {
+		t.Errorf("%s: %s after attaching ssl.log, want MISS", cacheHeader, after.Header().Get(cacheHeader))
+	}
+	if !strings.Contains(after.Body.String(), "773906b0efdefa24a7f2b8eb6985bf37") {
+		t.Errorf("TLS fingerprints lack the attached session: %s", after.Body)
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the pure-Go "sqlite" database/sql driver
+
+	"zeek-viz/models"
+)
+
+const (
+	defaultQueryRowLimit = 1000             // Default number of rows returned by /api/query
+	maxQueryRowLimit     = 10000            // Upper bound on the row limit a client may request
+	queryTimeout         = 10 * time.Second // Maximum execution time of a single query
+	maxQueryBodySize     = 64 << 10         // 64KB
+	millisPerSecond      = 1000.0
+)
+
+var (
+	errNoDataset        = errors.New("no file loaded")
+	errQueryRequired    = errors.New("sql is required")
+	errQueryNotReadOnly = errors.New("only a single SELECT or WITH statement is allowed")
+)
+
+// connectionsTableSchema defines the SQL view of the connection log.
+const connectionsTableSchema = `CREATE TABLE connections (
+	ts REAL, uid TEXT,
+	orig_h TEXT, orig_p INTEGER, resp_h TEXT, resp_p INTEGER,
+	proto TEXT, service TEXT, duration REAL,
+	orig_bytes INTEGER, resp_bytes INTEGER, conn_state TEXT,
+	local_orig INTEGER, local_resp INTEGER, missed_bytes INTEGER, history TEXT,
+	orig_pkts INTEGER, orig_ip_bytes INTEGER, resp_pkts INTEGER, resp_ip_bytes INTEGER,
+	ip_proto INTEGER, source_file TEXT, service_guess TEXT, stitched INTEGER
+)`
+
+// connectionsInsert inserts one row into the connections table.
+const connectionsInsert = `INSERT INTO connections VALUES
+	(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// queryResult is the response of a SQL query.
+type queryResult struct {
+	Columns   []string       `json:"columns"`
+	Rows      [][]any        `json:"rows"`
+	RowCount  int            `json:"row_count"` //nolint:tagliatelle // API consistency
+	Truncated bool           `json:"truncated"`
+	Limits    map[string]int `json:"limits"`
+	ElapsedMS float64        `json:"elapsed_ms"` //nolint:tagliatelle // API consistency
+}
+
+// QueryConnections runs a read-only SQL query against the current file's connections.
+// The data is exposed as a single table named "connections".
+func (a *API) QueryConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		SQL   string `json:"sql"`
+		Limit int    `json:"limit"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		request.SQL = r.URL.Query().Get("sql")
+		request.Limit = parseLimit(r.URL.Query(), "limit")
+	case http.MethodPost:
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBodySize)).Decode(&request)
+		if err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	fileData := a.files[a.currentDataset(r)]
+	if fileData == nil {
+		http.Error(w, errNoDataset.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultQueryRowLimit
+	}
+	limit = min(limit, maxQueryRowLimit)
+
+	statement, err := validateReadOnlyQuery(request.SQL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db, err := fileData.sqlDatabase()
+	if err != nil {
+		log.Printf("Failed to build SQL view: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	result, err := runQuery(ctx, db, statement, limit)
+	if err != nil {
+		http.Error(w, "Query failed: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		log.Printf("Failed to encode query result: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// validateReadOnlyQuery ensures the statement is a single SELECT/WITH query.
+func validateReadOnlyQuery(statement string) (string, error) {
+	statement = strings.TrimSpace(statement)
+	statement = strings.TrimSpace(strings.TrimRight(statement, ";"))
+	if statement == "" {
+		return "", errQueryRequired
+	}
+
+	if hasStatementSeparator(statement) {
+		return "", errQueryNotReadOnly
+	}
+
+	firstWord := strings.ToUpper(strings.Fields(statement)[0])
+	if firstWord != "SELECT" && firstWord != "WITH" {
+		return "", errQueryNotReadOnly
+	}
+
+	return statement, nil
+}
+
+// hasStatementSeparator reports whether the statement holds a ';' outside its string literals
+// and quoted identifiers, within which a quote is escaped by doubling it.
+func hasStatementSeparator(statement string) bool {
+	var closing byte // Quote ending the literal the scan is in, or 0 outside of one
+	for i := range len(statement) {
+		switch c := statement[i]; {
+		case closing != 0:
+			if c == closing {
+				closing = 0 // A doubled quote reopens the literal on the next byte
+			}
+		case c == '\'' || c == '"' || c == '`':
+			closing = c
+		case c == '[':
+			closing = ']'
+		case c == ';':
+			return true
+		}
+	}
+
+	return false
+}
+
+// runQuery executes the statement and collects up to limit rows.
+func runQuery(ctx context.Context, db *sql.DB, statement string, limit int) (*queryResult, error) {
+	started := time.Now()
+
+	rows, err := db.QueryContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &queryResult{
+		Columns: columns,
+		Rows:    make([][]any, 0),
+		Limits:  map[string]int{"limit": limit},
+	}
+
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+
+			break
+		}
+
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, value := range values {
+			if raw, ok := value.([]byte); ok {
+				values[i] = string(raw)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	result.RowCount = len(result.Rows)
+	result.ElapsedMS = float64(time.Since(started).Microseconds()) / millisPerSecond
+
+	return result, nil
+}
+
+// sqlDatabase returns the file's in-memory SQL view, building it on first use.
+func (f *FileData) sqlDatabase() (*sql.DB, error) {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+
+	if f.sqlDB != nil {
+		return f.sqlDB, nil
+	}
+
+	db, err := buildSQLDatabase(f.Connections)
+	if err != nil {
+		return nil, err
+	}
+	f.sqlDB = db
+
+	return db, nil
+}
+
+// closeSQLDatabase releases the in-memory SQL view, if any. Callers must hold cacheMu.
+func (f *FileData) closeSQLDatabase() {
+	if f.sqlDB == nil {
+		return
+	}
+
+	err := f.sqlDB.Close()
+	if err != nil {
+		log.Printf("Failed to close SQL view: %v", err)
+	}
+	f.sqlDB = nil
+}
+
+// buildSQLDatabase loads connections into a private in-memory SQLite database
+// and switches it to query-only mode.
+func buildSQLDatabase(connections []models.Connection) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQL view: %w", err)
+	}
+
+	// Every connection to ":memory:" is a separate database, so pin the pool to one
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	err = loadSQLDatabase(db, connections)
+	if err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	_, err = db.Exec("PRAGMA query_only = ON")
+	if err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("failed to enable query-only mode: %w", err)
+	}
+
+	return db, nil
+}
+
+// loadSQLDatabase creates the connections table and inserts all connections in one transaction.
+func loadSQLDatabase(db *sql.DB, connections []models.Connection) error {
+	_, err := db.Exec(connectionsTableSchema)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	insert, err := tx.Prepare(connectionsInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer insert.Close()
+
+	for i := range connections {
+		c := &connections[i]
+		_, err = insert.Exec(
+			c.Timestamp, c.UID,
+			c.OrigHost, c.OrigPort, c.RespHost, c.RespPort,
+			c.Protocol, c.Service, c.Duration,
+			c.OrigBytes, c.RespBytes, c.ConnState,
+			c.LocalOrig, c.LocalResp, c.MissedBytes, c.History,
+			c.OrigPackets, c.OrigIPBytes, c.RespPackets, c.RespIPBytes,
+			c.IPProtocol, c.SourceFile, c.ServiceGuess, c.Stitched,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert connection: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      string
+		err       error
+	}{
+		{"SELECT * FROM connections;", "SELECT * FROM connections", nil},
+		{"  with t as (select 1) select * from t ;; ", "with t as (select 1) select * from t", nil},
+		{"SELECT uid FROM connections WHERE history = 'a;b'", "SELECT uid FROM connections WHERE history = 'a;b'", nil},
+		{"SELECT 'it''s; fine', \"a;b\", [c;d], `e;f`", "SELECT 'it''s; fine', \"a;b\", [c;d], `e;f`", nil},
+		{"SELECT 1; DROP TABLE connections", "", errQueryNotReadOnly},
+		{"SELECT 'a;b'; DELETE FROM connections", "", errQueryNotReadOnly},
+		{"SELECT 'it''s'; DELETE FROM connections", "", errQueryNotReadOnly},
+		{"DELETE FROM connections WHERE uid = 'x'", "", errQueryNotReadOnly},
+		{" ; ", "", errQueryRequired},
+	}
+	for _, test := range tests {
+		statement, err := validateReadOnlyQuery(test.statement)
+		if statement != test.want || !errors.Is(err, test.err) {
+			t.Errorf("validateReadOnlyQuery(%q) = %q, %v; want %q, %v", test.statement, statement, err, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"zeek-viz/connstats"
+)
+
+const (
+	quotaDatasets = "dataset_quota" // Code of uploads beyond the number of stored datasets
+	quotaStorage  = "storage_quota" // Code of uploads beyond the bytes stored datasets may total
+)
+
+// quotaError rejects content that would take the stored datasets beyond the server's quota.
+type quotaError struct {
+	Success     bool   `json:"success"`
+	Code        string `json:"error"`
+	Message     string `json:"message"`
+	Datasets    int    `json:"datasets"`               // Stored datasets, with the rejected ones
+	MaxDatasets int    `json:"max_datasets,omitempty"` //nolint:tagliatelle // API consistency
+	Bytes       int64  `json:"bytes"`                  // Bytes of the stored datasets, with the rejected ones
+	MaxBytes    int64  `json:"max_bytes,omitempty"`    //nolint:tagliatelle // API consistency
+}
+
+// Error returns the message of the rejection.
+func (e *quotaError) Error() string {
+	return e.Message
+}
+
+// SetDatasetQuota limits the datasets the server stores to maxDatasets and the bytes of
+// their uploaded logs to maxBytes; zero disables a limit. Uploads, merges, replacements, and
+// snapshot imports beyond the quota are rejected with 413. Live datasets don't count.
+func (a *API) SetDatasetQuota(maxDatasets int, maxBytes int64) {
+	a.maxStored, a.storageQuota = max(maxDatasets, 0), max(maxBytes, 0)
+}
+
+// checkQuota returns a *quotaError when storing the datasets of sizes, by file ID, would
+// exceed the quota. Datasets stored under those IDs are replaced; with keepOthers unset, all
+// other datasets are too. Callers must hold a.mu.
+func (a *API) checkQuota(sizes map[string]int64, keepOthers bool) error {
+	if a.maxStored == 0 && a.storageQuota == 0 {
+		return nil
+	}
+
+	datasets, bytes := a.quotaUsage(sizes, keepOthers)
+	for _, size := range sizes {
+		datasets++
+		bytes += size
+	}
+
+	switch {
+	case a.maxStored > 0 && datasets > a.maxStored:
+		return &quotaError{
+			Code:        quotaDatasets,
+			Message:     fmt.Sprintf("the server stores at most %d datasets; delete some before uploading more", a.maxStored),
+			Datasets:    datasets,
+			MaxDatasets: a.maxStored,
+			Bytes:       bytes,
+			MaxBytes:    a.storageQuota,
+		}
+	case a.storageQuota > 0 && bytes > a.storageQuota:
+		return &quotaError{
+			Code: quotaStorage,
+			Message: fmt.Sprintf("stored datasets are limited to %s and would take %s; delete some before uploading more",
+				connstats.HumanizeBytes(float64(a.storageQuota)), connstats.HumanizeBytes(float64(bytes))),
+			Datasets:    datasets,
+			MaxDatasets: a.maxStored,
+			Bytes:       bytes,
+			MaxBytes:    a.storageQuota,
+		}
+	default:
+		return nil
+	}
+}
+
+// quotaUsage returns the number and bytes of the stored datasets that count towards the
+// quota, leaving out those sizes replaces, or all of them without keepOthers. Callers must
+// hold a.mu.
+func (a *API) quotaUsage(sizes map[string]int64, keepOthers bool) (int, int64) {
+	if !keepOthers {
+		return 0, 0
+	}
+
+	datasets, bytes := 0, int64(0)
+	for fileID, fileData := range a.files {
+		if _, replaced := sizes[fileID]; replaced || a.isLiveDataset(fileID) {
+			continue
+		}
+		datasets++
+		bytes += fileData.Size
+	}
+
+	return datasets, bytes
+}
+
+// quotaStatus describes the quota and its use for file listings, or returns nil without one.
+// Callers must hold a.mu.
+func (a *API) quotaStatus() map[string]any {
+	if a.maxStored == 0 && a.storageQuota == 0 {
+		return nil
+	}
+
+	datasets, bytes := a.quotaUsage(nil, true)
+	status := map[string]any{"datasets": datasets, "bytes": bytes}
+	if a.maxStored > 0 {
+		status["max_datasets"] = a.maxStored
+	}
+	if a.storageQuota > 0 {
+		status["max_bytes"] = a.storageQuota
+	}
+
+	return status
+}
+
+// writeQuotaError sends a quota rejection as a JSON 413 response.
+func writeQuotaError(w http.ResponseWriter, quotaErr *quotaError) {
+	log.Printf("Rejected upload: %s", quotaErr.Message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+	err := json.NewEncoder(w).Encode(quotaErr)
+	if err != nil {
+		log.Printf("Failed to encode quota error: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rateLimitSweep = time.Minute // How often the buckets of idle clients are dropped
+
+// rateLimiter hands out requests per client address from token buckets that refill at rate
+// per second up to burst.
+type rateLimiter struct {
+	perMinute int     // Requests allowed a minute
+	rate      float64 // Tokens added per second
+	burst     float64 // Tokens a bucket holds at most
+	header    string  // Request header naming the client, appended to by trusted reverse proxies
+	hops      int     // Trusted proxies appending to header; the client is the address they saw
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is the remaining allowance of one client.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// SetRateLimit allows each client address perMinute API requests a minute, in bursts of up
+// to burst requests (default perMinute); zero disables the limit. Clients are told apart by
+// the address they connect from or, behind trusted reverse proxies that append to a header
+// such as X-Forwarded-For, by the address the outermost of them saw: the hops-th address
+// from the right of header (default 1, the rightmost). Addresses left of it are set by the
+// client and can't be trusted.
+func (a *API) SetRateLimit(perMinute, burst int, header string, hops int) {
+	if perMinute <= 0 {
+		a.limiter = nil
+
+		return
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	if hops <= 0 {
+		hops = 1
+	}
+	a.limiter = &rateLimiter{
+		perMinute: perMinute,
+		rate:      float64(perMinute) / time.Minute.Seconds(),
+		burst:     float64(burst),
+		header:    header,
+		hops:      hops,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// RateLimited answers /api/ requests of clients beyond the rate limit with 429 and a JSON
+// error telling them when to retry. The UI page, static assets, /health, and /metrics aren't
+// limited.
+func (a *API) RateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.limiter == nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		client := a.limiter.client(r)
+		allowed, remaining, retry := a.limiter.take(client, time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(a.limiter.perMinute))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if allowed {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		log.Printf("Rate limited %s %s from %s", r.Method, r.URL.Path, client)
+		if strings.HasPrefix(r.URL.Path, v1Prefix+"/") {
+			writer := &v1Writer{ResponseWriter: w}
+			a.writeRateLimited(writer, retry)
+			writer.finish()
+
+			return
+		}
+		a.writeRateLimited(w, retry)
+	})
+}
+
+// writeRateLimited sends the 429 response of a rate-limited request.
+func (a *API) writeRateLimited(w http.ResponseWriter, retry time.Duration) {
+	seconds := int(math.Ceil(retry.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"success":     false,
+		"error":       "rate_limited",
+		"message":     fmt.Sprintf("too many requests: the limit is %d a minute per client; retry in %d seconds", a.limiter.perMinute, seconds),
+		"limit":       a.limiter.perMinute,
+		"burst":       int(a.limiter.burst),
+		"retry_after": seconds,
+	})
+	if err != nil {
+		log.Printf("Failed to encode rate limit response: %v", err)
+	}
+}
+
+// client returns the address the request is counted against: the address the outermost
+// trusted proxy appended to the header, or the connecting address without a header or
+// when the entry isn't an IP address. A header with fewer entries than trusted hops
+// yields its leftmost one, which a trusted proxy still wrote.
+func (l *rateLimiter) client(r *http.Request) string {
+	if l.header != "" {
+		var forwarded []string
+		for _, value := range r.Header.Values(l.header) {
+			forwarded = append(forwarded, strings.Split(value, ",")...)
+		}
+		if len(forwarded) > 0 {
+			entry := strings.TrimSpace(forwarded[max(len(forwarded)-l.hops, 0)])
+			if addr, err := netip.ParseAddr(entry); err == nil {
+				return addr.Unmap().String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// take spends a token of client's bucket. It reports whether one was left, the whole tokens
+// remaining, and otherwise how long until the next one.
+func (l *rateLimiter) take(client string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	bucket := l.buckets[client]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		missing := 1 - bucket.tokens
+
+		return false, 0, time.Duration(missing / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+
+	return true, int(bucket.tokens), 0
+}
+
+// sweep drops the buckets of clients that have been idle long enough to refill, so the map
+// doesn't grow with every address seen. Callers must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweep {
+		return
+	}
+	l.lastSweep = now
+
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// rateLimitedRequest sends a request through the rate limiter from RemoteAddr 10.0.0.1, the
+// reverse proxy, with the given X-Forwarded-For, and returns the status.
+func rateLimitedRequest(api *API, forwarded string) int {
+	handler := api.RateLimited(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	r.RemoteAddr = "10.0.0.1:40000"
+	if forwarded != "" {
+		r.Header.Set("X-Forwarded-For", forwarded)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestRateLimitIgnoresSpoofedForwardedEntries(t *testing.T) {
+	api := NewAPI("")
+	api.SetRateLimit(3, 3, "X-Forwarded-For", 1)
+
+	// The client rotates the entries it sends; the proxy appends the address it saw.
+	for i := range 3 {
+		forwarded := fmt.Sprintf("198.51.100.%d, 203.0.113.9", i)
+		if status := rateLimitedRequest(api, forwarded); status != http.StatusNoContent {
+			t.Fatalf("request %d: status %d, want %d", i, status, http.StatusNoContent)
+		}
+	}
+	if status := rateLimitedRequest(api, "198.51.100.99, 203.0.113.9"); status != http.StatusTooManyRequests {
+		t.Errorf("spoofed leading entry: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+	if buckets := len(api.limiter.buckets); buckets != 1 {
+		t.Errorf("%d buckets, want 1 for the one client", buckets)
+	}
+
+	// Another client behind the same proxy has its own allowance.
+	if status := rateLimitedRequest(api, "203.0.113.10"); status != http.StatusNoContent {
+		t.Errorf("other client: status %d, want %d", status, http.StatusNoContent)
+	}
+}
+
+func TestRateLimitClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		hops      int
+		forwarded string
+		want      string
+	}{
+		{"rightmost entry", 1, "198.51.100.1, 203.0.113.9", "203.0.113.9"},
+		{"second proxy", 2, "198.51.100.1, 203.0.113.9, 192.0.2.1", "203.0.113.9"},
+		{"fewer entries than hops", 3, "203.0.113.9, 192.0.2.1", "203.0.113.9"},
+		{"mapped IPv4", 1, "::ffff:203.0.113.9", "203.0.113.9"},
+		{"not an address", 1, "198.51.100.1, unknown", "10.0.0.1"},
+		{"no header", 1, "", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := &rateLimiter{header: "X-Forwarded-For", hops: test.hops}
+			r := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+			r.RemoteAddr = "10.0.0.1:40000"
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if client := limiter.client(r); client != test.want {
+				t.Errorf("client = %q, want %q", client, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	defaultRDNSConcurrency = 8                       // Concurrent PTR lookups when none is configured
+	defaultRDNSTTL         = time.Hour               // How long resolved hostnames are cached
+	rdnsNegativeTTL        = 5 * time.Minute         // How long failed lookups are cached
+	rdnsLookupTimeout      = 3 * time.Second         // Limit of a single PTR lookup
+	rdnsRequestWait        = 1500 * time.Millisecond // Time a request waits for lookups before responding
+	maxRDNSEntries         = 100000                  // Cached addresses before expired entries are dropped
+)
+
+// rdnsEntry is a cached PTR lookup; an empty hostname records a failed one.
+type rdnsEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// resolver looks up the hostnames of node addresses. Lookups run in the background with
+// bounded concurrency and their results are cached, so a request that can't wait for a slow
+// lookup sees its result on the next refresh.
+type resolver struct {
+	slots  chan struct{} // Limits concurrent lookups
+	ttl    time.Duration
+	lookup func(ctx context.Context, addr string) ([]string, error) // PTR query, net.DefaultResolver's
+
+	mu         sync.Mutex
+	cache      map[string]rdnsEntry
+	pending    map[string]chan struct{} // Closed when the lookup of the address completes
+	generation int64                    // Lookups completed, so responses with hostnames change version
+}
+
+// SetReverseDNS enables hostname lookups for graph nodes, with at most concurrency PTR queries
+// in flight and hostnames cached for ttl. Zero values use the defaults.
+func (a *API) SetReverseDNS(concurrency int, ttl time.Duration) {
+	if concurrency <= 0 {
+		concurrency = defaultRDNSConcurrency
+	}
+	if ttl <= 0 {
+		ttl = defaultRDNSTTL
+	}
+
+	a.rdns = &resolver{
+		slots:   make(chan struct{}, concurrency),
+		ttl:     ttl,
+		lookup:  net.DefaultResolver.LookupAddr,
+		cache:   make(map[string]rdnsEntry),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+// annotateHostnames adds the hostnames of the nodes' addresses, waiting briefly for lookups
+// that aren't cached yet. When some are still running, the response is marked partial so it
+// isn't shared (see Cached). The nodes are a copy; without reverse DNS they are returned
+// unchanged.
+func (a *API) annotateHostnames(ctx context.Context, nodes []models.Node) []models.Node {
+	if a.rdns == nil {
+		return nodes
+	}
+
+	addrs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		addrs = append(addrs, node.ID)
+	}
+	hostnames, complete := a.rdns.resolve(ctx, addrs)
+	if !complete {
+		markPartialResponse(ctx)
+	}
+
+	nodes = slices.Clone(nodes)
+	for i := range nodes {
+		nodes[i].Hostname = hostnames[nodes[i].ID]
+	}
+
+	return nodes
+}
+
+// resolve returns the hostnames of the addresses known by the time ctx is done or
+// rdnsRequestWait has passed, and whether all lookups had completed by then. Values that
+// aren't IP addresses, such as subnets, are skipped.
+func (r *resolver) resolve(ctx context.Context, addrs []string) (map[string]string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, rdnsRequestWait)
+	defer cancel()
+
+	hostnames := make(map[string]string, len(addrs))
+	var waiting []string
+	r.mu.Lock()
+	for _, addr := range addrs {
+		if _, err := netip.ParseAddr(addr); err != nil {
+			continue
+		}
+		if entry, cached := r.cache[addr]; cached && time.Now().Before(entry.expires) {
+			hostnames[addr] = entry.hostname
+		} else {
+			waiting = append(waiting, addr)
+			r.start(addr)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, addr := range waiting {
+		r.mu.Lock()
+		done := r.pending[addr]
+		r.mu.Unlock()
+		if done != nil {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return hostnames, false // The remaining lookups finish in the background
+			}
+		}
+
+		r.mu.Lock()
+		hostnames[addr] = r.cache[addr].hostname
+		r.mu.Unlock()
+	}
+
+	return hostnames, true
+}
+
+// version returns the number of lookups completed, which changes whenever hostnames become
+// known or expire; 0 without reverse DNS.
+func (r *resolver) version() int64 {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.generation
+}
+
+// start looks up addr in the background unless a lookup is already running. Callers must
+// hold mu.
+func (r *resolver) start(addr string) {
+	if r.pending[addr] != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	r.pending[addr] = done
+	go func() {
+		defer close(done)
+
+		r.slots <- struct{}{}
+		ctx, cancel := context.WithTimeout(context.Background(), rdnsLookupTimeout)
+		names, err := r.lookup(ctx, addr)
+		cancel()
+		<-r.slots
+
+		entry := rdnsEntry{expires: time.Now().Add(rdnsNegativeTTL)}
+		if err == nil && len(names) > 0 {
+			entry = rdnsEntry{hostname: strings.TrimSuffix(names[0], "."), expires: time.Now().Add(r.ttl)}
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.pending, addr)
+		r.generation++
+		if len(r.cache) >= maxRDNSEntries {
+			r.evict()
+		}
+		r.cache[addr] = entry
+	}()
+}
+
+// evict drops expired entries, or the whole cache when none have expired. Callers must hold mu.
+func (r *resolver) evict() {
+	now := time.Now()
+	for addr, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, addr)
+		}
+	}
+	if len(r.cache) >= maxRDNSEntries {
+		clear(r.cache)
+	}
+}
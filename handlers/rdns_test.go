@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryCache is a store.Cache in a map.
+type memoryCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, found := c.values[key]
+
+	return value, found, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.values == nil {
+		c.values = make(map[string][]byte)
+	}
+	c.values[key] = value
+
+	return nil
+}
+
+func (c *memoryCache) Close() error { return nil }
+
+// responses returns the number of cached responses.
+func (c *memoryCache) responses() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for key := range c.values {
+		if strings.HasPrefix(key, "response:") {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestPendingHostnamesAreNotShared(t *testing.T) {
+	api, mux := newDemoServer(t)
+	cache := &memoryCache{}
+	api.SetCache(cache)
+	api.SetReverseDNS(0, 0)
+	release := make(chan struct{})
+	api.rdns.lookup = func(ctx context.Context, addr string) ([]string, error) {
+		select {
+		case <-release:
+			return []string{"host-" + strings.ReplaceAll(addr, ":", "-") + ".example."}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	nodes := func(header http.Header) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/nodes?limit=5", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	partial := nodes(nil)
+	if partial.Code != http.StatusOK || strings.Contains(partial.Body.String(), "host-") {
+		t.Fatalf("response during lookups: status %d, hostnames %t", partial.Code, strings.Contains(partial.Body.String(), "host-"))
+	}
+	if cached := cache.responses(); cached != 0 {
+		t.Errorf("%d responses cached while hostname lookups were pending, want 0", cached)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		api.rdns.mu.Lock()
+		pending := len(api.rdns.pending)
+		api.rdns.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hostname lookups didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	complete := nodes(http.Header{"If-None-Match": {partial.Header().Get("ETag")}})
+	if complete.Code != http.StatusOK {
+		t.Fatalf("revalidating the partial response: status %d, want %d", complete.Code, http.StatusOK)
+	}
+	if !strings.Contains(complete.Body.String(), "host-") {
+		t.Error("response after the lookups finished lacks hostnames")
+	}
+	if complete.Header().Get(cacheHeader) != "MISS" {
+		t.Errorf("%s: %s, want MISS", cacheHeader, complete.Header().Get(cacheHeader))
+	}
+
+	repeated := nodes(nil)
+	if repeated.Header().Get(cacheHeader) != "HIT" || !strings.Contains(repeated.Body.String(), "host-") {
+		t.Errorf("repeated request: %s %s, hostnames %t", cacheHeader, repeated.Header().Get(cacheHeader), strings.Contains(repeated.Body.String(), "host-"))
+	}
+}
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const (
+	reportPrefix         = "zeek-viz-report" // Filename prefix of reports
+	reportTimeFormat     = "2006-01-02 15:04:05 MST"
+	reportAnomalies      = 10                   // Timeline anomalies listed at most
+	reportTimelineWidth  = 960.0                // Width of the timeline image
+	reportTimelineHeight = 180.0                // Height of the timeline image
+	reportTimelineAxis   = 20.0                 // Space below the bars for time labels
+	reportBarColor       = "#1565c0"            // Fill of timeline bars
+	reportSpikeColor     = "#c62828"            // Fill of anomalous timeline bars
+	reportPercent        = 100                  // Shares are shown as percentages
+	reportTemplatePath   = "report/report.html" // Path of the page template in reportFS
+)
+
+// reportFS holds the page template of /api/report.
+//
+//go:embed report/report.html
+var reportFS embed.FS
+
+// reportFilter is a filter parameter the report was narrowed by.
+type reportFilter struct {
+	Name  string
+	Value string
+}
+
+// reportRow is a ranked host, port, protocol, or service of a report table.
+type reportRow struct {
+	Label       string
+	Connections string
+	Bytes       string
+	Share       string
+}
+
+// reportTable is a titled ranking of a report.
+type reportTable struct {
+	Title string
+	Label string // Heading of the ranked column
+	Rows  []reportRow
+}
+
+// reportState is a connection state with its meaning and share.
+type reportState struct {
+	Code        string
+	Description string
+	Connections string
+	Share       string
+	Failed      bool // A TCP attempt that was never established
+}
+
+// reportAnomaly is a timeline bucket deviating from the ones before it.
+type reportAnomaly struct {
+	Start     string
+	Direction string
+	Count     string
+	Baseline  string
+	Score     string
+}
+
+// reportScan is a port scan or host sweep.
+type reportScan struct {
+	Src     string
+	Type    string
+	Target  string
+	Targets int
+	Failed  string
+	Window  string
+}
+
+// reportData is what the report template renders.
+type reportData struct {
+	Title             string
+	Dataset           string
+	Filename          string
+	FileID            string
+	SHA256            string
+	CaseNumber        string
+	Description       string
+	Generated         string
+	Filters           []reportFilter
+	Connections       string
+	Bytes             string
+	Hosts             string
+	Duration          string
+	Start             string
+	End               string
+	BucketSize        string
+	Timeline          template.HTML
+	Anomalies         []reportAnomaly
+	Graph             template.HTML
+	GraphNote         string
+	Top               []reportTable
+	Breakdowns        []reportTable
+	ConnStates        []reportState
+	FailedConnections string
+	FailedShare       string
+	FailedOriginators []reportRow
+	Scans             []reportScan
+}
+
+// GetReport renders a self-contained HTML summary of the current dataset for attaching to an
+// incident ticket: totals, the timeline with its anomalies, the graph of the busiest hosts,
+// top talkers, protocols and services, connection states with the originators of failed
+// attempts, and scans. Images are inline SVG and nothing is loaded from elsewhere; printing
+// the page from a browser gives a PDF. Accepts the standard filters, tz, bucket, and n, the
+// rows of each ranking.
+func (a *API) GetReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	n := parseLimit(query, "n")
+	if n == 0 {
+		n = defaultTopN
+	}
+
+	fileID := a.currentDataset(r)
+	fileData := a.files[fileID]
+	if fileData == nil {
+		http.Error(w, "No file selected", http.StatusNotFound)
+
+		return
+	}
+	connections, err := a.filterFile(fileData, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	bucketSize, err := parseTimelineBucket(query, connections)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var timeline *models.TimelineData
+	if isUnfiltered(query) {
+		timeline = fileData.timeline(bucketSize, loc) // Includes rolled-up live history
+	} else {
+		timeline = buildTimeline(connections, bucketSize, loc)
+		scoreTimeline(timeline, loc)
+	}
+
+	data, err := a.buildReport(fileID, fileData, connections, timeline, loc, n)
+	if err != nil {
+		log.Printf("Failed to build report: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	for _, param := range filterParams() {
+		if value := query.Get(param); value != "" {
+			data.Filters = append(data.Filters, reportFilter{Name: param, Value: value})
+		}
+	}
+
+	page, err := template.ParseFS(reportFS, reportTemplatePath)
+	if err != nil {
+		log.Printf("Failed to parse report template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	var rendered bytes.Buffer
+	err = page.Execute(&rendered, data)
+	if err != nil {
+		log.Printf("Failed to render report: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s-%s.html", reportPrefix, fileID, time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+
+	_, err = w.Write(rendered.Bytes())
+	if err != nil {
+		log.Printf("Error writing report: %v", err)
+	}
+}
+
+// buildReport summarizes the connections of a dataset and their scored timeline for the
+// report template.
+func (a *API) buildReport(fileID string, fileData *FileData, connections []models.Connection, timeline *models.TimelineData, loc *time.Location, n int) (*reportData, error) {
+	stats := connstats.Compute(connections)
+	data := &reportData{
+		Title:       a.Config().InstanceName,
+		Dataset:     cmp.Or(fileData.Name, fileData.Filename),
+		Filename:    fileData.Filename,
+		FileID:      fileID,
+		SHA256:      fileData.SHA256,
+		CaseNumber:  fileData.CaseNumber,
+		Description: fileData.Description,
+		Generated:   reportTime(float64(time.Now().Unix()), loc),
+		Connections: connstats.HumanizeCount(stats.TotalConnections),
+		Bytes:       connstats.HumanizeBytes(float64(stats.TotalBytes)),
+		Hosts:       connstats.HumanizeCount(stats.UniqueIPCount()),
+		Duration:    connstats.HumanizeDuration(max(0, stats.Duration())),
+		Start:       reportTime(stats.StartTime, loc),
+		End:         reportTime(stats.EndTime, loc),
+		BucketSize:  connstats.HumanizeDuration(float64(timeline.BucketSize)),
+	}
+
+	var image bytes.Buffer
+	renderTimelineSVG(&image, timeline, loc)
+	data.Timeline = template.HTML(image.String()) //nolint:gosec // Rendered with escaped labels
+	data.Anomalies = reportTimelineAnomalies(timeline, loc)
+
+	graph := evidenceGraph(connections, a.localNetworks)
+	image.Reset()
+	renderGraphSVG(&image, graph)
+	data.Graph = template.HTML(image.String()) //nolint:gosec // Rendered with escaped labels
+	data.GraphNote = fmt.Sprintf("The %d busiest of %d hosts by bytes, with the edges between them.",
+		min(evidenceImageNodes, graph.TotalNodes), graph.TotalNodes)
+
+	dimensions := topDimensions()
+	for _, ranking := range []struct{ by, title, label string }{
+		{"src", "Sources", "Originator"},
+		{"dst", "Destinations", "Responder"},
+		{"port", "Ports", "Port"},
+	} {
+		entries, _, err := rankTop(connections, dimensions[ranking.by], "bytes", n)
+		if err != nil {
+			return nil, err
+		}
+		table := reportTable{Title: ranking.title, Label: ranking.label, Rows: make([]reportRow, 0, len(entries))}
+		for _, entry := range entries {
+			label := entry.Key[ranking.by]
+			if ranking.by == "port" {
+				label += "/" + entry.Key["proto"]
+			}
+			table.Rows = append(table.Rows, reportRow{
+				Label:       label,
+				Connections: connstats.HumanizeCount(entry.Connections),
+				Bytes:       connstats.HumanizeBytes(float64(entry.Bytes)),
+				Share:       reportShare(entry.Share),
+			})
+		}
+		data.Top = append(data.Top, table)
+	}
+
+	data.Breakdowns = []reportTable{
+		{Title: "Protocols", Label: "Protocol", Rows: reportCounts(stats.Protocols, stats.TotalConnections, n)},
+		{Title: "Services", Label: "Service", Rows: reportCounts(stats.Services, stats.TotalConnections, n)},
+	}
+
+	failed := 0
+	for _, state := range connstats.ConnStateDescriptions(stats.ConnStates) {
+		code, _ := state["code"].(string)
+		count, _ := state["count"].(int)
+		isFailed := slices.Contains(models.FailedStates(), code)
+		if isFailed {
+			failed += count
+		}
+		data.ConnStates = append(data.ConnStates, reportState{
+			Code:        code,
+			Description: connstats.ConnStateDescription(code),
+			Connections: connstats.HumanizeCount(count),
+			Share:       reportShare(float64(count) / float64(max(1, stats.TotalConnections))),
+			Failed:      isFailed,
+		})
+	}
+	data.FailedConnections = connstats.HumanizeCount(failed)
+	data.FailedShare = reportShare(float64(failed) / float64(max(1, stats.TotalConnections)))
+
+	originators := make(map[string]int)
+	for i := range connections {
+		if slices.Contains(models.FailedStates(), connections[i].ConnState) {
+			originators[connections[i].OrigHost]++
+		}
+	}
+	data.FailedOriginators = reportCounts(originators, failed, n)
+
+	data.Scans = a.reportScans(connections, loc, n)
+
+	return data, nil
+}
+
+// reportCounts ranks the values of a count map, the largest first, as rows of a report table.
+func reportCounts(counts map[string]int, total, n int) []reportRow {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+
+		return values[i] < values[j]
+	})
+
+	rows := make([]reportRow, 0, min(n, len(values)))
+	for _, value := range values[:min(n, len(values))] {
+		rows = append(rows, reportRow{
+			Label:       value,
+			Connections: connstats.HumanizeCount(counts[value]),
+			Share:       reportShare(float64(counts[value]) / float64(max(1, total))),
+		})
+	}
+
+	return rows
+}
+
+// reportTimelineAnomalies lists the buckets scoring at least the default minimum score of
+// /api/analysis/anomalies, the most deviating first.
+func reportTimelineAnomalies(timeline *models.TimelineData, loc *time.Location) []reportAnomaly {
+	scores := scoreBuckets(timeline.Points, timeline.BucketSize, loc, anomalyMetric(anomalyMetricCount))
+	scores = slices.DeleteFunc(scores, func(bucket bucketScore) bool {
+		return bucket.score == 0 || math.Abs(bucket.score) < defaultAnomalyMinScore
+	})
+	sort.SliceStable(scores, func(i, j int) bool {
+		return math.Abs(scores[i].score) > math.Abs(scores[j].score)
+	})
+
+	anomalies := make([]reportAnomaly, 0, min(reportAnomalies, len(scores)))
+	for _, bucket := range scores[:min(reportAnomalies, len(scores))] {
+		direction := "spike"
+		if bucket.score < 0 {
+			direction = "drop"
+		}
+		anomalies = append(anomalies, reportAnomaly{
+			Start:     reportTime(float64(bucket.timestamp), loc),
+			Direction: direction,
+			Count:     connstats.HumanizeCount(int(bucket.value)),
+			Baseline:  strconv.FormatFloat(bucket.baseline, 'f', 1, 64),
+			Score:     strconv.FormatFloat(bucket.score, 'f', 2, 64), //nolint:mnd // Two decimals
+		})
+	}
+
+	return anomalies
+}
+
+// reportScans lists the scans of the connections at the default thresholds of
+// /api/analysis/scans, leaving out suppressed ones.
+func (a *API) reportScans(connections []models.Connection, loc *time.Location, n int) []reportScan {
+	var scans []reportScan
+	for _, scan := range detectScans(connections, defaultScanThresholds()) {
+		if len(scans) == n {
+			break
+		}
+		if connectionSuppressed(a.suppressions, scanRule, scan.first) {
+			continue
+		}
+		target := scan.Dst
+		if scan.Type == scanHorizontal {
+			target = strconv.Itoa(scan.Port) + "/" + scan.Proto
+		}
+		scans = append(scans, reportScan{
+			Src:     scan.Src,
+			Type:    scan.Type,
+			Target:  target,
+			Targets: scan.Targets,
+			Failed:  reportShare(scan.FailedShare),
+			Window:  reportTime(scan.WindowStart, loc),
+		})
+	}
+
+	return scans
+}
+
+// renderTimelineSVG draws the connections per bucket as bars, anomalous buckets highlighted,
+// with the time of the first and last bucket below.
+func renderTimelineSVG(w io.Writer, timeline *models.TimelineData, loc *time.Location) {
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %[1]g %[2]g">`+"\n",
+		reportTimelineWidth, reportTimelineHeight)
+	defer fmt.Fprintln(w, "</svg>")
+
+	points := timeline.Points
+	if len(points) == 0 {
+		fmt.Fprintf(w, `<text x="%g" y="%g" font-family="sans-serif" font-size="12" text-anchor="middle">No connections</text>`+"\n",
+			reportTimelineWidth/2, reportTimelineHeight/2) //nolint:mnd // Centered
+
+		return
+	}
+
+	first := points[0].Timestamp
+	span := float64(points[len(points)-1].Timestamp + timeline.BucketSize - first)
+	barWidth := max(1, reportTimelineWidth*float64(timeline.BucketSize)/span-1)
+	highest := 1
+	for _, point := range points {
+		highest = max(highest, point.Count)
+	}
+
+	chart := reportTimelineHeight - reportTimelineAxis
+	for _, point := range points {
+		color := reportBarColor
+		if math.Abs(point.AnomalyScore) >= defaultAnomalyMinScore {
+			color = reportSpikeColor
+		}
+		height := max(1, chart*float64(point.Count)/float64(highest))
+		fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %d</title></rect>`+"\n",
+			reportTimelineWidth*float64(point.Timestamp-first)/span, chart-height, barWidth, height, color,
+			template.HTMLEscapeString(reportTime(float64(point.Timestamp), loc)), point.Count)
+	}
+
+	fmt.Fprintf(w, `<text x="0" y="%g" font-family="sans-serif" font-size="11">%s</text>`+"\n",
+		reportTimelineHeight-4, template.HTMLEscapeString(reportTime(float64(first), loc))) //nolint:mnd // Above the bottom edge
+	fmt.Fprintf(w, `<text x="%g" y="%g" font-family="sans-serif" font-size="11" text-anchor="end">%s</text>`+"\n",
+		reportTimelineWidth, reportTimelineHeight-4, //nolint:mnd // Above the bottom edge
+		template.HTMLEscapeString(reportTime(float64(points[len(points)-1].Timestamp+timeline.BucketSize), loc)))
+	fmt.Fprintf(w, `<text x="0" y="12" font-family="sans-serif" font-size="11">%s connections</text>`+"\n", connstats.HumanizeCount(highest))
+}
+
+// reportTime formats a Unix timestamp in the requested time zone, UTC without one.
+func reportTime(ts float64, loc *time.Location) string {
+	if ts < 0 {
+		return "-"
+	}
+
+	return time.Unix(int64(ts), 0).In(cmp.Or(loc, time.UTC)).Format(reportTimeFormat)
+}
+
+// reportShare formats a fraction as a percentage with one decimal.
+func reportShare(share float64) string {
+	return strconv.FormatFloat(share*reportPercent, 'f', 1, 64) + "%"
+}
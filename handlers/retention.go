@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"zeek-viz/connstats"
+)
+
+const (
+	retentionInterval = time.Minute // How often the janitor looks for expired datasets
+
+	expireAge   = "age"   // Expiry of datasets uploaded longer ago than the maximum age
+	expireCount = "count" // Expiry of the oldest datasets beyond the maximum number
+	expireSize  = "size"  // Expiry of the oldest datasets beyond the maximum total size
+)
+
+var errInvalidRetention = errors.New("retention limits must be non-negative, with max_age a duration such as 720h")
+
+// RetentionPolicy limits how long and how many datasets the server keeps. The janitor removes
+// datasets uploaded longer ago than MaxAge and the oldest ones beyond MaxDatasets or beyond
+// MaxBytes of uploaded logs, from memory, the store, and the search backend. Zero disables a
+// limit. Live datasets are kept, as they roll up their old connections themselves.
+type RetentionPolicy struct {
+	MaxAge      time.Duration
+	MaxDatasets int
+	MaxBytes    int64
+}
+
+// RetentionSettings is the retention policy as /api/settings shows and changes it.
+type RetentionSettings struct {
+	MaxAge      string `json:"max_age,omitempty"`      //nolint:tagliatelle // API consistency
+	MaxDatasets int    `json:"max_datasets,omitempty"` //nolint:tagliatelle // API consistency
+	MaxBytes    int64  `json:"max_bytes,omitempty"`    //nolint:tagliatelle // API consistency
+}
+
+// enabled reports whether the policy limits anything.
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxDatasets > 0 || p.MaxBytes > 0
+}
+
+// String describes the policy for logs.
+func (p RetentionPolicy) String() string {
+	var limits []string
+	if p.MaxAge > 0 {
+		limits = append(limits, "max age "+p.MaxAge.String())
+	}
+	if p.MaxDatasets > 0 {
+		limits = append(limits, fmt.Sprintf("at most %d datasets", p.MaxDatasets))
+	}
+	if p.MaxBytes > 0 {
+		limits = append(limits, "at most "+connstats.HumanizeBytes(float64(p.MaxBytes)))
+	}
+	if limits == nil {
+		return "no limits"
+	}
+
+	return strings.Join(limits, ", ")
+}
+
+// settings returns the policy as /api/settings shows it.
+func (p RetentionPolicy) settings() RetentionSettings {
+	settings := RetentionSettings{MaxDatasets: p.MaxDatasets, MaxBytes: p.MaxBytes}
+	if p.MaxAge > 0 {
+		settings.MaxAge = p.MaxAge.String()
+	}
+
+	return settings
+}
+
+// policy parses retention settings; omitted limits are off.
+func (s RetentionSettings) policy() (RetentionPolicy, error) {
+	policy := RetentionPolicy{MaxDatasets: s.MaxDatasets, MaxBytes: s.MaxBytes}
+	if s.MaxAge != "" {
+		maxAge, err := time.ParseDuration(s.MaxAge)
+		if err != nil {
+			return RetentionPolicy{}, errInvalidRetention
+		}
+		policy.MaxAge = maxAge
+	}
+	if policy.MaxAge < 0 || policy.MaxDatasets < 0 || policy.MaxBytes < 0 {
+		return RetentionPolicy{}, errInvalidRetention
+	}
+
+	return policy, nil
+}
+
+// SetRetention replaces the retention policy. The janitor applies it on its next run;
+// changes through /api/settings take effect at once.
+func (a *API) SetRetention(policy RetentionPolicy) {
+	a.retention = policy
+}
+
+// StartRetention runs the janitor: once now and then every retentionInterval until ctx is
+// done, it removes the datasets the retention policy expires.
+func (a *API) StartRetention(ctx context.Context) {
+	a.mu.Lock()
+	a.expireDatasets(time.Now())
+	a.retentionSweep = time.Now().Add(retentionInterval).Unix()
+	a.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			a.mu.Lock()
+			a.expireDatasets(time.Now())
+			a.retentionSweep = time.Now().Add(retentionInterval).Unix()
+			a.mu.Unlock()
+		}
+	}()
+}
+
+// expireDatasets removes the datasets the retention policy expires at now. Callers must hold
+// a.mu.
+func (a *API) expireDatasets(now time.Time) {
+	for fileID, reason := range a.expiredDatasets(now) {
+		fileData := a.files[fileID]
+		a.removeFile(fileID)
+		a.metrics.expirations.Inc(reason)
+		log.Printf("Removed dataset %s (%s, uploaded %s) under the retention policy (%s)",
+			fileID, fileData.Filename, time.Unix(fileData.UploadTime, 0).UTC().Format(time.RFC3339), reason)
+	}
+}
+
+// expiredDatasets returns the datasets the retention policy expires at now, with the limit
+// each exceeds: those older than the maximum age, then the oldest of the rest until the
+// maximum number and size are met. Callers must hold a.mu, for reading.
+func (a *API) expiredDatasets(now time.Time) map[string]string {
+	if !a.retention.enabled() {
+		return nil
+	}
+
+	fileIDs := make([]string, 0, len(a.files))
+	for fileID := range a.files {
+		if !a.isLiveDataset(fileID) {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	slices.SortFunc(fileIDs, func(x, y string) int { // Oldest first
+		return cmp.Or(cmp.Compare(a.files[x].UploadTime, a.files[y].UploadTime), strings.Compare(x, y))
+	})
+
+	expired := make(map[string]string)
+	kept, bytes := 0, int64(0)
+	for _, fileID := range fileIDs {
+		fileData := a.files[fileID]
+		if a.retention.MaxAge > 0 && now.Sub(time.Unix(fileData.UploadTime, 0)) > a.retention.MaxAge {
+			expired[fileID] = expireAge
+
+			continue
+		}
+		kept++
+		bytes += fileData.Size
+	}
+	for _, fileID := range fileIDs {
+		if _, gone := expired[fileID]; gone {
+			continue
+		}
+		switch {
+		case a.retention.MaxDatasets > 0 && kept > a.retention.MaxDatasets:
+			expired[fileID] = expireCount
+		case a.retention.MaxBytes > 0 && bytes > a.retention.MaxBytes:
+			expired[fileID] = expireSize
+		default:
+			return expired
+		}
+		kept--
+		bytes -= a.files[fileID].Size
+	}
+
+	return expired
+}
+
+// markExpiry sets when the retention policy removes each listed dataset: the next run of the
+// janitor for those it expires already, or when they reach the maximum age. Callers must
+// hold a.mu, for reading.
+func (a *API) markExpiry(files []FileInfo) {
+	if !a.retention.enabled() {
+		return
+	}
+
+	now := time.Now()
+	due := a.expiredDatasets(now)
+	for i := range files {
+		switch {
+		case a.isLiveDataset(files[i].ID):
+		case due[files[i].ID] != "":
+			files[i].ExpiresAt = max(a.retentionSweep, now.Unix())
+		case a.retention.MaxAge > 0:
+			files[i].ExpiresAt = time.Unix(files[i].UploadTime, 0).Add(a.retention.MaxAge).Unix()
+		}
+	}
+}
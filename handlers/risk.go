@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+)
+
+const riskMetric = "risk" // Top-N metric ranking hosts by risk score
+
+var errRiskField = errors.New("by=risk requires field id.orig_h or id.resp_h")
+
+// roundTenth rounds a value to one decimal.
+func roundTenth(points float64) float64 {
+	return math.Round(points*10) / 10 //nolint:mnd // One decimal place
+}
+
+// riskGroups ranks the hosts seen in the given address field by risk score, without suppressed
+// findings, in the shape of aggregated groups so top-N queries can rank by risk like any other metric.
+func riskGroups(connections []models.Connection, field string, suppressions []Suppression, local models.LocalNetworks) ([]aggregateGroup, error) {
+	canonical := models.CanonicalFieldName(field)
+	accessor, exists := models.StringFieldAccessor(canonical)
+	if !exists || (canonical != "id.orig_h" && canonical != "id.resp_h") {
+		return nil, errRiskField
+	}
+
+	seen := make(map[string]int)
+	for i := range connections {
+		seen[accessor(&connections[i])]++
+	}
+
+	nodes, _ := netgraph.NodesAndEdges(connections, local, netgraph.ByProtocol)
+	nodes, _ = suppressNodeFindings(nodes, suppressions)
+	groups := make([]aggregateGroup, 0, len(seen))
+	for _, node := range nodes {
+		if seen[node.ID] == 0 {
+			continue
+		}
+		groups = append(groups, aggregateGroup{
+			Key:     map[string]string{field: node.ID},
+			Metrics: map[string]float64{riskMetric: node.RiskScore, countMetric: float64(seen[node.ID])},
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Metrics[riskMetric] != groups[j].Metrics[riskMetric] {
+			return groups[i].Metrics[riskMetric] > groups[j].Metrics[riskMetric]
+		}
+
+		return groups[i].Key[field] < groups[j].Key[field]
+	})
+
+	return groups, nil
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// apiRoute is an endpoint of the unversioned API.
+type apiRoute struct {
+	pattern string           // Method and path, as registered with http.ServeMux
+	handler http.HandlerFunc // Handler, wrapped with the lock and caches it needs
+}
+
+// apiRoutes returns the endpoints of /api. Datasets are addressed as /api/files/{id}; the
+// routes taking a file ID in the body are kept for existing clients.
+func (a *API) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{pattern: "GET /api/config", handler: a.GetConfig},
+		{pattern: "GET /api/me", handler: a.GetMe},
+		{pattern: "POST /api/login", handler: a.Login},
+		{pattern: "POST /api/logout", handler: a.Logout},
+
+		{pattern: "POST /api/upload", handler: a.UploadFile},
+		{pattern: "POST /api/upload/stream", handler: a.StreamUpload},
+		{pattern: "GET /api/upload/status/{id}", handler: a.GetIngestStatus},
+		{pattern: "GET /api/files", handler: a.Locked(a.GetFiles)},
+		{pattern: "GET /api/files/{id}", handler: a.ReadLocked(a.GetFile)},
+		{pattern: "PATCH /api/files/{id}", handler: a.Locked(a.UpdateFile)},
+		{pattern: "PUT /api/files/{id}", handler: a.ReplaceFile},
+		{pattern: "DELETE /api/files/{id}", handler: a.Locked(a.deleteDataset)},
+		{pattern: "POST /api/files/{id}/select", handler: a.Locked(a.selectDataset)},
+		{pattern: "GET /api/files/{id}/raw", handler: a.ReadLocked(a.GetRawFile)},
+		{pattern: "POST /api/files/{id}/replace", handler: a.ReplaceFile}, // Earlier form of PUT
+		{pattern: "GET /api/files/{id}/parse-report", handler: a.ReadLocked(a.GetParseErrors)},
+		{pattern: "GET /api/files/{id}/parse-errors", handler: a.ReadLocked(a.GetParseErrors)}, // Earlier name
+		{pattern: "POST /api/merge", handler: a.Locked(a.MergeFiles)},
+		{pattern: "GET /api/compare", handler: a.ReadLocked(a.CompareDatasets)},
+		{pattern: "POST /api/switch", handler: a.Locked(a.SwitchFile)},   // Earlier form of POST /api/files/{id}/select
+		{pattern: "POST /api/delete", handler: a.Locked(a.DeleteFile)},   // Earlier form of DELETE /api/files/{id}
+		{pattern: "DELETE /api/delete", handler: a.Locked(a.DeleteFile)}, // Earlier form of DELETE /api/files/{id}
+		{pattern: "POST /api/demo/load", handler: a.Locked(a.LoadDemoData)},
+
+		{pattern: "GET /api/evidence", handler: a.ReadLocked(a.ExportEvidence)},
+		{pattern: "GET /api/report", handler: a.ReadLocked(a.GetReport)},
+		{pattern: "GET /api/export", handler: a.ReadLocked(a.ExportConnections)},
+		{pattern: "GET /api/export/graph", handler: a.ReadLocked(a.ExportGraph)},
+		{pattern: "GET /api/snapshot/export", handler: a.ReadLocked(a.ExportSnapshot)},
+		{pattern: "POST /api/snapshot/import", handler: a.ImportSnapshot},
+		{pattern: "GET /api/backups", handler: a.ListBackups},
+		{pattern: "POST /api/backups", handler: a.ReadLocked(a.CreateBackup)},
+		{pattern: "POST /api/backups/{name}/restore", handler: a.RestoreBackup},
+
+		{pattern: "GET /api/connections", handler: a.SearchLocked(a.Conditional(a.GetConnections))},
+		{pattern: "GET /api/connections/count", handler: a.SearchLocked(a.Conditional(a.CountConnections))},
+		{pattern: "GET /api/connections/{uid}", handler: a.ReadLocked(a.GetConnection)},
+		{pattern: "GET /api/connections/{uid}/details", handler: a.ReadLocked(a.GetConnectionDetails)},
+
+		{pattern: "GET /api/nodes", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetNodes)))},
+		{pattern: "GET /api/nodes/{ip}/timeline", handler: a.ReadLocked(a.GetHostTimeline)},
+		{pattern: "GET /api/hosts/{ip}", handler: a.ReadLocked(a.GetHostProfile)},
+		{pattern: "GET /api/nodes/frames", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetGraphFrames)))},
+		{pattern: "GET /api/edges/timeline", handler: a.ReadLocked(a.GetEdgeTimeline)},
+		{pattern: "GET /api/timeline", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTimeline)))},
+		{pattern: "GET /api/timeline/{start}", handler: a.ReadLocked(a.GetTimelineBucket)},
+		{pattern: "GET /api/stats", handler: a.ReadLocked(a.GetStats)},
+		{pattern: "GET /api/stats/global", handler: a.ReadLocked(a.GetGlobalStats)},
+
+		{pattern: "GET /api/aggregate", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetAggregate)))},
+		{pattern: "GET /api/query", handler: a.ReadLocked(a.Conditional(a.Cached(a.QueryConnections)))},
+		{pattern: "POST /api/query", handler: a.ReadLocked(a.Conditional(a.Cached(a.QueryConnections)))},
+		{pattern: "GET /api/pipeline", handler: a.ReadLocked(a.Conditional(a.Cached(a.RunPipeline)))},
+		{pattern: "GET /api/histograms", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetHistogram)))},
+		{pattern: "GET /api/topn", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTopN)))},
+		{pattern: "GET /api/top", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTop)))},
+		{pattern: "GET /api/values", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetValues)))},
+		{pattern: "GET /api/hierarchy", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetHierarchy)))},
+
+		{pattern: "GET /api/clusters", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetClusters)))},
+		{pattern: "GET /api/analysis/beacons", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetBeacons)))},
+		{pattern: "GET /api/analysis/scans", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetScans)))},
+		{pattern: "GET /api/analysis/exfil", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetExfil)))},
+		{pattern: "GET /api/analysis/long-connections", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetLongConnections)))},
+		{pattern: "GET /api/analysis/anomalies", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetAnomalies)))},
+		{pattern: "GET /api/analysis/tls", handler: a.ReadLocked(a.Conditional(a.Cached(a.GetTLSFingerprints)))},
+		{pattern: "GET /api/analysis/new-hosts", handler: a.ReadLocked(a.GetNewHosts)},
+		{pattern: "GET /api/alerts", handler: a.GetAlerts},
+		{pattern: "POST /api/alerts/test", handler: a.TestAlert},
+		{pattern: "GET /api/notices", handler: a.ReadLocked(a.GetNotices)},
+
+		{pattern: "GET /api/live/stats", handler: a.GetLiveStats},
+		{pattern: "GET /api/live/events", handler: a.GetLiveEvents},
+		{pattern: "GET /api/watch", handler: a.GetTails},
+		{pattern: "GET /api/watchlist", handler: a.ReadLocked(a.GetWatchlist)},
+		{pattern: "POST /api/watchlist", handler: a.Locked(a.AddWatchlistEntry)},
+		{pattern: "DELETE /api/watchlist", handler: a.Locked(a.DeleteWatchlistEntry)},
+
+		{pattern: "GET /api/settings", handler: a.ReadLocked(a.GetSettings)},
+		{pattern: "PUT /api/settings", handler: a.Locked(a.UpdateSettings)},
+		{pattern: "GET /api/intel", handler: a.ReadLocked(a.GetIntel)},
+		{pattern: "POST /api/intel", handler: a.Locked(a.UploadIntel)},
+		{pattern: "DELETE /api/intel", handler: a.Locked(a.DeleteIntel)},
+		{pattern: "GET /api/suppressions", handler: a.ReadLocked(a.GetSuppressions)},
+		{pattern: "POST /api/suppressions", handler: a.Locked(a.AddSuppression)},
+		{pattern: "DELETE /api/suppressions/{id}", handler: a.Locked(a.DeleteSuppression)},
+		{pattern: "GET /api/views", handler: a.ReadLocked(a.GetViews)},
+		{pattern: "POST /api/views", handler: a.Locked(a.CreateView)},
+		{pattern: "GET /api/views/{id}", handler: a.ReadLocked(a.GetView)},
+		{pattern: "PUT /api/views/{id}", handler: a.Locked(a.UpdateView)},
+		{pattern: "DELETE /api/views/{id}", handler: a.Locked(a.DeleteView)},
+		{pattern: "GET /api/annotations", handler: a.ReadLocked(a.GetAnnotations)},
+		{pattern: "GET /api/annotations/{target}", handler: a.ReadLocked(a.GetAnnotation)},
+		{pattern: "PUT /api/annotations/{target}", handler: a.Locked(a.PutAnnotation)},
+		{pattern: "DELETE /api/annotations/{target}", handler: a.Locked(a.DeleteAnnotation)},
+	}
+}
+
+// HandleAPI registers the unversioned API on mux, each endpoint for the methods it serves.
+// Other methods are answered with 405 and an Allow header, and unknown /api paths with 404
+// rather than the page.
+func (a *API) HandleAPI(mux *http.ServeMux) {
+	for _, route := range a.apiRoutes() {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if allowed := allowedMethods(mux, r); len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+		http.Error(w, "Unknown endpoint "+r.URL.Path, http.StatusNotFound)
+	})
+}
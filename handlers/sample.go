@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const (
+	sampleRandom      = "random"     // Uniform sample of the matches
+	sampleStratified  = "stratified" // Uniform sample per protocol, in proportion to the matches
+	sampleTopBytes    = "top_bytes"  // The matches that transferred the most bytes
+	defaultSampleSize = 5000         // Connections in a sample without sample_size
+	defaultSampleSeed = 1            // Seed without seed, so repeated requests draw the same sample
+	sampleSeedStream  = 0x5a3d1e     // Second PCG seed word; any constant works
+)
+
+var (
+	errInvalidSample     = errors.New("sample must be random, stratified, or top_bytes")
+	errInvalidSampleSeed = errors.New("seed must be a non-negative integer")
+)
+
+// sampleConnections returns a representative subset of the matching connections when the query
+// asks for one with sample: up to sample_size of them (default 5000, at most --max-results),
+// in the order of the matches. random draws uniformly; stratified draws from each protocol in
+// proportion to its matches, with at least one connection of every protocol; top_bytes takes
+// the connections that transferred the most bytes. Random draws depend only on seed, so
+// repeated requests get the same sample. Without sample, the connections are returned as
+// they are with a nil description.
+func (a *API) sampleConnections(connections []models.Connection, query url.Values) ([]models.Connection, *models.ConnectionSample, error) {
+	strategy := strings.ToLower(query.Get("sample"))
+	if strategy == "" {
+		return connections, nil, nil
+	}
+	if !slices.Contains([]string{sampleRandom, sampleStratified, sampleTopBytes}, strategy) {
+		return nil, nil, errInvalidSample
+	}
+
+	seed := uint64(defaultSampleSeed)
+	if value := query.Get("seed"); value != "" {
+		var err error
+		seed, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, nil, errInvalidSampleSeed
+		}
+	}
+	size := cmp.Or(parseLimit(query, "sample_size"), defaultSampleSize)
+	if a.maxResults > 0 {
+		size = min(size, a.maxResults)
+	}
+
+	sample := &models.ConnectionSample{Strategy: strategy}
+	var sampled []models.Connection
+	switch strategy {
+	case sampleTopBytes:
+		sampled = topByBytes(connections, size)
+	case sampleStratified:
+		quotas, strata := stratumQuotas(connections, size)
+		sampled = drawSample(connections, quotas, true, seed)
+		for i := range strata {
+			strata[i].Size = quotas[strata[i].Protocol]
+		}
+		sample.Strata, sample.Seed = strata, seed
+	default:
+		sampled = drawSample(connections, map[string]int{"": min(size, len(connections))}, false, seed)
+		sample.Seed = seed
+	}
+	sample.Size = len(sampled)
+
+	return sampled, sample, nil
+}
+
+// drawSample draws quotas[key] connections uniformly from those of each key, the protocol
+// when stratified and "" otherwise. Connections keep their order: each is taken with the
+// probability of the quota still open among those left.
+func drawSample(connections []models.Connection, quotas map[string]int, stratified bool, seed uint64) []models.Connection {
+	remaining := make(map[string]int, len(quotas))
+	for i := range connections {
+		remaining[stratumKey(&connections[i], stratified)]++
+	}
+
+	rng := rand.New(rand.NewPCG(seed, sampleSeedStream)) //nolint:gosec // Sampling, not security
+	open := maps.Clone(quotas)
+	wanted := 0
+	for _, quota := range quotas {
+		wanted += quota
+	}
+	sampled := make([]models.Connection, 0, wanted)
+	for i := range connections {
+		key := stratumKey(&connections[i], stratified)
+		if open[key] > 0 && rng.IntN(remaining[key]) < open[key] {
+			sampled = append(sampled, connections[i])
+			open[key]--
+		}
+		remaining[key]--
+	}
+
+	return sampled
+}
+
+// stratumKey returns the stratum of a connection in drawSample.
+func stratumKey(conn *models.Connection, stratified bool) string {
+	if !stratified {
+		return ""
+	}
+
+	return conn.Protocol
+}
+
+// stratumQuotas splits size among the protocols of the connections in proportion to their
+// matches, by largest remainder, giving every protocol at least one connection. The strata
+// are listed busiest first.
+func stratumQuotas(connections []models.Connection, size int) (map[string]int, []models.SampleStratum) {
+	counts := make(map[string]int)
+	for i := range connections {
+		counts[connections[i].Protocol]++
+	}
+
+	strata := make([]models.SampleStratum, 0, len(counts))
+	for protocol, count := range counts {
+		strata = append(strata, models.SampleStratum{Protocol: protocol, Matching: count})
+	}
+	slices.SortFunc(strata, func(x, y models.SampleStratum) int {
+		return cmp.Or(cmp.Compare(y.Matching, x.Matching), strings.Compare(x.Protocol, y.Protocol))
+	})
+
+	quotas := make(map[string]int, len(strata))
+	total := len(connections)
+	if size >= total {
+		for protocol, count := range counts {
+			quotas[protocol] = count
+		}
+
+		return quotas, strata
+	}
+
+	assigned := 0
+	for _, stratum := range strata {
+		quotas[stratum.Protocol] = max(1, stratum.Matching*size/total)
+		assigned += quotas[stratum.Protocol]
+	}
+
+	// Hand out what rounding down left over by largest remainder, or take back what the minimum
+	// of one gave out too much from the largest strata
+	byRemainder := slices.Clone(strata)
+	slices.SortStableFunc(byRemainder, func(x, y models.SampleStratum) int {
+		return cmp.Compare(y.Matching*size%total, x.Matching*size%total)
+	})
+	for i := 0; assigned < size && i < len(byRemainder); i++ {
+		if protocol := byRemainder[i].Protocol; quotas[protocol] < counts[protocol] {
+			quotas[protocol]++
+			assigned++
+		}
+	}
+	for assigned > size && quotas[strata[0].Protocol] > 1 {
+		quotas[strata[0].Protocol]--
+		assigned--
+	}
+
+	return quotas, strata
+}
+
+// topByBytes returns the size connections that transferred the most bytes, keeping their order.
+func topByBytes(connections []models.Connection, size int) []models.Connection {
+	if size >= len(connections) {
+		return slices.Clone(connections)
+	}
+
+	positions := make([]int, len(connections))
+	for i := range positions {
+		positions[i] = i
+	}
+	slices.SortStableFunc(positions, func(x, y int) int {
+		return cmp.Compare(connections[y].TotalBytes(), connections[x].TotalBytes())
+	})
+	positions = positions[:size]
+	slices.Sort(positions)
+
+	sampled := make([]models.Connection, size)
+	for i, position := range positions {
+		sampled[i] = connections[position]
+	}
+
+	return sampled
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"zeek-viz/models"
+)
+
+const (
+	scanRule            = "scan"       // Finding rule of port scans and host sweeps
+	scanVertical        = "vertical"   // Many ports of one host
+	scanHorizontal      = "horizontal" // One port of many hosts
+	defaultScanWindow   = 300.0        // Seconds the distinct targets must fall within
+	defaultScanMinPorts = 20           // Distinct ports of one host that make a vertical scan
+	defaultScanMinHosts = 20           // Distinct hosts on one port that make a horizontal sweep
+	defaultScanLimit    = 50           // Scans returned by default
+	maxScanSamples      = 20           // Targets listed per scan
+	scanSharePrecision  = 1000         // Failed shares are rounded to three decimals
+)
+
+var (
+	errInvalidScanWindow = errors.New("window must be a positive number of seconds")
+	errScanType          = errors.New("type must be vertical or horizontal")
+)
+
+// Scan is an originator that contacted many distinct ports of one host (a vertical scan) or
+// one port across many hosts (a horizontal sweep) within the time window.
+type Scan struct {
+	Type         string         `json:"type"` // vertical or horizontal
+	Src          string         `json:"src"`
+	Dst          string         `json:"dst,omitempty"`  // Scanned host of a vertical scan
+	Port         int            `json:"port,omitempty"` // Swept port of a horizontal sweep
+	Proto        string         `json:"proto,omitempty"`
+	Targets      int            `json:"targets"`       // Most distinct ports or hosts within one window
+	TotalTargets int            `json:"total_targets"` //nolint:tagliatelle // API consistency
+	Sample       []string       `json:"sample"`        // Targets of the busiest window, up to maxScanSamples
+	Connections  int            `json:"connections"`   // Connections in the busiest window
+	FailedShare  float64        `json:"failed_share"`  //nolint:tagliatelle // Share of those that were rejected or unanswered
+	WindowStart  float64        `json:"window_start"`  //nolint:tagliatelle // API consistency
+	WindowEnd    float64        `json:"window_end"`    //nolint:tagliatelle // API consistency
+	Threat       *models.Threat `json:"threat,omitempty"`
+
+	first *models.Connection // Connection that opens the busiest window, for suppressions
+}
+
+// scanThresholds are the parameters of a scan detection.
+type scanThresholds struct {
+	window   float64
+	minPorts int
+	minHosts int
+}
+
+// defaultScanThresholds returns the thresholds /api/nodes annotates scanners by.
+func defaultScanThresholds() scanThresholds {
+	return scanThresholds{window: defaultScanWindow, minPorts: defaultScanMinPorts, minHosts: defaultScanMinHosts}
+}
+
+// scanCandidate collects the connections of one vertical or horizontal grouping together with
+// the target each contacted.
+type scanCandidate struct {
+	conns   []*models.Connection
+	targets []string
+}
+
+// GetScans returns the port scans and host sweeps of the filtered connections. Accepts the
+// standard filters, type, window, min_ports, min_hosts, and limit.
+func (a *API) GetScans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	thresholds := defaultScanThresholds()
+	window, err := parseFloatParam(query.Get("window"), defaultScanWindow)
+	if err != nil || window <= 0 {
+		http.Error(w, errInvalidScanWindow.Error(), http.StatusBadRequest)
+
+		return
+	}
+	thresholds.window = window
+	if minPorts := parseLimit(query, "min_ports"); minPorts > 0 {
+		thresholds.minPorts = minPorts
+	}
+	if minHosts := parseLimit(query, "min_hosts"); minHosts > 0 {
+		thresholds.minHosts = minHosts
+	}
+	scanType := query.Get("type")
+	if scanType != "" && scanType != scanVertical && scanType != scanHorizontal {
+		http.Error(w, errScanType.Error(), http.StatusBadRequest)
+
+		return
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultScanLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	scans := make([]Scan, 0)
+	suppressed := 0
+	for _, scan := range detectScans(connections, thresholds) {
+		if scanType != "" && scan.Type != scanType {
+			continue
+		}
+		if connectionSuppressed(a.suppressions, scanRule, scan.first) {
+			suppressed++
+
+			continue
+		}
+		scan.Threat = a.intel.matchConnection(scan.first)
+		scans = append(scans, scan)
+	}
+
+	response := map[string]any{
+		"scans":               scans[:min(limit, len(scans))],
+		"total":               len(scans),
+		"truncated":           len(scans) > limit,
+		"suppressed_findings": suppressed,
+		"limits": map[string]any{
+			"limit":     limit,
+			"window":    thresholds.window,
+			"min_ports": thresholds.minPorts,
+			"min_hosts": thresholds.minHosts,
+		},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode scans: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// detectScans finds the vertical scans and horizontal sweeps of the connections, ordered by
+// the number of distinct targets within a window.
+func detectScans(connections []models.Connection, thresholds scanThresholds) []Scan {
+	vertical := make(map[string]*scanCandidate)
+	horizontal := make(map[string]*scanCandidate)
+	for i := range connections {
+		conn := &connections[i]
+		port := strconv.Itoa(conn.RespPort)
+		addScanTarget(vertical, conn.OrigHost+groupKeySeparator+conn.RespHost, conn, port+"/"+conn.Protocol)
+		addScanTarget(horizontal, conn.OrigHost+groupKeySeparator+port+groupKeySeparator+conn.Protocol, conn, conn.RespHost)
+	}
+
+	scans := make([]Scan, 0)
+	for _, candidate := range vertical {
+		if scan, found := candidate.busiestWindow(thresholds.window, thresholds.minPorts); found {
+			scan.Type, scan.Dst = scanVertical, scan.first.RespHost
+			scans = append(scans, scan)
+		}
+	}
+	for _, candidate := range horizontal {
+		if scan, found := candidate.busiestWindow(thresholds.window, thresholds.minHosts); found {
+			scan.Type, scan.Port, scan.Proto = scanHorizontal, scan.first.RespPort, scan.first.Protocol
+			scans = append(scans, scan)
+		}
+	}
+
+	sort.Slice(scans, func(i, j int) bool {
+		if scans[i].Targets != scans[j].Targets {
+			return scans[i].Targets > scans[j].Targets
+		}
+		if scans[i].Src != scans[j].Src {
+			return scans[i].Src < scans[j].Src
+		}
+		if scans[i].Type != scans[j].Type {
+			return scans[i].Type > scans[j].Type
+		}
+		if scans[i].Dst != scans[j].Dst {
+			return scans[i].Dst < scans[j].Dst
+		}
+
+		return scans[i].Port < scans[j].Port
+	})
+
+	return scans
+}
+
+// addScanTarget records that a connection of the grouping contacted target.
+func addScanTarget(groups map[string]*scanCandidate, key string, conn *models.Connection, target string) {
+	candidate, exists := groups[key]
+	if !exists {
+		candidate = &scanCandidate{}
+		groups[key] = candidate
+	}
+	candidate.conns = append(candidate.conns, conn)
+	candidate.targets = append(candidate.targets, target)
+}
+
+// busiestWindow slides a window of the given width over the candidate's connections and
+// returns the window with the most distinct targets, when it reaches minTargets.
+func (c *scanCandidate) busiestWindow(window float64, minTargets int) (Scan, bool) {
+	if len(c.conns) < minTargets || distinctCount(c.targets) < minTargets {
+		return Scan{}, false
+	}
+
+	order := make([]int, len(c.conns))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return c.conns[order[i]].Timestamp < c.conns[order[j]].Timestamp
+	})
+
+	counts := make(map[string]int)
+	best, bestStart, bestEnd := 0, 0, 0
+	start := 0
+	for end, index := range order {
+		counts[c.targets[index]]++
+		for c.conns[index].Timestamp-c.conns[order[start]].Timestamp > window {
+			target := c.targets[order[start]]
+			counts[target]--
+			if counts[target] == 0 {
+				delete(counts, target)
+			}
+			start++
+		}
+		if len(counts) > best {
+			best, bestStart, bestEnd = len(counts), start, end
+		}
+	}
+	if best < minTargets {
+		return Scan{}, false
+	}
+
+	seen := make(map[string]bool, best)
+	sample := make([]string, 0, min(best, maxScanSamples))
+	failed := 0
+	for _, index := range order[bestStart : bestEnd+1] {
+		if slices.Contains(models.FailedStates(), c.conns[index].ConnState) {
+			failed++
+		}
+		if target := c.targets[index]; !seen[target] {
+			seen[target] = true
+			if len(sample) < maxScanSamples {
+				sample = append(sample, target)
+			}
+		}
+	}
+	connections := bestEnd - bestStart + 1
+	first := c.conns[order[bestStart]]
+
+	return Scan{
+		Src:          first.OrigHost,
+		Proto:        first.Protocol,
+		Targets:      best,
+		TotalTargets: distinctCount(c.targets),
+		Sample:       sample,
+		Connections:  connections,
+		FailedShare:  roundTo(float64(failed)/float64(connections), scanSharePrecision),
+		WindowStart:  first.Timestamp,
+		WindowEnd:    c.conns[order[bestEnd]].Timestamp,
+		first:        first,
+	}, true
+}
+
+// distinctCount returns the number of distinct values.
+func distinctCount(values []string) int {
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		seen[value] = struct{}{}
+	}
+
+	return len(seen)
+}
+
+// annotateGraphScans flags the nodes of the graph that originated a scan or sweep. Suppressed
+// scans are left out and counted as suppressed findings. The nodes are copied first, since
+// the unfiltered graph is shared by concurrent requests.
+func (a *API) annotateGraphScans(graph *models.NetworkGraph, scans []Scan) {
+	if len(scans) == 0 {
+		return
+	}
+
+	activity := make(map[string]*models.ScanActivity)
+	for i := range scans {
+		scan := &scans[i]
+		if connectionSuppressed(a.suppressions, scanRule, scan.first) {
+			graph.SuppressedFindings++
+
+			continue
+		}
+		node, exists := activity[scan.Src]
+		if !exists {
+			node = &models.ScanActivity{}
+			activity[scan.Src] = node
+		}
+		if scan.Type == scanVertical {
+			node.VerticalScans++
+		} else {
+			node.HorizontalSweeps++
+		}
+		node.MaxTargets = max(node.MaxTargets, scan.Targets)
+	}
+	if len(activity) == 0 {
+		return
+	}
+
+	graph.Nodes = slices.Clone(graph.Nodes)
+	for i := range graph.Nodes {
+		graph.Nodes[i].Scan = activity[graph.Nodes[i].ID]
+	}
+}
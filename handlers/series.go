@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+)
+
+// directionFunc classifies a connection for a directional timeline. It reports whether the
+// connection belongs to the series and, if so, its outgoing and incoming byte counts.
+type directionFunc func(conn *models.Connection) (bool, int, int)
+
+// GetHostTimeline returns bucketed connection counts and in/out bytes for a single host.
+func (a *API) GetHostTimeline(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("ip")
+	query := r.URL.Query()
+
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	timeline := buildDirectionalTimeline(connections, query, loc, hostDirection(host))
+
+	writeDirectionalTimeline(w, timeline)
+}
+
+// GetEdgeTimeline returns bucketed activity for a host pair. By default only connections
+// originated by source towards target are included; bidirectional=true adds the reverse direction.
+func (a *API) GetEdgeTimeline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	source := query.Get("source")
+	target := query.Get("target")
+	if source == "" || target == "" {
+		http.Error(w, "source and target parameters are required", http.StatusBadRequest)
+
+		return
+	}
+
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	classify := edgeDirection(source, target, query.Get("bidirectional") == "true")
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	timeline := buildDirectionalTimeline(connections, query, loc, classify)
+
+	writeDirectionalTimeline(w, timeline)
+}
+
+// edgeDirection classifies connections between two hosts: bytes sent by source are outgoing.
+func edgeDirection(source, target string, bidirectional bool) directionFunc {
+	return func(conn *models.Connection) (bool, int, int) {
+		if conn.OrigHost == source && conn.RespHost == target {
+			return true, conn.OrigBytes, conn.RespBytes
+		}
+		if bidirectional && conn.OrigHost == target && conn.RespHost == source {
+			return true, conn.RespBytes, conn.OrigBytes
+		}
+
+		return false, 0, 0
+	}
+}
+
+// hostDirection classifies connections relative to a host: bytes it sent are outgoing.
+func hostDirection(host string) directionFunc {
+	return func(conn *models.Connection) (bool, int, int) {
+		switch host {
+		case conn.OrigHost:
+			return true, conn.OrigBytes, conn.RespBytes
+		case conn.RespHost:
+			return true, conn.RespBytes, conn.OrigBytes
+		default:
+			return false, 0, 0
+		}
+	}
+}
+
+// buildDirectionalTimeline buckets the connections selected by classify.
+// The bucket size in seconds comes from the "bucket" query parameter; buckets are aligned
+// to loc when given.
+func buildDirectionalTimeline(
+	connections []models.Connection, query url.Values, loc *time.Location, classify directionFunc,
+) *models.DirectionalTimeline {
+	bucketSize := int64(parseLimit(query, "bucket"))
+	if bucketSize == 0 {
+		bucketSize = timelineBucketSec
+	}
+
+	timeline := &models.DirectionalTimeline{
+		Points:     []models.DirectionalTimelinePoint{},
+		BucketSize: bucketSize,
+		Timezone:   timezoneName(loc),
+	}
+	buckets := make(map[int64]*models.DirectionalTimelinePoint)
+
+	for i := range connections {
+		matched, bytesOut, bytesIn := classify(&connections[i])
+		if !matched {
+			continue
+		}
+
+		ts := int64(connections[i].Timestamp)
+		if len(buckets) == 0 {
+			timeline.Start, timeline.End = ts, ts
+		}
+		timeline.Start = min(timeline.Start, ts)
+		timeline.End = max(timeline.End, ts)
+
+		bucket := bucketStart(ts, bucketSize, loc)
+		point, exists := buckets[bucket]
+		if !exists {
+			point = &models.DirectionalTimelinePoint{Timestamp: bucket}
+			if loc != nil {
+				point.Local = formatLocal(bucket, loc)
+			}
+			buckets[bucket] = point
+		}
+		point.Count++
+		point.BytesOut += bytesOut
+		point.BytesIn += bytesIn
+	}
+
+	for _, point := range buckets {
+		timeline.Points = append(timeline.Points, *point)
+	}
+
+	sort.Slice(timeline.Points, func(i, j int) bool {
+		return timeline.Points[i].Timestamp < timeline.Points[j].Timestamp
+	})
+
+	return timeline
+}
+
+// writeDirectionalTimeline encodes a directional timeline response.
+func writeDirectionalTimeline(w http.ResponseWriter, timeline *models.DirectionalTimeline) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(timeline)
+	if err != nil {
+		log.Printf("Failed to encode timeline: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
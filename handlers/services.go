@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+// SetServicePorts adds overrides such as "8080/tcp=http" to the well-known ports services are
+// guessed from; an empty service ("8080=") stops guessing on a port. Guesses are made as
+// datasets are loaded, so the overrides must be set before any are.
+func (a *API) SetServicePorts(values []string) error {
+	overrides, err := models.ParseServicePorts(values)
+	if err != nil {
+		return err
+	}
+	a.servicePorts = models.DefaultServicePorts().WithOverrides(overrides)
+
+	return nil
+}
+
+// guessFileServices guesses the services of a file's connections as it is added.
+func (a *API) guessFileServices(fileData *FileData) {
+	if fileData.unloaded == nil {
+		connstats.GuessServices(fileData.Connections, a.servicePorts, fileData.Stats)
+	}
+}
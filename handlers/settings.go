@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"zeek-viz/models"
+)
+
+// Settings are the analysis settings that can be changed at runtime.
+type Settings struct {
+	LocalNetworks        []string          `json:"local_networks"`         //nolint:tagliatelle // API consistency
+	DefaultLocalNetworks []string          `json:"default_local_networks"` //nolint:tagliatelle // API consistency
+	Retention            RetentionSettings `json:"retention"`
+}
+
+// settingsUpdate is the body of a settings change. Omitted settings are left unchanged.
+type settingsUpdate struct {
+	LocalNetworks *[]string          `json:"local_networks"` //nolint:tagliatelle // API consistency
+	Retention     *RetentionSettings `json:"retention"`      // Replaces the whole policy; omitted limits are off
+}
+
+// SetLocalNetworks replaces the prefixes whose hosts count as local, the private ranges by
+// default. Values are CIDR prefixes or IP addresses; an empty list restores the defaults.
+func (a *API) SetLocalNetworks(values []string) error {
+	networks, err := models.ParseLocalNetworks(values)
+	if err != nil {
+		return err
+	}
+	if len(networks) == 0 {
+		networks = models.DefaultLocalNetworks()
+	}
+
+	// Cached graphs notice the change themselves; shared responses are keyed by the version
+	a.localNetworks = networks
+	a.settingsVersion = time.Now().UnixNano()
+
+	return nil
+}
+
+// GetSettings returns the current analysis settings.
+func (a *API) GetSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(a.settings())
+	if err != nil {
+		log.Printf("Failed to encode settings: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// UpdateSettings changes the settings given in the JSON body and returns the result.
+func (a *API) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var update settingsUpdate
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+
+	if update.LocalNetworks != nil {
+		err = a.SetLocalNetworks(*update.LocalNetworks)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		log.Printf("Local networks set to %s", strings.Join(a.localNetworks.Strings(), ", "))
+	}
+	if update.Retention != nil {
+		policy, err := update.Retention.policy()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		a.SetRetention(policy)
+		log.Printf("Retention policy set to %s", policy)
+		if a.retentionSweep != 0 {
+			a.expireDatasets(time.Now())
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(a.settings())
+	if err != nil {
+		log.Printf("Failed to encode settings: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// settings returns the current analysis settings.
+func (a *API) settings() Settings {
+	return Settings{
+		LocalNetworks:        a.localNetworks.Strings(),
+		DefaultLocalNetworks: models.DefaultLocalNetworks().Strings(),
+		Retention:            a.retention.settings(),
+	}
+}
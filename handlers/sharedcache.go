@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"zeek-viz/store"
+)
+
+const (
+	responseCacheTTL   = 10 * time.Minute // Lifetime of cached query responses
+	maxCachedResponse  = 4 << 20          // 4MB, larger responses are not cached
+	sharedCacheTimeout = time.Second      // Upper bound for one cache operation
+	currentFileKey     = "current_file"   // Cache key of the selected dataset
+	cacheHeader        = "X-Cache"        // Response header reporting HIT or MISS
+)
+
+// responseRecorder captures a response while passing it through to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code.
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records and forwards the body.
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.body.Len() <= maxCachedResponse {
+		r.body.Write(data)
+	}
+
+	return r.ResponseWriter.Write(data) //nolint:wrapcheck // Transparent wrapper
+}
+
+// SetCache shares query results and the selected dataset through cache, so replicas behind
+// a load balancer answer consistently.
+func (a *API) SetCache(cache store.Cache) {
+	a.cache = cache
+}
+
+// SharedState loads the dataset selected on any instance, server-wide and by the request's
+// workspace, before API requests are handled.
+func (a *API) SharedState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.cache != nil && strings.HasPrefix(r.URL.Path, "/api/") {
+			a.loadCurrentFile(r.Context())
+			if id := workspaceID(r); id != "" {
+				a.loadWorkspace(r.Context(), id)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// partialResponseKey is the context key of the flag handlers set, through
+// markPartialResponse, when their response lacks data that is still being fetched.
+type partialResponseKey struct{}
+
+// markPartialResponse flags the response of the request with ctx as partial, so Cached
+// doesn't store it.
+func markPartialResponse(ctx context.Context) {
+	if partial, ok := ctx.Value(partialResponseKey{}).(*atomic.Bool); ok {
+		partial.Store(true)
+	}
+}
+
+// Cached serves repeated GET requests for the same dataset from the shared cache. Keys embed
+// the dataset's content hash and flow stitching gap, so replaced or restitched datasets never
+// serve stale results; live datasets, which change continuously, queries across several
+// datasets, and partial responses, such as nodes whose hostname lookups are still running,
+// are not cached.
+func (a *API) Cached(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := a.responseCacheKey(r)
+		if key == "" {
+			handler(w, r)
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), sharedCacheTimeout)
+		cached, found, err := a.cache.Get(ctx, key)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to read cached response: %v", err)
+		}
+		if found {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(cacheHeader, "HIT")
+			_, err = w.Write(cached)
+			if err != nil {
+				log.Printf("Failed to write cached response: %v", err)
+			}
+
+			return
+		}
+
+		w.Header().Set(cacheHeader, "MISS")
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		partial := new(atomic.Bool)
+		handler(recorder, r.WithContext(context.WithValue(r.Context(), partialResponseKey{}, partial)))
+
+		if recorder.status != http.StatusOK || recorder.body.Len() > maxCachedResponse || partial.Load() {
+			return
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), sharedCacheTimeout)
+		defer cancel()
+
+		err = a.cache.Set(ctx, key, recorder.body.Bytes(), responseCacheTTL)
+		if err != nil {
+			log.Printf("Failed to cache response: %v", err)
+		}
+	}
+}
+
+// responseCacheKey identifies a request's result, or returns "" when it can't be cached.
+func (a *API) responseCacheKey(r *http.Request) string {
+	if a.cache == nil {
+		return ""
+	}
+
+	version := a.responseVersion(r)
+	if version == "" {
+		return ""
+	}
+
+	return "response:" + version
+}
+
+// responseVersion identifies the data a GET request's result depends on: the request, the
+// content, stitching, and attached protocol logs of its dataset, the settings, and with
+// reverse DNS the hostnames resolved so far. It returns "" for live datasets,
+// which change continuously, and queries across several datasets. Callers must hold a.mu,
+// for reading.
+func (a *API) responseVersion(r *http.Request) string {
+	if r.Method != http.MethodGet {
+		return ""
+	}
+
+	fileID := a.currentDataset(r)
+	currentFile := a.files[fileID]
+	if currentFile == nil || currentFile.SHA256 == "" || isMultiDataset(r.URL.Query()) {
+		return ""
+	}
+
+	// Encode sorts parameters, so equivalent queries share a version
+	return fmt.Sprintf("%s:%s:%g:%d:%d:%d:%s?%s", fileID, currentFile.SHA256, currentFile.StitchGap, currentFile.attachVersion,
+		a.settingsVersion, a.rdns.version(), r.URL.Path, r.URL.Query().Encode())
+}
+
+// publishCurrentFile shares the selected dataset with the other instances.
+func (a *API) publishCurrentFile() {
+	if a.cache == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+	defer cancel()
+
+	err := a.cache.Set(ctx, currentFileKey, []byte(a.currentFileID), 0)
+	if err != nil {
+		log.Printf("Failed to share current file: %v", err)
+	}
+}
+
+// loadCurrentFile selects the dataset last selected on any instance, when it is loaded here.
+func (a *API) loadCurrentFile(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, sharedCacheTimeout)
+	defer cancel()
+
+	fileID, found, err := a.cache.Get(ctx, currentFileKey)
+	if err != nil {
+		log.Printf("Failed to read shared current file: %v", err)
+
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if found && a.files[string(fileID)] != nil {
+		a.currentFileID = string(fileID)
+	}
+}
@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+	"zeek-viz/store"
+)
+
+const (
+	snapshotVersion  = 1                   // Format version written to snapshot manifests
+	snapshotManifest = "manifest.json"     // Name of the manifest inside a snapshot archive
+	maxSnapshotSize  = 1 << 30             // 1GB
+	snapshotDir      = "datasets/"         // Archive directory holding dataset contents
+	snapshotMIMEType = "application/zip"   // Content type of snapshot archives
+	snapshotPrefix   = "zeek-viz-snapshot" // Filename prefix of exported snapshots
+)
+
+var (
+	errSnapshotManifest = errors.New("snapshot has no valid manifest")
+	errSnapshotVersion  = errors.New("unsupported snapshot version")
+	errSnapshotContent  = errors.New("snapshot dataset content missing")
+)
+
+// snapshot is the manifest of a state archive: every dataset with its metadata, plus settings.
+type snapshot struct {
+	Version     int               `json:"version"`
+	CreatedAt   int64             `json:"created_at"`   //nolint:tagliatelle // API consistency
+	CurrentFile string            `json:"current_file"` //nolint:tagliatelle // API consistency
+	Settings    snapshotSettings  `json:"settings"`
+	Datasets    []snapshotDataset `json:"datasets"`
+}
+
+// snapshotSettings are the runtime settings carried by a snapshot.
+type snapshotSettings struct {
+	LiveRetentionSec int64            `json:"live_retention_sec"` //nolint:tagliatelle // API consistency
+	StoreRawUploads  bool             `json:"store_raw_uploads"`  //nolint:tagliatelle // API consistency
+	Watchlist        []WatchlistEntry `json:"watchlist,omitempty"`
+	Suppressions     []Suppression    `json:"suppressions,omitempty"`
+	Views            []View           `json:"views,omitempty"`
+	Annotations      []Annotation     `json:"annotations,omitempty"`
+	LocalNetworks    []string         `json:"local_networks,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// snapshotDataset describes one dataset in a snapshot. Content is the archive path of either
+// the original uploaded bytes (Raw) or the connections serialized as JSON lines.
+type snapshotDataset struct {
+	store.Metadata
+
+	Content string                 `json:"content"`
+	Rollups []models.TimelinePoint `json:"rollups,omitempty"`
+}
+
+// ExportSnapshot streams a zip archive with all loaded datasets and settings, which
+// /api/snapshot/import restores on another instance.
+func (a *API) ExportSnapshot(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("%s-%s.zip", snapshotPrefix, time.Now().UTC().Format("20060102-150405"))
+
+	w.Header().Set("Content-Type", snapshotMIMEType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err := a.writeSnapshot(w)
+	if err != nil {
+		log.Printf("Failed to write snapshot: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Exported snapshot with %d datasets", len(a.files))
+}
+
+// ImportSnapshot restores datasets and settings from a snapshot archive sent as the request
+// body. Datasets with the same ID are overwritten; replace=true drops all others first. The
+// archive is read and parsed before the lock is taken, so other requests are only held up
+// while the datasets are swapped in. The server keeps its live retention and raw upload
+// storage unless restore_settings=true.
+func (a *API) ImportSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSnapshotSize+1))
+	if err != nil {
+		http.Error(w, "Failed to read snapshot", http.StatusBadRequest)
+
+		return
+	}
+	if len(data) > maxSnapshotSize {
+		http.Error(w, "Snapshot too large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	manifest, files, err := readSnapshot(r.Context(), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	replace := r.URL.Query().Get("replace") == "true"
+	restoreSettings := r.URL.Query().Get("restore_settings") == "true"
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sizes := make(map[string]int64, len(files))
+	for fileID, fileData := range files {
+		sizes[fileID] = fileData.Size
+	}
+	var quotaErr *quotaError
+	if errors.As(a.checkQuota(sizes, !replace), &quotaErr) {
+		writeQuotaError(w, quotaErr)
+
+		return
+	}
+
+	a.restoreSnapshot(manifest, files, replace, restoreSettings)
+
+	log.Printf("Imported snapshot with %d datasets", len(files))
+
+	response := map[string]any{
+		"success":      true,
+		"message":      fmt.Sprintf("Imported %d datasets", len(files)),
+		"imported":     len(files),
+		"total_files":  len(a.files),
+		"current_file": a.currentFileID,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeSnapshot writes all datasets and settings as a zip archive.
+func (a *API) writeSnapshot(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	manifest := snapshot{
+		Version:     snapshotVersion,
+		CreatedAt:   time.Now().Unix(),
+		CurrentFile: a.currentFileID,
+		Settings: snapshotSettings{
+			LiveRetentionSec: int64(a.liveRetention / time.Second),
+			StoreRawUploads:  !a.discardRaw,
+			Watchlist:        a.watchlist,
+			Suppressions:     a.suppressions,
+			Views:            a.views,
+			Annotations:      a.annotationList(),
+			LocalNetworks:    a.localNetworks.Strings(),
+		},
+		Datasets: make([]snapshotDataset, 0, len(a.files)),
+	}
+
+	fileIDs := make([]string, 0, len(a.files))
+	for fileID := range a.files {
+		fileIDs = append(fileIDs, fileID)
+	}
+	sort.Strings(fileIDs)
+
+	for _, fileID := range fileIDs {
+		fileData, err := a.snapshotFile(fileID)
+		if err != nil {
+			return err
+		}
+		dataset, err := writeSnapshotDataset(archive, fileID, fileData)
+		if err != nil {
+			return err
+		}
+		manifest.Datasets = append(manifest.Datasets, dataset)
+	}
+
+	entry, err := createZipEntry(archive, snapshotManifest)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(manifest)
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return fmt.Errorf("closing snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// snapshotFile returns the dataset to write to a snapshot. Evicted datasets are read from the
+// store without loading them back.
+func (a *API) snapshotFile(fileID string) (*FileData, error) {
+	fileData := a.files[fileID]
+	if fileData.unloaded == nil {
+		return fileData, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	_, stored := a.readStoredFile(ctx, fileID)
+	if stored == nil {
+		return nil, fmt.Errorf("%w: %s", errUnloadedDataset, fileID)
+	}
+
+	return stored, nil
+}
+
+// writeSnapshotDataset adds one dataset's content to the archive and returns its manifest entry.
+func writeSnapshotDataset(archive *zip.Writer, fileID string, fileData *FileData) (snapshotDataset, error) {
+	fileData.cacheMu.Lock()
+	defer fileData.cacheMu.Unlock()
+
+	dataset := snapshotDataset{
+		Metadata: storedMetadata(fileID, fileData),
+		Content:  snapshotDir + fileID + ".log",
+	}
+	for _, point := range fileData.rollups {
+		dataset.Rollups = append(dataset.Rollups, *point)
+	}
+	sort.Slice(dataset.Rollups, func(i, j int) bool {
+		return dataset.Rollups[i].Timestamp < dataset.Rollups[j].Timestamp
+	})
+
+	entry, err := createZipEntry(archive, dataset.Content)
+	if err != nil {
+		return dataset, err
+	}
+
+	if dataset.Raw {
+		_, err = entry.Write(fileData.raw)
+		if err != nil {
+			return dataset, fmt.Errorf("writing %s: %w", dataset.Content, err)
+		}
+
+		return dataset, nil
+	}
+
+	err = encodeConnections(entry, fileData.originalConnections())
+	if err != nil {
+		return dataset, fmt.Errorf("writing %s: %w", dataset.Content, err)
+	}
+
+	return dataset, nil
+}
+
+// createZipEntry adds a compressed, timestamped entry to the archive.
+func createZipEntry(archive *zip.Writer, name string) (io.Writer, error) {
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", name, err)
+	}
+
+	return entry, nil
+}
+
+// readSnapshot parses a snapshot archive and rebuilds its datasets, verifying the checksum
+// of every dataset stored with its original bytes.
+func readSnapshot(ctx context.Context, data []byte) (*snapshot, map[string]*FileData, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errSnapshotManifest, err)
+	}
+
+	contents := make(map[string]*zip.File, len(archive.File))
+	for _, entry := range archive.File {
+		contents[entry.Name] = entry
+	}
+
+	manifestEntry := contents[snapshotManifest]
+	if manifestEntry == nil {
+		return nil, nil, errSnapshotManifest
+	}
+
+	var manifest snapshot
+	err = readZipJSON(manifestEntry, &manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errSnapshotManifest, err)
+	}
+	if manifest.Version != snapshotVersion {
+		return nil, nil, fmt.Errorf("%w: %d", errSnapshotVersion, manifest.Version)
+	}
+
+	files := make(map[string]*FileData, len(manifest.Datasets))
+	for _, dataset := range manifest.Datasets {
+		fileData, err := readSnapshotDataset(ctx, contents[dataset.Content], dataset)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dataset %s: %w", dataset.ID, err)
+		}
+		files[dataset.ID] = fileData
+	}
+
+	return &manifest, files, nil
+}
+
+// readSnapshotDataset re-parses one dataset's content and restores its metadata.
+func readSnapshotDataset(ctx context.Context, entry *zip.File, dataset snapshotDataset) (*FileData, error) {
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %s", errSnapshotContent, dataset.Content)
+	}
+
+	reader, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", dataset.Content, err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, maxSnapshotSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dataset.Content, err)
+	}
+
+	fileData, err := fileDataFromContent(ctx, dataset.Metadata, content)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range dataset.Rollups {
+		if fileData.rollups == nil {
+			fileData.rollups = make(map[int64]*models.TimelinePoint)
+		}
+		fileData.rollups[dataset.Rollups[i].Timestamp] = &dataset.Rollups[i]
+	}
+
+	return fileData, nil
+}
+
+// readZipJSON decodes a JSON archive entry.
+func readZipJSON(entry *zip.File, target any) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", entry.Name, err)
+	}
+	defer reader.Close()
+
+	err = json.NewDecoder(reader).Decode(target)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", entry.Name, err)
+	}
+
+	return nil
+}
+
+// restoreSnapshot installs the datasets and settings of a snapshot. The live retention and
+// raw upload storage of the server it was taken on replace the running ones only with
+// restoreSettings. Callers must hold a.mu.
+func (a *API) restoreSnapshot(manifest *snapshot, files map[string]*FileData, replace, restoreSettings bool) {
+	if replace {
+		for fileID, fileData := range a.files {
+			fileData.release()
+			delete(a.files, fileID)
+			if files[fileID] == nil {
+				a.deleteStoredFile(fileID)
+				a.deleteSearchIndex(fileID)
+			}
+		}
+		a.currentFileID = ""
+	}
+
+	for fileID, fileData := range files {
+		if existing := a.files[fileID]; existing != nil {
+			existing.release()
+		}
+		a.guessFileServices(fileData)
+		a.files[fileID] = fileData
+		a.checkWatchlist(fileID, fileData)
+		a.persistFile(fileID, fileData)
+		a.indexFile(fileID, fileData)
+	}
+	a.requestEviction()
+
+	if restoreSettings {
+		a.liveRetention = time.Duration(manifest.Settings.LiveRetentionSec) * time.Second
+		a.discardRaw = !manifest.Settings.StoreRawUploads
+	}
+	if manifest.Settings.Suppressions != nil {
+		a.restoreSuppressions(manifest.Settings.Suppressions)
+	}
+	if manifest.Settings.Watchlist != nil {
+		a.restoreWatchlist(manifest.Settings.Watchlist)
+	}
+	if manifest.Settings.Views != nil {
+		a.restoreViews(manifest.Settings.Views)
+	}
+	if manifest.Settings.Annotations != nil {
+		a.restoreAnnotations(manifest.Settings.Annotations)
+	}
+	if manifest.Settings.LocalNetworks != nil {
+		err := a.SetLocalNetworks(manifest.Settings.LocalNetworks)
+		if err != nil {
+			log.Printf("Keeping local networks, snapshot has invalid ones: %v", err)
+		}
+	}
+
+	if a.files[manifest.CurrentFile] != nil {
+		a.currentFileID = manifest.CurrentFile
+	}
+	if a.currentFileID == "" {
+		for fileID := range files {
+			a.currentFileID = fileID
+
+			break
+		}
+	}
+	a.publishCurrentFile()
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// exportSnapshot returns a snapshot of the demo dataset taken with the given live retention
+// and raw upload storage.
+func exportSnapshot(t *testing.T, retention time.Duration, storeRaw bool) []byte {
+	t.Helper()
+
+	api, mux := newDemoServer(t)
+	api.SetLiveRetention(retention)
+	api.SetStoreRawUploads(storeRaw)
+	w := serve(mux, http.MethodGet, "/api/snapshot/export", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("exporting snapshot: status %d: %s", w.Code, w.Body)
+	}
+
+	return w.Body.Bytes()
+}
+
+func TestSnapshotImportKeepsServerSettings(t *testing.T) {
+	archive := exportSnapshot(t, 24*time.Hour, false)
+
+	for _, restore := range []bool{false, true} {
+		api, mux := newDemoServer(t)
+		api.SetLiveRetention(2 * time.Hour)
+		target := "/api/snapshot/import"
+		if restore {
+			target += "?restore_settings=true"
+		}
+		if w := serve(mux, http.MethodPost, target, string(archive), nil); w.Code != http.StatusOK {
+			t.Fatalf("POST %s: status %d: %s", target, w.Code, w.Body)
+		}
+
+		wantRetention, wantDiscard := 2*time.Hour, false
+		if restore {
+			wantRetention, wantDiscard = 24*time.Hour, true
+		}
+		if api.liveRetention != wantRetention || api.discardRaw != wantDiscard {
+			t.Errorf("POST %s: live retention %s, raw uploads discarded %t; want %s, %t",
+				target, api.liveRetention, api.discardRaw, wantRetention, wantDiscard)
+		}
+	}
+}
+
+func TestSnapshotImportDoesNotBlockReads(t *testing.T) {
+	archive := exportSnapshot(t, 0, true)
+	_, mux := newDemoServer(t)
+
+	// An archive still arriving from the client doesn't hold up readers
+	body, writer := io.Pipe()
+	imported := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/snapshot/import", body))
+		imported <- w
+	}()
+	_, err := writer.Write(archive[:len(archive)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan int, 1)
+	go func() {
+		read <- serve(mux, http.MethodGet, "/api/stats", "", nil).Code
+	}()
+	select {
+	case code := <-read:
+		if code != http.StatusOK {
+			t.Errorf("GET /api/stats during an import: status %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("GET /api/stats waited for the import to arrive")
+	}
+
+	_, err = writer.Write(archive[len(archive)/2:])
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := <-imported; w.Code != http.StatusOK {
+		t.Errorf("import: status %d: %s", w.Code, w.Body)
+	}
+}
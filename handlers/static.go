@@ -1,35 +1,207 @@
 package handlers
 
 import (
-	"embed"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
 	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// StaticHandler serves static files from the embedded filesystem..
-func StaticHandler(staticFS embed.FS) http.Handler {
-	staticSubFS, err := fs.Sub(staticFS, "static")
+const (
+	fingerprintLength = 12                                    // Hex digits of the content hash used in asset URLs
+	immutableCache    = "public, max-age=31536000, immutable" // Fingerprinted assets never change
+	revalidateCache   = "no-cache"                            // Other responses are revalidated with their ETag
+)
+
+// staticAsset is a static file with its content hash and precompressed variants.
+type staticAsset struct {
+	data      []byte
+	hash      string
+	modTime   time.Time
+	encodings map[string][]byte // Content by Content-Encoding, from variants generated by tools/precompress
+}
+
+// contentEncoding is a precompressed variant of static files.
+type contentEncoding struct {
+	name string
+	ext  string
+}
+
+// contentEncodings returns the precompressed variants in order of preference.
+func contentEncodings() []contentEncoding {
+	return []contentEncoding{{"br", ".br"}, {"gzip", ".gz"}}
+}
+
+// Assets serves static files with content-hash ETags. URLs produced by URL carry the hash
+// as a fingerprint, so browsers cache them for a year and fetch new versions after upgrades.
+type Assets struct {
+	fsys  fs.FS
+	mu    sync.Mutex
+	cache map[string]*staticAsset
+}
+
+// NewAssets serves the static files in fsys.
+func NewAssets(fsys fs.FS) *Assets {
+	return &Assets{fsys: fsys, cache: make(map[string]*staticAsset)}
+}
+
+// ServeHTTP serves a static file. Requests whose v parameter matches the current
+// fingerprint are cacheable forever; all others must revalidate.
+func (s *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	asset, err := s.load(name)
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if r.URL.Query().Get("v") == asset.hash[:fingerprintLength] {
+		w.Header().Set("Cache-Control", immutableCache)
+	} else {
+		w.Header().Set("Cache-Control", revalidateCache)
+	}
+
+	data, etag := asset.data, asset.hash
+	if len(asset.encodings) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, encoding := range contentEncodings() {
+			if compressed := asset.encodings[encoding.name]; compressed != nil && acceptsEncoding(r, encoding.name) {
+				w.Header().Set("Content-Encoding", encoding.name)
+				data, etag = compressed, asset.hash+"-"+encoding.name
+
+				break
+			}
+		}
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, name, asset.modTime, bytes.NewReader(data))
+}
+
+// acceptsEncoding reports whether the client accepts a content encoding, honoring q=0.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for accepted := range strings.SplitSeq(value, ",") {
+			coding, params, _ := strings.Cut(accepted, ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), encoding) {
+				continue
+			}
+
+			weight, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !found {
+				return true
+			}
+			quality, err := strconv.ParseFloat(weight, 64)
+
+			return err == nil && quality > 0
+		}
+	}
+
+	return false
+}
+
+// URL returns the fingerprinted URL of a static file under basePath.
+func (s *Assets) URL(basePath, name string) string {
+	asset, err := s.load(name)
 	if err != nil {
-		panic(err)
+		log.Printf("Failed to fingerprint %s: %v", name, err)
+
+		return basePath + "/static/" + name
 	}
 
-	return http.FileServer(http.FS(staticSubFS))
+	return basePath + "/static/" + name + "?v=" + asset.hash[:fingerprintLength]
 }
 
-// IndexHandler serves the main index.html file from embedded filesystem..
-func IndexHandler(staticFS embed.FS) http.HandlerFunc {
+// load returns a file with its hash. Files are re-read when their size or modification
+// time changes, so edits in an external static directory are picked up.
+func (s *Assets) load(name string) (*staticAsset, error) {
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only checked for existence
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached := s.cache[name]; cached != nil && cached.modTime.Equal(info.ModTime()) && int64(len(cached.data)) == info.Size() {
+		return cached, nil
+	}
+
+	data, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only checked for existence
+	}
+
+	digest := sha256.Sum256(data)
+	asset := &staticAsset{data: data, hash: hex.EncodeToString(digest[:]), modTime: info.ModTime()}
+	for _, encoding := range contentEncodings() {
+		// Variants are named after the hash of the content they were built from, so
+		// stale ones left over from an earlier build are never served
+		compressed, err := fs.ReadFile(s.fsys, variantName(name, asset.hash, encoding.ext))
+		if err != nil {
+			continue
+		}
+		if asset.encodings == nil {
+			asset.encodings = make(map[string][]byte)
+		}
+		asset.encodings[encoding.name] = compressed
+	}
+	s.cache[name] = asset
+
+	return asset, nil
+}
+
+// variantName returns the file name of a precompressed variant of content with the given hash.
+// It must match the names written by tools/precompress.
+func variantName(name, hash, ext string) string {
+	return name + "." + hash[:fingerprintLength] + ext
+}
+
+// IndexHandler serves the main index.html file from the static assets, rendered as a
+// template with the UI configuration and an asset function for fingerprinted URLs.
+func IndexHandler(assets *Assets, config func() UIConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Read index.html from embedded filesystem
-		data, err := staticFS.ReadFile("static/index.html")
+		settings := config()
+
+		// Parse index.html on every request, so edits in an external static directory show up immediately
+		index, err := template.New("index.html").Funcs(template.FuncMap{
+			"asset": func(name string) string { return assets.URL(settings.BasePath, name) },
+		}).ParseFS(assets.fsys, "index.html")
 		if err != nil {
+			log.Printf("Failed to parse index.html: %v", err)
 			http.Error(w, "Index file not found", http.StatusNotFound)
 
 			return
 		}
 
+		var page bytes.Buffer
+		err = index.Execute(&page, settings)
+		if err != nil {
+			log.Printf("Failed to render index.html: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, err = w.Write(data)
+		w.Header().Set("Cache-Control", revalidateCache)
+		_, err = w.Write(page.Bytes())
 		if err != nil {
 			log.Printf("Error writing response: %v", err)
 		}
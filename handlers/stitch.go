@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const maxStitchGap = 3600 // Seconds between two records of a flow that stitching bridges at most
+
+var (
+	errInvalidStitchGap = errors.New("stitch_gap must be between 0 and 3600 seconds")
+	errStitchLive       = errors.New("flow stitching isn't available for live datasets")
+)
+
+// stitchConnections merges the records Zeek split one flow into: records of the same
+// originator and responder address and port and protocol where each starts at most gap
+// seconds after the previous one ended. Bytes and packets are summed, the duration spans all
+// records, the history is concatenated, and the state is that of the last record; Stitched
+// counts the records merged. The merged record takes the place of the flow's first record.
+// It returns the stitched connections and the number of records merged away.
+func stitchConnections(connections []models.Connection, gap float64) ([]models.Connection, int) {
+	flows := make(map[string][]int)
+	for i := range connections {
+		key := flowKey(&connections[i])
+		flows[key] = append(flows[key], i)
+	}
+
+	heads := make(map[int]models.Connection)
+	merged := make([]bool, len(connections))
+	removed := 0
+	for _, indexes := range flows {
+		if len(indexes) < 2 { //nolint:mnd // A single record has nothing to merge with
+			continue
+		}
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return connections[indexes[i]].Timestamp < connections[indexes[j]].Timestamp
+		})
+
+		head := indexes[0]
+		current := connections[head]
+		for _, i := range indexes[1:] {
+			next := &connections[i]
+			if next.Timestamp-connectionEnd(&current) > gap {
+				if current.Stitched > 0 {
+					heads[head] = current
+				}
+				head, current = i, *next
+
+				continue
+			}
+			mergeRecord(&current, next)
+			merged[i] = true
+			removed++
+		}
+		if current.Stitched > 0 {
+			heads[head] = current
+		}
+	}
+
+	if removed == 0 {
+		return connections, 0
+	}
+
+	stitched := make([]models.Connection, 0, len(connections)-removed)
+	for i := range connections {
+		if merged[i] {
+			continue
+		}
+		if head, exists := heads[i]; exists {
+			stitched = append(stitched, head)
+
+			continue
+		}
+		stitched = append(stitched, connections[i])
+	}
+
+	return stitched, removed
+}
+
+// flowKey returns the 5-tuple identifying the flow of a connection.
+func flowKey(conn *models.Connection) string {
+	return conn.OrigHost + groupKeySeparator + conn.RespHost + groupKeySeparator + conn.Protocol + groupKeySeparator +
+		strconv.Itoa(conn.OrigPort) + groupKeySeparator + strconv.Itoa(conn.RespPort)
+}
+
+// mergeRecord adds a later record of the same flow to the stitched record conn.
+func mergeRecord(conn, next *models.Connection) {
+	if conn.Stitched == 0 {
+		conn.Stitched = 1
+	}
+	conn.Stitched += max(next.Stitched, 1)
+
+	conn.Duration = max(connectionEnd(conn), connectionEnd(next)) - conn.Timestamp
+	conn.OrigBytes += next.OrigBytes
+	conn.RespBytes += next.RespBytes
+	conn.MissedBytes += next.MissedBytes
+	conn.OrigPackets += next.OrigPackets
+	conn.RespPackets += next.RespPackets
+	conn.OrigIPBytes += next.OrigIPBytes
+	conn.RespIPBytes += next.RespIPBytes
+	conn.History += next.History
+	conn.ConnState = next.ConnState
+	if conn.Service == "" {
+		conn.Service = next.Service
+	}
+}
+
+// originalConnections returns the file's connections as logged, before any flow stitching.
+// Callers must hold cacheMu.
+func (f *FileData) originalConnections() []models.Connection {
+	if f.unstitched != nil {
+		return f.unstitched
+	}
+
+	return f.Connections
+}
+
+// setStitchGap turns flow stitching of the file on with the given gap, or off with 0,
+// restitching its connections as logged.
+func (f *FileData) setStitchGap(gap float64) {
+	f.cacheMu.Lock()
+	connections := f.originalConnections()
+	f.cacheMu.Unlock()
+
+	f.StitchGap = gap
+	f.setConnections(connections, connstats.Compute(connections))
+}
+
+// stitchedRecords returns the number of records flow stitching merged away.
+func (f *FileData) stitchedRecords() int {
+	if f.unstitched == nil {
+		return 0
+	}
+
+	return len(f.unstitched) - len(f.Connections)
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+	"zeek-viz/store"
+)
+
+const storeTimeout = 30 * time.Second // Upper bound for one store operation
+
+var errDatasetChecksum = errors.New("dataset checksum mismatch")
+
+// SetStore persists datasets in s, shared with any other instance using the same store.
+// The datasets already stored there are listed right away and loaded in the background,
+// most recently uploaded first, so a large store doesn't delay startup.
+func (a *API) SetStore(ctx context.Context, s store.Store) error {
+	stored, err := s.List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing stored datasets: %w", err)
+	}
+
+	a.store = s
+	a.storePending.Store(int64(len(stored)))
+	go a.loadStore(stored)
+
+	return nil
+}
+
+// loadStore loads the datasets listed at startup, holding the lock only to add each parsed
+// dataset, so requests are answered while the rest are still being loaded.
+func (a *API) loadStore(stored []store.Metadata) {
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].UploadTime > stored[j].UploadTime
+	})
+
+	start := time.Now()
+	for _, listed := range stored {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		meta, fileData := a.readStoredFile(ctx, listed.ID)
+		cancel()
+
+		if fileData != nil {
+			a.mu.Lock()
+			if local := a.files[meta.ID]; local == nil || local.storedAt < meta.UpdatedAt {
+				a.addStoredFile(meta, fileData)
+			}
+			if a.currentFileID == "" {
+				a.currentFileID = meta.ID
+				a.files[meta.ID].warmCaches(a.localNetworks)
+			}
+			a.mu.Unlock()
+		}
+		a.storePending.Add(-1)
+	}
+	log.Printf("Loaded %d stored datasets in %s", len(stored), time.Since(start).Round(time.Millisecond))
+
+	if a.cache != nil {
+		a.loadCurrentFile(context.Background())
+	}
+}
+
+// persistFile writes a dataset to the store, if one is configured. Failures are logged;
+// the dataset stays available on this instance.
+func (a *API) persistFile(fileID string, fileData *FileData) {
+	if a.store == nil {
+		return
+	}
+
+	fileData.cacheMu.Lock()
+	meta := storedMetadata(fileID, fileData)
+	meta.UpdatedAt = time.Now().UnixNano()
+	content := fileData.raw
+	if content == nil {
+		var encoded bytes.Buffer
+		err := encodeConnections(&encoded, fileData.originalConnections())
+		if err != nil {
+			fileData.cacheMu.Unlock()
+			log.Printf("Failed to encode dataset %s for the store: %v", fileID, err)
+
+			return
+		}
+		content = encoded.Bytes()
+	}
+	fileData.cacheMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	err := a.store.Put(ctx, meta, content)
+	if err != nil {
+		log.Printf("Failed to store dataset %s: %v", fileID, err)
+
+		return
+	}
+	fileData.storedAt = meta.UpdatedAt
+}
+
+// deleteStoredFile removes a dataset from the store, if one is configured.
+func (a *API) deleteStoredFile(fileID string) {
+	if a.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	err := a.store.Delete(ctx, fileID)
+	if err != nil {
+		log.Printf("Failed to delete dataset %s from the store: %v", fileID, err)
+	}
+}
+
+// syncStore picks up changes made by other instances before a request is answered. It is
+// skipped until the datasets listed at startup are loaded.
+func (a *API) syncStore(ctx context.Context) {
+	if a.storePending.Load() > 0 {
+		return
+	}
+
+	err := a.syncFromStore(ctx)
+	if err != nil {
+		log.Printf("Failed to sync datasets from the store: %v", err)
+	}
+}
+
+// syncFromStore loads datasets added or replaced by other instances and drops stored
+// datasets that were deleted elsewhere. Datasets never stored (such as live streams) are kept.
+func (a *API) syncFromStore(ctx context.Context) error {
+	if a.store == nil {
+		return nil
+	}
+
+	stored, err := a.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing stored datasets: %w", err)
+	}
+
+	present := make(map[string]bool, len(stored))
+	for _, meta := range stored {
+		present[meta.ID] = true
+		if local := a.files[meta.ID]; local != nil && local.storedAt >= meta.UpdatedAt {
+			continue
+		}
+
+		a.loadStoredFile(ctx, meta.ID)
+	}
+
+	for fileID, fileData := range a.files {
+		if fileData.storedAt == 0 || present[fileID] {
+			continue
+		}
+
+		log.Printf("Dataset %s was deleted from the store", fileID)
+		fileData.release()
+		delete(a.files, fileID)
+		if a.currentFileID == fileID {
+			a.currentFileID = ""
+		}
+	}
+
+	if a.currentFileID == "" {
+		for fileID := range a.files {
+			a.currentFileID = fileID
+
+			break
+		}
+	}
+
+	return nil
+}
+
+// loadStoredFile parses a stored dataset into the files map, replacing any local copy.
+func (a *API) loadStoredFile(ctx context.Context, fileID string) {
+	meta, fileData := a.readStoredFile(ctx, fileID)
+	if fileData != nil {
+		a.addStoredFile(meta, fileData)
+	}
+}
+
+// readStoredFile fetches and parses a stored dataset. Failures are logged and return nil.
+func (a *API) readStoredFile(ctx context.Context, fileID string) (store.Metadata, *FileData) {
+	meta, content, err := a.store.Get(ctx, fileID)
+	if errors.Is(err, store.ErrNotFound) {
+		return meta, nil // Deleted since it was listed
+	}
+	if err != nil {
+		log.Printf("Failed to load stored dataset %s: %v", fileID, err)
+
+		return meta, nil
+	}
+
+	fileData, err := fileDataFromContent(ctx, meta, content)
+	if err != nil {
+		log.Printf("Failed to parse stored dataset %s: %v", fileID, err)
+
+		return meta, nil
+	}
+	fileData.storedAt = meta.UpdatedAt
+
+	return meta, fileData
+}
+
+// addStoredFile adds a parsed stored dataset to the files map, replacing any local copy.
+// Callers must hold a.mu.
+func (a *API) addStoredFile(meta store.Metadata, fileData *FileData) {
+	if existing := a.files[meta.ID]; existing != nil {
+		existing.release()
+	}
+	a.guessFileServices(fileData)
+	a.files[meta.ID] = fileData
+	a.checkWatchlist(meta.ID, fileData)
+	a.indexFile(meta.ID, fileData)
+	a.requestEviction()
+
+	log.Printf("Loaded stored dataset %s (%s, %d connections)", meta.ID, meta.Filename, len(fileData.Connections))
+}
+
+// storedMetadata describes a file for the store and snapshots.
+func storedMetadata(fileID string, fileData *FileData) store.Metadata {
+	return store.Metadata{
+		ID:          fileID,
+		Filename:    fileData.Filename,
+		UploadTime:  fileData.UploadTime,
+		Size:        fileData.Size,
+		Tags:        fileData.Tags,
+		SHA256:      fileData.SHA256,
+		Dataset:     fileData.Dataset,
+		Name:        fileData.Name,
+		Description: fileData.Description,
+		CaseNumber:  fileData.CaseNumber,
+		ParseMode:   fileData.ParseMode,
+		StitchGap:   fileData.StitchGap,
+		Raw:         fileData.raw != nil,
+	}
+}
+
+// fileDataFromContent rebuilds a file from stored content, verifying the checksum of
+// original uploads.
+func fileDataFromContent(ctx context.Context, meta store.Metadata, content []byte) (*FileData, error) {
+	if meta.Raw && meta.SHA256 != "" {
+		digest := sha256.Sum256(content)
+		if hex.EncodeToString(digest[:]) != meta.SHA256 {
+			return nil, errDatasetChecksum
+		}
+	}
+
+	connections, stats, report, err := parseConnections(ctx, bytes.NewReader(content), false)
+	if err != nil {
+		return nil, err
+	}
+
+	fileData := storedFileData(meta)
+	fileData.ParseReport = report
+	if meta.Raw {
+		fileData.raw = content
+	}
+	fileData.setConnections(connections, stats)
+
+	return fileData, nil
+}
+
+// storedFileData returns a file with the metadata of a stored dataset and no connections yet.
+func storedFileData(meta store.Metadata) *FileData {
+	return &FileData{
+		Filename:    meta.Filename,
+		UploadTime:  meta.UploadTime,
+		Size:        meta.Size,
+		Tags:        meta.Tags,
+		SHA256:      meta.SHA256,
+		Dataset:     meta.Dataset,
+		Name:        meta.Name,
+		Description: meta.Description,
+		CaseNumber:  meta.CaseNumber,
+		ParseMode:   meta.ParseMode,
+		StitchGap:   meta.StitchGap,
+	}
+}
+
+// encodeConnections writes connections as Zeek JSON lines.
+func encodeConnections(w io.Writer, connections []models.Connection) error {
+	encoder := json.NewEncoder(w)
+	for i := range connections {
+		err := encoder.Encode(&connections[i])
+		if err != nil {
+			return fmt.Errorf("encoding connection: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultIngestBudget = 2 << 30       // Heap growth allowed while parsing a streamed upload (2GiB)
+	maxTrackedIngests   = 50            // Streamed uploads whose status is kept
+	streamFilename      = "stream.log"  // Filename of streamed uploads that don't name one
+	uploadIDHeader      = "X-Upload-ID" // Response header carrying the ID of a streamed upload
+	uploadIDBytes       = 8             // Random bytes of generated upload IDs
+
+	ingestReceiving = "receiving" // Body is being received and parsed
+	ingestDone      = "done"      // Stored as a dataset
+	ingestFailed    = "failed"    // Rejected or aborted
+)
+
+var (
+	errIngestBudget  = errors.New("streamed upload exceeds the ingest memory budget")
+	errUploadIDInUse = errors.New("upload_id belongs to an upload in progress")
+	errIngestUnknown = errors.New("unknown upload")
+)
+
+// IngestStatus reports the progress of a streamed upload.
+type IngestStatus struct {
+	ID         string  `json:"id"`
+	State      string  `json:"state"`
+	Filename   string  `json:"filename"`
+	BytesRead  int64   `json:"bytes_read"`            //nolint:tagliatelle // API consistency
+	TotalBytes int64   `json:"total_bytes,omitempty"` //nolint:tagliatelle // API consistency
+	Percent    float64 `json:"percent,omitempty"`     // Share of total_bytes read, when the client sent a Content-Length
+	Lines      int64   `json:"lines"`
+	HeapDelta  int64   `json:"heap_delta"`            //nolint:tagliatelle // API consistency
+	FileID     string  `json:"file_id,omitempty"`     //nolint:tagliatelle // API consistency
+	Error      string  `json:"error,omitempty"`       // Why the upload failed
+	StartedAt  int64   `json:"started_at"`            //nolint:tagliatelle // API consistency
+	FinishedAt int64   `json:"finished_at,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// ingestProgress tracks a streamed upload. Counters are updated by the reader while the
+// status is polled concurrently.
+type ingestProgress struct {
+	bytesRead atomic.Int64
+	lines     atomic.Int64
+	heapDelta atomic.Int64
+
+	mu     sync.Mutex
+	status IngestStatus
+}
+
+// progressReader counts the bytes and lines of a streamed body and aborts reading with
+// errIngestBudget once the heap has grown by more than budget bytes since it started.
+type progressReader struct {
+	reader   io.Reader
+	progress *ingestProgress
+	baseHeap int64
+	budget   int64
+}
+
+// Read reads from the body and updates the progress.
+func (p *progressReader) Read(buf []byte) (int, error) {
+	delta := heapBytes() - p.baseHeap
+	p.progress.heapDelta.Store(max(p.progress.heapDelta.Load(), delta))
+	if delta > p.budget {
+		return 0, fmt.Errorf("%w (%d MiB)", errIngestBudget, p.budget>>20)
+	}
+
+	n, err := p.reader.Read(buf)
+	p.progress.bytesRead.Add(int64(n))
+	p.progress.lines.Add(int64(bytes.Count(buf[:n], []byte{'\n'})))
+
+	return n, err //nolint:wrapcheck // io.EOF must stay comparable
+}
+
+// SetIngestBudget limits the heap growth while a streamed upload is parsed (0 for the default).
+func (a *API) SetIngestBudget(budget int64) {
+	a.ingestBudget = budget
+}
+
+// StreamUpload ingests a conn.log sent as the raw request body, parsing it while it streams
+// in, so logs of any size can be loaded without multipart buffering or the server timeouts.
+// Progress is available from /api/upload/status/{id} while the request runs; clients pick
+// the ID with upload_id. Parsing stops with 413 when the heap grows beyond the ingest budget.
+func (a *API) StreamUpload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode, dedup := query.Get("mode"), query.Get("dedup")
+	if mode == "" {
+		mode = lenientMode
+	}
+	if dedup == "" {
+		dedup = dedupNone
+	}
+	if mode != lenientMode && mode != strictMode {
+		http.Error(w, errInvalidParseMode.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if !validDedup(dedup) {
+		http.Error(w, errInvalidDedup.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	filename := query.Get("filename")
+	if filename == "" {
+		filename = streamFilename
+	}
+	progress, err := a.startIngest(query.Get("upload_id"), filename, r.ContentLength)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+	w.Header().Set(uploadIDHeader, progress.status.ID)
+
+	// The body may take far longer to arrive than the server's timeouts allow
+	controller := http.NewResponseController(w)
+	_ = controller.SetReadDeadline(time.Time{})  // Not every ResponseWriter supports deadlines
+	_ = controller.SetWriteDeadline(time.Time{}) // Not every ResponseWriter supports deadlines
+
+	budget := a.ingestBudget
+	if budget <= 0 {
+		budget = defaultIngestBudget
+	}
+	log.Printf("Receiving streamed upload %s: %s (%d bytes announced, %s mode)", progress.status.ID, filename, r.ContentLength, mode)
+
+	reader := &progressReader{reader: r.Body, progress: progress, baseHeap: heapBytes(), budget: budget}
+	upload, ok := a.parseUpload(r.Context(), w, reader, mode, dedup, false)
+	if !ok {
+		progress.finish("", "upload rejected; see the upload response")
+
+		return
+	}
+	upload.filename = filename
+	upload.size = progress.bytesRead.Load()
+	upload.metrics.log(filename)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fileID := a.storeUpload(w, r, upload)
+	if fileID == "" {
+		progress.finish("", "upload rejected; see the upload response")
+
+		return
+	}
+	progress.finish(fileID, "")
+}
+
+// GetIngestStatus reports the progress of a streamed upload.
+func (a *API) GetIngestStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	a.ingestMu.Lock()
+	progress := a.ingests[r.PathValue("id")]
+	a.ingestMu.Unlock()
+	if progress == nil {
+		http.Error(w, errIngestUnknown.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(progress.snapshot())
+	if err != nil {
+		log.Printf("Failed to encode upload status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// startIngest registers a streamed upload, generating an ID when none is given. Finished
+// uploads beyond maxTrackedIngests are forgotten, oldest first.
+func (a *API) startIngest(id, filename string, totalBytes int64) (*ingestProgress, error) {
+	a.ingestMu.Lock()
+	defer a.ingestMu.Unlock()
+
+	if id == "" {
+		random := make([]byte, uploadIDBytes)
+		_, _ = rand.Read(random) // crypto/rand never fails on supported platforms
+		id = hex.EncodeToString(random)
+	}
+	if existing := a.ingests[id]; existing != nil && existing.snapshot().State == ingestReceiving {
+		return nil, errUploadIDInUse
+	}
+
+	if a.ingests == nil {
+		a.ingests = make(map[string]*ingestProgress)
+	}
+	progress := &ingestProgress{status: IngestStatus{
+		ID:         id,
+		State:      ingestReceiving,
+		Filename:   filename,
+		TotalBytes: max(totalBytes, 0),
+		StartedAt:  time.Now().Unix(),
+	}}
+	a.ingests[id] = progress
+
+	finished := make([]IngestStatus, 0, len(a.ingests))
+	for _, tracked := range a.ingests {
+		if status := tracked.snapshot(); status.State != ingestReceiving {
+			finished = append(finished, status)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt < finished[j].FinishedAt
+	})
+	for _, status := range finished[:max(0, len(a.ingests)-maxTrackedIngests)] {
+		delete(a.ingests, status.ID)
+	}
+
+	return progress, nil
+}
+
+// finish records the outcome of the upload.
+func (p *ingestProgress) finish(fileID, failure string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.status.State = ingestDone
+	if failure != "" {
+		p.status.State = ingestFailed
+	}
+	p.status.FileID = fileID
+	p.status.Error = failure
+	p.status.FinishedAt = time.Now().Unix()
+}
+
+// snapshot returns the current status.
+func (p *ingestProgress) snapshot() IngestStatus {
+	p.mu.Lock()
+	status := p.status
+	p.mu.Unlock()
+
+	status.BytesRead = p.bytesRead.Load()
+	status.Lines = p.lines.Load()
+	status.HeapDelta = p.heapDelta.Load()
+	if status.TotalBytes > 0 {
+		status.Percent = roundTenth(100 * float64(status.BytesRead) / float64(status.TotalBytes)) //nolint:mnd // Percent
+	}
+
+	return status
+}
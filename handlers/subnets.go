@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"zeek-viz/models"
+	"zeek-viz/netgraph"
+)
+
+const (
+	ipv4Bits         = 32  // Length of an IPv4 address in bits
+	ipv6Bits         = 128 // Length of an IPv6 address in bits
+	defaultIPv6Group = 64  // IPv6 prefix length hosts are grouped by when only subnet_group is set
+)
+
+var (
+	errInvalidSubnetGroup   = errors.New("subnet_group must be an IPv4 prefix length between 1 and 32")
+	errInvalidSubnetGroupV6 = errors.New("subnet_group_v6 must be an IPv6 prefix length between 1 and 128")
+)
+
+// subnetGrouping collapses hosts into their subnets. A prefix length of 0 leaves hosts of that
+// address family ungrouped. With systems set, external hosts are collapsed into their
+// autonomous systems instead.
+type subnetGrouping struct {
+	ipv4    int
+	ipv6    int
+	systems *asnGrouping
+}
+
+// parseSubnetGrouping reads the subnet_group (IPv4) and subnet_group_v6 prefix lengths. IPv6
+// hosts are grouped by /64 unless subnet_group_v6 says otherwise.
+func parseSubnetGrouping(query url.Values) (*subnetGrouping, error) {
+	grouping := &subnetGrouping{}
+	if value := query.Get("subnet_group"); value != "" {
+		bits, err := strconv.Atoi(value)
+		if err != nil || bits < 1 || bits > ipv4Bits {
+			return nil, errInvalidSubnetGroup
+		}
+		grouping.ipv4, grouping.ipv6 = bits, defaultIPv6Group
+	}
+	if value := query.Get("subnet_group_v6"); value != "" {
+		bits, err := strconv.Atoi(value)
+		if err != nil || bits < 1 || bits > ipv6Bits {
+			return nil, errInvalidSubnetGroupV6
+		}
+		grouping.ipv6 = bits
+	}
+
+	return grouping, nil
+}
+
+// enabled reports whether any hosts are grouped.
+func (g *subnetGrouping) enabled() bool {
+	return g.ipv4 > 0 || g.ipv6 > 0 || g.systems != nil
+}
+
+// subnet returns the subnet host belongs to in CIDR notation, or the node of its autonomous
+// system, or host itself when its address family isn't grouped or it isn't an IP address.
+func (g *subnetGrouping) subnet(host string) string {
+	if g.systems != nil {
+		if id, ok := g.systems.node(host); ok {
+			return id
+		}
+	}
+
+	addr, err := models.ParseHost(host)
+	if err != nil {
+		return host
+	}
+
+	bits := g.ipv6
+	if addr.Is4() {
+		bits = g.ipv4
+	}
+	if bits == 0 || bits == addr.BitLen() {
+		return host
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return host
+	}
+
+	return prefix.String()
+}
+
+// buildSubnetGraph builds the graph with every subnet collapsed into one node. Traffic within
+// a subnet is counted once on its node instead of appearing as a self-loop; members reports
+// how many distinct hosts a subnet node stands for. A subnet is local when it lies entirely
+// within the local networks; autonomous system nodes are external. The aggregation selects
+// the connections that share an edge.
+func buildSubnetGraph(connections []models.Connection, grouping *subnetGrouping, local models.LocalNetworks, aggregation string) ([]models.Node, []models.Edge) {
+	grouped := make([]models.Connection, len(connections))
+	members := make(map[string]map[string]bool)
+	member := func(host string) string {
+		subnet := grouping.subnet(host)
+		if subnet != host {
+			if members[subnet] == nil {
+				members[subnet] = make(map[string]bool)
+			}
+			members[subnet][host] = true
+		}
+
+		return subnet
+	}
+	for i := range connections {
+		grouped[i] = connections[i]
+		grouped[i].OrigHost = member(connections[i].OrigHost)
+		grouped[i].RespHost = member(connections[i].RespHost)
+	}
+
+	nodes, edges := netgraph.NodesAndEdges(grouped, local, aggregation)
+	nodeIndex := make(map[string]int, len(nodes))
+	for i := range nodes {
+		nodeIndex[nodes[i].ID] = i
+		nodes[i].Members = len(members[nodes[i].ID])
+	}
+
+	kept := edges[:0]
+	for _, edge := range edges {
+		if edge.Source != edge.Target {
+			kept = append(kept, edge)
+
+			continue
+		}
+		node := &nodes[nodeIndex[edge.Source]]
+		node.Connections -= edge.Count // Both endpoints were counted on the same node
+		node.TotalBytes -= edge.TotalBytes
+		node.InternalConns += edge.Count
+	}
+	if grouping.systems != nil {
+		grouping.systems.annotate(nodes)
+	}
+
+	return nodes, kept
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const watchlistRule = "watchlist" // Finding rule of watchlist hits
+
+var (
+	errSuppressionScope    = errors.New("a suppression needs a host or a rule")
+	errSuppressionPeer     = errors.New("peer requires host")
+	errSuppressionRule     = errors.New("unknown rule")
+	errSuppressionNotFound = errors.New("suppression not found")
+)
+
+// Suppression silences findings of known-benign behavior: a rule everywhere, all rules for a
+// host, a rule for a host, or findings between a host and a peer. Hosts and peers are IP
+// addresses or CIDR prefixes.
+type Suppression struct {
+	ID        string `json:"id"`
+	Rule      string `json:"rule,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Peer      string `json:"peer,omitempty"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt int64  `json:"created_at"` //nolint:tagliatelle // API consistency
+
+	host netip.Prefix
+	peer netip.Prefix
+}
+
+// findingRules returns the IDs of the rules that produce findings.
+func findingRules() []string {
+	return []string{"external", "unusual_ports", "failed_connections", watchlistRule, beaconRule, scanRule, exfilRule, longConnectionRule, tlsRule}
+}
+
+// parse validates the suppression and prepares its address prefixes.
+func (s *Suppression) parse() error {
+	if s.Host == "" && s.Rule == "" {
+		return errSuppressionScope
+	}
+	if s.Peer != "" && s.Host == "" {
+		return errSuppressionPeer
+	}
+	if s.Rule != "" && !slices.Contains(findingRules(), s.Rule) {
+		return fmt.Errorf("%w %q", errSuppressionRule, s.Rule)
+	}
+
+	var err error
+	if s.Host != "" {
+		s.host, err = parseWatchlistValue(s.Host)
+		if err != nil {
+			return fmt.Errorf("host: %w", err)
+		}
+		s.Host = watchlistValue(s.host)
+	}
+	if s.Peer != "" {
+		s.peer, err = parseWatchlistValue(s.Peer)
+		if err != nil {
+			return fmt.Errorf("peer: %w", err)
+		}
+		s.Peer = watchlistValue(s.peer)
+	}
+
+	digest := sha256.Sum256([]byte(s.Rule + "|" + s.Host + "|" + s.Peer))
+	s.ID = hex.EncodeToString(digest[:8])
+
+	return nil
+}
+
+// suppressesHost reports whether the suppression silences a finding of the rule about a host.
+// Host-pair suppressions only apply to findings about connections.
+func (s *Suppression) suppressesHost(rule string, host netip.Addr) bool {
+	return s.Peer == "" && (s.Rule == "" || s.Rule == rule) && (s.Host == "" || s.host.Contains(host))
+}
+
+// suppressesConnection reports whether the suppression silences a finding of the rule about
+// a connection between orig and resp, in either direction.
+func (s *Suppression) suppressesConnection(rule string, orig, resp netip.Addr) bool {
+	if s.Rule != "" && s.Rule != rule {
+		return false
+	}
+	if s.Host == "" {
+		return true
+	}
+
+	return (s.host.Contains(orig) && (s.Peer == "" || s.peer.Contains(resp))) ||
+		(s.host.Contains(resp) && (s.Peer == "" || s.peer.Contains(orig)))
+}
+
+// SetSuppressionsFile persists suppressions in path, loading the ones saved there.
+func (a *API) SetSuppressionsFile(path string) error {
+	var suppressions []Suppression
+	err := readJSONFile(path, &suppressions)
+	if err != nil {
+		return err
+	}
+
+	for i := range suppressions {
+		err = suppressions[i].parse()
+		if err != nil {
+			return fmt.Errorf("suppression %s: %w", suppressions[i].ID, err)
+		}
+	}
+
+	a.suppressionsPath = path
+	a.setSuppressions(suppressions)
+
+	return nil
+}
+
+// GetSuppressions returns the configured suppressions.
+func (a *API) GetSuppressions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	suppressions := slices.Clone(a.suppressions)
+	if suppressions == nil {
+		suppressions = []Suppression{}
+	}
+
+	err := json.NewEncoder(w).Encode(map[string]any{"suppressions": suppressions, "rules": findingRules()})
+	if err != nil {
+		log.Printf("Failed to encode suppressions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// AddSuppression adds a suppression, or updates the note of an identical one.
+func (a *API) AddSuppression(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var suppression Suppression
+	err := json.NewDecoder(r.Body).Decode(&suppression)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+
+	err = suppression.parse()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	suppression.CreatedAt = time.Now().Unix()
+
+	suppressions := slices.DeleteFunc(slices.Clone(a.suppressions), func(existing Suppression) bool {
+		return existing.ID == suppression.ID
+	})
+	a.setSuppressions(append(suppressions, suppression))
+
+	err = a.saveSuppressions()
+	if err != nil {
+		log.Printf("Failed to save suppressions: %v", err)
+		http.Error(w, "Failed to save suppressions", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Added suppression %s (rule %q, host %q, peer %q)", suppression.ID, suppression.Rule, suppression.Host, suppression.Peer)
+
+	err = json.NewEncoder(w).Encode(suppression)
+	if err != nil {
+		log.Printf("Failed to encode suppression: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// DeleteSuppression removes a suppression, so its findings are reported again.
+func (a *API) DeleteSuppression(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	remaining := slices.DeleteFunc(slices.Clone(a.suppressions), func(suppression Suppression) bool {
+		return suppression.ID == id
+	})
+	if len(remaining) == len(a.suppressions) {
+		http.Error(w, errSuppressionNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+	a.setSuppressions(remaining)
+
+	err := a.saveSuppressions()
+	if err != nil {
+		log.Printf("Failed to save suppressions: %v", err)
+		http.Error(w, "Failed to save suppressions", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Removed suppression %s", id)
+
+	err = json.NewEncoder(w).Encode(map[string]any{"success": true, "id": id})
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// restoreSuppressions replaces the suppressions with those of a snapshot, skipping invalid ones.
+func (a *API) restoreSuppressions(suppressions []Suppression) {
+	valid := make([]Suppression, 0, len(suppressions))
+	for _, suppression := range suppressions {
+		err := suppression.parse()
+		if err != nil {
+			log.Printf("Skipping invalid suppression %s: %v", suppression.ID, err)
+
+			continue
+		}
+		valid = append(valid, suppression)
+	}
+	a.setSuppressions(valid)
+
+	err := a.saveSuppressions()
+	if err != nil {
+		log.Printf("Failed to save suppressions: %v", err)
+	}
+}
+
+// setSuppressions replaces the suppressions, invalidates cached responses that reflect the
+// previous ones, and re-checks all loaded datasets.
+func (a *API) setSuppressions(suppressions []Suppression) {
+	sort.Slice(suppressions, func(i, j int) bool {
+		return suppressions[i].ID < suppressions[j].ID
+	})
+	a.suppressions = suppressions
+	a.settingsVersion = time.Now().UnixNano()
+
+	for fileID, fileData := range a.files {
+		a.checkWatchlist(fileID, fileData)
+	}
+}
+
+// saveSuppressions writes the suppressions to their file, if one is configured.
+func (a *API) saveSuppressions() error {
+	if a.suppressionsPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.suppressions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding suppressions: %w", err)
+	}
+
+	return writeFileAtomic(a.suppressionsPath, data)
+}
+
+// suppressNodeFindings removes suppressed risk factors from the nodes and recomputes their
+// scores. It returns a copy of the nodes and the number of suppressed findings.
+func suppressNodeFindings(nodes []models.Node, suppressions []Suppression) ([]models.Node, int) {
+	if len(suppressions) == 0 {
+		return nodes, 0
+	}
+
+	nodes = slices.Clone(nodes)
+	suppressed := 0
+	for i := range nodes {
+		node := &nodes[i]
+		addr, err := models.ParseHost(node.ID)
+		if err != nil || len(node.RiskFactors) == 0 {
+			continue
+		}
+
+		factors := maps.Clone(node.RiskFactors)
+		for rule := range factors {
+			for j := range suppressions {
+				if suppressions[j].suppressesHost(rule, addr) {
+					delete(factors, rule)
+					suppressed++
+
+					break
+				}
+			}
+		}
+		if len(factors) == len(node.RiskFactors) {
+			continue
+		}
+
+		var score float64
+		for _, points := range factors {
+			score += points
+		}
+		node.RiskScore = roundTenth(score)
+		node.RiskFactors = factors
+		if len(factors) == 0 {
+			node.RiskFactors = nil
+		}
+	}
+
+	return nodes, suppressed
+}
+
+// connectionSuppressed reports whether any suppression silences a finding of the rule about
+// the connection.
+func connectionSuppressed(suppressions []Suppression, rule string, conn *models.Connection) bool {
+	if len(suppressions) == 0 {
+		return false
+	}
+
+	orig, _ := models.ParseHost(conn.OrigHost) // Unparsable hosts only match rule-wide suppressions
+	resp, _ := models.ParseHost(conn.RespHost)
+	for i := range suppressions {
+		if suppressions[i].suppressesConnection(rule, orig, resp) {
+			return true
+		}
+	}
+
+	return false
+}
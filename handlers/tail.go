@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"zeek-viz/models"
+	"zeek-viz/parse"
+)
+
+const tailPollInterval = time.Second // How often followed files are checked for new lines
+
+// TailStatus reports a log file followed in live tail mode.
+type TailStatus struct {
+	Path         string `json:"path"`
+	FileID       string `json:"file_id,omitempty"`   //nolint:tagliatelle // API consistency
+	Offset       int64  `json:"offset"`              // Bytes of the current file read so far
+	Connections  int64  `json:"connections"`         // Connections appended since following started
+	SkippedLines int    `json:"skipped_lines"`       //nolint:tagliatelle // API consistency
+	Rotations    int    `json:"rotations"`           // Times the file was truncated or replaced
+	LastRead     int64  `json:"last_read,omitempty"` //nolint:tagliatelle // API consistency
+	Error        string `json:"error,omitempty"`     // Why the file can't currently be read
+}
+
+// tailer follows one log file. Its status is read by GetTails while run updates it.
+type tailer struct {
+	path string
+
+	// Only used by run
+	file       *os.File
+	reader     *bufio.Reader
+	parser     *parse.Parser
+	partial    string // Last line, still being written
+	discarding bool   // Rest of a line over parse.MaxLineLength is being dropped
+	lineNumber int
+
+	mu     sync.Mutex
+	status TailStatus
+}
+
+// Tail follows the log file at path like tail -F, appending connections written to it to a
+// live dataset named after the file until ctx is done. Existing content is read first when
+// fromStart is set; otherwise only the header of a TSV log is. A truncated or rotated file is
+// read again from its start.
+func (a *API) Tail(ctx context.Context, path string, fromStart bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+
+	t := &tailer{path: path, status: TailStatus{Path: path}}
+	err = t.open(file, fromStart)
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	a.tailMu.Lock()
+	a.tails = append(a.tails, t)
+	a.tailMu.Unlock()
+
+	log.Printf("Following %s", path)
+	go t.run(ctx, a)
+
+	return nil
+}
+
+// open starts reading file, from its start or after its TSV header.
+func (t *tailer) open(file *os.File, fromStart bool) error {
+	t.file = file
+	t.reader = bufio.NewReader(file)
+	t.parser = parse.NewParser(false)
+	t.partial, t.discarding, t.lineNumber = "", false, 0
+	t.setOffset(0)
+	if fromStart {
+		return nil
+	}
+
+	// Only the header is needed to parse what's written from now on
+	var offset int64
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "#") {
+			break
+		}
+		offset += int64(len(line))
+		t.parser.Header(strings.TrimRight(line, "\r\n"))
+	}
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errErrorReadingData, err)
+	}
+	t.reader.Reset(file)
+	t.setOffset(max(offset, end))
+
+	return nil
+}
+
+// run polls the file for new lines until ctx is done.
+func (t *tailer) run(ctx context.Context, a *API) {
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	defer func() { t.file.Close() }()
+
+	var rotationErr error
+	for {
+		connections, err := t.read()
+		if len(connections) > 0 {
+			fileID := a.IngestLive(t.path, connections)
+
+			t.mu.Lock()
+			t.status.FileID = fileID
+			t.status.Connections += int64(len(connections))
+			t.status.LastRead = time.Now().Unix()
+			t.mu.Unlock()
+		}
+		t.setError(errors.Join(err, rotationErr))
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped following %s", t.path)
+
+			return
+		case <-ticker.C:
+		}
+
+		rotationErr = t.checkRotation()
+	}
+}
+
+// read parses the complete lines written since the last call.
+func (t *tailer) read() ([]models.Connection, error) {
+	var read int64
+	defer func() { t.addOffset(read) }()
+
+	for {
+		chunk, err := t.reader.ReadString('\n')
+		read += int64(len(chunk))
+		if errors.Is(err, io.EOF) {
+			t.partial += chunk
+			if len(t.partial) > parse.MaxLineLength {
+				t.partial, t.discarding = "", true
+			}
+
+			break
+		}
+		if err != nil {
+			return t.takeConnections(), fmt.Errorf("%w: %w", errErrorReadingData, err)
+		}
+
+		line := strings.TrimRight(t.partial+chunk, "\r\n")
+		t.partial = ""
+		t.lineNumber++
+		switch {
+		case t.discarding || len(line) > parse.MaxLineLength:
+			t.discarding = false
+			_ = t.parser.LongLine(t.lineNumber) // Only fails in strict mode
+		default:
+			_ = t.parser.Line(t.lineNumber, line) // Only fails in strict mode
+		}
+	}
+
+	return t.takeConnections(), nil
+}
+
+// takeConnections returns the connections parsed so far and resets the parser's buffer.
+func (t *tailer) takeConnections() []models.Connection {
+	connections := t.parser.Take() // The live dataset keeps its own statistics
+
+	t.mu.Lock()
+	t.status.SkippedLines = t.parser.Report().SkippedLines
+	t.mu.Unlock()
+
+	return connections
+}
+
+// checkRotation reopens the file from its start when it was truncated or replaced, as log
+// rotation does. While the file is missing, the error is returned and the old file kept.
+func (t *tailer) checkRotation() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+	current, err := t.file.Stat()
+	if err == nil && os.SameFile(info, current) && info.Size() >= t.offset() {
+		return nil
+	}
+
+	file, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+	t.file.Close()
+	_ = t.open(file, true) // Reading from the start never fails
+
+	t.mu.Lock()
+	t.status.Rotations++
+	t.mu.Unlock()
+	log.Printf("%s was rotated, reading it from the start", t.path)
+
+	return nil
+}
+
+// offset returns the bytes of the current file read so far.
+func (t *tailer) offset() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status.Offset
+}
+
+// setOffset sets the bytes of the current file read so far.
+func (t *tailer) setOffset(offset int64) {
+	t.mu.Lock()
+	t.status.Offset = offset
+	t.mu.Unlock()
+}
+
+// addOffset counts read bytes.
+func (t *tailer) addOffset(read int64) {
+	t.mu.Lock()
+	t.status.Offset += read
+	t.mu.Unlock()
+}
+
+// setError records why the file can't be read, or clears it.
+func (t *tailer) setError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Error = ""
+	if err != nil {
+		t.status.Error = err.Error()
+	}
+}
+
+// snapshot returns the current status.
+func (t *tailer) snapshot() TailStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+)
+
+const (
+	timelineAutoPoints  = 200     // Buckets an automatic bucket size aims for at most
+	maxTimelineSeries   = 10      // Series returned by group_by; the rest are merged into one
+	maxCachedTimelines  = 16      // Bucket size and time zone combinations cached per dataset
+	timelineOtherSeries = "other" // Key of the merged remaining series
+	timelineNoValue     = "-"     // Key of connections without a value, as Zeek logs it
+	bucketPageSize      = 100     // Connections a bucket drill-down lists by default
+)
+
+var (
+	errInvalidBucket   = errors.New("bucket must be a positive number of seconds or auto")
+	errTimelineGroupBy = errors.New("group_by must be protocol, service, conn_state, or dataset")
+	errBucketStart     = errors.New("bucket start must be a Unix timestamp")
+)
+
+// timelineBucket is what happened within one bucket of the timeline: the bucket's totals and
+// breakdowns, its busiest hosts, and a page of its connections in time order.
+type timelineBucket struct {
+	Start           int64              `json:"start"`
+	End             int64              `json:"end"`
+	BucketSize      int64              `json:"bucket_size"` //nolint:tagliatelle // API consistency
+	Local           string             `json:"local,omitempty"`
+	Count           int                `json:"count"`
+	Bytes           int                `json:"bytes"`
+	Protocols       map[string]int     `json:"protocols"`
+	Services        map[string]int     `json:"services"`
+	ConnStates      map[string]int     `json:"conn_states"`      //nolint:tagliatelle // API consistency
+	TopSources      []models.HostCount `json:"top_sources"`      //nolint:tagliatelle // API consistency
+	TopDestinations []models.HostCount `json:"top_destinations"` //nolint:tagliatelle // API consistency
+	UIDs            []string           `json:"uids,omitempty"`   // All the bucket's connections, with include=uids
+
+	models.ConnectionsResponse
+}
+
+// timelineBucketSizes returns the bucket sizes an automatic bucket size picks from, in seconds.
+func timelineBucketSizes() []int64 {
+	return []int64{
+		1, 2, 5, 10, 15, 30, // Seconds
+		60, 120, 300, 600, 900, 1800, // Minutes
+		3600, 7200, 10800, 21600, 43200, // Hours
+		86400, 172800, 604800, // Days
+	}
+}
+
+// parseTimelineBucket reads the "bucket" query parameter: a size in seconds, or "auto" for
+// the smallest size that spans the connections in at most timelineAutoPoints buckets. It
+// defaults to timelineBucketSec.
+func parseTimelineBucket(query url.Values, connections []models.Connection) (int64, error) {
+	value := query.Get("bucket")
+	switch value {
+	case "":
+		return timelineBucketSec, nil
+	case "auto":
+		return autoBucketSize(connections), nil
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, errInvalidBucket
+	}
+
+	return size, nil
+}
+
+// autoBucketSize returns the smallest bucket size that covers the connections' time span in
+// at most timelineAutoPoints buckets.
+func autoBucketSize(connections []models.Connection) int64 {
+	if len(connections) == 0 {
+		return timelineBucketSec
+	}
+
+	start, end := connections[0].Timestamp, connections[0].Timestamp
+	for i := range connections {
+		start = min(start, connections[i].Timestamp)
+		end = max(end, connections[i].Timestamp)
+	}
+
+	sizes := timelineBucketSizes()
+	span := int64(end - start)
+	for _, size := range sizes {
+		if span/size < timelineAutoPoints {
+			return size
+		}
+	}
+
+	return sizes[len(sizes)-1]
+}
+
+// parseTimelineGroupBy reads the "group_by" query parameter of /api/timeline and returns the
+// canonical field name, or an empty one when the timeline isn't split into series. Grouping by
+// dataset splits timelines across several datasets.
+func parseTimelineGroupBy(query url.Values) (string, models.StringAccessor, error) {
+	name := query.Get("group_by")
+	if name == "" {
+		return "", nil, nil
+	}
+
+	field := models.CanonicalFieldName(name)
+	if field != "proto" && field != "service" && field != "conn_state" && field != "dataset" {
+		return "", nil, errTimelineGroupBy
+	}
+	accessor, _ := models.StringFieldAccessor(field)
+
+	return field, accessor, nil
+}
+
+// buildTimelineSeries splits the connections by the value of a field and buckets each group.
+// Series are ordered by connection count; beyond maxTimelineSeries the remaining groups are
+// merged into an "other" series so stacked charts stay readable.
+func buildTimelineSeries(connections []models.Connection, bucketSize int64, loc *time.Location, key models.StringAccessor) []models.TimelineSeries {
+	groups := make(map[string]*models.TimelineSeries)
+	buckets := make(map[string]map[int64]*models.TimelinePoint)
+	for i := range connections {
+		conn := &connections[i]
+		value := key(conn)
+		if value == "" {
+			value = timelineNoValue
+		}
+		series, exists := groups[value]
+		if !exists {
+			series = &models.TimelineSeries{Key: value}
+			groups[value] = series
+			buckets[value] = make(map[int64]*models.TimelinePoint)
+		}
+		series.Count++
+		series.Bytes += conn.TotalBytes()
+
+		bucket := bucketStart(int64(conn.Timestamp), bucketSize, loc)
+		point, exists := buckets[value][bucket]
+		if !exists {
+			point = &models.TimelinePoint{Timestamp: bucket}
+			buckets[value][bucket] = point
+		}
+		point.Count++
+		point.Bytes += conn.TotalBytes()
+	}
+
+	keys := make([]string, 0, len(groups))
+	for value := range groups {
+		keys = append(keys, value)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if groups[keys[i]].Count != groups[keys[j]].Count {
+			return groups[keys[i]].Count > groups[keys[j]].Count
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	if len(keys) > maxTimelineSeries {
+		other := &models.TimelineSeries{Key: timelineOtherSeries}
+		points := make(map[int64]*models.TimelinePoint)
+		for _, value := range keys[maxTimelineSeries-1:] {
+			other.Count += groups[value].Count
+			other.Bytes += groups[value].Bytes
+			for bucket, point := range buckets[value] {
+				if merged, exists := points[bucket]; exists {
+					merged.Count += point.Count
+					merged.Bytes += point.Bytes
+				} else {
+					points[bucket] = point
+				}
+			}
+		}
+		keys = append(keys[:maxTimelineSeries-1], timelineOtherSeries)
+		groups[timelineOtherSeries], buckets[timelineOtherSeries] = other, points
+	}
+
+	series := make([]models.TimelineSeries, 0, len(keys))
+	for _, value := range keys {
+		group := groups[value]
+		group.Points = make([]models.TimelinePoint, 0, len(buckets[value]))
+		for _, point := range buckets[value] {
+			group.Points = append(group.Points, *point)
+		}
+		sort.Slice(group.Points, func(i, j int) bool {
+			return group.Points[i].Timestamp < group.Points[j].Timestamp
+		})
+		series = append(series, *group)
+	}
+
+	return series
+}
+
+// localizeTimeline records the time zone of a timeline and the local start of each bucket.
+func localizeTimeline(timeline *models.TimelineData, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+
+	timeline.Timezone = loc.String()
+	localizePoints(timeline.Points, loc)
+}
+
+// localizePoints records the local start of each bucket.
+func localizePoints(points []models.TimelinePoint, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+
+	for i := range points {
+		points[i].Local = formatLocal(points[i].Timestamp, loc)
+	}
+}
+
+// GetTimelineBucket returns the connections of the timeline bucket containing the {start}
+// timestamp, so a spike in the timeline can be drilled into. bucket, tz, and the filters
+// select the bucket as they do for /api/timeline; limit (100 by default), offset, and fields
+// page the connections, and include=uids lists the UIDs of all of them.
+func (a *API) GetTimelineBucket(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	start, err := strconv.ParseInt(r.PathValue("start"), 10, 64)
+	if err != nil {
+		http.Error(w, errBucketStart.Error(), http.StatusBadRequest)
+
+		return
+	}
+	query := r.URL.Query()
+	loc, err := parseTimezone(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	bucketSize, err := parseTimelineBucket(query, connections)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	bucket, inBucket := buildTimelineBucket(connections, bucketStart(start, bucketSize, loc), bucketSize, loc)
+	if query.Get("include") != "uids" {
+		bucket.UIDs = nil
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = bucketPageSize
+	}
+	bucket.ConnectionsResponse = pageConnections(inBucket, parseLimit(query, "offset"), limit)
+	if fields := connfilter.SplitList(query.Get("fields")); len(fields) > 0 {
+		err = projectConnections(&bucket.ConnectionsResponse, fields, a.annotator())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	} else if page, ok := bucket.Connections.([]models.Connection); ok {
+		bucket.Connections = a.annotateConnections(page)
+	}
+
+	err = json.NewEncoder(w).Encode(bucket)
+	if err != nil {
+		log.Printf("Failed to encode timeline bucket: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// buildTimelineBucket sums up the connections that started in the bucket at start and returns
+// them in time order, for the caller to page.
+func buildTimelineBucket(
+	connections []models.Connection, start, bucketSize int64, loc *time.Location,
+) (*timelineBucket, []models.Connection) {
+	bucket := &timelineBucket{
+		Start:      start,
+		End:        nextBucket(start, bucketSize, loc),
+		BucketSize: bucketSize,
+		Protocols:  map[string]int{},
+		Services:   map[string]int{},
+		ConnStates: map[string]int{},
+		UIDs:       []string{},
+	}
+	if loc != nil {
+		bucket.Local = formatLocal(start, loc)
+	}
+
+	var inBucket []models.Connection
+	for i := range connections {
+		if bucketStart(int64(connections[i].Timestamp), bucketSize, loc) == start {
+			inBucket = append(inBucket, connections[i])
+		}
+	}
+	sort.SliceStable(inBucket, func(i, j int) bool {
+		return inBucket[i].Timestamp < inBucket[j].Timestamp
+	})
+
+	sources := make(map[string]*models.HostCount)
+	destinations := make(map[string]*models.HostCount)
+	for i := range inBucket {
+		conn := &inBucket[i]
+		bucket.Count++
+		bucket.Bytes += conn.TotalBytes()
+		bucket.Protocols[conn.Protocol]++
+		bucket.Services[cmp.Or(conn.Service, timelineNoValue)]++
+		bucket.ConnStates[cmp.Or(conn.ConnState, timelineNoValue)]++
+		bucket.UIDs = append(bucket.UIDs, conn.UID)
+		countHost(sources, conn.OrigHost, conn.TotalBytes())
+		countHost(destinations, conn.RespHost, conn.TotalBytes())
+	}
+	bucket.TopSources, bucket.TopDestinations = topHosts(sources), topHosts(destinations)
+
+	return bucket, inBucket
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const secondsPerDay = 86400 // Seconds in a (non-DST) day
+
+// timelineKey identifies a cached timeline.
+type timelineKey struct {
+	bucketSize int64
+	timezone   string
+}
+
+var errInvalidTimezone = errors.New("tz must be an IANA time zone name")
+
+// parseTimezone reads the "tz" query parameter. It returns nil when the parameter is absent,
+// in which case buckets stay aligned to the Unix epoch (UTC).
+func parseTimezone(query url.Values) (*time.Location, error) {
+	name := query.Get("tz")
+	if name == "" {
+		return nil, nil //nolint:nilnil // No time zone requested
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errInvalidTimezone, name)
+	}
+
+	return loc, nil
+}
+
+// bucketStart returns the start of the bucket of size seconds containing ts. Without a
+// location buckets are aligned to the Unix epoch. With one, buckets of whole days start at
+// local midnight and span calendar days, so days of 23 or 25 hours around DST changes are
+// one bucket each; shorter buckets are aligned to local midnight (so hours follow the zone)
+// and other longer buckets to local wall-clock time.
+func bucketStart(ts, size int64, loc *time.Location) int64 {
+	if loc == nil {
+		return (ts / size) * size
+	}
+
+	t := time.Unix(ts, 0).In(loc)
+	if size%secondsPerDay == 0 {
+		days := size / secondsPerDay
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / secondsPerDay
+		day -= ((day % days) + days) % days
+
+		return time.Date(1970, time.January, 1+int(day), 0, 0, 0, 0, loc).Unix()
+	}
+	if size < secondsPerDay {
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Unix()
+
+		return midnight + ((ts-midnight)/size)*size
+	}
+
+	_, offset := t.Zone()
+	local := ts + int64(offset)
+
+	return (local/size)*size - int64(offset)
+}
+
+// previousBucket returns the start of the bucket before the one starting at bucket.
+func previousBucket(bucket, bucketSize int64, loc *time.Location) int64 {
+	if loc == nil {
+		return bucket - bucketSize
+	}
+
+	return bucketStart(bucket-1, bucketSize, loc)
+}
+
+// nextBucket returns the start of the bucket after the one starting at bucket. With a
+// location, buckets of whole days step by calendar days rather than by 24 hours.
+func nextBucket(bucket, bucketSize int64, loc *time.Location) int64 {
+	if loc == nil {
+		return bucket + bucketSize
+	}
+	if bucketSize%secondsPerDay == 0 {
+		t := time.Unix(bucket, 0).In(loc)
+
+		return time.Date(t.Year(), t.Month(), t.Day()+int(bucketSize/secondsPerDay), 0, 0, 0, 0, loc).Unix()
+	}
+
+	return bucketStart(bucket+bucketSize, bucketSize, loc)
+}
+
+// formatLocal formats a Unix timestamp as RFC 3339 in the given location.
+func formatLocal(ts int64, loc *time.Location) string {
+	return time.Unix(ts, 0).In(loc).Format(time.RFC3339)
+}
+
+// timezoneName returns the name of a location, or an empty string for none.
+func timezoneName(loc *time.Location) string {
+	if loc == nil {
+		return ""
+	}
+
+	return loc.String()
+}
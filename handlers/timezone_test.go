@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStartAcrossDST(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	local := func(value string) int64 {
+		parsed, err := time.ParseInLocation(time.DateTime, value, zurich)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return parsed.Unix()
+	}
+
+	tests := []struct {
+		name       string
+		ts         string
+		size       int64
+		start      string
+		next       string
+		nextLength time.Duration
+	}{
+		// 2025-10-26, the last Sunday of October, has 25 hours: clocks go back at 03:00.
+		{"25-hour day, before the change", "2025-10-26 01:30:00", secondsPerDay, "2025-10-26 00:00:00", "2025-10-27 00:00:00", 25 * time.Hour},
+		{"25-hour day, last hour", "2025-10-26 23:30:00", secondsPerDay, "2025-10-26 00:00:00", "2025-10-27 00:00:00", 25 * time.Hour},
+		{"day after the change", "2025-10-27 00:30:00", secondsPerDay, "2025-10-27 00:00:00", "2025-10-28 00:00:00", 24 * time.Hour},
+		// 2025-03-30, the last Sunday of March, has 23 hours: clocks go forward at 02:00.
+		{"23-hour day, last hour", "2025-03-30 23:30:00", secondsPerDay, "2025-03-30 00:00:00", "2025-03-31 00:00:00", 23 * time.Hour},
+		{"hour after the change", "2025-10-26 22:10:00", 3600, "2025-10-26 22:00:00", "2025-10-26 23:00:00", time.Hour},
+		{"week spanning the change", "2025-10-27 12:00:00", 7 * secondsPerDay, "2025-10-23 00:00:00", "2025-10-30 00:00:00", 7*24*time.Hour + time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := bucketStart(local(test.ts), test.size, zurich)
+			if want := local(test.start); start != want {
+				t.Errorf("bucketStart = %s, want %s", formatLocal(start, zurich), formatLocal(want, zurich))
+			}
+			next := nextBucket(start, test.size, zurich)
+			if want := local(test.next); next != want {
+				t.Errorf("nextBucket = %s, want %s", formatLocal(next, zurich), formatLocal(want, zurich))
+			}
+			if length := time.Duration(next-start) * time.Second; length != test.nextLength {
+				t.Errorf("bucket spans %s, want %s", length, test.nextLength)
+			}
+			if previous := previousBucket(next, test.size, zurich); previous != start {
+				t.Errorf("previousBucket(nextBucket) = %s, want %s", formatLocal(previous, zurich), formatLocal(start, zurich))
+			}
+		})
+	}
+}
+
+func TestBucketStartWithoutLocation(t *testing.T) {
+	if start := bucketStart(1761438600, secondsPerDay, nil); start != 1761436800 {
+		t.Errorf("bucketStart = %d, want 1761436800", start)
+	}
+	if next := nextBucket(1761436800, secondsPerDay, nil); next != 1761436800+secondsPerDay {
+		t.Errorf("nextBucket = %d, want %d", next, 1761436800+secondsPerDay)
+	}
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const (
+	tlsRule              = "tls"              // Finding rule of rare fingerprints and server name mismatches
+	fingerprintJA3       = "ja3"              // Client fingerprints of the ja3 Zeek package
+	fingerprintJA4       = "ja4"              // Client fingerprints of the ja4 Zeek package
+	tlsFindingRare       = "rare_fingerprint" // Fingerprint few clients use
+	tlsFindingMismatch   = "sni_mismatch"     // Server name that doesn't fit the destination
+	defaultTLSMaxClients = 1                  // Clients at most that make a fingerprint rare
+	defaultTLSLimit      = 50                 // Clusters and findings returned by default
+	maxTLSSamples        = 5                  // Clients and server names listed per cluster
+	maxTLSFindingUIDs    = 20                 // Connection UIDs listed per finding
+	sniMinSessions       = 10                 // Sessions of a server name before its destinations are compared
+	sniDominantShare     = 0.5                // Share of a server name's sessions its busiest subnet must hold
+	sniOutlierShare      = 0.05               // Share of a server name's sessions below which a subnet is an outlier
+	sniSubnetBits        = 24                 // IPv4 prefix length destinations of a server name are compared by
+	sniSubnetBitsV6      = 48                 // IPv6 prefix length destinations of a server name are compared by
+)
+
+var errInvalidFingerprint = errors.New("fingerprint must be ja3 or ja4")
+
+// TLSCluster groups the TLS clients sharing a fingerprint, usually one TLS library or
+// application.
+type TLSCluster struct {
+	Fingerprint   string          `json:"fingerprint"`
+	Clients       int             `json:"clients"`
+	SampleClients []string        `json:"sample_clients"` //nolint:tagliatelle // API consistency
+	Sessions      int             `json:"sessions"`
+	Destinations  int             `json:"destinations"`
+	ServerNames   []TLSServerName `json:"server_names"` //nolint:tagliatelle // Busiest first
+	FirstSeen     float64         `json:"first_seen"`   //nolint:tagliatelle // API consistency
+	LastSeen      float64         `json:"last_seen"`    //nolint:tagliatelle // API consistency
+	Rare          bool            `json:"rare"`         // Used by at most max_clients clients
+
+	clients      map[string]bool
+	destinations map[string]bool
+	serverNames  map[string]int
+}
+
+// TLSServerName is a server name clients of a cluster asked for.
+type TLSServerName struct {
+	Name     string `json:"name"`
+	Sessions int    `json:"sessions"`
+}
+
+// TLSFinding is a client and destination whose TLS sessions use a rare fingerprint or a server
+// name that doesn't fit the destination. Source and target are the graph nodes of its edge.
+type TLSFinding struct {
+	Type        string         `json:"type"`
+	Reason      string         `json:"reason"`
+	Source      string         `json:"source"`
+	Target      string         `json:"target"`
+	Fingerprint string         `json:"fingerprint,omitempty"`
+	ServerName  string         `json:"server_name,omitempty"` //nolint:tagliatelle // API consistency
+	Sessions    int            `json:"sessions"`
+	UIDs        []string       `json:"uids"`
+	FirstSeen   float64        `json:"first_seen"` //nolint:tagliatelle // API consistency
+	LastSeen    float64        `json:"last_seen"`  //nolint:tagliatelle // API consistency
+	Threat      *models.Threat `json:"threat,omitempty"`
+}
+
+// tlsObservation is an ssl.log session correlated with its connection.
+type tlsObservation struct {
+	conn    *models.Connection
+	session *models.TLSSession
+}
+
+// GetTLSFingerprints clusters the TLS clients of the filtered connections by the JA3 or JA4
+// fingerprint of their attached ssl.log sessions and flags rare fingerprints and server names
+// that don't fit their destination. Accepts the standard filters, fingerprint, max_clients,
+// and limit.
+func (a *API) GetTLSFingerprints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	kind := query.Get("fingerprint")
+	if kind != "" && kind != fingerprintJA3 && kind != fingerprintJA4 {
+		http.Error(w, errInvalidFingerprint.Error(), http.StatusBadRequest)
+
+		return
+	}
+	maxClients := parseLimit(query, "max_clients")
+	if maxClients == 0 {
+		maxClients = defaultTLSMaxClients
+	}
+	limit := parseLimit(query, "limit")
+	if limit == 0 {
+		limit = defaultTLSLimit
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var observations []tlsObservation
+	if fileData := a.files[a.currentDataset(r)]; fileData != nil && len(fileData.tlsSessions) > 0 {
+		for i := range connections {
+			sessions := fileData.tlsSessions[connections[i].UID]
+			for j := range sessions {
+				observations = append(observations, tlsObservation{conn: &connections[i], session: &sessions[j]})
+			}
+		}
+	}
+	if kind == "" {
+		kind = fingerprintJA3
+		for _, observation := range observations {
+			if observation.session.JA4 != "" {
+				kind = fingerprintJA4
+
+				break
+			}
+		}
+	}
+
+	clusters, clients, unfingerprinted := clusterTLSClients(observations, kind, maxClients)
+	findings, suppressed := a.tlsFindings(observations, clusters, kind, clients)
+
+	ranked := make([]*TLSCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		ranked = append(ranked, cluster)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Sessions != ranked[j].Sessions {
+			return ranked[i].Sessions > ranked[j].Sessions
+		}
+
+		return ranked[i].Fingerprint < ranked[j].Fingerprint
+	})
+
+	response := map[string]any{
+		"fingerprint":         kind,
+		"clusters":            ranked[:min(limit, len(ranked))],
+		"findings":            findings[:min(limit, len(findings))],
+		"total_clusters":      len(ranked),
+		"total_findings":      len(findings),
+		"truncated":           len(ranked) > limit || len(findings) > limit,
+		"sessions":            len(observations),
+		"clients":             clients,
+		"unfingerprinted":     unfingerprinted,
+		"suppressed_findings": suppressed,
+		"limits":              map[string]any{"limit": limit, "max_clients": maxClients},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode TLS fingerprints: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// sessionFingerprint returns the client fingerprint of the kind of a session.
+func sessionFingerprint(session *models.TLSSession, kind string) string {
+	if kind == fingerprintJA4 {
+		return session.JA4
+	}
+
+	return session.JA3
+}
+
+// clusterTLSClients groups the sessions by client fingerprint. It returns the clusters, the
+// number of distinct clients, and the number of sessions without a fingerprint of the kind.
+// Fingerprints used by at most maxClients clients are rare, unless no more clients were seen.
+func clusterTLSClients(observations []tlsObservation, kind string, maxClients int) (map[string]*TLSCluster, int, int) {
+	clusters := make(map[string]*TLSCluster)
+	clients := make(map[string]bool)
+	unfingerprinted := 0
+	for _, observation := range observations {
+		conn := observation.conn
+		fingerprint := sessionFingerprint(observation.session, kind)
+		if fingerprint == "" {
+			unfingerprinted++
+
+			continue
+		}
+		clients[conn.OrigHost] = true
+
+		cluster, exists := clusters[fingerprint]
+		if !exists {
+			cluster = &TLSCluster{
+				Fingerprint:  fingerprint,
+				FirstSeen:    conn.Timestamp,
+				LastSeen:     conn.Timestamp,
+				clients:      make(map[string]bool),
+				destinations: make(map[string]bool),
+				serverNames:  make(map[string]int),
+			}
+			clusters[fingerprint] = cluster
+		}
+		cluster.Sessions++
+		cluster.FirstSeen = min(cluster.FirstSeen, conn.Timestamp)
+		cluster.LastSeen = max(cluster.LastSeen, conn.Timestamp)
+		cluster.clients[conn.OrigHost] = true
+		cluster.destinations[conn.RespHost] = true
+		if name := strings.ToLower(observation.session.ServerName); name != "" {
+			cluster.serverNames[name]++
+		}
+	}
+
+	for _, cluster := range clusters {
+		cluster.finish(len(clients) > maxClients, maxClients)
+	}
+
+	return clusters, len(clients), unfingerprinted
+}
+
+// finish computes the cluster's counts and samples from its sets.
+func (c *TLSCluster) finish(population bool, maxClients int) {
+	c.Clients = len(c.clients)
+	c.Destinations = len(c.destinations)
+	c.Rare = population && c.Clients <= maxClients
+
+	c.SampleClients = make([]string, 0, len(c.clients))
+	for client := range c.clients {
+		c.SampleClients = append(c.SampleClients, client)
+	}
+	sort.Strings(c.SampleClients)
+	c.SampleClients = c.SampleClients[:min(maxTLSSamples, len(c.SampleClients))]
+
+	c.ServerNames = make([]TLSServerName, 0, len(c.serverNames))
+	for name, sessions := range c.serverNames {
+		c.ServerNames = append(c.ServerNames, TLSServerName{Name: name, Sessions: sessions})
+	}
+	sort.Slice(c.ServerNames, func(i, j int) bool {
+		if c.ServerNames[i].Sessions != c.ServerNames[j].Sessions {
+			return c.ServerNames[i].Sessions > c.ServerNames[j].Sessions
+		}
+
+		return c.ServerNames[i].Name < c.ServerNames[j].Name
+	})
+	c.ServerNames = c.ServerNames[:min(maxTLSSamples, len(c.ServerNames))]
+	c.clients, c.destinations, c.serverNames = nil, nil, nil
+}
+
+// tlsFindings flags the sessions of rare fingerprints and those whose server name doesn't fit
+// the destination, grouped by client, destination, and fingerprint or server name, oldest
+// first. It also returns how many findings suppressions silenced.
+func (a *API) tlsFindings(observations []tlsObservation, clusters map[string]*TLSCluster, kind string, clients int) ([]TLSFinding, int) {
+	subnets := serverNameSubnets(observations)
+
+	findings := make(map[string]*TLSFinding)
+	suppressed := make(map[string]bool)
+	var order []string
+	add := func(findingType, reason string, observation tlsObservation) {
+		conn := observation.conn
+		key := findingType + "|" + conn.OrigHost + "|" + conn.RespHost + "|" + reason
+		if connectionSuppressed(a.suppressions, tlsRule, conn) {
+			suppressed[key] = true
+
+			return
+		}
+		finding, exists := findings[key]
+		if !exists {
+			finding = &TLSFinding{
+				Type:        findingType,
+				Reason:      reason,
+				Source:      conn.OrigHost,
+				Target:      conn.RespHost,
+				Fingerprint: sessionFingerprint(observation.session, kind),
+				ServerName:  strings.ToLower(observation.session.ServerName),
+				UIDs:        []string{},
+				FirstSeen:   conn.Timestamp,
+				LastSeen:    conn.Timestamp,
+			}
+			findings[key] = finding
+			order = append(order, key)
+		}
+		finding.Sessions++
+		finding.FirstSeen = min(finding.FirstSeen, conn.Timestamp)
+		finding.LastSeen = max(finding.LastSeen, conn.Timestamp)
+		if len(finding.UIDs) < maxTLSFindingUIDs && !slices.Contains(finding.UIDs, conn.UID) {
+			finding.UIDs = append(finding.UIDs, conn.UID)
+		}
+		if finding.Threat == nil {
+			finding.Threat = a.intel.matchConnection(conn)
+		}
+	}
+
+	for _, observation := range observations {
+		fingerprint := sessionFingerprint(observation.session, kind)
+		if cluster := clusters[fingerprint]; cluster != nil && cluster.Rare {
+			add(tlsFindingRare, fmt.Sprintf("%s %s seen from %d of %d clients", kind, fingerprint, cluster.Clients, clients), observation)
+		}
+		if reason := serverNameMismatch(observation, subnets); reason != "" {
+			add(tlsFindingMismatch, reason, observation)
+		}
+	}
+
+	ranked := make([]TLSFinding, 0, len(order))
+	for _, key := range order {
+		ranked = append(ranked, *findings[key])
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].FirstSeen < ranked[j].FirstSeen
+	})
+
+	return ranked, len(suppressed)
+}
+
+// serverNameSubnets counts the sessions of each server name per destination subnet.
+func serverNameSubnets(observations []tlsObservation) map[string]map[string]int {
+	grouping := &subnetGrouping{ipv4: sniSubnetBits, ipv6: sniSubnetBitsV6}
+	subnets := make(map[string]map[string]int)
+	for _, observation := range observations {
+		name := strings.ToLower(observation.session.ServerName)
+		if name == "" {
+			continue
+		}
+		if subnets[name] == nil {
+			subnets[name] = make(map[string]int)
+		}
+		subnets[name][grouping.subnet(observation.conn.RespHost)]++
+	}
+
+	return subnets
+}
+
+// serverNameMismatch returns why a session's server name doesn't fit its destination, or an
+// empty string when it does or there is too little traffic to tell: the name is an IP address
+// other than the destination, or the name's sessions mostly go to one subnet and hardly ever
+// to the destination's, as domain fronting and spoofed names do.
+func serverNameMismatch(observation tlsObservation, subnets map[string]map[string]int) string {
+	name := strings.ToLower(observation.session.ServerName)
+	if name == "" {
+		return ""
+	}
+	resp := models.CanonicalHost(observation.conn.RespHost)
+	if _, err := models.ParseHost(name); err == nil {
+		if models.CanonicalHost(name) != resp {
+			return fmt.Sprintf("server name %s is another address than %s", name, resp)
+		}
+
+		return ""
+	}
+
+	counts := subnets[name]
+	total, busiest, busiestCount := 0, "", 0
+	for subnet, count := range counts {
+		total += count
+		if count > busiestCount || (count == busiestCount && subnet < busiest) {
+			busiest, busiestCount = subnet, count
+		}
+	}
+	grouping := &subnetGrouping{ipv4: sniSubnetBits, ipv6: sniSubnetBitsV6}
+	share := float64(counts[grouping.subnet(observation.conn.RespHost)]) / float64(total)
+	if total < sniMinSessions || float64(busiestCount)/float64(total) < sniDominantShare || share >= sniOutlierShare {
+		return ""
+	}
+
+	return fmt.Sprintf("%s is usually served from %s", name, busiest)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+var (
+	errTopBy     = errors.New("by must be src, dst, port, service, or pair")
+	errTopMetric = errors.New("metric must be bytes, connections, or packets")
+)
+
+// topDimension is a named connection field that /api/top groups by.
+type topDimension struct {
+	name  string
+	field string
+}
+
+// TopEntry is one ranked source, destination, port, service, or host pair with its totals.
+type TopEntry struct {
+	Key          map[string]string `json:"key"`
+	Connections  int               `json:"connections"`
+	Bytes        int               `json:"bytes"`
+	Packets      int               `json:"packets"`
+	Share        float64           `json:"share"`                       // Fraction of the metric's total
+	BytesHuman   string            `json:"bytes_human,omitempty"`       //nolint:tagliatelle // API consistency
+	PacketsHuman string            `json:"packets_human,omitempty"`     //nolint:tagliatelle // API consistency
+	ConnsHuman   string            `json:"connections_human,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// topDimensions returns the fields each by value of /api/top groups by. Ports are destination
+// ports, kept apart per transport protocol.
+func topDimensions() map[string][]topDimension {
+	return map[string][]topDimension{
+		"src":     {{"src", "id.orig_h"}},
+		"dst":     {{"dst", "id.resp_h"}},
+		"port":    {{"port", "id.resp_p"}, {"proto", "proto"}},
+		"service": {{"service", "service"}},
+		"pair":    {{"src", "id.orig_h"}, {"dst", "id.resp_h"}},
+	}
+}
+
+// topMetrics returns the aggregate metric each metric value of /api/top ranks by.
+func topMetrics() map[string]string {
+	return map[string]string{
+		"bytes":       "bytes",
+		"connections": countMetric,
+		"packets":     "pkts",
+	}
+}
+
+// GetTop ranks the sources, destinations, destination ports, services, or host pairs of the
+// filtered connections by bytes, connections, or packets. It is a fixed-shape shortcut over
+// /api/aggregate for the usual top-talker questions.
+func (a *API) GetTop(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	by, metric := query.Get("by"), query.Get("metric")
+	if by == "" {
+		by = "src"
+	}
+	if metric == "" {
+		metric = "bytes"
+	}
+	dimensions, exists := topDimensions()[by]
+	if !exists {
+		http.Error(w, errTopBy.Error(), http.StatusBadRequest)
+
+		return
+	}
+	primary, exists := topMetrics()[metric]
+	if !exists {
+		http.Error(w, errTopMetric.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	n := parseLimit(query, "n")
+	if n == 0 {
+		n = defaultTopN
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	entries, total, err := rankTop(connections, dimensions, primary, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if wantsHumanize(query) {
+		for i := range entries {
+			entries[i].BytesHuman = connstats.HumanizeBytes(float64(entries[i].Bytes))
+			entries[i].PacketsHuman = connstats.HumanizeCount(entries[i].Packets)
+			entries[i].ConnsHuman = connstats.HumanizeCount(entries[i].Connections)
+		}
+	}
+
+	response := map[string]any{
+		"by":             by,
+		"metric":         metric,
+		"entries":        entries,
+		"total_distinct": total,
+		"truncated":      total > n,
+		"limits":         map[string]int{"n": n},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode top entries: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// rankTop returns the n groups of the connections with the highest primary metric, and the
+// number of distinct groups.
+func rankTop(connections []models.Connection, dimensions []topDimension, primary string, n int) ([]TopEntry, int, error) {
+	fields := make([]string, len(dimensions))
+	for i, dimension := range dimensions {
+		fields[i] = dimension.field
+	}
+	metrics := []string{primary}
+	for _, metric := range []string{countMetric, "bytes", "pkts"} {
+		if metric != primary {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	groups, err := aggregateConnections(connections, fields, metrics)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := 0.0
+	for _, group := range groups {
+		total += group.Metrics[primary]
+	}
+
+	entries := make([]TopEntry, 0, min(n, len(groups)))
+	for _, group := range groups[:min(n, len(groups))] {
+		entry := TopEntry{
+			Key:         make(map[string]string, len(dimensions)),
+			Connections: int(group.Metrics[countMetric]),
+			Bytes:       int(group.Metrics["bytes"]),
+			Packets:     int(group.Metrics["pkts"]),
+		}
+		for _, dimension := range dimensions {
+			entry.Key[dimension.name] = group.Key[dimension.field]
+		}
+		if total > 0 {
+			entry.Share = math.Round(group.Metrics[primary]/total*10000) / 10000 //nolint:mnd // Four decimal places
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, len(groups), nil
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+)
+
+const defaultTopN = 10 // Default number of entries returned by /api/topn
+
+// TopNEntry is one ranked value of a top-N query.
+type TopNEntry struct {
+	Value      string  `json:"value"`
+	Count      int     `json:"count"`
+	Score      float64 `json:"score"`
+	CountHuman string  `json:"count_human,omitempty"` //nolint:tagliatelle // API consistency
+	ScoreHuman string  `json:"score_human,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// GetTopN ranks distinct values of a field by connection count or a summed numeric field.
+func (a *API) GetTopN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	field := query.Get("field")
+	if field == "" {
+		http.Error(w, errFieldRequired.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	by := query.Get("by")
+	if by == "" {
+		by = countMetric
+	}
+
+	n := parseLimit(query, "n")
+	if n == 0 {
+		n = defaultTopN
+	}
+
+	metrics := []string{by}
+	if by != countMetric {
+		metrics = append(metrics, countMetric)
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	groups, err := rankGroups(connections, field, by, metrics, a.suppressions, a.localNetworks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	humanize := wantsHumanize(query)
+	entries := make([]TopNEntry, 0, min(n, len(groups)))
+	for _, group := range groups[:min(n, len(groups))] {
+		entry := TopNEntry{
+			Value: group.Key[field],
+			Count: int(group.Metrics[countMetric]),
+			Score: group.Metrics[by],
+		}
+		if humanize {
+			entry.CountHuman = connstats.HumanizeCount(entry.Count)
+			entry.ScoreHuman = humanizeMetric(models.CanonicalFieldName(by), entry.Score)
+		}
+		entries = append(entries, entry)
+	}
+
+	response := map[string]any{
+		"field":          field,
+		"by":             by,
+		"entries":        entries,
+		"total_distinct": len(groups),
+		"truncated":      len(groups) > n,
+		"limits":         map[string]int{"n": n},
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode top-N: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// rankGroups groups the connections by field, ranked by the first metric.
+func rankGroups(connections []models.Connection, field, by string, metrics []string, suppressions []Suppression, local models.LocalNetworks) ([]aggregateGroup, error) {
+	if by == riskMetric {
+		return riskGroups(connections, field, suppressions, local)
+	}
+
+	return aggregateConnections(connections, []string{field}, metrics)
+}
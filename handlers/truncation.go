@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"zeek-viz/models"
+)
+
+const (
+	summaryBucketSec = 60 // Smallest timeline bucket of a result summary
+	summaryTopHosts  = 10 // Sources and destinations ranked by a result summary
+)
+
+// parseLimit reads a positive integer limit from the query, returning 0 when absent or invalid.
+func parseLimit(query url.Values, name string) int {
+	limit, err := strconv.Atoi(query.Get(name))
+	if err != nil || limit <= 0 {
+		return 0
+	}
+
+	return limit
+}
+
+// pageConnections returns the page of up to limit connections (all when 0) starting at offset
+// and reports the truncation. next_offset points at the following page when there is one.
+func pageConnections(connections []models.Connection, offset, limit int) models.ConnectionsResponse {
+	response := models.ConnectionsResponse{
+		Total:  len(connections),
+		Offset: offset,
+		Limits: map[string]int{},
+	}
+
+	page := connections[min(offset, len(connections)):]
+	if limit > 0 {
+		response.Limits["limit"] = limit
+		if len(page) > limit {
+			page = page[:limit]
+			response.Truncated = true
+			response.NextOffset = offset + limit
+		}
+	}
+	if page == nil {
+		page = []models.Connection{}
+	}
+	response.Connections = page
+
+	return response
+}
+
+// SetMaxResults limits the connections /api/connections returns at once (0 for no limit).
+// Larger results are cut to a page of that size with a summary of all matches.
+func (a *API) SetMaxResults(limit int) {
+	a.maxResults = limit
+}
+
+// boundPage cuts the connections of a response at the result limit when the page would hold
+// more, adding a summary of all matching connections. It reports whether it cut the page.
+func (a *API) boundPage(connections []models.Connection, offset, limit int) (models.ConnectionsResponse, bool) {
+	remaining := len(connections) - min(offset, len(connections))
+	if a.maxResults <= 0 || remaining <= a.maxResults || (limit > 0 && limit <= a.maxResults) {
+		return models.ConnectionsResponse{}, false
+	}
+
+	page := pageConnections(connections, offset, a.maxResults)
+	delete(page.Limits, "limit")
+	page.Limits["max_results"] = a.maxResults
+	if limit > 0 {
+		page.Limits["limit"] = limit
+	}
+	page.Summary = summarizeConnections(connections)
+
+	return page, true
+}
+
+// summarizeConnections counts connections per minute, or per larger bucket for long spans, and
+// ranks their busiest sources and destinations.
+func summarizeConnections(connections []models.Connection) *models.ConnectionsSummary {
+	bucketSize := max(summaryBucketSec, autoBucketSize(connections))
+	sources := make(map[string]*models.HostCount)
+	destinations := make(map[string]*models.HostCount)
+	for i := range connections {
+		conn := &connections[i]
+		countHost(sources, conn.OrigHost, conn.TotalBytes())
+		countHost(destinations, conn.RespHost, conn.TotalBytes())
+	}
+
+	return &models.ConnectionsSummary{
+		BucketSize:      bucketSize,
+		Timeline:        buildTimeline(connections, bucketSize, nil).Points,
+		TopSources:      topHosts(sources),
+		TopDestinations: topHosts(destinations),
+	}
+}
+
+// countHost adds a connection of host to counts.
+func countHost(counts map[string]*models.HostCount, host string, bytes int) {
+	count := counts[host]
+	if count == nil {
+		count = &models.HostCount{Host: host}
+		counts[host] = count
+	}
+	count.Connections++
+	count.Bytes += bytes
+}
+
+// topHosts returns the summaryTopHosts hosts with the most connections, busiest first.
+func topHosts(counts map[string]*models.HostCount) []models.HostCount {
+	hosts := make([]models.HostCount, 0, len(counts))
+	for _, count := range counts {
+		hosts = append(hosts, *count)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Connections != hosts[j].Connections {
+			return hosts[i].Connections > hosts[j].Connections
+		}
+
+		return hosts[i].Host < hosts[j].Host
+	})
+
+	return hosts[:min(len(hosts), summaryTopHosts)]
+}
+
+// projectConnections replaces the connections of a page with records holding only the given
+// fields, keyed by their Zeek names, and the annotations of annotated connections.
+func projectConnections(response *models.ConnectionsResponse, fields []string, annotate models.Annotator) error {
+	names := make([]string, len(fields))
+	accessors := make([]models.ValueAccessor, len(fields))
+	for i, field := range fields {
+		accessor, exists := models.ValueFieldAccessor(field)
+		if !exists {
+			return fmt.Errorf("%w: %s", errUnknownField, field)
+		}
+		names[i], accessors[i] = models.CanonicalFieldName(field), accessor
+	}
+
+	connections, _ := response.Connections.([]models.Connection)
+	records := make([]map[string]any, len(connections))
+	for i := range connections {
+		records[i] = make(map[string]any, len(fields))
+		for j, accessor := range accessors {
+			records[i][names[j]] = accessor(&connections[i])
+		}
+		if annotate == nil {
+			continue
+		}
+		if annotations := annotate(&connections[i]); annotations != nil {
+			records[i]["annotations"] = annotations
+		}
+	}
+	response.Connections = records
+	response.Fields = names
+
+	return nil
+}
+
+// limitEdges keeps the heaviest edges of the graph up to the given limit, together with the
+// nodes they connect: nodes whose edges were all cut are dropped as well.
+func limitEdges(graph *models.NetworkGraph, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	if graph.Limits == nil {
+		graph.Limits = make(map[string]int)
+	}
+	graph.Limits["edge_limit"] = limit
+
+	if len(graph.Edges) <= limit {
+		return
+	}
+
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].TotalBytes != graph.Edges[j].TotalBytes {
+			return graph.Edges[i].TotalBytes > graph.Edges[j].TotalBytes
+		}
+
+		return graph.Edges[i].Count > graph.Edges[j].Count
+	})
+
+	connected := make(map[string]bool, len(graph.Nodes))
+	for _, edge := range graph.Edges[:limit] {
+		connected[edge.Source] = true
+		connected[edge.Target] = true
+	}
+	cut := make(map[string]bool)
+	for _, edge := range graph.Edges[limit:] {
+		cut[edge.Source] = !connected[edge.Source]
+		cut[edge.Target] = !connected[edge.Target]
+	}
+
+	nodes := make([]models.Node, 0, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		if !cut[node.ID] {
+			nodes = append(nodes, node)
+		}
+	}
+
+	graph.Nodes = nodes
+	graph.Edges = graph.Edges[:limit]
+	graph.Truncated = true
+}
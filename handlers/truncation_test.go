@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"zeek-viz/models"
+)
+
+func TestLimitEdgesDropsOrphanNodes(t *testing.T) {
+	graph := models.NetworkGraph{
+		// 10.0.0.5 had no edges before the cut either, such as a subnet with only internal traffic
+		Nodes: []models.Node{{ID: "10.0.0.1"}, {ID: "10.0.0.2"}, {ID: "10.0.0.3"}, {ID: "10.0.0.4"}, {ID: "10.0.0.5"}},
+		Edges: []models.Edge{
+			{Source: "10.0.0.1", Target: "10.0.0.2", TotalBytes: 500},
+			{Source: "10.0.0.3", Target: "10.0.0.4", TotalBytes: 10}, // Cut, orphaning 10.0.0.4 but not 10.0.0.3
+			{Source: "10.0.0.2", Target: "10.0.0.3", TotalBytes: 400},
+		},
+	}
+
+	limitEdges(&graph, 2)
+	if !graph.Truncated || len(graph.Edges) != 2 || graph.Limits["edge_limit"] != 2 {
+		t.Fatalf("truncated %t, %d edges, limits %v", graph.Truncated, len(graph.Edges), graph.Limits)
+	}
+	ids := make([]string, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		ids[i] = node.ID
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.5"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("nodes %v, want %v", ids, want)
+	}
+}
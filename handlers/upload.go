@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"zeek-viz/connstats"
+	"zeek-viz/models"
+	"zeek-viz/parse"
+)
+
+const (
+	uploadCreated   = "created"   // Upload stored as a new file
+	uploadDuplicate = "duplicate" // Identical content was already stored under the requested ID
+	uploadReplaced  = "replaced"  // New content for an existing dataset name
+
+	lenientMode = "lenient" // Skip malformed lines and keep parsing
+	strictMode  = "strict"  // Reject the upload on the first malformed line
+)
+
+var errInvalidParseMode = errors.New("mode must be lenient or strict")
+
+// parsedUpload is a log file received in a multipart upload, parsed and hashed.
+type parsedUpload struct {
+	filename    string
+	size        int64
+	mode        string
+	connections []models.Connection
+	stats       *models.ConnectionStats
+	report      *parse.Report
+	sha256      string
+	raw         []byte
+	metrics     *IngestMetrics
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter int64
+
+// Write counts p.
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+
+	return len(p), nil
+}
+
+// uploadOptions are the parsing options of a multipart upload.
+type uploadOptions struct {
+	mode    string
+	dedup   string
+	receive time.Duration // Time taken to receive the body
+}
+
+// readUpload parses the "logfile" form field of a multipart request, hashing (and unless
+// disabled, keeping) the original bytes. On failure it writes the error response and returns false.
+// A protocol log, such as an http.log, is attached to its conn.log dataset instead (see attachProtocolLog);
+// the response is then written as well and readUpload returns false.
+func (a *API) readUpload(w http.ResponseWriter, r *http.Request) (*parsedUpload, bool) {
+	options, ok := a.readUploadForm(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	return a.readUploadFile(w, r, options)
+}
+
+// readUploadForm receives a multipart upload and validates its parsing options. Uploads
+// beyond the upload limit are rejected with 413. On failure it writes the error response and
+// returns false.
+func (a *API) readUploadForm(w http.ResponseWriter, r *http.Request) (uploadOptions, bool) {
+	limit := a.maxUploadBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	// Parse multipart form data, which receives the whole body
+	receiveStart := time.Now()
+	err := r.ParseMultipartForm(maxUploadSize)
+	receive := time.Since(receiveStart)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("upload exceeds the %d MiB limit; stream larger logs to /api/upload/stream", limit>>20), http.StatusRequestEntityTooLarge)
+
+		return uploadOptions{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+
+		return uploadOptions{}, false
+	}
+
+	options := uploadOptions{mode: r.FormValue("mode"), dedup: r.FormValue("dedup"), receive: receive}
+	if options.mode == "" {
+		options.mode = lenientMode
+	}
+	if options.mode != lenientMode && options.mode != strictMode {
+		http.Error(w, errInvalidParseMode.Error(), http.StatusBadRequest)
+
+		return options, false
+	}
+
+	if options.dedup == "" {
+		options.dedup = dedupNone
+	}
+	if !validDedup(options.dedup) {
+		http.Error(w, errInvalidDedup.Error(), http.StatusBadRequest)
+
+		return options, false
+	}
+
+	return options, true
+}
+
+// readUploadFile parses the "logfile" form field of a received multipart upload, as described
+// for readUpload.
+func (a *API) readUploadFile(w http.ResponseWriter, r *http.Request, options uploadOptions) (*parsedUpload, bool) {
+	// Get the file from form data
+	file, header, err := r.FormFile("logfile")
+	if err != nil {
+		http.Error(w, "Failed to get file from request", http.StatusBadRequest)
+
+		return nil, false
+	}
+	defer file.Close()
+
+	log.Printf("Received file upload: %s (size: %d bytes, %s mode)", header.Filename, header.Size, options.mode)
+
+	buffered := bufio.NewReaderSize(file, sniffSize)
+	head, _ := buffered.Peek(sniffSize) // Read errors surface while parsing
+	if logType := protocolLogType(head); logType != "" {
+		a.attachProtocolLog(w, r, logType, header.Filename, buffered)
+
+		return nil, false
+	}
+
+	upload, ok := a.parseUpload(r.Context(), w, buffered, options.mode, options.dedup, !a.discardRaw)
+	if !ok {
+		return nil, false
+	}
+	upload.filename = header.Filename
+	upload.size = header.Size
+	upload.metrics.ReceiveMs = durationMs(options.receive)
+	upload.metrics.log(header.Filename)
+
+	return upload, true
+}
+
+// parseUpload sniffs, hashes, and parses uploaded content, collapsing duplicate UIDs as
+// requested. The raw bytes are kept when keepRaw is set. Parsing stops when ctx is done, such
+// as when the client disconnects. On failure it writes the error response and returns false.
+func (a *API) parseUpload(ctx context.Context, w http.ResponseWriter, file io.Reader, mode, dedup string, keepRaw bool) (*parsedUpload, bool) {
+	upload, err := parseLog(ctx, file, mode, dedup, keepRaw)
+	if err != nil {
+		a.metrics.rejectUpload(err)
+	}
+	var uploadErr *uploadError
+	switch {
+	case errors.As(err, &uploadErr):
+		writeUploadError(w, uploadErr)
+
+		return nil, false
+	case errors.Is(err, errIngestBudget):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+
+		return nil, false
+	case errors.Is(err, parse.ErrCanceled):
+		writeCanceled(w, "Upload", err)
+
+		return nil, false
+	case err != nil:
+		log.Printf("Failed to load connections from uploaded file: %v", err)
+		http.Error(w, "Failed to parse connection log file", http.StatusBadRequest)
+
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// parseLog sniffs, hashes, and parses a conn.log, collapsing duplicate UIDs as requested.
+// Content that isn't a parsable conn.log is rejected with an *uploadError.
+func parseLog(ctx context.Context, file io.Reader, mode, dedup string, keepRaw bool) (*parsedUpload, error) {
+	// Hash (and optionally keep) the raw bytes while parsing them
+	hasher := sha256.New()
+	var raw bytes.Buffer
+	var size byteCounter
+	var sink io.Writer = io.MultiWriter(hasher, &size)
+	if keepRaw {
+		sink = io.MultiWriter(hasher, &size, &raw)
+	}
+
+	// Reject content that clearly isn't a conn.log before parsing it
+	buffered := bufio.NewReaderSize(file, sniffSize)
+	head, _ := buffered.Peek(sniffSize) // Shorter files return what's there; read errors surface while parsing
+	if uploadErr := sniffUpload(head); uploadErr != nil {
+		return nil, uploadErr
+	}
+
+	// Parse connections from uploaded file
+	measurement := startIngestMeasurement()
+	connections, stats, report, err := parseConnections(ctx, io.TeeReader(buffered, sink), mode == strictMode)
+	var lines int
+	if report != nil {
+		lines = report.TotalLines
+	}
+	ingest := measurement.stop(lines, int64(size))
+	if errors.Is(err, parse.ErrMalformedLine) {
+		return nil, &uploadError{
+			Code:    "malformed_line",
+			Message: err.Error(),
+			Line:    report.Samples[0].Line,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(connections) == 0 {
+		return nil, &uploadError{
+			Code:    "no_connections",
+			Message: fmt.Sprintf("no valid conn.log records could be parsed from the file (%d malformed lines skipped)", report.SkippedLines),
+		}
+	}
+
+	connections, report.Duplicates = dedupConnections(connections, dedup)
+	report.Dedup = dedup
+	if dedup != dedupNone && report.Duplicates > 0 {
+		report.ParsedLines = len(connections)
+		stats = connstats.Compute(connections)
+		log.Printf("Collapsed %d duplicate records (%s wins)", report.Duplicates, dedup)
+	}
+
+	upload := &parsedUpload{
+		mode:        mode,
+		connections: connections,
+		stats:       stats,
+		report:      report,
+		sha256:      hex.EncodeToString(hasher.Sum(nil)),
+		metrics:     ingest,
+	}
+	if keepRaw {
+		upload.raw = raw.Bytes()
+	}
+
+	return upload, nil
+}
+
+// uploadFileID returns the file ID for an upload. Clients may pin it with a dataset name or an
+// idempotency key (header or form field) so that retried or repeated uploads don't create duplicates.
+// Without either, content already stored returns the ID of the file holding it, unless force=true.
+// Callers must hold a.mu.
+func (a *API) uploadFileID(r *http.Request, upload *parsedUpload, uploadTime int64) string {
+	if dataset := r.FormValue("dataset"); dataset != "" {
+		return a.generateFileID("dataset:"+dataset, 0)
+	}
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = r.FormValue("idempotency_key")
+	}
+	if idempotencyKey != "" {
+		return a.generateFileID("key:"+idempotencyKey, 0)
+	}
+	if existingID := a.fileWithContent(upload.sha256); existingID != "" && r.FormValue("force") != "true" {
+		return existingID
+	}
+
+	return a.newFileID(upload.filename, uploadTime)
+}
+
+// fileWithContent returns the ID of the earliest uploaded file whose raw bytes have the SHA256
+// digest, or "" when no file does. Callers must hold a.mu.
+func (a *API) fileWithContent(digest string) string {
+	existingID := ""
+	for fileID, fileData := range a.files {
+		if digest == "" || fileData.SHA256 != digest {
+			continue
+		}
+		if existingID == "" || fileData.UploadTime < a.files[existingID].UploadTime ||
+			(fileData.UploadTime == a.files[existingID].UploadTime && fileID < existingID) {
+			existingID = fileID
+		}
+	}
+
+	return existingID
+}
+
+// SetMaxUploadSize limits the bytes of a multipart upload (0 for the default of 50 MiB).
+// Streamed uploads are limited by the ingest budget instead.
+func (a *API) SetMaxUploadSize(limit int64) {
+	a.uploadLimit = limit
+}
+
+// maxUploadBytes returns the bytes a multipart upload may hold.
+func (a *API) maxUploadBytes() int64 {
+	if a.uploadLimit > 0 {
+		return a.uploadLimit
+	}
+
+	return maxUploadSize
+}
+
+// applyTo replaces the content of a file record with the upload, dropping all derived caches.
+func (u *parsedUpload) applyTo(fileData *FileData) {
+	fileData.Filename = u.filename
+	fileData.Size = u.size
+	fileData.SHA256 = u.sha256
+	fileData.raw = u.raw
+	fileData.ParseReport = u.report
+	fileData.ParseMode = u.mode
+	fileData.setConnections(u.connections, u.stats)
+}
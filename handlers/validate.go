@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"zeek-viz/parse"
+)
+
+const sniffSize = 64 << 10 // Bytes inspected to recognize the format of an upload
+
+// uploadError is a structured rejection of an uploaded file, telling the user what was
+// detected instead of a conn.log.
+type uploadError struct {
+	Success  bool   `json:"success"`
+	Code     string `json:"error"`
+	Message  string `json:"message"`
+	Detected string `json:"detected,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+// Error returns the message of the rejection.
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// magicSignature identifies a binary format by its leading bytes.
+type magicSignature struct {
+	magic    []byte
+	code     string
+	detected string
+	message  string
+}
+
+// logFingerprint identifies another Zeek log type by fields only it carries.
+type logFingerprint struct {
+	path   string
+	fields []string
+}
+
+// magicSignatures returns the leading bytes of binary formats users commonly upload by mistake.
+func magicSignatures() []magicSignature {
+	const pcapHint = "packet capture detected; upload it as a multipart file to /api/upload to extract its connections, or run it through Zeek first (zeek -r capture.pcap)"
+
+	return []magicSignature{
+		{[]byte{0xd4, 0xc3, 0xb2, 0xa1}, "pcap_file", "pcap", pcapHint},
+		{[]byte{0xa1, 0xb2, 0xc3, 0xd4}, "pcap_file", "pcap", pcapHint},
+		{[]byte{0x4d, 0x3c, 0xb2, 0xa1}, "pcap_file", "pcap", pcapHint},
+		{[]byte{0xa1, 0xb2, 0x3c, 0x4d}, "pcap_file", "pcap", pcapHint},
+		{[]byte{0x0a, 0x0d, 0x0d, 0x0a}, "pcap_file", "pcapng", pcapHint},
+		{[]byte{0x1f, 0x8b}, "compressed_file", "gzip", "gzip-compressed file detected; decompress it before uploading"},
+		{[]byte("PK\x03\x04"), "compressed_file", "zip", "zip archive detected; extract conn.log before uploading"},
+	}
+}
+
+// logFingerprints returns fields that identify other Zeek log types.
+func logFingerprints() []logFingerprint {
+	return []logFingerprint{
+		{"dns", []string{"query", "qtype_name", "rcode_name"}},
+		{"http", []string{"method", "uri", "status_code"}},
+		{"ssl", []string{"server_name", "cipher", "established"}},
+		{"x509", []string{"certificate.version", "certificate.serial"}},
+		{"files", []string{"fuid", "mime_type"}},
+		{"dhcp", []string{"assigned_addr", "msg_types"}},
+		{"ssh", []string{"auth_success", "auth_attempts"}},
+		{"smtp", []string{"mailfrom", "rcptto"}},
+		{"notice", []string{"note", "msg"}},
+		{"weird", []string{"name", "notice", "peer"}},
+	}
+}
+
+// connFields returns the fields an entry needs to be read as a conn.log record.
+func connFields() []string {
+	return []string{"id.orig_h", "id.resp_h", "proto"}
+}
+
+// sniffUpload inspects the beginning of an upload and rejects files that are clearly not a
+// Zeek conn.log in JSON or TSV format. It returns nil when the content looks acceptable.
+func sniffUpload(head []byte) *uploadError {
+	if len(bytes.TrimSpace(head)) == 0 {
+		return &uploadError{Code: "empty_file", Message: "the uploaded file is empty"}
+	}
+
+	for _, signature := range magicSignatures() {
+		if bytes.HasPrefix(head, signature.magic) {
+			return &uploadError{Code: signature.code, Message: signature.message, Detected: signature.detected}
+		}
+	}
+
+	if isBinary(head) {
+		return &uploadError{Code: "binary_file", Message: "binary file detected; expected a Zeek conn.log in JSON or TSV format"}
+	}
+
+	line := firstLine(head)
+	switch {
+	case strings.HasPrefix(line, "#"):
+		return sniffTSVHeader(head)
+	case strings.HasPrefix(line, "{"):
+		return sniffJSONRecord(line, len(head) == sniffSize)
+	case strings.Contains(line, "{"):
+		// Text before the record (e.g. a syslog prefix) is stripped by the parser
+		return sniffJSONRecord(line[strings.IndexByte(line, '{'):], len(head) == sniffSize)
+	default:
+		return &uploadError{
+			Code:    "not_zeek_log",
+			Message: "the file is neither Zeek JSON nor Zeek TSV; expected a conn.log with one record per line",
+		}
+	}
+}
+
+// isBinary reports whether the content contains NUL bytes or invalid UTF-8. A multi-byte
+// sequence truncated at the end of the sniffed window is tolerated.
+func isBinary(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	if last := bytes.LastIndexByte(head, '\n'); last >= 0 {
+		head = head[:last]
+	} else {
+		head = head[:max(0, len(head)-utf8.UTFMax)]
+	}
+
+	return !utf8.Valid(head)
+}
+
+// firstLine returns the first non-blank line of the content, without a byte order mark.
+func firstLine(head []byte) string {
+	for line := range strings.SplitSeq(string(head), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, parse.ByteOrderMark))
+		if line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// sniffTSVHeader checks the #path directive of a Zeek TSV log. Logs without one are left to
+// the parser, which reports lines it can't map to conn.log fields.
+func sniffTSVHeader(head []byte) *uploadError {
+	path := ""
+	for line := range strings.SplitSeq(string(head), "\n") {
+		if value, found := strings.CutPrefix(line, "#path"); found {
+			path = strings.TrimSpace(value)
+
+			break
+		}
+	}
+
+	if path != "" && path != "conn" {
+		return wrongLogType(path)
+	}
+
+	return nil
+}
+
+// sniffJSONRecord checks that the first JSON record carries conn.log fields. Unparsable
+// records are left to the parser when the sniffed window may have cut them off. The _path
+// field and the fingerprints of other log types are checked first, since records of logs
+// such as notice.log carry the connection fields as well.
+func sniffJSONRecord(line string, truncated bool) *uploadError {
+	var record map[string]json.RawMessage
+	err := json.Unmarshal([]byte(line), &record)
+	if err != nil {
+		if truncated {
+			return nil
+		}
+
+		return &uploadError{Code: "invalid_json", Message: fmt.Sprintf("the first line is not valid JSON: %v", err)}
+	}
+
+	var path string
+	if raw, exists := record["_path"]; exists && json.Unmarshal(raw, &path) == nil && path != "" && path != "conn" {
+		return wrongLogType(path)
+	}
+
+	for _, fingerprint := range logFingerprints() {
+		matched := 0
+		for _, field := range fingerprint.fields {
+			if _, exists := record[field]; exists {
+				matched++
+			}
+		}
+		if matched >= 2 { //nolint:mnd // Two distinctive fields are required to name a log type
+			return wrongLogType(fingerprint.path)
+		}
+	}
+
+	missing := 0
+	for _, field := range connFields() {
+		if _, exists := record[field]; !exists {
+			missing++
+		}
+	}
+	if missing == 0 {
+		return nil
+	}
+
+	return &uploadError{
+		Code:    "not_zeek_log",
+		Message: "JSON records don't contain Zeek conn.log fields (" + strings.Join(connFields(), ", ") + ")",
+	}
+}
+
+// wrongLogType rejects a Zeek log of another type.
+func wrongLogType(path string) *uploadError {
+	return &uploadError{
+		Code:     "wrong_log_type",
+		Message:  fmt.Sprintf("this looks like %s.log, expected conn.log fields", path),
+		Detected: path,
+	}
+}
+
+// writeUploadError sends a structured upload rejection.
+func writeUploadError(w http.ResponseWriter, uploadErr *uploadError) {
+	log.Printf("Rejected upload: %s", uploadErr.Message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	err := json.NewEncoder(w).Encode(uploadErr)
+	if err != nil {
+		log.Printf("Failed to encode upload error: %v", err)
+	}
+}
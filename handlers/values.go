@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ValueCount is a distinct field value with the number of connections carrying it.
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// GetValues returns the distinct values of a field with their counts, most frequent first,
+// so the UI can populate filter dropdowns from the data actually present.
+func (a *API) GetValues(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	field := query.Get("field")
+	if field == "" {
+		http.Error(w, errFieldRequired.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	connections, err := a.filterCurrentConnections(r, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	groups, err := aggregateConnections(connections, []string{field}, []string{countMetric})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	totalDistinct := len(groups)
+	if limit := parseLimit(query, "limit"); limit > 0 && len(groups) > limit {
+		groups = groups[:limit]
+	}
+
+	values := make([]ValueCount, 0, len(groups))
+	for _, group := range groups {
+		values = append(values, ValueCount{
+			Value: group.Key[field],
+			Count: int(group.Metrics[countMetric]),
+		})
+	}
+
+	response := map[string]any{
+		"field":          field,
+		"values":         values,
+		"total_distinct": totalDistinct,
+		"truncated":      len(values) < totalDistinct,
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode values: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
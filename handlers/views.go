@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	viewIDBytes       = 8   // Random bytes of a view ID
+	maxViewNameLength = 200 // Characters of a view name
+)
+
+var (
+	errViewName        = errors.New("a view needs a name of at most 200 characters")
+	errViewFilter      = errors.New("unknown filter")
+	errViewTimeRange   = errors.New("start and end must both be Unix timestamps")
+	errViewFile        = errors.New("unknown file")
+	errViewGraphLayout = errors.New("graph_layout must be force, circular, or hierarchical")
+	errViewColorBy     = errors.New("color_by must be locality, country, or community")
+	errViewSizeBy      = errors.New("size_by must be connections, degree, or betweenness")
+	errViewEdgeBy      = errors.New("edge_by must be protocol, pair, service, or port")
+	errViewNotFound    = errors.New("view not found")
+)
+
+// View is a saved combination of filters and display options, such as "suspicious SMB
+// traffic last Tuesday". Filters holds query parameters that every endpoint reading
+// connections accepts; the layout options only concern the UI.
+type View struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	FileID      string            `json:"file_id,omitempty"` //nolint:tagliatelle // API consistency
+	Filters     map[string]string `json:"filters"`
+	Layout      ViewLayout        `json:"layout"`
+	CreatedAt   int64             `json:"created_at"` //nolint:tagliatelle // API consistency
+	UpdatedAt   int64             `json:"updated_at"` //nolint:tagliatelle // API consistency
+}
+
+// ViewLayout holds the graph and timeline options of a view, as the UI sets them. The subnet
+// grouping, edge, and timeline options take the values of the subnet_group, subnet_group_v6,
+// edge_by, bucket, and group_by parameters; GroupBy is the group_by of the graph.
+type ViewLayout struct {
+	GraphLayout    string `json:"graph_layout,omitempty"`    //nolint:tagliatelle // API consistency
+	SubnetGroup    string `json:"subnet_group,omitempty"`    //nolint:tagliatelle // API consistency
+	SubnetGroupV6  string `json:"subnet_group_v6,omitempty"` //nolint:tagliatelle // API consistency
+	GroupBy        string `json:"group_by,omitempty"`        //nolint:tagliatelle // API consistency
+	EdgeBy         string `json:"edge_by,omitempty"`         //nolint:tagliatelle // API consistency
+	ColorBy        string `json:"color_by,omitempty"`        //nolint:tagliatelle // API consistency
+	SizeBy         string `json:"size_by,omitempty"`         //nolint:tagliatelle // API consistency
+	TimelineBucket string `json:"timeline_bucket,omitempty"` //nolint:tagliatelle // API consistency
+	TimelineGroup  string `json:"timeline_group,omitempty"`  //nolint:tagliatelle // API consistency
+}
+
+// viewResponse is a view with the links to open it.
+type viewResponse struct {
+	View
+
+	URL   string `json:"url"`   // Opens the view in the UI, relative to the server
+	Query string `json:"query"` // The filters as a query string for the API
+}
+
+// validate checks the layout options take values the UI and the API accept.
+func (l *ViewLayout) validate() error {
+	_, err := parseGraphLayout(l.GraphLayout)
+	if err != nil {
+		return errViewGraphLayout
+	}
+	if l.ColorBy != "" && l.ColorBy != "locality" && l.ColorBy != "country" && l.ColorBy != "community" {
+		return errViewColorBy
+	}
+	if l.SizeBy != "" && l.SizeBy != "connections" && l.SizeBy != "degree" && l.SizeBy != "betweenness" {
+		return errViewSizeBy
+	}
+	_, err = parseEdgeAggregation(l.EdgeBy)
+	if err != nil {
+		return errViewEdgeBy
+	}
+
+	_, err = parseSubnetGrouping(url.Values{"subnet_group": {l.SubnetGroup}, "subnet_group_v6": {l.SubnetGroupV6}})
+	if err != nil {
+		return err
+	}
+	_, _, err = parseGraphGroupBy(l.GroupBy)
+	if err != nil {
+		return err
+	}
+	_, err = parseTimelineBucket(url.Values{"bucket": {l.TimelineBucket}}, nil)
+	if err != nil {
+		return err
+	}
+	_, _, err = parseTimelineGroupBy(url.Values{"group_by": {l.TimelineGroup}})
+
+	return err
+}
+
+// query returns the view's filters as query parameters.
+func (v *View) query() url.Values {
+	query := url.Values{}
+	for name, value := range v.Filters {
+		query.Set(name, value)
+	}
+
+	return query
+}
+
+// SetViewsFile persists saved views in path, loading the ones saved there.
+func (a *API) SetViewsFile(path string) error {
+	var views []View
+	err := readJSONFile(path, &views)
+	if err != nil {
+		return err
+	}
+
+	a.viewsPath = path
+	a.setViews(views)
+
+	return nil
+}
+
+// GetViews returns the saved views, sorted by name.
+func (a *API) GetViews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	views := make([]viewResponse, 0, len(a.views))
+	for _, view := range a.views {
+		views = append(views, a.viewResponse(view))
+	}
+
+	err := json.NewEncoder(w).Encode(map[string]any{"views": views})
+	if err != nil {
+		log.Printf("Failed to encode views: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetView returns a saved view.
+func (a *API) GetView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	index := a.viewIndex(r.PathValue("id"))
+	if index < 0 {
+		http.Error(w, errViewNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(a.viewResponse(a.views[index]))
+	if err != nil {
+		log.Printf("Failed to encode view: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// CreateView saves a new view under a generated ID.
+func (a *API) CreateView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var view View
+	err := json.NewDecoder(r.Body).Decode(&view)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	err = a.validateView(&view)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	random := make([]byte, viewIDBytes)
+	_, _ = rand.Read(random) // crypto/rand never fails on supported platforms
+	view.ID = hex.EncodeToString(random)
+	view.CreatedAt = time.Now().Unix()
+	view.UpdatedAt = view.CreatedAt
+
+	a.setViews(append(slices.Clone(a.views), view))
+	a.respondSavedView(w, view, "Saved")
+}
+
+// UpdateView replaces the name, filters, and options of a saved view.
+func (a *API) UpdateView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	index := a.viewIndex(r.PathValue("id"))
+	if index < 0 {
+		http.Error(w, errViewNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	var view View
+	err := json.NewDecoder(r.Body).Decode(&view)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	err = a.validateView(&view)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	view.ID = a.views[index].ID
+	view.CreatedAt = a.views[index].CreatedAt
+	view.UpdatedAt = time.Now().Unix()
+
+	views := slices.Clone(a.views)
+	views[index] = view
+	a.setViews(views)
+	a.respondSavedView(w, view, "Updated")
+}
+
+// DeleteView removes a saved view. Links to it stop working.
+func (a *API) DeleteView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	index := a.viewIndex(id)
+	if index < 0 {
+		http.Error(w, errViewNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+	a.setViews(slices.Delete(slices.Clone(a.views), index, index+1))
+
+	err := a.saveViews()
+	if err != nil {
+		log.Printf("Failed to save views: %v", err)
+		http.Error(w, "Failed to save views", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Removed view %s", id)
+
+	err = json.NewEncoder(w).Encode(map[string]any{"success": true, "id": id})
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// validateView checks and normalizes a view sent by a client. Empty filters are dropped, and
+// the others must be ones filterConnections accepts.
+func (a *API) validateView(view *View) error {
+	view.Name = strings.TrimSpace(view.Name)
+	if view.Name == "" || len([]rune(view.Name)) > maxViewNameLength {
+		return errViewName
+	}
+	view.Description = strings.TrimSpace(view.Description)
+	if view.FileID != "" && a.files[view.FileID] == nil {
+		return fmt.Errorf("%w %q", errViewFile, view.FileID)
+	}
+
+	filters := make(map[string]string, len(view.Filters))
+	for name, value := range view.Filters {
+		if !slices.Contains(filterParams(), name) {
+			return fmt.Errorf("%w %q", errViewFilter, name)
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			filters[name] = value
+		}
+	}
+	view.Filters = filters
+
+	err := validateViewTimeRange(filters["start"], filters["end"])
+	if err != nil {
+		return err
+	}
+	_, err = a.filterConnections(nil, view.query())
+	if err != nil {
+		return err
+	}
+
+	return view.Layout.validate()
+}
+
+// validateViewTimeRange checks a view has no time range, or a complete one that
+// connfilter reads, which ignores the others.
+func validateViewTimeRange(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	_, err1 := strconv.ParseInt(start, 10, 64)
+	_, err2 := strconv.ParseInt(end, 10, 64)
+	if err1 != nil || err2 != nil {
+		return errViewTimeRange
+	}
+
+	return nil
+}
+
+// respondSavedView persists the views and answers with the saved one.
+func (a *API) respondSavedView(w http.ResponseWriter, view View, action string) {
+	err := a.saveViews()
+	if err != nil {
+		log.Printf("Failed to save views: %v", err)
+		http.Error(w, "Failed to save views", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("%s view %s (%q)", action, view.ID, view.Name)
+
+	err = json.NewEncoder(w).Encode(a.viewResponse(view))
+	if err != nil {
+		log.Printf("Failed to encode view: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// viewResponse adds the links of a view.
+func (a *API) viewResponse(view View) viewResponse {
+	return viewResponse{
+		View:  view,
+		URL:   a.basePath + "/?" + url.Values{"view": {view.ID}}.Encode(),
+		Query: view.query().Encode(),
+	}
+}
+
+// viewIndex returns the position of the view with the ID, or -1.
+func (a *API) viewIndex(id string) int {
+	return slices.IndexFunc(a.views, func(view View) bool {
+		return view.ID == id
+	})
+}
+
+// restoreViews replaces the saved views with those of a snapshot.
+func (a *API) restoreViews(views []View) {
+	a.setViews(slices.Clone(views))
+
+	err := a.saveViews()
+	if err != nil {
+		log.Printf("Failed to save views: %v", err)
+	}
+}
+
+// setViews replaces the saved views, sorting them by name.
+func (a *API) setViews(views []View) {
+	slices.SortStableFunc(views, func(x, y View) int {
+		return cmp.Or(cmp.Compare(strings.ToLower(x.Name), strings.ToLower(y.Name)), cmp.Compare(x.ID, y.ID))
+	})
+	a.views = views
+}
+
+// saveViews writes the saved views to their file, if one is configured.
+func (a *API) saveViews() error {
+	if a.viewsPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.views, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding views: %w", err)
+	}
+
+	return writeFileAtomic(a.viewsPath, data)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const watchPollInterval = 10 * time.Second // How often watched directories are scanned for new logs
+
+var errNotADirectory = errors.New("not a directory")
+
+// WatchStatus reports a directory watched for rotated conn.logs.
+type WatchStatus struct {
+	Path        string `json:"path"`
+	FileID      string `json:"file_id,omitempty"`   //nolint:tagliatelle // API consistency
+	Files       int    `json:"files"`               // Logs ingested since watching started
+	Failed      int    `json:"failed"`              // Logs that couldn't be read, and were skipped
+	Connections int64  `json:"connections"`         // Connections appended since watching started
+	LastFile    string `json:"last_file,omitempty"` //nolint:tagliatelle // API consistency
+	LastRead    int64  `json:"last_read,omitempty"` //nolint:tagliatelle // API consistency
+	Error       string `json:"error,omitempty"`     // Why the directory can't currently be scanned
+}
+
+// watchedFile is a rotated log seen in a watched directory.
+type watchedFile struct {
+	size     int64
+	modTime  time.Time
+	ingested bool // Ingested, or there at startup and skipped
+}
+
+// dirWatcher ingests the rotated conn.logs appearing in a directory. Its status is read by
+// GetTails while run updates it.
+type dirWatcher struct {
+	dir  string
+	seen map[string]watchedFile // Only used by run
+
+	mu     sync.Mutex
+	status WatchStatus
+}
+
+// WatchDir scans the Zeek log directory dir and its subdirectories for rotated conn.logs
+// (conn.log.1, conn.10:00:00-11:00:00.log.gz, ...) until ctx is done, appending the
+// connections of each new one to a live dataset named after the directory. Logs are ingested
+// once they stopped growing between two scans. Logs already there are skipped unless
+// existing is set.
+func (a *API) WatchDir(ctx context.Context, dir string, existing bool) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFailedToOpenLogFile, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s", errNotADirectory, dir)
+	}
+
+	w := &dirWatcher{dir: dir, seen: map[string]watchedFile{}, status: WatchStatus{Path: dir}}
+	if !existing {
+		current, err := w.scan()
+		if err != nil {
+			return err
+		}
+		for path, file := range current {
+			file.ingested = true
+			w.seen[path] = file
+		}
+	}
+
+	a.tailMu.Lock()
+	a.watchers = append(a.watchers, w)
+	a.tailMu.Unlock()
+
+	log.Printf("Watching %s for rotated conn.logs", dir)
+	go w.run(ctx, a)
+
+	return nil
+}
+
+// isRotatedConnLog reports whether a file name is that of a rotated conn.log. The conn.log
+// Zeek is still writing is left to --tail.
+func isRotatedConnLog(name string) bool {
+	return strings.HasPrefix(name, "conn.") && name != "conn.log"
+}
+
+// run scans the directory until ctx is done.
+func (w *dirWatcher) run(ctx context.Context, a *API) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped watching %s", w.dir)
+
+			return
+		case <-ticker.C:
+		}
+
+		current, err := w.scan()
+		if err != nil {
+			w.setError(err)
+
+			continue
+		}
+		w.setError(nil)
+
+		var ready []string
+		for path, file := range current {
+			previous, known := w.seen[path]
+			switch {
+			case known && previous.ingested:
+				file.ingested = true
+			case known && previous.size == file.size && previous.modTime.Equal(file.modTime):
+				ready = append(ready, path)
+			}
+			current[path] = file
+		}
+		w.seen = current // Forgets logs that were deleted
+
+		slices.Sort(ready) // Rotations in time order
+		for _, path := range ready {
+			if ctx.Err() != nil {
+				return
+			}
+			w.ingest(ctx, a, path)
+			file := w.seen[path]
+			file.ingested = true
+			w.seen[path] = file
+		}
+	}
+}
+
+// scan returns the rotated conn.logs in the directory and its subdirectories, skipping
+// hidden ones.
+func (w *dirWatcher) scan() (map[string]watchedFile, error) {
+	files := make(map[string]watchedFile)
+	err := filepath.WalkDir(w.dir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case path != w.dir && strings.HasPrefix(entry.Name(), "."):
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		case !entry.Type().IsRegular() || !isRotatedConnLog(entry.Name()):
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil //nolint:nilerr // Removed while scanning
+		}
+		files[path] = watchedFile{size: info.Size(), modTime: info.ModTime()}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning %s: %w", w.dir, err)
+	}
+
+	return files, nil
+}
+
+// ingest parses a rotated log, decompressing it if needed, and appends its connections to the
+// directory's live dataset. Unreadable logs are logged and not retried.
+func (w *dirWatcher) ingest(ctx context.Context, a *API, path string) {
+	name, err := filepath.Rel(filepath.Dir(w.dir), path)
+	if err != nil {
+		name = filepath.Base(path)
+	}
+	walk := func(visit batchVisitor) error {
+		return a.walkLocalFile(ctx, path, filepath.ToSlash(name), visit)
+	}
+	files, err := readBatch(ctx, walk, uploadOptions{mode: lenientMode, dedup: dedupNone}, false, a.maxBatchBytes())
+	if err != nil {
+		log.Printf("Failed to ingest %s: %v", path, err)
+
+		w.mu.Lock()
+		w.status.Failed++
+		w.mu.Unlock()
+
+		return
+	}
+
+	for _, file := range files {
+		if file.upload == nil {
+			log.Printf("Skipped %s: %s", file.entry.Filename, file.entry.Message)
+
+			continue
+		}
+		connections := file.upload.connections
+		fileID := a.IngestLive(w.dir, connections)
+		log.Printf("Ingested %d connections from %s", len(connections), file.entry.Filename)
+
+		w.mu.Lock()
+		w.status.FileID = fileID
+		w.status.Files++
+		w.status.Connections += int64(len(connections))
+		w.status.LastFile = file.entry.Filename
+		w.status.LastRead = time.Now().Unix()
+		w.mu.Unlock()
+	}
+}
+
+// setError records why the directory can't be scanned, or clears it.
+func (w *dirWatcher) setError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.status.Error = ""
+	if err != nil {
+		w.status.Error = err.Error()
+	}
+}
+
+// snapshot returns the current status.
+func (w *dirWatcher) snapshot() WatchStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.status
+}
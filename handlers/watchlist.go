@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+
+	"zeek-viz/connfilter"
+	"zeek-viz/models"
+)
+
+const maxWatchlistHosts = 20 // Matched hosts listed per watchlist hit
+
+var (
+	errWatchlistValue    = errors.New("value must be an IP address or CIDR prefix")
+	errWatchlistNotFound = errors.New("watchlist entry not found")
+)
+
+// WatchlistEntry is an IP address or CIDR prefix of interest.
+type WatchlistEntry struct {
+	Value   string `json:"value"`
+	Note    string `json:"note,omitempty"`
+	AddedAt int64  `json:"added_at"` //nolint:tagliatelle // API consistency
+
+	prefix netip.Prefix
+}
+
+// WatchlistHit records the connections of a dataset that touch a watchlist entry.
+type WatchlistHit struct {
+	Value       string   `json:"value"`
+	Note        string   `json:"note,omitempty"`
+	Connections int      `json:"connections"`
+	Hosts       []string `json:"hosts"`
+}
+
+// SetWatchlistFile persists the watchlist in path, loading the entries saved there, and
+// flags the loaded datasets that touch them.
+func (a *API) SetWatchlistFile(path string) error {
+	var entries []WatchlistEntry
+	err := readJSONFile(path, &entries)
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		entries[i].prefix, err = parseWatchlistValue(entries[i].Value)
+		if err != nil {
+			return fmt.Errorf("watchlist entry %q: %w", entries[i].Value, err)
+		}
+	}
+
+	a.watchlistPath = path
+	a.setWatchlist(entries)
+
+	return nil
+}
+
+// GetWatchlist returns the watchlist entries.
+func (a *API) GetWatchlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(map[string]any{"entries": a.watchlistEntries()})
+	if err != nil {
+		log.Printf("Failed to encode watchlist: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// AddWatchlistEntry adds an IP address or CIDR prefix to the watchlist, or updates the note
+// of an existing entry, and re-flags all loaded datasets.
+func (a *API) AddWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var entry WatchlistEntry
+	err := json.NewDecoder(r.Body).Decode(&entry)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+
+	entry.prefix, err = parseWatchlistValue(entry.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	entry.Value = watchlistValue(entry.prefix)
+	entry.AddedAt = time.Now().Unix()
+
+	entries := slices.DeleteFunc(a.watchlistEntries(), func(existing WatchlistEntry) bool {
+		return existing.Value == entry.Value
+	})
+	a.setWatchlist(append(entries, entry))
+
+	err = a.saveWatchlist()
+	if err != nil {
+		log.Printf("Failed to save watchlist: %v", err)
+		http.Error(w, "Failed to save watchlist", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Added %s to the watchlist", entry.Value)
+
+	err = json.NewEncoder(w).Encode(entry)
+	if err != nil {
+		log.Printf("Failed to encode watchlist entry: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// DeleteWatchlistEntry removes the entry named by the value query parameter.
+func (a *API) DeleteWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	prefix, err := parseWatchlistValue(r.URL.Query().Get("value"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	entries := a.watchlistEntries()
+	remaining := slices.DeleteFunc(slices.Clone(entries), func(entry WatchlistEntry) bool {
+		return entry.prefix == prefix
+	})
+	if len(remaining) == len(entries) {
+		http.Error(w, errWatchlistNotFound.Error(), http.StatusNotFound)
+
+		return
+	}
+	a.setWatchlist(remaining)
+
+	err = a.saveWatchlist()
+	if err != nil {
+		log.Printf("Failed to save watchlist: %v", err)
+		http.Error(w, "Failed to save watchlist", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Removed %s from the watchlist", watchlistValue(prefix))
+
+	err = json.NewEncoder(w).Encode(map[string]any{"success": true, "entries": remaining})
+	if err != nil {
+		log.Printf("Failed to encode watchlist: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// parseWatchlistValue parses an IP address as a single-address prefix, or a CIDR prefix.
+func parseWatchlistValue(value string) (netip.Prefix, error) {
+	prefix, err := connfilter.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, errWatchlistValue
+	}
+
+	return prefix, nil
+}
+
+// watchlistValue formats a prefix for display, as a bare address when it is a single IP.
+func watchlistValue(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+
+	return prefix.String()
+}
+
+// restoreWatchlist replaces the watchlist with entries from a snapshot, skipping invalid ones.
+func (a *API) restoreWatchlist(entries []WatchlistEntry) {
+	valid := make([]WatchlistEntry, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parseWatchlistValue(entry.Value)
+		if err != nil {
+			log.Printf("Skipping invalid watchlist entry %q: %v", entry.Value, err)
+
+			continue
+		}
+		entry.prefix = prefix
+		valid = append(valid, entry)
+	}
+	a.setWatchlist(valid)
+
+	err := a.saveWatchlist()
+	if err != nil {
+		log.Printf("Failed to save watchlist: %v", err)
+	}
+}
+
+// watchlistEntries returns a copy of the watchlist, sorted by value.
+func (a *API) watchlistEntries() []WatchlistEntry {
+	entries := slices.Clone(a.watchlist)
+	if entries == nil {
+		entries = []WatchlistEntry{}
+	}
+
+	return entries
+}
+
+// setWatchlist replaces the watchlist, invalidates cached responses that reflect the
+// previous one, and re-flags all loaded datasets.
+func (a *API) setWatchlist(entries []WatchlistEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Value < entries[j].Value
+	})
+	a.watchlist = entries
+	a.settingsVersion = time.Now().UnixNano()
+
+	for fileID, fileData := range a.files {
+		a.checkWatchlist(fileID, fileData)
+	}
+}
+
+// saveWatchlist writes the watchlist to its file, if one is configured.
+func (a *API) saveWatchlist() error {
+	if a.watchlistPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.watchlist, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding watchlist: %w", err)
+	}
+
+	return writeFileAtomic(a.watchlistPath, data)
+}
+
+// checkWatchlist flags the dataset with the watchlist entries its connections touch, apart
+// from suppressed ones, and logs each new hit.
+func (a *API) checkWatchlist(fileID string, fileData *FileData) {
+	if fileData.unloaded != nil {
+		return // Checked again once it is read back
+	}
+
+	previous := make(map[string]bool, len(fileData.watchlistHits))
+	for _, hit := range fileData.watchlistHits {
+		previous[hit.Value] = true
+	}
+
+	fileData.watchlistHits, fileData.suppressedHits = matchWatchlist(a.watchlist, fileData.Connections, a.suppressions)
+
+	for _, hit := range fileData.watchlistHits {
+		if !previous[hit.Value] {
+			log.Printf("Dataset %s touches watchlisted %s in %d connections", fileID, hit.Value, hit.Connections)
+		}
+	}
+}
+
+// matchWatchlist returns the watchlist entries touched by the connections, with the number of
+// matching connections and the matching hosts, and the number of hits that were suppressed.
+func matchWatchlist(entries []WatchlistEntry, connections []models.Connection, suppressions []Suppression) ([]WatchlistHit, int) {
+	if len(entries) == 0 {
+		return nil, 0
+	}
+
+	hits := make([]WatchlistHit, len(entries))
+	silenced := make([]int, len(entries))
+	hosts := make([]map[string]bool, len(entries))
+	for i, entry := range entries {
+		hits[i] = WatchlistHit{Value: entry.Value, Note: entry.Note, Hosts: []string{}}
+		hosts[i] = make(map[string]bool)
+	}
+
+	for _, conn := range connections {
+		origin, originErr := models.ParseHost(conn.OrigHost)
+		responder, responderErr := models.ParseHost(conn.RespHost)
+
+		for i, entry := range entries {
+			originHit := originErr == nil && entry.prefix.Contains(origin)
+			responderHit := responderErr == nil && entry.prefix.Contains(responder)
+			if !originHit && !responderHit {
+				continue
+			}
+			if connectionSuppressed(suppressions, watchlistRule, &conn) {
+				silenced[i]++
+
+				continue
+			}
+
+			hits[i].Connections++
+			if originHit {
+				hosts[i][conn.OrigHost] = true
+			}
+			if responderHit {
+				hosts[i][conn.RespHost] = true
+			}
+		}
+	}
+
+	matched := make([]WatchlistHit, 0, len(hits))
+	suppressed := 0
+	for i, hit := range hits {
+		if hit.Connections == 0 {
+			if silenced[i] > 0 {
+				suppressed++
+			}
+
+			continue
+		}
+		for host := range hosts[i] {
+			hit.Hosts = append(hit.Hosts, host)
+		}
+		sort.Strings(hit.Hosts)
+		hit.Hosts = hit.Hosts[:min(len(hit.Hosts), maxWatchlistHosts)]
+		matched = append(matched, hit)
+	}
+
+	return matched, suppressed
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newDemoServer returns an API with the demo dataset loaded and the mux serving its /api
+// routes.
+func newDemoServer(t *testing.T) (*API, *http.ServeMux) {
+	t.Helper()
+
+	api := NewAPI("")
+	_, err := api.LoadDemo(context.Background())
+	if err != nil {
+		t.Fatalf("loading demo dataset: %v", err)
+	}
+	mux := http.NewServeMux()
+	api.HandleAPI(mux)
+
+	return api, mux
+}
+
+// serve sends a request to the mux and returns its response.
+func serve(mux http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestWatchlistChangesETag(t *testing.T) {
+	_, mux := newDemoServer(t)
+
+	first := serve(mux, http.MethodGet, "/api/nodes", "", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /api/nodes: status %d, ETag %q", first.Code, etag)
+	}
+	revalidated := serve(mux, http.MethodGet, "/api/nodes", "", http.Header{"If-None-Match": {etag}})
+	if revalidated.Code != http.StatusNotModified {
+		t.Fatalf("unchanged revalidation: status %d, want %d", revalidated.Code, http.StatusNotModified)
+	}
+
+	added := serve(mux, http.MethodPost, "/api/watchlist", `{"value": "10.0.0.0/8"}`, nil)
+	if added.Code != http.StatusOK {
+		t.Fatalf("POST /api/watchlist: status %d: %s", added.Code, added.Body)
+	}
+	afterAdd := serve(mux, http.MethodGet, "/api/nodes", "", http.Header{"If-None-Match": {etag}})
+	if afterAdd.Code != http.StatusOK {
+		t.Errorf("revalidation after adding a watchlist entry: status %d, want %d", afterAdd.Code, http.StatusOK)
+	}
+	addedETag := afterAdd.Header().Get("ETag")
+	if addedETag == etag {
+		t.Errorf("ETag %q unchanged after adding a watchlist entry", etag)
+	}
+
+	deleted := serve(mux, http.MethodDelete, "/api/watchlist?value=10.0.0.0/8", "", nil)
+	if deleted.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/watchlist: status %d: %s", deleted.Code, deleted.Body)
+	}
+	afterDelete := serve(mux, http.MethodGet, "/api/nodes", "", http.Header{"If-None-Match": {addedETag}})
+	if afterDelete.Code != http.StatusOK || afterDelete.Header().Get("ETag") == addedETag {
+		t.Errorf("revalidation after deleting the watchlist entry: status %d, ETag %q", afterDelete.Code, afterDelete.Header().Get("ETag"))
+	}
+}
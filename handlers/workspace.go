@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	workspaceCookie    = "zeek_viz_workspace" // Cookie naming the workspace of a browser
+	datasetHeader      = "X-Zeek-Viz-Dataset" // Request header selecting the dataset a request reads
+	workspaceIDBytes   = 16                   // Random bytes of a workspace ID
+	workspaceTTL       = 30 * 24 * time.Hour  // Lifetime of workspace cookies and selections
+	maxWorkspaces      = 10000                // Selections kept at most; the least recently used are forgotten
+	workspaceKeyPrefix = "workspace:"         // Cache key prefix of workspace selections
+)
+
+// workspace is the dataset a browser selected. Browsers are given a workspace with the UI, so
+// analysts sharing a server each keep their own current dataset.
+type workspace struct {
+	fileID string
+	used   atomic.Int64 // When a request last read the selection (Unix seconds)
+}
+
+// WithWorkspace gives browsers loading the UI a workspace cookie unless they have one. Their
+// requests then switch and read the dataset of their workspace; clients without the cookie
+// share the server-wide selection.
+func (a *API) WithWorkspace(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if workspaceID(r) == "" {
+			random := make([]byte, workspaceIDBytes)
+			_, _ = rand.Read(random) // crypto/rand never fails on supported platforms
+			http.SetCookie(w, &http.Cookie{
+				Name:     workspaceCookie,
+				Value:    hex.EncodeToString(random),
+				Path:     a.cookiePath(),
+				MaxAge:   int(workspaceTTL.Seconds()),
+				Secure:   secureRequest(r),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode, // Sent when following links to the UI, so they keep the workspace
+			})
+		}
+		next(w, r)
+	}
+}
+
+// workspaceID returns the workspace of a request, or "" without a well-formed cookie.
+func workspaceID(r *http.Request) string {
+	cookie, err := r.Cookie(workspaceCookie)
+	if err != nil || len(cookie.Value) != 2*workspaceIDBytes {
+		return ""
+	}
+	_, err = hex.DecodeString(cookie.Value)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// currentDataset returns the file ID of the dataset a request reads when it names none in its
+// path: its file_id parameter, its X-Zeek-Viz-Dataset header, the selection of its
+// workspace, or else the server-wide selection. Named datasets are returned even when not
+// loaded, so handlers report them missing instead of reading another one. Callers must hold
+// a.mu, for reading.
+func (a *API) currentDataset(r *http.Request) string {
+	if fileID := r.URL.Query().Get("file_id"); fileID != "" && fileID != allDatasets {
+		return fileID
+	}
+	if fileID := r.Header.Get(datasetHeader); fileID != "" {
+		return fileID
+	}
+	if selected := a.workspaces[workspaceID(r)]; selected != nil && a.files[selected.fileID] != nil {
+		selected.used.Store(time.Now().Unix())
+
+		return selected.fileID
+	}
+
+	return a.currentFileID
+}
+
+// setCurrentDataset makes a dataset the current one of the request's workspace, or of the
+// server for clients without one, and shares the selection with the other instances. Callers
+// must hold a.mu.
+func (a *API) setCurrentDataset(r *http.Request, fileID string) {
+	id := workspaceID(r)
+	if id == "" {
+		a.currentFileID = fileID
+		a.publishCurrentFile()
+
+		return
+	}
+
+	a.setWorkspace(id, fileID)
+	a.publishWorkspace(id, fileID)
+}
+
+// setWorkspace records the selection of a workspace, forgetting the least recently used one
+// beyond maxWorkspaces. Callers must hold a.mu.
+func (a *API) setWorkspace(id, fileID string) {
+	if a.workspaces == nil {
+		a.workspaces = make(map[string]*workspace)
+	}
+	if _, exists := a.workspaces[id]; !exists && len(a.workspaces) >= maxWorkspaces {
+		oldest := ""
+		for other, selected := range a.workspaces {
+			if oldest == "" || selected.used.Load() < a.workspaces[oldest].used.Load() {
+				oldest = other
+			}
+		}
+		delete(a.workspaces, oldest)
+	}
+
+	selected := &workspace{fileID: fileID}
+	selected.used.Store(time.Now().Unix())
+	a.workspaces[id] = selected
+}
+
+// forgetWorkspaceSelections drops the workspace selections of a deleted dataset, so those
+// workspaces read the server-wide selection again. Callers must hold a.mu.
+func (a *API) forgetWorkspaceSelections(fileID string) {
+	for id, selected := range a.workspaces {
+		if selected.fileID == fileID {
+			delete(a.workspaces, id)
+		}
+	}
+}
+
+// publishWorkspace shares the selection of a workspace with the other instances.
+func (a *API) publishWorkspace(id, fileID string) {
+	if a.cache == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+	defer cancel()
+
+	err := a.cache.Set(ctx, workspaceKeyPrefix+id, []byte(fileID), workspaceTTL)
+	if err != nil {
+		log.Printf("Failed to share workspace selection: %v", err)
+	}
+}
+
+// loadWorkspace takes the selection a workspace made on any instance, when the dataset is
+// loaded here.
+func (a *API) loadWorkspace(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(ctx, sharedCacheTimeout)
+	defer cancel()
+
+	fileID, found, err := a.cache.Get(ctx, workspaceKeyPrefix+id)
+	if err != nil {
+		log.Printf("Failed to read shared workspace selection: %v", err)
+
+		return
+	}
+	if !found {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if selected := a.workspaces[id]; a.files[string(fileID)] != nil && (selected == nil || selected.fileID != string(fileID)) {
+		a.setWorkspace(id, string(fileID))
+	}
+}
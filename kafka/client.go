@@ -0,0 +1,378 @@
+// Package kafka consumes topics of Apache Kafka, to which Zeek publishes its logs with the
+// zeek-kafka plugin. It speaks the wire protocol over TCP and needs no client library; it
+// implements the few request versions a consumer tracking its offsets in a consumer group
+// needs, which brokers of Kafka 1.0 and later accept.
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	clientID       = "zeek-viz"
+	dialTimeout    = 10 * time.Second // Upper bound for connecting to a broker
+	requestTimeout = 30 * time.Second // Upper bound for one request, well above the fetch wait
+	maxResponse    = 256 << 20        // Bytes a response may take (256MiB)
+)
+
+// Request types, by API key.
+const (
+	apiFetch           int16 = 1
+	apiListOffsets     int16 = 2
+	apiMetadata        int16 = 3
+	apiOffsetCommit    int16 = 8
+	apiOffsetFetch     int16 = 9
+	apiFindCoordinator int16 = 10
+)
+
+var (
+	errNoBrokers        = errors.New("no Kafka brokers given")
+	errNoBrokerAnswered = errors.New("no Kafka broker answered")
+	errUnknownBroker    = errors.New("broker missing from the cluster metadata")
+	errCorrelation      = errors.New("Kafka response doesn't match its request")
+	errResponseTooLarge = errors.New("Kafka response exceeds 256MiB")
+	errMissingTopic     = errors.New("Kafka response misses the requested topic")
+)
+
+// Error codes brokers answer with that consumers handle.
+const (
+	codeNone                 int16 = 0
+	codeOffsetOutOfRange     int16 = 1
+	codeUnknownTopic         int16 = 3
+	codeLeaderNotAvailable   int16 = 5
+	codeNotLeader            int16 = 6
+	codeCoordinatorLoading   int16 = 14
+	codeCoordinatorNotActive int16 = 15
+	codeNotCoordinator       int16 = 16
+)
+
+// brokerError is an error code a broker answered with.
+type brokerError struct {
+	Code int16
+}
+
+// Error names the well-known codes and reports the others by number.
+func (e *brokerError) Error() string {
+	names := map[int16]string{
+		codeOffsetOutOfRange:     "offset out of range",
+		codeUnknownTopic:         "unknown topic or partition",
+		codeLeaderNotAvailable:   "leader not available",
+		codeNotLeader:            "not the leader of the partition",
+		7:                        "request timed out",
+		codeCoordinatorLoading:   "coordinator loading",
+		codeCoordinatorNotActive: "coordinator not available",
+		codeNotCoordinator:       "not the coordinator of the group",
+		22:                       "illegal generation; the group has active members",
+		25:                       "unknown member; the group has active members",
+		29:                       "not authorized to read the topic",
+		30:                       "not authorized to use the group",
+		35:                       "request version not supported; the broker is older than Kafka 1.0",
+	}
+	if name, known := names[e.Code]; known {
+		return "Kafka broker answered " + name
+	}
+
+	return "Kafka broker answered error " + strconv.Itoa(int(e.Code))
+}
+
+// codeError returns the error of a code, nil for codeNone.
+func codeError(code int16) error {
+	if code == codeNone {
+		return nil
+	}
+
+	return &brokerError{Code: code}
+}
+
+// hasCode reports whether err is a broker error with one of the codes.
+func hasCode(err error, codes ...int16) bool {
+	var answered *brokerError
+	if !errors.As(err, &answered) {
+		return false
+	}
+	for _, code := range codes {
+		if answered.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// broker is a connection to one broker, opened on first use and again after a failure.
+// Requests on it are sent one at a time.
+type broker struct {
+	addr string
+
+	mu          sync.Mutex
+	conn        net.Conn
+	correlation int32
+}
+
+// request sends a request and returns a decoder of the response body.
+func (b *broker) request(ctx context.Context, apiKey, version int16, body *encoder) (*decoder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	response, err := b.roundTrip(ctx, apiKey, version, body.buf)
+	if err != nil {
+		if b.conn != nil {
+			b.conn.Close()
+			b.conn = nil
+		}
+
+		return nil, fmt.Errorf("%s: %w", b.addr, err)
+	}
+
+	return &decoder{buf: response}, nil
+}
+
+// roundTrip writes a request and reads its response. Callers must hold mu.
+func (b *broker) roundTrip(ctx context.Context, apiKey, version int16, body []byte) ([]byte, error) {
+	if b.conn == nil {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", b.addr)
+		if err != nil {
+			return nil, fmt.Errorf("connecting: %w", err)
+		}
+		b.conn = conn
+	}
+
+	deadline := time.Now().Add(requestTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = b.conn.SetDeadline(deadline) // Connections of the net package support deadlines
+	stop := context.AfterFunc(ctx, func() { _ = b.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	b.correlation++
+	header := &encoder{}
+	header.int32(0) // Size, set below
+	header.int16(apiKey)
+	header.int16(version)
+	header.int32(b.correlation)
+	header.string(clientID)
+	request := append(header.buf, body...)
+	binary.BigEndian.PutUint32(request, uint32(len(request)-4)) //nolint:gosec,mnd // Size excludes itself
+
+	_, err := b.conn.Write(request)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", errors.Join(ctx.Err(), err))
+	}
+
+	var size [4]byte
+	_, err = io.ReadFull(b.conn, size[:])
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", errors.Join(ctx.Err(), err))
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	switch {
+	case n > maxResponse:
+		return nil, errResponseTooLarge
+	case n < 4: //nolint:mnd // Correlation ID
+		return nil, errShortResponse
+	}
+	response := make([]byte, n)
+	_, err = io.ReadFull(b.conn, response)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", errors.Join(ctx.Err(), err))
+	}
+	if int32(binary.BigEndian.Uint32(response)) != b.correlation { //nolint:gosec // Two's complement
+		return nil, errCorrelation
+	}
+
+	return response[4:], nil
+}
+
+// close closes the connection, if it is open.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+// cluster tracks the brokers of a cluster, learnt from the metadata the bootstrap brokers
+// report.
+type cluster struct {
+	seeds []*broker
+
+	mu      sync.Mutex
+	brokers map[int32]*broker // By node ID
+}
+
+// newCluster returns the cluster of the bootstrap brokers, given as host:port.
+func newCluster(addrs []string) (*cluster, error) {
+	if len(addrs) == 0 {
+		return nil, errNoBrokers
+	}
+	c := &cluster{brokers: make(map[int32]*broker)}
+	for _, addr := range addrs {
+		c.seeds = append(c.seeds, &broker{addr: addr})
+	}
+
+	return c, nil
+}
+
+// any sends a request to the first broker that answers, trying the known brokers before the
+// bootstrap ones.
+func (c *cluster) any(ctx context.Context, apiKey, version int16, body *encoder) (*decoder, error) {
+	c.mu.Lock()
+	candidates := make([]*broker, 0, len(c.brokers)+len(c.seeds))
+	for _, b := range c.brokers {
+		candidates = append(candidates, b)
+	}
+	candidates = append(candidates, c.seeds...)
+	c.mu.Unlock()
+
+	var failures error
+	for _, b := range candidates {
+		response, err := b.request(ctx, apiKey, version, body)
+		if err == nil {
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		failures = errors.Join(failures, err)
+	}
+
+	return nil, fmt.Errorf("%w: %w", errNoBrokerAnswered, failures)
+}
+
+// broker returns the broker with a node ID.
+func (c *cluster) broker(id int32) (*broker, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, exists := c.brokers[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: node %d", errUnknownBroker, id)
+	}
+
+	return b, nil
+}
+
+// setBroker records the address of a node, replacing its connection if it moved.
+func (c *cluster) setBroker(id int32, addr string) *broker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, exists := c.brokers[id]
+	if exists && b.addr == addr {
+		return b
+	}
+	if exists {
+		b.close()
+	}
+	b = &broker{addr: addr}
+	c.brokers[id] = b
+
+	return b
+}
+
+// metadata returns the leader of each partition of a topic, -1 while a partition has none.
+func (c *cluster) metadata(ctx context.Context, topic string) (map[int32]int32, error) {
+	request := &encoder{}
+	request.array(1)
+	request.string(topic)
+	d, err := c.any(ctx, apiMetadata, 1, request)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	for range d.array() {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		_ = d.string() // Rack
+		if d.err == nil {
+			c.setBroker(id, net.JoinHostPort(host, strconv.Itoa(int(port))))
+		}
+	}
+	_ = d.int32() // Controller
+
+	leaders := make(map[int32]int32)
+	var topicErr error
+	answered := false
+	for range d.array() {
+		code := d.int16()
+		name := d.string()
+		_ = d.int8() // Internal
+		for range d.array() {
+			_ = d.int16() // Error of the partition, such as a missing leader
+			partition := d.int32()
+			leader := d.int32()
+			for range d.array() {
+				_ = d.int32() // Replica
+			}
+			for range d.array() {
+				_ = d.int32() // In-sync replica
+			}
+			if name == topic {
+				leaders[partition] = leader
+			}
+		}
+		if name == topic {
+			answered, topicErr = true, codeError(code)
+		}
+	}
+	switch {
+	case d.err != nil:
+		return nil, fmt.Errorf("reading metadata: %w", d.err)
+	case !answered:
+		return nil, fmt.Errorf("reading metadata: %w", errMissingTopic)
+	case topicErr != nil:
+		return nil, fmt.Errorf("topic %s: %w", topic, topicErr)
+	}
+
+	return leaders, nil
+}
+
+// coordinator returns the broker coordinating a consumer group.
+func (c *cluster) coordinator(ctx context.Context, group string) (*broker, error) {
+	request := &encoder{}
+	request.string(group)
+	d, err := c.any(ctx, apiFindCoordinator, 0, request)
+	if err != nil {
+		return nil, fmt.Errorf("finding coordinator of group %s: %w", group, err)
+	}
+
+	code := d.int16()
+	id := d.int32()
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return nil, fmt.Errorf("finding coordinator of group %s: %w", group, d.err)
+	}
+	if err := codeError(code); err != nil {
+		return nil, fmt.Errorf("finding coordinator of group %s: %w", group, err)
+	}
+
+	return c.setBroker(id, net.JoinHostPort(host, strconv.Itoa(int(port)))), nil
+}
+
+// close closes the connections to all brokers.
+func (c *cluster) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, b := range c.brokers {
+		b.close()
+	}
+	for _, b := range c.seeds {
+		b.close()
+	}
+}
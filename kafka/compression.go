@@ -0,0 +1,310 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"zeek-viz/kafka/internal/zstd"
+)
+
+// Compression codecs of the batch attributes.
+const (
+	codecNone   = 0
+	codecGzip   = 1
+	codecSnappy = 2
+	codecLZ4    = 3
+	codecZstd   = 4
+)
+
+const (
+	xerialHeader    = 16         // Bytes of the magic, version, and compatible version of xerial framing
+	lz4Magic        = 0x184d2204 // First bytes of an LZ4 frame, little-endian
+	lz4Uncompressed = 1 << 31    // Block size bit of blocks stored uncompressed
+	lz4MinMatch     = 4          // Bytes a match copies beyond its length field
+	lz4LengthMask   = 0x0f       // Literal or match length bits of a sequence token
+)
+
+// xerialMagic starts snappy data in the xerial framing of Kafka's Java producer.
+var xerialMagic = []byte("\x82SNAPPY\x00")
+
+var (
+	errCompression = errors.New("unsupported compression codec; use gzip, snappy, lz4, zstd, or none")
+	errSnappy      = errors.New("invalid snappy data")
+	errLZ4         = errors.New("invalid lz4 frame")
+	errTooLarge    = errors.New("batch expands beyond the fetch limit")
+)
+
+// uncompress returns the records of a batch compressed with codec, up to maxUncompressed bytes.
+func uncompress(records []byte, codec int16) ([]byte, error) {
+	var uncompressed []byte
+	var err error
+	switch codec {
+	case codecNone:
+		return records, nil
+	case codecGzip:
+		var reader *gzip.Reader
+		reader, err = gzip.NewReader(bytes.NewReader(records))
+		if err == nil {
+			uncompressed, err = readLimited(reader)
+		}
+	case codecSnappy:
+		uncompressed, err = unsnappy(records)
+	case codecLZ4:
+		uncompressed, err = unlz4(records)
+	case codecZstd:
+		uncompressed, err = readLimited(zstd.NewReader(bytes.NewReader(records)))
+	default:
+		return nil, fmt.Errorf("%w (codec %d)", errCompression, codec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing batch: %w", err)
+	}
+
+	return uncompressed, nil
+}
+
+// readLimited reads a decompressing reader to its end, failing past maxUncompressed bytes.
+func readLimited(reader io.Reader) ([]byte, error) {
+	uncompressed, err := io.ReadAll(io.LimitReader(reader, maxUncompressed+1))
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Wrapped by uncompress
+	}
+	if len(uncompressed) > maxUncompressed {
+		return nil, errTooLarge
+	}
+
+	return uncompressed, nil
+}
+
+// unsnappy decodes snappy data in the xerial framing Java producers write, a header and
+// blocks each prefixed by its length, or as one raw block, as librdkafka writes it.
+func unsnappy(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, xerialMagic) {
+		return snappyBlock(nil, data)
+	}
+	if len(data) < xerialHeader {
+		return nil, errSnappy
+	}
+
+	var uncompressed []byte
+	for data = data[xerialHeader:]; len(data) > 0; {
+		if len(data) < 4 { //nolint:mnd // Block length
+			return nil, errSnappy
+		}
+		length := binary.BigEndian.Uint32(data)
+		if uint64(length) > uint64(len(data)-4) { //nolint:mnd // After the block length
+			return nil, errSnappy
+		}
+		var err error
+		uncompressed, err = snappyBlock(uncompressed, data[4:4+length])
+		if err != nil {
+			return nil, err
+		}
+		data = data[4+length:]
+	}
+
+	return uncompressed, nil
+}
+
+// snappyBlock appends the decoding of a raw snappy block to dst: the uncompressed length as a
+// uvarint, then literals and copies of earlier output.
+func snappyBlock(dst, block []byte) ([]byte, error) {
+	length, n := binary.Uvarint(block)
+	if n <= 0 || length > maxUncompressed || uint64(len(dst))+length > maxUncompressed {
+		return nil, errSnappy
+	}
+	start := len(dst)
+	block = block[n:]
+
+	for len(block) > 0 {
+		tag := block[0]
+		var size, offset int
+		switch tag & 0x03 { //nolint:mnd // Element type
+		case 0x00: // Literal, its length less one in the tag or the 1 to 4 bytes after it
+			size = int(tag>>2) + 1
+			block = block[1:]
+			if extra := size - 60; extra > 0 { //nolint:mnd // Longer literals
+				if len(block) < extra {
+					return nil, errSnappy
+				}
+				size = 1
+				for i := extra - 1; i >= 0; i-- {
+					size += int(block[i]) << (8 * i) //nolint:mnd // Little-endian bytes
+				}
+				block = block[extra:]
+			}
+			if size > len(block) || uint64(len(dst)-start+size) > length {
+				return nil, errSnappy
+			}
+			dst = append(dst, block[:size]...)
+			block = block[size:]
+
+			continue
+		case 0x01: // Copy of 4 to 11 bytes within 2 KiB
+			if len(block) < 2 { //nolint:mnd // Tag and offset byte
+				return nil, errSnappy
+			}
+			size = int(tag>>2&0x07) + 4               //nolint:mnd // Length bits
+			offset = int(tag&0xe0)<<3 | int(block[1]) //nolint:mnd // High offset bits
+			block = block[2:]
+		case 0x02: // Copy with a 2-byte offset
+			if len(block) < 3 { //nolint:mnd // Tag and offset
+				return nil, errSnappy
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(block[1:]))
+			block = block[3:]
+		default: // Copy with a 4-byte offset
+			if len(block) < 5 { //nolint:mnd // Tag and offset
+				return nil, errSnappy
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(block[1:]))
+			block = block[5:]
+		}
+
+		if offset <= 0 || offset > len(dst)-start || uint64(len(dst)-start+size) > length {
+			return nil, errSnappy
+		}
+		dst = appendCopy(dst, offset, size)
+	}
+	if uint64(len(dst)-start) != length {
+		return nil, errSnappy
+	}
+
+	return dst, nil
+}
+
+// appendCopy appends size bytes copied from offset bytes back in dst, which may overlap the
+// bytes appended.
+func appendCopy(dst []byte, offset, size int) []byte {
+	from := len(dst) - offset
+	for i := range size {
+		dst = append(dst, dst[from+i])
+	}
+
+	return dst
+}
+
+// unlz4 decodes an LZ4 frame, as Kafka producers write for codec 3: a header, compressed or
+// stored blocks, and an end mark. Checksums are skipped; the batch checksum covers the frame.
+func unlz4(data []byte) ([]byte, error) {
+	const (
+		blockChecksum   = 0x10 // Frame flag of a checksum after each block
+		contentSize     = 0x08 // Frame flag of the uncompressed size in the header
+		contentChecksum = 0x04 // Frame flag of a checksum after the end mark
+		dictionaryID    = 0x01 // Frame flag of a dictionary ID in the header
+	)
+
+	if len(data) < 7 || binary.LittleEndian.Uint32(data) != lz4Magic { //nolint:mnd // Magic, flags, block size, and header checksum
+		return nil, errLZ4
+	}
+	flags := data[4]
+	header := 7
+	if flags&contentSize != 0 {
+		header += 8
+	}
+	if flags&dictionaryID != 0 {
+		header += 4
+	}
+	if len(data) < header {
+		return nil, errLZ4
+	}
+	data = data[header:]
+
+	var uncompressed []byte
+	for {
+		if len(data) < 4 { //nolint:mnd // Block size
+			return nil, errLZ4
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if size == 0 {
+			break // End mark
+		}
+		stored := size&lz4Uncompressed != 0
+		size &^= lz4Uncompressed
+		if uint64(size) > uint64(len(data)) {
+			return nil, errLZ4
+		}
+
+		var err error
+		if stored {
+			uncompressed = append(uncompressed, data[:size]...)
+		} else {
+			uncompressed, err = lz4Block(uncompressed, data[:size])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(uncompressed) > maxUncompressed {
+			return nil, errTooLarge
+		}
+		data = data[size:]
+		if flags&blockChecksum != 0 {
+			if len(data) < 4 { //nolint:mnd // Block checksum
+				return nil, errLZ4
+			}
+			data = data[4:]
+		}
+	}
+	if flags&contentChecksum != 0 && len(data) < 4 { //nolint:mnd // Content checksum
+		return nil, errLZ4
+	}
+
+	return uncompressed, nil
+}
+
+// lz4Block appends the decoding of an LZ4 block to dst. Matches may reach into earlier
+// blocks of the frame, which dst holds.
+func lz4Block(dst, block []byte) ([]byte, error) {
+	for len(block) > 0 {
+		token := block[0]
+		block = block[1:]
+
+		literals, rest, ok := lz4Length(int(token>>4), block)
+		if !ok || literals > len(rest) {
+			return nil, errLZ4
+		}
+		dst = append(dst, rest[:literals]...)
+		block = rest[literals:]
+		if len(block) == 0 {
+			break // The last sequence has only literals
+		}
+
+		if len(block) < 2 { //nolint:mnd // Match offset
+			return nil, errLZ4
+		}
+		offset := int(binary.LittleEndian.Uint16(block))
+		match, rest, ok := lz4Length(int(token&lz4LengthMask), block[2:])
+		if !ok || offset == 0 || offset > len(dst) || len(dst)+match > maxUncompressed {
+			return nil, errLZ4
+		}
+		dst = appendCopy(dst, offset, match+lz4MinMatch)
+		block = rest
+	}
+
+	return dst, nil
+}
+
+// lz4Length completes a literal or match length of a sequence token: a nibble of 15 is
+// followed by bytes added to it, up to one below 255.
+func lz4Length(length int, block []byte) (int, []byte, bool) {
+	if length != lz4LengthMask {
+		return length, block, true
+	}
+	for len(block) > 0 && length <= maxUncompressed {
+		extra := block[0]
+		block = block[1:]
+		length += int(extra)
+		if extra != 0xff { //nolint:mnd // More length bytes follow 255
+			return length, block, true
+		}
+	}
+
+	return 0, nil, false
+}
@@ -0,0 +1,485 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	fetchWait         = 500 * time.Millisecond // How long a broker holds a fetch while no messages arrive
+	fetchMaxBytes     = 16 << 20               // Bytes one fetch returns at most, across partitions
+	partitionMaxBytes = 2 << 20                // Bytes per partition, unless its next batch is larger
+	offsetEarliest    = -2                     // ListOffsets timestamp of the first offset kept
+	offsetLatest      = -1                     // ListOffsets timestamp of the offset after the last message
+	noOffset          = -1                     // Committed offset of partitions the group never read
+)
+
+var (
+	errNoPartitions = errors.New("topic has no partitions")
+	errNoLeaders    = errors.New("no partition of the topic has a leader")
+)
+
+// Message is a message of a topic.
+type Message struct {
+	Partition int32
+	Offset    int64
+	Value     []byte
+}
+
+// Consumer reads every partition of a topic, from the offsets committed for a consumer group
+// on. It assigns itself all partitions instead of joining the group, so the group must not be
+// shared with other consumers. A Consumer is used by one goroutine at a time.
+type Consumer struct {
+	cluster     *cluster
+	topic       string
+	group       string
+	fromStart   bool              // Partitions the group never read start at their first message rather than the next one
+	partitions  []*partition      // Sorted by ID
+	coordinator *broker           // Broker the offsets of the group are committed to; nil until found
+	stale       bool              // Leaders moved; metadata is read again before the next fetch
+	rotation    int               // Partition asked for first in the next fetch, so none is starved
+	leaders     map[int32][]int32 // Partitions by leader, as of the last metadata
+}
+
+// partition is the read position in one partition.
+type partition struct {
+	id        int32
+	leader    int32 // -1 while the partition has none
+	offset    int64 // Next offset to read
+	committed int64 // Offset last committed, noOffset before the first commit
+	end       int64 // High watermark the last fetch reported
+}
+
+// NewConsumer connects to the cluster of the bootstrap brokers, given as host:port, and
+// positions a consumer of the topic at the offsets committed for group. Partitions the group
+// never read start after their last message, or at their first one when fromStart is set.
+func NewConsumer(ctx context.Context, brokers []string, topic, group string, fromStart bool) (*Consumer, error) {
+	cluster, err := newCluster(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Consumer{cluster: cluster, topic: topic, group: group, fromStart: fromStart}
+	err = c.refresh(ctx)
+	if err != nil {
+		cluster.close()
+
+		return nil, err
+	}
+	if len(c.partitions) == 0 {
+		cluster.close()
+
+		return nil, fmt.Errorf("topic %s: %w", topic, errNoPartitions)
+	}
+
+	return c, nil
+}
+
+// Partitions returns the number of partitions of the topic.
+func (c *Consumer) Partitions() int {
+	return len(c.partitions)
+}
+
+// Lag returns the number of messages in the topic not read yet, as of the last fetch.
+func (c *Consumer) Lag() int64 {
+	var lag int64
+	for _, p := range c.partitions {
+		lag += max(0, p.end-p.offset)
+	}
+
+	return lag
+}
+
+// Poll returns the messages published since the last call, waiting briefly for new ones when
+// there are none. It returns at most a few megabytes, so a slow reader lags behind the topic
+// instead of buffering it. Messages read before a failure are returned with the error.
+func (c *Consumer) Poll(ctx context.Context) ([]Message, error) {
+	if c.stale {
+		err := c.refresh(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var messages []Message
+	var failures error
+	fetched := false
+	for leader, ids := range c.leaders {
+		if leader < 0 {
+			c.stale = true
+
+			continue
+		}
+		read, err := c.fetch(ctx, leader, ids)
+		messages = append(messages, read...)
+		failures = errors.Join(failures, err)
+		fetched = true
+	}
+	c.rotation++
+	if !fetched && failures == nil {
+		failures = fmt.Errorf("topic %s: %w", c.topic, errNoLeaders)
+	}
+
+	return messages, failures
+}
+
+// Commit records the offsets read so far for the group, so a restarted consumer continues
+// where this one stopped.
+func (c *Consumer) Commit(ctx context.Context) error {
+	changed := make([]*partition, 0, len(c.partitions))
+	for _, p := range c.partitions {
+		if p.offset != p.committed {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	coordinator, err := c.groupCoordinator(ctx)
+	if err != nil {
+		return err
+	}
+
+	request := &encoder{}
+	request.string(c.group)
+	request.int32(-1)  // Generation of consumers outside the group
+	request.string("") // Member ID of consumers outside the group
+	request.int64(-1)  // Retention of the broker
+	request.array(1)
+	request.string(c.topic)
+	request.array(len(changed))
+	for _, p := range changed {
+		request.int32(p.id)
+		request.int64(p.offset)
+		request.nullString()
+	}
+	d, err := coordinator.request(ctx, apiOffsetCommit, 2, request) //nolint:mnd // Version
+	if err != nil {
+		c.coordinator = nil
+
+		return fmt.Errorf("committing offsets of group %s: %w", c.group, err)
+	}
+
+	offsets := make(map[int32]int64, len(changed))
+	for _, p := range changed {
+		offsets[p.id] = p.offset
+	}
+	var failures error
+	for range d.array() {
+		_ = d.string() // Topic
+		for range d.array() {
+			id := d.int32()
+			err := codeError(d.int16())
+			if err == nil {
+				c.partition(id).committed = offsets[id]
+
+				continue
+			}
+			if hasCode(err, codeNotCoordinator, codeCoordinatorNotActive, codeCoordinatorLoading) {
+				c.coordinator = nil
+			}
+			failures = errors.Join(failures, fmt.Errorf("committing offset of partition %d: %w", id, err))
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("committing offsets of group %s: %w", c.group, d.err)
+	}
+
+	return failures
+}
+
+// Close closes the connections to the brokers.
+func (c *Consumer) Close() {
+	c.cluster.close()
+}
+
+// refresh reads which broker leads each partition, positioning partitions seen for the first
+// time, such as those added to the topic, at the group's committed offsets.
+func (c *Consumer) refresh(ctx context.Context) error {
+	leaders, err := c.cluster.metadata(ctx, c.topic)
+	if err != nil {
+		return err
+	}
+
+	var added []*partition
+	for id, leader := range leaders {
+		p := c.partition(id)
+		if p == nil {
+			p = &partition{id: id, committed: noOffset}
+			added = append(added, p)
+		}
+		p.leader = leader
+	}
+	if len(added) > 0 {
+		err = c.position(ctx, added)
+		if err != nil {
+			return err
+		}
+		c.partitions = append(c.partitions, added...)
+		slices.SortFunc(c.partitions, func(a, b *partition) int { return int(a.id - b.id) })
+	}
+
+	c.leaders = make(map[int32][]int32)
+	for _, p := range c.partitions {
+		c.leaders[p.leader] = append(c.leaders[p.leader], p.id)
+	}
+	c.stale = false
+
+	return nil
+}
+
+// position sets the offsets of new partitions to those committed for the group, or to the
+// start or end of the partition when the group has none.
+func (c *Consumer) position(ctx context.Context, partitions []*partition) error {
+	committed, err := c.committedOffsets(ctx, partitions)
+	if err != nil {
+		return err
+	}
+
+	timestamp := int64(offsetLatest)
+	if c.fromStart {
+		timestamp = offsetEarliest
+	}
+	var unread []*partition
+	for _, p := range partitions {
+		offset, exists := committed[p.id]
+		if !exists || offset < 0 {
+			unread = append(unread, p)
+
+			continue
+		}
+		p.offset, p.committed = offset, offset
+	}
+
+	return c.reset(ctx, unread, timestamp)
+}
+
+// reset moves partitions to their start (offsetEarliest) or end (offsetLatest).
+func (c *Consumer) reset(ctx context.Context, partitions []*partition, timestamp int64) error {
+	byLeader := make(map[int32][]*partition)
+	for _, p := range partitions {
+		byLeader[p.leader] = append(byLeader[p.leader], p)
+	}
+
+	for leader, led := range byLeader {
+		offsets, err := c.listOffsets(ctx, leader, led, timestamp)
+		if err != nil {
+			return err
+		}
+		for _, p := range led {
+			p.offset = offsets[p.id]
+		}
+	}
+
+	return nil
+}
+
+// committedOffsets returns the offsets committed for the group, noOffset for partitions it
+// never read.
+func (c *Consumer) committedOffsets(ctx context.Context, partitions []*partition) (map[int32]int64, error) {
+	coordinator, err := c.groupCoordinator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &encoder{}
+	request.string(c.group)
+	request.array(1)
+	request.string(c.topic)
+	request.array(len(partitions))
+	for _, p := range partitions {
+		request.int32(p.id)
+	}
+	d, err := coordinator.request(ctx, apiOffsetFetch, 1, request)
+	if err != nil {
+		c.coordinator = nil
+
+		return nil, fmt.Errorf("reading offsets of group %s: %w", c.group, err)
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	var failures error
+	for range d.array() {
+		_ = d.string() // Topic
+		for range d.array() {
+			id := d.int32()
+			offset := d.int64()
+			_ = d.string() // Metadata
+			err := codeError(d.int16())
+			if err != nil {
+				failures = errors.Join(failures, fmt.Errorf("reading offset of partition %d: %w", id, err))
+			}
+			offsets[id] = offset
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("reading offsets of group %s: %w", c.group, d.err)
+	}
+	if failures != nil {
+		c.coordinator = nil
+
+		return nil, failures
+	}
+
+	return offsets, nil
+}
+
+// listOffsets returns the first offset (offsetEarliest) or the offset after the last message
+// (offsetLatest) of partitions led by the same broker.
+func (c *Consumer) listOffsets(ctx context.Context, leader int32, partitions []*partition, timestamp int64) (map[int32]int64, error) {
+	b, err := c.cluster.broker(leader)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &encoder{}
+	request.int32(-1) // Replica ID of consumers
+	request.array(1)
+	request.string(c.topic)
+	request.array(len(partitions))
+	for _, p := range partitions {
+		request.int32(p.id)
+		request.int64(timestamp)
+	}
+	d, err := b.request(ctx, apiListOffsets, 1, request)
+	if err != nil {
+		return nil, fmt.Errorf("listing offsets of %s: %w", c.topic, err)
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	var failures error
+	for range d.array() {
+		_ = d.string() // Topic
+		for range d.array() {
+			id := d.int32()
+			err := codeError(d.int16())
+			_ = d.int64() // Timestamp
+			offsets[id] = d.int64()
+			if err != nil {
+				failures = errors.Join(failures, fmt.Errorf("listing offsets of partition %d: %w", id, err))
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("listing offsets of %s: %w", c.topic, d.err)
+	}
+	if failures != nil {
+		c.stale = true
+
+		return nil, failures
+	}
+
+	return offsets, nil
+}
+
+// fetch reads the messages after the current offsets of partitions led by the same broker. A
+// partition whose offset is no longer kept, because retention deleted its messages, restarts
+// at its first message.
+func (c *Consumer) fetch(ctx context.Context, leader int32, ids []int32) ([]Message, error) {
+	b, err := c.cluster.broker(leader)
+	if err != nil {
+		c.stale = true
+
+		return nil, err
+	}
+
+	start := c.rotation % len(ids)
+	ordered := append(slices.Clone(ids[start:]), ids[:start]...)
+	request := &encoder{}
+	request.int32(-1) // Replica ID of consumers
+	request.int32(int32(fetchWait / time.Millisecond))
+	request.int32(1) // Answer once a byte is there
+	request.int32(fetchMaxBytes)
+	request.int8(0) // Read uncommitted
+	request.array(1)
+	request.string(c.topic)
+	request.array(len(ordered))
+	for _, id := range ordered {
+		request.int32(id)
+		request.int64(c.partition(id).offset)
+		request.int32(partitionMaxBytes)
+	}
+	d, err := b.request(ctx, apiFetch, 4, request) //nolint:mnd // Version
+	if err != nil {
+		c.stale = true
+
+		return nil, fmt.Errorf("fetching %s: %w", c.topic, err)
+	}
+
+	var messages []Message
+	var failures, outOfRange []*partition
+	var errs error
+	_ = d.int32() // Throttle time
+	for range d.array() {
+		_ = d.string() // Topic
+		for range d.array() {
+			p := c.partition(d.int32())
+			code := d.int16()
+			end := d.int64()
+			_ = d.int64() // Last stable offset
+			for range d.array() {
+				_ = d.int64() // Producer of an aborted transaction
+				_ = d.int64() // First offset of the transaction
+			}
+			set := d.bytes()
+			if d.err != nil || p == nil {
+				continue
+			}
+
+			switch err := codeError(code); {
+			case err == nil:
+				p.end = end
+				read, next, err := readRecords(set, p.id, p.offset)
+				messages = append(messages, read...)
+				p.offset = next
+				if err != nil {
+					errs = errors.Join(errs, fmt.Errorf("partition %d: %w", p.id, err))
+				}
+			case hasCode(err, codeOffsetOutOfRange):
+				outOfRange = append(outOfRange, p)
+			default:
+				failures = append(failures, p)
+				errs = errors.Join(errs, fmt.Errorf("partition %d: %w", p.id, err))
+			}
+		}
+	}
+	if d.err != nil {
+		return messages, fmt.Errorf("fetching %s: %w", c.topic, d.err)
+	}
+	if len(failures) > 0 {
+		c.stale = true
+	}
+	if len(outOfRange) > 0 {
+		errs = errors.Join(errs, c.reset(ctx, outOfRange, offsetEarliest))
+	}
+
+	return messages, errs
+}
+
+// groupCoordinator returns the broker coordinating the group, finding it if necessary.
+func (c *Consumer) groupCoordinator(ctx context.Context) (*broker, error) {
+	if c.coordinator == nil {
+		coordinator, err := c.cluster.coordinator(ctx, c.group)
+		if err != nil {
+			return nil, err
+		}
+		c.coordinator = coordinator
+	}
+
+	return c.coordinator, nil
+}
+
+// partition returns the partition with an ID, nil for unknown ones.
+func (c *Consumer) partition(id int32) *partition {
+	for _, p := range c.partitions {
+		if p.id == id {
+			return p
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errShortResponse = errors.New("truncated Kafka response")
+
+// encoder builds the body of a request in the Kafka wire format, big-endian with
+// length-prefixed strings and arrays.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s))) //nolint:gosec // Names are far below 32KiB
+	e.buf = append(e.buf, s...)
+}
+
+// nullString writes the null string.
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+// array writes the length of an array, whose elements follow.
+func (e *encoder) array(n int) {
+	e.int32(int32(n)) //nolint:gosec // Arrays hold a few partitions
+}
+
+// decoder reads a response in the Kafka wire format. The first failure is kept in err, and
+// reads after it return zero values, so a response is decoded in full before err is checked.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// take returns the next n bytes.
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errShortResponse
+
+		return nil
+	}
+	taken := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return taken
+}
+
+func (d *decoder) int8() int8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+
+	return int8(b[0])
+}
+
+func (d *decoder) int16() int16 {
+	b := d.take(2) //nolint:mnd // Bytes of an int16
+	if b == nil {
+		return 0
+	}
+
+	return int16(binary.BigEndian.Uint16(b)) //nolint:gosec // Two's complement
+}
+
+func (d *decoder) int32() int32 {
+	b := d.take(4) //nolint:mnd // Bytes of an int32
+	if b == nil {
+		return 0
+	}
+
+	return int32(binary.BigEndian.Uint32(b)) //nolint:gosec // Two's complement
+}
+
+func (d *decoder) int64() int64 {
+	b := d.take(8) //nolint:mnd // Bytes of an int64
+	if b == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b)) //nolint:gosec // Two's complement
+}
+
+// string reads a string, returning the null string as "".
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+
+	return string(d.take(int(n)))
+}
+
+// bytes reads a byte array, returning the null array as nil.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+
+	return d.take(int(n))
+}
+
+// array reads the length of an array, whose elements follow. The null array is empty.
+func (d *decoder) array() int {
+	n := int(d.int32())
+	if n < 0 {
+		return 0
+	}
+	if n > len(d.buf) { // Every element takes at least a byte
+		d.err = errShortResponse
+
+		return 0
+	}
+
+	return n
+}
+
+// varint reads a zigzag-encoded variable-length integer, as records use.
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errShortResponse
+
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+// varBytes reads a byte array with a variable-length size, returning the null array as nil.
+func (d *decoder) varBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+
+	return d.take(int(n))
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	batchPrefix     = 12          // Bytes of the base offset and length preceding a record batch
+	batchHeader     = 49          // Bytes of a record batch after its length, up to its records
+	magicBatch      = 2           // Message format of record batches (Kafka 0.11 and later)
+	codecMask       = 0x07        // Bits of the batch attributes naming the compression codec
+	controlBatch    = 0x20        // Batch attribute of transaction markers
+	maxUncompressed = maxResponse // Bytes a compressed batch may expand to
+)
+
+var (
+	errOldFormat = errors.New("topic uses the message format of Kafka before 0.11")
+	errCorrupt   = errors.New("record batch checksum mismatch")
+)
+
+// readRecords decodes the record batches of a fetched record set, returning the messages at
+// offset or after, and the offset following the last batch read. A batch cut off at the end
+// of the set is left for the next fetch.
+func readRecords(set []byte, partition int32, offset int64) ([]Message, int64, error) {
+	var messages []Message
+	for len(set) >= batchPrefix {
+		baseOffset := int64(binary.BigEndian.Uint64(set))      //nolint:gosec // Two's complement
+		length := int(int32(binary.BigEndian.Uint32(set[8:]))) //nolint:gosec,mnd // Two's complement, after the offset
+		if length < batchHeader || batchPrefix+length > len(set) {
+			break
+		}
+		batch := set[batchPrefix : batchPrefix+length]
+		set = set[batchPrefix+length:]
+
+		if batch[4] != magicBatch { //nolint:mnd // After the leader epoch
+			return messages, offset, errOldFormat
+		}
+		if binary.BigEndian.Uint32(batch[5:]) != crc32.Checksum(batch[9:], crc32.MakeTable(crc32.Castagnoli)) { //nolint:mnd // Checksum covers the batch after it
+			return messages, offset, fmt.Errorf("%w at offset %d", errCorrupt, baseOffset)
+		}
+		header := &decoder{buf: batch[9:batchHeader]} //nolint:mnd // After the checksum
+		attributes := header.int16()
+		lastOffsetDelta := header.int32()
+		next := baseOffset + int64(lastOffsetDelta) + 1
+		if attributes&controlBatch != 0 {
+			offset = max(offset, next)
+
+			continue
+		}
+
+		records, err := uncompress(batch[batchHeader:], attributes&codecMask)
+		if err != nil {
+			return messages, offset, err
+		}
+		d := &decoder{buf: records}
+		for range int(int32(binary.BigEndian.Uint32(batch[batchHeader-4:]))) { //nolint:gosec,mnd // Record count precedes the records
+			record := &decoder{buf: d.varBytes()}
+			_ = record.int8()   // Attributes
+			_ = record.varint() // Timestamp delta
+			recordOffset := baseOffset + record.varint()
+			_ = record.varBytes() // Key
+			value := record.varBytes()
+			if d.err != nil || record.err != nil {
+				return messages, offset, fmt.Errorf("decoding batch at offset %d: %w", baseOffset, errors.Join(d.err, record.err))
+			}
+			if recordOffset >= offset {
+				messages = append(messages, Message{Partition: partition, Offset: recordOffset, Value: value})
+			}
+		}
+		offset = max(offset, next)
+	}
+
+	return messages, offset, nil
+}
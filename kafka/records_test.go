@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fetchedSet reads the record set of testdata/fetch-<name>.bin.
+func fetchedSet(t *testing.T, name string) []byte {
+	t.Helper()
+
+	set, err := os.ReadFile(filepath.Join("testdata", "fetch-"+name+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return set
+}
+
+func TestReadRecordsOfEachCodec(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "messages.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+
+	for _, name := range []string{"none", "gzip", "snappy-xerial", "snappy-raw", "lz4", "lz4-dependent", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			messages, next, err := readRecords(fetchedSet(t, name), 3, 100)
+			if err != nil {
+				t.Fatalf("readRecords: %v", err)
+			}
+			if next != 221 {
+				t.Errorf("next offset %d, want 221 before the cut-off batch", next)
+			}
+			if len(messages) != len(values) {
+				t.Fatalf("%d messages, want %d", len(messages), len(values))
+			}
+			for i, message := range messages {
+				offset := int64(100 + i)
+				if i >= 70 {
+					offset++ // After the transaction marker
+				}
+				if message.Partition != 3 || message.Offset != offset || !bytes.Equal(message.Value, values[i]) {
+					t.Fatalf("message %d: partition %d, offset %d, value %.40q; want offset %d, value %.40q",
+						i, message.Partition, message.Offset, message.Value, offset, values[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadRecordsFromOffset(t *testing.T) {
+	// A fetch from the middle of a batch returns the whole batch; earlier records are dropped
+	messages, next, err := readRecords(fetchedSet(t, "zstd"), 0, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 120-50 || messages[0].Offset != 150 || next != 221 {
+		t.Errorf("%d messages from offset %d, next %d; want 70 from 150, next 221", len(messages), messages[0].Offset, next)
+	}
+}
+
+func TestReadRecordsRejectsDamage(t *testing.T) {
+	set := fetchedSet(t, "lz4")
+	corrupt := bytes.Clone(set)
+	corrupt[batchPrefix+batchHeader+20] ^= 0xff
+	_, next, err := readRecords(corrupt, 0, 100)
+	if !errors.Is(err, errCorrupt) || next != 100 {
+		t.Errorf("corrupted batch: error %v, next %d; want %v at 100", err, next, errCorrupt)
+	}
+
+	// Codec 5 doesn't exist; rewrite the attributes and the checksum over them
+	unknown := bytes.Clone(set)
+	length := int(binary.BigEndian.Uint32(unknown[8:]))
+	binary.BigEndian.PutUint16(unknown[batchPrefix+9:], 5)
+	binary.BigEndian.PutUint32(unknown[batchPrefix+5:], crc32.Checksum(unknown[batchPrefix+9:batchPrefix+length], crc32.MakeTable(crc32.Castagnoli)))
+	_, _, err = readRecords(unknown, 0, 100)
+	if !errors.Is(err, errCompression) {
+		t.Errorf("codec 5: error %v, want %v", err, errCompression)
+	}
+}
+
+func TestUncompressRejectsInvalidData(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec int16
+		data  []byte
+		want  error
+	}{
+		{"snappy copy before any output", codecSnappy, []byte{0x08, 0x01, 0x01}, errSnappy},
+		{"snappy length mismatch", codecSnappy, []byte{0x05, 0x00, 'a'}, errSnappy},
+		{"snappy truncated xerial block", codecSnappy, append(bytes.Clone(xerialMagic), 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 9, 1), errSnappy},
+		{"lz4 without magic", codecLZ4, []byte("not an lz4 frame"), errLZ4},
+		{"lz4 without end mark", codecLZ4, []byte{0x04, 0x22, 0x4d, 0x18, 0x60, 0x40, 0x82, 0x02, 0x00, 0x00, 0x80, 'h', 'i'}, errLZ4},
+		{"lz4 match before any output", codecLZ4, []byte{0x04, 0x22, 0x4d, 0x18, 0x60, 0x40, 0x82, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, errLZ4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := uncompress(test.data, test.codec)
+			if !errors.Is(err, test.want) {
+				t.Errorf("error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
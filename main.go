@@ -1,41 +1,258 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"embed"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"zeek-viz/auth"
+	"zeek-viz/elastic"
+	"zeek-viz/geoip"
 	"zeek-viz/handlers"
+	"zeek-viz/store"
+	"zeek-viz/synth"
 )
 
 const (
-	readTimeoutSec  = 15 // HTTP read timeout in seconds
-	writeTimeoutSec = 15 // HTTP write timeout in seconds
-	idleTimeoutSec  = 60 // HTTP idle timeout in seconds
+	defaultPort         = 8080             // Port the server listens on
+	defaultMaxUploadMiB = 50               // Largest multipart upload in MiB
+	defaultMaxBatchMiB  = 512              // Decompressed MiB of logs a batch upload may hold
+	defaultMaxResults   = 50000            // Connections /api/connections returns at once
+	defaultReadTimeout  = 15 * time.Second // HTTP read timeout
+	defaultWriteTimeout = 15 * time.Second // HTTP write timeout
+	defaultIdleTimeout  = 60 * time.Second // HTTP idle timeout
+	defaultShutdownWait = 30 * time.Second // Time in-flight requests get to finish on shutdown
+	defaultSessionTTL   = 12 * time.Hour   // Lifetime of session cookies issued by /api/login
+
+	backendMemory = "memory" // --backend answering every query from the datasets in memory
+	backendES     = "es"     // --backend indexing datasets in Elasticsearch or OpenSearch
 )
 
+//go:generate go run ./tools/precompress static
+
 //go:embed static/*
 var staticFS embed.FS
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			args = args[1:]
+		case "stats":
+			runStats(args[1:])
+
+			return
+		case "graph":
+			runGraph(args[1:])
+
+			return
+		case "export":
+			runExport(args[1:])
+
+			return
+		case "generate":
+			runGenerate(args[1:])
+
+			return
+		}
+	}
+
+	flag.String(configFlag, "", "JSON file with values of these flags, keyed by flag name")
+	bind := flag.String("bind", "", "Address to listen on (default all interfaces)")
+	port := flag.Int("port", defaultPort, "Port to listen on")
+	readTimeout := flag.Duration("read-timeout", defaultReadTimeout, "Time allowed to read a request, including the body of a multipart upload")
+	writeTimeout := flag.Duration("write-timeout", defaultWriteTimeout, "Time allowed to write a response")
+	idleTimeout := flag.Duration("idle-timeout", defaultIdleTimeout, "How long idle keep-alive connections are kept open")
+	compress := flag.Bool("compress", true, "Gzip-compress responses for clients that accept it; turn off behind a proxy that compresses")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate (chain) to serve HTTPS with, re-read when it changes; requires --tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key of --tls-cert")
+	redirectPort := flag.Int("http-redirect-port", 0, "With TLS, also listen on this port (e.g. 80) and redirect plain HTTP to HTTPS")
+	shutdownTimeout := flag.Duration("shutdown-timeout", defaultShutdownWait,
+		"Time in-flight requests, such as uploads, get to finish on SIGINT or SIGTERM before they are cut off")
+	maxUploadMiB := flag.Int64("max-upload-mb", defaultMaxUploadMiB, "Largest multipart upload in MiB; larger logs are streamed to /api/upload/stream")
+	maxBatchMiB := flag.Int64("max-batch-mb", defaultMaxBatchMiB,
+		"Decompressed MiB of logs a batch upload, --load, or a log rotated into --watch-dir may hold; larger batch uploads are rejected with 413")
+	maxResults := flag.Int("max-results", defaultMaxResults, "Connections /api/connections returns at once; larger results are cut and summarized (0 for no limit)")
+	maxDatasets := flag.Int("max-datasets", 0, "Datasets kept in memory at most, evicting the least recently used (default no limit)")
+	memoryLimitMiB := flag.Int64("memory-limit-mb", 0, "Estimated MiB the datasets in memory may take, evicting the least recently used (default no limit)")
+	maxStored := flag.Int("max-stored-datasets", 0, "Datasets the server stores at most; further uploads are rejected with 413 (default no limit)")
+	storageQuotaMiB := flag.Int64("storage-quota-mb", 0, "MiB of uploaded logs the stored datasets may total; further uploads are rejected with 413 (default no limit)")
+	retentionAge := flag.Duration("retention-max-age", 0, "Remove datasets uploaded longer ago than this, e.g. 720h (default keep them)")
+	retentionCount := flag.Int("retention-max-datasets", 0, "Keep at most this many datasets, removing the oldest (default no limit)")
+	retentionMiB := flag.Int64("retention-max-size-mb", 0, "MiB of uploaded logs the datasets may total, removing the oldest beyond (default no limit)")
+	rateLimit := flag.Int("rate-limit", 0, "API requests each client address may make a minute; further requests get 429 (default no limit)")
+	rateBurst := flag.Int("rate-limit-burst", 0, "Requests a client may make at once before --rate-limit applies (default the per-minute limit)")
+	rateHeader := flag.String("rate-limit-header", "",
+		"Tell clients apart by the address trusted reverse proxies append to this header, such as X-Forwarded-For: the rightmost, or see --rate-limit-trusted-hops")
+	rateHops := flag.Int("rate-limit-trusted-hops", 1,
+		"Trusted reverse proxies appending to --rate-limit-header; the client is this many addresses from the right")
+	load := flag.String("load", "", "Load this conn.log, archive, packet capture, or directory of Zeek logs at startup")
+	alertWebhook := flag.String("alert-webhook", "", "URL to POST new scan, beacon, and exfil findings to (default no alerting)")
+	alertFormat := flag.String("alert-format", "", "Body of --alert-webhook requests: generic, slack, or teams (default guessed from the URL)")
+	alertSeverity := flag.String("alert-min-severity", "high", "Least severe findings posted to --alert-webhook: low, medium, high, or critical")
+	zeek := flag.String("zeek", "", "Zeek binary to read uploaded packet captures with, writing their protocol logs too (default built-in flow extraction)")
+	staticDir := flag.String("static-dir", "", "Serve frontend assets from this directory instead of the embedded copy")
+	demo := flag.Bool("demo", false, "Load the built-in demo dataset at startup")
+	dataDir := flag.String("data-dir", "", "Persist datasets in a SQLite database in this directory")
+	backend := flag.String("backend", backendMemory,
+		"Query backend: memory, or es to index connections in Elasticsearch/OpenSearch and query evicted datasets there")
+	esURL := flag.String("es-url", "http://localhost:9200", "Elasticsearch or OpenSearch URL of --backend=es, with user:password@ if it needs credentials")
+	esIndexPrefix := flag.String("es-index-prefix", "zeek-viz-", "Prefix of the index names of --backend=es, followed by the file ID")
+	tail := flag.String("tail", "", "Follow this growing conn.log and stream new connections to the browser")
+	tailFromStart := flag.Bool("tail-from-start", false, "Read the existing content of the --tail file before following it")
+	watchDir := flag.String("watch-dir", "", "Watch this Zeek log directory and ingest rotated conn.logs as they appear")
+	watchExisting := flag.Bool("watch-existing", false, "Also ingest the rotated conn.logs already in the --watch-dir directory")
+	kafkaBrokers := flag.String("kafka-brokers", "", "Comma-separated host:port of Kafka brokers to consume Zeek's conn.log records from")
+	kafkaTopic := flag.String("kafka-topic", "zeek", "Kafka topic of --kafka-brokers that Zeek publishes its logs to")
+	kafkaGroup := flag.String("kafka-group", "zeek-viz", "Kafka consumer group the offsets of --kafka-topic are committed to")
+	kafkaFromStart := flag.Bool("kafka-from-start", false, "Read the oldest messages of --kafka-topic kept when the group has no offsets yet")
+	liveRetention := flag.Duration("live-retention", 0, "How long live datasets keep raw connections before rolling them up (default 1h)")
+	localNetworks := flag.String("local-networks", "",
+		"Comma-separated CIDR prefixes of the local network, or @file with one per line (default: private ranges)")
+	servicePorts := flag.String("service-ports", "",
+		"Comma-separated port=service or port/proto=service overrides of the ports services are guessed from, or @file with one per line")
+	reverseDNS := flag.Bool("reverse-dns", false, "Label graph nodes with the hostnames of their PTR records")
+	rdnsConcurrency := flag.Int("reverse-dns-concurrency", 0, "Concurrent reverse DNS lookups (default 8)")
+	rdnsTTL := flag.Duration("reverse-dns-ttl", 0, "How long resolved hostnames are cached (default 1h)")
+	geoipDB := flag.String("geoip-db", "", "Comma-separated MaxMind DB files (e.g. GeoLite2-City.mmdb,GeoLite2-ASN.mmdb) or ip2asn tables (.tsv, .tsv.gz) to locate external hosts")
+	authTokens := flag.String("auth-token", "",
+		"Comma-separated API tokens, each name:token or a bare token, or @file with one per line; clients send Authorization: Bearer <token>")
+	authBasic := flag.String("auth-basic", "", "Comma-separated user:password pairs, or @file with one per line, accepted as HTTP basic auth")
+	authProxyHeader := flag.String("auth-proxy-header", "",
+		"Trust this request header, set by an authenticating reverse proxy such as oauth2-proxy, to name the user")
+	sessionKey := flag.String("auth-session-key", "", "Key of at least 32 bytes signing session cookies, shared by replicas (default random per start)")
+	sessionTTL := flag.Duration("auth-session-ttl", defaultSessionTTL, "How long a browser stays signed in after /api/login")
+	logFormat := flag.String("log-format", "text", "Log format: text, or json for one JSON object per line")
+	accessLog := flag.Bool("access-log", true, "Log every API request with its status, duration, and dataset")
+	flag.Usage = usage
+	_ = flag.CommandLine.Parse(args) // ExitOnError exits on invalid flags
+	if flag.NArg() > 0 {
+		log.Fatalf("Unknown command %q; see zeek-viz -h", flag.Arg(0))
+	}
+
+	err := applySettings(flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	configureLogging(*logFormat)
+
+	// Canceled on SIGINT or SIGTERM: stops background work and starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	assets := handlers.NewAssets(staticAssets(*staticDir))
+
 	// Create API handler without loading connections initially
 	api := handlers.NewAPI("")
 
+	api.SetBranding(os.Getenv("ZEEK_VIZ_INSTANCE_NAME"), os.Getenv("ZEEK_VIZ_BASE_PATH"))
+	api.SetAccessLog(*accessLog)
+	configureServicePorts(api, *servicePorts)              // Before datasets are loaded, which guesses services
+	configureSearch(api, *backend, *esURL, *esIndexPrefix) // Before datasets are loaded, which indexes them
+	configureStore(api, *dataDir)
+	configureCache(api)
+	configureBackups(ctx, api)
+	configureWatchlist(api)
+	configureSuppressions(api)
+	configureViews(api)
+	configureAnnotations(api)
+	configureLocalNetworks(api, *localNetworks)
+	configureGeoIP(api, *geoipDB)
+	configureAuth(api, authSettings{
+		tokens:      *authTokens,
+		basic:       *authBasic,
+		proxyHeader: *authProxyHeader,
+		sessionKey:  *sessionKey,
+		sessionTTL:  *sessionTTL,
+	})
+	if *reverseDNS {
+		api.SetReverseDNS(*rdnsConcurrency, *rdnsTTL)
+	}
+
+	budgetMiB, _ := strconv.ParseInt(os.Getenv("ZEEK_VIZ_INGEST_BUDGET_MB"), 10, 64) // Invalid values use the default
+	api.SetIngestBudget(budgetMiB << 20)                                             //nolint:mnd // MiB to bytes
+	api.SetMaxUploadSize(*maxUploadMiB << 20)                                        //nolint:mnd // MiB to bytes
+	api.SetMaxBatchSize(*maxBatchMiB << 20)                                          //nolint:mnd // MiB to bytes
+	api.SetMaxResults(*maxResults)
+	configureMemoryLimits(api, *maxDatasets, *memoryLimitMiB, *dataDir != "" || os.Getenv("ZEEK_VIZ_STORE") != "" || *backend == backendES)
+	api.SetDatasetQuota(*maxStored, *storageQuotaMiB<<20) //nolint:mnd // MiB to bytes
+	api.SetRateLimit(*rateLimit, *rateBurst, *rateHeader, *rateHops)
+	api.SetRetention(handlers.RetentionPolicy{MaxAge: *retentionAge, MaxDatasets: *retentionCount, MaxBytes: *retentionMiB << 20}) //nolint:mnd // MiB to bytes
+
+	err = api.SetZeek(*zeek)
+	if err != nil {
+		log.Fatalf("Invalid --zeek: %v", err)
+	}
+
+	err = api.SetAlerts(*alertWebhook, *alertFormat, *alertSeverity)
+	if err != nil {
+		log.Fatalf("Invalid --alert-webhook: %v", err)
+	}
+
+	api.SetLiveRetention(*liveRetention)
+	if *tail != "" {
+		err := api.Tail(ctx, *tail, *tailFromStart)
+		if err != nil {
+			log.Fatalf("Failed to follow %s: %v", *tail, err)
+		}
+	}
+	if *watchDir != "" {
+		err := api.WatchDir(ctx, *watchDir, *watchExisting)
+		if err != nil {
+			log.Fatalf("Failed to watch %s: %v", *watchDir, err)
+		}
+	}
+	if *kafkaBrokers != "" {
+		err := api.ConsumeKafka(ctx, strings.Split(*kafkaBrokers, ","), *kafkaTopic, *kafkaGroup, *kafkaFromStart)
+		if err != nil {
+			log.Fatalf("Failed to consume Kafka topic %s: %v", *kafkaTopic, err)
+		}
+	}
+
+	if *demo {
+		_, err := api.LoadDemo(ctx)
+		if err != nil {
+			log.Fatalf("Failed to load demo dataset: %v", err)
+		}
+	}
+
+	if *load != "" {
+		count, err := api.LoadPath(ctx, *load)
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *load, err)
+		}
+		log.Printf("Loaded %d datasets from %s", count, *load)
+	}
+	api.StartRetention(ctx) // Once the datasets are loaded, as it expires old ones at once
+	api.StartAlerts(ctx)    // Once the datasets are loaded, so their findings aren't posted again on restarts
+
 	// Setup routes
-	http.HandleFunc("/", handlers.IndexHandler(staticFS))
-	http.Handle("/static/", http.StripPrefix("/static/", handlers.StaticHandler(staticFS)))
+	http.HandleFunc("/", api.WithWorkspace(handlers.IndexHandler(assets, api.Config)))
+	http.Handle("/static/", http.StripPrefix("/static/", assets))
 
 	// API routes
-	http.HandleFunc("/api/upload", api.UploadFile)
-	http.HandleFunc("/api/files", api.GetFiles)
-	http.HandleFunc("/api/switch", api.SwitchFile)
-	http.HandleFunc("/api/delete", api.DeleteFile)
-	http.HandleFunc("/api/connections", api.GetConnections)
-	http.HandleFunc("/api/nodes", api.GetNodes)
-	http.HandleFunc("/api/timeline", api.GetTimeline)
-	http.HandleFunc("/api/stats", api.GetStats)
+	api.HandleAPI(http.DefaultServeMux)
+
+	// Versioned API with an OpenAPI document
+	api.HandleV1(http.DefaultServeMux)
+
+	// Prometheus metrics
+	http.HandleFunc("GET /metrics", api.GetMetrics)
 
 	// Health check endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -44,19 +261,482 @@ func main() {
 	})
 
 	// Start server
-	addr := ":8080"
-	log.Printf("Starting server on http://localhost%s", addr)
-	log.Println("Ready to accept file uploads...")
+	addr := net.JoinHostPort(*bind, strconv.Itoa(*port))
+	host := *bind
+	if host == "" {
+		host = "localhost"
+	}
 
+	var handler http.Handler = api.RateLimited(api.Authenticated(api.SharedState(http.DefaultServeMux)))
+	if *compress {
+		handler = handlers.Compressed(handler)
+	}
 	server := &http.Server{
 		Addr:         addr,
-		ReadTimeout:  readTimeoutSec * time.Second,
-		WriteTimeout: writeTimeoutSec * time.Second,
-		IdleTimeout:  idleTimeoutSec * time.Second,
+		Handler:      api.Instrumented(http.DefaultServeMux, handler),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
 	}
+	server.RegisterOnShutdown(api.CloseLiveEvents)
+	servers := []*http.Server{server}
 
-	err := server.ListenAndServe()
-	if err != nil {
+	scheme := "http"
+	switch {
+	case (*tlsCert == "") != (*tlsKey == ""):
+		log.Fatalf("--tls-cert and --tls-key must be set together")
+	case *tlsCert != "":
+		scheme = "https"
+		server.TLSConfig, err = tlsConfig(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		log.Printf("TLS enabled, serving HTTP/2 and HTTP/1.1")
+		if *redirectPort != 0 {
+			server.Handler = strictTransport(server.Handler)
+			servers = append(servers, redirectServer(net.JoinHostPort(*bind, strconv.Itoa(*redirectPort)), *port))
+			log.Printf("Redirecting HTTP on port %d to HTTPS", *redirectPort)
+		}
+	case *redirectPort != 0:
+		log.Fatalf("--http-redirect-port requires --tls-cert and --tls-key")
+	}
+	log.Printf("Starting server on %s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(*port)))
+	log.Println("Ready to accept file uploads...")
+
+	serveErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			if s.TLSConfig != nil {
+				serveErr <- s.ListenAndServeTLS("", "") // The certificate comes from TLSConfig
+			} else {
+				serveErr <- s.ListenAndServe()
+			}
+		}()
+	}
+
+	select {
+	case err = <-serveErr:
 		log.Fatalf("Server failed to start: %v", err)
+	case <-ctx.Done():
 	}
+	stop() // A second signal exits immediately
+
+	shutdown(*shutdownTimeout, servers...)
+	api.WaitKafka()
+}
+
+// shutdown stops accepting connections and waits up to timeout for in-flight requests to
+// finish. Requests still running then are cut off, which cancels their contexts and aborts
+// their parsing.
+func shutdown(timeout time.Duration, servers ...*http.Server) {
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		err := server.Shutdown(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Requests still running after %s; closing their connections", timeout)
+			err = server.Close()
+		}
+		if err != nil {
+			log.Printf("Shutdown failed: %v", err)
+
+			return
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// runGenerate implements "zeek-viz generate": it writes synthetic conn.log data to stdout
+// or the --output file.
+func runGenerate(args []string) {
+	const defaultHosts, defaultRate = 50, 2.0 // Default network size and peak connections per host and minute
+
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	hosts := flags.Int("hosts", defaultHosts, "Number of internal workstations")
+	duration := flags.Duration("duration", time.Hour, "Covered time span")
+	start := flags.String("start", "2024-01-01T00:00:00Z", "Timestamp of the first connection (RFC 3339)")
+	rate := flags.Float64("rate", defaultRate, "Connections per host and minute at peak hours")
+	seed := flags.Uint64("seed", 1, "Random seed; equal flags produce equal output")
+	output := flags.String("output", "", "Output file (default stdout)")
+	_ = flags.Parse(args) // ExitOnError exits on invalid flags
+
+	startTime, err := time.Parse(time.RFC3339, *start)
+	if err != nil {
+		log.Fatalf("Invalid --start: %v", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+	}
+
+	writer := bufio.NewWriter(out)
+	count, err := synth.Generate(writer, synth.Config{
+		Hosts:    *hosts,
+		Duration: *duration,
+		Start:    startTime,
+		Rate:     *rate,
+		Seed:     *seed,
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		log.Fatalf("Failed to generate connections: %v", err)
+	}
+
+	log.Printf("Generated %d connections for %d hosts over %s", count, *hosts, *duration)
+}
+
+// staticAssets returns the frontend assets: the files in dir when set, re-read when they
+// change so edits apply without a restart, or the copy embedded in the binary.
+func staticAssets(dir string) fs.FS {
+	if dir == "" {
+		assets, err := fs.Sub(staticFS, "static")
+		if err != nil {
+			log.Fatalf("Failed to load embedded static files: %v", err)
+		}
+
+		return assets
+	}
+
+	assets := os.DirFS(dir)
+	_, err := fs.Stat(assets, "index.html")
+	if err != nil {
+		log.Fatalf("Static directory %s has no index.html: %v", dir, err)
+	}
+	log.Printf("Serving static files from %s", dir)
+
+	return assets
+}
+
+// configureLogging switches the server log, including the access log, to one JSON object per
+// line for log collectors when format is "json".
+func configureLogging(format string) {
+	switch format {
+	case "text":
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil))) // Also routes the log package
+	default:
+		log.Fatalf("Invalid --log-format %q: must be text or json", format)
+	}
+}
+
+// configureMemoryLimits evicts the least recently used datasets beyond maxDatasets or
+// memoryLimitMiB. Without a store or search backend, evicted datasets are gone for good.
+func configureMemoryLimits(api *handlers.API, maxDatasets int, memoryLimitMiB int64, stored bool) {
+	if maxDatasets <= 0 && memoryLimitMiB <= 0 {
+		return
+	}
+
+	api.SetMemoryLimits(maxDatasets, memoryLimitMiB<<20) //nolint:mnd // MiB to bytes
+	if !stored {
+		log.Printf("Evicting least recently used datasets beyond the memory limits; without a store they are dropped")
+
+		return
+	}
+	log.Printf("Evicting least recently used datasets beyond the memory limits; they reload from the store or search backend on use")
+}
+
+// configureSearch indexes datasets in the Elasticsearch or OpenSearch server at url with
+// --backend=es, so evicted datasets are queried there instead of being read back.
+func configureSearch(api *handlers.API, backend, url, prefix string) {
+	switch backend {
+	case backendMemory:
+		return
+	case backendES:
+	default:
+		log.Fatalf("Invalid --backend %q: must be %s or %s", backend, backendMemory, backendES)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) //nolint:mnd // Startup connection timeout
+	defer cancel()
+
+	client, err := elastic.New(ctx, url, prefix)
+	if err != nil {
+		log.Fatalf("Failed to connect to the search backend: %v", err)
+	}
+
+	api.SetSearchBackend(client)
+	log.Printf("Indexing datasets in %s at %s", client.Version(), client.URL())
+}
+
+// configureStore persists datasets in a SQLite database in dataDir (--data-dir), or shares
+// them through the store named by ZEEK_VIZ_STORE: a directory, a SQLite file, or a
+// postgres:// URL. Datasets stay in memory only when neither is set.
+func configureStore(api *handlers.API, dataDir string) {
+	location := os.Getenv("ZEEK_VIZ_STORE")
+	if dataDir != "" {
+		if location != "" {
+			log.Fatalf("--data-dir and ZEEK_VIZ_STORE cannot be combined")
+		}
+		location = "sqlite://" + filepath.Join(dataDir, store.SQLiteFilename)
+	}
+	if location == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	datasetStore, err := store.Open(ctx, location)
+	if err != nil {
+		log.Fatalf("Failed to open dataset store: %v", err)
+	}
+
+	err = api.SetStore(ctx, datasetStore)
+	if err != nil {
+		log.Fatalf("Failed to load datasets from the store: %v", err)
+	}
+	log.Printf("Sharing datasets through store %s", store.Redact(location))
+}
+
+// configureCache shares query results and the selected dataset through the Redis server
+// named by ZEEK_VIZ_REDIS_URL (e.g. "redis://redis:6379/0").
+func configureCache(api *handlers.API) {
+	url := os.Getenv("ZEEK_VIZ_REDIS_URL")
+	if url == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) //nolint:mnd // Startup connection timeout
+	defer cancel()
+
+	cache, err := store.NewRedisCache(ctx, url)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	api.SetCache(cache)
+	log.Printf("Sharing query results and selection through Redis %s", store.Redact(url))
+}
+
+// configureBackups enables backups from the ZEEK_VIZ_BACKUP_DIR, ZEEK_VIZ_BACKUP_INTERVAL
+// (e.g. "6h", scheduled backups are off when unset), and ZEEK_VIZ_BACKUP_KEEP environment
+// variables. Scheduled backups stop when ctx is done.
+func configureBackups(ctx context.Context, api *handlers.API) {
+	dir := os.Getenv("ZEEK_VIZ_BACKUP_DIR")
+	if dir == "" {
+		return
+	}
+
+	keep, _ := strconv.Atoi(os.Getenv("ZEEK_VIZ_BACKUP_KEEP")) // Invalid values use the default
+	err := api.SetBackupDir(dir, keep)
+	if err != nil {
+		log.Fatalf("Failed to configure backups: %v", err)
+	}
+	log.Printf("Backups enabled in %s", dir)
+
+	if value := os.Getenv("ZEEK_VIZ_BACKUP_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid ZEEK_VIZ_BACKUP_INTERVAL %q", value)
+		}
+		api.StartBackupSchedule(ctx, interval)
+		log.Printf("Scheduled backups every %s", interval)
+	}
+}
+
+// configureWatchlist persists the IP watchlist in the JSON file named by ZEEK_VIZ_WATCHLIST.
+// The watchlist is kept in memory only when it is unset.
+func configureWatchlist(api *handlers.API) {
+	path := os.Getenv("ZEEK_VIZ_WATCHLIST")
+	if path == "" {
+		return
+	}
+
+	err := api.SetWatchlistFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load watchlist: %v", err)
+	}
+	log.Printf("Persisting watchlist in %s", path)
+}
+
+// configureSuppressions persists detection suppressions in the JSON file named by
+// ZEEK_VIZ_SUPPRESSIONS. Suppressions are kept in memory only when it is unset.
+func configureSuppressions(api *handlers.API) {
+	path := os.Getenv("ZEEK_VIZ_SUPPRESSIONS")
+	if path == "" {
+		return
+	}
+
+	err := api.SetSuppressionsFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load suppressions: %v", err)
+	}
+	log.Printf("Persisting suppressions in %s", path)
+}
+
+// configureViews persists saved views in the JSON file named by ZEEK_VIZ_VIEWS. Views are kept
+// in memory only when it is unset.
+func configureViews(api *handlers.API) {
+	path := os.Getenv("ZEEK_VIZ_VIEWS")
+	if path == "" {
+		return
+	}
+
+	err := api.SetViewsFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load views: %v", err)
+	}
+	log.Printf("Persisting views in %s", path)
+}
+
+// configureAnnotations persists host and connection annotations in the JSON file named by
+// ZEEK_VIZ_ANNOTATIONS. Annotations are kept in memory only when it is unset.
+func configureAnnotations(api *handlers.API) {
+	path := os.Getenv("ZEEK_VIZ_ANNOTATIONS")
+	if path == "" {
+		return
+	}
+
+	err := api.SetAnnotationsFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load annotations: %v", err)
+	}
+	log.Printf("Persisting annotations in %s", path)
+}
+
+// configureLocalNetworks sets the prefixes whose hosts count as local from the --local-networks
+// flag: a comma-separated list, or @path to a file with one prefix per line and # comments.
+func configureLocalNetworks(api *handlers.API, value string) {
+	if value == "" {
+		return
+	}
+
+	values, err := listValues(value)
+	if err != nil {
+		log.Fatalf("Failed to read local networks: %v", err)
+	}
+
+	err = api.SetLocalNetworks(values)
+	if err != nil {
+		log.Fatalf("Invalid local networks: %v", err)
+	}
+	log.Printf("Treating %s as local", value)
+}
+
+// configureServicePorts overrides the ports services are guessed from with the --service-ports
+// flag: a comma-separated list, or @path to a file with one override per line and # comments.
+func configureServicePorts(api *handlers.API, value string) {
+	if value == "" {
+		return
+	}
+
+	values, err := listValues(value)
+	if err != nil {
+		log.Fatalf("Failed to read service ports: %v", err)
+	}
+
+	err = api.SetServicePorts(values)
+	if err != nil {
+		log.Fatalf("Invalid service ports: %v", err)
+	}
+	log.Printf("Guessing services with overrides %s", value)
+}
+
+// configureGeoIP loads the MaxMind databases and ip2asn tables named by the --geoip-db flag.
+// Without it, nodes carry no locations and the country filter and AS grouping are rejected.
+func configureGeoIP(api *handlers.API, value string) {
+	if value == "" {
+		return
+	}
+
+	db, err := geoip.Open(strings.Split(value, ","))
+	if err != nil {
+		log.Fatalf("Failed to load GeoIP database: %v", err)
+	}
+	api.SetGeoIP(db)
+	log.Printf("Locating external hosts with %s", strings.Join(db.Types(), ", "))
+}
+
+// authSettings are the flags configuring authentication.
+type authSettings struct {
+	tokens      string
+	basic       string
+	proxyHeader string
+	sessionKey  string
+	sessionTTL  time.Duration
+}
+
+// configureAuth requires /api requests to carry one of the credentials the --auth-* flags
+// configure: a bearer token, basic auth, or a header set by an authenticating proxy. The API
+// stays open when none is set.
+func configureAuth(api *handlers.API, settings authSettings) {
+	var authenticators []auth.Authenticator
+	if settings.tokens != "" {
+		values, err := listValues(settings.tokens)
+		if err != nil {
+			log.Fatalf("Failed to read auth tokens: %v", err)
+		}
+		tokens, err := auth.NewTokens(values)
+		if err != nil {
+			log.Fatalf("Invalid auth tokens: %v", err)
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if settings.basic != "" {
+		values, err := listValues(settings.basic)
+		if err != nil {
+			log.Fatalf("Failed to read basic auth users: %v", err)
+		}
+		basic, err := auth.NewBasic(values)
+		if err != nil {
+			log.Fatalf("Invalid basic auth users: %v", err)
+		}
+		authenticators = append(authenticators, basic)
+	}
+	if settings.proxyHeader != "" {
+		authenticators = append(authenticators, auth.NewProxy(settings.proxyHeader))
+	}
+	if len(authenticators) == 0 {
+		return
+	}
+
+	sessions, err := auth.NewSessions([]byte(settings.sessionKey), settings.sessionTTL)
+	if err != nil {
+		log.Fatalf("Invalid --auth-session-key: %v", err)
+	}
+	api.SetAuth(sessions, authenticators...)
+
+	methods := make([]string, len(authenticators))
+	for i, authenticator := range authenticators {
+		methods[i] = authenticator.Method()
+	}
+	log.Printf("API requires authentication (%s)", strings.Join(methods, ", "))
+}
+
+// listValues splits a comma-separated flag value, or reads the file named by @path with one
+// value per line and # comments. Values are trimmed and empty ones dropped.
+func listValues(value string) ([]string, error) {
+	lines := strings.Split(value, ",")
+	if path, isFile := strings.CutPrefix(value, "@"); isFile {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		lines = nil
+		for line := range strings.Lines(string(data)) {
+			line, _, _ = strings.Cut(line, "#")
+			lines = append(lines, line)
+		}
+	}
+
+	values := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+
+	return values, nil
 }
@@ -0,0 +1,247 @@
+// Package metrics collects counters, gauges, and histograms and writes them in the Prometheus
+// text exposition format, so instances can be scraped without a client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the exposition format Write produces.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DurationBuckets are histogram buckets in seconds suiting request and parse durations.
+func DurationBuckets() []float64 {
+	return []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+}
+
+// family is a metric with its samples.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in the order they were registered. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a counter, partitioned by the given labels.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	counter := &Counter{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]*counterValue)}
+	r.register(counter)
+
+	return counter
+}
+
+// Histogram registers a histogram with the given upper bucket bounds, partitioned by labels.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	histogram := &Histogram{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		values:  make(map[string]*histogramValue),
+	}
+	r.register(histogram)
+
+	return histogram
+}
+
+// Gauge registers a gauge whose value is read from value at every scrape.
+func (r *Registry) Gauge(name, help string, value func() float64) {
+	r.register(&gauge{desc: desc{name: name, help: help}, value: value})
+}
+
+// Write writes every metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buffered)
+	}
+
+	err := buffered.Flush()
+	if err != nil {
+		return fmt.Errorf("writing metrics: %w", err)
+	}
+
+	return nil
+}
+
+// register adds a metric.
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families = append(r.families, f)
+}
+
+// desc is the name, help text, and label names of a metric.
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric.
+func (d *desc) writeHeader(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "), d.name, kind)
+}
+
+// labelPairs formats label values as name="value" pairs, followed by extra, already formatted
+// pairs. Missing values are empty.
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(d.labels)+len(extra))
+	for i, label := range d.labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, label+"="+strconv.Quote(value))
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing count per combination of label values.
+type Counter struct {
+	desc
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+// counterValue is the count of one combination of label values.
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// Add adds delta, which must not be negative, to the count for the label values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	value, exists := c.values[key]
+	if !exists {
+		value = &counterValue{labels: labelValues}
+		c.values[key] = value
+	}
+	value.value += delta
+}
+
+// Inc adds one to the count for the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// write writes the counter and its samples, ordered by labels.
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w, "counter")
+	for _, key := range slices.Sorted(maps.Keys(c.values)) {
+		value := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(value.labels), formatFloat(value.value))
+	}
+}
+
+// Histogram counts observations in buckets per combination of label values.
+type Histogram struct {
+	desc
+
+	buckets []float64 // Upper bounds, ascending; +Inf is implied
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+// histogramValue is the distribution of one combination of label values.
+type histogramValue struct {
+	labels []string
+	counts []uint64 // Observations per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe records value for the label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	histogram, exists := h.values[key]
+	if !exists {
+		histogram = &histogramValue{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = histogram
+	}
+	if bucket, _ := slices.BinarySearch(h.buckets, value); bucket < len(h.buckets) {
+		histogram.counts[bucket]++
+	}
+	histogram.count++
+	histogram.sum += value
+}
+
+// write writes the histogram with cumulative buckets, ordered by labels.
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w, "histogram")
+	for _, key := range slices.Sorted(maps.Keys(h.values)) {
+		value := h.values[key]
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(value.labels, "le="+strconv.Quote(formatFloat(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(value.labels, `le="+Inf"`), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(value.labels), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(value.labels), value.count)
+	}
+}
+
+// gauge is a value read at scrape time.
+type gauge struct {
+	desc
+
+	value func() float64
+}
+
+// write writes the gauge's current value.
+func (g *gauge) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value()))
+}
+
+// formatFloat formats a sample value.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
@@ -1,3 +1,6 @@
+// Package models defines connections as Zeek logs them in conn.log, their statistics, and the
+// network graph and timeline zeek-viz builds from them, with readers and writers of the log
+// and graph formats it supports.
 package models
 
 import (
@@ -32,6 +35,15 @@ type Connection struct {
 	RespPackets int     `json:"resp_pkts,omitempty"`     //nolint:tagliatelle // Zeek log format
 	RespIPBytes int     `json:"resp_ip_bytes,omitempty"` //nolint:tagliatelle // Zeek log format
 	IPProtocol  int     `json:"ip_proto,omitempty"`      //nolint:tagliatelle // Zeek log format
+	SourceFile  string  `json:"source_file,omitempty"`   //nolint:tagliatelle // File a merged dataset took the record from
+	Stitched    int     `json:"stitched,omitempty"`      // Records flow stitching combined into this one, 0 if none
+	Dataset     string  `json:"dataset,omitempty"`       // File ID a query across several datasets took the record from
+
+	// ServiceGuess is the service the responder port suggests when Zeek identified none,
+	// filled in when connections are loaded. It is never a Zeek-confirmed service.
+	ServiceGuess string `json:"service_guess,omitempty"` //nolint:tagliatelle // API consistency
+
+	Extras map[string]json.RawMessage `json:"extras,omitempty"` // Fields without a place above, as logged
 }
 
 // GetTime returns the timestamp as a time.Time.
@@ -44,15 +56,36 @@ func (c *Connection) TotalBytes() int {
 	return c.OrigBytes + c.RespBytes
 }
 
+// FailedStates returns the connection states of TCP attempts that were never established.
+func FailedStates() []string {
+	return []string{"S0", "REJ", "RSTOS0", "RSTRH", "SH", "SHR"}
+}
+
 // Node represents a network node (IP address) in the graph.
 type Node struct {
-	ID          string  `json:"id"`
-	Label       string  `json:"label"`
-	Connections int     `json:"connections"`
-	TotalBytes  int     `json:"total_bytes"` //nolint:tagliatelle // API consistency
-	IsLocal     bool    `json:"is_local"`    //nolint:tagliatelle // API consistency
-	X           float64 `json:"x,omitempty"`
-	Y           float64 `json:"y,omitempty"`
+	ID            string             `json:"id"`
+	Label         string             `json:"label"`
+	Hostname      string             `json:"hostname,omitempty"` // PTR name, with reverse DNS enabled
+	Connections   int                `json:"connections"`
+	TotalBytes    int                `json:"total_bytes"`                    //nolint:tagliatelle // API consistency
+	IsLocal       bool               `json:"is_local"`                       //nolint:tagliatelle // API consistency
+	FirstSeen     float64            `json:"first_seen"`                     //nolint:tagliatelle // API consistency
+	LastSeen      float64            `json:"last_seen"`                      //nolint:tagliatelle // API consistency
+	RiskScore     float64            `json:"risk_score"`                     //nolint:tagliatelle // API consistency
+	RiskFactors   map[string]float64 `json:"risk_factors,omitempty"`         //nolint:tagliatelle // API consistency
+	Members       int                `json:"members,omitempty"`              // Hosts collapsed into a subnet node
+	InternalConns int                `json:"internal_connections,omitempty"` //nolint:tagliatelle // Within a subnet node
+	Country       string             `json:"country,omitempty"`              // ISO country code of external hosts, from GeoIP
+	City          string             `json:"city,omitempty"`
+	ASN           uint               `json:"asn,omitempty"`
+	ASOrg         string             `json:"as_org,omitempty"`     //nolint:tagliatelle // API consistency
+	Threat        *Threat            `json:"threat,omitempty"`     // Matching threat-intel indicator
+	Scan          *ScanActivity      `json:"scan,omitempty"`       // Port scans and host sweeps the host originated
+	Annotation    *Annotation        `json:"annotation,omitempty"` // Analyst tags and note
+	Metrics       *NodeMetrics       `json:"metrics,omitempty"`    // Centrality and community, with analytics=true
+	X             float64            `json:"x,omitempty"`          // Position from the layout parameter, in the layout's box
+	Y             float64            `json:"y,omitempty"`
+	Datasets      []string           `json:"datasets,omitempty"` // File IDs the host appears in, for graphs across several datasets
 }
 
 // Edge represents a connection between two nodes.
@@ -61,41 +94,223 @@ type Edge struct {
 	Target     string  `json:"target"`
 	Protocol   string  `json:"protocol"`
 	Service    string  `json:"service"`
+	Port       int     `json:"port,omitempty"` // Responder port, when edges are aggregated by port
 	Count      int     `json:"count"`
 	TotalBytes int     `json:"total_bytes"` //nolint:tagliatelle // API consistency
 	Weight     float64 `json:"weight"`
+	FirstSeen  float64 `json:"first_seen"`       //nolint:tagliatelle // API consistency
+	LastSeen   float64 `json:"last_seen"`        //nolint:tagliatelle // API consistency
+	Threat     *Threat `json:"threat,omitempty"` // Indicator matched by either endpoint
+
+	// Bytes sent from the source to the target and back. The source originated the
+	// connections, except the ReverseCount of edges aggregated by host pair.
+	OrigBytes    int `json:"orig_bytes"`              //nolint:tagliatelle // Zeek field name
+	RespBytes    int `json:"resp_bytes"`              //nolint:tagliatelle // Zeek field name
+	ReverseCount int `json:"reverse_count,omitempty"` //nolint:tagliatelle // API consistency
+
+	Datasets []string `json:"datasets,omitempty"` // File IDs with connections on the edge, for graphs across several datasets
+}
+
+// Threat is a threat-intel indicator matched by a host.
+type Threat struct {
+	Indicator   string `json:"indicator"` // IP address or CIDR prefix from the IOC list
+	Source      string `json:"source"`    // IOC list the indicator came from
+	Host        string `json:"host"`      // Address that matched
+	Description string `json:"description,omitempty"`
+}
+
+// Annotation is what analysts noted about a host or connection: tags such as "DC01" or
+// "known scanner", and a free-text note.
+type Annotation struct {
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// ConnectionAnnotations are the annotations of a connection's UID and of its two hosts.
+type ConnectionAnnotations struct {
+	Connection *Annotation `json:"connection,omitempty"`
+	Orig       *Annotation `json:"orig_h,omitempty"` //nolint:tagliatelle // Zeek field name
+	Resp       *Annotation `json:"resp_h,omitempty"` //nolint:tagliatelle // Zeek field name
+}
+
+// Annotator returns the annotations of a connection, or nil when it has none.
+type Annotator func(conn *Connection) *ConnectionAnnotations
+
+// ScanActivity summarizes the port scans and host sweeps a host originated.
+type ScanActivity struct {
+	VerticalScans    int `json:"vertical_scans"`    //nolint:tagliatelle // Hosts it probed on many ports
+	HorizontalSweeps int `json:"horizontal_sweeps"` //nolint:tagliatelle // Ports it probed across many hosts
+	MaxTargets       int `json:"max_targets"`       //nolint:tagliatelle // Most distinct targets of one scan within a window
 }
 
 // TimelinePoint represents a point in the timeline.
 type TimelinePoint struct {
-	Timestamp   int64        `json:"timestamp"`
-	Count       int          `json:"count"`
-	Bytes       int          `json:"bytes"`
-	Protocol    string       `json:"protocol,omitempty"`
-	Connections []Connection `json:"connections,omitempty"`
+	Timestamp    int64        `json:"timestamp"`
+	Count        int          `json:"count"`
+	Bytes        int          `json:"bytes"`
+	Protocol     string       `json:"protocol,omitempty"`
+	Connections  []Connection `json:"connections,omitempty"`
+	Local        string       `json:"local,omitempty"`         // Bucket start in the requested time zone
+	AnomalyScore float64      `json:"anomaly_score,omitempty"` //nolint:tagliatelle // Deviation of the count from the preceding buckets
+}
+
+// DirectionalTimelinePoint represents the activity of a host or host pair within a time bucket.
+type DirectionalTimelinePoint struct {
+	Timestamp int64  `json:"timestamp"`
+	Count     int    `json:"count"`
+	BytesOut  int    `json:"bytes_out"`       //nolint:tagliatelle // API consistency
+	BytesIn   int    `json:"bytes_in"`        //nolint:tagliatelle // API consistency
+	Local     string `json:"local,omitempty"` // Bucket start in the requested time zone
+}
+
+// DirectionalTimeline represents bucketed activity with traffic direction for a host or host pair.
+type DirectionalTimeline struct {
+	Points     []DirectionalTimelinePoint `json:"points"`
+	BucketSize int64                      `json:"bucket_size"` //nolint:tagliatelle // API consistency
+	Start      int64                      `json:"start"`
+	End        int64                      `json:"end"`
+	Timezone   string                     `json:"timezone,omitempty"`
 }
 
 // NetworkGraph represents the complete network visualization data.
 type NetworkGraph struct {
-	Nodes []Node `json:"nodes"`
-	Edges []Edge `json:"edges"`
+	Nodes      []Node          `json:"nodes"`
+	Edges      []Edge          `json:"edges"`
+	Truncated  bool            `json:"truncated"`
+	TotalNodes int             `json:"total_nodes"`         //nolint:tagliatelle // API consistency
+	TotalEdges int             `json:"total_edges"`         //nolint:tagliatelle // API consistency
+	Limits     map[string]int  `json:"limits,omitempty"`    // Limits applied to this response
+	Layout     *GraphLayout    `json:"layout,omitempty"`    // Server-side layout the node positions come from
+	Analytics  *GraphAnalytics `json:"analytics,omitempty"` // Summary of the node metrics, with analytics=true
+
+	SuppressedFindings int `json:"suppressed_findings,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// GraphLayout describes the node positions computed on the server. Positions lie within a box
+// from (0, 0) to (Width, Height), in the units of the UI's pixels.
+type GraphLayout struct {
+	Algorithm  string        `json:"algorithm"` // force, circular, or hierarchical
+	Width      float64       `json:"width"`     // Extent of the box holding the nodes
+	Height     float64       `json:"height"`
+	Iterations int           `json:"iterations,omitempty"` // Steps of the force-directed layout
+	Groups     []LayoutGroup `json:"groups,omitempty"`     // Subnet boxes of the hierarchical layout
+}
+
+// LayoutGroup is the box a hierarchical layout places the hosts of a subnet in.
+type LayoutGroup struct {
+	ID      string  `json:"id"`       // Subnet in CIDR notation, or "other" for nodes that aren't addresses
+	IsLocal bool    `json:"is_local"` //nolint:tagliatelle // API consistency
+	X       float64 `json:"x"`        // Top-left corner
+	Y       float64 `json:"y"`
+	Width   float64 `json:"width"`
+	Height  float64 `json:"height"`
+	Nodes   int     `json:"nodes"`
+}
+
+// NodeMetrics describes a node's place in the graph. The graph is taken as undirected, with
+// the edges of a host pair merged.
+type NodeMetrics struct {
+	Degree         int     `json:"degree"`          // Distinct peers
+	WeightedDegree float64 `json:"weighted_degree"` //nolint:tagliatelle // Connections on the node's edges
+	Betweenness    float64 `json:"betweenness"`     // Share of shortest paths between other nodes through this one, 0 to 1
+	Community      int     `json:"community"`       // Louvain community, numbered by size from 0
+}
+
+// GraphAnalytics summarizes the node metrics of a graph.
+type GraphAnalytics struct {
+	Communities        int     `json:"communities"`
+	Modularity         float64 `json:"modularity"`          // Of the community partition, -0.5 to 1
+	BetweennessSources int     `json:"betweenness_sources"` //nolint:tagliatelle // API consistency
+	BetweennessSampled bool    `json:"betweenness_sampled"` //nolint:tagliatelle // Estimated from a sample of sources
+}
+
+// ConnectionsResponse wraps a page of connections with truncation metadata.
+type ConnectionsResponse struct {
+	Connections any                 `json:"connections"` // []Connection, or records of the requested fields
+	Truncated   bool                `json:"truncated"`
+	Total       int                 `json:"total"`
+	Offset      int                 `json:"offset"`
+	NextOffset  int                 `json:"next_offset,omitempty"` //nolint:tagliatelle // API consistency
+	Fields      []string            `json:"fields,omitempty"`
+	Limits      map[string]int      `json:"limits"`
+	Summary     *ConnectionsSummary `json:"summary,omitempty"` // All matches aggregated, when max_results cut the page
+	Sample      *ConnectionSample   `json:"sample,omitempty"`  // How the connections were sampled from the Total matches
+}
+
+// ConnectionSample describes a representative subset of the matching connections returned
+// instead of all of them, for overviews such as scatter plots.
+type ConnectionSample struct {
+	Strategy string          `json:"strategy"`         // random, stratified, or top_bytes
+	Size     int             `json:"size"`             // Connections in the sample
+	Seed     uint64          `json:"seed,omitempty"`   // Of random and stratified samples; equal seeds draw equal samples
+	Strata   []SampleStratum `json:"strata,omitempty"` // Per protocol, for stratified samples
+}
+
+// SampleStratum is the share of one protocol in a stratified sample.
+type SampleStratum struct {
+	Protocol string `json:"protocol"`
+	Size     int    `json:"size"`     // Connections of the protocol in the sample
+	Matching int    `json:"matching"` // Connections of the protocol matching the filters
+}
+
+// ConnectionsSummary aggregates the connections matching a query that are too many to return,
+// so clients can show where they are and ask for a narrower filter.
+type ConnectionsSummary struct {
+	BucketSize      int64           `json:"bucket_size"` //nolint:tagliatelle // API consistency
+	Timeline        []TimelinePoint `json:"timeline"`
+	TopSources      []HostCount     `json:"top_sources"`      //nolint:tagliatelle // API consistency
+	TopDestinations []HostCount     `json:"top_destinations"` //nolint:tagliatelle // API consistency
+}
+
+// HostCount is the number of connections and bytes of one host.
+type HostCount struct {
+	Host        string `json:"host"`
+	Connections int    `json:"connections"`
+	Bytes       int    `json:"bytes"`
 }
 
 // TimelineData represents timeline visualization data.
 type TimelineData struct {
+	Points     []TimelinePoint  `json:"points"`
+	Start      int64            `json:"start"`
+	End        int64            `json:"end"`
+	BucketSize int64            `json:"bucket_size"` //nolint:tagliatelle // API consistency
+	Timezone   string           `json:"timezone,omitempty"`
+	GroupBy    string           `json:"group_by,omitempty"` //nolint:tagliatelle // API consistency
+	Series     []TimelineSeries `json:"series,omitempty"`
+}
+
+// TimelineSeries is the timeline of the connections sharing one value of the group-by field,
+// for stacked charts.
+type TimelineSeries struct {
+	Key    string          `json:"key"`
+	Count  int             `json:"count"`
+	Bytes  int             `json:"bytes"`
 	Points []TimelinePoint `json:"points"`
-	Start  int64           `json:"start"`
-	End    int64           `json:"end"`
 }
 
-// UnmarshalConnection parses a JSON line into a Connection.
+// UnmarshalConnection parses a JSON line into a Connection. Fields Connection has no place
+// for, and fields of the wrong type, are kept in Extras.
 func UnmarshalConnection(data []byte) (*Connection, error) {
+	conn := &Connection{}
+	decoder := connectionDecoder{data: data}
+	if decoder.decode(conn) {
+		return conn, nil
+	}
+
+	// Damaged or unusual records: encoding/json reports what is wrong, or decodes them
 	var raw map[string]any
 	err := json.Unmarshal(data, &raw)
 	if err != nil {
 		return nil, err
 	}
 
+	return connectionFromMap(raw), nil
+}
+
+// connectionFromMap builds a Connection from decoded JSON values, keeping the fields without
+// a Connection field of their type in Extras.
+func connectionFromMap(raw map[string]any) *Connection {
 	conn := &Connection{}
 
 	parseStringFields(raw, conn)
@@ -103,7 +318,33 @@ func UnmarshalConnection(data []byte) (*Connection, error) {
 	parseFloatFields(raw, conn)
 	parseBooleanFields(raw, conn)
 
-	return conn, nil
+	for name, value := range raw {
+		if kind := connectionFieldKind(name); kind != kindOther && (value == nil || kind == valueKind(value)) {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err == nil {
+			conn.setExtra(name, encoded)
+		}
+	}
+
+	return conn
+}
+
+// valueKind returns the JSON type of a decoded value.
+func valueKind(value any) fieldKind {
+	switch value.(type) {
+	case string:
+		return kindString
+	case float64, int, int64, uint64:
+		return kindNumber
+	case bool:
+		return kindBool
+	case nil:
+		return kindNull
+	default:
+		return kindComposite
+	}
 }
 
 // parseStringFields extracts string fields from raw JSON data.
@@ -129,6 +370,12 @@ func parseStringFields(raw map[string]any, conn *Connection) {
 	if history, ok := raw["history"].(string); ok {
 		conn.History = history
 	}
+	if source, ok := raw["source_file"].(string); ok {
+		conn.SourceFile = source
+	}
+	if guess, ok := raw["service_guess"].(string); ok {
+		conn.ServiceGuess = guess
+	}
 }
 
 // parseIntegerFields extracts integer and timestamp fields from raw JSON data.
@@ -152,6 +399,9 @@ func parseTimestampAndPorts(raw map[string]any, conn *Connection) {
 	if ipProto, ok := raw["ip_proto"].(float64); ok {
 		conn.IPProtocol = int(ipProto)
 	}
+	if stitched, ok := raw["stitched"].(float64); ok {
+		conn.Stitched = int(stitched)
+	}
 }
 
 // parseByteFields extracts byte-related fields.
@@ -199,31 +449,3 @@ func parseBooleanFields(raw map[string]any, conn *Connection) {
 		conn.LocalResp = localResp
 	}
 }
-
-// IsLocalIP checks if an IP address is in local ranges.
-func IsLocalIP(ip string) bool {
-	if ip == "" {
-		return false
-	}
-
-	// Common local IP patterns
-	localPrefixes := []string{
-		"192.168.",
-		"10.",
-		"172.16.", "172.17.", "172.18.", "172.19.",
-		"172.20.", "172.21.", "172.22.", "172.23.",
-		"172.24.", "172.25.", "172.26.", "172.27.",
-		"172.28.", "172.29.", "172.30.", "172.31.",
-		"127.",
-		"fe80::",
-		"::1",
-	}
-
-	for _, prefix := range localPrefixes {
-		if len(ip) >= len(prefix) && ip[:len(prefix)] == prefix {
-			return true
-		}
-	}
-
-	return false
-}
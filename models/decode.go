@@ -0,0 +1,477 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxExactDigits = 15 // Digits of integers that convert to float64 exactly
+	maxNesting     = 64 // Depth of nested objects and arrays skipped without encoding/json
+
+	unicodeEscapeDigits = 4 // Hex digits of a \u escape
+)
+
+// fieldKind is the JSON type of a Connection field.
+type fieldKind int
+
+// JSON value types. kindOther stands for fields that aren't Connection fields.
+const (
+	kindOther fieldKind = iota
+	kindString
+	kindNumber
+	kindBool
+	kindNull
+	kindComposite // Object or array
+)
+
+// connectionFieldKind returns the JSON type of the Connection field name, or kindOther for
+// names Connection has no field for.
+func connectionFieldKind(name string) fieldKind {
+	switch name {
+	case "uid", "id.orig_h", "id.resp_h", "proto", "service", "conn_state", "history", "source_file", "service_guess":
+		return kindString
+	case "ts", "duration", "id.orig_p", "id.resp_p", "ip_proto", "orig_bytes", "resp_bytes",
+		"missed_bytes", "orig_ip_bytes", "resp_ip_bytes", "orig_pkts", "resp_pkts", "stitched":
+		return kindNumber
+	case "local_orig", "local_resp":
+		return kindBool
+	default:
+		return kindOther
+	}
+}
+
+// connectionDecoder decodes a JSON object straight into a Connection, without the map and
+// interface values encoding/json would build for it. It only accepts well-formed input,
+// leaving damaged records to encoding/json to describe; strings with escapes or non-ASCII
+// bytes are unquoted by encoding/json too.
+type connectionDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decode decodes the object into conn. Fields of the wrong type are kept in Extras like
+// unknown fields; for repeated names the last value wins. It reports false for input it
+// doesn't accept, leaving conn partially filled.
+func (d *connectionDecoder) decode(conn *Connection) bool {
+	d.skipSpace()
+	if !d.consume('{') {
+		return false
+	}
+	d.skipSpace()
+	if d.consume('}') {
+		return d.atEnd()
+	}
+
+	for {
+		d.skipSpace()
+		keyStart := d.pos
+		if !d.consume('"') || !d.skipString() {
+			return false
+		}
+		key, ok := decodeName(d.data[keyStart:d.pos])
+		if !ok {
+			return false
+		}
+		d.skipSpace()
+		if !d.consume(':') {
+			return false
+		}
+		d.skipSpace()
+
+		valueStart := d.pos
+		kind, ok := d.skipValue(0)
+		if !ok || !conn.setField(key, kind, d.data[valueStart:d.pos]) {
+			return false
+		}
+
+		d.skipSpace()
+		switch {
+		case d.consume(','):
+		case d.consume('}'):
+			return d.atEnd()
+		default:
+			return false
+		}
+	}
+}
+
+// atEnd reports whether only whitespace follows the object.
+func (d *connectionDecoder) atEnd() bool {
+	d.skipSpace()
+
+	return d.pos == len(d.data)
+}
+
+// skipSpace skips JSON whitespace.
+func (d *connectionDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips the next byte if it is c.
+func (d *connectionDecoder) consume(c byte) bool {
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+
+		return true
+	}
+
+	return false
+}
+
+// skipValue skips a value nested depth composites deep and returns its type.
+func (d *connectionDecoder) skipValue(depth int) (fieldKind, bool) {
+	if d.pos >= len(d.data) {
+		return kindOther, false
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == '"':
+		d.pos++
+
+		return kindString, d.skipString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return kindNumber, d.skipNumber()
+	case c == '{' || c == '[':
+		return kindComposite, d.skipComposite(depth)
+	case d.skipLiteral("true"), d.skipLiteral("false"):
+		return kindBool, true
+	case d.skipLiteral("null"):
+		return kindNull, true
+	default:
+		return kindOther, false
+	}
+}
+
+// skipString skips the rest of a string after its opening quote, checking its escapes.
+func (d *connectionDecoder) skipString() bool {
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		d.pos++
+		switch {
+		case c == '"':
+			return true
+		case c == '\\':
+			if !d.skipEscape() {
+				return false
+			}
+		case c < ' ':
+			return false
+		}
+	}
+
+	return false
+}
+
+// skipEscape skips the rest of an escape sequence after its backslash.
+func (d *connectionDecoder) skipEscape() bool {
+	if d.pos >= len(d.data) {
+		return false
+	}
+	c := d.data[d.pos]
+	d.pos++
+	switch c {
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		return true
+	case 'u':
+		if d.pos+unicodeEscapeDigits > len(d.data) {
+			return false
+		}
+		for _, digit := range d.data[d.pos : d.pos+unicodeEscapeDigits] {
+			if !strings.ContainsRune("0123456789abcdefABCDEF", rune(digit)) {
+				return false
+			}
+		}
+		d.pos += unicodeEscapeDigits
+
+		return true
+	default:
+		return false
+	}
+}
+
+// skipNumber skips a number, checking it follows the JSON grammar.
+func (d *connectionDecoder) skipNumber() bool {
+	d.consume('-')
+	switch {
+	case d.consume('0'):
+	case d.skipDigits() == 0:
+		return false
+	}
+	if d.consume('.') && d.skipDigits() == 0 {
+		return false
+	}
+	if d.consume('e') || d.consume('E') {
+		if !d.consume('+') {
+			d.consume('-')
+		}
+		if d.skipDigits() == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// skipDigits skips decimal digits and returns how many there were.
+func (d *connectionDecoder) skipDigits() int {
+	start := d.pos
+	for d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9' {
+		d.pos++
+	}
+
+	return d.pos - start
+}
+
+// skipComposite skips an object or array, including everything nested in it. Deeper nesting
+// than maxNesting is left to encoding/json.
+func (d *connectionDecoder) skipComposite(depth int) bool {
+	if depth > maxNesting {
+		return false
+	}
+	object := d.data[d.pos] == '{'
+	closing := byte(']')
+	if object {
+		closing = '}'
+	}
+	d.pos++
+	d.skipSpace()
+	if d.consume(closing) {
+		return true
+	}
+
+	for {
+		d.skipSpace()
+		if object && !d.skipMemberName() {
+			return false
+		}
+		if _, ok := d.skipValue(depth + 1); !ok {
+			return false
+		}
+		d.skipSpace()
+		if !d.consume(',') {
+			return d.consume(closing)
+		}
+	}
+}
+
+// skipMemberName skips the name of an object member and its colon.
+func (d *connectionDecoder) skipMemberName() bool {
+	start := d.pos
+	if !d.consume('"') || !d.skipString() {
+		return false
+	}
+	if _, ok := decodeName(d.data[start:d.pos]); !ok {
+		return false
+	}
+	d.skipSpace()
+	if !d.consume(':') {
+		return false
+	}
+	d.skipSpace()
+
+	return true
+}
+
+// skipLiteral skips literal if the input continues with it.
+func (d *connectionDecoder) skipLiteral(literal string) bool {
+	if bytes.HasPrefix(d.data[d.pos:], []byte(literal)) {
+		d.pos += len(literal)
+
+		return true
+	}
+
+	return false
+}
+
+// decodeName returns the bytes of a quoted member name, without copying names that have no
+// escapes or non-ASCII bytes.
+func decodeName(token []byte) ([]byte, bool) {
+	inner := token[1 : len(token)-1]
+	if isPlain(inner) {
+		return inner, true
+	}
+	value, ok := decodeString(token)
+
+	return []byte(value), ok
+}
+
+// isPlain reports whether string content has no escapes or non-ASCII bytes, so it is its own
+// value.
+func isPlain(inner []byte) bool {
+	for _, c := range inner {
+		if c == '\\' || c >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeString returns the value of a quoted string token. Tokens with escapes or non-ASCII
+// bytes are decoded by encoding/json, which validates and normalizes them.
+func decodeString(token []byte) (string, bool) {
+	if inner := token[1 : len(token)-1]; isPlain(inner) {
+		return string(inner), true
+	}
+
+	var value string
+	err := json.Unmarshal(token, &value)
+
+	return value, err == nil
+}
+
+// decodeNumber returns the value of a number token as encoding/json would decode it into an
+// interface value.
+func decodeNumber(token []byte) (float64, bool) {
+	if len(token) <= maxExactDigits {
+		value := 0
+		for _, c := range token {
+			if c < '0' || c > '9' {
+				value = -1
+
+				break
+			}
+			value = value*10 + int(c-'0') //nolint:mnd // Decimal digits
+		}
+		if value >= 0 {
+			return float64(value), true
+		}
+	}
+
+	value, err := strconv.ParseFloat(string(token), 64)
+
+	return value, err == nil
+}
+
+// setField stores the value of the named field, given as its JSON token. Values of other
+// fields, and values of the wrong type, go to Extras; the latter and null reset the field.
+// The name is only copied for Extras.
+func (c *Connection) setField(name []byte, kind fieldKind, token []byte) bool {
+	expected := connectionFieldKind(string(name))
+	if expected == kindOther {
+		c.setExtra(string(name), token)
+
+		return true
+	}
+	if kind != expected && kind != kindNull {
+		c.setExtra(string(name), token)
+	} else if c.Extras != nil {
+		c.deleteExtra(string(name))
+	}
+
+	switch { // Values of the wrong type reset the field, as a later null does
+	case expected == kindString:
+		value, ok := "", true
+		if kind == kindString {
+			value, ok = decodeString(token)
+		}
+		c.setString(string(name), value)
+
+		return ok
+	case expected == kindNumber:
+		value, ok := 0.0, true
+		if kind == kindNumber {
+			value, ok = decodeNumber(token)
+		}
+		c.setNumber(string(name), value)
+
+		return ok
+	default:
+		c.setBool(string(name), kind == kindBool && token[0] == 't')
+
+		return true
+	}
+}
+
+// setExtra keeps a copy of the value of a field Connection has no place for.
+func (c *Connection) setExtra(name string, token []byte) {
+	if c.Extras == nil {
+		c.Extras = make(map[string]json.RawMessage)
+	}
+	c.Extras[name] = bytes.Clone(token)
+}
+
+// deleteExtra drops an extra field replaced by a later value of the right type.
+func (c *Connection) deleteExtra(name string) {
+	delete(c.Extras, name)
+	if len(c.Extras) == 0 {
+		c.Extras = nil
+	}
+}
+
+// setString sets the named string field.
+func (c *Connection) setString(name, value string) {
+	switch name {
+	case "uid":
+		c.UID = value
+	case "id.orig_h":
+		c.OrigHost = value
+	case "id.resp_h":
+		c.RespHost = value
+	case "proto":
+		c.Protocol = value
+	case "service":
+		c.Service = value
+	case "conn_state":
+		c.ConnState = value
+	case "history":
+		c.History = value
+	case "source_file":
+		c.SourceFile = value
+	case "service_guess":
+		c.ServiceGuess = value
+	}
+}
+
+// setNumber sets the named numeric field, truncating it for integer fields.
+func (c *Connection) setNumber(name string, value float64) {
+	switch name {
+	case "ts":
+		c.Timestamp = value
+	case "duration":
+		c.Duration = value
+	case "id.orig_p":
+		c.OrigPort = int(value)
+	case "id.resp_p":
+		c.RespPort = int(value)
+	case "ip_proto":
+		c.IPProtocol = int(value)
+	case "orig_bytes":
+		c.OrigBytes = int(value)
+	case "resp_bytes":
+		c.RespBytes = int(value)
+	case "missed_bytes":
+		c.MissedBytes = int(value)
+	case "orig_ip_bytes":
+		c.OrigIPBytes = int(value)
+	case "resp_ip_bytes":
+		c.RespIPBytes = int(value)
+	case "orig_pkts":
+		c.OrigPackets = int(value)
+	case "resp_pkts":
+		c.RespPackets = int(value)
+	case "stitched":
+		c.Stitched = int(value)
+	}
+}
+
+// setBool sets the named boolean field.
+func (c *Connection) setBool(name string, value bool) {
+	switch name {
+	case "local_orig":
+		c.LocalOrig = value
+	case "local_resp":
+		c.LocalResp = value
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnknownField is returned when an export names a field connections don't have.
+var ErrUnknownField = errors.New("unknown field")
+
+// ConnLogFields returns the fields of a Zeek conn.log record in the order Zeek writes them.
+func ConnLogFields() []string {
+	return []string{
+		"ts", "uid", "id.orig_h", "id.orig_p", "id.resp_h", "id.resp_p", "proto", "service",
+		"duration", "orig_bytes", "resp_bytes", "conn_state", "local_orig", "local_resp",
+		"missed_bytes", "history", "orig_pkts", "orig_ip_bytes", "resp_pkts", "resp_ip_bytes",
+		"ip_proto",
+	}
+}
+
+// annotationColumns returns the names of the CSV columns of connection annotations.
+func annotationColumns() []string {
+	return []string{"tags", "note", "orig_tags", "orig_note", "resp_tags", "resp_note"}
+}
+
+// appendAnnotationValues appends the annotation columns of a connection to record, with tags
+// comma-separated as Zeek writes sets.
+func appendAnnotationValues(record []string, annotations *ConnectionAnnotations) []string {
+	if annotations == nil {
+		annotations = &ConnectionAnnotations{}
+	}
+	for _, annotation := range []*Annotation{annotations.Connection, annotations.Orig, annotations.Resp} {
+		if annotation == nil {
+			record = append(record, "", "")
+
+			continue
+		}
+		record = append(record, strings.Join(annotation.Tags, ","), annotation.Note)
+	}
+
+	return record
+}
+
+// WriteCSV writes connections as CSV with a header row of the given fields, or of all
+// conn.log fields when none are given. With an annotator, the tags and notes of each
+// connection and its hosts follow in six more columns.
+func WriteCSV(w io.Writer, connections []Connection, fields []string, annotate Annotator) error {
+	if len(fields) == 0 {
+		fields = ConnLogFields()
+	}
+
+	header := make([]string, len(fields))
+	accessors := make([]StringAccessor, len(fields))
+	for i, field := range fields {
+		accessor, exists := StringFieldAccessor(field)
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+		header[i], accessors[i] = CanonicalFieldName(field), accessor
+	}
+	if annotate != nil {
+		header = append(header, annotationColumns()...)
+	}
+
+	writer := csv.NewWriter(w)
+	err := writer.Write(header)
+	if err != nil {
+		return fmt.Errorf("failed to write csv header: %w", err)
+	}
+
+	record := make([]string, len(fields), len(header))
+	for i := range connections {
+		for j, accessor := range accessors {
+			record[j] = accessor(&connections[i])
+		}
+		if annotate != nil {
+			record = appendAnnotationValues(record[:len(fields)], annotate(&connections[i]))
+		}
+		err := writer.Write(record)
+		if err != nil {
+			return fmt.Errorf("failed to write csv record: %w", err)
+		}
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+
+	return nil
+}
+
+// annotatedRecord is a connection in Zeek's conn.log layout with its annotations.
+type annotatedRecord struct {
+	*Connection
+
+	Annotations *ConnectionAnnotations `json:"annotations,omitempty"`
+}
+
+// WriteNDJSON writes connections as newline-delimited JSON in Zeek's conn.log layout, or as
+// objects of the given fields only. With an annotator, records of annotated connections
+// carry their annotations.
+func WriteNDJSON(w io.Writer, connections []Connection, fields []string, annotate Annotator) error {
+	names := make([]string, len(fields))
+	accessors := make([]ValueAccessor, len(fields))
+	for i, field := range fields {
+		accessor, exists := ValueFieldAccessor(field)
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+		names[i], accessors[i] = CanonicalFieldName(field), accessor
+	}
+
+	encoder := json.NewEncoder(w)
+	for i := range connections {
+		var annotations *ConnectionAnnotations
+		if annotate != nil {
+			annotations = annotate(&connections[i])
+		}
+
+		var record any = &connections[i]
+		switch {
+		case len(fields) > 0:
+			projected := make(map[string]any, len(fields)+1)
+			for j, accessor := range accessors {
+				projected[names[j]] = accessor(&connections[i])
+			}
+			if annotations != nil {
+				projected["annotations"] = annotations
+			}
+			record = projected
+		case annotations != nil:
+			record = annotatedRecord{Connection: &connections[i], Annotations: annotations}
+		}
+
+		err := encoder.Encode(record)
+		if err != nil {
+			return fmt.Errorf("failed to write ndjson record: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// StringAccessor extracts a connection field formatted as a string.
+type StringAccessor func(conn *Connection) string
+
+// NumericAccessor extracts a numeric connection field.
+type NumericAccessor func(conn *Connection) float64
+
+// ValueAccessor extracts a connection field with its JSON type.
+type ValueAccessor func(conn *Connection) any
+
+// CanonicalFieldName maps field aliases (e.g. "resp_port", "resp_h") to Zeek field names.
+func CanonicalFieldName(name string) string {
+	aliases := map[string]string{
+		"orig_h":    "id.orig_h",
+		"orig_host": "id.orig_h",
+		"src":       "id.orig_h",
+		"orig_p":    "id.orig_p",
+		"orig_port": "id.orig_p",
+		"resp_h":    "id.resp_h",
+		"resp_host": "id.resp_h",
+		"dst":       "id.resp_h",
+		"resp_p":    "id.resp_p",
+		"resp_port": "id.resp_p",
+		"port":      "id.resp_p",
+		"protocol":  "proto",
+		"state":     "conn_state",
+		"packets":   "pkts",
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if canonical, exists := aliases[name]; exists {
+		return canonical
+	}
+
+	return name
+}
+
+// StringFieldAccessor returns an accessor for any connection field, formatting numbers as strings.
+func StringFieldAccessor(name string) (StringAccessor, bool) {
+	name = CanonicalFieldName(name)
+
+	stringFields := map[string]StringAccessor{
+		"uid":           func(c *Connection) string { return c.UID },
+		"id.orig_h":     func(c *Connection) string { return c.OrigHost },
+		"id.resp_h":     func(c *Connection) string { return c.RespHost },
+		"proto":         func(c *Connection) string { return c.Protocol },
+		"service":       func(c *Connection) string { return c.Service },
+		"conn_state":    func(c *Connection) string { return c.ConnState },
+		"history":       func(c *Connection) string { return c.History },
+		"local_orig":    func(c *Connection) string { return strconv.FormatBool(c.LocalOrig) },
+		"local_resp":    func(c *Connection) string { return strconv.FormatBool(c.LocalResp) },
+		"source_file":   func(c *Connection) string { return c.SourceFile },
+		"service_guess": func(c *Connection) string { return c.ServiceGuess },
+		"dataset":       func(c *Connection) string { return c.Dataset },
+		"history_flags": func(c *Connection) string { return strings.Join(c.HistoryFlags(), ",") },
+	}
+	for _, flag := range HistoryFlagNames() {
+		stringFields[flag] = func(c *Connection) string { return strconv.FormatBool(c.HasHistoryFlag(flag)) }
+	}
+
+	if accessor, exists := stringFields[name]; exists {
+		return accessor, true
+	}
+
+	numeric, exists := NumericFieldAccessor(name)
+	if !exists {
+		return nil, false
+	}
+
+	return func(c *Connection) string {
+		return strconv.FormatFloat(numeric(c), 'f', -1, 64)
+	}, true
+}
+
+// NumericFieldAccessor returns an accessor for a numeric connection field.
+// The derived fields "bytes" and "pkts" sum both directions.
+func NumericFieldAccessor(name string) (NumericAccessor, bool) {
+	numericFields := map[string]NumericAccessor{
+		"ts":            func(c *Connection) float64 { return c.Timestamp },
+		"id.orig_p":     func(c *Connection) float64 { return float64(c.OrigPort) },
+		"id.resp_p":     func(c *Connection) float64 { return float64(c.RespPort) },
+		"duration":      func(c *Connection) float64 { return c.Duration },
+		"orig_bytes":    func(c *Connection) float64 { return float64(c.OrigBytes) },
+		"resp_bytes":    func(c *Connection) float64 { return float64(c.RespBytes) },
+		"bytes":         func(c *Connection) float64 { return float64(c.TotalBytes()) },
+		"missed_bytes":  func(c *Connection) float64 { return float64(c.MissedBytes) },
+		"orig_pkts":     func(c *Connection) float64 { return float64(c.OrigPackets) },
+		"resp_pkts":     func(c *Connection) float64 { return float64(c.RespPackets) },
+		"pkts":          func(c *Connection) float64 { return float64(c.OrigPackets + c.RespPackets) },
+		"orig_ip_bytes": func(c *Connection) float64 { return float64(c.OrigIPBytes) },
+		"resp_ip_bytes": func(c *Connection) float64 { return float64(c.RespIPBytes) },
+		"ip_proto":      func(c *Connection) float64 { return float64(c.IPProtocol) },
+		"stitched":      func(c *Connection) float64 { return float64(c.Stitched) },
+	}
+
+	accessor, exists := numericFields[CanonicalFieldName(name)]
+
+	return accessor, exists
+}
+
+// ValueFieldAccessor returns an accessor for any connection field that keeps numbers,
+// booleans, and the history_flags list typed, for projecting connections onto a subset of
+// their fields.
+func ValueFieldAccessor(name string) (ValueAccessor, bool) {
+	switch canonical := CanonicalFieldName(name); {
+	case canonical == "local_orig":
+		return func(c *Connection) any { return c.LocalOrig }, true
+	case canonical == "local_resp":
+		return func(c *Connection) any { return c.LocalResp }, true
+	case canonical == "history_flags":
+		return func(c *Connection) any { return c.HistoryFlags() }, true
+	case slices.Contains(HistoryFlagNames(), canonical):
+		return func(c *Connection) any { return c.HasHistoryFlag(canonical) }, true
+	}
+
+	if numeric, exists := NumericFieldAccessor(name); exists {
+		return func(c *Connection) any { return numeric(c) }, true
+	}
+
+	accessor, exists := StringFieldAccessor(name)
+	if !exists {
+		return nil, false
+	}
+
+	return func(c *Connection) any { return accessor(c) }, true
+}
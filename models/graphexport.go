@@ -0,0 +1,387 @@
+package models
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	graphMLNamespace = "http://graphml.graphdrawing.org/xmlns" // GraphML 1.0 schema
+	gexfNamespace    = "http://gexf.net/1.3"                   // GEXF 1.3 schema
+	gexfVersion      = "1.3"
+	graphCreator     = "zeek-viz"
+)
+
+// graphAttribute is a node or edge property carried into exported graph formats. Types are
+// GraphML's; GEXF and DOT map them to their own.
+type graphAttribute[T any] struct {
+	name  string
+	kind  string // string, int, long, double, or boolean
+	value func(item *T) string
+}
+
+// nodeAttributes returns the node properties exported graphs carry.
+func nodeAttributes() []graphAttribute[Node] {
+	return []graphAttribute[Node]{
+		{"hostname", "string", func(n *Node) string { return n.Hostname }},
+		{"connections", "int", func(n *Node) string { return strconv.Itoa(n.Connections) }},
+		{"total_bytes", "long", func(n *Node) string { return strconv.Itoa(n.TotalBytes) }},
+		{"is_local", "boolean", func(n *Node) string { return strconv.FormatBool(n.IsLocal) }},
+		{"risk_score", "double", func(n *Node) string { return strconv.FormatFloat(n.RiskScore, 'f', -1, 64) }},
+		{"first_seen", "double", func(n *Node) string { return strconv.FormatFloat(n.FirstSeen, 'f', -1, 64) }},
+		{"last_seen", "double", func(n *Node) string { return strconv.FormatFloat(n.LastSeen, 'f', -1, 64) }},
+		{"country", "string", func(n *Node) string { return n.Country }},
+		{"asn", "long", func(n *Node) string {
+			if n.ASN == 0 {
+				return ""
+			}
+
+			return strconv.FormatUint(uint64(n.ASN), 10)
+		}},
+		{"threat", "string", func(n *Node) string {
+			if n.Threat == nil {
+				return ""
+			}
+
+			return n.Threat.Indicator
+		}},
+		{"tags", "string", func(n *Node) string {
+			if n.Annotation == nil {
+				return ""
+			}
+
+			return strings.Join(n.Annotation.Tags, ",")
+		}},
+		{"note", "string", func(n *Node) string {
+			if n.Annotation == nil {
+				return ""
+			}
+
+			return n.Annotation.Note
+		}},
+		{"degree", "int", func(n *Node) string {
+			if n.Metrics == nil {
+				return ""
+			}
+
+			return strconv.Itoa(n.Metrics.Degree)
+		}},
+		{"betweenness", "double", func(n *Node) string {
+			if n.Metrics == nil {
+				return ""
+			}
+
+			return strconv.FormatFloat(n.Metrics.Betweenness, 'f', -1, 64)
+		}},
+		{"community", "int", func(n *Node) string {
+			if n.Metrics == nil {
+				return ""
+			}
+
+			return strconv.Itoa(n.Metrics.Community)
+		}},
+		{"x", "double", func(n *Node) string { return layoutCoordinate(n, n.X) }},
+		{"y", "double", func(n *Node) string { return layoutCoordinate(n, n.Y) }},
+	}
+}
+
+// layoutCoordinate formats a coordinate of a node placed by a server-side layout, or returns
+// "" for graphs without one.
+func layoutCoordinate(n *Node, value float64) string {
+	if n.X == 0 && n.Y == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// edgeAttributes returns the edge properties exported graphs carry.
+func edgeAttributes() []graphAttribute[Edge] {
+	return []graphAttribute[Edge]{
+		{"protocol", "string", func(e *Edge) string { return e.Protocol }},
+		{"service", "string", func(e *Edge) string { return e.Service }},
+		{"count", "int", func(e *Edge) string { return strconv.Itoa(e.Count) }},
+		{"total_bytes", "long", func(e *Edge) string { return strconv.Itoa(e.TotalBytes) }},
+		{"orig_bytes", "long", func(e *Edge) string { return strconv.Itoa(e.OrigBytes) }},
+		{"resp_bytes", "long", func(e *Edge) string { return strconv.Itoa(e.RespBytes) }},
+		{"port", "int", func(e *Edge) string {
+			if e.Port == 0 {
+				return ""
+			}
+
+			return strconv.Itoa(e.Port)
+		}},
+		{"reverse_count", "int", func(e *Edge) string {
+			if e.ReverseCount == 0 {
+				return ""
+			}
+
+			return strconv.Itoa(e.ReverseCount)
+		}},
+		{"first_seen", "double", func(e *Edge) string { return strconv.FormatFloat(e.FirstSeen, 'f', -1, 64) }},
+		{"last_seen", "double", func(e *Edge) string { return strconv.FormatFloat(e.LastSeen, 'f', -1, 64) }},
+	}
+}
+
+// graphMLDocument is the root element of a GraphML file.
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// graphMLKey declares a GraphML data attribute.
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+// graphMLGraph holds the nodes and edges of a GraphML file.
+type graphMLGraph struct {
+	ID          string           `xml:"id,attr"`
+	EdgeDefault string           `xml:"edgedefault,attr"`
+	Nodes       []graphMLElement `xml:"node"`
+	Edges       []graphMLElement `xml:"edge"`
+}
+
+// graphMLElement is a GraphML node or edge with its data values.
+type graphMLElement struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr,omitempty"`
+	Target string        `xml:"target,attr,omitempty"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// graphMLData is one attribute value of a GraphML node or edge.
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteGraphML writes the graph as GraphML, as read by yEd, Gephi, and NetworkX.
+func WriteGraphML(w io.Writer, graph NetworkGraph) error {
+	nodeAttrs, edgeAttrs := nodeAttributes(), edgeAttributes()
+	document := graphMLDocument{
+		XMLNS: graphMLNamespace,
+		Keys:  []graphMLKey{{ID: "n_label", For: "node", Name: "label", Type: "string"}},
+		Graph: graphMLGraph{ID: "G", EdgeDefault: "directed"},
+	}
+	for _, attr := range nodeAttrs {
+		document.Keys = append(document.Keys, graphMLKey{ID: "n_" + attr.name, For: "node", Name: attr.name, Type: attr.kind})
+	}
+	for _, attr := range edgeAttrs {
+		document.Keys = append(document.Keys, graphMLKey{ID: "e_" + attr.name, For: "edge", Name: attr.name, Type: attr.kind})
+	}
+
+	for i := range graph.Nodes {
+		node := &graph.Nodes[i]
+		element := graphMLElement{ID: node.ID, Data: []graphMLData{{Key: "n_label", Value: node.Label}}}
+		for _, attr := range nodeAttrs {
+			if value := attr.value(node); value != "" {
+				element.Data = append(element.Data, graphMLData{Key: "n_" + attr.name, Value: value})
+			}
+		}
+		document.Graph.Nodes = append(document.Graph.Nodes, element)
+	}
+	for i := range graph.Edges {
+		edge := &graph.Edges[i]
+		element := graphMLElement{ID: "e" + strconv.Itoa(i), Source: edge.Source, Target: edge.Target}
+		for _, attr := range edgeAttrs {
+			if value := attr.value(edge); value != "" {
+				element.Data = append(element.Data, graphMLData{Key: "e_" + attr.name, Value: value})
+			}
+		}
+		document.Graph.Edges = append(document.Graph.Edges, element)
+	}
+
+	return writeXML(w, document)
+}
+
+// gexfDocument is the root element of a GEXF file.
+type gexfDocument struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+// gexfMeta describes who wrote a GEXF file.
+type gexfMeta struct {
+	Creator string `xml:"creator"`
+}
+
+// gexfGraph holds the attribute declarations, nodes, and edges of a GEXF file.
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfElement    `xml:"nodes>node"`
+	Edges           []gexfElement    `xml:"edges>edge"`
+}
+
+// gexfAttributes declares the attributes of the nodes or edges of a GEXF file.
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+// gexfAttribute declares one GEXF attribute.
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+// gexfElement is a GEXF node or edge with its attribute values.
+type gexfElement struct {
+	ID        string          `xml:"id,attr"`
+	Label     string          `xml:"label,attr,omitempty"`
+	Source    string          `xml:"source,attr,omitempty"`
+	Target    string          `xml:"target,attr,omitempty"`
+	Weight    string          `xml:"weight,attr,omitempty"`
+	AttValues []gexfAttrValue `xml:"attvalues>attvalue"`
+}
+
+// gexfAttrValue is one attribute value of a GEXF node or edge.
+type gexfAttrValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// gexfType maps a GraphML attribute type to its GEXF name.
+func gexfType(kind string) string {
+	if kind == "int" {
+		return "integer"
+	}
+
+	return kind
+}
+
+// WriteGEXF writes the graph as GEXF 1.3, Gephi's native format. Edge weights are the
+// connection counts.
+func WriteGEXF(w io.Writer, graph NetworkGraph) error {
+	nodeAttrs, edgeAttrs := nodeAttributes(), edgeAttributes()
+	nodeDecl := gexfAttributes{Class: "node"}
+	for _, attr := range nodeAttrs {
+		nodeDecl.Attributes = append(nodeDecl.Attributes, gexfAttribute{ID: attr.name, Title: attr.name, Type: gexfType(attr.kind)})
+	}
+	edgeDecl := gexfAttributes{Class: "edge"}
+	for _, attr := range edgeAttrs {
+		edgeDecl.Attributes = append(edgeDecl.Attributes, gexfAttribute{ID: attr.name, Title: attr.name, Type: gexfType(attr.kind)})
+	}
+
+	document := gexfDocument{
+		XMLNS:   gexfNamespace,
+		Version: gexfVersion,
+		Meta:    gexfMeta{Creator: graphCreator},
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes:      []gexfAttributes{nodeDecl, edgeDecl},
+		},
+	}
+	for i := range graph.Nodes {
+		node := &graph.Nodes[i]
+		element := gexfElement{ID: node.ID, Label: node.Label}
+		for _, attr := range nodeAttrs {
+			if value := attr.value(node); value != "" {
+				element.AttValues = append(element.AttValues, gexfAttrValue{For: attr.name, Value: value})
+			}
+		}
+		document.Graph.Nodes = append(document.Graph.Nodes, element)
+	}
+	for i := range graph.Edges {
+		edge := &graph.Edges[i]
+		element := gexfElement{
+			ID:     strconv.Itoa(i),
+			Source: edge.Source,
+			Target: edge.Target,
+			Weight: strconv.Itoa(edge.Count),
+		}
+		for _, attr := range edgeAttrs {
+			if value := attr.value(edge); value != "" {
+				element.AttValues = append(element.AttValues, gexfAttrValue{For: attr.name, Value: value})
+			}
+		}
+		document.Graph.Edges = append(document.Graph.Edges, element)
+	}
+
+	return writeXML(w, document)
+}
+
+// writeXML writes an XML declaration followed by the indented document.
+func writeXML(w io.Writer, document any) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return fmt.Errorf("failed to write xml header: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return fmt.Errorf("failed to write xml: %w", err)
+	}
+
+	_, err = io.WriteString(w, "\n")
+	if err != nil {
+		return fmt.Errorf("failed to write xml: %w", err)
+	}
+
+	return nil
+}
+
+// WriteDOT writes the graph in Graphviz DOT. Node and edge properties become attributes;
+// edges are labeled with their protocol, service, and port.
+func WriteDOT(w io.Writer, graph NetworkGraph) error {
+	var out strings.Builder
+	out.WriteString("digraph zeek {\n")
+	out.WriteString("  node [shape=ellipse];\n")
+
+	nodeAttrs, edgeAttrs := nodeAttributes(), edgeAttributes()
+	for i := range graph.Nodes {
+		node := &graph.Nodes[i]
+		attrs := []string{"label=" + quoteDOT(node.Label)}
+		for _, attr := range nodeAttrs {
+			if value := attr.value(node); value != "" {
+				attrs = append(attrs, attr.name+"="+quoteDOT(value))
+			}
+		}
+		fmt.Fprintf(&out, "  %s [%s];\n", quoteDOT(node.ID), strings.Join(attrs, ", "))
+	}
+	for i := range graph.Edges {
+		edge := &graph.Edges[i]
+		label := edge.Protocol
+		if edge.Service != "" {
+			label += "/" + edge.Service
+		}
+		if edge.Port != 0 {
+			label += ":" + strconv.Itoa(edge.Port)
+		}
+		attrs := []string{"label=" + quoteDOT(label)}
+		for _, attr := range edgeAttrs {
+			if value := attr.value(edge); value != "" {
+				attrs = append(attrs, attr.name+"="+quoteDOT(value))
+			}
+		}
+		fmt.Fprintf(&out, "  %s -> %s [%s];\n", quoteDOT(edge.Source), quoteDOT(edge.Target), strings.Join(attrs, ", "))
+	}
+	out.WriteString("}\n")
+
+	_, err := io.WriteString(w, out.String())
+	if err != nil {
+		return fmt.Errorf("failed to write dot: %w", err)
+	}
+
+	return nil
+}
+
+// quoteDOT returns value as a double-quoted DOT string.
+func quoteDOT(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
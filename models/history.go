@@ -0,0 +1,177 @@
+package models
+
+import (
+	"slices"
+	"strings"
+)
+
+const historyRepeatBase = 10 // Repeats of c, g, t, and w are logged at 1, 10, 100, ... occurrences
+
+// HistoryEvent is one flag of a Zeek connection history string.
+type HistoryEvent struct {
+	Code        string `json:"code"`                // Letter as it appears in the history
+	Direction   string `json:"direction,omitempty"` // originator or responder; empty for ^
+	Event       string `json:"event"`
+	Description string `json:"description"`
+	AtLeast     int    `json:"at_least,omitempty"` //nolint:tagliatelle // API consistency
+}
+
+// historyFlags returns the event name and description of each history letter, in lower case.
+func historyFlags() map[byte][2]string {
+	return map[byte][2]string{
+		's': {"syn", "SYN without the ACK bit set"},
+		'h': {"syn_ack", "SYN+ACK (handshake)"},
+		'a': {"ack", "Pure ACK"},
+		'd': {"data", "Packet with payload"},
+		'f': {"fin", "FIN"},
+		'r': {"rst", "RST"},
+		'c': {"bad_checksum", "Packet with a bad checksum"},
+		'g': {"content_gap", "Content gap"},
+		't': {"retransmission", "Retransmitted payload"},
+		'w': {"zero_window", "Zero window advertisement"},
+		'i': {"inconsistent", "Inconsistent packet, such as FIN+RST"},
+		'q': {"multi_flag", "Multi-flag packet, such as SYN+FIN or SYN+RST"},
+	}
+}
+
+// DecodeHistory expands a Zeek history string into its events. Upper-case letters are sent by
+// the originator and lower-case ones by the responder. Zeek logs c, g, t, and w again each time
+// their count reaches the next power of ten, so repeats carry the count they stand for.
+func DecodeHistory(history string) []HistoryEvent {
+	flags := historyFlags()
+	events := make([]HistoryEvent, 0, len(history))
+	repeats := make(map[byte]int)
+	for i := range len(history) {
+		code := history[i]
+		if code == '^' {
+			events = append(events, HistoryEvent{
+				Code:        "^",
+				Event:       "flipped",
+				Description: "Direction flipped: Zeek swapped originator and responder",
+			})
+
+			continue
+		}
+
+		lower := strings.ToLower(string(code))[0]
+		flag, known := flags[lower]
+		if !known {
+			events = append(events, HistoryEvent{Code: string(code), Event: "unknown", Description: "Unknown history flag"})
+
+			continue
+		}
+
+		event := HistoryEvent{Code: string(code), Direction: "responder", Event: flag[0]}
+		sender := "Responder"
+		if code != lower {
+			event.Direction, sender = "originator", "Originator"
+		}
+		event.Description = sender + ": " + flag[1]
+		if strings.IndexByte("cgtw", lower) >= 0 {
+			count := 1
+			for range repeats[code] {
+				count *= historyRepeatBase
+			}
+			repeats[code]++
+			if count > 1 {
+				event.AtLeast = count
+			}
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// Handshake and teardown anomalies derived from a connection's history. Responses list them
+// in history_flags, and q reads each as a boolean field.
+const (
+	HistoryHalfOpen       = "half_open"       // The originator's SYN was never answered with a SYN+ACK
+	HistoryMidstream      = "midstream"       // Neither SYN was seen: the capture joined an established connection
+	HistoryNoData         = "no_data"         // No packet with payload in either direction
+	HistoryResetMidstream = "reset_midstream" // RST after payload was exchanged, without a FIN before it
+	HistoryContentGap     = "content_gap"     // Payload missing from the capture
+	HistoryRetransmitted  = "retransmitted"   // Payload sent again
+	HistoryZeroWindow     = "zero_window"     // A receiver advertised a zero window
+	HistoryFlipped        = "flipped"         // Zeek swapped originator and responder
+)
+
+// HistoryFlagNames returns the history flags in the order responses list them.
+func HistoryFlagNames() []string {
+	return []string{
+		HistoryHalfOpen, HistoryMidstream, HistoryNoData, HistoryResetMidstream,
+		HistoryContentGap, HistoryRetransmitted, HistoryZeroWindow, HistoryFlipped,
+	}
+}
+
+// HistoryFlags returns the history flags of a connection, empty rather than nil without any.
+// The handshake flags only apply to TCP; connections without a history have none.
+func (c *Connection) HistoryFlags() []string {
+	set := historyFlagSet(c.Protocol, c.History)
+	flags := []string{}
+	for i, name := range HistoryFlagNames() {
+		if set&(1<<i) != 0 {
+			flags = append(flags, name)
+		}
+	}
+
+	return flags
+}
+
+// HasHistoryFlag reports whether a connection has the named history flag.
+func (c *Connection) HasHistoryFlag(name string) bool {
+	i := slices.Index(HistoryFlagNames(), name)
+
+	return i >= 0 && historyFlagSet(c.Protocol, c.History)&(1<<i) != 0
+}
+
+// historyFlagSet returns the history flags of a connection as bits in HistoryFlagNames order.
+func historyFlagSet(protocol, history string) uint {
+	if history == "" {
+		return 0
+	}
+
+	tcp := protocol == "tcp"
+	var handshake, data, fin, resetMidstream bool
+	var set uint
+	for i := range len(history) {
+		switch history[i] {
+		case 'S', 's', 'H', 'h':
+			handshake = true
+		case 'D', 'd':
+			data = true
+		case 'F', 'f':
+			fin = true
+		case 'R', 'r':
+			resetMidstream = resetMidstream || (data && !fin)
+		case 'G', 'g':
+			set |= historyBit(HistoryContentGap)
+		case 'T', 't':
+			set |= historyBit(HistoryRetransmitted)
+		case 'W', 'w':
+			set |= historyBit(HistoryZeroWindow)
+		case '^':
+			set |= historyBit(HistoryFlipped)
+		}
+	}
+
+	if tcp && strings.IndexByte(history, 'S') >= 0 && strings.IndexByte(history, 'h') < 0 {
+		set |= historyBit(HistoryHalfOpen)
+	}
+	if tcp && !handshake {
+		set |= historyBit(HistoryMidstream)
+	}
+	if !data {
+		set |= historyBit(HistoryNoData)
+	}
+	if tcp && resetMidstream {
+		set |= historyBit(HistoryResetMidstream)
+	}
+
+	return set
+}
+
+// historyBit returns the bit of a history flag in historyFlagSet.
+func historyBit(name string) uint {
+	return 1 << slices.Index(HistoryFlagNames(), name)
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	hllPrecision = 14                // Number of index bits
+	hllRegisters = 1 << hllPrecision // Number of registers (16384)
+	hllAlphaBase = 0.7213            // Bias correction constant numerator
+	hllAlphaDiv  = 1.079             // Bias correction constant denominator term
+	hllSmallMult = 2.5               // Threshold multiplier for linear counting
+	mix64Const1  = 0xff51afd7ed558ccd
+	mix64Const2  = 0xc4ceb9fe1a85ec53
+	mix64Shift   = 33
+)
+
+// HyperLogLog is a cardinality sketch used to approximate unique value counts
+// without keeping every value in memory.
+type HyperLogLog struct {
+	registers []uint8
+}
+
+// NewHyperLogLog creates an empty HyperLogLog sketch.
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{
+		registers: make([]uint8, hllRegisters),
+	}
+}
+
+// Add records a value in the sketch.
+func (h *HyperLogLog) Add(value string) {
+	hash := hashString(value)
+	index := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1) //nolint:gosec // Rank is at most 51
+
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Merge folds another sketch into this one.
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other == nil {
+		return
+	}
+
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+// Count returns the estimated number of distinct values added to the sketch.
+func (h *HyperLogLog) Count() int {
+	m := float64(hllRegisters)
+	alpha := hllAlphaBase / (1 + hllAlphaDiv/m)
+
+	var sum float64
+	var zeros int
+	for _, rank := range h.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+
+	// Use linear counting for small cardinalities where the raw estimate is biased
+	if estimate <= hllSmallMult*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(math.Round(estimate))
+}
+
+// hashString returns a well-mixed 64-bit hash of a string.
+func hashString(value string) uint64 {
+	hasher := fnv.New64a()
+	_, _ = hasher.Write([]byte(value))
+	hash := hasher.Sum64()
+
+	// Finalize with the murmur3 mixer to improve bit distribution
+	hash ^= hash >> mix64Shift
+	hash *= mix64Const1
+	hash ^= hash >> mix64Shift
+	hash *= mix64Const2
+	hash ^= hash >> mix64Shift
+
+	return hash
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	liveHistorySec = 3600 // Seconds of per-second history kept for rolling windows (1h)
+	liveTopTalkers = 10   // Number of top talkers reported per window
+)
+
+// liveBucket aggregates the connections ingested during one second.
+type liveBucket struct {
+	second      int64
+	connections int
+	bytes       int
+	talkers     map[string]int // Bytes by host
+}
+
+// LiveWindow is a rolling aggregate over a recent time window.
+type LiveWindow struct {
+	Window         string      `json:"window"`
+	Seconds        int         `json:"seconds"`
+	Connections    int         `json:"connections"`
+	Bytes          int         `json:"bytes"`
+	ConnectionsPer float64     `json:"connections_per_sec"` //nolint:tagliatelle // API consistency
+	BytesPer       float64     `json:"bytes_per_sec"`       //nolint:tagliatelle // API consistency
+	TopTalkers     []HostBytes `json:"top_talkers"`         //nolint:tagliatelle // API consistency
+}
+
+// HostBytes is a host with its byte volume.
+type HostBytes struct {
+	Host  string `json:"host"`
+	Bytes int    `json:"bytes"`
+}
+
+// LiveSnapshot is the current state of live ingestion.
+type LiveSnapshot struct {
+	Active    bool         `json:"active"`
+	Source    string       `json:"source,omitempty"`
+	StartedAt int64        `json:"started_at,omitempty"` //nolint:tagliatelle // API consistency
+	Total     int          `json:"total_connections"`    //nolint:tagliatelle // API consistency
+	Windows   []LiveWindow `json:"windows"`
+}
+
+// LiveStats maintains rolling 1m/5m/1h aggregates of connections as they are ingested
+// by a streaming source. Connections are bucketed by ingestion time.
+type LiveStats struct {
+	mu        sync.Mutex
+	buckets   [liveHistorySec]liveBucket
+	active    bool
+	source    string
+	startedAt time.Time
+	total     int
+	now       func() time.Time
+}
+
+// NewLiveStats creates an inactive live statistics aggregator.
+func NewLiveStats() *LiveStats {
+	return &LiveStats{now: time.Now}
+}
+
+// Start marks live ingestion as active for the given source and resets all windows.
+func (l *LiveStats) Start(source string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buckets = [liveHistorySec]liveBucket{}
+	l.active = true
+	l.source = source
+	l.startedAt = l.now()
+	l.total = 0
+}
+
+// Stop marks live ingestion as inactive. Collected windows remain readable.
+func (l *LiveStats) Stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active = false
+}
+
+// Record adds newly ingested connections to the current second's bucket.
+func (l *LiveStats) Record(connections []Connection) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	second := l.now().Unix()
+	bucket := &l.buckets[second%liveHistorySec]
+	if bucket.second != second {
+		*bucket = liveBucket{second: second, talkers: make(map[string]int)}
+	}
+
+	for i := range connections {
+		bytes := connections[i].TotalBytes()
+		bucket.connections++
+		bucket.bytes += bytes
+		bucket.talkers[connections[i].OrigHost] += bytes
+		bucket.talkers[connections[i].RespHost] += bytes
+	}
+	l.total += len(connections)
+}
+
+// Snapshot returns the 1m, 5m, and 1h rolling aggregates.
+func (l *LiveStats) Snapshot() LiveSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snapshot := LiveSnapshot{
+		Active: l.active,
+		Source: l.source,
+		Total:  l.total,
+	}
+	if !l.startedAt.IsZero() {
+		snapshot.StartedAt = l.startedAt.Unix()
+	}
+
+	now := l.now().Unix()
+	for _, window := range []struct {
+		name    string
+		seconds int
+	}{{"1m", 60}, {"5m", 300}, {"1h", liveHistorySec}} { //nolint:mnd // Window sizes in seconds
+		snapshot.Windows = append(snapshot.Windows, l.window(window.name, window.seconds, now))
+	}
+
+	return snapshot
+}
+
+// window aggregates the buckets of the last seconds up to now. Callers must hold mu.
+func (l *LiveStats) window(name string, seconds int, now int64) LiveWindow {
+	result := LiveWindow{Window: name, Seconds: seconds, TopTalkers: []HostBytes{}}
+	talkers := make(map[string]int)
+
+	for offset := range int64(seconds) {
+		second := now - offset
+		bucket := &l.buckets[second%liveHistorySec]
+		if bucket.second != second {
+			continue
+		}
+
+		result.Connections += bucket.connections
+		result.Bytes += bucket.bytes
+		for host, bytes := range bucket.talkers {
+			talkers[host] += bytes
+		}
+	}
+
+	result.ConnectionsPer = float64(result.Connections) / float64(seconds)
+	result.BytesPer = float64(result.Bytes) / float64(seconds)
+
+	for host, bytes := range talkers {
+		result.TopTalkers = append(result.TopTalkers, HostBytes{Host: host, Bytes: bytes})
+	}
+	sort.Slice(result.TopTalkers, func(i, j int) bool {
+		return result.TopTalkers[i].Bytes > result.TopTalkers[j].Bytes
+	})
+	if len(result.TopTalkers) > liveTopTalkers {
+		result.TopTalkers = result.TopTalkers[:liveTopTalkers]
+	}
+
+	return result
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidLocalNetwork is returned for local network definitions that aren't prefixes.
+var ErrInvalidLocalNetwork = errors.New("local networks must be CIDR prefixes or IP addresses")
+
+// LocalNetworks are the prefixes of the monitored network. Hosts inside them are local.
+type LocalNetworks []netip.Prefix
+
+// DefaultLocalNetworks returns the private, loopback, and link-local ranges of IPv4 and IPv6.
+func DefaultLocalNetworks() LocalNetworks {
+	return LocalNetworks{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("169.254.0.0/16"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("fc00::/7"),
+		netip.MustParsePrefix("fe80::/10"),
+	}
+}
+
+// ParseLocalNetworks parses CIDR prefixes, or IP addresses as single-address prefixes. The
+// result is sorted and free of duplicates.
+func ParseLocalNetworks(values []string) (LocalNetworks, error) {
+	networks := make(LocalNetworks, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLocalNetwork, value)
+		}
+		networks = append(networks, prefix.Masked())
+	}
+
+	slices.SortFunc(networks, func(a, b netip.Prefix) int {
+		if order := a.Addr().Compare(b.Addr()); order != 0 {
+			return order
+		}
+
+		return a.Bits() - b.Bits()
+	})
+
+	return slices.Compact(networks), nil
+}
+
+// Contains reports whether host is local. Hosts are IP addresses, or CIDR prefixes such as
+// grouped subnets, which are local when they lie entirely within one of the networks.
+func (n LocalNetworks) Contains(host string) bool {
+	prefix, err := parsePrefix(host)
+	if err != nil {
+		return false
+	}
+
+	for _, network := range n {
+		if network.Bits() <= prefix.Bits() && network.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Strings returns the networks in CIDR notation.
+func (n LocalNetworks) Strings() []string {
+	values := make([]string, len(n))
+	for i, network := range n {
+		values[i] = network.String()
+	}
+
+	return values
+}
+
+// ParseHost parses a host address for matching against prefixes: IPv4-mapped IPv6 addresses
+// are treated as IPv4, and the zone of a link-local IPv6 address (fe80::1%eth0) is dropped.
+func ParseHost(host string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parsing host: %w", err)
+	}
+
+	return addr.Unmap().WithZone(""), nil
+}
+
+// CanonicalHost returns an IPv6 address in its canonical RFC 5952 form, lower-case and
+// compressed, and IPv4-mapped addresses as IPv4, so one host always has one spelling. Zones
+// are kept; IPv4 addresses and other values are returned as they are.
+func CanonicalHost(host string) string {
+	if !strings.Contains(host, ":") {
+		return host
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if canonical := addr.Unmap().String(); canonical != host {
+		return canonical
+	}
+
+	return host
+}
+
+// IPVersion returns 4 or 6 for an IPv4 or IPv6 host, IPv4-mapped addresses counting as IPv4,
+// or 0 for values that aren't IP addresses.
+func IPVersion(host string) int {
+	addr, err := ParseHost(host)
+	switch {
+	case err != nil:
+		return 0
+	case addr.Is4():
+		return 4 //nolint:mnd // IPv4
+	default:
+		return 6 //nolint:mnd // IPv6
+	}
+}
+
+// parsePrefix parses a CIDR prefix, or an IP address as a single-address prefix. IPv4-mapped
+// IPv6 addresses are treated as IPv4.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		addr = addr.Unmap().WithZone("")
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("parsing prefix: %w", err)
+	}
+
+	return prefix, nil
+}
@@ -0,0 +1,79 @@
+package models
+
+// HTTPRequest represents a Zeek http.log entry, correlated with its connection by UID.
+type HTTPRequest struct {
+	Timestamp       float64  `json:"ts"`
+	UID             string   `json:"uid"`
+	TransDepth      int      `json:"trans_depth,omitempty"` //nolint:tagliatelle // Zeek log format
+	Method          string   `json:"method,omitempty"`
+	Host            string   `json:"host,omitempty"`
+	URI             string   `json:"uri,omitempty"`
+	Referrer        string   `json:"referrer,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	UserAgent       string   `json:"user_agent,omitempty"`        //nolint:tagliatelle // Zeek log format
+	RequestBodyLen  int      `json:"request_body_len,omitempty"`  //nolint:tagliatelle // Zeek log format
+	ResponseBodyLen int      `json:"response_body_len,omitempty"` //nolint:tagliatelle // Zeek log format
+	StatusCode      int      `json:"status_code,omitempty"`       //nolint:tagliatelle // Zeek log format
+	StatusMsg       string   `json:"status_msg,omitempty"`        //nolint:tagliatelle // Zeek log format
+	OrigMIMETypes   []string `json:"orig_mime_types,omitempty"`   //nolint:tagliatelle // Zeek log format
+	RespMIMETypes   []string `json:"resp_mime_types,omitempty"`   //nolint:tagliatelle // Zeek log format
+}
+
+// TLSSession represents a Zeek ssl.log entry, correlated with its connection by UID. JA3 and
+// JA4 fingerprints are present when the ja3 or ja4 package is loaded in Zeek.
+type TLSSession struct {
+	Timestamp        float64  `json:"ts"`
+	UID              string   `json:"uid"`
+	Version          string   `json:"version,omitempty"`
+	Cipher           string   `json:"cipher,omitempty"`
+	Curve            string   `json:"curve,omitempty"`
+	ServerName       string   `json:"server_name,omitempty"` //nolint:tagliatelle // Zeek log format
+	Resumed          bool     `json:"resumed,omitempty"`
+	Established      bool     `json:"established,omitempty"`
+	NextProtocol     string   `json:"next_protocol,omitempty"` //nolint:tagliatelle // Zeek log format
+	JA3              string   `json:"ja3,omitempty"`
+	JA3S             string   `json:"ja3s,omitempty"`
+	JA4              string   `json:"ja4,omitempty"`
+	JA4S             string   `json:"ja4s,omitempty"`
+	Subject          string   `json:"subject,omitempty"`
+	Issuer           string   `json:"issuer,omitempty"`
+	ValidationStatus string   `json:"validation_status,omitempty"` //nolint:tagliatelle // Zeek log format
+	CertChainFps     []string `json:"cert_chain_fps,omitempty"`    //nolint:tagliatelle // Zeek log format
+}
+
+// Notice represents a Zeek notice.log entry. Notices raised outside of a connection, such as
+// scan detections, have no UID and name the host they concern as Src or Dst.
+type Notice struct {
+	Timestamp   float64  `json:"ts"`
+	UID         string   `json:"uid,omitempty"`
+	OrigHost    string   `json:"id.orig_h,omitempty"` //nolint:tagliatelle // Zeek log format
+	OrigPort    int      `json:"id.orig_p,omitempty"` //nolint:tagliatelle // Zeek log format
+	RespHost    string   `json:"id.resp_h,omitempty"` //nolint:tagliatelle // Zeek log format
+	RespPort    int      `json:"id.resp_p,omitempty"` //nolint:tagliatelle // Zeek log format
+	Protocol    string   `json:"proto,omitempty"`
+	Note        string   `json:"note"`
+	Msg         string   `json:"msg,omitempty"`
+	Sub         string   `json:"sub,omitempty"`
+	Src         string   `json:"src,omitempty"`
+	Dst         string   `json:"dst,omitempty"`
+	Port        int      `json:"p,omitempty"`
+	Count       int      `json:"n,omitempty"`
+	Actions     []string `json:"actions,omitempty"`
+	SuppressFor float64  `json:"suppress_for,omitempty"` //nolint:tagliatelle // Zeek log format
+}
+
+// Weird represents a Zeek weird.log entry: unexpected protocol behavior, correlated with its
+// connection by UID when it happened within one.
+type Weird struct {
+	Timestamp float64 `json:"ts"`
+	UID       string  `json:"uid,omitempty"`
+	OrigHost  string  `json:"id.orig_h,omitempty"` //nolint:tagliatelle // Zeek log format
+	OrigPort  int     `json:"id.orig_p,omitempty"` //nolint:tagliatelle // Zeek log format
+	RespHost  string  `json:"id.resp_h,omitempty"` //nolint:tagliatelle // Zeek log format
+	RespPort  int     `json:"id.resp_p,omitempty"` //nolint:tagliatelle // Zeek log format
+	Name      string  `json:"name"`
+	Addl      string  `json:"addl,omitempty"`
+	Notice    bool    `json:"notice,omitempty"`
+	Peer      string  `json:"peer,omitempty"`
+	Source    string  `json:"source,omitempty"`
+}
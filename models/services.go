@@ -0,0 +1,152 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxPortNumber = 65535 // Highest TCP/UDP port number
+
+// ErrInvalidServicePort is returned for service port overrides that aren't port=service pairs.
+var ErrInvalidServicePort = errors.New("service ports must be port=service or port/proto=service, e.g. 8080/tcp=http")
+
+// ServicePort is a responder port of one transport protocol.
+type ServicePort struct {
+	Port     int
+	Protocol string // tcp or udp
+}
+
+// ServicePorts names the service usually found on a responder port. Services are named like
+// Zeek's analyzers (http, ssl, dns, ...) so guesses compare with the service field.
+type ServicePorts map[ServicePort]string
+
+// DefaultServicePorts returns the IANA well-known and registered ports of common services.
+// TLS ports are guessed as ssl, the name Zeek gives the protocol whatever it carries.
+func DefaultServicePorts() ServicePorts {
+	return ServicePorts{
+		{20, "tcp"}:    "ftp-data",
+		{21, "tcp"}:    "ftp",
+		{22, "tcp"}:    "ssh",
+		{23, "tcp"}:    "telnet",
+		{25, "tcp"}:    "smtp",
+		{53, "tcp"}:    "dns",
+		{53, "udp"}:    "dns",
+		{67, "udp"}:    "dhcp",
+		{68, "udp"}:    "dhcp",
+		{69, "udp"}:    "tftp",
+		{80, "tcp"}:    "http",
+		{88, "tcp"}:    "krb",
+		{88, "udp"}:    "krb",
+		{110, "tcp"}:   "pop3",
+		{123, "udp"}:   "ntp",
+		{135, "tcp"}:   "dce_rpc",
+		{137, "udp"}:   "netbios-ns",
+		{138, "udp"}:   "netbios-dgm",
+		{139, "tcp"}:   "netbios-ssn",
+		{143, "tcp"}:   "imap",
+		{161, "udp"}:   "snmp",
+		{162, "udp"}:   "snmp",
+		{389, "tcp"}:   "ldap",
+		{389, "udp"}:   "ldap",
+		{443, "tcp"}:   "ssl",
+		{443, "udp"}:   "quic",
+		{445, "tcp"}:   "smb",
+		{465, "tcp"}:   "ssl",
+		{500, "udp"}:   "ipsec",
+		{502, "tcp"}:   "modbus",
+		{514, "udp"}:   "syslog",
+		{546, "udp"}:   "dhcp",
+		{547, "udp"}:   "dhcp",
+		{587, "tcp"}:   "smtp",
+		{636, "tcp"}:   "ssl",
+		{853, "tcp"}:   "ssl",
+		{993, "tcp"}:   "ssl",
+		{995, "tcp"}:   "ssl",
+		{1080, "tcp"}:  "socks",
+		{1194, "udp"}:  "openvpn",
+		{1433, "tcp"}:  "mssql",
+		{1812, "udp"}:  "radius",
+		{1813, "udp"}:  "radius",
+		{1883, "tcp"}:  "mqtt",
+		{1900, "udp"}:  "ssdp",
+		{3306, "tcp"}:  "mysql",
+		{3389, "tcp"}:  "rdp",
+		{3478, "tcp"}:  "stun",
+		{3478, "udp"}:  "stun",
+		{4500, "udp"}:  "ipsec",
+		{5060, "tcp"}:  "sip",
+		{5060, "udp"}:  "sip",
+		{5353, "udp"}:  "mdns",
+		{5355, "udp"}:  "llmnr",
+		{5432, "tcp"}:  "postgresql",
+		{5900, "tcp"}:  "vnc",
+		{6379, "tcp"}:  "redis",
+		{6667, "tcp"}:  "irc",
+		{8000, "tcp"}:  "http",
+		{8080, "tcp"}:  "http",
+		{8443, "tcp"}:  "ssl",
+		{20000, "tcp"}: "dnp3",
+	}
+}
+
+// ParseServicePorts parses overrides such as "8080/tcp=http" or "5000=upnp"; a port without
+// protocol applies to TCP and UDP. An empty service removes the port from the table.
+func ParseServicePorts(values []string) (ServicePorts, error) {
+	overrides := ServicePorts{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		portText, service, found := strings.Cut(value, "=")
+		portText, protocol, hasProtocol := strings.Cut(strings.TrimSpace(portText), "/")
+		port, err := strconv.Atoi(portText)
+		protocol = strings.ToLower(protocol)
+		if !found || err != nil || port < 0 || port > maxPortNumber {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidServicePort, value)
+		}
+
+		service = strings.ToLower(strings.TrimSpace(service))
+		switch {
+		case !hasProtocol:
+			overrides[ServicePort{port, "tcp"}] = service
+			overrides[ServicePort{port, "udp"}] = service
+		case protocol == "tcp" || protocol == "udp":
+			overrides[ServicePort{port, protocol}] = service
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidServicePort, value)
+		}
+	}
+
+	return overrides, nil
+}
+
+// WithOverrides returns a copy of the table with the overrides applied.
+func (p ServicePorts) WithOverrides(overrides ServicePorts) ServicePorts {
+	ports := make(ServicePorts, len(p)+len(overrides))
+	for port, service := range p {
+		ports[port] = service
+	}
+	for port, service := range overrides {
+		if service == "" {
+			delete(ports, port)
+		} else {
+			ports[port] = service
+		}
+	}
+
+	return ports
+}
+
+// Guess returns the service usually found on the connection's responder port, for
+// connections Zeek identified no service on; it returns "" otherwise.
+func (p ServicePorts) Guess(conn *Connection) string {
+	if conn.Service != "" {
+		return ""
+	}
+
+	return p[ServicePort{conn.RespPort, conn.Protocol}]
+}
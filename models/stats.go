@@ -0,0 +1,96 @@
+package models
+
+// ConnectionStats holds summary statistics accumulated while connections are parsed.
+type ConnectionStats struct {
+	TotalConnections int
+	Protocols        map[string]int
+	Services         map[string]int
+	ServiceGuesses   map[string]int // Services guessed from the port of connections without one
+	ConnStates       map[string]int
+	TotalBytes       int
+	StartTime        float64
+	EndTime          float64
+	UniqueIPs        *HyperLogLog
+}
+
+// NewConnectionStats creates an empty statistics accumulator.
+func NewConnectionStats() *ConnectionStats {
+	return &ConnectionStats{
+		Protocols:      make(map[string]int),
+		Services:       make(map[string]int),
+		ServiceGuesses: make(map[string]int),
+		ConnStates:     make(map[string]int),
+		StartTime:      -1,
+		EndTime:        -1,
+		UniqueIPs:      NewHyperLogLog(),
+	}
+}
+
+// Add updates the statistics with a single connection.
+func (s *ConnectionStats) Add(conn *Connection) {
+	s.TotalConnections++
+
+	// Protocol distribution
+	s.Protocols[conn.Protocol]++
+
+	// Service distribution
+	if conn.Service != "" {
+		s.Services[conn.Service]++
+	}
+	if conn.ServiceGuess != "" {
+		s.ServiceGuesses[conn.ServiceGuess]++
+	}
+
+	// Connection state distribution
+	s.ConnStates[conn.ConnState]++
+
+	// Unique IPs
+	s.UniqueIPs.Add(conn.OrigHost)
+	s.UniqueIPs.Add(conn.RespHost)
+
+	// Total bytes
+	s.TotalBytes += conn.TotalBytes()
+
+	// Time range
+	if s.StartTime == -1 || conn.Timestamp < s.StartTime {
+		s.StartTime = conn.Timestamp
+	}
+	if s.EndTime == -1 || conn.Timestamp > s.EndTime {
+		s.EndTime = conn.Timestamp
+	}
+}
+
+// Merge adds the statistics of other connections, such as those of another dataset.
+func (s *ConnectionStats) Merge(other *ConnectionStats) {
+	s.TotalConnections += other.TotalConnections
+	s.TotalBytes += other.TotalBytes
+	addCounts(s.Protocols, other.Protocols)
+	addCounts(s.Services, other.Services)
+	addCounts(s.ServiceGuesses, other.ServiceGuesses)
+	addCounts(s.ConnStates, other.ConnStates)
+	s.UniqueIPs.Merge(other.UniqueIPs)
+
+	if other.StartTime != -1 && (s.StartTime == -1 || other.StartTime < s.StartTime) {
+		s.StartTime = other.StartTime
+	}
+	if other.EndTime != -1 && (s.EndTime == -1 || other.EndTime > s.EndTime) {
+		s.EndTime = other.EndTime
+	}
+}
+
+// addCounts adds the counts of a distribution to another one.
+func addCounts(counts, added map[string]int) {
+	for value, count := range added {
+		counts[value] += count
+	}
+}
+
+// UniqueIPCount returns the approximate number of distinct IP addresses seen.
+func (s *ConnectionStats) UniqueIPCount() int {
+	return s.UniqueIPs.Count()
+}
+
+// Duration returns the time span covered by the connections in seconds.
+func (s *ConnectionStats) Duration() float64 {
+	return s.EndTime - s.StartTime
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tsvDefaultSeparator    = "\t"        // Column separator before a #separator directive
+	tsvDefaultSetSeparator = ","         // Separator of set and vector elements
+	tsvDefaultEmpty        = "(empty)"   // Marker of empty strings, sets, and vectors
+	tsvDefaultUnset        = "-"         // Marker of unset fields
+	tsvHexEscapeLength     = len(`\xHH`) // Length of an escaped byte
+)
+
+var (
+	// ErrInvalidTSVLine is returned for TSV data lines that don't match their header.
+	ErrInvalidTSVLine = errors.New("invalid TSV line")
+
+	errTSVDirective = errors.New("unknown TSV header directive")
+)
+
+// TSVHeader holds the header directives of a Zeek TSV log, which describe how the data lines
+// that follow are laid out.
+type TSVHeader struct {
+	Separator    string
+	SetSeparator string
+	Empty        string
+	Unset        string
+	Path         string
+	Fields       []string
+	Types        []string
+}
+
+// NewTSVHeader returns a header with Zeek's default separators and markers.
+func NewTSVHeader() *TSVHeader {
+	return &TSVHeader{
+		Separator:    tsvDefaultSeparator,
+		SetSeparator: tsvDefaultSetSeparator,
+		Empty:        tsvDefaultEmpty,
+		Unset:        tsvDefaultUnset,
+	}
+}
+
+// HasFields reports whether a #fields directive was read, so data lines can be parsed.
+func (h *TSVHeader) HasFields() bool {
+	return len(h.Fields) > 0
+}
+
+// ParseDirective reads a header line such as "#fields\tts\tuid". A new #fields directive
+// (e.g. of a concatenated log) replaces the previous columns and their types.
+func (h *TSVHeader) ParseDirective(line string) error {
+	// #separator is separated from its value by a space, as the separator isn't known yet
+	if value, found := strings.CutPrefix(line, "#separator "); found {
+		h.Separator = unescapeTSV(strings.TrimSpace(value))
+
+		return nil
+	}
+
+	name, value, _ := strings.Cut(strings.TrimPrefix(line, "#"), h.Separator)
+	switch name {
+	case "set_separator":
+		h.SetSeparator = value
+	case "empty_field":
+		h.Empty = value
+	case "unset_field":
+		h.Unset = value
+	case "path":
+		h.Path = value
+	case "fields":
+		h.Fields = strings.Split(value, h.Separator)
+		h.Types = nil
+	case "types":
+		h.Types = strings.Split(value, h.Separator)
+	case "open", "close":
+		// Log rotation timestamps
+	default:
+		return fmt.Errorf("%w %q", errTSVDirective, name)
+	}
+
+	return nil
+}
+
+// UnmarshalConnection parses a TSV data line into a Connection, mapping columns by the
+// #fields names. Unset fields are left at their zero value; columns without a Connection
+// field are kept in Extras.
+func (h *TSVHeader) UnmarshalConnection(line string) (*Connection, error) {
+	raw, err := h.Record(line)
+	if err != nil {
+		return nil, err
+	}
+
+	return connectionFromMap(raw), nil
+}
+
+// UnmarshalRecord parses a TSV data line into target, like a JSON line of the same log would
+// be decoded with encoding/json.
+func (h *TSVHeader) UnmarshalRecord(line string, target any) error {
+	raw, err := h.Record(line)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTSVLine, err)
+	}
+
+	err = json.Unmarshal(data, target)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTSVLine, err)
+	}
+
+	return nil
+}
+
+// Record parses a TSV data line into its set fields, keyed by #fields name, with values of
+// the types their JSON equivalents decode to.
+func (h *TSVHeader) Record(line string) (map[string]any, error) {
+	if !h.HasFields() {
+		return nil, fmt.Errorf("%w: no #fields header", ErrInvalidTSVLine)
+	}
+
+	values := strings.Split(line, h.Separator)
+	if len(values) != len(h.Fields) {
+		return nil, fmt.Errorf("%w: %d columns, #fields has %d", ErrInvalidTSVLine, len(values), len(h.Fields))
+	}
+
+	raw := make(map[string]any, len(values))
+	for i, value := range values {
+		if value == h.Unset {
+			continue
+		}
+
+		typed, err := h.convert(i, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: field %s: %w", ErrInvalidTSVLine, h.Fields[i], err)
+		}
+		raw[h.Fields[i]] = typed
+	}
+
+	return raw, nil
+}
+
+// convert turns the value of column i into the Go type its JSON equivalent decodes to, based
+// on the column's #types entry: numbers, booleans, string slices for sets and vectors, and
+// strings. Without a type, it is guessed from the value.
+func (h *TSVHeader) convert(i int, value string) (any, error) {
+	zeekType := ""
+	if i < len(h.Types) {
+		zeekType = h.Types[i]
+	}
+
+	switch zeekType {
+	case "time", "interval", "double", "count", "int", "port":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", zeekType, err)
+		}
+
+		return number, nil
+	case "bool":
+		return value == "T", nil
+	case "":
+		if value == "T" || value == "F" {
+			return value == "T", nil
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number, nil
+		}
+	}
+
+	container := strings.HasPrefix(zeekType, "set[") || strings.HasPrefix(zeekType, "vector[")
+	switch {
+	case container && value == h.Empty:
+		return []string{}, nil
+	case container:
+		elements := strings.Split(value, h.SetSeparator)
+		for j, element := range elements {
+			elements[j] = unescapeTSV(element)
+		}
+
+		return elements, nil
+	case value == h.Empty:
+		return "", nil
+	default:
+		return unescapeTSV(value), nil
+	}
+}
+
+// unescapeTSV decodes the \xHH escapes Zeek writes for separators and non-printable bytes.
+func unescapeTSV(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+
+	var decoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		if strings.HasPrefix(value[i:], `\x`) && i+tsvHexEscapeLength <= len(value) {
+			if b, err := strconv.ParseUint(value[i+2:i+tsvHexEscapeLength], 16, 8); err == nil {
+				decoded.WriteByte(byte(b))
+				i += tsvHexEscapeLength - 1
+
+				continue
+			}
+		}
+		decoded.WriteByte(value[i])
+	}
+
+	return decoded.String()
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ZJSON type IDs. Zed reserves IDs below 30 for primitive types.
+const (
+	zjsonPortTypeID   = 30
+	zjsonIDTypeID     = 31
+	zjsonConnTypeID   = 32
+	nanosecondsFactor = float64(time.Second)
+)
+
+// zjsonRecord is a single line of a ZJSON stream.
+type zjsonRecord struct {
+	Type  any   `json:"type"`
+	Value []any `json:"value"`
+}
+
+// zjsonField is a field of a ZJSON record type.
+type zjsonField struct {
+	Name string `json:"name"`
+	Type any    `json:"type"`
+}
+
+// WriteZJSON writes connections as a ZJSON stream readable by zq/Zed (`zq -i zjson`).
+// The record layout mirrors Zed's own Zeek conn.log mapping, including the nested id record.
+func WriteZJSON(w io.Writer, connections []Connection) error {
+	encoder := json.NewEncoder(w)
+
+	for i := range connections {
+		record := zjsonRecord{
+			Type:  map[string]any{"kind": "ref", "id": zjsonConnTypeID},
+			Value: zjsonConnValue(&connections[i]),
+		}
+		if i == 0 {
+			record.Type = zjsonConnType()
+		}
+
+		err := encoder.Encode(record)
+		if err != nil {
+			return fmt.Errorf("failed to write zjson record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// zjsonConnType returns the full type definition of a conn record.
+func zjsonConnType() map[string]any {
+	primitive := func(name string) map[string]any {
+		return map[string]any{"kind": "primitive", "name": name}
+	}
+
+	idType := map[string]any{
+		"kind": "record",
+		"id":   zjsonIDTypeID,
+		"fields": []zjsonField{
+			{Name: "orig_h", Type: primitive("ip")},
+			{Name: "orig_p", Type: map[string]any{
+				"kind": "named", "name": "port", "id": zjsonPortTypeID, "type": primitive("uint16"),
+			}},
+			{Name: "resp_h", Type: primitive("ip")},
+			{Name: "resp_p", Type: map[string]any{"kind": "ref", "id": zjsonPortTypeID}},
+		},
+	}
+
+	return map[string]any{
+		"kind": "record",
+		"id":   zjsonConnTypeID,
+		"fields": []zjsonField{
+			{Name: "ts", Type: primitive("time")},
+			{Name: "uid", Type: primitive("string")},
+			{Name: "id", Type: idType},
+			{Name: "proto", Type: primitive("string")},
+			{Name: "service", Type: primitive("string")},
+			{Name: "duration", Type: primitive("duration")},
+			{Name: "orig_bytes", Type: primitive("uint64")},
+			{Name: "resp_bytes", Type: primitive("uint64")},
+			{Name: "conn_state", Type: primitive("string")},
+			{Name: "local_orig", Type: primitive("bool")},
+			{Name: "local_resp", Type: primitive("bool")},
+			{Name: "missed_bytes", Type: primitive("uint64")},
+			{Name: "history", Type: primitive("string")},
+			{Name: "orig_pkts", Type: primitive("uint64")},
+			{Name: "orig_ip_bytes", Type: primitive("uint64")},
+			{Name: "resp_pkts", Type: primitive("uint64")},
+			{Name: "resp_ip_bytes", Type: primitive("uint64")},
+			{Name: "ip_proto", Type: primitive("uint64")},
+		},
+	}
+}
+
+// zjsonConnValue returns the ZJSON value array of a connection. ZJSON encodes
+// every primitive as a string; unset optional strings are encoded as null.
+func zjsonConnValue(c *Connection) []any {
+	optional := func(value string) any {
+		if value == "" {
+			return nil
+		}
+
+		return value
+	}
+	itoa := strconv.Itoa
+
+	return []any{
+		c.GetTime().UTC().Format(time.RFC3339Nano),
+		c.UID,
+		[]any{c.OrigHost, itoa(c.OrigPort), c.RespHost, itoa(c.RespPort)},
+		c.Protocol,
+		optional(c.Service),
+		time.Duration(c.Duration * nanosecondsFactor).String(),
+		itoa(c.OrigBytes),
+		itoa(c.RespBytes),
+		c.ConnState,
+		strconv.FormatBool(c.LocalOrig),
+		strconv.FormatBool(c.LocalResp),
+		itoa(c.MissedBytes),
+		optional(c.History),
+		itoa(c.OrigPackets),
+		itoa(c.OrigIPBytes),
+		itoa(c.RespPackets),
+		itoa(c.RespIPBytes),
+		itoa(c.IPProtocol),
+	}
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ZNG frame types, primitive type IDs, and type definition codes, from the ZNG specification.
+// The conn record takes the IDs of its ZJSON type definition.
+const (
+	zngTypesFrame  = 0
+	zngValuesFrame = 1
+
+	zngUint16ID   = 1
+	zngUint64ID   = 3
+	zngDurationID = 12
+	zngTimeID     = 13
+	zngBoolID     = 23
+	zngStringID   = 25
+	zngIPID       = 26
+
+	zngRecordDef = 0
+	zngNamedDef  = 7
+
+	zngFrameBytes = 512 << 10 // Values encoded before a values frame is written
+	zngLengthBits = 4         // Length bits in a frame header byte
+)
+
+// zngField is a field of a ZNG record type definition.
+type zngField struct {
+	name   string
+	typeID uint64
+}
+
+// WriteZNG writes connections as a ZNG stream, the binary format of zq/Zed, with the record
+// layout of WriteZJSON. The values are written uncompressed, in frames of about 512 KiB.
+func WriteZNG(w io.Writer, connections []Connection) error {
+	err := writeZNGFrame(w, zngTypesFrame, zngConnTypes())
+	if err != nil {
+		return err
+	}
+
+	var values []byte
+	for i := range connections {
+		values = binary.AppendUvarint(values, zjsonConnTypeID)
+		values = appendZNGTag(values, zngConnValue(&connections[i]))
+		if len(values) >= zngFrameBytes {
+			err = writeZNGFrame(w, zngValuesFrame, values)
+			if err != nil {
+				return err
+			}
+			values = values[:0]
+		}
+	}
+	if len(values) > 0 {
+		return writeZNGFrame(w, zngValuesFrame, values)
+	}
+
+	return nil
+}
+
+// writeZNGFrame writes an uncompressed frame: a header byte of the type and the low bits of
+// the length, the remaining length bits as a uvarint, and the payload.
+func writeZNGFrame(w io.Writer, frameType int, payload []byte) error {
+	length := len(payload)
+	header := []byte{byte(frameType<<zngLengthBits | length&(1<<zngLengthBits-1))}
+	header = binary.AppendUvarint(header, uint64(length>>zngLengthBits))
+
+	_, err := w.Write(append(header, payload...))
+	if err != nil {
+		return fmt.Errorf("failed to write zng frame: %w", err)
+	}
+
+	return nil
+}
+
+// zngConnTypes returns the type definitions of a conn record, in ID order: the port type (30),
+// the id record (31), and the conn record (32).
+func zngConnTypes() []byte {
+	types := []byte{zngNamedDef}
+	types = appendZNGName(types, "port")
+	types = binary.AppendUvarint(types, zngUint16ID)
+
+	types = appendZNGRecordDef(types, []zngField{
+		{"orig_h", zngIPID},
+		{"orig_p", zjsonPortTypeID},
+		{"resp_h", zngIPID},
+		{"resp_p", zjsonPortTypeID},
+	})
+
+	return appendZNGRecordDef(types, []zngField{
+		{"ts", zngTimeID},
+		{"uid", zngStringID},
+		{"id", zjsonIDTypeID},
+		{"proto", zngStringID},
+		{"service", zngStringID},
+		{"duration", zngDurationID},
+		{"orig_bytes", zngUint64ID},
+		{"resp_bytes", zngUint64ID},
+		{"conn_state", zngStringID},
+		{"local_orig", zngBoolID},
+		{"local_resp", zngBoolID},
+		{"missed_bytes", zngUint64ID},
+		{"history", zngStringID},
+		{"orig_pkts", zngUint64ID},
+		{"orig_ip_bytes", zngUint64ID},
+		{"resp_pkts", zngUint64ID},
+		{"resp_ip_bytes", zngUint64ID},
+		{"ip_proto", zngUint64ID},
+	})
+}
+
+// appendZNGRecordDef appends a record type definition.
+func appendZNGRecordDef(types []byte, fields []zngField) []byte {
+	types = append(types, zngRecordDef)
+	types = binary.AppendUvarint(types, uint64(len(fields)))
+	for _, field := range fields {
+		types = appendZNGName(types, field.name)
+		types = binary.AppendUvarint(types, field.typeID)
+	}
+
+	return types
+}
+
+// appendZNGName appends a length-prefixed type or field name.
+func appendZNGName(types []byte, name string) []byte {
+	types = binary.AppendUvarint(types, uint64(len(name)))
+
+	return append(types, name...)
+}
+
+// zngConnValue returns the body of a connection's conn record: its tagged fields.
+func zngConnValue(c *Connection) []byte {
+	optional := func(value string) []byte {
+		if value == "" {
+			return nil
+		}
+
+		return []byte(value)
+	}
+
+	id := appendZNGTag(nil, zngIPValue(c.OrigHost))
+	id = appendZNGTag(id, zngUint(c.OrigPort))
+	id = appendZNGTag(id, zngIPValue(c.RespHost))
+	id = appendZNGTag(id, zngUint(c.RespPort))
+
+	fields := [][]byte{
+		zngInt(c.GetTime().UnixNano()),
+		[]byte(c.UID),
+		id,
+		[]byte(c.Protocol),
+		optional(c.Service),
+		zngInt(int64(c.Duration * nanosecondsFactor)),
+		zngUint(c.OrigBytes),
+		zngUint(c.RespBytes),
+		[]byte(c.ConnState),
+		zngBool(c.LocalOrig),
+		zngBool(c.LocalResp),
+		zngUint(c.MissedBytes),
+		optional(c.History),
+		zngUint(c.OrigPackets),
+		zngUint(c.OrigIPBytes),
+		zngUint(c.RespPackets),
+		zngUint(c.RespIPBytes),
+		zngUint(c.IPProtocol),
+	}
+
+	var body []byte
+	for _, field := range fields {
+		body = appendZNGTag(body, field)
+	}
+
+	return body
+}
+
+// appendZNGTag appends a value with its tag, the length plus one; a nil value is null, tag 0.
+func appendZNGTag(dst, value []byte) []byte {
+	if value == nil {
+		return binary.AppendUvarint(dst, 0)
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(value))+1)
+
+	return append(dst, value...)
+}
+
+// zngUint encodes an unsigned integer as little-endian bytes without the high zero bytes;
+// zero is no bytes. Negative values, which Zeek doesn't log, are encoded as zero.
+func zngUint(value int) []byte {
+	encoded := []byte{}
+	for u := uint64(max(value, 0)); u != 0; u >>= 8 {
+		encoded = append(encoded, byte(u))
+	}
+
+	return encoded
+}
+
+// zngInt encodes a signed integer, such as the nanoseconds of a time or duration, zig-zag
+// encoded as an unsigned one.
+func zngInt(value int64) []byte {
+	encoded := []byte{}
+	for u := uint64(value<<1) ^ uint64(value>>63); u != 0; u >>= 8 { //nolint:gosec,mnd // Zig-zag encoding
+		encoded = append(encoded, byte(u))
+	}
+
+	return encoded
+}
+
+// zngBoolID encodes a bool as one byte.
+func zngBool(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+// zngIPValue encodes an address as its 4 or 16 bytes, or null when host isn't one.
+func zngIPValue(host string) []byte {
+	addr, err := ParseHost(host)
+	if err != nil {
+		return nil
+	}
+
+	return addr.AsSlice()
+}
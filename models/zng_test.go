@@ -0,0 +1,200 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// zngReader decodes the parts of a ZNG stream WriteZNG writes, following the specification
+// independently of the writer.
+type zngReader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (r *zngReader) uvarint() uint64 {
+	r.t.Helper()
+
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.t.Fatalf("bad uvarint at % x", r.data[:min(len(r.data), 8)])
+	}
+	r.data = r.data[n:]
+
+	return value
+}
+
+func (r *zngReader) bytes(n uint64) []byte {
+	r.t.Helper()
+
+	if uint64(len(r.data)) < n {
+		r.t.Fatalf("%d bytes left, want %d", len(r.data), n)
+	}
+	value := r.data[:n]
+	r.data = r.data[n:]
+
+	return value
+}
+
+// frame returns the type and payload of the next frame, or false at the end of the stream.
+func (r *zngReader) frame() (int, *zngReader, bool) {
+	r.t.Helper()
+
+	if len(r.data) == 0 {
+		return 0, nil, false
+	}
+	header := r.bytes(1)[0]
+	if header&0xc0 != 0 {
+		r.t.Fatalf("frame header %#x sets the version or compression bit", header)
+	}
+	length := r.uvarint()<<4 | uint64(header&0x0f)
+
+	return int(header >> 4), &zngReader{t: r.t, data: r.bytes(length)}, true
+}
+
+// tagged returns the next tagged value; null is nil.
+func (r *zngReader) tagged() []byte {
+	r.t.Helper()
+
+	tag := r.uvarint()
+	if tag == 0 {
+		return nil
+	}
+
+	return r.bytes(tag - 1)
+}
+
+// countedUint decodes a little-endian integer of any length.
+func countedUint(value []byte) uint64 {
+	var u uint64
+	for i := len(value) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(value[i])
+	}
+
+	return u
+}
+
+// countedInt decodes a zig-zag encoded integer.
+func countedInt(value []byte) int64 {
+	u := countedUint(value)
+
+	return int64(u>>1) ^ -int64(u&1) //nolint:gosec // Zig-zag decoding
+}
+
+func TestWriteZNG(t *testing.T) {
+	connections := []Connection{
+		{
+			Timestamp: 1704067200.25, UID: "C4", OrigHost: "10.0.0.1", OrigPort: 51000, RespHost: "192.0.2.1", RespPort: 443,
+			Protocol: "tcp", Service: "ssl", Duration: 1.5, OrigBytes: 300, RespBytes: 70000, ConnState: "SF", LocalOrig: true,
+			History: "ShADadFf", OrigPackets: 10, IPProtocol: 6,
+		},
+		{
+			Timestamp: 1704067201, UID: "C6", OrigHost: "2001:db8::1", OrigPort: 0, RespHost: "ff02::fb", RespPort: 5353,
+			Protocol: "udp", ConnState: "S0",
+		},
+	}
+	var out bytes.Buffer
+	err := WriteZNG(&out, connections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &zngReader{t: t, data: out.Bytes()}
+	frameType, types, ok := stream.frame()
+	if !ok || frameType != zngTypesFrame {
+		t.Fatalf("first frame of type %d, want the types frame", frameType)
+	}
+	if code := types.bytes(1)[0]; code != zngNamedDef || string(types.bytes(types.uvarint())) != "port" || types.uvarint() != zngUint16ID {
+		t.Fatal("first type isn't port, a named uint16")
+	}
+	var fields [][]string
+	for range 2 {
+		if code := types.bytes(1)[0]; code != zngRecordDef {
+			t.Fatalf("type definition %d, want a record", code)
+		}
+		var names []string
+		for range types.uvarint() {
+			names = append(names, string(types.bytes(types.uvarint())))
+			types.uvarint()
+		}
+		fields = append(fields, names)
+	}
+	if len(fields[0]) != 4 || fields[0][1] != "orig_p" || len(fields[1]) != 18 || fields[1][2] != "id" {
+		t.Fatalf("record fields %v", fields)
+	}
+
+	frameType, values, ok := stream.frame()
+	if !ok || frameType != zngValuesFrame {
+		t.Fatalf("second frame of type %d, want a values frame", frameType)
+	}
+	for i := range connections {
+		if id := values.uvarint(); id != zjsonConnTypeID {
+			t.Fatalf("value %d of type %d, want %d", i, id, zjsonConnTypeID)
+		}
+		record := &zngReader{t: t, data: values.tagged()}
+		ts := time.Unix(0, countedInt(record.tagged()))
+		uid := string(record.tagged())
+		id := &zngReader{t: t, data: record.tagged()}
+		origHost, _ := netip.AddrFromSlice(id.tagged())
+		origPort := countedUint(id.tagged())
+		respHost, _ := netip.AddrFromSlice(id.tagged())
+		respPort := countedUint(id.tagged())
+		proto := string(record.tagged())
+		service := record.tagged()
+		duration := time.Duration(countedInt(record.tagged()))
+		origBytes, respBytes := countedUint(record.tagged()), countedUint(record.tagged())
+		state := string(record.tagged())
+		localOrig := record.tagged()
+
+		conn := connections[i]
+		if !ts.Equal(conn.GetTime()) || uid != conn.UID || origHost.String() != conn.OrigHost || origPort != uint64(conn.OrigPort) ||
+			respHost.String() != conn.RespHost || respPort != uint64(conn.RespPort) || proto != conn.Protocol ||
+			duration != time.Duration(conn.Duration*float64(time.Second)) || origBytes != uint64(conn.OrigBytes) ||
+			respBytes != uint64(conn.RespBytes) || state != conn.ConnState || (localOrig[0] == 1) != conn.LocalOrig {
+			t.Errorf("record %d: %v %s %v:%d %v:%d %s %v %d %d %s", i, ts, uid, origHost, origPort, respHost, respPort,
+				proto, duration, origBytes, respBytes, state)
+		}
+		if (service == nil) != (conn.Service == "") || string(service) != conn.Service {
+			t.Errorf("record %d: service %q, want %q as null when empty", i, service, conn.Service)
+		}
+	}
+	if len(values.data) != 0 {
+		t.Errorf("%d bytes after the records", len(values.data))
+	}
+	if _, _, ok := stream.frame(); ok {
+		t.Error("frames after the values frame")
+	}
+}
+
+func TestWriteZNGSplitsFrames(t *testing.T) {
+	connections := make([]Connection, 20000)
+	for i := range connections {
+		connections[i] = Connection{UID: "C" + string(rune('a'+i%26)), OrigHost: "10.0.0.1", RespHost: "10.0.0.2", Protocol: "tcp"}
+	}
+	var out bytes.Buffer
+	err := WriteZNG(&out, connections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &zngReader{t: t, data: out.Bytes()}
+	frames, records := 0, 0
+	for {
+		frameType, frame, ok := stream.frame()
+		if !ok {
+			break
+		}
+		frames++
+		for frameType == zngValuesFrame && len(frame.data) > 0 {
+			frame.uvarint()
+			frame.tagged()
+			records++
+		}
+	}
+	if frames < 3 || records != len(connections) {
+		t.Errorf("%d frames of %d records, want several of %d", frames, records, len(connections))
+	}
+}
@@ -0,0 +1,212 @@
+// Package netgraph builds the network graph of connections, as zeek-viz serves it at
+// /api/nodes: a node per host, with its traffic, locality, and risk score, and an edge per
+// pair of hosts and protocol, service, or port.
+//
+//	local := models.DefaultLocalNetworks()
+//	graph := netgraph.Build(result.Connections, local, netgraph.ByProtocol)
+//	err := models.WriteGraphML(os.Stdout, graph)
+package netgraph
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+
+	"zeek-viz/models"
+)
+
+// Edge aggregations, selecting the connections that share an edge.
+const (
+	ByProtocol = "protocol" // One edge per originator, responder, and protocol
+	ByPair     = "pair"     // One edge per pair of hosts, whichever originated
+	ByService  = "service"  // One edge per originator, responder, and service
+	ByPort     = "port"     // One edge per originator, responder, protocol, and responder port
+	Mixed      = "mixed"    // Protocol of an edge whose connections differ in it
+
+	bytesScaleFactor = 1000.0 // Bytes per unit of edge weight
+)
+
+// Build builds the network graph of the connections, as NodesAndEdges does, with its nodes
+// busiest first and its edges heaviest first.
+func Build(connections []models.Connection, local models.LocalNetworks, aggregation string) models.NetworkGraph {
+	nodes, edges := NodesAndEdges(connections, local, aggregation)
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Connections != nodes[j].Connections {
+			return nodes[i].Connections > nodes[j].Connections
+		}
+
+		return nodes[i].ID < nodes[j].ID
+	})
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].TotalBytes != edges[j].TotalBytes {
+			return edges[i].TotalBytes > edges[j].TotalBytes
+		}
+
+		return EdgeID(&edges[i], aggregation) < EdgeID(&edges[j], aggregation)
+	})
+
+	return models.NetworkGraph{Nodes: nodes, Edges: edges, TotalNodes: len(nodes), TotalEdges: len(edges)}
+}
+
+// processNode updates or creates a node in the nodeMap.
+func processNode(nodeMap map[string]*models.Node, host string, totalBytes int, timestamp float64, local models.LocalNetworks) {
+	if _, exists := nodeMap[host]; !exists {
+		nodeMap[host] = &models.Node{
+			ID:        host,
+			Label:     host,
+			IsLocal:   local.Contains(host),
+			FirstSeen: timestamp,
+			LastSeen:  timestamp,
+		}
+	}
+	nodeMap[host].Connections++
+	nodeMap[host].TotalBytes += totalBytes
+	nodeMap[host].FirstSeen = min(nodeMap[host].FirstSeen, timestamp)
+	nodeMap[host].LastSeen = max(nodeMap[host].LastSeen, timestamp)
+}
+
+// processEdge updates or creates the edge in the edgeMap that the aggregation counts the
+// connection on.
+func processEdge(edgeMap map[string]*models.Edge, conn models.Connection, aggregation string) {
+	key := edgeKey(&conn, aggregation)
+
+	edge, exists := edgeMap[key]
+	if !exists {
+		edge = &models.Edge{
+			Source:    conn.OrigHost,
+			Target:    conn.RespHost,
+			Protocol:  conn.Protocol,
+			Service:   conn.Service,
+			FirstSeen: conn.Timestamp,
+			LastSeen:  conn.Timestamp,
+		}
+		if aggregation == ByPort {
+			edge.Port = conn.RespPort
+		}
+		edgeMap[key] = edge
+	}
+	if aggregation == ByPair || aggregation == ByService {
+		edge.Protocol = mergeEdgeValue(edge.Protocol, conn.Protocol)
+	}
+	edge.Count++
+	if edge.Source == conn.OrigHost {
+		edge.OrigBytes += conn.OrigBytes
+		edge.RespBytes += conn.RespBytes
+	} else {
+		edge.ReverseCount++
+		edge.OrigBytes += conn.RespBytes
+		edge.RespBytes += conn.OrigBytes
+	}
+	edge.TotalBytes += conn.TotalBytes()
+	edge.Weight = float64(edge.TotalBytes) / bytesScaleFactor
+	edge.FirstSeen = min(edge.FirstSeen, conn.Timestamp)
+	edge.LastSeen = max(edge.LastSeen, conn.Timestamp)
+}
+
+// NodesAndEdges builds the nodes and edges of the connections, marking the hosts inside the
+// local networks and scoring their risk (see Score). The aggregation, one of ByProtocol (the
+// default for ""), ByPair, ByService, and ByPort, selects the connections that share an edge.
+// Connections combined from several datasets, with Dataset set, add their file IDs to the
+// datasets of their nodes and edge. Nodes and edges come in no particular order.
+func NodesAndEdges(connections []models.Connection, local models.LocalNetworks, aggregation string) ([]models.Node, []models.Edge) {
+	nodeMap := make(map[string]*models.Node)
+	edgeMap := make(map[string]*models.Edge)
+
+	for _, conn := range connections {
+		totalBytes := conn.TotalBytes()
+		processNode(nodeMap, conn.OrigHost, totalBytes, conn.Timestamp, local)
+		processNode(nodeMap, conn.RespHost, totalBytes, conn.Timestamp, local)
+		processEdge(edgeMap, conn, aggregation)
+		if conn.Dataset != "" { // Combined from several datasets
+			nodeMap[conn.OrigHost].Datasets = addDataset(nodeMap[conn.OrigHost].Datasets, conn.Dataset)
+			nodeMap[conn.RespHost].Datasets = addDataset(nodeMap[conn.RespHost].Datasets, conn.Dataset)
+			edge := edgeMap[edgeKey(&conn, aggregation)]
+			edge.Datasets = addDataset(edge.Datasets, conn.Dataset)
+		}
+	}
+
+	// Convert maps to slices
+	nodes := make([]models.Node, 0, len(nodeMap))
+	for _, node := range nodeMap {
+		nodes = append(nodes, *node)
+	}
+
+	edges := make([]models.Edge, 0, len(edgeMap))
+	for _, edge := range edgeMap {
+		edges = append(edges, *edge)
+	}
+	if aggregation == ByPair {
+		orientPairEdges(edges)
+	}
+
+	Score(nodes, connections)
+
+	return nodes, edges
+}
+
+// edgeKey returns the key of the edge a connection is counted on.
+func edgeKey(conn *models.Connection, aggregation string) string {
+	switch aggregation {
+	case ByPair:
+		if conn.RespHost < conn.OrigHost {
+			return conn.RespHost + "|" + conn.OrigHost
+		}
+
+		return conn.OrigHost + "|" + conn.RespHost
+	case ByService:
+		return conn.OrigHost + "|" + conn.RespHost + "|" + conn.Service
+	case ByPort:
+		return conn.OrigHost + "|" + conn.RespHost + "|" + conn.Protocol + "|" + strconv.Itoa(conn.RespPort)
+	default:
+		return conn.OrigHost + "|" + conn.RespHost + "|" + conn.Protocol
+	}
+}
+
+// EdgeID returns the key of an edge built with the aggregation, the one its connections
+// were counted on, which identifies it among the edges of a graph.
+func EdgeID(edge *models.Edge, aggregation string) string {
+	conn := models.Connection{
+		OrigHost: edge.Source,
+		RespHost: edge.Target,
+		Protocol: edge.Protocol,
+		Service:  edge.Service,
+		RespPort: edge.Port,
+	}
+
+	return edgeKey(&conn, aggregation)
+}
+
+// mergeEdgeValue returns the protocol of an edge whose key leaves it open after adding a
+// connection with the value: the edge's own while they agree, mixed otherwise.
+func mergeEdgeValue(current, value string) string {
+	if current == value {
+		return current
+	}
+
+	return Mixed
+}
+
+// orientPairEdges points each edge of a host pair from the host that originated most of its
+// connections, the lower address on a tie, so the direction of an edge is where traffic was
+// initiated from.
+func orientPairEdges(edges []models.Edge) {
+	for i := range edges {
+		edge := &edges[i]
+		forward := edge.Count - edge.ReverseCount
+		if edge.ReverseCount < forward || (edge.ReverseCount == forward && edge.Source <= edge.Target) {
+			continue
+		}
+		edge.Source, edge.Target = edge.Target, edge.Source
+		edge.OrigBytes, edge.RespBytes = edge.RespBytes, edge.OrigBytes
+		edge.ReverseCount = forward
+	}
+}
+
+// addDataset appends a file ID to the datasets of a node or edge unless it is listed already.
+func addDataset(datasets []string, fileID string) []string {
+	if fileID == "" || slices.Contains(datasets, fileID) {
+		return datasets
+	}
+
+	return append(datasets, fileID)
+}
@@ -0,0 +1,98 @@
+package netgraph
+
+import (
+	"math"
+	"slices"
+
+	"zeek-viz/models"
+)
+
+const (
+	riskExternalPoints    = 20.0 // Points for hosts outside the local network
+	riskUnusualPortPoints = 40.0 // Points when all of a host's connections use unusual ports
+	riskFailedPoints      = 40.0 // Points when all TCP connections a host originated failed
+	riskFullSample        = 10.0 // Connections a host needs before a share gets its full weight
+)
+
+// riskCounts holds the per-host signals a risk score is computed from.
+type riskCounts struct {
+	unusualPorts int
+	originated   int
+	failed       int
+}
+
+// commonPorts returns responder ports of widely used services.
+func commonPorts() []int {
+	return []int{
+		20, 21, 22, 23, 25, 53, 67, 68, 80, 88, 110, 123, 137, 138, 139, 143, 161, 389, 443, 445,
+		465, 514, 587, 636, 853, 993, 995, 1900, 3389, 5353, 5355, 8080,
+	}
+}
+
+// Score assigns each node a risk score from 0 to 100 combining its locality, the share of its
+// connections to unusual ports, and the share of its TCP attempts that failed, which is how
+// scanners and misbehaving hosts usually stand out. RiskFactors holds the points of each:
+// external, unusual_ports, and failed_connections.
+func Score(nodes []models.Node, connections []models.Connection) {
+	counts := make(map[string]*riskCounts, len(nodes))
+	count := func(host string) *riskCounts {
+		if counts[host] == nil {
+			counts[host] = &riskCounts{}
+		}
+
+		return counts[host]
+	}
+
+	for i := range connections {
+		conn := &connections[i]
+		if conn.Protocol != "icmp" && !slices.Contains(commonPorts(), conn.RespPort) {
+			count(conn.OrigHost).unusualPorts++
+			count(conn.RespHost).unusualPorts++
+		}
+		if conn.Protocol == "tcp" {
+			origin := count(conn.OrigHost)
+			origin.originated++
+			if slices.Contains(models.FailedStates(), conn.ConnState) {
+				origin.failed++
+			}
+		}
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		signals := count(node.ID)
+		factors := make(map[string]float64)
+
+		if !node.IsLocal {
+			factors["external"] = riskExternalPoints
+		}
+		if signals.unusualPorts > 0 {
+			factors["unusual_ports"] = riskUnusualPortPoints * weightedShare(signals.unusualPorts, node.Connections)
+		}
+		if signals.failed > 0 {
+			factors["failed_connections"] = riskFailedPoints * weightedShare(signals.failed, signals.originated)
+		}
+
+		var score float64
+		for name, points := range factors {
+			factors[name] = roundTenth(points)
+			score += points
+		}
+		node.RiskScore = roundTenth(score)
+		if len(factors) > 0 {
+			node.RiskFactors = factors
+		}
+	}
+}
+
+// weightedShare returns part/total, discounted for hosts with too few connections to judge.
+func weightedShare(part, total int) float64 {
+	confidence := min(1, float64(total)/riskFullSample)
+
+	return confidence * float64(part) / float64(total)
+}
+
+// roundTenth rounds a value to one decimal.
+func roundTenth(points float64) float64 {
+	return math.Round(points*10) / 10 //nolint:mnd // One decimal place
+}
@@ -0,0 +1,67 @@
+// Package parse reads Zeek conn.log files into connections, as zeek-viz ingests uploads. Logs
+// may be Zeek JSON (one record per line) or TSV with its #fields header, or a mix of both.
+// Damaged lines are repaired where possible: byte order marks and text around a JSON record
+// are stripped, records concatenated on one line are split apart, and records broken across
+// two lines are joined. Lines beyond repair are skipped and recorded in a Report.
+//
+// Parse a whole log with Connections:
+//
+//	result, err := parse.Connections(ctx, file, parse.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Printf("%d connections, %d lines skipped\n", len(result.Connections), result.Report.SkippedLines)
+//
+// or feed a Parser the lines of a log that arrives piecemeal, such as a file being written:
+//
+//	parser := parse.NewParser(false)
+//	parser.Line(1, line)
+//	connections := parser.Take()
+package parse
+
+import (
+	"context"
+	"io"
+	"runtime"
+
+	"zeek-viz/models"
+)
+
+// Options control how Connections parses a log.
+type Options struct {
+	// Strict stops at the first damaged line with ErrMalformedLine instead of repairing or
+	// skipping it. Byte order marks are still stripped.
+	Strict bool
+	// Workers decode records in parallel: 0 for one per CPU, 1 to parse on the calling
+	// goroutine alone.
+	Workers int
+}
+
+// Result is a parsed log.
+type Result struct {
+	Connections []models.Connection
+	Stats       *models.ConnectionStats // Accumulated over Connections
+	Report      *Report                 // Lines recovered and skipped
+}
+
+// Connections parses the connections of a log. IPv6 hosts come out in canonical form.
+// Parsing stops with ErrCanceled once ctx is done, checking every 1024 lines. In strict mode a
+// damaged line returns ErrMalformedLine, with the Report of the result holding the line.
+// However many workers decode records, the connections, statistics, and report come out as
+// if one goroutine had parsed the log.
+func Connections(ctx context.Context, reader io.Reader, options Options) (Result, error) {
+	workers := options.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers <= 1 {
+		return parseSequentially(ctx, reader, options.Strict)
+	}
+
+	return parseParallel(ctx, reader, options.Strict, workers)
+}
+
+// result returns what the parser parsed.
+func (p *Parser) result() Result {
+	return Result{Connections: p.connections, Stats: p.stats, Report: p.report}
+}
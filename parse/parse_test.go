@@ -0,0 +1,194 @@
+package parse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"zeek-viz/synth"
+)
+
+// benchLogEnv names a conn.log to benchmark instead of the synthetic one.
+const benchLogEnv = "ZEEK_VIZ_BENCH_LOG"
+
+// syntheticLog returns a synthetic JSON conn.log of the given number of workstations over an
+// hour.
+func syntheticLog(tb testing.TB, hosts int) []byte {
+	tb.Helper()
+
+	var log bytes.Buffer
+	_, err := synth.Generate(&log, synth.Config{
+		Hosts:    hosts,
+		Duration: time.Hour,
+		Start:    time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Rate:     2,
+		Seed:     1,
+	})
+	if err != nil {
+		tb.Fatalf("generating log: %v", err)
+	}
+
+	return log.Bytes()
+}
+
+// damagedLog returns a synthetic log with damage spread across parser batches: a byte order
+// mark, garbage lines, records concatenated on one line or broken across two, a record
+// wrapped in text, and a TSV section.
+func damagedLog(t *testing.T) []byte {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSuffix(string(syntheticLog(t, 20)), "\n"), "\n")
+	if len(lines) < 4*parseBatchLines {
+		t.Fatalf("synthetic log has %d lines, want at least %d", len(lines), 4*parseBatchLines)
+	}
+
+	var log strings.Builder
+	log.WriteString(ByteOrderMark)
+	for i, line := range lines {
+		switch {
+		case i%700 == 350:
+			log.WriteString("garbage that is no record\n")
+		case i%900 == 450 && i+1 < len(lines):
+			log.WriteString(line + lines[i+1] + "\n") // Concatenated; the next line repeats a record
+		case i == parseBatchLines-1 || i == 3*parseBatchLines-1:
+			half := len(line) / 2
+			log.WriteString(line[:half] + "\n" + line[half:] + "\n") // Broken across batches
+		case i%1100 == 5:
+			log.WriteString("2024-01-01 00:00:00 zeek: " + line + "\n")
+		default:
+			log.WriteString(line + "\n")
+		}
+		if i == 2*parseBatchLines {
+			log.WriteString("#separator \\x09\n#fields\tts\tuid\tid.orig_h\tid.orig_p\tid.resp_h\tid.resp_p\tproto\tconn_state\n")
+			for j := range 5 {
+				fmt.Fprintf(&log, "1704067200.%06d\tCtsv%d\t10.1.0.%d\t5000%d\t192.0.2.1\t53\tudp\tSF\n", j, j, j, j)
+			}
+		}
+	}
+
+	return []byte(log.String())
+}
+
+func TestParallelMatchesSequential(t *testing.T) {
+	inputs := map[string][]byte{"synthetic": syntheticLog(t, 20), "damaged": damagedLog(t)}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			sequential, err := Connections(context.Background(), bytes.NewReader(input), Options{Workers: 1})
+			if err != nil {
+				t.Fatalf("sequential parse: %v", err)
+			}
+			if len(sequential.Connections) < 4*parseBatchLines {
+				t.Fatalf("sequential parse found %d connections", len(sequential.Connections))
+			}
+			if name == "damaged" && (sequential.Report.SkippedLines == 0 || sequential.Report.RecoveredLines == 0) {
+				t.Fatalf("damaged log: %d lines skipped and %d recovered, want some of each",
+					sequential.Report.SkippedLines, sequential.Report.RecoveredLines)
+			}
+			tsv := 0
+			for i := range sequential.Connections {
+				if strings.HasPrefix(sequential.Connections[i].UID, "Ctsv") {
+					tsv++
+				}
+			}
+			if want := map[string]int{"synthetic": 0, "damaged": 5}[name]; tsv != want {
+				t.Fatalf("%d connections of the TSV section, want %d", tsv, want)
+			}
+
+			for _, workers := range []int{2, 3, 8} {
+				parallel, err := Connections(context.Background(), bytes.NewReader(input), Options{Workers: workers})
+				if err != nil {
+					t.Fatalf("%d workers: %v", workers, err)
+				}
+				if len(parallel.Connections) != len(sequential.Connections) {
+					t.Fatalf("%d workers: %d connections, want %d", workers, len(parallel.Connections), len(sequential.Connections))
+				}
+				for i := range sequential.Connections {
+					if !reflect.DeepEqual(parallel.Connections[i], sequential.Connections[i]) {
+						t.Fatalf("%d workers: connection %d is %s, want %s", workers, i,
+							parallel.Connections[i].UID, sequential.Connections[i].UID)
+					}
+				}
+				if !reflect.DeepEqual(parallel.Stats, sequential.Stats) {
+					t.Errorf("%d workers: statistics differ from the sequential parse", workers)
+				}
+				if !reflect.DeepEqual(parallel.Report, sequential.Report) {
+					t.Errorf("%d workers: report %+v, want %+v", workers, parallel.Report, sequential.Report)
+				}
+			}
+		})
+	}
+}
+
+func TestStrictParallelStopsAtFirstDamagedLine(t *testing.T) {
+	input := damagedLog(t)
+	_, sequential := Connections(context.Background(), bytes.NewReader(input), Options{Strict: true, Workers: 1})
+	_, parallel := Connections(context.Background(), bytes.NewReader(input), Options{Strict: true, Workers: 4})
+	if sequential == nil || parallel == nil || sequential.Error() != parallel.Error() {
+		t.Errorf("strict parallel parse returned %v, want %v", parallel, sequential)
+	}
+}
+
+// benchmarkInput returns the log named by ZEEK_VIZ_BENCH_LOG, or a synthetic one of 500
+// workstations over an hour.
+func benchmarkInput(b *testing.B) []byte {
+	b.Helper()
+
+	if path := os.Getenv(benchLogEnv); path != "" {
+		content, err := os.ReadFile(path) //nolint:gosec // Named by the person running the benchmark
+		if err != nil {
+			b.Fatalf("reading %s: %v", path, err)
+		}
+
+		return content
+	}
+
+	return syntheticLog(b, 500)
+}
+
+// benchmarkParse parses input b.N times with the given workers, reporting throughput in bytes
+// and connections per second.
+func benchmarkParse(b *testing.B, input []byte, workers int) {
+	b.Helper()
+
+	b.SetBytes(int64(len(input)))
+	connections := 0
+	for b.Loop() {
+		result, err := Connections(context.Background(), bytes.NewReader(input), Options{Workers: workers})
+		if err != nil {
+			b.Fatal(err)
+		}
+		connections += len(result.Connections)
+	}
+	b.ReportMetric(float64(connections)/b.Elapsed().Seconds(), "connections/s")
+}
+
+// BenchmarkConnections measures parsing on one goroutine. Set ZEEK_VIZ_BENCH_LOG to parse a
+// real conn.log instead of a synthetic one.
+func BenchmarkConnections(b *testing.B) {
+	benchmarkParse(b, benchmarkInput(b), 1)
+}
+
+// BenchmarkParallelParse measures parsing with one worker, doubling up to one per CPU;
+// compare the sub-benchmarks for the speedup.
+func BenchmarkParallelParse(b *testing.B) {
+	input := benchmarkInput(b)
+	counts := []int{1}
+	for count := 2; count < runtime.GOMAXPROCS(0); count *= 2 {
+		counts = append(counts, count)
+	}
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		counts = append(counts, procs)
+	}
+
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkParse(b, input, workers)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package parse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"zeek-viz/models"
+)
+
+const (
+	parseBatchLines   = 1024 // Lines a decoding worker takes at a time
+	parseQueueBatches = 4    // Batches read ahead per worker, bounding the memory of the pipeline
+)
+
+// readLine is a line of a log read ahead of the parser. Workers decode records in advance,
+// so the parser only has to order them, recover damaged lines, and accumulate statistics.
+type readLine struct {
+	number  int
+	text    string
+	tooLong bool               // The line exceeded MaxLineLength and text is empty
+	decoded bool               // A worker decoded the line as a record, into conn or err
+	conn    *models.Connection // Record the line decoded to
+	err     error              // Why decoding the line failed
+}
+
+// lineBatch is a run of consecutive lines handed to a worker.
+type lineBatch struct {
+	lines []readLine
+	tsv   *models.TSVHeader // TSV header directives preceding the lines, nil before any
+	err   error             // Read error or cancellation ending the input after the lines
+	done  chan struct{}     // Closed once a worker decoded the lines
+}
+
+// parseParallel parses connections as Connections does, with workers decoding JSON and TSV
+// records in parallel. A reader goroutine splits the input into batches of
+// lines, which the workers decode in any order while the parser takes them back in input
+// order, so connections, statistics, and the parse report come out as if one goroutine had
+// parsed the log.
+func parseParallel(ctx context.Context, reader io.Reader, strict bool, workers int) (Result, error) {
+	parser := NewParser(strict)
+	work := make(chan *lineBatch, workers)
+	ordered := make(chan *lineBatch, workers*parseQueueBatches)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		readBatches(ctx, reader, work, ordered, stop)
+	})
+	for range workers {
+		wg.Go(func() {
+			for batch := range work {
+				for i := range batch.lines {
+					batch.lines[i].decode(batch.tsv)
+				}
+				close(batch.done)
+			}
+		})
+	}
+	defer wg.Wait() // The reader must be done with reader before callers read what it fed
+	defer close(stop)
+
+	for batch := range ordered {
+		<-batch.done
+		for i := range batch.lines {
+			err := parser.parseRead(&batch.lines[i])
+			if err != nil {
+				return Result{Report: parser.report}, err
+			}
+		}
+		switch {
+		case errors.Is(batch.err, ErrCanceled):
+			return Result{}, batch.err
+		case batch.err != nil:
+			return Result{}, fmt.Errorf("%w: %w", errReading, batch.err)
+		}
+	}
+	parser.Finish()
+
+	return parser.result(), nil
+}
+
+// parseSequentially parses connections line by line on the calling goroutine.
+func parseSequentially(ctx context.Context, reader io.Reader, strict bool) (Result, error) {
+	parser := NewParser(strict)
+	lines := NewLineReader(reader)
+
+	for lineNumber := 1; ; lineNumber++ {
+		err := CheckCanceled(ctx, lineNumber)
+		if err != nil {
+			return Result{}, err
+		}
+
+		line, err := lines.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			parser.Finish()
+
+			return parser.result(), nil
+		case errors.Is(err, ErrLineTooLong):
+			err = parser.LongLine(lineNumber)
+		case err != nil:
+			return Result{}, fmt.Errorf("%w: %w", errReading, err)
+		default:
+			err = parser.Line(lineNumber, line)
+		}
+		if err != nil {
+			return Result{Report: parser.report}, err
+		}
+	}
+}
+
+// readBatches reads the lines of a log into batches, sending each to the workers and then, in
+// order, to the parser, until the input ends, fails, or stop is closed. It follows the TSV
+// header directives as the parser does, and ends a batch after every directive, so each
+// batch's lines decode with the header the parser will have when it reaches them.
+func readBatches(ctx context.Context, reader io.Reader, work, ordered chan<- *lineBatch, stop <-chan struct{}) {
+	defer close(ordered)
+	defer close(work)
+
+	send := func(batch *lineBatch) bool {
+		select {
+		case work <- batch:
+		case <-stop:
+			return false
+		}
+		select {
+		case ordered <- batch:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	lines := NewLineReader(reader)
+	var tsv *models.TSVHeader
+	batch := &lineBatch{lines: make([]readLine, 0, parseBatchLines), done: make(chan struct{})}
+	for lineNumber := 1; ; lineNumber++ {
+		err := CheckCanceled(ctx, lineNumber)
+		var text string
+		if err == nil {
+			text, err = lines.Next()
+		}
+		switch {
+		case errors.Is(err, ErrLineTooLong):
+			batch.lines = append(batch.lines, readLine{number: lineNumber, tooLong: true})
+		case errors.Is(err, io.EOF):
+			send(batch)
+
+			return
+		case err != nil:
+			batch.err = err
+			send(batch)
+
+			return
+		default:
+			batch.lines = append(batch.lines, readLine{number: lineNumber, text: text})
+		}
+
+		header, changed := followHeader(tsv, text)
+		if !changed && len(batch.lines) < parseBatchLines {
+			continue
+		}
+		if !send(batch) {
+			return
+		}
+		tsv = header
+		batch = &lineBatch{lines: make([]readLine, 0, parseBatchLines), tsv: tsv, done: make(chan struct{})}
+	}
+}
+
+// followHeader applies the TSV header directive on a line to a copy of tsv. It reports false,
+// with tsv unchanged, for lines that are not directives.
+func followHeader(tsv *models.TSVHeader, line string) (*models.TSVHeader, bool) {
+	line = strings.TrimPrefix(line, ByteOrderMark)
+	if !strings.HasPrefix(line, "#") {
+		return tsv, false
+	}
+
+	header := models.NewTSVHeader()
+	if tsv != nil {
+		*header = *tsv // Directives replace the field slices rather than modifying them
+	}
+	if header.ParseDirective(line) != nil {
+		return tsv, false
+	}
+
+	return header, true
+}
+
+// decode decodes the line as the parser would decode a clean record, given the TSV header
+// directives before it. Blank, too long, and # lines are left to the parser.
+func (l *readLine) decode(tsv *models.TSVHeader) {
+	text := strings.TrimPrefix(l.text, ByteOrderMark)
+	if l.tooLong || strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+		return
+	}
+
+	if tsv != nil && tsv.HasFields() && !strings.HasPrefix(strings.TrimSpace(text), "{") {
+		l.conn, l.err = tsv.UnmarshalConnection(text)
+	} else {
+		l.conn, l.err = models.UnmarshalConnection([]byte(text))
+	}
+	l.decoded = true
+}
@@ -0,0 +1,329 @@
+package parse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const (
+	MaxLineLength    = 1 << 20  // 1MB, longer lines are dropped
+	ByteOrderMark    = "\uFEFF" // UTF-8 BOM some editors and exporters prepend
+	cancelCheckLines = 1024     // Lines parsed between checks whether parsing was canceled
+
+	recoveredBOM          = "bom"           // Byte order mark stripped
+	recoveredEmbedded     = "embedded_json" // Text around a JSON record stripped (e.g. syslog prefixes)
+	recoveredConcatenated = "concatenated"  // Several records on one line split apart
+	recoveredSplitLine    = "split_line"    // Record broken across two lines joined
+)
+
+var (
+	// ErrCanceled ends parsing once its context is done.
+	ErrCanceled = errors.New("parsing canceled")
+	// ErrMalformedLine ends parsing in strict mode at the first line that isn't a record.
+	ErrMalformedLine = errors.New("malformed line")
+	// ErrLineTooLong is returned by LineReader for lines over MaxLineLength.
+	ErrLineTooLong = errors.New("line exceeds 1MB")
+
+	errReading         = errors.New("error reading data")
+	errTruncatedRecord = errors.New("truncated JSON record")
+	errNonJSONText     = errors.New("line contains no JSON record")
+	errTSVLine         = errors.New("Zeek TSV line without a #fields header")
+	errCommentLine     = errors.New("comment line")
+)
+
+// CheckCanceled returns an ErrCanceled wrapping the context's error once ctx is done,
+// checking every cancelCheckLines lines, so parsing stops when the client disconnects or the
+// server shuts down.
+func CheckCanceled(ctx context.Context, lineNumber int) error {
+	if lineNumber%cancelCheckLines != 0 {
+		return nil
+	}
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("%w after %d lines: %w", ErrCanceled, lineNumber, err)
+	}
+
+	return nil
+}
+
+// LineReader reads lines of any length, reporting lines over MaxLineLength instead of
+// failing like bufio.Scanner.
+type LineReader struct {
+	reader *bufio.Reader
+}
+
+// NewLineReader reads the lines of r.
+func NewLineReader(r io.Reader) *LineReader {
+	return &LineReader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next line without its line ending. Lines over MaxLineLength are
+// consumed and returned as ErrLineTooLong; io.EOF ends the input.
+func (l *LineReader) Next() (string, error) {
+	var line []byte
+	tooLong := false
+
+	for {
+		chunk, isPrefix, err := l.reader.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) && (len(line) > 0 || tooLong) {
+				break // Last line without a trailing newline
+			}
+
+			return "", err //nolint:wrapcheck // io.EOF must stay comparable
+		}
+
+		if !tooLong && len(line)+len(chunk) > MaxLineLength {
+			tooLong, line = true, nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+		if !isPrefix {
+			break
+		}
+	}
+
+	if tooLong {
+		return "", ErrLineTooLong
+	}
+
+	return string(line), nil
+}
+
+// Parser turns log lines into connections, recovering records from damaged lines where
+// possible and recording every recovered or dropped line in its report. Zeek JSON and TSV
+// lines are both accepted; TSV lines are read with the preceding header. Feed it lines in
+// order, as a LineReader returns them, to parse a log that arrives piecemeal, such as a
+// followed file; Connections parses a whole log at once.
+type Parser struct {
+	strict      bool
+	report      *Report
+	stats       *models.ConnectionStats
+	connections []models.Connection
+	pending     string // Truncated line that may continue on the next line
+	pendingLine int
+	tsv         *models.TSVHeader // Header directives of a TSV log, once seen
+}
+
+// NewParser creates a parser. In strict mode recovery is limited to stripping byte order
+// marks, and the first damaged line aborts parsing.
+func NewParser(strict bool) *Parser {
+	return &Parser{strict: strict, report: NewReport(), stats: models.NewConnectionStats()}
+}
+
+// Line handles one line, numbered from 1. In strict mode a damaged line returns
+// ErrMalformedLine.
+func (p *Parser) Line(lineNumber int, line string) error {
+	return p.parseLine(lineNumber, line, nil)
+}
+
+// Take returns the connections parsed since the last call and starts their statistics
+// afresh. The report keeps counting.
+func (p *Parser) Take() []models.Connection {
+	connections := p.connections
+	p.connections = nil
+	p.stats = models.NewConnectionStats()
+
+	return connections
+}
+
+// Report returns the lines the parser recovered and skipped so far.
+func (p *Parser) Report() *Report {
+	return p.report
+}
+
+// parseRead handles a line read ahead, using the record a worker decoded from it.
+func (p *Parser) parseRead(line *readLine) error {
+	if line.tooLong {
+		return p.LongLine(line.number)
+	}
+
+	return p.parseLine(line.number, line.text, line)
+}
+
+// parseLine handles one line, decoding it unless decoded holds the outcome already.
+func (p *Parser) parseLine(lineNumber int, line string, decoded *readLine) error {
+	if stripped, found := strings.CutPrefix(line, ByteOrderMark); found {
+		line = stripped
+		p.report.recover(recoveredBOM, 0)
+	}
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, "#") && p.Header(line) {
+		return nil
+	}
+	p.report.TotalLines++
+
+	if p.tsv != nil && p.tsv.HasFields() && !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return p.parseTSV(lineNumber, line, decoded)
+	}
+
+	if p.pending != "" {
+		pending := p.pending
+		p.pending = ""
+
+		records, _, err := recoverRecords(pending + line)
+		if err == nil {
+			p.add(records)
+			p.report.recover(recoveredSplitLine, len(records))
+
+			return nil
+		}
+		p.report.Skip(p.pendingLine, pending, errTruncatedRecord)
+	}
+
+	var conn *models.Connection
+	var err error
+	if decoded != nil && decoded.decoded {
+		conn, err = decoded.conn, decoded.err
+	} else {
+		conn, err = models.UnmarshalConnection([]byte(line))
+	}
+	if err == nil {
+		p.add([]*models.Connection{conn})
+
+		return nil
+	}
+	if p.strict {
+		p.report.Skip(lineNumber, line, err)
+
+		return fmt.Errorf("%w %d: %w", ErrMalformedLine, lineNumber, err)
+	}
+
+	records, category, err := recoverRecords(line)
+	switch {
+	case err == nil:
+		p.add(records)
+		p.report.recover(category, len(records))
+	case errors.Is(err, errTruncatedRecord):
+		p.pending, p.pendingLine = line, lineNumber // Retried joined with the next line
+	default:
+		p.report.Skip(lineNumber, line, err)
+	}
+
+	return nil
+}
+
+// Header reads a Zeek TSV header directive. It reports false for other # lines, which are
+// dropped as comments.
+func (p *Parser) Header(line string) bool {
+	if p.tsv == nil {
+		p.tsv = models.NewTSVHeader()
+	}
+
+	return p.tsv.ParseDirective(line) == nil
+}
+
+// parseTSV handles a data line of a TSV log. TSV lines are not repaired; in strict mode a
+// line that doesn't match the header aborts parsing with ErrMalformedLine.
+func (p *Parser) parseTSV(lineNumber int, line string, decoded *readLine) error {
+	var conn *models.Connection
+	var err error
+	if decoded != nil && decoded.decoded {
+		conn, err = decoded.conn, decoded.err
+	} else {
+		conn, err = p.tsv.UnmarshalConnection(line)
+	}
+	if err != nil {
+		p.report.Skip(lineNumber, line, err)
+		if p.strict {
+			return fmt.Errorf("%w %d: %w", ErrMalformedLine, lineNumber, err)
+		}
+
+		return nil
+	}
+	p.add([]*models.Connection{conn})
+
+	return nil
+}
+
+// LongLine records a dropped line over MaxLineLength, which LineReader reported as
+// ErrLineTooLong.
+func (p *Parser) LongLine(lineNumber int) error {
+	p.report.TotalLines++
+	p.report.Skip(lineNumber, "", ErrLineTooLong)
+	if p.strict {
+		return fmt.Errorf("%w %d: %w", ErrMalformedLine, lineNumber, ErrLineTooLong)
+	}
+
+	return nil
+}
+
+// Finish drops a truncated last line. Call it once the log ended.
+func (p *Parser) Finish() {
+	if p.pending != "" {
+		p.report.Skip(p.pendingLine, p.pending, errTruncatedRecord)
+		p.pending = ""
+	}
+	p.report.ParsedLines = len(p.connections)
+}
+
+// add appends parsed records, with IPv6 hosts in canonical form.
+func (p *Parser) add(records []*models.Connection) {
+	for _, conn := range records {
+		conn.OrigHost = models.CanonicalHost(conn.OrigHost)
+		conn.RespHost = models.CanonicalHost(conn.RespHost)
+		p.connections = append(p.connections, *conn)
+		p.stats.Add(conn)
+	}
+}
+
+// recoverRecords extracts the JSON records from a line that is not a single clean record:
+// text before the first or after the last record is ignored, and several records on one
+// line are split apart. It returns the recovery category, or an error classifying why
+// nothing could be recovered.
+func recoverRecords(line string) ([]*models.Connection, string, error) {
+	trimmed := strings.TrimSpace(line)
+	start := strings.IndexByte(trimmed, '{')
+	switch {
+	case strings.HasPrefix(trimmed, "#"):
+		return nil, "", errCommentLine
+	case start < 0 && strings.Contains(trimmed, "\t"):
+		return nil, "", errTSVLine
+	case start < 0:
+		return nil, "", errNonJSONText
+	}
+
+	var records []*models.Connection
+	decoder := json.NewDecoder(strings.NewReader(trimmed[start:]))
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			var conn *models.Connection
+			conn, err = models.UnmarshalConnection(raw)
+			if err == nil {
+				records = append(records, conn)
+
+				continue
+			}
+		}
+
+		if len(records) > 0 {
+			break // Trailing text after the last record
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, "", errTruncatedRecord
+		}
+
+		return nil, "", err //nolint:wrapcheck // Classified by errorReason
+	}
+
+	if len(records) > 1 {
+		return records, recoveredConcatenated, nil
+	}
+
+	return records, recoveredEmbedded, nil
+}
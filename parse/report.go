@@ -0,0 +1,131 @@
+package parse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+const (
+	maxParseErrorSamples = 20  // Offending lines kept per dataset
+	maxResponseSamples   = 5   // Offending lines included in upload responses
+	maxSampleLength      = 256 // Characters kept of each offending line
+)
+
+// Report summarizes the lines recovered and skipped while parsing a log. Reasons counts
+// dropped lines by category, Recovered counts repairs by category.
+type Report struct {
+	TotalLines     int            `json:"total_lines"`     //nolint:tagliatelle // API consistency
+	ParsedLines    int            `json:"parsed_lines"`    //nolint:tagliatelle // API consistency
+	RecoveredLines int            `json:"recovered_lines"` //nolint:tagliatelle // API consistency
+	SkippedLines   int            `json:"skipped_lines"`   //nolint:tagliatelle // API consistency
+	Reasons        map[string]int `json:"reasons"`
+	Recovered      map[string]int `json:"recovered"`
+	Duplicates     int            `json:"duplicates"` // Records repeating an earlier UID, collapsed unless Dedup is none
+	Dedup          string         `json:"dedup,omitempty"`
+	Samples        []SkippedLine  `json:"samples,omitempty"`
+}
+
+// SkippedLine is one line that could not be parsed.
+type SkippedLine struct {
+	Line    int    `json:"line"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error"`
+	Content string `json:"content"`
+}
+
+// NewReport creates an empty parse report.
+func NewReport() *Report {
+	return &Report{Reasons: make(map[string]int), Recovered: make(map[string]int)}
+}
+
+// recover records a repaired line yielding the given number of records. Byte order marks
+// are counted without records, as the line is then parsed normally.
+func (p *Report) recover(category string, records int) {
+	p.Recovered[category]++
+	if records > 0 {
+		p.RecoveredLines++
+	}
+}
+
+// Skip records a line that could not be parsed, keeping the first few as samples.
+func (p *Report) Skip(lineNumber int, line string, err error) {
+	reason := errorReason(err)
+	p.SkippedLines++
+	p.Reasons[reason]++
+
+	if len(p.Samples) < maxParseErrorSamples {
+		if len(line) > maxSampleLength {
+			line = strings.ToValidUTF8(line[:maxSampleLength], "")
+		}
+		p.Samples = append(p.Samples, SkippedLine{
+			Line:    lineNumber,
+			Reason:  reason,
+			Error:   err.Error(),
+			Content: line,
+		})
+	}
+}
+
+// Summary returns the report with only its first few samples, for inclusion in upload
+// responses.
+func (p *Report) Summary() *Report {
+	if p == nil {
+		return nil
+	}
+
+	summary := *p
+	summary.Samples = summary.Samples[:min(len(summary.Samples), maxResponseSamples)]
+
+	return &summary
+}
+
+// Warning describes the skipped lines for upload responses, or returns "" if none were.
+func (p *Report) Warning() string {
+	if p == nil || p.SkippedLines == 0 {
+		return ""
+	}
+
+	share := 100 * float64(p.SkippedLines) / float64(max(p.TotalLines, 1)) //nolint:mnd // Percent
+
+	return fmt.Sprintf("%d of %d lines (%.1f%%) could not be parsed and were skipped", p.SkippedLines, p.TotalLines, share)
+}
+
+// Skipped returns the number of skipped lines, 0 without a report.
+func (p *Report) Skipped() int {
+	if p == nil {
+		return 0
+	}
+
+	return p.SkippedLines
+}
+
+// errorReason classifies a parse error.
+func errorReason(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, errTruncatedRecord):
+		return "truncated"
+	case errors.Is(err, errNonJSONText):
+		return "non_json_text"
+	case errors.Is(err, errTSVLine):
+		return "tsv_line"
+	case errors.Is(err, errCommentLine):
+		return "comment"
+	case errors.Is(err, models.ErrInvalidTSVLine):
+		return "invalid_tsv"
+	case errors.Is(err, ErrLineTooLong):
+		return "line_too_long"
+	case errors.As(err, &syntaxErr):
+		return "invalid_json"
+	case errors.As(err, &typeErr):
+		return "not_an_object"
+	default:
+		return "other"
+	}
+}
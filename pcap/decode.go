@@ -0,0 +1,247 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// Link types, as pcap and pcapng number them.
+const (
+	linkNull     = 0   // BSD loopback, with the address family in host order
+	linkEthernet = 1   // Ethernet
+	linkRaw      = 101 // Raw IPv4 or IPv6
+	linkRawBSD   = 12  // Raw IP, as OpenBSD numbers it
+	linkRawAlt   = 14  // Raw IP, as some BSDs number it
+	linkLoop     = 108 // OpenBSD loopback, with the address family in network order
+	linkLinuxSLL = 113 // Linux cooked capture, as tcpdump -i any writes it
+	linkIPv4     = 228 // Raw IPv4
+	linkIPv6     = 229 // Raw IPv6
+	linkSLL2     = 276 // Linux cooked capture version 2
+)
+
+// Header sizes and fields of the decoded protocols.
+const (
+	etherHeader    = 14     // Destination, source, and EtherType
+	vlanTag        = 4      // 802.1Q tag before the inner EtherType
+	sllHeader      = 16     // Linux cooked header
+	sll2Header     = 20     // Linux cooked header, version 2
+	loopHeader     = 4      // BSD loopback address family
+	etherIPv4      = 0x0800 // EtherType of IPv4
+	etherIPv6      = 0x86dd // EtherType of IPv6
+	etherVLAN      = 0x8100 // EtherType of an 802.1Q tag
+	etherQinQ      = 0x88a8 // EtherType of an 802.1ad service tag
+	etherQinQOld   = 0x9100 // EtherType of pre-standard stacked VLAN tags
+	familyIPv4     = 2      // AF_INET on every platform
+	ipv4Header     = 20     // IPv4 header without options
+	ipv6Header     = 40     // Fixed IPv6 header
+	fragmentOffset = 0x1fff // Fragment offset bits of the IPv4 flags field
+	tcpHeader      = 20     // TCP header without options
+	udpHeader      = 8      // UDP header
+	icmpHeader     = 4      // ICMP type, code, and checksum
+
+	protoICMP   = 1  // IP protocol number of ICMP
+	protoTCP    = 6  // IP protocol number of TCP
+	protoUDP    = 17 // IP protocol number of UDP
+	protoICMPv6 = 58 // IP protocol number of ICMPv6
+
+	ipv6HopByHop    = 0  // IPv6 hop-by-hop options header
+	ipv6Routing     = 43 // IPv6 routing header
+	ipv6Fragment    = 44 // IPv6 fragment header
+	ipv6DestOptions = 60 // IPv6 destination options header
+)
+
+// TCP flags.
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
+// segment is a decoded IP packet of a supported transport protocol.
+type segment struct {
+	src, dst         netip.Addr
+	srcPort, dstPort int // ICMP type and code for ICMP
+	proto            int
+	ipBytes          int // IP total length, as the packet claims it
+	payload          int // Transport payload bytes, as the packet claims them
+
+	// TCP
+	flags  uint8
+	seq    uint32
+	window uint16
+}
+
+// decode decodes a captured frame into a segment, reporting false for frames of other
+// protocols, non-first fragments, and frames truncated before the transport header.
+func decode(linkType uint16, data []byte) (segment, bool) {
+	switch linkType {
+	case linkEthernet:
+		return decodeEthernet(data)
+	case linkRaw, linkRawBSD, linkRawAlt, linkIPv4, linkIPv6:
+		return decodeIP(data)
+	case linkNull, linkLoop:
+		if len(data) < loopHeader {
+			return segment{}, false
+		}
+		family := binary.LittleEndian.Uint32(data)
+		if linkType == linkLoop || family > 0xffff { // Written by a big-endian host
+			family = binary.BigEndian.Uint32(data)
+		}
+		if family != familyIPv4 { // IPv6 families differ by platform; the version nibble tells
+			return decodeIP(data[loopHeader:])
+		}
+
+		return decodeIPv4(data[loopHeader:])
+	case linkLinuxSLL:
+		if len(data) < sllHeader {
+			return segment{}, false
+		}
+
+		return decodeEtherType(binary.BigEndian.Uint16(data[sllHeader-2:]), data[sllHeader:])
+	case linkSLL2:
+		if len(data) < sll2Header {
+			return segment{}, false
+		}
+
+		return decodeEtherType(binary.BigEndian.Uint16(data), data[sll2Header:])
+	default:
+		return segment{}, false
+	}
+}
+
+// decodeEthernet decodes an Ethernet frame, skipping VLAN tags.
+func decodeEthernet(data []byte) (segment, bool) {
+	if len(data) < etherHeader {
+		return segment{}, false
+	}
+	etherType, data := binary.BigEndian.Uint16(data[etherHeader-2:]), data[etherHeader:]
+	for etherType == etherVLAN || etherType == etherQinQ || etherType == etherQinQOld {
+		if len(data) < vlanTag {
+			return segment{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[2:]), data[vlanTag:]
+	}
+
+	return decodeEtherType(etherType, data)
+}
+
+// decodeEtherType decodes the payload of a frame by its EtherType.
+func decodeEtherType(etherType uint16, data []byte) (segment, bool) {
+	switch etherType {
+	case etherIPv4:
+		return decodeIPv4(data)
+	case etherIPv6:
+		return decodeIPv6(data)
+	default:
+		return segment{}, false
+	}
+}
+
+// decodeIP decodes an IPv4 or IPv6 packet by its version.
+func decodeIP(data []byte) (segment, bool) {
+	if len(data) == 0 {
+		return segment{}, false
+	}
+	switch data[0] >> 4 {
+	case 4: //nolint:mnd // IP version
+		return decodeIPv4(data)
+	case 6: //nolint:mnd // IP version
+		return decodeIPv6(data)
+	default:
+		return segment{}, false
+	}
+}
+
+// decodeIPv4 decodes an IPv4 packet.
+func decodeIPv4(data []byte) (segment, bool) {
+	if len(data) < ipv4Header || data[0]>>4 != 4 {
+		return segment{}, false
+	}
+	headerLength := int(data[0]&0x0f) * 4 //nolint:mnd // IHL counts 32-bit words
+	if headerLength < ipv4Header || len(data) < headerLength {
+		return segment{}, false
+	}
+	if binary.BigEndian.Uint16(data[6:])&fragmentOffset != 0 {
+		return segment{}, false // The transport header is in the first fragment
+	}
+
+	seg := segment{
+		src:     netip.AddrFrom4([4]byte(data[12:16])),
+		dst:     netip.AddrFrom4([4]byte(data[16:20])),
+		ipBytes: int(binary.BigEndian.Uint16(data[2:])),
+	}
+	if seg.ipBytes == 0 { // Captured before segmentation offload filled it in
+		seg.ipBytes = len(data)
+	}
+
+	return decodeTransport(seg, int(data[9]), data[headerLength:], seg.ipBytes-headerLength)
+}
+
+// decodeIPv6 decodes an IPv6 packet, skipping extension headers.
+func decodeIPv6(data []byte) (segment, bool) {
+	if len(data) < ipv6Header || data[0]>>4 != 6 {
+		return segment{}, false
+	}
+	payloadLength := int(binary.BigEndian.Uint16(data[4:]))
+	seg := segment{
+		src:     netip.AddrFrom16([16]byte(data[8:24])),
+		dst:     netip.AddrFrom16([16]byte(data[24:40])),
+		ipBytes: ipv6Header + payloadLength,
+	}
+
+	next, data := int(data[6]), data[ipv6Header:]
+	for {
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOptions:
+			if len(data) < 2 {
+				return segment{}, false
+			}
+			length := (int(data[1]) + 1) * 8 //nolint:mnd // Lengths count 8-byte units beyond the first
+			if len(data) < length {
+				return segment{}, false
+			}
+			next, data, payloadLength = int(data[0]), data[length:], payloadLength-length
+		case ipv6Fragment:
+			if len(data) < 8 || binary.BigEndian.Uint16(data[2:])&^0x7 != 0 { //nolint:mnd // Fragment header and offset bits
+				return segment{}, false // The transport header is in the first fragment
+			}
+			next, data, payloadLength = int(data[0]), data[8:], payloadLength-8 //nolint:mnd // Fragment header
+		default:
+			return decodeTransport(seg, next, data, payloadLength)
+		}
+	}
+}
+
+// decodeTransport decodes the TCP, UDP, or ICMP header of a packet whose IP header claims
+// length bytes of transport header and payload.
+func decodeTransport(seg segment, proto int, data []byte, length int) (segment, bool) {
+	seg.proto = proto
+	switch proto {
+	case protoTCP:
+		if len(data) < tcpHeader {
+			return segment{}, false
+		}
+		seg.srcPort, seg.dstPort = int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+		seg.seq = binary.BigEndian.Uint32(data[4:])
+		seg.flags, seg.window = data[13], binary.BigEndian.Uint16(data[14:])
+		seg.payload = length - int(data[12]>>4)*4 //nolint:mnd // Data offset counts 32-bit words
+	case protoUDP:
+		if len(data) < udpHeader {
+			return segment{}, false
+		}
+		seg.srcPort, seg.dstPort = int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+		seg.payload = length - udpHeader
+	case protoICMP, protoICMPv6:
+		if len(data) < icmpHeader {
+			return segment{}, false
+		}
+		seg.srcPort, seg.dstPort = int(data[0]), int(data[1])
+		seg.payload = length - icmpHeader
+	default:
+		return segment{}, false
+	}
+	seg.payload = max(seg.payload, 0)
+
+	return seg, true
+}
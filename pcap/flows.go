@@ -0,0 +1,395 @@
+package pcap
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	tcpTimeout    = 5 * time.Minute // Inactivity after which a TCP flow ends, as Zeek's tcp_inactivity_timeout
+	udpTimeout    = time.Minute     // Inactivity after which a UDP flow ends, as Zeek's udp_inactivity_timeout
+	icmpTimeout   = time.Minute     // Inactivity after which an ICMP flow ends, as Zeek's icmp_inactivity_timeout
+	cancelCheck   = 4096            // Packets between checks whether extraction was canceled
+	uidBytes      = 12              // Hash bytes of a generated UID, as many bits as Zeek's
+	uidBase       = 62              // Digits and letters of both cases
+	halfSequences = 1 << 31         // Sequence distance beyond which a segment lies before the flow's start
+)
+
+// Summary counts what Extract read.
+type Summary struct {
+	Packets int // Packets in the capture
+	Skipped int // Packets of other protocols, later fragments, and packets truncated before their transport header
+	Flows   int // Connections extracted
+
+	Truncated bool // The capture ends within a packet, which was dropped
+}
+
+// flowKey identifies the flow of a packet regardless of its direction.
+type flowKey struct {
+	low, high   netip.AddrPort // Endpoints, ordered
+	proto       int
+	icmpRequest int // ICMP type of the request a reply answers, -1 for TCP and UDP
+}
+
+// endpoint is one side of a flow.
+type endpoint struct {
+	packets, ipBytes int
+	payload          int // Payload bytes of UDP and ICMP
+
+	// TCP
+	syn, synAck, fin, rst bool
+	base                  uint32 // Sequence number of the first payload byte
+	based                 bool
+	end                   uint32 // Sequence distance of the highest payload byte sent, from base
+}
+
+// flow is a connection being assembled.
+type flow struct {
+	orig, resp  netip.AddrPort
+	proto       int
+	start, last time.Time
+	sides       [2]endpoint // Originator, responder
+	history     []byte
+}
+
+// Extract reads a capture and returns its flows as Zeek would log them in conn.log, ordered by
+// start. The originator of a flow is the sender of its first packet, or of the SYN when the
+// capture starts with the SYN-ACK. TCP flows end after 5 minutes of inactivity, or when a new
+// SYN follows their close; UDP and ICMP flows after a minute. Connection states and
+// histories follow Zeek's definitions; payload bytes of TCP follow sequence numbers, so
+// retransmissions aren't counted twice. A capture cut off within a packet yields the flows
+// before it. Reading stops with ctx's error once ctx is done.
+func Extract(ctx context.Context, r io.Reader) ([]models.Connection, Summary, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, Summary{}, err
+	}
+
+	var summary Summary
+	open := make(map[flowKey]*flow)
+	var done []*flow
+	for {
+		packet, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) { // Cut off while written, as when tcpdump was killed
+			summary.Truncated = true
+
+			break
+		}
+		if err != nil {
+			return nil, summary, err
+		}
+		summary.Packets++
+		if summary.Packets%cancelCheck == 0 && ctx.Err() != nil {
+			return nil, summary, fmt.Errorf("packet capture: %w", ctx.Err())
+		}
+
+		seg, ok := decode(packet.LinkType, packet.Data)
+		if !ok {
+			summary.Skipped++
+
+			continue
+		}
+		key := newFlowKey(&seg)
+		current := open[key]
+		if current != nil && current.ends(&seg, packet.Time) {
+			done = append(done, current)
+			current = nil
+		}
+		if current == nil {
+			current = newFlow(&seg, packet.Time)
+			open[key] = current
+		}
+		current.add(&seg, packet.Time)
+	}
+	for _, current := range open {
+		done = append(done, current)
+	}
+
+	slices.SortFunc(done, func(x, y *flow) int {
+		return cmp.Or(x.start.Compare(y.start), strings.Compare(x.orig.String(), y.orig.String()),
+			strings.Compare(x.resp.String(), y.resp.String()))
+	})
+	connections := make([]models.Connection, len(done))
+	for i, current := range done {
+		connections[i] = current.connection()
+	}
+	summary.Flows = len(connections)
+
+	return connections, summary, nil
+}
+
+// newFlowKey returns the flow key of a segment.
+func newFlowKey(seg *segment) flowKey {
+	src, dst := netip.AddrPortFrom(seg.src, uint16(seg.srcPort)), netip.AddrPortFrom(seg.dst, uint16(seg.dstPort)) //nolint:gosec // Ports fit
+	key := flowKey{proto: seg.proto, icmpRequest: -1}
+	if seg.proto == protoICMP || seg.proto == protoICMPv6 {
+		src, dst = netip.AddrPortFrom(seg.src, 0), netip.AddrPortFrom(seg.dst, 0) // Both directions share the type of the request
+		key.icmpRequest = icmpRequestType(seg.proto, seg.srcPort)
+	}
+	if src.Compare(dst) > 0 {
+		src, dst = dst, src
+	}
+	key.low, key.high = src, dst
+
+	return key
+}
+
+// icmpRequestType returns the type of the request an ICMP message of type kind answers, or
+// kind itself for requests and unpaired messages.
+func icmpRequestType(proto, kind int) int {
+	requests := map[int]int{0: 8, 14: 13, 16: 15, 18: 17} // Echo, timestamp, information, and address mask replies
+	if proto == protoICMPv6 {
+		requests = map[int]int{129: 128, 134: 133, 136: 135} // Echo reply, router and neighbor advertisements
+	}
+	if request, isReply := requests[kind]; isReply {
+		return request
+	}
+
+	return kind
+}
+
+// newFlow starts a flow with its first segment. A SYN-ACK makes its receiver the originator,
+// as the SYN was missed.
+func newFlow(seg *segment, now time.Time) *flow {
+	current := &flow{
+		orig:  netip.AddrPortFrom(seg.src, uint16(seg.srcPort)), //nolint:gosec // Ports fit
+		resp:  netip.AddrPortFrom(seg.dst, uint16(seg.dstPort)), //nolint:gosec // Ports fit
+		proto: seg.proto,
+		start: now,
+	}
+	if seg.proto == protoTCP && seg.flags&(flagSYN|flagACK) == flagSYN|flagACK {
+		current.orig, current.resp = current.resp, current.orig
+		current.history = append(current.history, '^')
+	}
+
+	return current
+}
+
+// ends reports whether a segment starts a new flow after the current one: after the
+// inactivity timeout of the protocol, or with a SYN once a TCP connection closed.
+func (f *flow) ends(seg *segment, now time.Time) bool {
+	timeout := udpTimeout
+	switch f.proto {
+	case protoTCP:
+		timeout = tcpTimeout
+	case protoICMP, protoICMPv6:
+		timeout = icmpTimeout
+	}
+	if now.Sub(f.last) > timeout {
+		return true
+	}
+
+	closed := f.sides[0].rst || f.sides[1].rst || f.sides[0].fin && f.sides[1].fin
+
+	return f.proto == protoTCP && closed && seg.flags&(flagSYN|flagACK) == flagSYN
+}
+
+// direction returns the side of the flow that sent a segment: 0 for the originator, 1 for the
+// responder. ICMP ports are message types, so only addresses tell.
+func (f *flow) direction(seg *segment) int {
+	if seg.src != f.orig.Addr() || seg.proto != protoICMP && seg.proto != protoICMPv6 && seg.srcPort != int(f.orig.Port()) {
+		return 1
+	}
+
+	return 0
+}
+
+// add accounts a segment to the flow.
+func (f *flow) add(seg *segment, now time.Time) {
+	dir := f.direction(seg)
+	side := &f.sides[dir]
+	side.packets++
+	side.ipBytes += seg.ipBytes
+	f.last = now
+
+	switch seg.proto {
+	case protoTCP:
+		f.addTCP(dir, seg)
+	case protoUDP:
+		side.payload += seg.payload
+		if seg.payload > 0 {
+			f.record(dir, 'D')
+		}
+	default:
+		side.payload += seg.payload
+	}
+}
+
+// addTCP tracks the flags and sequence numbers of a TCP segment, recording the history
+// letters it adds.
+func (f *flow) addTCP(dir int, seg *segment) {
+	side := &f.sides[dir]
+	syn, ack, fin, rst := seg.flags&flagSYN != 0, seg.flags&flagACK != 0, seg.flags&flagFIN != 0, seg.flags&flagRST != 0
+	switch {
+	case syn && (fin || rst):
+		f.record(dir, 'Q')
+	case syn && ack:
+		side.synAck = true
+		f.record(dir, 'H')
+	case syn:
+		side.syn = true
+		f.record(dir, 'S')
+	}
+	if fin {
+		side.fin = true
+		f.record(dir, 'F')
+	}
+	if rst {
+		side.rst = true
+		f.record(dir, 'R')
+	}
+	if seg.window == 0 && !syn && !rst {
+		f.record(dir, 'W')
+	}
+
+	seq := seg.seq
+	if syn {
+		seq++ // The SYN takes a sequence number before the first payload byte
+	}
+	if !side.based {
+		side.base, side.based = seq, true
+	}
+	if seg.payload == 0 {
+		if ack && !syn && !fin && !rst {
+			f.record(dir, 'A')
+		}
+
+		return
+	}
+
+	offset := seq - side.base
+	if offset >= halfSequences { // Sent before the first segment seen, as reordered
+		return
+	}
+	end := offset + uint32(seg.payload) //nolint:gosec // Payloads fit
+	if end <= side.end {
+		f.record(dir, 'T')
+
+		return
+	}
+	side.end = end
+	f.record(dir, 'D')
+}
+
+// record appends a history letter the first time a side of the flow does what it stands for:
+// uppercase for the originator and lowercase for the responder.
+func (f *flow) record(dir int, letter byte) {
+	if dir == 1 {
+		letter += 'a' - 'A'
+	}
+	if !slices.Contains(f.history, letter) {
+		f.history = append(f.history, letter)
+	}
+}
+
+// connection returns the flow as a conn.log record.
+func (f *flow) connection() models.Connection {
+	orig, resp := f.sides[0], f.sides[1]
+	conn := models.Connection{
+		Timestamp:   float64(f.start.UnixNano()) / float64(time.Second),
+		UID:         f.uid(),
+		OrigHost:    f.orig.Addr().String(),
+		OrigPort:    int(f.orig.Port()),
+		RespHost:    f.resp.Addr().String(),
+		RespPort:    int(f.resp.Port()),
+		Duration:    f.last.Sub(f.start).Seconds(),
+		ConnState:   f.state(),
+		History:     string(f.history),
+		OrigPackets: orig.packets,
+		OrigIPBytes: orig.ipBytes,
+		RespPackets: resp.packets,
+		RespIPBytes: resp.ipBytes,
+		IPProtocol:  f.proto,
+	}
+	switch f.proto {
+	case protoTCP:
+		conn.Protocol = "tcp"
+		conn.OrigBytes, conn.RespBytes = int(orig.end), int(resp.end)
+	case protoUDP:
+		conn.Protocol = "udp"
+		conn.OrigBytes, conn.RespBytes = orig.payload, resp.payload
+	default:
+		conn.Protocol = "icmp" // Zeek logs ICMPv6 as icmp too
+		conn.OrigBytes, conn.RespBytes = orig.payload, resp.payload
+	}
+
+	return conn
+}
+
+// uid returns a Zeek-style connection UID derived from the flow's endpoints and start, so
+// extracting the same capture again yields the same UIDs.
+func (f *flow) uid() string {
+	var buf []byte
+	buf = binary.BigEndian.AppendUint64(buf, uint64(f.start.UnixNano())) //nolint:gosec // Capture timestamps are positive
+	buf = append(buf, f.orig.String()...)
+	buf = append(buf, f.resp.String()...)
+	buf = append(buf, byte(f.proto))
+	sum := sha256.Sum256(buf)
+
+	return "C" + new(big.Int).SetBytes(sum[:uidBytes]).Text(uidBase)
+}
+
+// state returns the conn_state of the flow. UDP and ICMP flows are S0 without and SF with
+// a reply.
+func (f *flow) state() string {
+	orig, resp := f.sides[0], f.sides[1]
+	if f.proto != protoTCP {
+		if resp.packets == 0 {
+			return "S0"
+		}
+
+		return "SF"
+	}
+
+	switch {
+	case orig.syn && !resp.synAck:
+		switch {
+		case resp.rst:
+			return "REJ"
+		case orig.rst:
+			return "RSTOS0"
+		case orig.fin:
+			return "SH"
+		default:
+			return "S0"
+		}
+	case !orig.syn && resp.synAck:
+		switch {
+		case resp.rst:
+			return "RSTRH"
+		case resp.fin:
+			return "SHR"
+		default:
+			return "OTH"
+		}
+	case !orig.syn:
+		return "OTH" // Midstream traffic
+	case orig.rst:
+		return "RSTO"
+	case resp.rst:
+		return "RSTR"
+	case orig.fin && resp.fin:
+		return "SF"
+	case orig.fin:
+		return "S2"
+	case resp.fin:
+		return "S3"
+	default:
+		return "S1"
+	}
+}
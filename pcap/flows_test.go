@@ -0,0 +1,150 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"zeek-viz/models"
+)
+
+// captureStart is the time of the first packet of the testdata captures.
+var captureStart = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// readCapture reads a capture of testdata.
+func readCapture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+// capturedAt returns the timestamp of a conn.log record starting micros after captureStart.
+func capturedAt(micros int) float64 {
+	return float64(captureStart.Add(time.Duration(micros)*time.Microsecond).UnixNano()) / float64(time.Second)
+}
+
+func TestExtractFlows(t *testing.T) {
+	want := []models.Connection{
+		{ // Over VLAN 100, with the first data segment captured only up to 64 bytes
+			Timestamp: capturedAt(1250), OrigHost: "10.0.0.1", OrigPort: 49152, RespHost: "192.0.2.10", RespPort: 80,
+			Protocol: "tcp", Duration: 0.074565, OrigBytes: 100, RespBytes: 200, ConnState: "SF", History: "ShADdFf",
+			OrigPackets: 5, OrigIPBytes: 300, RespPackets: 3, RespIPBytes: 320, IPProtocol: protoTCP,
+		},
+		{ // The query carries a hop-by-hop options header
+			Timestamp: capturedAt(180126), OrigHost: "2001:db8::1", OrigPort: 5353, RespHost: "2001:db8::53", RespPort: 53,
+			Protocol: "udp", Duration: 0.008812, OrigBytes: 30, RespBytes: 60, ConnState: "SF", History: "Dd",
+			OrigPackets: 1, OrigIPBytes: 86, RespPackets: 1, RespIPBytes: 108, IPProtocol: protoUDP,
+		},
+		{ // An echo request and its reply
+			Timestamp: capturedAt(438938), OrigHost: "2001:db8::1", OrigPort: 128, RespHost: "2001:db8::53", RespPort: 0,
+			Protocol: "icmp", Duration: 0.009071, OrigBytes: 20, RespBytes: 20, ConnState: "SF",
+			OrigPackets: 1, OrigIPBytes: 64, RespPackets: 1, RespIPBytes: 64, IPProtocol: protoICMPv6,
+		},
+	}
+
+	var uids []string
+	for _, name := range []string{"flows.pcap", "flows.pcapng"} {
+		t.Run(name, func(t *testing.T) {
+			connections, summary, err := Extract(context.Background(), bytes.NewReader(readCapture(t, name)))
+			if err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			// An ARP request, a SYN cut within its IP header, and a later fragment
+			if want := (Summary{Packets: 15, Skipped: 3, Flows: 3}); summary != want {
+				t.Errorf("summary %+v, want %+v", summary, want)
+			}
+			if len(connections) != len(want) {
+				t.Fatalf("%d connections, want %d", len(connections), len(want))
+			}
+			for i := range connections {
+				got := connections[i]
+				if i < len(uids) && got.UID != uids[i] {
+					t.Errorf("connection %d: UID %s, want %s as from the other capture", i, got.UID, uids[i])
+				}
+				if len(uids) < len(want) {
+					uids = append(uids, got.UID)
+				}
+				got.UID = ""
+				if !reflect.DeepEqual(got, want[i]) {
+					t.Errorf("connection %d:\n got %+v\nwant %+v", i, got, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTruncatedCapture(t *testing.T) {
+	for _, name := range []string{"flows.pcap", "flows.pcapng"} {
+		t.Run(name, func(t *testing.T) {
+			capture := readCapture(t, name)
+			connections, summary, err := Extract(context.Background(), bytes.NewReader(capture[:len(capture)-20]))
+			if err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			if want := (Summary{Packets: 14, Skipped: 2, Flows: 3, Truncated: true}); summary != want {
+				t.Errorf("summary %+v, want %+v", summary, want)
+			}
+			if len(connections) != 3 {
+				t.Errorf("%d connections, want the 3 before the cut", len(connections))
+			}
+		})
+	}
+}
+
+func TestDecodeCutPackets(t *testing.T) {
+	reader, err := NewReader(bytes.NewReader(readCapture(t, "flows.pcap")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []Packet
+	for {
+		packet, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet.Data = bytes.Clone(packet.Data)
+		packets = append(packets, packet)
+	}
+	if len(packets) != 15 {
+		t.Fatalf("%d packets, want 15", len(packets))
+	}
+
+	// The payload follows the IP header's lengths, not the captured bytes
+	data := packets[4]
+	if len(data.Data) != 64 || data.Length != 158 {
+		t.Fatalf("data segment: %d of %d bytes captured, want 64 of 158", len(data.Data), data.Length)
+	}
+	seg, ok := decode(data.LinkType, data.Data)
+	if !ok || seg.payload != 100 || seg.ipBytes != 140 || seg.srcPort != 49152 || seg.dstPort != 80 {
+		t.Errorf("data segment decoded %t: %+v", ok, seg)
+	}
+
+	// A SYN cut within its IP header, and cut again within its Ethernet header
+	cut := packets[13]
+	for _, length := range []int{len(cut.Data), etherHeader - 1, 0} {
+		if seg, ok := decode(cut.LinkType, cut.Data[:length]); ok {
+			t.Errorf("SYN cut at %d bytes decoded as %+v", length, seg)
+		}
+	}
+	// The VLAN-tagged SYN, cut within its tag and within its TCP header
+	syn := packets[1]
+	for _, length := range []int{etherHeader + 2, etherHeader + vlanTag + ipv4Header + tcpHeader - 1} {
+		if seg, ok := decode(syn.LinkType, syn.Data[:length]); ok {
+			t.Errorf("VLAN-tagged SYN cut at %d bytes decoded as %+v", length, seg)
+		}
+	}
+}
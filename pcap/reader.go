@@ -0,0 +1,313 @@
+// Package pcap reads packet captures and extracts the flows in them as Zeek would log them in
+// conn.log. It reads the classic pcap format and pcapng without libpcap, and decodes Ethernet,
+// Linux cooked, raw IP, and loopback frames carrying TCP, UDP, and ICMP over IPv4 and IPv6.
+// Flows are approximations of Zeek's: no protocol analyzers run, so services and application
+// logs are missing, and fragments are not reassembled.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	magicMicros     = 0xa1b2c3d4 // Classic pcap with microsecond timestamps
+	magicNanos      = 0xa1b23c4d // Classic pcap with nanosecond timestamps
+	pcapHeaderSize  = 24         // Bytes of the classic pcap file header
+	pcapRecordSize  = 16         // Bytes of a classic pcap record header
+	pcapLinkOffset  = 20         // Offset of the link type in the file header
+	maxPacketSize   = 256 << 10  // Bytes a captured packet may take, well above any snaplen in use
+	maxBlockSize    = 16 << 20   // Bytes a pcapng block may take
+	ngSectionHeader = 0x0a0d0d0a // pcapng section header block
+	ngByteOrder     = 0x1a2b3c4d // pcapng byte-order magic, as written by the capturing host
+	ngInterface     = 1          // pcapng interface description block
+	ngPacket        = 2          // Obsolete pcapng packet block
+	ngSimplePacket  = 3          // pcapng simple packet block
+	ngEnhanced      = 6          // pcapng enhanced packet block
+	ngBlockOverhead = 12         // Bytes of a block's type and both length fields
+	ngTSResolution  = 9          // Option setting an interface's timestamp resolution
+	ngOptionEnd     = 0          // Option ending an option list
+	defaultTSUnits  = 1e6        // Timestamp units per second of interfaces without a resolution
+	decimalBase     = 10         // Base of if_tsresol values without the binary flag
+	binaryTSFlag    = 0x80       // Flag of if_tsresol values that are powers of two
+)
+
+var (
+	errNotCapture     = errors.New("not a pcap or pcapng capture")
+	errPacketTooLarge = errors.New("captured packet exceeds 256KiB")
+	errBlockTooLarge  = errors.New("pcapng block exceeds 16MiB")
+	errBadBlock       = errors.New("malformed pcapng block")
+	errNoInterface    = errors.New("pcapng packet names an undescribed interface")
+)
+
+// Packet is one captured frame.
+type Packet struct {
+	Time     time.Time
+	LinkType uint16
+	Data     []byte // Captured bytes, valid until the next call to Next
+	Length   int    // Length of the frame on the wire, which may exceed the captured bytes
+}
+
+// Reader reads the packets of a pcap or pcapng capture.
+type Reader struct {
+	reader *bufio.Reader
+	order  binary.ByteOrder
+	ng     bool
+	buffer []byte
+
+	// Classic pcap
+	linkType uint16
+	units    float64 // Fractional timestamp units per second
+
+	// pcapng, per interface of the current section
+	interfaces []ngInterfaceInfo
+}
+
+// ngInterfaceInfo is an interface of a pcapng section.
+type ngInterfaceInfo struct {
+	linkType uint16
+	snapLen  uint32
+	units    float64
+}
+
+// IsCapture reports whether content starts like a pcap or pcapng capture.
+func IsCapture(head []byte) bool {
+	if len(head) < 4 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(head) {
+	case magicMicros, magicNanos, ngSectionHeader:
+		return true
+	}
+	switch binary.LittleEndian.Uint32(head) {
+	case magicMicros, magicNanos:
+		return true
+	}
+
+	return false
+}
+
+// NewReader reads the header of a capture.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{reader: bufio.NewReader(r)}
+	head, err := reader.reader.Peek(4)
+	if err != nil || !IsCapture(head) {
+		return nil, errNotCapture
+	}
+	if binary.BigEndian.Uint32(head) == ngSectionHeader {
+		reader.ng = true
+
+		return reader, nil
+	}
+
+	header := make([]byte, pcapHeaderSize)
+	_, err = io.ReadFull(reader.reader, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	reader.order = binary.BigEndian
+	if magic := binary.LittleEndian.Uint32(header); magic == magicMicros || magic == magicNanos {
+		reader.order = binary.LittleEndian
+	}
+	reader.units = defaultTSUnits
+	if reader.order.Uint32(header) == magicNanos {
+		reader.units = 1e9
+	}
+	reader.linkType = uint16(reader.order.Uint32(header[pcapLinkOffset:])) //nolint:gosec // Link types use the low 16 bits
+
+	return reader, nil
+}
+
+// Next returns the next packet, or io.EOF at the end of the capture.
+func (r *Reader) Next() (Packet, error) {
+	if r.ng {
+		return r.nextBlock()
+	}
+
+	header := make([]byte, pcapRecordSize)
+	_, err := io.ReadFull(r.reader, header)
+	if errors.Is(err, io.EOF) {
+		return Packet{}, io.EOF
+	}
+	if err != nil {
+		return Packet{}, fmt.Errorf("failed to read packet header: %w", err) // Truncated capture
+	}
+	captured := r.order.Uint32(header[8:])
+	if captured > maxPacketSize {
+		return Packet{}, errPacketTooLarge
+	}
+	data, err := r.read(int(captured))
+	if err != nil {
+		return Packet{}, err
+	}
+
+	seconds, fraction := r.order.Uint32(header), r.order.Uint32(header[4:])
+
+	return Packet{
+		Time:     time.Unix(int64(seconds), int64(float64(fraction)*1e9/r.units)),
+		LinkType: r.linkType,
+		Data:     data,
+		Length:   int(r.order.Uint32(header[12:])),
+	}, nil
+}
+
+// read reads n bytes into the reader's buffer.
+func (r *Reader) read(n int) ([]byte, error) {
+	if cap(r.buffer) < n {
+		r.buffer = make([]byte, n)
+	}
+	data := r.buffer[:n]
+	_, err := io.ReadFull(r.reader, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packet: %w", err)
+	}
+
+	return data, nil
+}
+
+// nextBlock reads pcapng blocks until one holds a packet.
+func (r *Reader) nextBlock() (Packet, error) {
+	for {
+		head, err := r.reader.Peek(8)
+		if errors.Is(err, io.EOF) && len(head) == 0 {
+			return Packet{}, io.EOF
+		}
+		if err != nil {
+			return Packet{}, fmt.Errorf("failed to read pcapng block: %w", err)
+		}
+		if binary.BigEndian.Uint32(head) == ngSectionHeader {
+			err = r.readSectionOrder()
+			if err != nil {
+				return Packet{}, err
+			}
+		}
+		if r.order == nil {
+			return Packet{}, errBadBlock
+		}
+
+		blockType, length := r.order.Uint32(head), r.order.Uint32(head[4:])
+		switch {
+		case length > maxBlockSize:
+			return Packet{}, errBlockTooLarge
+		case length < ngBlockOverhead || length%4 != 0:
+			return Packet{}, errBadBlock
+		}
+		block, err := r.read(int(length))
+		if err != nil {
+			return Packet{}, err
+		}
+		body := block[8 : length-4]
+
+		switch blockType {
+		case ngSectionHeader:
+			r.interfaces = r.interfaces[:0]
+		case ngInterface:
+			err = r.addInterface(body)
+		case ngEnhanced, ngPacket:
+			return r.packet(blockType, body)
+		case ngSimplePacket:
+			return r.simplePacket(body)
+		}
+		if err != nil {
+			return Packet{}, err
+		}
+	}
+}
+
+// readSectionOrder takes the byte order of the section a section header block starts.
+func (r *Reader) readSectionOrder() error {
+	head, err := r.reader.Peek(ngBlockOverhead)
+	if err != nil {
+		return fmt.Errorf("failed to read pcapng section header: %w", err)
+	}
+	switch {
+	case binary.BigEndian.Uint32(head[8:]) == ngByteOrder:
+		r.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(head[8:]) == ngByteOrder:
+		r.order = binary.LittleEndian
+	default:
+		return errBadBlock
+	}
+
+	return nil
+}
+
+// addInterface records an interface description block.
+func (r *Reader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return errBadBlock
+	}
+	info := ngInterfaceInfo{linkType: r.order.Uint16(body), snapLen: r.order.Uint32(body[4:]), units: defaultTSUnits}
+	for options := body[8:]; len(options) >= 4; {
+		code, length := r.order.Uint16(options), int(r.order.Uint16(options[2:]))
+		if code == ngOptionEnd || len(options) < 4+length {
+			break
+		}
+		if code == ngTSResolution && length >= 1 {
+			resolution := options[4]
+			if resolution&binaryTSFlag != 0 {
+				info.units = math.Pow(2, float64(resolution&^binaryTSFlag))
+			} else {
+				info.units = math.Pow(decimalBase, float64(resolution))
+			}
+		}
+		options = options[4+(length+3)&^3:]
+	}
+	r.interfaces = append(r.interfaces, info)
+
+	return nil
+}
+
+// packet decodes an enhanced or obsolete packet block.
+func (r *Reader) packet(blockType uint32, body []byte) (Packet, error) {
+	const header = 20 // Interface, timestamp, and lengths
+
+	if len(body) < header {
+		return Packet{}, errBadBlock
+	}
+	var id int
+	if blockType == ngPacket {
+		id = int(r.order.Uint16(body))
+	} else {
+		id = int(r.order.Uint32(body))
+	}
+	if id >= len(r.interfaces) {
+		return Packet{}, errNoInterface
+	}
+	info := r.interfaces[id]
+
+	captured := int(r.order.Uint32(body[12:]))
+	if captured > len(body)-header {
+		return Packet{}, errBadBlock
+	}
+	units := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+	seconds := units / uint64(info.units)
+	fraction := float64(units-seconds*uint64(info.units)) / info.units
+
+	return Packet{
+		Time:     time.Unix(int64(seconds), int64(fraction*1e9)), //nolint:gosec // Capture timestamps fit
+		LinkType: info.linkType,
+		Data:     body[header : header+captured],
+		Length:   int(r.order.Uint32(body[16:])),
+	}, nil
+}
+
+// simplePacket decodes a simple packet block, which has no timestamp.
+func (r *Reader) simplePacket(body []byte) (Packet, error) {
+	if len(body) < 4 || len(r.interfaces) == 0 {
+		return Packet{}, errNoInterface
+	}
+	info := r.interfaces[0]
+	length := int(r.order.Uint32(body))
+	captured := min(length, len(body)-4)
+	if info.snapLen > 0 {
+		captured = min(captured, int(info.snapLen))
+	}
+
+	return Packet{LinkType: info.linkType, Data: body[4 : 4+captured], Length: length}, nil
+}
@@ -0,0 +1,676 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+var (
+	errUnexpectedEnd   = errors.New("unexpected end of expression")
+	errUnexpectedToken = errors.New("unexpected token")
+	errExpectedValue   = errors.New("expected a value")
+	errUnknownField    = errors.New("unknown field")
+)
+
+// Expr is a parsed boolean expression over record fields.
+type Expr interface {
+	// String returns a canonical representation of the expression.
+	String() string
+}
+
+// AndExpr matches when both sides match.
+type AndExpr struct {
+	Left, Right Expr
+}
+
+// OrExpr matches when either side matches.
+type OrExpr struct {
+	Left, Right Expr
+}
+
+// NotExpr inverts the match of its operand.
+type NotExpr struct {
+	Operand Expr
+}
+
+// Comparison compares a field against a literal value, or with the in operator against a
+// list of values.
+type Comparison struct {
+	Field    string
+	Operator string
+	Value    string
+	Number   float64
+	IsNumber bool
+	Values   []string // Listed values of the in operator
+}
+
+// TextExpr matches records with a field containing the text, ignoring case.
+type TextExpr struct {
+	Text string
+}
+
+// String returns a canonical representation of the expression.
+func (e *AndExpr) String() string { return "(" + e.Left.String() + " and " + e.Right.String() + ")" }
+
+// String returns a canonical representation of the expression.
+func (e *OrExpr) String() string { return "(" + e.Left.String() + " or " + e.Right.String() + ")" }
+
+// String returns a canonical representation of the expression.
+func (e *NotExpr) String() string { return "not " + e.Operand.String() }
+
+// String returns a canonical representation of the expression.
+func (e *Comparison) String() string {
+	if e.Operator != "in" {
+		return e.Field + e.Operator + strconv.Quote(e.Value)
+	}
+	quoted := make([]string, len(e.Values))
+	for i, value := range e.Values {
+		quoted[i] = strconv.Quote(value)
+	}
+
+	return e.Field + " in (" + strings.Join(quoted, ", ") + ")"
+}
+
+// String returns a canonical representation of the expression.
+func (e *TextExpr) String() string { return strconv.Quote(e.Text) }
+
+// ParseExpr parses a boolean expression such as `proto=="tcp" and orig_bytes>1e6` or
+// `resp_h in 10.0.0.0/8`. A bare value such as `10.0.0.5` or "ssl" is a text search.
+func ParseExpr(input string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		return nil, p.unexpected()
+	}
+
+	return expr, nil
+}
+
+// parser is a recursive-descent parser over a token slice.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// done reports whether all tokens have been consumed.
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() (token, bool) {
+	if p.done() {
+		return token{}, false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() (token, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return token{}, errUnexpectedEnd
+	}
+	p.pos++
+
+	return tok, nil
+}
+
+// accept consumes the next token if it matches one of the given texts (case-insensitive).
+func (p *parser) accept(texts ...string) bool {
+	tok, ok := p.peek()
+	if !ok || tok.kind == tokenString {
+		return false
+	}
+
+	for _, text := range texts {
+		if strings.EqualFold(tok.text, text) {
+			p.pos++
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// expect consumes the next token, failing unless it matches text.
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		if p.done() {
+			return fmt.Errorf("%w: expected %q", errUnexpectedEnd, text)
+		}
+
+		return fmt.Errorf("%w: expected %q", p.unexpected(), text)
+	}
+
+	return nil
+}
+
+// unexpected returns an error describing the token at the current position.
+func (p *parser) unexpected() error {
+	tok, ok := p.peek()
+	if !ok {
+		return errUnexpectedEnd
+	}
+
+	return fmt.Errorf("%w %q at position %d", errUnexpectedToken, tok.text, tok.start)
+}
+
+// parseExpr parses a full expression (lowest precedence: or).
+func (p *parser) parseExpr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &OrExpr{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses a conjunction of unary expressions.
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("and", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &AndExpr{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseUnary parses negations, parenthesized expressions, and comparisons.
+func (p *parser) parseUnary() (Expr, error) {
+	if p.accept("not", "!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &NotExpr{Operand: operand}, nil
+	}
+
+	if p.accept("(") {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expect(")")
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses `field op value`, `field [not] in values`, or a text search term.
+func (p *parser) parseComparison() (Expr, error) {
+	field, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if field.kind == tokenOp {
+		p.pos--
+
+		return nil, p.unexpected()
+	}
+	if field.kind != tokenWord || !p.operatorFollows() {
+		return &TextExpr{Text: field.text}, nil
+	}
+
+	negated := p.accept("not")
+	if negated || p.accept("in") {
+		if negated {
+			err = p.expect("in")
+			if err != nil {
+				return nil, err
+			}
+		}
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		comparison := &Comparison{Field: field.text, Operator: "in", Values: values}
+		if negated {
+			return &NotExpr{Operand: comparison}, nil
+		}
+
+		return comparison, nil
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.kind != tokenOp || !isComparisonOperator(op.text) {
+		p.pos--
+
+		return nil, p.unexpected()
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &Comparison{Field: field.text, Operator: op.text, Value: value.text}
+	if comparison.Operator == "=" {
+		comparison.Operator = "=="
+	}
+	if value.kind == tokenNumber {
+		comparison.Number = value.num
+		comparison.IsNumber = true
+	}
+
+	return comparison, nil
+}
+
+// operatorFollows reports whether the next tokens are a comparison operator, in, or not in,
+// so the word before them is a field.
+func (p *parser) operatorFollows() bool {
+	tok, ok := p.peek()
+	switch {
+	case !ok:
+		return false
+	case tok.kind == tokenOp:
+		return isComparisonOperator(tok.text)
+	case tok.kind != tokenWord:
+		return false
+	case strings.EqualFold(tok.text, "in"):
+		return true
+	default:
+		next := p.pos + 1
+
+		return strings.EqualFold(tok.text, "not") && next < len(p.tokens) &&
+			p.tokens[next].kind == tokenWord && strings.EqualFold(p.tokens[next].text, "in")
+	}
+}
+
+// parseValueList parses the values of the in operator: one value, or a parenthesized,
+// comma-separated list.
+func (p *parser) parseValueList() ([]string, error) {
+	if !p.accept("(") {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{value.text}, nil
+	}
+
+	var values []string
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value.text)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	return values, p.expect(")")
+}
+
+// parseValue parses a literal value token.
+func (p *parser) parseValue() (token, error) {
+	value, err := p.next()
+	if err != nil {
+		return token{}, fmt.Errorf("%w: %w", errExpectedValue, err)
+	}
+
+	if value.kind == tokenOp {
+		p.pos--
+
+		return token{}, fmt.Errorf("%w: %w", errExpectedValue, p.unexpected())
+	}
+
+	return value, nil
+}
+
+// isComparisonOperator reports whether op is a supported comparison operator.
+func isComparisonOperator(op string) bool {
+	switch op {
+	case "==", "=", "!=", ">", ">=", "<", "<=":
+		return true
+	default:
+		return false
+	}
+}
+
+// ConnectionPredicate reports whether a connection matches an expression.
+type ConnectionPredicate func(conn *models.Connection) bool
+
+// CompileConnectionFilter compiles an expression into a predicate over connections,
+// resolving field accessors once up front.
+func CompileConnectionFilter(expr Expr) (ConnectionPredicate, error) {
+	switch e := expr.(type) {
+	case *AndExpr:
+		left, right, err := compileConnectionPair(e.Left, e.Right)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(c *models.Connection) bool { return left(c) && right(c) }, nil
+	case *OrExpr:
+		left, right, err := compileConnectionPair(e.Left, e.Right)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(c *models.Connection) bool { return left(c) || right(c) }, nil
+	case *NotExpr:
+		operand, err := CompileConnectionFilter(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(c *models.Connection) bool { return !operand(c) }, nil
+	case *Comparison:
+		if e.Operator == "in" {
+			return compileConnectionMembership(e)
+		}
+
+		return compileConnectionComparison(e)
+	case *TextExpr:
+		return compileConnectionText(e), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnexpectedToken, expr.String())
+	}
+}
+
+// compileConnectionPair compiles both operands of a binary expression.
+func compileConnectionPair(leftExpr, rightExpr Expr) (ConnectionPredicate, ConnectionPredicate, error) {
+	left, err := CompileConnectionFilter(leftExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	right, err := CompileConnectionFilter(rightExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return left, right, nil
+}
+
+// compileConnectionComparison compiles a single comparison against a connection field.
+// Numeric fields compared with numeric literals use numeric ordering; everything else compares as strings.
+func compileConnectionComparison(c *Comparison) (ConnectionPredicate, error) {
+	if numeric, ok := models.NumericFieldAccessor(c.Field); ok && c.IsNumber {
+		return func(conn *models.Connection) bool {
+			return compareNumbers(numeric(conn), c.Operator, c.Number)
+		}, nil
+	}
+
+	accessor, ok := models.StringFieldAccessor(c.Field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownField, c.Field)
+	}
+
+	return func(conn *models.Connection) bool {
+		return compareStrings(accessor(conn), c.Operator, c.Value)
+	}, nil
+}
+
+// compileConnectionMembership compiles an in comparison. Numeric fields match listed numbers
+// and ranges such as 8000-8100, other fields listed values and, for addresses, CIDR prefixes.
+func compileConnectionMembership(c *Comparison) (ConnectionPredicate, error) {
+	values := newMembership(c.Values)
+	if numeric, ok := models.NumericFieldAccessor(c.Field); ok {
+		return func(conn *models.Connection) bool {
+			return values.containsNumber(numeric(conn))
+		}, nil
+	}
+
+	accessor, ok := models.StringFieldAccessor(c.Field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownField, c.Field)
+	}
+
+	return func(conn *models.Connection) bool {
+		return values.containsString(accessor(conn))
+	}, nil
+}
+
+// textFields returns the connection fields a text search looks in.
+func textFields() []string {
+	return []string{
+		"uid", "id.orig_h", "id.resp_h", "proto", "service", "service_guess", "conn_state",
+		"history", "source_file",
+	}
+}
+
+// compileConnectionText compiles a text search over the connection's string fields.
+func compileConnectionText(e *TextExpr) ConnectionPredicate {
+	text := strings.ToLower(e.Text)
+	var accessors []models.StringAccessor
+	for _, field := range textFields() {
+		accessor, _ := models.StringFieldAccessor(field)
+		accessors = append(accessors, accessor)
+	}
+
+	return func(conn *models.Connection) bool {
+		for _, accessor := range accessors {
+			if strings.Contains(strings.ToLower(accessor(conn)), text) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// membership holds the values of an in comparison by how they match.
+type membership struct {
+	values   []string
+	numbers  []float64
+	ranges   [][2]float64   // Inclusive bounds
+	prefixes []netip.Prefix // Addresses match when a prefix contains them
+}
+
+// newMembership sorts the listed values into numbers, ranges, and prefixes. Every value also
+// matches as a string.
+func newMembership(values []string) *membership {
+	m := &membership{values: values}
+	for _, value := range values {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			m.numbers = append(m.numbers, number)
+
+			continue
+		}
+		if low, high, isRange := strings.Cut(value, "-"); isRange {
+			lowNumber, lowErr := strconv.ParseFloat(low, 64)
+			highNumber, highErr := strconv.ParseFloat(high, 64)
+			if lowErr == nil && highErr == nil {
+				m.ranges = append(m.ranges, [2]float64{lowNumber, highNumber})
+
+				continue
+			}
+		}
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			m.prefixes = append(m.prefixes, prefix.Masked())
+		}
+	}
+
+	return m
+}
+
+// containsNumber reports whether the number is listed or in a listed range.
+func (m *membership) containsNumber(number float64) bool {
+	if slices.Contains(m.numbers, number) {
+		return true
+	}
+	for _, bounds := range m.ranges {
+		if number >= bounds[0] && number <= bounds[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsString reports whether the value is listed or an address in a listed prefix.
+func (m *membership) containsString(value string) bool {
+	if slices.Contains(m.values, value) {
+		return true
+	}
+	if len(m.prefixes) == 0 {
+		return false
+	}
+	addr, err := models.ParseHost(value)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range m.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RecordPredicate reports whether a record matches an expression.
+type RecordPredicate func(record Record) bool
+
+// CompileRecordFilter compiles an expression into a predicate over generic records.
+func CompileRecordFilter(expr Expr) RecordPredicate {
+	switch e := expr.(type) {
+	case *AndExpr:
+		left, right := CompileRecordFilter(e.Left), CompileRecordFilter(e.Right)
+
+		return func(r Record) bool { return left(r) && right(r) }
+	case *OrExpr:
+		left, right := CompileRecordFilter(e.Left), CompileRecordFilter(e.Right)
+
+		return func(r Record) bool { return left(r) || right(r) }
+	case *NotExpr:
+		operand := CompileRecordFilter(e.Operand)
+
+		return func(r Record) bool { return !operand(r) }
+	case *Comparison:
+		if e.Operator == "in" {
+			values := newMembership(e.Values)
+
+			return func(r Record) bool { return recordFieldIn(r, e.Field, values) }
+		}
+
+		return func(r Record) bool { return compareRecordField(r, e) }
+	case *TextExpr:
+		text := strings.ToLower(e.Text)
+
+		return func(r Record) bool { return recordContainsText(r, text) }
+	default:
+		return func(Record) bool { return false }
+	}
+}
+
+// compareRecordField evaluates a comparison against a record field.
+func compareRecordField(record Record, c *Comparison) bool {
+	value, exists := record[c.Field]
+	if !exists {
+		return false
+	}
+
+	if number, ok := value.(float64); ok && c.IsNumber {
+		return compareNumbers(number, c.Operator, c.Number)
+	}
+
+	return compareStrings(fmt.Sprint(value), c.Operator, c.Value)
+}
+
+// recordFieldIn evaluates an in comparison against a record field.
+func recordFieldIn(record Record, field string, values *membership) bool {
+	value, exists := record[field]
+	if !exists {
+		return false
+	}
+	if number, ok := value.(float64); ok {
+		return values.containsNumber(number)
+	}
+
+	return values.containsString(fmt.Sprint(value))
+}
+
+// recordContainsText reports whether a field of the record contains the lower-case text.
+func recordContainsText(record Record, text string) bool {
+	for _, value := range record {
+		if strings.Contains(strings.ToLower(fmt.Sprint(value)), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareNumbers applies a comparison operator to two numbers.
+func compareNumbers(left float64, op string, right float64) bool {
+	switch op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	default:
+		return false
+	}
+}
+
+// compareStrings applies a comparison operator to two strings.
+func compareStrings(left, op, right string) bool {
+	switch op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	default:
+		return false
+	}
+}
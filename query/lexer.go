@@ -0,0 +1,140 @@
+// Package query implements the expression and pipeline languages used to query connections.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind identifies the type of a lexical token.
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // Identifier, keyword, or bare value such as an IP address
+	tokenNumber                  // Numeric literal
+	tokenString                  // Quoted string literal
+	tokenOp                      // Operator or punctuation
+)
+
+var (
+	errUnterminatedString = errors.New("unterminated string")
+	errUnexpectedChar     = errors.New("unexpected character")
+)
+
+// token is a single lexical token.
+type token struct {
+	kind  tokenKind
+	text  string
+	num   float64
+	start int
+}
+
+// tokenize splits the input into tokens.
+func tokenize(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	pos := 0
+
+	for pos < len(input) {
+		ch := rune(input[pos])
+
+		switch {
+		case unicode.IsSpace(ch):
+			pos++
+		case ch == '"' || ch == '\'':
+			tok, end, err := lexString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			pos = end
+		case isWordStart(input, pos):
+			end := pos + 1
+			for end < len(input) && isWordChar(rune(input[end])) {
+				end++
+			}
+			tokens = append(tokens, classifyWord(input[pos:end], pos))
+			pos = end
+		default:
+			op := lexOperator(input[pos:])
+			if op == "" {
+				return nil, fmt.Errorf("%w %q at position %d", errUnexpectedChar, ch, pos)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, start: pos})
+			pos += len(op)
+		}
+	}
+
+	return tokens, nil
+}
+
+// lexString reads a quoted string starting at pos.
+func lexString(input string, pos int) (token, int, error) {
+	quote := input[pos]
+
+	end := pos + 1
+	for end < len(input) && input[end] != quote {
+		if input[end] == '\\' {
+			end++
+		}
+		end++
+	}
+
+	if end >= len(input) {
+		return token{}, 0, fmt.Errorf("%w at position %d", errUnterminatedString, pos)
+	}
+
+	raw := input[pos+1 : end]
+	text := strings.ReplaceAll(raw, `\`+string(quote), string(quote))
+	if quote == '"' {
+		unquoted, err := strconv.Unquote(input[pos : end+1])
+		if err == nil {
+			text = unquoted
+		}
+	}
+
+	return token{kind: tokenString, text: text, start: pos}, end + 1, nil
+}
+
+// lexOperator returns the operator at the start of input, preferring two-character operators.
+func lexOperator(input string) string {
+	for _, op := range []string{"==", "!=", ">=", "<=", "&&", "||"} {
+		if strings.HasPrefix(input, op) {
+			return op
+		}
+	}
+
+	if strings.ContainsAny(input[:1], "<>=!()|,") {
+		return input[:1]
+	}
+
+	return ""
+}
+
+// isWordStart reports whether a word token begins at pos. A leading '-' is
+// allowed for negative numbers and flags such as "-r".
+func isWordStart(input string, pos int) bool {
+	ch := rune(input[pos])
+	if ch == '-' {
+		return pos+1 < len(input) && isWordChar(rune(input[pos+1]))
+	}
+
+	return isWordChar(ch)
+}
+
+// isWordChar reports whether ch can appear inside a bare word.
+func isWordChar(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || strings.ContainsRune("._:/-*", ch)
+}
+
+// classifyWord turns a bare word into a number or word token.
+func classifyWord(text string, start int) token {
+	num, err := strconv.ParseFloat(text, 64)
+	if err == nil {
+		return token{kind: tokenNumber, text: text, num: num, start: start}
+	}
+
+	return token{kind: tokenWord, text: text, start: start}
+}
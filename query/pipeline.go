@@ -0,0 +1,712 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"zeek-viz/models"
+)
+
+var (
+	errEmptyPipeline   = errors.New("empty pipeline")
+	errUnknownStage    = errors.New("unknown pipeline stage")
+	errInvalidCount    = errors.New("expected a positive count")
+	errUnknownFunction = errors.New("unknown aggregate function")
+)
+
+// Record is a generic output row produced by summarize and cut stages.
+type Record map[string]any
+
+// Result is the output of a pipeline. Until a summarize or cut stage runs the
+// result still holds connections; afterwards it holds records.
+type Result struct {
+	Connections []models.Connection
+	Records     []Record
+	IsRecords   bool
+}
+
+// Len returns the number of rows in the result.
+func (r *Result) Len() int {
+	if r.IsRecords {
+		return len(r.Records)
+	}
+
+	return len(r.Connections)
+}
+
+// Truncate keeps at most n rows.
+func (r *Result) Truncate(n int) {
+	if r.IsRecords && len(r.Records) > n {
+		r.Records = r.Records[:n]
+	}
+	if !r.IsRecords && len(r.Connections) > n {
+		r.Connections = r.Connections[:n]
+	}
+}
+
+// stage is one step of a pipeline.
+type stage interface {
+	apply(result *Result) error
+}
+
+// Pipeline is a parsed sequence of stages separated by "|".
+type Pipeline struct {
+	stages []stage
+}
+
+// ParsePipeline parses a pipeline such as
+// `filter proto=="tcp" | summarize sum(orig_bytes) by resp_h | sort -r sum_orig_bytes | head 20`.
+func ParsePipeline(input string) (*Pipeline, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := &Pipeline{}
+	for _, stageTokens := range splitStages(tokens) {
+		if len(stageTokens) == 0 {
+			return nil, errEmptyPipeline
+		}
+
+		parsed, err := parseStage(stageTokens)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.stages = append(pipeline.stages, parsed)
+	}
+
+	if len(pipeline.stages) == 0 {
+		return nil, errEmptyPipeline
+	}
+
+	return pipeline, nil
+}
+
+// Execute runs the pipeline over the connections.
+func (p *Pipeline) Execute(connections []models.Connection) (*Result, error) {
+	result := &Result{Connections: connections}
+
+	for _, s := range p.stages {
+		err := s.apply(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// splitStages splits tokens at top-level "|" operators.
+func splitStages(tokens []token) [][]token {
+	stages := make([][]token, 0)
+	current := make([]token, 0)
+
+	for _, tok := range tokens {
+		if tok.kind == tokenOp && tok.text == "|" {
+			stages = append(stages, current)
+			current = make([]token, 0)
+
+			continue
+		}
+		current = append(current, tok)
+	}
+
+	return append(stages, current)
+}
+
+// parseStage parses the tokens of a single stage.
+func parseStage(tokens []token) (stage, error) {
+	p := &parser{tokens: tokens[1:]}
+
+	var parsed stage
+	var err error
+
+	switch strings.ToLower(tokens[0].text) {
+	case "filter", "where", "search":
+		parsed, err = parseFilterStage(p)
+	case "head", "take", "limit":
+		parsed, err = parseHeadStage(p, false)
+	case "tail":
+		parsed, err = parseHeadStage(p, true)
+	case "sort":
+		parsed, err = parseSortStage(p)
+	case "cut", "project":
+		parsed, err = parseCutStage(p)
+	case "summarize":
+		parsed, err = parseSummarizeStage(p)
+	case "count":
+		parsed = &summarizeStage{aggregations: []aggregation{{name: "count", function: "count"}}}
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownStage, tokens[0].text)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.unexpected()
+	}
+
+	return parsed, nil
+}
+
+// parseWordList parses a comma-separated list of words.
+func parseWordList(p *parser) ([]string, error) {
+	words := make([]string, 0)
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind != tokenWord {
+			p.pos--
+
+			return nil, p.unexpected()
+		}
+		words = append(words, tok.text)
+
+		if !p.accept(",") {
+			return words, nil
+		}
+	}
+}
+
+// filterStage keeps rows matching an expression.
+type filterStage struct {
+	expr Expr
+}
+
+// parseFilterStage parses `filter <expr>`.
+func parseFilterStage(p *parser) (stage, error) {
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &filterStage{expr: expr}, nil
+}
+
+func (s *filterStage) apply(result *Result) error {
+	if result.IsRecords {
+		predicate := CompileRecordFilter(s.expr)
+		filtered := make([]Record, 0)
+		for _, record := range result.Records {
+			if predicate(record) {
+				filtered = append(filtered, record)
+			}
+		}
+		result.Records = filtered
+
+		return nil
+	}
+
+	predicate, err := CompileConnectionFilter(s.expr)
+	if err != nil {
+		return err
+	}
+
+	filtered := make([]models.Connection, 0)
+	for i := range result.Connections {
+		if predicate(&result.Connections[i]) {
+			filtered = append(filtered, result.Connections[i])
+		}
+	}
+	result.Connections = filtered
+
+	return nil
+}
+
+// headStage keeps the first (or last) n rows.
+type headStage struct {
+	count int
+	tail  bool
+}
+
+// parseHeadStage parses `head [n]` or `tail [n]`; n defaults to 1 and may not exceed
+// math.MaxInt32.
+func parseHeadStage(p *parser, tail bool) (stage, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return &headStage{count: 1, tail: tail}, nil
+	}
+
+	p.pos++
+	if tok.kind != tokenNumber || tok.num < 1 || tok.num > math.MaxInt32 || tok.num != math.Trunc(tok.num) {
+		return nil, fmt.Errorf("%w: %s", errInvalidCount, tok.text)
+	}
+
+	return &headStage{count: int(tok.num), tail: tail}, nil
+}
+
+func (s *headStage) apply(result *Result) error {
+	length := result.Len()
+	if length <= s.count {
+		return nil
+	}
+
+	start, end := 0, s.count
+	if s.tail {
+		start, end = length-s.count, length
+	}
+
+	if result.IsRecords {
+		result.Records = result.Records[start:end]
+	} else {
+		result.Connections = result.Connections[start:end]
+	}
+
+	return nil
+}
+
+// sortStage orders rows by a field.
+type sortStage struct {
+	field      string
+	descending bool
+}
+
+// parseSortStage parses `sort [-r] field [asc|desc]`.
+func parseSortStage(p *parser) (stage, error) {
+	s := &sortStage{}
+	if p.accept("-r") {
+		s.descending = true
+	}
+
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind != tokenWord {
+		p.pos--
+
+		return nil, p.unexpected()
+	}
+	s.field = tok.text
+
+	if p.accept("desc") {
+		s.descending = true
+	} else {
+		p.accept("asc")
+	}
+
+	return s, nil
+}
+
+func (s *sortStage) apply(result *Result) error {
+	less, err := rowLess(result, s.field)
+	if err != nil {
+		return err
+	}
+
+	ordered := func(i, j int) bool {
+		if s.descending {
+			return less(j, i)
+		}
+
+		return less(i, j)
+	}
+
+	if result.IsRecords {
+		sort.SliceStable(result.Records, ordered)
+	} else {
+		sorted := make([]models.Connection, len(result.Connections))
+		copy(sorted, result.Connections)
+		result.Connections = sorted
+		sort.SliceStable(result.Connections, ordered)
+	}
+
+	return nil
+}
+
+// rowLess returns a comparison of two row indexes by field.
+func rowLess(result *Result, field string) (func(i, j int) bool, error) {
+	if result.IsRecords {
+		return func(i, j int) bool {
+			return lessValues(result.Records[i][field], result.Records[j][field])
+		}, nil
+	}
+
+	if numeric, ok := models.NumericFieldAccessor(field); ok {
+		return func(i, j int) bool {
+			return numeric(&result.Connections[i]) < numeric(&result.Connections[j])
+		}, nil
+	}
+
+	accessor, ok := models.StringFieldAccessor(field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownField, field)
+	}
+
+	return func(i, j int) bool {
+		return accessor(&result.Connections[i]) < accessor(&result.Connections[j])
+	}, nil
+}
+
+// lessValues orders record values, placing numbers before strings and missing values last.
+func lessValues(left, right any) bool {
+	leftNum, leftIsNum := left.(float64)
+	rightNum, rightIsNum := right.(float64)
+
+	switch {
+	case leftIsNum && rightIsNum:
+		return leftNum < rightNum
+	case left == nil || right == nil:
+		return right == nil && left != nil
+	case leftIsNum != rightIsNum:
+		return leftIsNum
+	default:
+		return fmt.Sprint(left) < fmt.Sprint(right)
+	}
+}
+
+// cutStage projects rows onto a list of fields.
+type cutStage struct {
+	fields []string
+}
+
+// parseCutStage parses `cut field[, field...]`.
+func parseCutStage(p *parser) (stage, error) {
+	fields, err := parseWordList(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cutStage{fields: fields}, nil
+}
+
+func (s *cutStage) apply(result *Result) error {
+	if result.IsRecords {
+		for i, record := range result.Records {
+			projected := make(Record, len(s.fields))
+			for _, field := range s.fields {
+				if value, exists := record[field]; exists {
+					projected[field] = value
+				}
+			}
+			result.Records[i] = projected
+		}
+
+		return nil
+	}
+
+	columns := make([]func(*models.Connection) any, len(s.fields))
+	for i, field := range s.fields {
+		column, err := connectionColumn(field)
+		if err != nil {
+			return err
+		}
+		columns[i] = column
+	}
+
+	records := make([]Record, len(result.Connections))
+	for i := range result.Connections {
+		record := make(Record, len(s.fields))
+		for j, field := range s.fields {
+			record[field] = columns[j](&result.Connections[i])
+		}
+		records[i] = record
+	}
+
+	result.Records = records
+	result.Connections = nil
+	result.IsRecords = true
+
+	return nil
+}
+
+// connectionColumn returns an accessor producing a float64 for numeric fields and a string otherwise.
+func connectionColumn(field string) (func(*models.Connection) any, error) {
+	if numeric, ok := models.NumericFieldAccessor(field); ok {
+		return func(c *models.Connection) any { return numeric(c) }, nil
+	}
+
+	accessor, ok := models.StringFieldAccessor(field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownField, field)
+	}
+
+	return func(c *models.Connection) any { return accessor(c) }, nil
+}
+
+// aggregation is a single aggregate function in a summarize stage.
+type aggregation struct {
+	name     string
+	function string
+	field    string
+}
+
+// summarizeStage groups rows and computes aggregates.
+type summarizeStage struct {
+	aggregations []aggregation
+	by           []string
+}
+
+// parseSummarizeStage parses `summarize [name=]func(field)[, ...] [by field[, ...]]`.
+func parseSummarizeStage(p *parser) (stage, error) {
+	s := &summarizeStage{}
+
+	for {
+		agg, err := parseAggregation(p)
+		if err != nil {
+			return nil, err
+		}
+		s.aggregations = append(s.aggregations, agg)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if p.accept("by") {
+		by, err := parseWordList(p)
+		if err != nil {
+			return nil, err
+		}
+		s.by = by
+	}
+
+	return s, nil
+}
+
+// parseAggregation parses one `[name=]func([field])` term.
+func parseAggregation(p *parser) (aggregation, error) {
+	tok, err := p.next()
+	if err != nil {
+		return aggregation{}, err
+	}
+
+	agg := aggregation{}
+	if p.accept("=") {
+		agg.name = tok.text
+		tok, err = p.next()
+		if err != nil {
+			return aggregation{}, err
+		}
+	}
+
+	agg.function = strings.ToLower(tok.text)
+	if !isAggregateFunction(agg.function) {
+		return aggregation{}, fmt.Errorf("%w: %s", errUnknownFunction, tok.text)
+	}
+
+	err = p.expect("(")
+	if err != nil {
+		return aggregation{}, err
+	}
+
+	if !p.accept(")") {
+		field, err := p.next()
+		if err != nil {
+			return aggregation{}, err
+		}
+		agg.field = field.text
+
+		err = p.expect(")")
+		if err != nil {
+			return aggregation{}, err
+		}
+	}
+
+	if agg.name == "" {
+		agg.name = agg.function
+		if agg.field != "" && agg.field != "*" {
+			agg.name += "_" + agg.field
+		}
+	}
+
+	return agg, nil
+}
+
+// isAggregateFunction reports whether name is a supported aggregate function.
+func isAggregateFunction(name string) bool {
+	switch name {
+	case "count", "sum", "avg", "min", "max", "dcount":
+		return true
+	default:
+		return false
+	}
+}
+
+// aggregateState accumulates one aggregate for one group.
+type aggregateState struct {
+	count    int
+	sum      float64
+	min, max float64
+	distinct map[string]struct{}
+}
+
+func (s *summarizeStage) apply(result *Result) error {
+	keyColumns := make([]func(i int) string, len(s.by))
+	for i, field := range s.by {
+		column, err := stringColumn(result, field)
+		if err != nil {
+			return err
+		}
+		keyColumns[i] = column
+	}
+
+	valueColumns := make([]func(i int) (float64, string, bool), len(s.aggregations))
+	for i, agg := range s.aggregations {
+		column, err := valueColumn(result, agg.field)
+		if err != nil {
+			return err
+		}
+		valueColumns[i] = column
+	}
+
+	groupKeys := make(map[string][]string)
+	groupStates := make(map[string][]*aggregateState)
+	order := make([]string, 0)
+	keyParts := make([]string, len(s.by))
+
+	for row := range result.Len() {
+		for i, column := range keyColumns {
+			keyParts[i] = column(row)
+		}
+		key := strings.Join(keyParts, "\x00")
+
+		states, exists := groupStates[key]
+		if !exists {
+			states = make([]*aggregateState, len(s.aggregations))
+			for i := range states {
+				states[i] = &aggregateState{min: math.Inf(1), max: math.Inf(-1), distinct: make(map[string]struct{})}
+			}
+			groupStates[key] = states
+			groupKeys[key] = append([]string(nil), keyParts...)
+			order = append(order, key)
+		}
+
+		for i, agg := range s.aggregations {
+			number, text, ok := valueColumns[i](row)
+			states[i].add(agg.function, number, text, ok)
+		}
+	}
+
+	records := make([]Record, 0, len(order))
+	for _, key := range order {
+		record := make(Record, len(s.by)+len(s.aggregations))
+		for i, field := range s.by {
+			record[field] = groupKeys[key][i]
+		}
+		for i, agg := range s.aggregations {
+			record[agg.name] = groupStates[key][i].value(agg.function)
+		}
+		records = append(records, record)
+	}
+
+	result.Records = records
+	result.Connections = nil
+	result.IsRecords = true
+
+	return nil
+}
+
+// add folds one value into the aggregate state.
+func (s *aggregateState) add(function string, number float64, text string, ok bool) {
+	if function == "count" {
+		s.count++
+
+		return
+	}
+	if !ok {
+		return
+	}
+
+	s.count++
+	s.sum += number
+	s.min = math.Min(s.min, number)
+	s.max = math.Max(s.max, number)
+	if function == "dcount" {
+		s.distinct[text] = struct{}{}
+	}
+}
+
+// value returns the final value of the aggregate.
+func (s *aggregateState) value(function string) float64 {
+	switch function {
+	case "sum":
+		return s.sum
+	case "avg":
+		if s.count == 0 {
+			return 0
+		}
+
+		return s.sum / float64(s.count)
+	case "min":
+		if s.count == 0 {
+			return 0
+		}
+
+		return s.min
+	case "max":
+		if s.count == 0 {
+			return 0
+		}
+
+		return s.max
+	case "dcount":
+		return float64(len(s.distinct))
+	default:
+		return float64(s.count)
+	}
+}
+
+// stringColumn returns a row-index accessor formatting the field as a string.
+func stringColumn(result *Result, field string) (func(i int) string, error) {
+	if result.IsRecords {
+		return func(i int) string {
+			value, exists := result.Records[i][field]
+			if !exists {
+				return ""
+			}
+			if number, ok := value.(float64); ok {
+				return strconv.FormatFloat(number, 'f', -1, 64)
+			}
+
+			return fmt.Sprint(value)
+		}, nil
+	}
+
+	accessor, ok := models.StringFieldAccessor(field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownField, field)
+	}
+
+	return func(i int) string { return accessor(&result.Connections[i]) }, nil
+}
+
+// valueColumn returns a row-index accessor yielding the field as a number (when numeric)
+// and as a string. An empty field or "*" yields no value, which is only meaningful for count().
+func valueColumn(result *Result, field string) (func(i int) (float64, string, bool), error) {
+	if field == "" || field == "*" {
+		return func(int) (float64, string, bool) { return 0, "", false }, nil
+	}
+
+	text, err := stringColumn(result, field)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.IsRecords {
+		return func(i int) (float64, string, bool) {
+			value, exists := result.Records[i][field]
+			number, _ := value.(float64)
+
+			return number, text(i), exists
+		}, nil
+	}
+
+	numeric, isNumeric := models.NumericFieldAccessor(field)
+
+	return func(i int) (float64, string, bool) {
+		if isNumeric {
+			return numeric(&result.Connections[i]), text(i), true
+		}
+
+		return 0, text(i), true
+	}, nil
+}
@@ -0,0 +1,51 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"zeek-viz/models"
+)
+
+func TestHeadAndTailCounts(t *testing.T) {
+	connections := make([]models.Connection, 5)
+	for i := range connections {
+		connections[i].UID = fmt.Sprintf("C%d", i)
+	}
+
+	tests := []struct {
+		query string
+		want  []string // UIDs kept
+	}{
+		{"head", []string{"C0"}},
+		{"head 2", []string{"C0", "C1"}},
+		{"tail 2", []string{"C3", "C4"}},
+		{"head 2147483647", []string{"C0", "C1", "C2", "C3", "C4"}},
+		{"tail 2147483647", []string{"C0", "C1", "C2", "C3", "C4"}},
+	}
+	for _, test := range tests {
+		pipeline, err := ParsePipeline(test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		result, err := pipeline.Execute(connections)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		uids := make([]string, len(result.Connections))
+		for i := range result.Connections {
+			uids[i] = result.Connections[i].UID
+		}
+		if fmt.Sprint(uids) != fmt.Sprint(test.want) {
+			t.Errorf("%s kept %v, want %v", test.query, uids, test.want)
+		}
+	}
+
+	for _, query := range []string{"head 1e300", "tail 999999999999999999999", "head 2147483648", "head 0", "tail 1.5", "head -3"} {
+		_, err := ParsePipeline(query)
+		if !errors.Is(err, errInvalidCount) {
+			t.Errorf("%s: error %v, want %v", query, err, errInvalidCount)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	configFlag = "config"    // Flag naming the config file
+	envPrefix  = "ZEEK_VIZ_" // Prefix of the environment variables that set flags
+)
+
+var (
+	errUnknownSetting = errors.New("unknown setting")
+	errInvalidSetting = errors.New("invalid value")
+)
+
+// applySettings fills the flags not given on the command line from environment variables
+// named after them (--data-dir from ZEEK_VIZ_DATA_DIR), and then from the JSON config file
+// named by --config or ZEEK_VIZ_CONFIG, keyed by flag name. The command line takes precedence
+// over the environment, and the environment over the file.
+func applySettings(flags *flag.FlagSet) error {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	configFile := flags.Lookup(configFlag).Value.String()
+	if !given[configFlag] {
+		configFile = os.Getenv(envName(configFlag))
+	}
+	fileValues, err := readConfigFile(flags, configFile)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	flags.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || f.Name == configFlag {
+			return
+		}
+
+		value, source := os.Getenv(envName(f.Name)), envName(f.Name)
+		if value == "" {
+			var exists bool
+			value, exists = fileValues[f.Name]
+			if !exists {
+				return
+			}
+			source = configFile
+		}
+
+		err := flags.Set(f.Name, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w %q for %s from %s: %w", errInvalidSetting, value, f.Name, source, err))
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// envName returns the environment variable that sets a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigFile reads the flag values of a JSON config file. Values are strings, numbers,
+// booleans, or, for flags taking comma-separated lists, arrays of strings. An empty path
+// yields no values.
+func readConfigFile(flags *flag.FlagSet, path string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var settings map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Keep integers such as upload sizes exact
+	err = decoder.Decode(&settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for name, setting := range settings {
+		if name == configFlag || flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("%w %s in %s", errUnknownSetting, name, path)
+		}
+
+		value, ok := settingString(setting)
+		if !ok {
+			return nil, fmt.Errorf("%w for %s in %s: expected a string, number, boolean, or list of strings", errInvalidSetting, name, path)
+		}
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+// settingString returns a config file value as flag text.
+func settingString(setting any) (string, bool) {
+	switch setting := setting.(type) {
+	case string:
+		return setting, true
+	case json.Number:
+		return setting.String(), true
+	case bool:
+		return strconv.FormatBool(setting), true
+	case []any:
+		items := make([]string, len(setting))
+		for i, item := range setting {
+			text, isString := item.(string)
+			if !isString {
+				return "", false
+			}
+			items[i] = text
+		}
+
+		return strings.Join(items, ","), true
+	default:
+		return "", false
+	}
+}
@@ -1,4 +1,16 @@
 // Zeek Visualization Application
+
+// Server configuration inlined into index.html (see /api/config)
+const CONFIG = JSON.parse(document.getElementById("zeek-viz-config")?.textContent || "{}");
+const BASE_PATH = CONFIG.base_path || "";
+const FEATURES = CONFIG.features || {};
+const LIVE_REFRESH_MS = 2000; // Minimum interval between redraws while following a live log
+const EDGE_CONNECTION_LIMIT = 50; // Connections listed when an edge is clicked
+const BEACON_MIN_SCORE = 0.8; // Beacon score from which "Find Beacons" lists a tuple
+const ANOMALY_MIN_SCORE = 3.5; // Absolute anomaly score from which a timeline bucket is marked
+const HOST_PROFILE_LIMIT = 10; // Peers and services listed when a node is clicked
+const STATIC_LAYOUT_NODES = 500; // Nodes from which the graph keeps the force-directed layout of the server
+
 class ZeekVisualizer {
   constructor() {
     this.data = {
@@ -6,13 +18,32 @@ class ZeekVisualizer {
       graph: { nodes: [], edges: [] },
       timeline: { points: [], start: 0, end: 0 },
       stats: {},
+      notices: { alerts: [], hosts: new Map(), edges: new Map() },
     };
 
     this.filters = {
       protocol: "all",
       connState: "all",
       timeRange: null,
+      excludeNoise: false,
+      country: "",
+      threat: false,
+      query: "", // Expression of the q parameter
+      other: {}, // Filters of a saved view that have no control, such as hosts and ports
     };
+    this.fileEntries = new Map(); // Listed datasets by ID
+    this.views = [];
+    this.currentView = null;
+    this.subnetGroup = "";
+    this.edgeBy = "protocol";
+    this.timelineBucket = "auto";
+    this.timelineGroup = "";
+    this.colorBy = "locality";
+    this.sizeBy = "connections";
+    this.layout = "force";
+    this.showHostnames = true;
+    this.countryColors = d3.scaleOrdinal(d3.schemeTableau10);
+    this.communityColors = d3.scaleOrdinal(d3.schemeCategory10);
 
     this.svg = {
       network: null,
@@ -20,12 +51,20 @@ class ZeekVisualizer {
     };
 
     this.simulation = null;
+    this.zoom = null;
+    this.staticLayout = false; // Nodes stay where the server placed them
     this.brush = null;
+    this.liveRefreshTimer = null;
 
     this.init();
   }
 
   async init() {
+    if (FEATURES.auth && !(await this.signIn())) {
+      this.showLoading(false);
+      return;
+    }
+
     this.setupUI();
     this.setupFileUpload();
 
@@ -34,20 +73,121 @@ class ZeekVisualizer {
 
     this.showVisualizationSections(false);
     this.showLoading(false);
+    await this.loadViews();
+
+    // Shared links name a saved view to open right away
+    const viewId = new URLSearchParams(location.search).get("view");
+    if (viewId) {
+      await this.openSharedView(viewId);
+    }
+
+    if (FEATURES.live_tail) {
+      this.followLiveUpdates();
+    }
+  }
+
+  // Resolves to true once API requests are authenticated: by a session cookie, basic auth the
+  // browser asked for, or a proxy, or after the user enters an API token.
+  async signIn() {
+    let response = await fetch(BASE_PATH + "/api/me");
+    while (response.status === 401) {
+      const { methods = [] } = await response.json();
+      if (!methods.includes("token")) {
+        alert("Sign-in required. Reload the page to try again.");
+        return false;
+      }
+      const token = prompt("API token");
+      if (!token) {
+        return false;
+      }
+      const login = await fetch(BASE_PATH + "/api/login", {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token.trim()}` },
+      });
+      if (!login.ok) {
+        alert("Invalid API token");
+      }
+      response = await fetch(BASE_PATH + "/api/me");
+    }
+    if (!response.ok) {
+      return false;
+    }
+
+    const me = await response.json();
+    document.getElementById("auth-user").textContent = `Signed in as ${me.user}`;
+    document.getElementById("auth-status").classList.remove("hidden");
+    if (me.method === "session") {
+      // Basic auth and proxy sign-ins last as long as the browser or proxy keeps them
+      const signOut = document.getElementById("sign-out");
+      signOut.classList.remove("hidden");
+      signOut.addEventListener("click", async () => {
+        await fetch(BASE_PATH + "/api/logout", { method: "POST" });
+        location.reload();
+      });
+    }
+
+    return true;
+  }
+
+  followLiveUpdates() {
+    // EventSource reconnects by itself when the stream drops
+    const events = new EventSource(BASE_PATH + "/api/live/events");
+    events.addEventListener("connections", (event) => {
+      const delta = JSON.parse(event.data);
+      const selected = document.getElementById("file-selector").value;
+      if (selected && selected !== delta.file_id) {
+        return;
+      }
+      this.scheduleLiveRefresh();
+    });
+  }
+
+  scheduleLiveRefresh() {
+    // Bursts of deltas are coalesced into one redraw
+    if (this.liveRefreshTimer) {
+      return;
+    }
+    this.liveRefreshTimer = setTimeout(async () => {
+      this.liveRefreshTimer = null;
+      try {
+        if (document.querySelector(".visualization-container").classList.contains("hidden")) {
+          // First connections of a followed log with nothing else loaded
+          this.showUploadSection(false);
+          this.showVisualizationSections(true);
+          await this.updateFileSelector();
+          await this.loadDataAndVisualize();
+          return;
+        }
+
+        await this.loadData();
+        await this.updateVisualizations();
+        if (!this.filters.timeRange) {
+          this.createTimelineVisualization(); // Keeps a brushed selection intact otherwise
+        }
+        this.updateStats();
+        this.updateFileSelector();
+      } catch (error) {
+        console.error("Live refresh failed:", error);
+      }
+    }, LIVE_REFRESH_MS);
   }
 
   async loadData() {
     try {
       // Load all data in parallel
-      const [statsResponse, graphResponse, timelineResponse] = await Promise.all([
-        fetch("/api/stats"),
-        fetch("/api/nodes"),
-        fetch("/api/timeline"),
+      const [statsResponse, graphResponse, timelineResponse, protocolsResponse, noticesResponse] = await Promise.all([
+        fetch(this.statsURL()),
+        fetch(`${BASE_PATH}/api/nodes?layout=${this.layout}`),
+        fetch(this.timelineURL()),
+        fetch(BASE_PATH + "/api/values?field=proto"),
+        fetch(BASE_PATH + "/api/notices"),
       ]);
 
       this.data.stats = await statsResponse.json();
       this.data.graph = await graphResponse.json();
       this.data.timeline = await timelineResponse.json();
+      this.data.protocols = (await protocolsResponse.json()).values || [];
+      this.setNotices(noticesResponse.ok ? await noticesResponse.json() : {});
 
       console.log("Data loaded:", {
         stats: this.data.stats,
@@ -60,6 +200,57 @@ class ZeekVisualizer {
     }
   }
 
+  // Keeps the alerts of attached notice.log and weird.log files, with their counts by host and edge
+  setNotices(notices) {
+    this.data.notices = {
+      alerts: notices.alerts || [],
+      hosts: new Map((notices.hosts || []).map((h) => [h.host, h])),
+      edges: new Map((notices.edges || []).map((e) => [`${e.source}|${e.target}`, e])),
+    };
+  }
+
+  // Alert counts of the edge between two hosts, in either direction
+  edgeAlerts(edge) {
+    const id = (end) => (typeof end === "object" ? end.id : end);
+    const { edges } = this.data.notices;
+    return edges.get(`${id(edge.source)}|${id(edge.target)}`) || edges.get(`${id(edge.target)}|${id(edge.source)}`);
+  }
+
+  timelineURL() {
+    const params = new URLSearchParams({ bucket: this.timelineBucket });
+    if (this.timelineGroup) {
+      params.set("group_by", this.timelineGroup);
+    }
+    return `${BASE_PATH}/api/timeline?${params}`;
+  }
+
+  async reloadTimeline() {
+    try {
+      const response = await fetch(this.timelineURL());
+      this.data.timeline = await response.json();
+      this.filters.timeRange = null;
+      document.getElementById("timeline-selection").textContent = "Select a time range to filter connections";
+      this.createTimelineVisualization();
+      this.updateVisualizations();
+    } catch (error) {
+      console.error("Failed to reload timeline:", error);
+    }
+  }
+
+  statsURL() {
+    return BASE_PATH + "/api/stats" + (this.filters.excludeNoise ? "?exclude_noise=true" : "");
+  }
+
+  async reloadStats() {
+    try {
+      const response = await fetch(this.statsURL());
+      this.data.stats = await response.json();
+      this.updateStats();
+    } catch (error) {
+      console.error("Failed to reload stats:", error);
+    }
+  }
+
   setupUI() {
     // File selector
     const fileSelector = document.getElementById("file-selector");
@@ -75,6 +266,11 @@ class ZeekVisualizer {
       this.showVisualizationSections(false);
     });
 
+    // Rename file button
+    document.getElementById("rename-file").addEventListener("click", () => {
+      this.renameCurrentFile();
+    });
+
     // Delete file button
     document.getElementById("delete-file").addEventListener("click", () => {
       this.deleteCurrentFile();
@@ -99,6 +295,102 @@ class ZeekVisualizer {
       this.updateVisualizations();
     });
 
+    // Broadcast/multicast noise filter
+    const excludeNoise = document.getElementById("exclude-noise");
+    excludeNoise.addEventListener("change", (e) => {
+      this.filters.excludeNoise = e.target.checked;
+      this.reloadStats();
+      this.updateVisualizations();
+    });
+
+    // Subnet grouping collapses the hosts of each prefix into one node, AS grouping the external
+    // hosts of each autonomous system or organization
+    const subnetGroup = document.getElementById("subnet-group");
+    subnetGroup.addEventListener("change", (e) => {
+      this.subnetGroup = e.target.value;
+      this.updateVisualizations();
+    });
+
+    // Edge aggregation merges the connections of a host pair across protocols, or splits
+    // them by service or port
+    document.getElementById("edge-by").addEventListener("change", (e) => {
+      this.edgeBy = e.target.value;
+      this.updateVisualizations();
+    });
+
+    // Country coloring and filtering need a GeoIP database on the server
+    if (FEATURES.geoip) {
+      document.querySelectorAll(".geoip-only").forEach((group) => group.classList.remove("hidden"));
+    }
+    document.getElementById("color-by").addEventListener("change", (e) => {
+      this.colorBy = e.target.value;
+      this.restyleNodes();
+    });
+    document.getElementById("size-by").addEventListener("change", (e) => {
+      this.sizeBy = e.target.value;
+      this.restyleNodes();
+    });
+    document.getElementById("country-filter").addEventListener("change", (e) => {
+      this.filters.country = e.target.value.trim().toUpperCase();
+      this.updateVisualizations();
+    });
+    document.getElementById("query-filter").addEventListener("change", (e) => {
+      this.filters.query = e.target.value.trim();
+      this.updateVisualizations();
+    });
+
+    // Hostname labels need reverse DNS on the server; unchecking skips the lookups
+    if (FEATURES.reverse_dns) {
+      document.querySelectorAll(".rdns-only").forEach((group) => group.classList.remove("hidden"));
+    }
+    document.getElementById("show-hostnames").addEventListener("change", (e) => {
+      this.showHostnames = e.target.checked;
+      this.updateVisualizations();
+    });
+
+    // IOC lists are matched on the server; matching nodes and edges come back flagged
+    const intelInput = document.getElementById("intel-input");
+    document.getElementById("load-intel").addEventListener("click", () => intelInput.click());
+    intelInput.addEventListener("change", (e) => {
+      if (e.target.files.length > 0) {
+        this.uploadIntel(e.target.files[0]);
+        e.target.value = "";
+      }
+    });
+    document.getElementById("threat-only").addEventListener("change", (e) => {
+      this.filters.threat = e.target.checked;
+      this.updateVisualizations();
+    });
+
+    // Timeline bucket size and stacking are computed by the server
+    document.getElementById("timeline-bucket").addEventListener("change", (e) => {
+      this.timelineBucket = e.target.value;
+      this.reloadTimeline();
+    });
+    document.getElementById("timeline-group").addEventListener("change", (e) => {
+      this.timelineGroup = e.target.value;
+      this.reloadTimeline();
+    });
+
+    // Saved views restore filters and display options, and link to them
+    document.getElementById("view-select").addEventListener("change", (e) => {
+      const view = this.views.find((v) => v.id === e.target.value);
+      if (view) {
+        this.applyView(view);
+      } else {
+        this.clearView();
+      }
+    });
+    document.getElementById("save-view").addEventListener("click", () => {
+      this.saveView();
+    });
+    document.getElementById("copy-view-link").addEventListener("click", () => {
+      this.copyViewLink();
+    });
+    document.getElementById("delete-view").addEventListener("click", () => {
+      this.deleteView();
+    });
+
     // Layout selector
     const layoutSelect = document.getElementById("layout-select");
     layoutSelect.addEventListener("change", (e) => {
@@ -114,6 +406,46 @@ class ZeekVisualizer {
       this.refresh();
     });
 
+    document.getElementById("export-evidence").addEventListener("click", () => {
+      this.exportEvidence();
+    });
+
+    document.getElementById("export-csv").addEventListener("click", () => {
+      this.exportConnections("csv");
+    });
+
+    document.getElementById("export-ndjson").addEventListener("click", () => {
+      this.exportConnections("ndjson");
+    });
+
+    document.getElementById("export-graph").addEventListener("click", () => {
+      this.exportGraph(document.getElementById("graph-export-format").value);
+    });
+
+    document.getElementById("find-beacons").addEventListener("click", () => {
+      this.showBeacons();
+    });
+
+    document.getElementById("find-scans").addEventListener("click", () => {
+      this.showScans();
+    });
+
+    document.getElementById("find-exfil").addEventListener("click", () => {
+      this.showExfil();
+    });
+
+    document.getElementById("find-long").addEventListener("click", () => {
+      this.showLongConnections();
+    });
+
+    document.getElementById("find-tls").addEventListener("click", () => {
+      this.showTLSFingerprints();
+    });
+
+    document.getElementById("find-new-hosts").addEventListener("click", () => {
+      this.showNewHosts();
+    });
+
     // Details panel
     document.getElementById("close-details").addEventListener("click", () => {
       this.hideDetails();
@@ -125,14 +457,21 @@ class ZeekVisualizer {
     const fileInput = document.getElementById("file-input");
     const browseButton = document.getElementById("browse-button");
 
+    const demoButton = document.getElementById("demo-button");
+
     // Browse button click
     browseButton.addEventListener("click", () => {
       fileInput.click();
     });
 
+    // Demo button click
+    demoButton.addEventListener("click", () => {
+      this.loadDemoData();
+    });
+
     // Upload area click
     uploadArea.addEventListener("click", (e) => {
-      if (e.target !== browseButton) {
+      if (e.target !== browseButton && e.target !== demoButton) {
         fileInput.click();
       }
     });
@@ -140,7 +479,7 @@ class ZeekVisualizer {
     // File input change
     fileInput.addEventListener("change", (e) => {
       if (e.target.files.length > 0) {
-        this.handleFileUpload(e.target.files[0]);
+        this.handleFileUpload(e.target.files);
       }
     });
 
@@ -161,40 +500,56 @@ class ZeekVisualizer {
 
       const files = e.dataTransfer.files;
       if (files.length > 0) {
-        this.handleFileUpload(files[0]);
+        this.handleFileUpload(files);
       }
     });
   }
 
-  async handleFileUpload(file) {
-    // Validate file size (50MB limit)
-    const maxSize = 50 * 1024 * 1024;
-    if (file.size > maxSize) {
-      alert("File size too large. Maximum size is 50MB.");
-      return;
-    }
+  async handleFileUpload(files) {
+    // A single file beyond the multipart limit (50MB unless configured) is streamed as the raw
+    // request body; several files, or archives of a log directory, are sent together as a batch
+    const maxFormSize = CONFIG.max_upload_size || 50 * 1024 * 1024;
+    const file = files[0];
 
     // Show progress
     this.showUploadProgress(true);
     this.updateUploadProgress(0, "Preparing upload...");
 
     try {
-      const formData = new FormData();
-      formData.append("logfile", file);
+      let response;
+      if (files.length === 1 && file.size > maxFormSize) {
+        const params = new URLSearchParams({ filename: file.name });
+        response = await this.uploadWithProgress(BASE_PATH + "/api/upload/stream?" + params, file);
+      } else {
+        const formData = new FormData();
+        for (const selected of files) {
+          formData.append("logfile", selected);
+        }
 
-      // Upload with progress tracking
-      const response = await this.uploadWithProgress("/api/upload", formData);
+        // Upload with progress tracking
+        response = await this.uploadWithProgress(BASE_PATH + "/api/upload", formData);
+      }
 
       if (response.success) {
-        this.updateUploadProgress(100, `Successfully loaded ${response.connections_count} connections`);
+        // Skipped lines stay on screen long enough to read, with the first offending lines
+        // A re-uploaded file opens the dataset that already holds it
+        const warning = this.uploadWarning(response);
+        const loaded =
+          response.status === "duplicate"
+            ? `${response.message}; showing it`
+            : `Successfully loaded ${response.connections_count} connections`;
+        this.updateUploadProgress(100, loaded + (warning ? `. Warning: ${warning}` : ""));
 
         // Update file list and hide upload section
-        setTimeout(() => {
-          this.showUploadSection(false);
-          this.showVisualizationSections(true);
-          this.updateFileSelector();
-          this.loadDataAndVisualize();
-        }, 1500);
+        setTimeout(
+          () => {
+            this.showUploadSection(false);
+            this.showVisualizationSections(true);
+            this.updateFileSelector();
+            this.loadDataAndVisualize();
+          },
+          warning ? 8000 : 1500,
+        );
       } else {
         throw new Error(response.message || "Upload failed");
       }
@@ -205,7 +560,51 @@ class ZeekVisualizer {
     }
   }
 
-  async uploadWithProgress(url, formData) {
+  // uploadWarning describes the lines an upload skipped, with the first few offending lines,
+  // or returns "" when every line parsed. Batch uploads report each file separately.
+  uploadWarning(response) {
+    const reports = response.files
+      ? response.files.map((file) => file.parse_errors).filter(Boolean)
+      : [response.parse_errors].filter(Boolean);
+    const skipped = reports.reduce((sum, report) => sum + (report.skipped_lines || 0), 0);
+    if (skipped === 0) {
+      return "";
+    }
+
+    const total = reports.reduce((sum, report) => sum + (report.total_lines || 0), 0);
+    const samples = reports
+      .flatMap((report) => report.samples || [])
+      .slice(0, 3)
+      .map((sample) => `line ${sample.line}: ${sample.reason}`);
+    const warning = response.warning || `${skipped} of ${total} lines could not be parsed and were skipped`;
+
+    return samples.length > 0 ? `${warning} (${samples.join("; ")})` : warning;
+  }
+
+  async loadDemoData() {
+    this.showUploadProgress(true);
+    this.updateUploadProgress(50, "Loading demo data...");
+
+    try {
+      const response = await fetch(BASE_PATH + "/api/demo/load", { method: "POST" });
+      if (!response.ok) {
+        throw new Error(`status ${response.status}`);
+      }
+      const result = await response.json();
+
+      this.updateUploadProgress(100, result.message);
+      this.showUploadSection(false);
+      this.showVisualizationSections(true);
+      this.updateFileSelector();
+      this.loadDataAndVisualize();
+    } catch (error) {
+      console.error("Failed to load demo data:", error);
+      this.updateUploadProgress(0, "Failed to load demo data: " + error.message);
+      this.showUploadProgress(false);
+    }
+  }
+
+  async uploadWithProgress(url, body) {
     return new Promise((resolve, reject) => {
       const xhr = new XMLHttpRequest();
 
@@ -225,7 +624,13 @@ class ZeekVisualizer {
             reject(new Error("Invalid response format"));
           }
         } else {
-          reject(new Error(`Upload failed with status ${xhr.status}`));
+          let message = `Upload failed with status ${xhr.status}`;
+          try {
+            message = JSON.parse(xhr.responseText).message || message;
+          } catch (e) {
+            // Plain-text error responses keep the generic message
+          }
+          reject(new Error(message));
         }
       });
 
@@ -235,7 +640,7 @@ class ZeekVisualizer {
 
       this.updateUploadProgress(5, "Starting upload...");
       xhr.open("POST", url);
-      xhr.send(formData);
+      xhr.send(body);
     });
   }
 
@@ -264,16 +669,25 @@ class ZeekVisualizer {
     d3.select(container).select("svg").remove();
 
     // Create SVG
-    this.svg.network = d3
-      .select(container)
-      .append("svg")
-      .attr("width", width)
-      .attr("height", height)
-      .call(
-        d3.zoom().on("zoom", (event) => {
-          this.svg.network.select(".graph-container").attr("transform", event.transform);
-        })
-      );
+    this.zoom = d3.zoom().on("zoom", (event) => {
+      this.svg.network.select(".graph-container").attr("transform", event.transform);
+    });
+    this.svg.network = d3.select(container).append("svg").attr("width", width).attr("height", height).call(this.zoom);
+
+    // Arrowheads point edges from the originator to the responder
+    this.svg.network
+      .append("defs")
+      .append("marker")
+      .attr("id", "edge-arrow")
+      .attr("viewBox", "0 -5 10 10")
+      .attr("refX", 10)
+      .attr("markerUnits", "userSpaceOnUse")
+      .attr("markerWidth", 8)
+      .attr("markerHeight", 8)
+      .attr("orient", "auto-start-reverse")
+      .append("path")
+      .attr("class", "edge-arrow")
+      .attr("d", "M0,-5L10,0L0,5");
 
     const g = this.svg.network.append("g").attr("class", "graph-container");
 
@@ -292,15 +706,28 @@ class ZeekVisualizer {
       .force("collision", d3.forceCollide().radius(20));
 
     await this.updateNetworkVisualization(g);
+    this.fitNetworkView();
   }
 
   async updateNetworkVisualization(g) {
     if (!g) g = this.svg.network.select(".graph-container");
 
-    const { nodes, edges } = await this.getFilteredGraphData();
+    const { nodes, edges, layout } = await this.getFilteredGraphData();
+    this.graphLayout = layout;
+
+    // Large graphs keep the positions the server computed, since simulating them in the browser
+    // is slow; smaller force-directed ones start from them and keep moving
+    this.staticLayout = !!layout && (layout.algorithm !== "force" || nodes.length > STATIC_LAYOUT_NODES);
+    if (this.staticLayout) {
+      nodes.forEach((node) => {
+        node.fx = node.x;
+        node.fy = node.y;
+      });
+    }
+    this.drawLayoutGroups(g, (layout && layout.groups) || []);
 
     // Update links
-    const link = g.selectAll(".link").data(edges, (d) => `${d.source}-${d.target}-${d.protocol}`);
+    const link = g.selectAll(".link").data(edges, (d) => `${d.source}-${d.target}-${d.protocol}-${d.service}-${d.port}`);
 
     link.exit().remove();
 
@@ -308,9 +735,16 @@ class ZeekVisualizer {
       .enter()
       .append("line")
       .attr("class", (d) => `link ${d.protocol}`)
-      .attr("stroke-width", (d) => Math.max(1, Math.min(5, d.weight / 100)));
+      .attr("stroke-width", (d) => Math.max(1, Math.min(5, d.weight / 100)))
+      .on("click", (event, d) => this.showEdgeDetails(d));
 
-    link.merge(linkEnter);
+    // Edges of host pairs that both originated connections get an arrowhead at each end
+    link
+      .merge(linkEnter)
+      .classed("threat", (d) => !!d.threat)
+      .classed("alerted", (d) => !!this.edgeAlerts(d))
+      .attr("marker-end", "url(#edge-arrow)")
+      .attr("marker-start", (d) => (d.reverse_count ? "url(#edge-arrow)" : null));
 
     // Update nodes
     const node = g.selectAll(".node").data(nodes, (d) => d.id);
@@ -321,13 +755,19 @@ class ZeekVisualizer {
       .enter()
       .append("circle")
       .attr("class", (d) => `node ${d.is_local ? "local" : "external"}`)
-      .attr("r", (d) => Math.max(8, Math.min(25, Math.sqrt(d.connections) * 3)))
       .call(this.dragHandler())
       .on("click", (event, d) => this.showNodeDetails(d))
       .on("mouseover", (event, d) => this.showTooltip(event, d))
       .on("mouseout", () => this.hideTooltip());
 
-    node.merge(nodeEnter);
+    node
+      .merge(nodeEnter)
+      .classed("threat", (d) => !!d.threat)
+      .classed("scanner", (d) => !!d.scan)
+      .classed("annotated", (d) => !!d.annotation)
+      .classed("alerted", (d) => this.data.notices.hosts.has(d.id))
+      .attr("r", (d) => this.nodeRadius(d))
+      .style("fill", (d) => this.nodeColor(d));
 
     // Add labels
     const label = g.selectAll(".node-label").data(nodes, (d) => d.id);
@@ -337,21 +777,20 @@ class ZeekVisualizer {
     const labelEnter = label
       .enter()
       .append("text")
-      .attr("class", "node-label")
-      .text((d) => this.formatNodeLabel(d.label));
+      .attr("class", "node-label");
 
-    label.merge(labelEnter);
+    label.merge(labelEnter).text((d) => this.formatNodeLabel(d.hostname || d.label));
 
     // Update simulation
     this.simulation.nodes(nodes);
     this.simulation.force("link").links(edges);
 
-    this.simulation.on("tick", () => {
-      g.selectAll(".link")
-        .attr("x1", (d) => d.source.x)
-        .attr("y1", (d) => d.source.y)
-        .attr("x2", (d) => d.target.x)
-        .attr("y2", (d) => d.target.y);
+    this.renderNetwork = () => {
+      g.selectAll(".link").each((d, i, lines) => {
+        const start = d.reverse_count ? this.edgeEnd(d.target, d.source) : d.source;
+        const end = this.edgeEnd(d.source, d.target);
+        d3.select(lines[i]).attr("x1", start.x).attr("y1", start.y).attr("x2", end.x).attr("y2", end.y);
+      });
 
       g.selectAll(".node")
         .attr("cx", (d) => d.x)
@@ -360,9 +799,101 @@ class ZeekVisualizer {
       g.selectAll(".node-label")
         .attr("x", (d) => d.x)
         .attr("y", (d) => d.y + 4);
-    });
+    };
+    this.simulation.on("tick", this.renderNetwork);
+
+    if (this.staticLayout) {
+      this.simulation.stop();
+      this.renderNetwork();
+    } else if (layout) {
+      this.simulation.force("center", d3.forceCenter(layout.width / 2, layout.height / 2));
+      this.simulation.alpha(0.3).restart();
+    } else {
+      this.simulation.alpha(1).restart();
+    }
+  }
 
-    this.simulation.alpha(1).restart();
+  // Community colors and centrality sizes need the metrics of analytics=true
+  needsAnalytics() {
+    return this.colorBy === "community" || this.sizeBy !== "connections";
+  }
+
+  // Recolors and resizes the nodes, fetching the graph again when it lacks the metrics
+  restyleNodes() {
+    const nodes = d3.selectAll("#network-graph .node");
+    if (this.needsAnalytics() && !nodes.empty() && !nodes.datum().metrics) {
+      this.updateNetworkVisualization();
+      return;
+    }
+    nodes.attr("r", (d) => this.nodeRadius(d)).style("fill", (d) => this.nodeColor(d));
+  }
+
+  nodeRadius(node) {
+    if (node.metrics && this.sizeBy === "degree") {
+      return Math.max(8, Math.min(25, Math.sqrt(node.metrics.degree) * 4));
+    }
+    if (node.metrics && this.sizeBy === "betweenness") {
+      return 8 + 17 * Math.sqrt(node.metrics.betweenness);
+    }
+    return Math.max(8, Math.min(25, Math.sqrt(node.connections) * 3));
+  }
+
+  // Returns where an edge from one node meets the outline of the other, so its arrowhead shows
+  edgeEnd(from, to) {
+    const dx = to.x - from.x;
+    const dy = to.y - from.y;
+    const length = Math.hypot(dx, dy);
+    if (!length) return to;
+
+    const offset = this.nodeRadius(to) / length;
+    return { x: to.x - dx * offset, y: to.y - dy * offset };
+  }
+
+  // Draws the subnet boxes of the hierarchical layout behind the graph
+  drawLayoutGroups(g, groups) {
+    let container = g.select(".layout-groups");
+    if (container.empty()) {
+      container = g.insert("g", ":first-child").attr("class", "layout-groups");
+    }
+
+    const group = container.selectAll(".layout-group").data(groups, (d) => `${d.is_local}-${d.id}`);
+
+    group.exit().remove();
+
+    const groupEnter = group
+      .enter()
+      .append("g")
+      .attr("class", (d) => `layout-group ${d.is_local ? "local" : "external"}`);
+    groupEnter.append("rect");
+    groupEnter.append("text");
+
+    const merged = group.merge(groupEnter);
+    merged
+      .select("rect")
+      .attr("x", (d) => d.x)
+      .attr("y", (d) => d.y)
+      .attr("width", (d) => d.width)
+      .attr("height", (d) => d.height);
+    merged
+      .select("text")
+      .attr("x", (d) => d.x + 6)
+      .attr("y", (d) => d.y + 16)
+      .text((d) => `${d.id} (${d.nodes})`);
+  }
+
+  // Zooms the graph so the server's layout fits the view
+  fitNetworkView() {
+    const layout = this.graphLayout;
+    if (!layout || !this.zoom || !layout.width || !layout.height) return;
+
+    const container = document.getElementById("network-graph");
+    const width = container.clientWidth;
+    const height = container.clientHeight;
+    const scale = Math.min(1, width / layout.width, height / layout.height);
+    const transform = d3.zoomIdentity
+      .translate((width - layout.width * scale) / 2, (height - layout.height * scale) / 2)
+      .scale(scale);
+    this.svg.network.call(this.zoom.transform, transform);
   }
 
   createTimelineVisualization() {
@@ -391,70 +922,514 @@ class ZeekVisualizer {
 
     if (points.length === 0) return;
 
-    // Scales
+    // Scales; the domain ends with the last bucket so every bar gets its full width
+    const bucketMs = (this.data.timeline.bucket_size || 10) * 1000;
     const xScale = d3
       .scaleTime()
-      .domain(d3.extent(points, (d) => new Date(d.timestamp * 1000)))
+      .domain([
+        new Date(points[0].timestamp * 1000),
+        new Date(points[points.length - 1].timestamp * 1000 + bucketMs),
+      ])
       .range([0, width]);
+    const barWidth = (d) =>
+      Math.max(1, (xScale(new Date(d.timestamp * 1000 + bucketMs)) - xScale(new Date(d.timestamp * 1000))) * 0.8);
 
     const yScale = d3
       .scaleLinear()
       .domain([0, d3.max(points, (d) => d.count)])
       .range([height, 0]);
 
-    // Clear existing
-    g.selectAll("*").remove();
+    // Clear existing
+    g.selectAll("*").remove();
+
+    // Bars, stacked by series when the timeline is grouped
+    const series = this.data.timeline.series || [];
+    const bars = [];
+    if (series.length > 0) {
+      const offsets = new Map();
+      series.forEach((s) => {
+        s.points.forEach((p) => {
+          const y0 = offsets.get(p.timestamp) || 0;
+          offsets.set(p.timestamp, y0 + p.count);
+          bars.push({ ...p, key: s.key, y0 });
+        });
+      });
+    } else {
+      points.forEach((p) => bars.push({ ...p, y0: 0 }));
+    }
+    const seriesColors = d3.scaleOrdinal(d3.schemeTableau10).domain(series.map((s) => s.key));
+
+    g.selectAll(".timeline-bar")
+      .data(bars)
+      .enter()
+      .append("rect")
+      .attr("class", "timeline-bar")
+      .attr("x", (d) => xScale(new Date(d.timestamp * 1000)))
+      .attr("y", (d) => yScale(d.y0 + d.count))
+      .attr("width", barWidth)
+      .attr("height", (d) => yScale(d.y0) - yScale(d.y0 + d.count))
+      .style("fill", (d) => (d.key === undefined ? null : seriesColors(d.key)))
+      .on("mouseover", (event, d) => this.showTimelineTooltip(event, d))
+      .on("mouseout", () => this.hideTooltip());
+
+    // Markers above buckets that spike or drop against the buckets before them
+    const anomalies = points.filter((p) => Math.abs(p.anomaly_score || 0) >= ANOMALY_MIN_SCORE);
+    g.selectAll(".timeline-anomaly")
+      .data(anomalies)
+      .enter()
+      .append("path")
+      .attr("class", "timeline-anomaly")
+      .attr("d", d3.symbol().type(d3.symbolTriangle).size(30))
+      .attr("transform", (d) => {
+        const x = xScale(new Date(d.timestamp * 1000)) + barWidth(d) / 2;
+        const y = Math.max(6, yScale(d.count) - 6);
+        return `translate(${x},${y}) rotate(${d.anomaly_score < 0 ? 180 : 0})`;
+      })
+      .on("mouseover", (event, d) => this.showTimelineTooltip(event, d))
+      .on("mouseout", () => this.hideTooltip());
+
+    // Markers along the top for the buckets with notices or weirds
+    this.bucketAlerts = new Map();
+    const bisect = d3.bisector((p) => p.timestamp).right;
+    this.data.notices.alerts.forEach((alert) => {
+      const point = points[bisect(points, alert.ts) - 1];
+      if (!point) return;
+      if (!this.bucketAlerts.has(point.timestamp)) this.bucketAlerts.set(point.timestamp, []);
+      this.bucketAlerts.get(point.timestamp).push(alert);
+    });
+    g.selectAll(".timeline-alert")
+      .data(points.filter((p) => this.bucketAlerts.has(p.timestamp)))
+      .enter()
+      .append("path")
+      .attr("class", (d) =>
+        this.bucketAlerts.get(d.timestamp).some((a) => a.kind === "notice") ? "timeline-alert notice" : "timeline-alert weird"
+      )
+      .attr("d", d3.symbol().type(d3.symbolDiamond).size(40))
+      .attr("transform", (d) => `translate(${xScale(new Date(d.timestamp * 1000)) + barWidth(d) / 2},-8)`)
+      .on("mouseover", (event, d) => this.showTimelineTooltip(event, d))
+      .on("mouseout", () => this.hideTooltip());
+
+    // Axes
+    g.append("g")
+      .attr("class", "axis")
+      .attr("transform", `translate(0,${height})`)
+      .call(d3.axisBottom(xScale).tickFormat(d3.timeFormat("%H:%M")));
+
+    g.append("g").attr("class", "axis").call(d3.axisLeft(yScale));
+
+    // Brush for time range selection
+    this.brush = d3
+      .brushX()
+      .extent([
+        [0, 0],
+        [width, height],
+      ])
+      .on("brush end", (event) => this.onBrushChange(event, xScale));
+
+    g.append("g").attr("class", "brush").call(this.brush);
+  }
+
+  // In country mode, external hosts are colored by country; null keeps the locality colors
+  nodeColor(node) {
+    if (this.colorBy === "community") {
+      return node.metrics ? this.communityColors(node.metrics.community) : null;
+    }
+    if (this.colorBy !== "country" || node.is_local) {
+      return null;
+    }
+    return node.country ? this.countryColors(node.country) : "#95a5a6";
+  }
+
+  // Country, city, and autonomous system of an external host, when the server has GeoIP data
+  formatLocation(node) {
+    const place = [node.city, node.country].filter(Boolean).join(", ");
+    const as = node.asn ? `AS${node.asn}${node.as_org ? " " + node.as_org : ""}` : "";
+    return [place, as].filter(Boolean).join(" · ");
+  }
+
+  escapeHTML(text) {
+    const element = document.createElement("span");
+    element.textContent = text;
+    return element.innerHTML;
+  }
+
+  // Tags and note an analyst attached to a host or connection
+  formatAnnotation(annotation) {
+    const tags = (annotation.tags || []).map((tag) => `[${tag}]`).join(" ");
+    return this.escapeHTML([tags, annotation.note].filter(Boolean).join(" "));
+  }
+
+  // Prompts for the tags and note of an IP address or connection UID and saves them; clearing
+  // both removes the annotation. Resolves to the new annotation, null when removed, or
+  // undefined when canceled.
+  async editAnnotation(target, annotation) {
+    const tags = prompt(`Tags for ${target}, comma-separated:`, (annotation?.tags || []).join(", "));
+    if (tags === null) return undefined;
+    const note = prompt(`Note for ${target}:`, annotation?.note || "");
+    if (note === null) return undefined;
+
+    const url = `${BASE_PATH}/api/annotations/${encodeURIComponent(target)}`;
+    const tagList = tags.split(",").map((tag) => tag.trim()).filter(Boolean);
+    try {
+      if (tagList.length === 0 && !note.trim()) {
+        if (annotation) {
+          const response = await fetch(url, { method: "DELETE" });
+          if (!response.ok) {
+            throw new Error(await response.text());
+          }
+        }
+        return null;
+      }
+      const response = await fetch(url, {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ tags: tagList, note }),
+      });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const saved = await response.json();
+      return { tags: saved.tags, note: saved.note };
+    } catch (error) {
+      console.error("Failed to save annotation:", error);
+      alert("Failed to save annotation: " + error.message);
+      return undefined;
+    }
+  }
+
+  // Indicator a host matched, with the IOC list it came from
+  formatThreat(threat) {
+    const indicator = threat.indicator === threat.host ? threat.indicator : `${threat.host} in ${threat.indicator}`;
+    return `${indicator} (${threat.source}${threat.description ? ": " + threat.description : ""})`;
+  }
+
+  async uploadIntel(file) {
+    const status = document.getElementById("intel-status");
+    try {
+      const response = await fetch(`${BASE_PATH}/api/intel?source=${encodeURIComponent(file.name)}`, {
+        method: "POST",
+        body: file,
+      });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      status.textContent = `${result.indicators} indicators, ${result.matches.total_hosts} matching hosts`;
+      await this.refresh();
+    } catch (error) {
+      console.error("Failed to load IOC list:", error);
+      alert("Failed to load IOC list: " + error.message);
+    }
+  }
+
+  filterParams() {
+    const params = new URLSearchParams();
+    if (this.filters.protocol !== "all") {
+      params.set("protocol", this.filters.protocol);
+    }
+    if (this.filters.connState !== "all") {
+      params.set("conn_state", this.filters.connState);
+    }
+    if (this.filters.timeRange) {
+      params.set("start", Math.floor(this.filters.timeRange[0].getTime() / 1000));
+      params.set("end", Math.floor(this.filters.timeRange[1].getTime() / 1000));
+    }
+    if (this.filters.excludeNoise) {
+      params.set("exclude_noise", "true");
+    }
+    if (this.filters.country) {
+      params.set("country", this.filters.country);
+    }
+    if (this.filters.threat) {
+      params.set("threat", "true");
+    }
+    if (this.filters.query) {
+      params.set("q", this.filters.query);
+    }
+    for (const [name, value] of Object.entries(this.filters.other)) {
+      params.set(name, value);
+    }
+    return params;
+  }
+
+  async loadViews() {
+    try {
+      const response = await fetch(BASE_PATH + "/api/views");
+      this.views = (await response.json()).views || [];
+    } catch (error) {
+      console.error("Failed to load saved views:", error);
+      this.views = [];
+    }
+
+    const select = document.getElementById("view-select");
+    select.innerHTML = '<option value="">None</option>';
+    this.views.forEach((view) => {
+      const option = document.createElement("option");
+      option.value = view.id;
+      option.textContent = view.name;
+      option.title = view.description || "";
+      select.appendChild(option);
+    });
+    select.value = this.currentView ? this.currentView.id : "";
+    this.updateViewButtons();
+  }
+
+  // Forgets the applied view, whose link no longer matches the filters
+  clearView() {
+    if (!this.currentView) return;
+    this.currentView = null;
+    document.getElementById("view-select").value = "";
+    history.replaceState(null, "", location.pathname);
+    this.updateViewButtons();
+  }
+
+  updateViewButtons() {
+    document.getElementById("copy-view-link").disabled = !this.currentView;
+    document.getElementById("delete-view").disabled = !this.currentView;
+  }
+
+  // The filters and display options a saved view stores, as the view API takes them
+  viewState() {
+    return {
+      file_id: document.getElementById("file-selector").value,
+      filters: Object.fromEntries(this.filterParams()),
+      layout: {
+        graph_layout: document.getElementById("layout-select").value,
+        subnet_group: this.isASGrouping() ? "" : this.subnetGroup,
+        group_by: this.isASGrouping() ? this.subnetGroup : "",
+        edge_by: this.edgeBy,
+        color_by: this.colorBy,
+        size_by: this.sizeBy,
+        timeline_bucket: this.timelineBucket,
+        timeline_group: this.timelineGroup,
+      },
+    };
+  }
+
+  // Saves the current filters under a name; reusing a view's name updates that view
+  async saveView() {
+    const name = prompt("Name of the view:", this.currentView ? this.currentView.name : "");
+    if (!name || !name.trim()) return;
+
+    const existing = this.views.find((v) => v.name.toLowerCase() === name.trim().toLowerCase());
+    if (existing && existing !== this.currentView && !confirm(`Replace the saved view "${existing.name}"?`)) {
+      return;
+    }
+
+    try {
+      const response = await fetch(BASE_PATH + "/api/views" + (existing ? `/${existing.id}` : ""), {
+        method: existing ? "PUT" : "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ name, description: existing?.description, ...this.viewState() }),
+      });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.currentView = await response.json();
+      await this.loadViews();
+      this.showViewURL();
+    } catch (error) {
+      console.error("Failed to save view:", error);
+      alert("Failed to save view: " + error.message);
+    }
+  }
+
+  async deleteView() {
+    if (!this.currentView || !confirm(`Delete the saved view "${this.currentView.name}"? Links to it stop working.`)) {
+      return;
+    }
+
+    try {
+      const response = await fetch(`${BASE_PATH}/api/views/${this.currentView.id}`, { method: "DELETE" });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.clearView();
+      await this.loadViews();
+    } catch (error) {
+      console.error("Failed to delete view:", error);
+      alert("Failed to delete view: " + error.message);
+    }
+  }
+
+  viewLink(view) {
+    return new URL(view.url, location.origin).href;
+  }
+
+  // Puts the link of the current view in the address bar, so it can be bookmarked
+  showViewURL() {
+    history.replaceState(null, "", this.viewLink(this.currentView));
+  }
+
+  async copyViewLink() {
+    const link = this.viewLink(this.currentView);
+    try {
+      await navigator.clipboard.writeText(link);
+    } catch {
+      prompt("Link to the view:", link); // Clipboard access needs a secure context
+    }
+  }
+
+  // Opens the view of a shared link: switches to its dataset when it still exists, then applies it
+  async openSharedView(viewId) {
+    try {
+      const [viewResponse, filesResponse] = await Promise.all([
+        fetch(`${BASE_PATH}/api/views/${encodeURIComponent(viewId)}`),
+        fetch(BASE_PATH + "/api/files"),
+      ]);
+      if (!viewResponse.ok) {
+        throw new Error(await viewResponse.text());
+      }
+      const view = await viewResponse.json();
+      const files = (await filesResponse.json()).files || [];
+      if (files.length === 0) {
+        alert(`The saved view "${view.name}" needs a loaded log file.`);
+        return;
+      }
+
+      const file = files.find((f) => f.id === view.file_id);
+      if (file && !file.is_current) {
+        await fetch(`${BASE_PATH}/api/files/${encodeURIComponent(file.id)}/select`, { method: "POST" });
+      } else if (view.file_id && !file) {
+        console.warn(`Saved view ${view.id} was made on a file that is no longer loaded`);
+      }
+
+      this.showUploadSection(false);
+      this.showVisualizationSections(true);
+      await this.updateFileSelector();
+      await this.loadDataAndVisualize();
+      await this.applyView(view);
+    } catch (error) {
+      console.error("Failed to open saved view:", error);
+      alert("Failed to open saved view: " + error.message);
+    }
+  }
+
+  // Sets the filters and display options of a saved view and redraws
+  async applyView(view) {
+    const filters = { ...view.filters };
+    const take = (name, fallback) => {
+      const value = filters[name];
+      delete filters[name];
+      return value === undefined ? fallback : value;
+    };
+    const layout = view.layout || {};
+
+    this.filters.protocol = take("protocol", "all");
+    this.filters.connState = take("conn_state", "all");
+    this.filters.excludeNoise = take("exclude_noise", "false") === "true";
+    this.filters.country = take("country", "");
+    this.filters.threat = take("threat", "false") === "true";
+    this.filters.query = take("q", "");
+    const start = take("start");
+    const end = take("end");
+    this.filters.timeRange = start && end ? [new Date(start * 1000), new Date(end * 1000)] : null;
+    this.filters.other = filters;
+    this.subnetGroup = layout.group_by || layout.subnet_group || "";
+    this.edgeBy = layout.edge_by || "protocol";
+    this.colorBy = layout.color_by || "locality";
+    this.sizeBy = layout.size_by || "connections";
+    this.timelineBucket = layout.timeline_bucket || "auto";
+    this.timelineGroup = layout.timeline_group || "";
+
+    document.getElementById("protocol-filter").value = this.filters.protocol;
+    document.getElementById("conn-state-filter").value = this.filters.connState;
+    document.getElementById("exclude-noise").checked = this.filters.excludeNoise;
+    document.getElementById("country-filter").value = this.filters.country;
+    document.getElementById("threat-only").checked = this.filters.threat;
+    document.getElementById("query-filter").value = this.filters.query;
+    document.getElementById("subnet-group").value = this.subnetGroup;
+    document.getElementById("edge-by").value = this.edgeBy;
+    document.getElementById("color-by").value = this.colorBy;
+    document.getElementById("size-by").value = this.sizeBy;
+    document.getElementById("timeline-bucket").value = this.timelineBucket;
+    document.getElementById("timeline-group").value = this.timelineGroup;
+    document.getElementById("layout-select").value = layout.graph_layout || "force";
+
+    this.currentView = view;
+    await this.loadViews();
+    this.showViewURL();
+
+    const response = await fetch(this.timelineURL());
+    this.data.timeline = await response.json();
+    await this.reloadStats();
+    this.createTimelineVisualization();
+    if (this.filters.timeRange) {
+      const [from, to] = this.filters.timeRange;
+      document.getElementById("timeline-selection").textContent = `Selected: ${d3.timeFormat("%H:%M:%S")(
+        from
+      )} - ${d3.timeFormat("%H:%M:%S")(to)}`;
+    }
+    await this.updateVisualizations();
+    this.updateNetworkLayout(layout.graph_layout || "force");
+  }
+
+  exportEvidence() {
+    const params = this.filterParams();
+    if (params.size === 0) {
+      alert("Select a protocol, connection state, or time range to choose the connections to export.");
+      return;
+    }
 
-    // Bars
-    g.selectAll(".timeline-bar")
-      .data(points)
-      .enter()
-      .append("rect")
-      .attr("class", "timeline-bar")
-      .attr("x", (d) => xScale(new Date(d.timestamp * 1000)))
-      .attr("y", (d) => yScale(d.count))
-      .attr("width", (width / points.length) * 0.8)
-      .attr("height", (d) => height - yScale(d.count))
-      .on("mouseover", (event, d) => this.showTimelineTooltip(event, d))
-      .on("mouseout", () => this.hideTooltip());
+    const note = prompt("Note to include in the evidence package (optional):");
+    if (note === null) return;
+    if (note) {
+      params.set("note", note);
+    }
+    window.location.href = `${BASE_PATH}/api/evidence?${params}`;
+  }
 
-    // Axes
-    g.append("g")
-      .attr("class", "axis")
-      .attr("transform", `translate(0,${height})`)
-      .call(d3.axisBottom(xScale).tickFormat(d3.timeFormat("%H:%M")));
+  // Downloads the connections matching the current filters
+  exportConnections(format) {
+    const params = this.filterParams();
+    params.set("format", format);
+    window.location.href = `${BASE_PATH}/api/export?${params}`;
+  }
 
-    g.append("g").attr("class", "axis").call(d3.axisLeft(yScale));
+  // Downloads the graph as drawn, with the current filters and grouping, for Gephi, yEd, or Graphviz
+  exportGraph(format) {
+    const params = this.filterParams();
+    this.setGroupingParams(params);
+    if (this.edgeBy !== "protocol") {
+      params.set("edge_by", this.edgeBy);
+    }
+    if (this.needsAnalytics()) {
+      params.set("analytics", "true");
+    }
+    params.set("format", format);
+    window.location.href = `${BASE_PATH}/api/export/graph?${params}`;
+  }
 
-    // Brush for time range selection
-    this.brush = d3
-      .brushX()
-      .extent([
-        [0, 0],
-        [width, height],
-      ])
-      .on("brush end", (event) => this.onBrushChange(event, xScale));
+  isASGrouping() {
+    return this.subnetGroup === "asn" || this.subnetGroup === "as_org";
+  }
 
-    g.append("g").attr("class", "brush").call(this.brush);
+  // Groups the graph by subnet prefix length, or external hosts by autonomous system
+  setGroupingParams(params) {
+    if (this.isASGrouping()) {
+      params.set("group_by", this.subnetGroup);
+    } else if (this.subnetGroup) {
+      params.set("subnet_group", this.subnetGroup);
+    }
   }
 
   async getFilteredGraphData() {
-    // If we have active filters, we need to fetch filtered data from the API
-    if (this.filters.protocol !== "all" || this.filters.connState !== "all" || this.filters.timeRange) {
+    // If we have active filters or grouping, we need to fetch the graph from the API
+    const params = this.filterParams();
+    this.setGroupingParams(params);
+    if (this.edgeBy !== "protocol") {
+      params.set("edge_by", this.edgeBy);
+    }
+    if (this.needsAnalytics()) {
+      params.set("analytics", "true");
+    }
+    if (FEATURES.reverse_dns && !this.showHostnames) {
+      params.set("hostnames", "false");
+    }
+    if (params.size > 0) {
+      params.set("layout", this.layout);
       try {
-        const params = new URLSearchParams();
-        if (this.filters.protocol !== "all") {
-          params.set("protocol", this.filters.protocol);
-        }
-        if (this.filters.connState !== "all") {
-          params.set("conn_state", this.filters.connState);
-        }
-        if (this.filters.timeRange) {
-          params.set("start", Math.floor(this.filters.timeRange[0].getTime() / 1000));
-          params.set("end", Math.floor(this.filters.timeRange[1].getTime() / 1000));
-        }
-
-        const response = await fetch(`/api/nodes?${params}`);
+        const response = await fetch(`${BASE_PATH}/api/nodes?${params}`);
         const filteredGraph = await response.json();
         return filteredGraph;
       } catch (error) {
@@ -467,33 +1442,28 @@ class ZeekVisualizer {
     return this.data.graph;
   }
 
+  // Fetches the graph laid out by the server with another algorithm and redraws it
   async updateNetworkLayout(layout) {
     if (!this.simulation) return;
 
-    const { nodes } = await this.getFilteredGraphData();
-    const width = document.getElementById("network-graph").clientWidth;
-    const height = document.getElementById("network-graph").clientHeight;
-
-    if (layout === "circular") {
-      const radius = Math.min(width, height) / 3;
-      const angleStep = (2 * Math.PI) / nodes.length;
-
-      nodes.forEach((node, i) => {
-        node.fx = width / 2 + radius * Math.cos(i * angleStep);
-        node.fy = height / 2 + radius * Math.sin(i * angleStep);
-      });
-    } else {
-      // Remove fixed positions for force-directed layout
-      nodes.forEach((node) => {
-        node.fx = null;
-        node.fy = null;
-      });
+    this.layout = layout;
+    try {
+      const response = await fetch(`${BASE_PATH}/api/nodes?layout=${layout}`);
+      this.data.graph = await response.json();
+    } catch (error) {
+      console.error("Failed to load the graph layout:", error);
+      return;
     }
-
-    this.simulation.alpha(1).restart();
+    await this.updateNetworkVisualization();
+    this.fitNetworkView();
   }
 
   onBrushChange(event, xScale) {
+    // A click without dragging shows the connections of the bucket under the pointer
+    if (!event.selection && event.type === "end" && event.sourceEvent) {
+      const [x] = d3.pointer(event.sourceEvent, this.svg.timeline.select(".brush").node());
+      this.showTimelineBucket(Math.floor(xScale.invert(x).getTime() / 1000));
+    }
     if (!event.selection) {
       this.filters.timeRange = null;
       document.getElementById("timeline-selection").textContent = "Select a time range to filter connections";
@@ -515,16 +1485,21 @@ class ZeekVisualizer {
     return d3
       .drag()
       .on("start", (event, d) => {
-        if (!event.active) this.simulation.alphaTarget(0.3).restart();
+        if (!event.active && !this.staticLayout) this.simulation.alphaTarget(0.3).restart();
         d.fx = d.x;
         d.fy = d.y;
       })
       .on("drag", (event, d) => {
         d.fx = event.x;
         d.fy = event.y;
+        if (this.staticLayout) {
+          d.x = d.fx;
+          d.y = d.fy;
+          this.renderNetwork();
+        }
       })
       .on("end", (event, d) => {
-        if (!event.active) this.simulation.alphaTarget(0);
+        if (!event.active && !this.staticLayout) this.simulation.alphaTarget(0);
         // Keep node fixed after dragging
         // d.fx = null;
         // d.fy = null;
@@ -535,11 +1510,6 @@ class ZeekVisualizer {
     const panel = document.getElementById("details-panel");
     const content = document.getElementById("details-content");
 
-    // Find connections for this node
-    const nodeConnections = this.data.connections.filter(
-      (conn) => conn.orig_host === node.id || conn.resp_host === node.id
-    );
-
     content.innerHTML = `
             <div class="detail-group">
                 <h4>Node Information</h4>
@@ -547,10 +1517,58 @@ class ZeekVisualizer {
                     <span class="detail-label">IP Address:</span>
                     <span class="detail-value">${node.label}</span>
                 </div>
+                ${
+                  node.hostname
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Hostname:</span>
+                    <span class="detail-value">${node.hostname}</span>
+                </div>`
+                    : ""
+                }
                 <div class="detail-item">
                     <span class="detail-label">Type:</span>
                     <span class="detail-value">${node.is_local ? "Local" : "External"}</span>
                 </div>
+                ${
+                  node.threat
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Threat Intel:</span>
+                    <span class="detail-value">${this.formatThreat(node.threat)}</span>
+                </div>`
+                    : ""
+                }
+                ${
+                  node.annotation
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Annotation:</span>
+                    <span class="detail-value">${this.formatAnnotation(node.annotation)}</span>
+                </div>`
+                    : ""
+                }
+                ${
+                  node.scan
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Scanning:</span>
+                    <span class="detail-value">${node.scan.vertical_scans} hosts on many ports, ${node.scan.horizontal_sweeps} ports across many hosts (up to ${node.scan.max_targets} targets)</span>
+                </div>`
+                    : ""
+                }
+                ${
+                  this.formatLocation(node)
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Location:</span>
+                    <span class="detail-value">${this.formatLocation(node)}</span>
+                </div>`
+                    : ""
+                }
+                ${
+                  node.members
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Hosts in Subnet:</span>
+                    <span class="detail-value">${node.members} (${node.internal_connections || 0} internal connections)</span>
+                </div>`
+                    : ""
+                }
                 <div class="detail-item">
                     <span class="detail-label">Total Connections:</span>
                     <span class="detail-value">${node.connections}</span>
@@ -559,22 +1577,541 @@ class ZeekVisualizer {
                     <span class="detail-label">Total Bytes:</span>
                     <span class="detail-value">${this.formatBytes(node.total_bytes)}</span>
                 </div>
+                <div class="detail-item">
+                    <span class="detail-label">Risk Score:</span>
+                    <span class="detail-value">${node.risk_score}</span>
+                </div>
+                ${
+                  node.metrics
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Centrality:</span>
+                    <span class="detail-value">${node.metrics.degree} peers, ${node.metrics.weighted_degree} connections, betweenness ${node.metrics.betweenness.toFixed(3)}</span>
+                </div>
+                <div class="detail-item">
+                    <span class="detail-label">Community:</span>
+                    <span class="detail-value">${node.metrics.community}</span>
+                </div>`
+                    : ""
+                }
+                <div class="detail-item">
+                    <span class="detail-label">First Seen:</span>
+                    <span class="detail-value">${new Date(node.first_seen * 1000).toLocaleString()}</span>
+                </div>
+                <div class="detail-item">
+                    <span class="detail-label">Last Seen:</span>
+                    <span class="detail-value">${new Date(node.last_seen * 1000).toLocaleString()}</span>
+                </div>
+                ${node.members ? "" : '<button type="button" class="annotate-button" id="annotate-node">Annotate</button>'}
             </div>
             
+            <div id="host-profile"></div>
+        `;
+
+    document.getElementById("annotate-node")?.addEventListener("click", async () => {
+      const annotation = await this.editAnnotation(node.id, node.annotation);
+      if (annotation === undefined) return;
+      node.annotation = annotation || undefined;
+      d3.selectAll("#network-graph .node").classed("annotated", (d) => !!d.annotation);
+      this.showNodeDetails(node);
+    });
+
+    panel.classList.remove("hidden");
+    this.showHostProfile(node);
+  }
+
+  // Fills the node details with the host's profile under the current filters
+  async showHostProfile(node) {
+    const container = document.getElementById("host-profile");
+    const params = this.filterParams();
+    params.set("limit", HOST_PROFILE_LIMIT);
+    if (FEATURES.reverse_dns && !this.showHostnames) {
+      params.set("hostnames", "false");
+    }
+    try {
+      const response = await fetch(`${BASE_PATH}/api/hosts/${encodeURIComponent(node.id)}?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const profile = await response.json();
+      const item = (label, value) => `<div class="detail-item">
+                    <span class="detail-label">${label}:</span>
+                    <span class="detail-value">${value}</span>
+                </div>`;
+      const more = (shown, total) => (total > shown ? `<p>Top ${shown} of ${total}</p>` : "");
+      container.innerHTML = `
             <div class="detail-group">
                 <h4>Connection Summary</h4>
+                ${item("As Source", profile.originated)}
+                ${item("As Destination", profile.received)}
+                ${item("Bytes", `${this.formatBytes(profile.bytes_out)} sent / ${this.formatBytes(profile.bytes_in)} received`)}
+                ${item(
+                  "States",
+                  Object.entries(profile.conn_states)
+                    .sort((x, y) => y[1] - x[1])
+                    .map(([state, count]) => `${state} ${count}`)
+                    .join(", ")
+                )}
+            </div>
+            <div class="detail-group">
+                <h4>Peers</h4>
+                ${profile.peers
+                  .map((peer) =>
+                    item(
+                      this.escapeHTML(peer.host),
+                      `${peer.connections} (${peer.originated} out) • ${this.formatBytes(peer.bytes_out)} / ${this.formatBytes(
+                        peer.bytes_in
+                      )}${peer.services ? ` • ${this.escapeHTML(peer.services.join(", "))}` : ""}`
+                    )
+                  )
+                  .join("")}
+                ${more(profile.peers.length, profile.total_peers)}
+            </div>
+            <div class="detail-group">
+                <h4>Services</h4>
+                ${profile.services
+                  .map((service) =>
+                    item(
+                      `${service.protocol}/${service.port}${service.service ? ` ${this.escapeHTML(service.service)}` : ""}`,
+                      `${service.connections} (${service.originated} as client)`
+                    )
+                  )
+                  .join("")}
+                ${more(profile.services.length, profile.total_services)}
+            </div>
+        `;
+    } catch (error) {
+      console.error("Failed to load host profile:", error);
+    }
+  }
+
+  // Lists the connections behind an edge; clicking one loads its full record
+  async showEdgeDetails(edge) {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const source = edge.source.id || edge.source;
+    const target = edge.target.id || edge.target;
+
+    // Edges of host pairs also hold the connections the target originated
+    const directions = edge.reverse_count ? [[source, target], [target, source]] : [[source, target]];
+    const pages = directions.map(([orig, resp]) => {
+      const params = this.filterParams();
+      params.set("orig_host", orig);
+      params.set("resp_host", resp);
+      if (edge.protocol !== "mixed") {
+        params.set("protocol", edge.protocol);
+      }
+      if (this.edgeBy === "service" && edge.service) {
+        params.set("service", edge.service);
+      }
+      if (edge.port !== undefined) {
+        params.set("resp_port", edge.port);
+      }
+      params.set("limit", EDGE_CONNECTION_LIMIT);
+      return fetch(`${BASE_PATH}/api/connections?${params}`).then(async (response) => {
+        if (!response.ok) {
+          throw new Error(await response.text());
+        }
+        return response.json();
+      });
+    });
+    let label = edge.service && this.edgeBy !== "protocol" ? `${edge.protocol}/${edge.service}` : edge.protocol;
+    if (edge.port !== undefined) {
+      label += `:${edge.port}`;
+    }
+    try {
+      const results = await Promise.all(pages);
+      const connections = results
+        .flatMap((result) => result.connections)
+        .sort((x, y) => x.ts - y.ts)
+        .slice(0, EDGE_CONNECTION_LIMIT);
+      const total = results.reduce((sum, result) => sum + result.total, 0);
+      const page = { connections, total, truncated: total > connections.length };
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>${source} ${edge.reverse_count ? "↔" : "→"} ${target} (${label})</h4>
                 <div class="detail-item">
-                    <span class="detail-label">As Source:</span>
-                    <span class="detail-value">${nodeConnections.filter((c) => c.orig_host === node.id).length}</span>
-                </div>
-                <div class="detail-item">
-                    <span class="detail-label">As Destination:</span>
-                    <span class="detail-value">${nodeConnections.filter((c) => c.resp_host === node.id).length}</span>
+                    <span class="detail-label">Bytes:</span>
+                    <span class="detail-value">${this.formatBytes(edge.orig_bytes || 0)} → / ← ${this.formatBytes(edge.resp_bytes || 0)}</span>
                 </div>
+                ${
+                  edge.reverse_count
+                    ? `<div class="detail-item">
+                    <span class="detail-label">Originated by ${target}:</span>
+                    <span class="detail-value">${edge.reverse_count} of ${edge.count}</span>
+                </div>`
+                    : ""
+                }
+                ${page.connections
+                  .map(
+                    (conn) => `<div class="detail-item">
+                    <button type="button" class="connection-link" data-uid="${conn.uid}">${conn.uid}</button>
+                    <span class="detail-value">${new Date(conn.ts * 1000).toLocaleTimeString()} :${conn["id.resp_p"]} ${conn.conn_state}</span>
+                </div>`
+                  )
+                  .join("")}
+                ${page.truncated ? `<p>First ${EDGE_CONNECTION_LIMIT} of ${page.total} connections</p>` : ""}
             </div>
+            <div id="connection-detail"></div>
         `;
+      content.querySelectorAll(".connection-link").forEach((button) => {
+        button.addEventListener("click", () => this.showConnectionDetail(button.dataset.uid));
+      });
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to load edge connections:", error);
+    }
+  }
 
-    panel.classList.remove("hidden");
+  // showTimelineBucket lists what happened in the timeline bucket containing the timestamp:
+  // its totals, protocols, busiest hosts, and first connections
+  async showTimelineBucket(timestamp) {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const params = new URLSearchParams({ bucket: this.data.timeline.bucket_size, limit: EDGE_CONNECTION_LIMIT });
+    try {
+      const response = await fetch(`${BASE_PATH}/api/timeline/${timestamp}?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const bucket = await response.json();
+      if (bucket.count === 0) {
+        return;
+      }
+      const item = (label, value) => `<div class="detail-item">
+                    <span class="detail-label">${label}:</span>
+                    <span class="detail-value">${value}</span>
+                </div>`;
+      const counts = (values) =>
+        Object.entries(values)
+          .sort((x, y) => y[1] - x[1])
+          .map(([key, count]) => `${this.escapeHTML(key)} (${count})`)
+          .join(", ");
+      const hosts = (list) => list.map((host) => `${host.host} (${host.connections})`).join(", ");
+      const format = d3.timeFormat("%H:%M:%S");
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>${format(new Date(bucket.start * 1000))} - ${format(new Date(bucket.end * 1000))}</h4>
+                ${item("Connections", bucket.count)}
+                ${item("Bytes", this.formatBytes(bucket.bytes))}
+                ${item("Protocols", counts(bucket.protocols))}
+                ${item("Services", counts(bucket.services))}
+                ${item("States", counts(bucket.conn_states))}
+                ${item("Top Sources", hosts(bucket.top_sources))}
+                ${item("Top Destinations", hosts(bucket.top_destinations))}
+                ${bucket.connections
+                  .map(
+                    (conn) => `<div class="detail-item">
+                    <button type="button" class="connection-link" data-uid="${conn.uid}">${conn.uid}</button>
+                    <span class="detail-value">${new Date(conn.ts * 1000).toLocaleTimeString()} ${conn["id.orig_h"]} → ${conn["id.resp_h"]}:${conn["id.resp_p"]} ${conn.conn_state}</span>
+                </div>`
+                  )
+                  .join("")}
+                ${bucket.truncated ? `<p>First ${EDGE_CONNECTION_LIMIT} of ${bucket.total} connections</p>` : ""}
+            </div>
+            <div id="connection-detail"></div>
+        `;
+      content.querySelectorAll(".connection-link").forEach((button) => {
+        button.addEventListener("click", () => this.showConnectionDetail(button.dataset.uid));
+      });
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to load timeline bucket:", error);
+    }
+  }
+
+  async showConnectionDetail(uid) {
+    const container = document.getElementById("connection-detail");
+    try {
+      const response = await fetch(`${BASE_PATH}/api/connections/${encodeURIComponent(uid)}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const detail = await response.json();
+      const conn = detail.connection;
+      const item = (label, value) => `<div class="detail-item">
+                    <span class="detail-label">${label}:</span>
+                    <span class="detail-value">${value}</span>
+                </div>`;
+      container.innerHTML = `
+            <div class="detail-group">
+                <h4>Connection ${conn.uid}</h4>
+                ${item("Time", new Date(conn.ts * 1000).toLocaleString())}
+                ${item("Originator", `${conn["id.orig_h"]}:${conn["id.orig_p"]}`)}
+                ${item("Responder", `${conn["id.resp_h"]}:${conn["id.resp_p"]}`)}
+                ${item("Protocol", conn.service ? `${conn.proto}/${conn.service}` : conn.proto)}
+                ${conn.service_guess ? item("Likely Service", `${this.escapeHTML(conn.service_guess)} <span class="text-muted">(guessed from the port)</span>`) : ""}
+                ${item("Duration", `${(conn.duration || 0).toFixed(3)}s`)}
+                ${item("Bytes", `${this.formatBytes(conn.orig_bytes || 0)} → / ← ${this.formatBytes(conn.resp_bytes || 0)}`)}
+                ${item("Packets", `${conn.orig_pkts || 0} → / ← ${conn.resp_pkts || 0}`)}
+                ${item("State", detail.conn_state_description)}
+                ${detail.history_flags?.length ? item("Flags", this.escapeHTML(detail.history_flags.join(", ").replaceAll("_", " "))) : ""}
+                ${detail.threat ? item("Threat Intel", this.formatThreat(detail.threat)) : ""}
+                ${detail.annotations?.connection ? item("Annotation", this.formatAnnotation(detail.annotations.connection)) : ""}
+                ${detail.annotations?.orig_h ? item("Originator Notes", this.formatAnnotation(detail.annotations.orig_h)) : ""}
+                ${detail.annotations?.resp_h ? item("Responder Notes", this.formatAnnotation(detail.annotations.resp_h)) : ""}
+                <button type="button" class="annotate-button" id="annotate-connection">Annotate</button>
+            </div>
+            ${
+              detail.history.length > 0
+                ? `<div class="detail-group">
+                <h4>History ${conn.history}</h4>
+                ${detail.history
+                  .map((event) => item(event.code, event.at_least ? `${event.description} (${event.at_least}+)` : event.description))
+                  .join("")}
+            </div>`
+                : ""
+            }
+        `;
+      document.getElementById("annotate-connection").addEventListener("click", async () => {
+        const annotation = await this.editAnnotation(conn.uid, detail.annotations?.connection);
+        if (annotation !== undefined) {
+          this.showConnectionDetail(uid);
+        }
+      });
+    } catch (error) {
+      console.error("Failed to load connection:", error);
+      container.textContent = "Failed to load connection: " + error.message;
+    }
+  }
+
+  // Lists the connection tuples that recur most regularly under the current filters
+  async showBeacons() {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const params = this.filterParams();
+    params.set("min_score", BEACON_MIN_SCORE);
+    try {
+      const response = await fetch(`${BASE_PATH}/api/analysis/beacons?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>Beacons (${result.total} of ${result.candidates} candidates)</h4>
+                ${
+                  result.beacons.length === 0
+                    ? "<p>No regularly recurring connections found.</p>"
+                    : result.beacons
+                        .map(
+                          (b) => `<div class="detail-item">
+                    <span class="detail-label">${b.src} → ${b.dst}:${b.port}/${b.proto}${b.threat ? " ⚠" : ""}</span>
+                    <span class="detail-value">${b.score.toFixed(2)} · every ${b.interval}s ±${b.jitter}s · ${b.connections}×</span>
+                </div>`
+                        )
+                        .join("")
+                }
+            </div>
+        `;
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to find beacons:", error);
+      alert("Failed to find beacons: " + error.message);
+    }
+  }
+
+  // Lists the port scans and host sweeps under the current filters
+  async showScans() {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    try {
+      const response = await fetch(`${BASE_PATH}/api/analysis/scans?${this.filterParams()}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>Scans (${result.total})</h4>
+                ${
+                  result.scans.length === 0
+                    ? "<p>No port scans or host sweeps found.</p>"
+                    : result.scans
+                        .map(
+                          (s) => `<div class="detail-item">
+                    <span class="detail-label">${s.src} → ${s.type === "vertical" ? s.dst : `*:${s.port}/${s.proto}`}${s.threat ? " ⚠" : ""}</span>
+                    <span class="detail-value">${s.targets} ${s.type === "vertical" ? "ports" : "hosts"} in ${Math.round(s.window_end - s.window_start)}s · ${Math.round(s.failed_share * 100)}% failed</span>
+                </div>`
+                        )
+                        .join("")
+                }
+            </div>
+        `;
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to find scans:", error);
+      alert("Failed to find scans: " + error.message);
+    }
+  }
+
+  // Lists the internal hosts sending external hosts far more than they receive, under the current filters
+  async showExfil() {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const params = this.filterParams();
+    params.set("humanize", "true");
+    try {
+      const response = await fetch(`${BASE_PATH}/api/analysis/exfil?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>Uploads (${result.total} of ${result.candidates} internal hosts)</h4>
+                ${
+                  result.hosts.length === 0
+                    ? "<p>No hosts sent notably more than they received.</p>"
+                    : result.hosts
+                        .map(
+                          (h) => `<div class="detail-item">
+                    <span class="detail-label">${h.host} → ${h.top_destinations[0].host}${h.destinations > 1 ? ` +${h.destinations - 1}` : ""}${h.threat ? " ⚠" : ""}</span>
+                    <span class="detail-value">${h.outbound_human} out · ${h.inbound_human} in · ${h.ratio}×</span>
+                </div>`
+                        )
+                        .join("")
+                }
+            </div>
+        `;
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to find uploads:", error);
+      alert("Failed to find uploads: " + error.message);
+    }
+  }
+
+  // Lists the longest-lived and still-open connections under the current filters
+  async showLongConnections() {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const params = this.filterParams();
+    params.set("humanize", "true");
+    try {
+      const response = await fetch(`${BASE_PATH}/api/analysis/long-connections?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>Long Connections (${result.total})</h4>
+                ${
+                  result.connections.length === 0
+                    ? "<p>No long-lived or open connections found.</p>"
+                    : result.connections
+                        .map(
+                          (c) => `<div class="detail-item">
+                    <span class="detail-label">${c["id.orig_h"]} → ${c["id.resp_h"]}:${c["id.resp_p"]}/${c.proto}${c.threat ? " ⚠" : ""}</span>
+                    <span class="detail-value">${c.duration_human || "-"}${c.reasons.includes("open") ? " · open" : ""}</span>
+                </div>`
+                        )
+                        .join("")
+                }
+            </div>
+        `;
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to load long connections:", error);
+      alert("Failed to load long connections: " + error.message);
+    }
+  }
+
+  // Lists rare TLS fingerprints and server name mismatches, then the fingerprint clusters, under
+  // the current filters; a finding links to its first connection and its graph edge
+  async showTLSFingerprints() {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const params = this.filterParams();
+    try {
+      const response = await fetch(`${BASE_PATH}/api/analysis/tls?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>TLS Findings (${result.total_findings} in ${result.sessions} sessions)</h4>
+                ${
+                  result.sessions === 0
+                    ? "<p>Upload an ssl.log to attach TLS sessions to this dataset.</p>"
+                    : result.findings.length === 0
+                      ? "<p>No rare fingerprints or server name mismatches found.</p>"
+                      : result.findings
+                          .map(
+                            (f, i) => `<div class="detail-item">
+                    <span class="detail-label">${f.source} → ${f.target}${f.threat ? " ⚠" : ""}</span>
+                    <span class="detail-value">${this.escapeHTML(f.reason)} · ${f.sessions} session(s)</span>
+                    <button type="button" class="connection-link" data-uid="${f.uids[0]}">${f.uids[0]}</button>
+                    <button type="button" class="edge-link" data-finding="${i}">Edge</button>
+                </div>`
+                          )
+                          .join("")
+                }
+            </div>
+            <div class="detail-group">
+                <h4>${result.fingerprint.toUpperCase()} Clusters (${result.total_clusters} among ${result.clients} clients)</h4>
+                ${result.clusters
+                  .map(
+                    (c) => `<div class="detail-item">
+                    <span class="detail-label">${this.escapeHTML(c.fingerprint)}${c.rare ? " (rare)" : ""}</span>
+                    <span class="detail-value">${c.clients} client(s) · ${c.sessions} sessions${c.server_names.length > 0 ? ` · ${this.escapeHTML(c.server_names[0].name)}` : ""}</span>
+                </div>`
+                  )
+                  .join("")}
+            </div>
+            <div id="connection-detail"></div>
+        `;
+      content.querySelectorAll(".connection-link").forEach((button) => {
+        button.addEventListener("click", () => this.showConnectionDetail(button.dataset.uid));
+      });
+      content.querySelectorAll(".edge-link").forEach((button) => {
+        const finding = result.findings[button.dataset.finding];
+        button.addEventListener("click", () =>
+          this.showEdgeDetails({ source: finding.source, target: finding.target, protocol: "mixed" })
+        );
+      });
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to load TLS fingerprints:", error);
+      alert("Failed to load TLS fingerprints: " + error.message);
+    }
+  }
+
+  // Lists the hosts of the current dataset that none of the datasets uploaded before it contain
+  async showNewHosts() {
+    const panel = document.getElementById("details-panel");
+    const content = document.getElementById("details-content");
+    const params = this.filterParams();
+    try {
+      const response = await fetch(`${BASE_PATH}/api/analysis/new-hosts?${params}`);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const result = await response.json();
+      content.innerHTML = `
+            <div class="detail-group">
+                <h4>New Hosts (${result.hosts.total} since ${result.baseline.length} earlier dataset(s))</h4>
+                ${result.skipped.length > 0 ? `<p>${result.skipped.length} evicted dataset(s) not compared</p>` : ""}
+                ${
+                  result.hosts.entries.length === 0
+                    ? "<p>Every host was seen before.</p>"
+                    : result.hosts.entries
+                        .map(
+                          (h) => `<div class="detail-item">
+                    <span class="detail-label">${h.host}${h.local ? " (local)" : ""}${h.threat ? " ⚠" : ""}</span>
+                    <span class="detail-value">${new Date(h.first_seen * 1000).toLocaleString()} · ${h.connections} conns · ${h.peers} peers</span>
+                </div>`
+                        )
+                        .join("")
+                }
+                <p>${result.pairs.total} new host pairs</p>
+            </div>
+        `;
+      panel.classList.remove("hidden");
+    } catch (error) {
+      console.error("Failed to find new hosts:", error);
+      alert("Failed to find new hosts: " + error.message);
+    }
   }
 
   hideDetails() {
@@ -599,9 +2136,22 @@ class ZeekVisualizer {
     tooltip
       .html(
         `
-            <strong>${data.label}</strong><br/>
+            <strong>${data.hostname || data.label}</strong><br/>
+            ${data.hostname ? `${data.label}<br/>` : ""}
             Connections: ${data.connections}<br/>
             Bytes: ${this.formatBytes(data.total_bytes)}
+            ${this.formatLocation(data) ? `<br/>${this.formatLocation(data)}` : ""}
+            ${data.threat ? `<br/>⚠ ${this.formatThreat(data.threat)}` : ""}
+            ${
+              this.data.notices.hosts.has(data.id)
+                ? `<br/>⚑ Alerts: ${this.data.notices.hosts.get(data.id).notices} notices, ${this.data.notices.hosts.get(data.id).weirds} weirds`
+                : ""
+            }
+            ${
+              data.metrics
+                ? `<br/>Degree: ${data.metrics.degree} • Betweenness: ${data.metrics.betweenness.toFixed(3)} • Community: ${data.metrics.community}`
+                : ""
+            }
         `
       )
       .style("left", event.pageX + 10 + "px")
@@ -627,14 +2177,35 @@ class ZeekVisualizer {
       .html(
         `
             <strong>${d3.timeFormat("%H:%M:%S")(time)}</strong><br/>
+            ${data.key !== undefined ? `${data.key}<br/>` : ""}
             Connections: ${data.count}<br/>
             Bytes: ${this.formatBytes(data.bytes)}
+            ${data.anomaly_score ? `<br/>Anomaly score: ${data.anomaly_score}` : ""}
+            ${this.formatBucketAlerts(data.timestamp)}
         `
       )
       .style("left", event.pageX + 10 + "px")
       .style("top", event.pageY - 10 + "px");
   }
 
+  // Names of the notices and weirds of a timeline bucket, most frequent first
+  formatBucketAlerts(timestamp) {
+    const alerts = (this.bucketAlerts && this.bucketAlerts.get(timestamp)) || [];
+    if (alerts.length === 0) return "";
+
+    const names = d3
+      .rollups(
+        alerts,
+        (v) => v.length,
+        (a) => a.name
+      )
+      .sort((a, b) => b[1] - a[1]);
+    return `<br/>⚑ ${alerts.length} alert(s): ${names
+      .slice(0, 5)
+      .map(([name, count]) => this.escapeHTML(`${name} ×${count}`))
+      .join(", ")}${names.length > 5 ? ", ..." : ""}`;
+  }
+
   hideTooltip() {
     d3.selectAll(".tooltip").remove();
   }
@@ -650,10 +2221,39 @@ class ZeekVisualizer {
             ${Object.keys(stats.protocols).join(", ")} protocols
         `;
 
-    // Update connection state dropdown with available states
+    // Update dropdowns with the values present in the current file
+    this.updateProtocolDropdown(this.data.protocols || []);
     this.updateConnectionStateDropdown(stats.available_conn_states || []);
   }
 
+  updateProtocolDropdown(availableProtocols) {
+    const dropdown = document.getElementById("protocol-filter");
+    const currentValue = dropdown.value;
+
+    // Clear existing options except "All Protocols"
+    dropdown.innerHTML = '<option value="all">All Protocols</option>';
+
+    // Add options for protocols present in the data
+    availableProtocols.forEach((protocol) => {
+      const option = document.createElement("option");
+      option.value = protocol.value;
+      option.textContent = `${protocol.value.toUpperCase()} (${protocol.count})`;
+      dropdown.appendChild(option);
+    });
+
+    // Restore previous selection if it still exists
+    if (currentValue !== "all") {
+      const optionExists = availableProtocols.some((protocol) => protocol.value === currentValue);
+      if (optionExists) {
+        dropdown.value = currentValue;
+      } else {
+        // Reset filter if previously selected protocol no longer exists
+        this.filters.protocol = "all";
+        dropdown.value = "all";
+      }
+    }
+  }
+
   updateConnectionStateDropdown(availableStates) {
     const dropdown = document.getElementById("conn-state-filter");
     const currentValue = dropdown.value;
@@ -693,10 +2293,21 @@ class ZeekVisualizer {
     this.filters.protocol = "all";
     this.filters.connState = "all";
     this.filters.timeRange = null;
+    const hadNoiseFilter = this.filters.excludeNoise;
+    this.filters.excludeNoise = false;
+    this.filters.country = "";
+    this.filters.threat = false;
+    this.filters.query = "";
+    this.filters.other = {};
+    this.clearView();
 
     // Reset UI
     document.getElementById("protocol-filter").value = "all";
     document.getElementById("conn-state-filter").value = "all";
+    document.getElementById("exclude-noise").checked = false;
+    document.getElementById("country-filter").value = "";
+    document.getElementById("threat-only").checked = false;
+    document.getElementById("query-filter").value = "";
     document.getElementById("timeline-selection").textContent = "Select a time range to filter connections";
 
     // Clear brush
@@ -705,6 +2316,9 @@ class ZeekVisualizer {
     }
 
     // Update visualizations
+    if (hadNoiseFilter) {
+      this.reloadStats();
+    }
     this.updateVisualizations();
   }
 
@@ -746,22 +2360,25 @@ class ZeekVisualizer {
 
   async updateFileSelector() {
     try {
-      const response = await fetch("/api/files");
+      const response = await fetch(BASE_PATH + "/api/files");
       const data = await response.json();
 
       const selector = document.getElementById("file-selector");
       const deleteButton = document.getElementById("delete-file");
+      const renameButton = document.getElementById("rename-file");
 
       // Clear existing options
       selector.innerHTML = "";
+      this.fileEntries = new Map((data.files || []).map((file) => [file.id, file]));
 
       if (data.files && data.files.length > 0) {
         data.files.forEach((file) => {
+          // Renamed datasets show their name and case number, with the description on hover
           const option = document.createElement("option");
           option.value = file.id;
-          option.textContent = `${file.filename} (${this.formatBytes(file.size)}, ${
-            file.connection_count
-          } connections)`;
+          const name = (file.case_number ? `[${file.case_number}] ` : "") + (file.name || file.filename);
+          option.textContent = `${name} (${this.formatBytes(file.size)}, ${file.connection_count} connections)`;
+          option.title = [file.name ? file.filename : "", file.description || ""].filter(Boolean).join(" - ");
           if (file.is_current) {
             option.selected = true;
           }
@@ -770,9 +2387,11 @@ class ZeekVisualizer {
 
         // Enable delete button only if there are multiple files
         deleteButton.disabled = data.files.length <= 1;
+        renameButton.disabled = false;
       } else {
         selector.innerHTML = '<option value="">No files loaded</option>';
         deleteButton.disabled = true;
+        renameButton.disabled = true;
       }
     } catch (error) {
       console.error("Failed to update file selector:", error);
@@ -783,13 +2402,7 @@ class ZeekVisualizer {
     this.showLoading(true);
 
     try {
-      const response = await fetch("/api/switch", {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({ file_id: fileId }),
-      });
+      const response = await fetch(`${BASE_PATH}/api/files/${encodeURIComponent(fileId)}/select`, { method: "POST" });
 
       const result = await response.json();
 
@@ -808,6 +2421,50 @@ class ZeekVisualizer {
     }
   }
 
+  // renameCurrentFile asks for a new name, case number, and description of the selected
+  // dataset; cancelling any prompt leaves the dataset unchanged.
+  async renameCurrentFile() {
+    const fileId = document.getElementById("file-selector").value;
+    const file = this.fileEntries.get(fileId);
+    if (!file) {
+      return;
+    }
+
+    const name = prompt("Dataset name:", file.name || file.filename);
+    if (name === null) {
+      return;
+    }
+    const caseNumber = prompt("Case number (optional):", file.case_number || "");
+    if (caseNumber === null) {
+      return;
+    }
+    const description = prompt("Description (optional):", file.description || "");
+    if (description === null) {
+      return;
+    }
+
+    try {
+      const response = await fetch(BASE_PATH + "/api/files/" + encodeURIComponent(fileId), {
+        method: "PATCH",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({
+          name: name.trim() === file.filename ? "" : name,
+          case_number: caseNumber,
+          description: description,
+        }),
+      });
+      if (!response.ok) {
+        throw new Error((await response.text()).trim() || `status ${response.status}`);
+      }
+      await this.updateFileSelector();
+    } catch (error) {
+      console.error("Failed to rename file:", error);
+      alert("Failed to rename file: " + error.message);
+    }
+  }
+
   async deleteCurrentFile() {
     const selector = document.getElementById("file-selector");
     const currentFileId = selector.value;
@@ -826,13 +2483,7 @@ class ZeekVisualizer {
     this.showLoading(true);
 
     try {
-      const response = await fetch("/api/delete", {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({ file_id: currentFileId }),
-      });
+      const response = await fetch(`${BASE_PATH}/api/files/${encodeURIComponent(currentFileId)}`, { method: "DELETE" });
 
       const result = await response.json();
 
@@ -861,7 +2512,7 @@ class ZeekVisualizer {
 
   async checkExistingFiles() {
     try {
-      const response = await fetch("/api/files");
+      const response = await fetch(BASE_PATH + "/api/files");
       const data = await response.json();
 
       if (data.files && data.files.length > 0) {
@@ -896,11 +2547,15 @@ class ZeekVisualizer {
                     <div class="file-name">${file.filename}</div>
                     <div class="file-details">
                         ${this.formatBytes(file.size)} • ${file.connection_count} connections • 
-                        Uploaded: ${uploadDate}
+                        Uploaded: ${uploadDate} •
+                        ${file.loaded === false ? "Unloaded, reloads when selected" : `${this.formatBytes(file.memory_bytes)} in memory`}
+                        ${file.skipped_lines ? ` • ${file.skipped_lines} lines skipped` : ""}
                         ${file.is_current ? " (Currently Active)" : ""}
                     </div>
                 </div>
                 <div class="file-actions">
+                    ${FEATURES.raw_downloads && file.has_raw ? `<a class="file-download-link" href="${BASE_PATH}/api/files/${file.id}/raw">Download</a>` : ""}
+                    ${file.skipped_lines ? `<a class="file-download-link" href="${BASE_PATH}/api/files/${file.id}/parse-report" target="_blank">Parse report</a>` : ""}
                     <button class="file-select-btn ${file.is_current ? "current" : ""}" 
                             data-file-id="${file.id}">
                         ${file.is_current ? "Current" : "Select"}
@@ -924,13 +2579,7 @@ class ZeekVisualizer {
     this.showLoading(true);
 
     try {
-      const response = await fetch("/api/switch", {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({ file_id: fileId }),
-      });
+      const response = await fetch(`${BASE_PATH}/api/files/${encodeURIComponent(fileId)}/select`, { method: "POST" });
 
       const result = await response.json();
 
@@ -958,7 +2607,7 @@ class ZeekVisualizer {
   async continueWithExistingFiles() {
     // Find the current file and proceed to visualization
     try {
-      const response = await fetch("/api/files");
+      const response = await fetch(BASE_PATH + "/api/files");
       const data = await response.json();
 
       if (data.current_file && data.files.length > 0) {
@@ -987,7 +2636,7 @@ class ZeekVisualizer {
   }
 
   formatNodeLabel(label) {
-    // Truncate long IPs for display
+    // Truncate long IPs and hostnames for display
     return label.length > 15 ? label.substring(0, 12) + "..." : label;
   }
 }
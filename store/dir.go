@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	metadataExt = ".json" // Extension of dataset metadata files
+	contentExt  = ".log"  // Extension of dataset content files
+	dirMode     = 0o750   // Permissions of the store directory
+	fileMode    = 0o600   // Permissions of stored files
+)
+
+var errInvalidID = errors.New("invalid dataset ID")
+
+// DirStore keeps datasets as files in a directory, which may be a network mount shared by
+// several instances. Writes go to temporary files that are renamed into place, so readers
+// never observe partially written datasets.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore creates a store in dir, creating the directory if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("creating store directory: %w", err)
+	}
+
+	return &DirStore{dir: dir}, nil
+}
+
+// Put creates or replaces a dataset. Content is written before metadata, so a listed
+// dataset always has its content.
+func (s *DirStore) Put(_ context.Context, meta Metadata, content []byte) error {
+	err := validateID(meta.ID)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	err = s.writeFile(meta.ID+contentExt, content)
+	if err != nil {
+		return err
+	}
+
+	return s.writeFile(meta.ID+metadataExt, encoded)
+}
+
+// Get returns a dataset's metadata and content.
+func (s *DirStore) Get(_ context.Context, id string) (Metadata, []byte, error) {
+	err := validateID(id)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+
+	meta, err := s.readMetadata(id + metadataExt)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(s.dir, id+contentExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return Metadata{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("reading dataset %s: %w", id, err)
+	}
+
+	return meta, content, nil
+}
+
+// List returns the metadata of all datasets, ordered by ID.
+func (s *DirStore) List(_ context.Context) ([]Metadata, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading store directory: %w", err)
+	}
+
+	datasets := make([]Metadata, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), metadataExt) {
+			continue
+		}
+
+		meta, err := s.readMetadata(entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			continue // Deleted since the directory was read
+		}
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, meta)
+	}
+
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].ID < datasets[j].ID
+	})
+
+	return datasets, nil
+}
+
+// Delete removes a dataset. Metadata goes first, so the dataset disappears from listings
+// before its content is removed.
+func (s *DirStore) Delete(_ context.Context, id string) error {
+	err := validateID(id)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{id + metadataExt, id + contentExt} {
+		err = os.Remove(filepath.Join(s.dir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("deleting dataset %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Close implements Store; a directory holds no resources.
+func (s *DirStore) Close() error {
+	return nil
+}
+
+// readMetadata decodes a metadata file.
+func (s *DirStore) readMetadata(name string) (Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Metadata{}, ErrNotFound
+	}
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	var meta Metadata
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("decoding %s: %w", name, err)
+	}
+
+	return meta, nil
+}
+
+// writeFile atomically replaces a file in the store directory.
+func (s *DirStore) writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(fileMode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing %s: %w", name, closeErr)
+	}
+
+	err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	if err != nil {
+		return fmt.Errorf("renaming %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// validateID rejects IDs that could escape the store directory.
+func validateID(id string) error {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("%w: %q", errInvalidID, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the "pgx" database/sql driver
+)
+
+// PostgresStore keeps datasets in a PostgreSQL table, shared by every instance connected
+// to the same database.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore connects to the database at url and creates the datasets table if needed.
+func NewPostgresStore(ctx context.Context, url string) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS zeek_viz_datasets (
+		id         TEXT PRIMARY KEY,
+		metadata   JSONB NOT NULL,
+		content    BYTEA NOT NULL,
+		updated_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf("creating datasets table: %w", err)
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+// Put creates or replaces a dataset.
+func (s *PostgresStore) Put(ctx context.Context, meta Metadata, content []byte) error {
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO zeek_viz_datasets (id, metadata, content, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET metadata = EXCLUDED.metadata, content = EXCLUDED.content,
+			updated_at = EXCLUDED.updated_at`,
+		meta.ID, encoded, content, meta.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("storing dataset %s: %w", meta.ID, err)
+	}
+
+	return nil
+}
+
+// Get returns a dataset's metadata and content.
+func (s *PostgresStore) Get(ctx context.Context, id string) (Metadata, []byte, error) {
+	var encoded, content []byte
+	err := s.db.QueryRowContext(ctx, "SELECT metadata, content FROM zeek_viz_datasets WHERE id = $1", id).
+		Scan(&encoded, &content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Metadata{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("loading dataset %s: %w", id, err)
+	}
+
+	var meta Metadata
+	err = json.Unmarshal(encoded, &meta)
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("decoding metadata of %s: %w", id, err)
+	}
+
+	return meta, content, nil
+}
+
+// List returns the metadata of all datasets, ordered by ID.
+func (s *PostgresStore) List(ctx context.Context) ([]Metadata, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT metadata FROM zeek_viz_datasets ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("listing datasets: %w", err)
+	}
+	defer rows.Close()
+
+	var datasets []Metadata
+	for rows.Next() {
+		var encoded []byte
+		err = rows.Scan(&encoded)
+		if err != nil {
+			return nil, fmt.Errorf("reading dataset row: %w", err)
+		}
+
+		var meta Metadata
+		err = json.Unmarshal(encoded, &meta)
+		if err != nil {
+			return nil, fmt.Errorf("decoding metadata: %w", err)
+		}
+		datasets = append(datasets, meta)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("listing datasets: %w", err)
+	}
+
+	return datasets, nil
+}
+
+// Delete removes a dataset.
+func (s *PostgresStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM zeek_viz_datasets WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("deleting dataset %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// Close closes the database connection pool.
+func (s *PostgresStore) Close() error {
+	err := s.db.Close()
+	if err != nil {
+		return fmt.Errorf("closing database: %w", err)
+	}
+
+	return nil
+}
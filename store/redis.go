@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "zeek-viz:" // Namespace of all keys written to Redis
+
+// Cache holds short-lived values shared by every instance, such as query results and the
+// selected dataset.
+type Cache interface {
+	// Get returns the value stored under key, or false when it is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key. A zero ttl keeps it until overwritten.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Close releases the cache's resources.
+	Close() error
+}
+
+// RedisCache is a Cache backed by a Redis server.
+type RedisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache connects to the Redis server at url (redis:// or rediss://).
+func NewRedisCache(ctx context.Context, url string) (*RedisCache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+
+		return nil, fmt.Errorf("connecting to Redis: %w", err)
+	}
+
+	return &RedisCache{client: client}, nil
+}
+
+// Get returns the value stored under key.
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s: %w", key, err)
+	}
+
+	return value, true, nil
+}
+
+// Set stores value under key.
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := c.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Close disconnects from the server.
+func (c *RedisCache) Close() error {
+	err := c.client.Close()
+	if err != nil {
+		return fmt.Errorf("closing Redis client: %w", err)
+	}
+
+	return nil
+}
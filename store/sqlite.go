@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite" // Registers the pure-Go "sqlite" database/sql driver
+)
+
+// SQLiteFilename is the database file a data directory holds.
+const SQLiteFilename = "zeek-viz.db"
+
+// SQLiteStore keeps datasets in a local SQLite database file. Metadata columns are indexed,
+// so datasets can be listed without reading their content.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the database at path, creating it and its directory if needed.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	err := os.MkdirAll(filepath.Dir(path), dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("creating database directory: %w", err)
+	}
+
+	// WAL lets listings run while a dataset is written; busy_timeout waits out other writers
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS datasets (
+		id          TEXT PRIMARY KEY,
+		filename    TEXT NOT NULL,
+		upload_time INTEGER NOT NULL,
+		dataset     TEXT NOT NULL,
+		metadata    TEXT NOT NULL,
+		content     BLOB NOT NULL,
+		updated_at  INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS datasets_upload_time ON datasets (upload_time);
+	CREATE INDEX IF NOT EXISTS datasets_dataset ON datasets (dataset)`)
+	if err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf("creating datasets table: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Put creates or replaces a dataset.
+func (s *SQLiteStore) Put(ctx context.Context, meta Metadata, content []byte) error {
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO datasets (id, filename, upload_time, dataset, metadata, content, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET filename = excluded.filename, upload_time = excluded.upload_time,
+			dataset = excluded.dataset, metadata = excluded.metadata, content = excluded.content,
+			updated_at = excluded.updated_at`,
+		meta.ID, meta.Filename, meta.UploadTime, meta.Dataset, string(encoded), content, meta.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("storing dataset %s: %w", meta.ID, err)
+	}
+
+	return nil
+}
+
+// Get returns a dataset's metadata and content.
+func (s *SQLiteStore) Get(ctx context.Context, id string) (Metadata, []byte, error) {
+	var encoded string
+	var content []byte
+	err := s.db.QueryRowContext(ctx, "SELECT metadata, content FROM datasets WHERE id = ?", id).
+		Scan(&encoded, &content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Metadata{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("loading dataset %s: %w", id, err)
+	}
+
+	var meta Metadata
+	err = json.Unmarshal([]byte(encoded), &meta)
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("decoding metadata of %s: %w", id, err)
+	}
+
+	return meta, content, nil
+}
+
+// List returns the metadata of all datasets, ordered by ID.
+func (s *SQLiteStore) List(ctx context.Context) ([]Metadata, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT metadata FROM datasets ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("listing datasets: %w", err)
+	}
+	defer rows.Close()
+
+	var datasets []Metadata
+	for rows.Next() {
+		var encoded string
+		err = rows.Scan(&encoded)
+		if err != nil {
+			return nil, fmt.Errorf("reading dataset row: %w", err)
+		}
+
+		var meta Metadata
+		err = json.Unmarshal([]byte(encoded), &meta)
+		if err != nil {
+			return nil, fmt.Errorf("decoding metadata: %w", err)
+		}
+		datasets = append(datasets, meta)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("listing datasets: %w", err)
+	}
+
+	return datasets, nil
+}
+
+// Delete removes a dataset.
+func (s *SQLiteStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM datasets WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting dataset %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	err := s.db.Close()
+	if err != nil {
+		return fmt.Errorf("closing database: %w", err)
+	}
+
+	return nil
+}
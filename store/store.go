@@ -0,0 +1,80 @@
+// Package store persists datasets outside the process, so several zeek-viz instances can
+// share the same files and datasets survive restarts.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when a dataset is not in the store.
+	ErrNotFound = errors.New("dataset not found")
+
+	errUnsupportedStore = errors.New("unsupported store URL")
+)
+
+// Metadata describes a stored dataset.
+type Metadata struct {
+	ID          string   `json:"id"`
+	Filename    string   `json:"filename"`
+	UploadTime  int64    `json:"upload_time"` //nolint:tagliatelle // API consistency
+	Size        int64    `json:"size"`
+	Tags        []string `json:"tags,omitempty"`
+	SHA256      string   `json:"sha256,omitempty"`
+	Dataset     string   `json:"dataset,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	CaseNumber  string   `json:"case_number,omitempty"` //nolint:tagliatelle // API consistency
+	ParseMode   string   `json:"parse_mode,omitempty"`  //nolint:tagliatelle // API consistency
+	StitchGap   float64  `json:"stitch_gap,omitempty"`  //nolint:tagliatelle // API consistency
+	Raw         bool     `json:"raw"`                   // Content is the original upload rather than serialized connections
+	UpdatedAt   int64    `json:"updated_at"`            //nolint:tagliatelle // API consistency
+}
+
+// Store persists datasets: their metadata and the log content they were parsed from.
+type Store interface {
+	// Put creates or replaces a dataset.
+	Put(ctx context.Context, meta Metadata, content []byte) error
+	// Get returns a dataset's metadata and content, or ErrNotFound.
+	Get(ctx context.Context, id string) (Metadata, []byte, error)
+	// List returns the metadata of all datasets.
+	List(ctx context.Context) ([]Metadata, error)
+	// Delete removes a dataset. Deleting a missing dataset is not an error.
+	Delete(ctx context.Context, id string) error
+	// Close releases the store's resources.
+	Close() error
+}
+
+// Open connects to the store at location: a postgres:// or postgresql:// URL for a shared
+// database, a sqlite:// URL or a path ending in .db or .sqlite for a local database file, or a
+// directory path (optionally file://) for a local or network-mounted directory.
+func Open(ctx context.Context, location string) (Store, error) {
+	switch {
+	case strings.HasPrefix(location, "postgres://"), strings.HasPrefix(location, "postgresql://"):
+		return NewPostgresStore(ctx, location)
+	case strings.HasPrefix(location, "sqlite://"):
+		return NewSQLiteStore(ctx, strings.TrimPrefix(location, "sqlite://"))
+	case strings.HasSuffix(location, ".db"), strings.HasSuffix(location, ".sqlite"):
+		return NewSQLiteStore(ctx, location)
+	case strings.HasPrefix(location, "file://"):
+		return NewDirStore(strings.TrimPrefix(location, "file://"))
+	case strings.Contains(location, "://"):
+		return nil, fmt.Errorf("%w: %s", errUnsupportedStore, location)
+	default:
+		return NewDirStore(location)
+	}
+}
+
+// Redact returns location with any password removed, for logging.
+func Redact(location string) string {
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.User == nil {
+		return location
+	}
+
+	return parsed.Redacted()
+}
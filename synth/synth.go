@@ -0,0 +1,308 @@
+// Package synth generates realistic synthetic Zeek conn.log data: workstation traffic
+// following a diurnal pattern, a port scan, and a periodic beacon. Output is
+// deterministic for a given configuration and seed, so generated files can be shared
+// in bug reports and regenerated instead of attached.
+package synth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/netip"
+	"sort"
+	"time"
+
+	"zeek-viz/models"
+)
+
+const (
+	uidLength       = 17              // Random characters after the "C" prefix of connection UIDs
+	resolverAddr    = "10.10.0.1"     // Internal DNS resolver
+	scannerAddr     = "203.0.113.66"  // External host scanning the network
+	beaconAddr      = "192.0.2.77"    // Command-and-control server contacted by the beacon
+	beaconPort      = 8443            // Port of the beacon server
+	beaconInterval  = 60.0            // Seconds between beacon check-ins
+	beaconJitter    = 2.0             // Maximum deviation of a check-in in seconds
+	scanDuration    = 2 * time.Minute // Length of the port scan
+	scanPorts       = 1024            // Ports probed per scanned host
+	closedPortShare = 0.3             // Share of scanned ports answering with a reset
+	scanHosts       = 5               // Internal hosts probed by the scan
+	externalServers = 60              // Distinct external servers contacted by workstations
+	peakHour        = 14.0            // Hour of day (UTC) with the most traffic
+	nightShare      = 0.1             // Share of peak traffic at the quietest hour
+	ephemeralBase   = 49152           // First ephemeral source port
+	ephemeralRange  = 16384           // Number of ephemeral source ports
+)
+
+// uidAlphabet holds the characters of connection UIDs.
+const uidAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+var (
+	errInvalidHosts    = errors.New("hosts must be between 1 and 65000")
+	errInvalidDuration = errors.New("duration must be positive")
+	errInvalidRate     = errors.New("rate must be positive")
+)
+
+// Config controls the generated traffic.
+type Config struct {
+	Hosts    int           // Internal workstations
+	Duration time.Duration // Covered time span
+	Start    time.Time     // Timestamp of the first connection
+	Rate     float64       // Connections per host and minute at peak hours
+	Seed     uint64        // Random seed; equal configurations produce equal output
+}
+
+// service is a kind of workstation traffic with its share of all connections.
+type service struct {
+	name     string
+	proto    string
+	port     int
+	weight   float64
+	origMean float64 // Mean bytes sent by the originator
+	respMean float64 // Mean bytes sent by the responder
+	duration float64 // Mean duration in seconds
+}
+
+// services returns the mix of workstation traffic.
+func services() []service {
+	return []service{
+		{"dns", "udp", 53, 0.45, 40, 120, 0.03},
+		{"ssl", "tcp", 443, 0.35, 2500, 40000, 8},
+		{"quic,ssl", "udp", 443, 0.1, 3000, 60000, 12},
+		{"http", "tcp", 80, 0.05, 600, 15000, 1.5},
+		{"ntp", "udp", 123, 0.03, 48, 48, 0.02},
+		{"ssh", "tcp", 22, 0.02, 4000, 6000, 120},
+	}
+}
+
+// generator holds the state of one generation run.
+type generator struct {
+	cfg         Config
+	rng         *rand.Rand
+	hosts       []string
+	servers     []string
+	connections []models.Connection
+}
+
+// Generate writes synthetic connections as Zeek JSON lines, ordered by timestamp, and
+// returns their number.
+func Generate(w io.Writer, cfg Config) (int, error) {
+	switch {
+	case cfg.Hosts <= 0 || cfg.Hosts > 65000:
+		return 0, errInvalidHosts
+	case cfg.Duration <= 0:
+		return 0, errInvalidDuration
+	case cfg.Rate <= 0:
+		return 0, errInvalidRate
+	}
+
+	gen := &generator{
+		cfg: cfg,
+		rng: rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x9e3779b97f4a7c15)), //nolint:gosec,mnd // Synthetic data; golden-ratio stream
+	}
+	gen.addressPlan()
+	gen.workstationTraffic()
+	gen.portScan()
+	gen.beacon()
+
+	sort.SliceStable(gen.connections, func(i, j int) bool {
+		return gen.connections[i].Timestamp < gen.connections[j].Timestamp
+	})
+
+	encoder := json.NewEncoder(w)
+	for i := range gen.connections {
+		err := encoder.Encode(&gen.connections[i])
+		if err != nil {
+			return i, fmt.Errorf("writing connection: %w", err)
+		}
+	}
+
+	return len(gen.connections), nil
+}
+
+// addressPlan numbers workstations from 10.10.1.1 and picks external servers from
+// documentation ranges.
+func (g *generator) addressPlan() {
+	next := netip.MustParseAddr("10.10.1.0")
+	for range g.cfg.Hosts {
+		next = next.Next()
+		if next.As4()[3] == 0 {
+			next = next.Next() // Skip network addresses
+		}
+		g.hosts = append(g.hosts, next.String())
+	}
+
+	for i := range externalServers {
+		if i%2 == 0 {
+			g.servers = append(g.servers, fmt.Sprintf("198.51.100.%d", i/2+1))
+		} else {
+			g.servers = append(g.servers, fmt.Sprintf("203.0.113.%d", i/2+1))
+		}
+	}
+}
+
+// diurnal returns the traffic level at a time, between nightShare and 1 at peakHour.
+func diurnal(ts time.Time) float64 {
+	hour := float64(ts.UTC().Hour()) + float64(ts.UTC().Minute())/60 //nolint:mnd // Minutes per hour
+	phase := 2 * math.Pi * (hour - peakHour) / 24                    //nolint:mnd // Hours per day
+
+	return nightShare + (1-nightShare)*(0.5+0.5*math.Cos(phase)) //nolint:mnd // Cosine scaled to [0, 1]
+}
+
+// workstationTraffic generates each host's connections minute by minute as a Poisson
+// process whose rate follows the time of day.
+func (g *generator) workstationTraffic() {
+	mix := services()
+	for minute := time.Duration(0); minute < g.cfg.Duration; minute += time.Minute {
+		window := min(time.Minute, g.cfg.Duration-minute)
+		start := g.cfg.Start.Add(minute)
+		mean := g.cfg.Rate * diurnal(start) * window.Minutes()
+
+		for _, host := range g.hosts {
+			for range g.poisson(mean) {
+				ts := start.Add(time.Duration(g.rng.Int64N(int64(window))))
+				g.connections = append(g.connections, g.workstationConnection(host, ts, pick(g.rng, mix)))
+			}
+		}
+	}
+}
+
+// workstationConnection generates one connection of a service from a workstation.
+func (g *generator) workstationConnection(host string, ts time.Time, svc service) models.Connection {
+	resp := g.servers[g.rng.IntN(len(g.servers))]
+	if svc.name == "dns" {
+		resp = resolverAddr
+	}
+
+	conn := g.connection(host, resp, ts, svc.proto, svc.port)
+	conn.Service = svc.name
+	conn.Duration = g.rng.ExpFloat64() * svc.duration
+	conn.OrigBytes = int(g.rng.ExpFloat64()*svc.origMean) + 1
+	conn.RespBytes = int(g.rng.ExpFloat64()*svc.respMean) + 1
+	conn.ConnState = "SF"
+	conn.LocalResp = resp == resolverAddr
+	conn.History = "ShADadFf"
+	if svc.proto == "udp" {
+		conn.History = "Dd"
+	}
+	fillPackets(&conn)
+
+	return conn
+}
+
+// portScan has an external host probe the low ports of a few workstations a third of the
+// way into the time span. Most probes go unanswered (S0), closed ports reset (REJ).
+func (g *generator) portScan() {
+	start := g.cfg.Start.Add(g.cfg.Duration / 3) //nolint:mnd // A third into the span
+	span := min(scanDuration, g.cfg.Duration-g.cfg.Duration/3)
+	targets := g.hosts[:min(scanHosts, len(g.hosts))]
+	probes := len(targets) * scanPorts
+
+	for i := range probes {
+		ts := start.Add(span * time.Duration(i) / time.Duration(probes))
+		conn := g.connection(scannerAddr, targets[i/scanPorts], ts, "tcp", i%scanPorts+1)
+		conn.LocalOrig = false
+		conn.LocalResp = true
+		conn.ConnState, conn.History, conn.OrigPackets, conn.OrigIPBytes = "S0", "S", 1, 44 //nolint:mnd // SYN packet size
+		if g.rng.Float64() < closedPortShare {
+			conn.ConnState, conn.History, conn.RespPackets, conn.RespIPBytes = "REJ", "Sr", 1, 40 //nolint:mnd // RST packet size
+		}
+		g.connections = append(g.connections, conn)
+	}
+}
+
+// beacon has the last workstation check in with a remote server at a fixed interval with
+// a little jitter and near-constant payload sizes, the signature of implant traffic.
+func (g *generator) beacon() {
+	host := g.hosts[len(g.hosts)-1]
+	for offset := g.rng.Float64() * beaconInterval; offset < g.cfg.Duration.Seconds(); offset += beaconInterval {
+		jitter := (g.rng.Float64()*2 - 1) * beaconJitter
+		ts := g.cfg.Start.Add(time.Duration((offset + jitter) * float64(time.Second)))
+		if ts.Before(g.cfg.Start) {
+			continue
+		}
+
+		conn := g.connection(host, beaconAddr, ts, "tcp", beaconPort)
+		conn.Service = "ssl"
+		conn.Duration = 0.3 + g.rng.Float64()*0.1 //nolint:mnd // Short check-in
+		conn.OrigBytes = 310 + g.rng.IntN(8)      //nolint:mnd // Near-constant request size
+		conn.RespBytes = 120 + g.rng.IntN(8)      //nolint:mnd // Near-constant response size
+		conn.ConnState = "SF"
+		conn.History = "ShADadFf"
+		fillPackets(&conn)
+		g.connections = append(g.connections, conn)
+	}
+}
+
+// connection returns a connection skeleton with a random UID and ephemeral source port.
+func (g *generator) connection(orig, resp string, ts time.Time, proto string, port int) models.Connection {
+	uid := make([]byte, 0, uidLength+1)
+	uid = append(uid, 'C')
+	for range uidLength {
+		uid = append(uid, uidAlphabet[g.rng.IntN(len(uidAlphabet))])
+	}
+
+	ipProto := 6 //nolint:mnd // TCP
+	if proto == "udp" {
+		ipProto = 17 //nolint:mnd // UDP
+	}
+
+	return models.Connection{
+		Timestamp:  float64(ts.UnixMicro()) / float64(time.Second/time.Microsecond),
+		UID:        string(uid),
+		OrigHost:   orig,
+		OrigPort:   ephemeralBase + g.rng.IntN(ephemeralRange),
+		RespHost:   resp,
+		RespPort:   port,
+		Protocol:   proto,
+		LocalOrig:  true,
+		IPProtocol: ipProto,
+	}
+}
+
+// fillPackets derives packet counts and IP-level byte counts from the payload sizes.
+func fillPackets(conn *models.Connection) {
+	const mss, header = 1400, 40 // Payload per packet, IP and transport header bytes
+
+	conn.OrigPackets = conn.OrigBytes/mss + 1
+	conn.RespPackets = conn.RespBytes/mss + 1
+	conn.OrigIPBytes = conn.OrigBytes + conn.OrigPackets*header
+	conn.RespIPBytes = conn.RespBytes + conn.RespPackets*header
+}
+
+// poisson draws from a Poisson distribution with the given mean (Knuth's method for small
+// means, a normal approximation for large ones).
+func (g *generator) poisson(mean float64) int {
+	if mean > 30 { //nolint:mnd // Normal approximation is accurate above ~30
+		return max(0, int(math.Round(mean+math.Sqrt(mean)*g.rng.NormFloat64())))
+	}
+
+	limit, product, count := math.Exp(-mean), g.rng.Float64(), 0
+	for product > limit {
+		product *= g.rng.Float64()
+		count++
+	}
+
+	return count
+}
+
+// pick chooses a service according to the weights.
+func pick(rng *rand.Rand, mix []service) service {
+	var total float64
+	for _, svc := range mix {
+		total += svc.weight
+	}
+
+	target := rng.Float64() * total
+	for _, svc := range mix {
+		target -= svc.weight
+		if target < 0 {
+			return svc
+		}
+	}
+
+	return mix[len(mix)-1]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	certCheckInterval = time.Minute          // How often the certificate files are checked for changes
+	hstsMaxAge        = 365 * 24 * time.Hour // How long browsers keep to HTTPS after a visit
+	redirectTimeout   = 10 * time.Second     // Read and write timeout of the HTTP redirect server
+	httpsPort         = 443                  // Port left out of redirect URLs
+)
+
+// certificateFiles serves the certificate pair in two PEM files, re-reading them when they
+// change, so renewed certificates (e.g. from certbot) apply without a restart.
+type certificateFiles struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // Newer modification time of the two files when cert was read
+	checked time.Time // When the files were last checked
+}
+
+// tlsConfig returns the TLS settings of the server: TLS 1.2 or newer, forward-secret AEAD
+// cipher suites for TLS 1.2, and the certificate pair in certFile and keyFile. net/http adds
+// HTTP/2 through ALPN.
+func tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	certs := &certificateFiles{certFile: certFile, keyFile: keyFile}
+	_, err := certs.certificate(nil) // Fail at startup rather than on the first handshake
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{ // TLS 1.3 suites are not configurable and all safe
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		GetCertificate: certs.certificate,
+	}, nil
+}
+
+// certificate returns the current certificate, reloading the files at most every
+// certCheckInterval when they changed. A pair that fails to load keeps the previous one in use.
+func (c *certificateFiles) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+
+	modTime, err := newestModTime(c.certFile, c.keyFile)
+	if err == nil && c.cert != nil && !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	}
+	if err != nil {
+		if c.cert != nil {
+			log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+
+			return c.cert, nil
+		}
+
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	if c.cert != nil {
+		log.Printf("Reloaded TLS certificate from %s", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+
+	return c.cert, nil
+}
+
+// newestModTime returns the later modification time of the files.
+func newestModTime(files ...string) (time.Time, error) {
+	var newest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("checking certificate files: %w", err)
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+
+	return newest, nil
+}
+
+// redirectServer answers plain HTTP requests on addr with a permanent redirect to the same
+// URL over HTTPS on port.
+func redirectServer(addr string, port int) *http.Server {
+	return &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host // No port in the Host header
+			}
+			if port != httpsPort {
+				host = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+			target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+		}),
+		ReadTimeout:  redirectTimeout,
+		WriteTimeout: redirectTimeout,
+	}
+}
+
+// strictTransport tells browsers to use HTTPS for every later visit (HSTS).
+func strictTransport(next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(hstsMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
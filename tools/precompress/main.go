@@ -0,0 +1,127 @@
+// Command precompress writes gzip and brotli variants next to the compressible static
+// assets in a directory, so the server can embed and serve them without compressing
+// at request time. Variants are named <asset>.<hash>.<ext> after the first 12 hex digits
+// of the asset's SHA-256, so the server ignores variants of outdated content. It runs via
+// go generate before building.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	fileMode   = 0o644 // Permissions of generated files
+	hashLength = 12    // Hex digits of the content hash in variant names
+)
+
+// compressible returns the extensions of assets worth compressing.
+func compressible() []string {
+	return []string{".css", ".js", ".json", ".svg", ".txt"}
+}
+
+func main() {
+	if len(os.Args) != 2 { //nolint:mnd // Program name and directory
+		log.Fatal("usage: precompress <dir>")
+	}
+
+	entries, err := os.ReadDir(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isCompressible(name) {
+			continue
+		}
+
+		err = precompress(filepath.Join(os.Args[1], name))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// isCompressible reports whether a file is a compressible asset.
+func isCompressible(name string) bool {
+	for _, ext := range compressible() {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// precompress writes the gzip and brotli variants of path with maximum compression. Variants
+// that are not smaller than the original are skipped, so the server falls back to the plain file.
+func precompress(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var gzipped bytes.Buffer
+	gzipWriter, err := gzip.NewWriterLevel(&gzipped, gzip.BestCompression)
+	if err != nil {
+		return fmt.Errorf("compressing %s: %w", path, err)
+	}
+	err = writeAndClose(gzipWriter, data)
+	if err != nil {
+		return fmt.Errorf("compressing %s: %w", path, err)
+	}
+
+	var brotlied bytes.Buffer
+	err = writeAndClose(brotli.NewWriterLevel(&brotlied, brotli.BestCompression), data)
+	if err != nil {
+		return fmt.Errorf("compressing %s: %w", path, err)
+	}
+
+	digest := sha256.Sum256(data)
+	hash := hex.EncodeToString(digest[:])[:hashLength]
+
+	for ext, compressed := range map[string][]byte{".gz": gzipped.Bytes(), ".br": brotlied.Bytes()} {
+		removeVariants(path, ext)
+		if len(compressed) >= len(data) {
+			continue
+		}
+
+		variant := path + "." + hash + ext
+		err = os.WriteFile(variant, compressed, fileMode)
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", variant, err)
+		}
+		log.Printf("%s: %d -> %d bytes", variant, len(data), len(compressed))
+	}
+
+	return nil
+}
+
+// removeVariants deletes previously generated variants of path with the given extension.
+func removeVariants(path, ext string) {
+	previous, _ := filepath.Glob(path + ".*" + ext) // Only fails on malformed patterns
+	for _, variant := range previous {
+		_ = os.Remove(variant)
+	}
+}
+
+// writeAndClose writes data to a compressor and flushes it.
+func writeAndClose(writer io.WriteCloser, data []byte) error {
+	_, err := writer.Write(data)
+	if err != nil {
+		return err //nolint:wrapcheck // Wrapped by the caller
+	}
+
+	return writer.Close() //nolint:wrapcheck // Wrapped by the caller
+}